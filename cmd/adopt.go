@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"summit/pkg/config"
+	"summit/pkg/diff"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/cobra"
+)
+
+var adoptModified bool
+
+// adoptCmd represents the adopt command
+var adoptCmd = &cobra.Command{
+	Use:   "adopt",
+	Short: "Imports files from the live system into the configuration",
+	Long: `The adopt command copies files from the live system into the configs section
+of system.yaml so that summit manages them as they are now.
+
+Use --modified to adopt every package-owned file that was edited by hand (as
+reported by apk audit). Without adoption, apply would revert those files to the
+package default.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+		if !adoptModified {
+			return fmt.Errorf("nothing to adopt: use --modified to adopt package-modified files")
+		}
+
+		desiredSystemState, err := config.LoadConfig(cfgFile, logger)
+		if err != nil {
+			return err
+		}
+
+		system.ExtraIntrinsicIgnores = desiredSystemState.IntrinsicIgnores
+		currentSystemState, _, err := system.InferSystemState(cmdRunner, false)
+		if err != nil {
+			return err
+		}
+
+		adopt, skipped, err := modifiedConfigsToAdopt(desiredSystemState, currentSystemState)
+		if err != nil {
+			return err
+		}
+		for _, s := range skipped {
+			fmt.Fprintf(cmd.OutOrStdout(), "skipped %s: %s\n", s.Field, s.Message)
+		}
+		if len(adopt) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No package-modified files to adopt.")
+			return nil
+		}
+
+		if err := config.AppendConfigs(cfgFile, adopt); err != nil {
+			return fmt.Errorf("failed to update configs in %s: %w", cfgFile, err)
+		}
+		for _, c := range adopt {
+			fmt.Fprintf(cmd.OutOrStdout(), "adopted %s\n", c.Path)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Updated %s: %d adopted\n", cfgFile, len(adopt))
+		return nil
+	},
+}
+
+// modifiedConfigsToAdopt returns the package-modified files that the desired
+// state neither manages nor ignores, with their current content, sorted by path.
+// Files too large or binary to inline under the config's limits are skipped and
+// returned with the reason; adopting more than the total limit is refused.
+func modifiedConfigsToAdopt(desired, current *model.SystemState) ([]model.SystemConfigState, model.ValidationErrors, error) {
+	return configsToAdopt(desired, current, func(c model.SystemConfigState) bool {
+		return c.Origin == model.OriginPackageModified
+	})
+}
+
+// configsToAdopt is like modifiedConfigsToAdopt for the current files that
+// selected picks.
+func configsToAdopt(desired, current *model.SystemState, selected func(model.SystemConfigState) bool) ([]model.SystemConfigState, model.ValidationErrors, error) {
+	managed := make(map[string]bool)
+	for _, c := range desired.Configs {
+		managed[c.Path] = true
+	}
+
+	var adopt []model.SystemConfigState
+	var skipped model.ValidationErrors
+	total := desired.InlinedSize()
+	for _, c := range current.Configs {
+		if !selected(c) || managed[c.Path] || isIgnoredConfig(desired, c.Path) {
+			continue
+		}
+		content, err := c.LoadContent()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := desired.Limits.CheckContent(content); err != nil {
+			skipped = append(skipped, model.ValidationError{Field: c.Path, Message: err.Error()})
+			continue
+		}
+		total += len(content)
+		adopt = append(adopt, model.SystemConfigState{Path: c.Path, Content: content, Mode: c.Mode, Owner: c.Owner, Group: c.Group})
+	}
+	if err := desired.Limits.CheckTotal(total); err != nil {
+		return nil, nil, fmt.Errorf("refusing to adopt %d file(s): %w", len(adopt), err)
+	}
+	sort.Slice(adopt, func(i, j int) bool {
+		return adopt[i].Path < adopt[j].Path
+	})
+	sort.Slice(skipped, func(i, j int) bool {
+		return skipped[i].Field < skipped[j].Field
+	})
+	return adopt, skipped, nil
+}
+
+// isIgnoredConfig reports whether path matches one of the config's ignored-configs patterns.
+func isIgnoredConfig(state *model.SystemState, path string) bool {
+	for _, pattern := range state.IgnoredConfigs {
+		if diff.MatchesGlob(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(adoptCmd)
+	adoptCmd.Flags().BoolVar(&adoptModified, "modified", false, "Adopt all package-modified files that are not yet managed or ignored")
+}
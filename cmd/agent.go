@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"summit/pkg/actions"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+const (
+	agentService    = "summit"
+	agentInitScript = "/etc/init.d/" + agentService
+	agentConfFile   = "/etc/conf.d/" + agentService
+)
+
+var (
+	agentInterval   time.Duration
+	agentAlertAfter int
+	agentNotify     string
+	agentApply      bool
+	agentLogFile    string
+	agentRunlevel   string
+	agentDryRun     bool
+)
+
+// installAgentCmd represents the install-agent command
+var installAgentCmd = &cobra.Command{
+	Use:   "install-agent",
+	Short: "Installs summit as an OpenRC service running watch mode",
+	Long: `The install-agent command installs an OpenRC init script and conf.d file that
+run "summit watch" in the background against the current config, then enables
+and starts the service. Running it again updates the settings and restarts the
+agent only if something changed.
+
+The watch settings are written to /etc/conf.d/summit and can be edited there.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+
+		binary, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate the summit binary: %w", err)
+		}
+		configPath, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		plan, err := agentPlan(binary, configPath)
+		if err != nil {
+			return err
+		}
+
+		if agentDryRun {
+			fmt.Fprintln(cmd.OutOrStdout(), "Dry run enabled. The following operations would be performed:")
+			for _, action := range plan {
+				fmt.Fprintf(cmd.OutOrStdout(), "=> %s\n", action.Description())
+				for _, detail := range action.ExecutionDetails() {
+					fmt.Fprintf(cmd.OutOrStdout(), "   - %s\n", detail)
+				}
+			}
+			return nil
+		}
+
+		_, err = executePlan(cmd, plan, &model.SystemState{}, cmdRunner, logger)
+		return err
+	},
+}
+
+// agentPlan returns the actions that install or update the agent service.
+func agentPlan(binary, configPath string) ([]actions.Action, error) {
+	var plan []actions.Action
+	changed := false
+	for _, file := range []struct {
+		path, content, mode string
+	}{
+		{agentInitScript, agentInitScriptContent(binary), "0755"},
+		{agentConfFile, agentConfContent(configPath), "0644"},
+	} {
+		fileActions, err := ensureFile(file.path, file.content, file.mode)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, fileActions...)
+		changed = changed || len(fileActions) > 0
+	}
+
+	enabled, err := afero.Exists(system.AppFs, filepath.Join("/etc/runlevels", agentRunlevel, agentService))
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		plan = append(plan, &actions.ServiceEnableAction{ServiceName: agentService, Runlevel: agentRunlevel})
+	} else if changed {
+		// A running agent only picks up new settings on restart
+		plan = append(plan, &actions.ServiceRestartAction{ServiceName: agentService, Files: []string{agentInitScript, agentConfFile}})
+	}
+	return plan, nil
+}
+
+// ensureFile returns the actions that give path the content and mode.
+func ensureFile(path, content, mode string) ([]actions.Action, error) {
+	current, err := afero.ReadFile(system.AppFs, path)
+	if os.IsNotExist(err) {
+		return []actions.Action{&actions.FileCreateAction{Path: path, Content: content, Mode: mode}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var a []actions.Action
+	if string(current) != content {
+		a = append(a, &actions.FileUpdateAction{Path: path, NewContent: content})
+	}
+	info, err := system.AppFs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%04o", info.Mode().Perm()) != mode {
+		a = append(a, &actions.FileChmodAction{Path: path, Mode: mode})
+	}
+	return a, nil
+}
+
+func agentInitScriptContent(binary string) string {
+	return fmt.Sprintf(`#!/sbin/openrc-run
+# Installed by summit install-agent. Settings live in /etc/conf.d/summit.
+
+name="summit"
+description="summit drift watch agent"
+command=%s
+command_args="--config \"${SUMMIT_CONFIG}\" --log-level ${SUMMIT_LOG_LEVEL} watch --interval ${SUMMIT_INTERVAL} ${SUMMIT_WATCH_ARGS}"
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+output_log="${SUMMIT_LOG_FILE}"
+error_log="${SUMMIT_LOG_FILE}"
+
+depend() {
+	need net
+	after firewall
+}
+`, system.ShellQuote(binary))
+}
+
+func agentConfContent(configPath string) string {
+	watchArgs := []string{fmt.Sprintf("--alert-after %d", agentAlertAfter)}
+	if agentNotify != "" {
+		watchArgs = append(watchArgs, "--notify "+system.ShellQuote(agentNotify))
+	}
+	if agentApply {
+		watchArgs = append(watchArgs, "--apply")
+	}
+	return fmt.Sprintf(`# Settings for the summit agent, written by summit install-agent.
+SUMMIT_CONFIG=%s
+SUMMIT_INTERVAL=%s
+SUMMIT_LOG_LEVEL=%s
+SUMMIT_LOG_FILE=%s
+SUMMIT_WATCH_ARGS=%s
+`, system.ShellQuote(configPath), agentInterval, logLevel, system.ShellQuote(agentLogFile), system.ShellQuote(strings.Join(watchArgs, " ")))
+}
+
+func init() {
+	rootCmd.AddCommand(installAgentCmd)
+	installAgentCmd.Flags().DurationVar(&agentInterval, "interval", 5*time.Minute, "Time between drift checks")
+	installAgentCmd.Flags().IntVar(&agentAlertAfter, "alert-after", 1, "Number of consecutive checks with drift before the notification hook runs")
+	installAgentCmd.Flags().StringVar(&agentNotify, "notify", "", "Shell command run when drift persists; receives the plan summary on stdin")
+	installAgentCmd.Flags().BoolVar(&agentApply, "apply", false, "Let the agent converge drift (within apply windows) instead of only reporting it")
+	installAgentCmd.Flags().StringVar(&agentLogFile, "log-file", "/var/log/summit.log", "File the agent logs to")
+	installAgentCmd.Flags().StringVar(&agentRunlevel, "runlevel", "default", "Runlevel the agent service is enabled in")
+	installAgentCmd.Flags().BoolVar(&agentDryRun, "dry-run", false, "Show what would be installed without changing the system")
+}
@@ -3,11 +3,17 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"summit/pkg/actions"
 	"summit/pkg/config"
 	"summit/pkg/diff"
+	"summit/pkg/executor"
 	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/schedule"
 	"summit/pkg/system"
+	"summit/pkg/verify"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -15,6 +21,22 @@ import (
 var (
 	dryRun              bool
 	applyPruneUnmanaged bool
+	interactivePruning  bool
+	rollbackOnAssert    bool
+	applyParallelism    int
+	applyOnFailure      string
+	applyRollbackScope  string
+	applyForce          bool
+	applyTeam           string
+	applyMailTo         string
+	applyNoStart        bool
+	applyDisruptive     bool
+	applyCanary         time.Duration
+	applyIndexUpdate    string
+	applyOffline        bool
+
+	// now is the clock apply windows are checked against.
+	now = time.Now
 )
 
 // applyCmd represents the apply command
@@ -23,37 +45,128 @@ var applyCmd = &cobra.Command{
 	Short: "Applies the changes necessary to get the system to the desired state",
 	Long: `The apply command reads the desired state from the system.yaml file
 and applies the necessary changes to the Alpine Linux system to match that state.
-It respects both intrinsic safety ignores and user-defined ignore patterns from the config.`,
-	RunE: func(cmd *cobra.Command, args []string) error {
+It respects both intrinsic safety ignores and user-defined ignore patterns from the config.
+
+When the config declares apply-windows, changes are only applied during those
+windows unless --force is given. --dry-run always works.
+
+Changes that could cut off remote management (stopping or removing the SSH
+server, stopping the network, changing /etc/network/interfaces, default-deny
+firewall rules) are refused unless --allow-disruptive is given.
+
+When the plan changes something, the pre-apply hooks of the config run before
+the first change and the post-apply hooks after the assertions; the on-failure
+hooks run when a pre-apply hook, a change or an assertion fails.
+
+With --canary 10m, apply waits after applying: unless "summit confirm" runs
+within 10 minutes, every change is rolled back. Use it on remote hosts to undo
+configs that break connectivity. The post-apply hooks run once the changes are
+confirmed, and the on-failure hooks when they are rolled back.
+
+With --from-metadata, the config is fetched from the instance metadata service
+instead of --config: the URL given with --metadata-url, the URL a DHCP client hook
+wrote to /run/summit/metadata-url, or the EC2-style user-data endpoint. Fetches
+are retried with backoff until --metadata-timeout, as the network may still be
+coming up at boot. The config must be signed (<url>.sig or <url>.minisig,
+checked with --allowed-signers or --minisign-key) unless --metadata-insecure is
+given.
+
+With --offline, nothing is fetched from the network: packages are installed
+with apk add --no-network from the offline-repository of the config, a local
+mirror or a copy of /var/cache/apk, or from the apk cache. The plan is refused
+before anything is applied when it would update the package index, install
+user packages with pipx, npm, cargo, gem or uv, or install packages the
+offline source does not have. Commands and hooks are not checked and may
+still use the network.
+
+With --mail-to, a summary is mailed when changes were made (or would be, with
+--dry-run) or the run failed, e.g. for unattended runs from cron.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		// Load the configuration file
 		logger := cmd.Context().Value("logger").(log.Logger)
-		desiredSystemState, err := config.LoadConfig(cfgFile, logger)
+		var plan, changes []actions.Action
+		if applyMailTo != "" {
+			verb := "applied"
+			if dryRun {
+				verb = "pending"
+			}
+			defer func() {
+				err = mailReport(cmdRunner, logger, applyMailTo, "apply", verb, plan, changes, err)
+			}()
+		}
+		if interactivePruning && dryRun {
+			return fmt.Errorf("--interactive-prune cannot be combined with --dry-run")
+		}
+		if applyCanary > 0 && dryRun {
+			return fmt.Errorf("--canary cannot be combined with --dry-run")
+		}
+		if applyOffline && applyFromMetadata {
+			return fmt.Errorf("--offline cannot be combined with --from-metadata")
+		}
+		if applyIndexUpdate != "" && !model.IsValidIndexUpdatePolicy(applyIndexUpdate) {
+			return fmt.Errorf("invalid --package-index-update '%s', must be one of: never, if-stale, always", applyIndexUpdate)
+		}
+		actions.StartServices = !applyNoStart
+		configPath := cfgFile
+		if applyFromMetadata {
+			if configPath, err = fetchMetadataConfig(logger); err != nil {
+				return err
+			}
+			// The fetched file was verified against its own signature; it
+			// is not part of a tree with a signed manifest
+			config.Integrity = nil
+		}
+		desiredSystemState, err := config.LoadConfig(configPath, logger)
 		if err != nil {
 			return err
 		}
+		if applyIndexUpdate != "" {
+			desiredSystemState.PackageIndexUpdate = applyIndexUpdate
+		}
+		if _, ok := system.Packages.(system.Apk); ok && applyOffline {
+			system.Packages = system.Apk{Offline: true, Source: desiredSystemState.OfflineRepository}
+		}
+
+		if err := resolveHostState(desiredSystemState, cmdRunner); err != nil {
+			return err
+		}
 
-		// infer  system state
-		currentSystemState, _, err := system.InferSystemState(cmdRunner, false)
+		currentSystemState, err := inferCurrentState(desiredSystemState, cmdRunner)
 		if err != nil {
 			return err
 		}
 
-		plan, err := diff.CalculatePlan(desiredSystemState, currentSystemState, cmdRunner, applyPruneUnmanaged)
+		plan, planWarnings, err := diff.CalculatePlanWithWarnings(desiredSystemState, currentSystemState, cmdRunner, applyPruneUnmanaged || interactivePruning)
 		if err != nil {
 			return err
 		}
+		if applyTeam != "" {
+			plan = diff.FilterByTeam(plan, desiredSystemState, applyTeam)
+		}
+		if applyOffline {
+			if errs := diff.CheckOffline(plan, cmdRunner); len(errs) > 0 {
+				return errs
+			}
+		}
+		warnings := append(diff.CollectWarnings(desiredSystemState, currentSystemState, cmdRunner), planWarnings...)
+		for _, finding := range diff.Analyze(plan, diff.RemoteUnsafe) {
+			warnings = append(warnings, model.ValidationError{Field: "plan", Message: finding.String() + "; requires --allow-disruptive"})
+		}
+		if !(dryRun && jsonOutput && jsonWarnings) {
+			logWarnings(logger, warnings)
+		}
+
+		if interactivePruning {
+			plan, err = interactivePrune(cmd, plan, currentSystemState, configPath)
+			if err != nil {
+				return err
+			}
+		}
 
 		if dryRun {
+			changes = plan
 			if jsonOutput {
-				actionsForJSON := []actionForJSON{}
-				for _, action := range plan {
-					actionsForJSON = append(actionsForJSON, actionForJSON{
-						Type:        fmt.Sprintf("%T", action),
-						Description: action.Description(),
-						Details:     action.ExecutionDetails(),
-					})
-				}
-				jsonBytes, err := json.MarshalIndent(actionsForJSON, "", "  ")
+				jsonBytes, err := json.MarshalIndent(planOutput(plan, desiredSystemState, warnings), "", "  ")
 				if err != nil {
 					return fmt.Errorf("failed to marshal plan to JSON: %w", err)
 				}
@@ -61,7 +174,7 @@ It respects both intrinsic safety ignores and user-defined ignore patterns from
 			} else {
 				fmt.Fprintln(cmd.OutOrStdout(), "Dry run enabled. The following operations would be performed:")
 				for _, action := range plan {
-					fmt.Fprintf(cmd.OutOrStdout(), "=> %s\n", action.Description()) // Keep the high-level description
+					fmt.Fprintf(cmd.OutOrStdout(), "=> %s\n", describeAction(action, desiredSystemState)) // Keep the high-level description
 					details := action.ExecutionDetails()
 					for _, detail := range details {
 						fmt.Fprintf(cmd.OutOrStdout(), "   - %s\n", detail) // Print the detailed steps
@@ -71,43 +184,211 @@ It respects both intrinsic safety ignores and user-defined ignore patterns from
 			return nil
 		}
 
-		// Execute the plan
-		return executePlan(cmd, plan, cmdRunner, logger)
+		if len(plan) > 0 && !applyForce {
+			if err := checkApplyWindow(desiredSystemState.ApplyWindows); err != nil {
+				return err
+			}
+		}
+		if !applyDisruptive {
+			if err := checkDisruptive(plan); err != nil {
+				return err
+			}
+		}
+		if applyCanary > 0 {
+			if err := checkNoCanary(logger); err != nil {
+				return err
+			}
+		}
+
+		// Execute the plan between the hooks of the config. A canary is part
+		// of the apply: post-apply hooks wait for its confirmation, and a
+		// canary rolled back runs the on-failure hooks
+		return runWithHooks(desiredSystemState.Hooks, plan, cmdRunner, logger, func() error {
+			completed, err := executePlan(cmd, plan, desiredSystemState, cmdRunner, logger)
+			if err != nil {
+				return err
+			}
+			changes = completed
+			if err := runAssertions(cmd, desiredSystemState.Assertions, completed, cmdRunner, logger); err != nil {
+				return err
+			}
+			if applyCanary > 0 && len(completed) > 0 {
+				return runCanary(cmd, completed, cmdRunner, logger)
+			}
+			return nil
+		})
 	},
 }
 
-func executePlan(cmd *cobra.Command, plan []actions.Action, runner system.CommandRunner, logger log.Logger) error {
-	completedActions := []actions.Action{}
+// runCanary waits for summit confirm after a canary apply and rolls the
+// applied actions back if it does not come within the window.
+func runCanary(cmd *cobra.Command, completed []actions.Action, runner system.CommandRunner, logger log.Logger) error {
+	if err := startCanary(len(completed), applyCanary); err != nil {
+		rollbackPlan(cmd, completed, runner, logger)
+		return fmt.Errorf("failed to start the canary, changes rolled back: %w", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Canary: run 'summit confirm' within %s to keep the changes, or they will be rolled back.\n", applyCanary)
+	if waitForConfirmation(applyCanary) {
+		logger.Info("Canary apply confirmed")
+		return nil
+	}
+	logger.Warn("Canary apply not confirmed, rolling back", "window", applyCanary)
+	rollbackPlan(cmd, completed, runner, logger)
+	return fmt.Errorf("canary apply not confirmed within %s; %d change(s) rolled back", applyCanary, len(completed))
+}
 
-	for _, action := range plan {
-		logger.Info(fmt.Sprintf("=> %s", action.Description()))
-		if err := action.Apply(runner, logger); err != nil {
-			logger.Error("Action failed, rolling back changes", "action", action.Description(), "error", err)
-			rollbackPlan(cmd, completedActions, runner, logger)
-			return err
-		}
-		completedActions = append(completedActions, action)
+// checkApplyWindow refuses to change the system outside the config's apply
+// windows. Without windows applying is always allowed.
+func checkApplyWindow(windows []string) error {
+	inWindow, err := schedule.InWindow(windows, now())
+	if err != nil {
+		return err
+	}
+	if !inWindow {
+		return fmt.Errorf("outside of the configured apply windows (%s); use --force to apply anyway", strings.Join(windows, ", "))
+	}
+	return nil
+}
+
+// checkDisruptive refuses plans with changes that could sever the connection
+// the host is managed through.
+func checkDisruptive(plan []actions.Action) error {
+	findings := diff.Analyze(plan, diff.RemoteUnsafe)
+	if len(findings) == 0 {
+		return nil
+	}
+	reasons := make([]string, len(findings))
+	for i, finding := range findings {
+		reasons[i] = finding.String()
+	}
+	return fmt.Errorf("refusing to apply %d change(s) that could cut off remote access; use --allow-disruptive to apply anyway:\n  - %s", len(findings), strings.Join(reasons, "\n  - "))
+}
+
+// runAssertions checks the config's assertions after a successful apply. When
+// any fail the run is marked failed and, if requested, the applied actions are
+// rolled back.
+func runAssertions(cmd *cobra.Command, assertions []model.AssertionState, completed []actions.Action, runner system.CommandRunner, logger log.Logger) error {
+	if len(assertions) == 0 {
+		return nil
+	}
+	failed := verify.Failures(verify.Run(assertions, runner))
+	if len(failed) == 0 {
+		logger.Info(fmt.Sprintf("All %d assertion(s) passed.", len(assertions)))
+		return nil
+	}
+	for _, r := range failed {
+		logger.Error("Assertion failed", "assertion", r.Assertion.Label(), "error", r.Err)
+	}
+	if rollbackOnAssert {
+		rollbackPlan(cmd, completed, runner, logger)
+	}
+	return fmt.Errorf("%d of %d assertion(s) failed after apply", len(failed), len(assertions))
+}
+
+// executePlan applies the plan with the shared executor and prints a recap of
+// the action statuses. It returns the actions that were applied.
+func executePlan(cmd *cobra.Command, plan []actions.Action, desired *model.SystemState, runner system.CommandRunner, logger log.Logger) ([]actions.Action, error) {
+	report, err := runPlan(cmd, plan, desired, runner, logger)
+	if err != nil {
+		return nil, err
+	}
+	return report.Applied, nil
+}
+
+// runPlan is executePlan returning the status of every action, which the
+// report holds even when the run failed. The failure policy, rollback scope
+// and parallelism come from the flags of apply.
+func runPlan(cmd *cobra.Command, plan []actions.Action, desired *model.SystemState, runner system.CommandRunner, logger log.Logger) (*executor.Report, error) {
+	policy, err := parseFailurePolicy(applyOnFailure)
+	if err != nil {
+		return nil, err
+	}
+	rollbackGroups, err := parseRollbackScope(applyRollbackScope, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	// Share user/group lookups across all actions of this run
+	actions.Resolver = actions.NewIDResolver()
+
+	report, err := executor.New(runner, logger, executor.Options{
+		Parallelism:    applyParallelism,
+		FailurePolicy:  policy,
+		RollbackGroups: rollbackGroups,
+	}).Execute(plan)
+	fmt.Fprintf(cmd.OutOrStdout(), "Recap: ok=%d changed=%d failed=%d skipped=%d\n",
+		report.Count(actions.StatusOK), report.Count(actions.StatusChanged), report.Count(actions.StatusFailed), report.Count(actions.StatusSkipped))
+	if err != nil {
+		return report, err
 	}
 
 	logger.Info("Apply complete.")
-	return nil
+	commitEtcHistory(desired.EtcHistory, report.Applied, runner, logger)
+	return report, nil
 }
 
+// rollbackPlan undoes applied actions in reverse order.
 func rollbackPlan(cmd *cobra.Command, plan []actions.Action, runner system.CommandRunner, logger log.Logger) {
-	logger.Info("--- Starting Rollback ---")
-	for i := len(plan) - 1; i >= 0; i-- {
-		action := plan[i]
-		logger.Info(fmt.Sprintf("<= Rolling back: %s", action.Description()))
-		// We ignore the error here because the Rollback action itself is responsible for logging it.
-		// We want to continue trying to roll back all other completed actions.
-		_ = action.Rollback(runner, logger)
-	}
-	logger.Info("--- Rollback Complete ---")
+	executor.New(runner, logger, executor.Options{}).Rollback(plan)
+}
+
+// parseFailurePolicy maps the --on-failure flag to an executor policy.
+func parseFailurePolicy(name string) (executor.FailurePolicy, error) {
+	switch name {
+	case "rollback":
+		return executor.RollbackOnFailure, nil
+	case "stop":
+		return executor.StopOnFailure, nil
+	case "continue":
+		return executor.ContinueOnFailure, nil
+	}
+	return 0, fmt.Errorf("invalid --on-failure value: %s (must be rollback, stop or continue)", name)
+}
+
+// parseRollbackScope maps the --rollback-scope flag to the groups the executor
+// rolls back together when an action fails.
+func parseRollbackScope(scope string, desired *model.SystemState) (func(actions.Action) []string, error) {
+	switch scope {
+	case "all":
+		return nil, nil
+	case "group":
+		return func(action actions.Action) []string {
+			return diff.RollbackGroups(action, desired)
+		}, nil
+	case "action":
+		// Only other changes to the failed action's own resource, such as the
+		// create before a failed chown of the same file
+		return func(action actions.Action) []string {
+			return diff.RollbackGroups(action, desired)[:1]
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid --rollback-scope value: %s (must be action, group or all)", scope)
 }
 
 func init() {
 	rootCmd.AddCommand(applyCmd)
 	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what changes would be made without executing them")
 	applyCmd.Flags().BoolVar(&applyPruneUnmanaged, "prune-unmanaged", false, "Delete unmanaged files not present in system.yaml")
+	applyCmd.Flags().BoolVar(&interactivePruning, "interactive-prune", false, "Ask whether to delete, ignore or adopt each unmanaged file (implies --prune-unmanaged)")
+	applyCmd.Flags().BoolVar(&rollbackOnAssert, "rollback-on-assert-failure", false, "Roll back the applied changes when a post-apply assertion fails")
+	applyCmd.Flags().IntVar(&applyParallelism, "parallelism", 1, "Maximum number of independent file actions applied concurrently")
+	applyCmd.Flags().StringVar(&applyOnFailure, "on-failure", "rollback", "What to do when an action fails: rollback, stop or continue")
+	applyCmd.Flags().StringVar(&applyRollbackScope, "rollback-scope", "all", "What to roll back when an action fails with --on-failure=rollback: action (changes to the same resource), group (its notify group) or all")
+	applyCmd.Flags().StringVar(&applyTeam, "team", "", "Only apply changes to resources labeled with this team")
+	applyCmd.Flags().StringSliceVar(&config.Profiles, "profile", nil, "Merge these profiles of the config on top of it, in order")
+	applyCmd.Flags().BoolVar(&applyForce, "force", false, "Apply even outside the configured apply windows")
+	applyCmd.Flags().DurationVar(&applyCanary, "canary", 0, "Roll the changes back unless 'summit confirm' runs within this time")
+	applyCmd.Flags().BoolVar(&applyDisruptive, "allow-disruptive", false, "Apply changes that could cut off remote access, such as disabling sshd")
+	applyCmd.Flags().BoolVar(&applyFromMetadata, "from-metadata", false, "Fetch the config from the instance metadata service instead of --config")
+	applyCmd.Flags().StringVar(&metadataURL, "metadata-url", "", "URL of the config for --from-metadata (default: the DHCP-provided URL, then "+defaultMetadataURL+")")
+	applyCmd.Flags().DurationVar(&metadataTimeout, "metadata-timeout", 5*time.Minute, "How long --from-metadata retries fetching the config")
+	applyCmd.Flags().BoolVar(&metadataInsecure, "metadata-insecure", false, "Apply a config from metadata without verifying its signature")
+	applyCmd.Flags().StringVar(&applyMailTo, "mail-to", "", "Mail a summary to these comma-separated addresses when changes were made or the run failed")
+	applyCmd.Flags().BoolVar(&applyNoStart, "no-start", false, "Enable and disable services in their runlevels without starting, stopping or restarting them, e.g. when the init system is not running")
+	applyCmd.Flags().BoolVar(&applyOffline, "offline", false, "Apply without network access: install packages only from offline-repository or the apk cache, and refuse plans that need the network")
+	applyCmd.Flags().StringVar(&applyIndexUpdate, "package-index-update", "", "Override the config's package-index-update policy: never, if-stale or always")
 	applyCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the plan in JSON format (only valid with --dry-run)")
+	applyCmd.Flags().BoolVar(&jsonWarnings, "json-warnings", false, "Print the JSON plan as an object with actions and warnings arrays instead of logging the warnings")
+	applyCmd.Flags().IntVar(&actions.DiffContextLines, "diff-context", actions.DiffContextLines, "Number of unchanged lines shown around each change in file diffs (with --dry-run)")
+	applyCmd.Flags().IntVar(&actions.DiffMaxLines, "diff-max-lines", actions.DiffMaxLines, "Maximum diff lines shown per file with --dry-run (0 for no limit)")
 }
@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"summit/pkg/log"
+	"summit/pkg/system"
+
+	"github.com/spf13/cobra"
+)
+
+// bakeDir is where the summit binary and the config tree are copied inside
+// the build container. It is removed before the image is committed.
+const bakeDir = "/tmp/summit-bake"
+
+var (
+	bakeBase   string
+	bakeTag    string
+	bakeEngine string
+	bakeDryRun bool
+)
+
+// bakeCmd represents the bake command
+var bakeCmd = &cobra.Command{
+	Use:   "bake",
+	Short: "Builds a container image by applying the config to a base image",
+	Long: `The bake command builds an OCI image from the config, so VM and container
+builds share one source of truth:
+
+  summit bake --config system.yaml --base alpine:3.20 -t myimage
+
+It starts a build container from the base image, copies the summit binary and the
+config's directory (so includes and sources resolve) into it, runs
+"summit apply --no-start" inside, removes the copies and commits the container as
+the tagged image. Services are enabled in their runlevels but not started, as the
+build container does not run an init system.
+
+The container engine must be installed: docker, or podman with --engine podman.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+		if bakeBase == "" || bakeTag == "" {
+			return fmt.Errorf("--base and --tag are required")
+		}
+
+		binary, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate the summit binary: %w", err)
+		}
+		configPath, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return err
+		}
+
+		container := fmt.Sprintf("summit-bake-%d", now().UnixNano())
+		run := fmt.Sprintf("%s run -d --name %s --entrypoint sleep %s infinity", bakeEngine, container, system.ShellQuote(bakeBase))
+		cleanup := fmt.Sprintf("%s rm -f %s", bakeEngine, container)
+		if bakeDryRun {
+			steps := bakeCommands(bakeEngine, bakeTag, container, binary, configPath, "<entrypoint of base>", "<cmd of base>")
+			fmt.Fprintln(cmd.OutOrStdout(), "Dry run enabled. The following commands would be run:")
+			for _, step := range append(append([]string{run}, steps...), cleanup) {
+				fmt.Fprintf(cmd.OutOrStdout(), "   - %s\n", step)
+			}
+			return nil
+		}
+
+		logger.Info("Starting build container", "base", bakeBase, "container", container)
+		if out, err := cmdRunner.Run("", run); err != nil {
+			return fmt.Errorf("failed to start the build container: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		defer func() {
+			if out, err := cmdRunner.Run("", cleanup); err != nil {
+				logger.Warn("Failed to remove the build container", "container", container, "error", err, "output", strings.TrimSpace(string(out)))
+			}
+		}()
+
+		// The container runs sleep to stay up; the image gets the base's
+		// entrypoint and command back when committed
+		entrypoint, err := imageConfig(bakeEngine, bakeBase, "Entrypoint")
+		if err != nil {
+			return err
+		}
+		command, err := imageConfig(bakeEngine, bakeBase, "Cmd")
+		if err != nil {
+			return err
+		}
+		for _, step := range bakeCommands(bakeEngine, bakeTag, container, binary, configPath, entrypoint, command) {
+			logger.Info("Running build step", "command", step)
+			if out, err := cmdRunner.Run("", step); err != nil {
+				return fmt.Errorf("bake step failed: %s: %w: %s", step, err, strings.TrimSpace(string(out)))
+			}
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Built image %s from %s\n", bakeTag, bakeBase)
+		return nil
+	},
+}
+
+// imageConfig returns a field of an image's config as JSON, with an unset
+// field as an empty list.
+func imageConfig(engine, image, field string) (string, error) {
+	format := fmt.Sprintf("{{json .Config.%s}}", field)
+	out, err := cmdRunner.Run("", fmt.Sprintf("%s image inspect --format %s %s", engine, system.ShellQuote(format), system.ShellQuote(image)))
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w: %s", image, err, strings.TrimSpace(string(out)))
+	}
+	value := strings.TrimSpace(string(out))
+	if value == "" || value == "null" {
+		return "[]", nil
+	}
+	return value, nil
+}
+
+// bakeCommands returns the engine commands that apply the config in the
+// running build container and commit it as the tagged image.
+func bakeCommands(engine, tag, container, binary, configPath, entrypoint, command string) []string {
+	q := system.ShellQuote
+	config := bakeDir + "/config/" + filepath.Base(configPath)
+	return []string{
+		fmt.Sprintf("%s exec %s mkdir -p %s", engine, container, bakeDir),
+		fmt.Sprintf("%s cp %s %s:%s/summit", engine, q(binary), container, bakeDir),
+		fmt.Sprintf("%s cp %s %s:%s/config", engine, q(filepath.Dir(configPath)), container, bakeDir),
+		fmt.Sprintf("%s exec %s %s/summit apply --config %s --no-start", engine, container, bakeDir, q(config)),
+		fmt.Sprintf("%s exec %s rm -rf %s", engine, container, bakeDir),
+		fmt.Sprintf("%s commit --change %s --change %s %s %s", engine, q("ENTRYPOINT "+entrypoint), q("CMD "+command), container, q(tag)),
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(bakeCmd)
+	bakeCmd.Flags().StringVar(&bakeBase, "base", "", "Base image to apply the config to, e.g. alpine:3.20")
+	bakeCmd.Flags().StringVarP(&bakeTag, "tag", "t", "", "Tag of the built image")
+	bakeCmd.Flags().StringVar(&bakeEngine, "engine", "docker", "Container engine used to build the image: docker or podman")
+	bakeCmd.Flags().BoolVar(&bakeDryRun, "dry-run", false, "Show the build commands without running them")
+}
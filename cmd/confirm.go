@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"summit/pkg/log"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// canaryPath marks a canary apply waiting for confirmation. confirm removes
+// it; the waiting apply rolls back if it is still there when the window ends.
+const canaryPath = "/run/summit/canary"
+
+// canaryPollInterval is how often a canary apply looks for the confirmation.
+var canaryPollInterval = time.Second
+
+// processRunning reports whether a process with pid exists. It is a variable
+// so tests can simulate a crashed canary apply.
+var processRunning = func(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// canaryForJSON is the content of the canary file.
+type canaryForJSON struct {
+	PID      int       `json:"pid"`
+	Deadline time.Time `json:"deadline"`
+	Actions  int       `json:"actions"`
+}
+
+// confirmCmd represents the confirm command
+var confirmCmd = &cobra.Command{
+	Use:   "confirm",
+	Short: "Keeps the changes of a pending canary apply",
+	Long: `The confirm command keeps the changes made by "apply --canary". Until it runs,
+the canary apply waits and, when its window ends, rolls the changes back, so a
+config that cuts the host off is undone without anyone logging in.
+
+Run it from a new session to prove the host is still reachable.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		canary, err := readCanary()
+		if err != nil {
+			return err
+		}
+		if err := system.AppFs.Remove(canaryPath); err != nil {
+			return fmt.Errorf("failed to confirm the canary apply: %w", err)
+		}
+		if reason := canary.stale(); reason != "" {
+			return fmt.Errorf("the canary apply of %d change(s) is no longer waiting: %s; removed its stale canary file", canary.Actions, reason)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Confirmed the canary apply of %d change(s) (pid %d); the changes are kept.\n", canary.Actions, canary.PID)
+		return nil
+	},
+}
+
+func readCanary() (*canaryForJSON, error) {
+	data, err := afero.ReadFile(system.AppFs, canaryPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no canary apply is waiting for confirmation")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", canaryPath, err)
+	}
+	var canary canaryForJSON
+	if err := json.Unmarshal(data, &canary); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", canaryPath, err)
+	}
+	return &canary, nil
+}
+
+// stale returns why the canary apply that wrote the file can no longer be
+// waiting for confirmation, or "" when it may still be.
+func (c *canaryForJSON) stale() string {
+	if !processRunning(c.PID) {
+		return fmt.Sprintf("pid %d is not running", c.PID)
+	}
+	if now().After(c.Deadline) {
+		return fmt.Sprintf("its window ended at %s", c.Deadline.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// checkNoCanary refuses to start a canary apply while another one waits. The
+// file of a canary apply that crashed or outlived its window is removed.
+func checkNoCanary(logger log.Logger) error {
+	if exists, _ := afero.Exists(system.AppFs, canaryPath); !exists {
+		return nil
+	}
+	canary, err := readCanary()
+	if err != nil {
+		return err
+	}
+	reason := canary.stale()
+	if reason == "" {
+		return fmt.Errorf("a canary apply is already waiting for confirmation (pid %d); run summit confirm or wait for it to roll back", canary.PID)
+	}
+	if err := system.AppFs.Remove(canaryPath); err != nil {
+		return fmt.Errorf("failed to remove the stale canary file %s: %w", canaryPath, err)
+	}
+	logger.Warn("Removed a stale canary file", "path", canaryPath, "reason", reason)
+	return nil
+}
+
+// startCanary records a canary apply of n actions that must be confirmed
+// within window.
+func startCanary(n int, window time.Duration) error {
+	data, err := json.Marshal(canaryForJSON{PID: os.Getpid(), Deadline: now().Add(window), Actions: n})
+	if err != nil {
+		return err
+	}
+	if err := system.AppFs.MkdirAll(filepath.Dir(canaryPath), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(canaryPath), err)
+	}
+	return afero.WriteFile(system.AppFs, canaryPath, data, 0600)
+}
+
+// waitForConfirmation waits up to window for summit confirm and reports
+// whether it ran. Losing the terminal must not stop the wait, since a broken
+// connection is what the canary protects against.
+func waitForConfirmation(window time.Duration) bool {
+	if !signal.Ignored(syscall.SIGHUP) {
+		signal.Ignore(syscall.SIGHUP)
+		defer signal.Reset(syscall.SIGHUP)
+	}
+
+	deadline := time.After(window)
+	ticker := time.NewTicker(canaryPollInterval)
+	defer ticker.Stop()
+	for {
+		if exists, _ := afero.Exists(system.AppFs, canaryPath); !exists {
+			return true
+		}
+		select {
+		case <-deadline:
+			// A confirmation racing the deadline still counts
+			exists, _ := afero.Exists(system.AppFs, canaryPath)
+			system.AppFs.Remove(canaryPath)
+			return !exists
+		case <-ticker.C:
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(confirmCmd)
+}
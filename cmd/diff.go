@@ -1,17 +1,29 @@
 package cmd
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"summit/pkg/actions"
 	"summit/pkg/config"
 	"summit/pkg/diff"
+	"summit/pkg/facts"
 	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/secrets"
 	"summit/pkg/system"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 )
 
-var diffPruneUnmanaged bool
+var (
+	diffPruneUnmanaged bool
+	diffFormat         string
+	diffOutputFile     string
+	diffSummary        bool
+	diffTeam           string
+	diffMailTo         string
+)
 
 // diffCmd represents the diff command
 var diffCmd = &cobra.Command{
@@ -19,9 +31,43 @@ var diffCmd = &cobra.Command{
 	Short: "Shows the difference between the current state and the desired state",
 	Long: `The diff command compares the current state of the Alpine Linux system
 with the desired state defined in the system.yaml file and shows the differences.
-It respects both intrinsic safety ignores and user-defined ignore patterns from the config.`,
-	RunE: func(cmd *cobra.Command, args []string) error {
+It respects both intrinsic safety ignores and user-defined ignore patterns from the config.
+
+Use --format golden to produce a deterministic plan suitable for committing and
+comparing in CI, optionally writing it to a file with -o.
+
+Use --format markdown for a table of the changes, or --format github for a pull
+request comment with the summary up front and the details folded away, so the
+plan can be posted to code review without scraping the text output.
+
+Use --summary to print only the number of changes per action type and the names
+of the affected resources, e.g. for MOTD, chat notifications or drift emails.
+
+With --mail-to, the summary is also mailed when there are pending changes or
+the diff failed, e.g. for drift reports from cron.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		logger := cmd.Context().Value("logger").(log.Logger)
+		var plan []actions.Action
+		if diffMailTo != "" {
+			defer func() {
+				err = mailReport(cmdRunner, logger, diffMailTo, "diff", "pending", plan, plan, err)
+			}()
+		}
+
+		format := diffFormat
+		if jsonOutput {
+			format = "json"
+		}
+		if diffSummary {
+			if format != "text" && format != "summary" {
+				return fmt.Errorf("--summary cannot be combined with --format %s", format)
+			}
+			format = "summary"
+		}
+		renderer, err := lookupRenderer(format)
+		if err != nil {
+			return err
+		}
 
 		// Load the configuration file
 		desiredSystemState, err := config.LoadConfig(cfgFile, logger)
@@ -29,49 +75,161 @@ It respects both intrinsic safety ignores and user-defined ignore patterns from
 			return err
 		}
 
-		// infer  system state
-		currentSystemState, _, err := system.InferSystemState(cmdRunner, false)
+		if err := resolveHostState(desiredSystemState, cmdRunner); err != nil {
+			return err
+		}
+
+		currentSystemState, err := inferCurrentState(desiredSystemState, cmdRunner)
 		if err != nil {
 			return err
 		}
 
 		// Generate the plan
-		plan, err := diff.CalculatePlan(desiredSystemState, currentSystemState, cmdRunner, diffPruneUnmanaged)
+		plan, planWarnings, err := diff.CalculatePlanWithWarnings(desiredSystemState, currentSystemState, cmdRunner, diffPruneUnmanaged)
 		if err != nil {
 			return err
 		}
+		if diffTeam != "" {
+			plan = diff.FilterByTeam(plan, desiredSystemState, diffTeam)
+		}
+		warnings := append(diff.CollectWarnings(desiredSystemState, currentSystemState, cmdRunner), planWarnings...)
+		if format == "text" || format == "golden" || format == "summary" || (format == "json" && !jsonWarnings) {
+			logWarnings(logger, warnings)
+		}
 
-		if jsonOutput {
-			actionsForJSON := []actionForJSON{}
-			for _, action := range plan {
-				actionsForJSON = append(actionsForJSON, actionForJSON{Type: fmt.Sprintf("%T", action),
-					Description: action.Description(),
-					Details:     action.ExecutionDetails(),
-				})
-			}
-			jsonBytes, err := json.MarshalIndent(actionsForJSON, "", "  ")
-			if err != nil {
-				return fmt.Errorf("failed to marshal plan to JSON: %w", err)
-			}
-			fmt.Fprint(cmd.OutOrStdout(), string(jsonBytes))
-		} else {
-			// Print the plan
-			fmt.Fprintln(cmd.OutOrStdout(), "The following operations will be performed:")
-			for _, action := range plan {
-				fmt.Fprintf(cmd.OutOrStdout(), "=> %s\n", action.Description()) // Keep the high-level description
-				details := action.ExecutionDetails()
-				for _, detail := range details {
-					fmt.Fprintf(cmd.OutOrStdout(), "   - %s\n", detail) // Print the detailed steps
-				}
+		out := &bytes.Buffer{}
+		if err := renderer.Render(out, renderedPlan{Plan: plan, Desired: desiredSystemState, Warnings: warnings}); err != nil {
+			return err
+		}
+
+		if diffOutputFile != "" {
+			if err := afero.WriteFile(system.AppFs, diffOutputFile, out.Bytes(), 0644); err != nil {
+				return fmt.Errorf("failed to write plan to %s: %w", diffOutputFile, err)
 			}
+			return nil
 		}
+		fmt.Fprint(cmd.OutOrStdout(), out.String())
 
 		return nil
 	},
 }
 
+// resolveHostState exports the config's proxy, installs its secrets
+// provider, checks the requirements of the roles the config pulls in, drops
+// the resources whose when expression is false, renders templated config
+// content against the host's vars and facts and checks that the environment
+// variables the contents reference are set. Facts are only gathered when the
+// config needs them.
+func resolveHostState(state *model.SystemState, runner system.CommandRunner) error {
+	useProxy(state.Proxy, runner)
+	provider, err := secrets.New(state.Secrets, runner)
+	if err != nil {
+		return err
+	}
+	actions.Secrets = secrets.NewResolver(provider)
+
+	if state.NeedsFacts() {
+		hostFacts := facts.Gather(runner)
+		if errs := state.CheckRoles(hostFacts); len(errs) > 0 {
+			return errs
+		}
+		if errs := state.ResolveConditions(hostFacts); len(errs) > 0 {
+			return errs
+		}
+		if err := state.RenderTemplates(hostFacts); err != nil {
+			return err
+		}
+	}
+	return checkEnvRefs(state)
+}
+
+// checkEnvRefs refuses a config whose contents, managed blocks or service
+// options reference unset environment variables. The values are only
+// substituted when files are written, so plans and dumps keep showing the
+// ${env:VAR} references.
+func checkEnvRefs(state *model.SystemState) error {
+	var errs model.ValidationErrors
+	for i, c := range state.Configs {
+		for _, name := range secrets.UnsetEnvRefs(c.Content) {
+			errs = append(errs, model.ValidationError{Field: fmt.Sprintf("configs[%d].content", i), Message: fmt.Sprintf("environment variable %s is not set", name)})
+		}
+	}
+	for i, c := range state.UserConfigs {
+		for _, name := range secrets.UnsetEnvRefs(c.Content) {
+			errs = append(errs, model.ValidationError{Field: fmt.Sprintf("user-configs[%d].content", i), Message: fmt.Sprintf("environment variable %s is not set", name)})
+		}
+	}
+	for i, b := range state.ManagedBlocks {
+		for _, name := range secrets.UnsetEnvRefs(b.Content) {
+			errs = append(errs, model.ValidationError{Field: fmt.Sprintf("managed-blocks[%d].content", i), Message: fmt.Sprintf("environment variable %s is not set", name)})
+		}
+	}
+	for i, o := range state.ServiceOptions {
+		for _, key := range o.Keys() {
+			for _, name := range secrets.UnsetEnvRefs(o.Options[key]) {
+				errs = append(errs, model.ValidationError{Field: fmt.Sprintf("service-options[%d].options.%s", i, key), Message: fmt.Sprintf("environment variable %s is not set", name)})
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return state.LocateErrors(errs)
+	}
+	return nil
+}
+
+// inferCurrentState infers the system state a plan for desired is computed
+// against. Only the files desired manages are read up front; the plan never
+// compares the content of the others. The same goes for the files of users'
+// homes, of which only the declared user configs are read.
+func inferCurrentState(desired *model.SystemState, runner system.CommandRunner) (*model.SystemState, error) {
+	system.ExtraIntrinsicIgnores = desired.IntrinsicIgnores
+	paths := make([]string, 0, len(desired.Configs))
+	for _, c := range desired.Configs {
+		paths = append(paths, c.Path)
+	}
+	for _, c := range desired.GeneratedConfigs() {
+		paths = append(paths, c.Path)
+	}
+	// Only the crontabs of declared users are reconciled
+	declared := make([]string, 0, len(desired.Users))
+	for _, u := range desired.Users {
+		declared = append(declared, u.Name)
+	}
+	current, _, err := system.InferSystemStateFor(runner, paths, declared)
+	if err != nil {
+		return nil, err
+	}
+
+	// Homes are not audited; only the files the config declares are read
+	var users []string
+	homePaths := make(map[string][]string)
+	for _, c := range desired.UserConfigs {
+		if _, ok := homePaths[c.User]; !ok {
+			users = append(users, c.User)
+		}
+		homePaths[c.User] = append(homePaths[c.User], c.Path)
+	}
+	for _, user := range users {
+		configs, err := system.ListUserConfigs(user, homePaths[user])
+		if err != nil {
+			return nil, err
+		}
+		current.UserConfigs = append(current.UserConfigs, configs...)
+	}
+	return current, nil
+}
+
 func init() {
 	rootCmd.AddCommand(diffCmd)
 	diffCmd.Flags().BoolVar(&diffPruneUnmanaged, "prune-unmanaged", false, "Include deletion of unmanaged files in diff output")
 	diffCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the plan in JSON format")
+	diffCmd.Flags().BoolVar(&jsonWarnings, "json-warnings", false, "Print the JSON plan as an object with actions and warnings arrays instead of logging the warnings")
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "Output format (text, json, golden, summary, markdown, github)")
+	diffCmd.Flags().BoolVar(&diffSummary, "summary", false, "Only print change counts per action type and affected resource names")
+	diffCmd.Flags().StringVar(&diffTeam, "team", "", "Only show changes to resources labeled with this team")
+	diffCmd.Flags().StringSliceVar(&config.Profiles, "profile", nil, "Merge these profiles of the config on top of it, in order")
+	diffCmd.Flags().StringVar(&diffMailTo, "mail-to", "", "Mail the summary to these comma-separated addresses when there are changes or the diff failed")
+	diffCmd.Flags().StringVarP(&diffOutputFile, "output", "o", "", "Write the plan to a file instead of stdout")
+	diffCmd.Flags().IntVar(&actions.DiffContextLines, "diff-context", actions.DiffContextLines, "Number of unchanged lines shown around each change in file diffs")
+	diffCmd.Flags().IntVar(&actions.DiffMaxLines, "diff-max-lines", actions.DiffMaxLines, "Maximum diff lines shown per file (0 for no limit)")
 }
@@ -3,12 +3,17 @@ package cmd
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
+	"strings"
 	"summit/pkg/config"
 	"summit/pkg/diff"
+	"summit/pkg/facts"
 	"summit/pkg/log"
 	"summit/pkg/model"
+	"summit/pkg/secrets"
 	"summit/pkg/system"
 
+	"github.com/spf13/afero"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
 )
@@ -19,6 +24,15 @@ var (
 	dumpPreviewIgnores string
 	dumpRaw            bool
 	dumpAllServices    bool
+	dumpAnnotate       bool
+	dumpFormat         string
+	dumpUserConfigs    []string
+	dumpAsConfig       bool
+	dumpSplit          string
+	dumpOnly           []string
+	dumpExclude        []string
+	dumpNoContent      bool
+	dumpWithVersions   bool
 )
 
 func previewIgnoresFunc(cmd *cobra.Command, configFile string, logger log.Logger) error {
@@ -56,6 +70,223 @@ func previewIgnoresFunc(cmd *cobra.Command, configFile string, logger log.Logger
 	return nil
 }
 
+// dumpSections keeps the sections of state named by only, all of them when
+// only is empty, minus the ones named by exclude, and returns the sections
+// kept. Sections are named by their YAML key, e.g. packages or user-configs.
+// Without filters the state is left alone and nil is returned.
+func dumpSections(state *model.SystemState, only, exclude []string) (map[string]bool, error) {
+	if len(only) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	v := reflect.ValueOf(state).Elem()
+	fields := make(map[string]int)
+	var names []string
+	for i := 0; i < v.NumField(); i++ {
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		if name != "" && name != "-" {
+			fields[name] = i
+			names = append(names, name)
+		}
+	}
+	for _, name := range append(append([]string{}, only...), exclude...) {
+		if _, ok := fields[name]; !ok {
+			return nil, fmt.Errorf("unknown section '%s' (sections: %s)", name, strings.Join(names, ", "))
+		}
+	}
+	keep := make(map[string]bool)
+	for _, name := range names {
+		keep[name] = len(only) == 0
+	}
+	for _, name := range only {
+		keep[name] = true
+	}
+	for _, name := range exclude {
+		keep[name] = false
+	}
+	for name, i := range fields {
+		if !keep[name] {
+			v.Field(i).Set(reflect.Zero(v.Field(i).Type()))
+		}
+	}
+	return keep, nil
+}
+
+// configSummary is a config in dump --no-content output: its metadata and the
+// hash of its content in place of the content.
+type configSummary struct {
+	Path      string           `yaml:"path" json:"path"`
+	Mode      string           `yaml:"mode,omitempty" json:"mode,omitempty"`
+	Owner     string           `yaml:"owner,omitempty" json:"owner,omitempty"`
+	Group     string           `yaml:"group,omitempty" json:"group,omitempty"`
+	Origin    model.FileOrigin `yaml:"origin" json:"origin"`
+	Package   string           `yaml:"package,omitempty" json:"package,omitempty"`
+	SHA256    string           `yaml:"sha256,omitempty" json:"sha256,omitempty"` // Empty for sensitive files
+	Sensitive bool             `yaml:"sensitive,omitempty" json:"sensitive,omitempty"`
+}
+
+// summaryDumpForJSON is the JSON output of dump --no-content: the state with
+// the configs summarized.
+type summaryDumpForJSON struct {
+	*model.SystemState
+	Configs []configSummary
+}
+
+// summarizeConfigs returns the summaries of configs, in order.
+func summarizeConfigs(configs []model.SystemConfigState) []configSummary {
+	summaries := []configSummary{}
+	for _, c := range configs {
+		summary := configSummary{Path: c.Path, Mode: c.Mode, Owner: c.Owner, Group: c.Group, Origin: c.Origin, Package: c.OriginPackage, SHA256: c.ContentHash, Sensitive: c.Sensitive}
+		if c.Sensitive {
+			summary.SHA256 = ""
+		}
+		summaries = append(summaries, summary)
+	}
+	return summaries
+}
+
+// marshalDump renders the state as YAML, limited to sections unless it is
+// nil and with the configs replaced by summaries when they are given. With
+// annotate set, every config is preceded by a comment describing where it
+// came from, so the output can be used to decide what to manage, ignore or
+// revert.
+func marshalDump(state *model.SystemState, annotate bool, sections map[string]bool, summaries []configSummary) ([]byte, error) {
+	if !annotate && sections == nil && summaries == nil {
+		return yaml.Marshal(state)
+	}
+
+	var doc yaml.Node
+	if err := doc.Encode(state); err != nil {
+		return nil, err
+	}
+	if sections != nil {
+		kept := doc.Content[:0]
+		for i := 0; i+1 < len(doc.Content); i += 2 {
+			if sections[doc.Content[i].Value] {
+				kept = append(kept, doc.Content[i], doc.Content[i+1])
+			}
+		}
+		doc.Content = kept
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if doc.Content[i].Value != "configs" {
+			continue
+		}
+		if summaries != nil {
+			if err := doc.Content[i+1].Encode(summaries); err != nil {
+				return nil, err
+			}
+		}
+		for j, item := range doc.Content[i+1].Content {
+			if annotate {
+				item.HeadComment = configAnnotation(state.Configs[j])
+			}
+		}
+	}
+	return yaml.Marshal(&doc)
+}
+
+// configAnnotation describes a config's origin in a single comment line.
+func configAnnotation(config model.SystemConfigState) string {
+	var origin string
+	switch config.FileStatus {
+	case "A":
+		origin = "added"
+	case "U":
+		origin = "modified"
+	case "X":
+		origin = "deleted"
+	default:
+		origin = "unknown"
+	}
+	annotation := fmt.Sprintf("%s (apk audit: %s)", origin, config.FileStatus)
+	if config.OriginPackage != "" {
+		annotation += ", owned by package " + config.OriginPackage
+	} else {
+		annotation += ", not owned by any package"
+	}
+	return annotation
+}
+
+// redactedContent is the content dumped for files the config marks sensitive.
+const redactedContent = "(redacted)"
+
+// redactSecrets replaces the content of the files the config fills from
+// secrets with the config's content, so the dump shows the secret://name
+// references rather than the values, and the content of the files it marks
+// sensitive with a placeholder. Without a loadable config the state is left
+// as is.
+func redactSecrets(state *model.SystemState, logger log.Logger) {
+	if exists, _ := afero.Exists(system.AppFs, cfgFile); !exists {
+		return
+	}
+	desired, err := config.LoadConfig(cfgFile, logger)
+	if err == nil {
+		err = resolveHostState(desired, cmdRunner)
+	}
+	if err != nil {
+		logger.Debug("Not redacting secrets, the config could not be loaded", "config", cfgFile, "error", err)
+		return
+	}
+	refs := make(map[string]string)
+	sensitive := make(map[string]bool)
+	for _, c := range desired.Configs {
+		if c.Sensitive {
+			sensitive[c.Path] = true
+		} else if secrets.HasRefs(c.Content) {
+			refs[c.Path] = c.Content
+		}
+	}
+	for i, c := range state.Configs {
+		if sensitive[c.Path] {
+			state.Configs[i].Content, state.Configs[i].Sensitive = redactedContent, true
+		} else if content, ok := refs[c.Path]; ok {
+			state.Configs[i].Content = content
+		}
+	}
+	for _, c := range desired.UserConfigs {
+		if c.Sensitive {
+			sensitive[c.Key()] = true
+		} else if secrets.HasRefs(c.Content) {
+			refs[c.Key()] = c.Content
+		}
+	}
+	for i, c := range state.UserConfigs {
+		if sensitive[c.Key()] {
+			state.UserConfigs[i].Content, state.UserConfigs[i].Sensitive = redactedContent, true
+		} else if content, ok := refs[c.Key()]; ok {
+			state.UserConfigs[i].Content = content
+		}
+	}
+}
+
+// listUserConfigs infers the files of the homes named by --user-configs.
+// Homes are only read for the users asked for: USER infers the common
+// dotfiles, USER:PATTERN the files matching a path or glob relative to the
+// home. Files too large or binary to inline are skipped.
+func listUserConfigs(specs []string, logger log.Logger) ([]model.UserConfigState, error) {
+	var result []model.UserConfigState
+	for _, spec := range specs {
+		user, pattern, ok := strings.Cut(spec, ":")
+		patterns := system.DefaultUserConfigs
+		if ok {
+			patterns = []string{pattern}
+		}
+		configs, err := system.ListUserConfigs(user, patterns)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range configs {
+			if err := (*model.LimitsState)(nil).CheckContent(c.Content); err != nil {
+				logger.Warn("Skipping user config", "user", c.User, "path", c.Path, "error", err)
+				continue
+			}
+			c.OmitDefaultOwnership()
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
 var dumpCmd = &cobra.Command{
 	Use:   "dump",
 	Short: "Dumps the current system state to the console",
@@ -65,7 +296,26 @@ It shows the actual state of the system, excluding intrinsically ignored files f
 By default, only enabled services are shown. Use --all-services to see all available services.
 Use --show-ignored to see what files are ignored and why.
 Use --preview-ignores <config> to see what would be ignored by a config file.
-Use --raw to show all files including security-sensitive ones (use with caution).`,
+Use --raw to show all files including security-sensitive ones (use with caution).
+Use --annotate to comment each config with its apk audit status and owning package.
+Use --user-configs <user> to include the common dotfiles of a user's home, or
+--user-configs <user>:<glob> for the files matching a glob relative to the home.
+Homes are never read otherwise.
+Files the config fills from secret://name references are shown with the references
+instead of the secret values.
+Use --format ansible-facts to print the host facts and the state in the layout of
+Ansible's setup, package_facts and service_facts modules.
+The pipx, npm, cargo, gem and uv packages of every user are listed as user-packages, best effort.
+Use --as-config to print a config that loads back as is: deleted files, runtime
+kernel parameters, whether services are running and files that would fail
+validation are left out and empty sections are dropped. --split <dir> writes it as one include per section plus a
+system.yaml including them.
+Use --only packages,services or --exclude configs to output only some sections,
+named by their key in system.yaml.
+Use --no-content to list each config's path, mode, owner, origin and content hash
+instead of its content, for dumps that stay reviewable on hosts with large files.
+Use --with-versions to record the installed version of each package, e.g. to
+write a version-pinned config from a golden host with --as-config.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		logger := cmd.Context().Value("logger").(log.Logger)
 
@@ -74,12 +324,43 @@ Use --raw to show all files including security-sensitive ones (use with caution)
 			return previewIgnoresFunc(cmd, dumpPreviewIgnores, logger)
 		}
 
+		format := dumpFormat
+		if jsonOutput {
+			format = "json"
+		}
+		if format != "yaml" && format != "json" && format != "ansible-facts" {
+			return fmt.Errorf("invalid --format value: %s (must be yaml, json or ansible-facts)", format)
+		}
+		asConfig := dumpAsConfig || dumpSplit != ""
+		if asConfig && (format != "yaml" || dumpRaw || dumpAnnotate || dumpNoContent) {
+			return fmt.Errorf("--as-config cannot be combined with --format %s, --raw, --annotate or --no-content", format)
+		}
+		if dumpNoContent && len(dumpUserConfigs) > 0 {
+			return fmt.Errorf("--no-content cannot be combined with --user-configs")
+		}
+
 		// infer system state
 		currentSystemState, ignored, err := system.InferSystemState(cmdRunner, dumpRaw)
 		if err != nil {
 			return err
 		}
 
+		// A service in several runlevels is dumped once per runlevel, as
+		// configs declare it
+		currentSystemState.Services = model.SplitRunlevels(currentSystemState.Services)
+
+		if dumpWithVersions {
+			versions, err := system.InstalledVersions(cmdRunner)
+			if err != nil {
+				return err
+			}
+			for i, p := range currentSystemState.Packages {
+				if p.Version == "" {
+					currentSystemState.Packages[i].Version = versions[p.Name]
+				}
+			}
+		}
+
 		// Filter out disabled services (not in any runlevel) unless --all-services is specified
 		if !dumpAllServices {
 			filteredServices := []model.ServiceState{}
@@ -92,15 +373,73 @@ Use --raw to show all files including security-sensitive ones (use with caution)
 			currentSystemState.Services = filteredServices
 		}
 
-		if jsonOutput {
-			jsonData, err := json.MarshalIndent(currentSystemState, "", "  ")
+		// /etc/timezone is written from the timezone setting, which the dump
+		// already carries
+		if currentSystemState.Timezone != "" {
+			configs := currentSystemState.Configs[:0]
+			for _, c := range currentSystemState.Configs {
+				if c.Path != model.TimezonePath {
+					configs = append(configs, c)
+				}
+			}
+			currentSystemState.Configs = configs
+		}
+
+		// Inferred configs only carry a content hash; load contents for output
+		// unless the hash is all that is shown
+		if !dumpNoContent {
+			for i := range currentSystemState.Configs {
+				if err := currentSystemState.Configs[i].MaterializeContent(); err != nil {
+					return err
+				}
+			}
+		}
+		if currentSystemState.UserConfigs, err = listUserConfigs(dumpUserConfigs, logger); err != nil {
+			return err
+		}
+		redactSecrets(currentSystemState, logger)
+
+		if asConfig {
+			cfg, err := dumpedConfig(currentSystemState, logger)
+			if err != nil {
+				return err
+			}
+			if _, err := dumpSections(cfg, dumpOnly, dumpExclude); err != nil {
+				return err
+			}
+			cfg.Version = config.CurrentVersion()
+			return writeDumpedConfig(cmd, cfg, dumpSplit)
+		}
+		sections, err := dumpSections(currentSystemState, dumpOnly, dumpExclude)
+		if err != nil {
+			return err
+		}
+
+		var summaries []configSummary
+		if dumpNoContent && (sections == nil || sections["configs"]) {
+			summaries = summarizeConfigs(currentSystemState.Configs)
+		}
+
+		switch format {
+		case "json":
+			var doc any = currentSystemState
+			if summaries != nil {
+				doc = summaryDumpForJSON{SystemState: currentSystemState, Configs: summaries}
+			}
+			jsonData, err := json.MarshalIndent(doc, "", "  ")
 			if err != nil {
 				return fmt.Errorf("error marshaling to JSON: %w", err)
 			}
 			fmt.Fprint(cmd.OutOrStdout(), string(jsonData))
-		} else {
+		case "ansible-facts":
+			jsonData, err := json.MarshalIndent(ansibleFacts(facts.Gather(cmdRunner), currentSystemState), "", "  ")
+			if err != nil {
+				return fmt.Errorf("error marshaling to JSON: %w", err)
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(jsonData))
+		default:
 			// Marshal the system state to YAML
-			yamlData, err := yaml.Marshal(currentSystemState)
+			yamlData, err := marshalDump(currentSystemState, dumpAnnotate, sections, summaries)
 			if err != nil {
 				return fmt.Errorf("error marshaling to YAML: %w", err)
 			}
@@ -115,6 +454,12 @@ Use --raw to show all files including security-sensitive ones (use with caution)
 				fmt.Fprintf(cmd.OutOrStdout(), "#   %s (%s)\n", ig.Path, ig.Reason)
 			}
 		}
+		if dumpShowIgnored {
+			fmt.Fprintln(cmd.OutOrStdout(), "\n# Intrinsic ignore rules:")
+			for _, rule := range model.IntrinsicIgnores(system.ExtraIntrinsicIgnores) {
+				fmt.Fprintf(cmd.OutOrStdout(), "#   %s %s (%s)\n", rule.Kind, rule.Pattern, rule.Reason)
+			}
+		}
 
 		// Warning for raw dump
 		if dumpRaw {
@@ -127,9 +472,18 @@ Use --raw to show all files including security-sensitive ones (use with caution)
 
 func init() {
 	rootCmd.AddCommand(dumpCmd)
-	dumpCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the state in JSON format")
+	dumpCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the state in JSON format (same as --format json)")
+	dumpCmd.Flags().StringVar(&dumpFormat, "format", "yaml", "Output format: yaml, json or ansible-facts")
 	dumpCmd.Flags().BoolVar(&dumpShowIgnored, "show-ignored", false, "Show files that are ignored with reasons")
 	dumpCmd.Flags().StringVar(&dumpPreviewIgnores, "preview-ignores", "", "Preview which files would be ignored by the specified config file")
 	dumpCmd.Flags().BoolVar(&dumpRaw, "raw", false, "Show all files including security-sensitive ones (use with caution)")
 	dumpCmd.Flags().BoolVar(&dumpAllServices, "all-services", false, "Show all services including those not enabled in any runlevel")
+	dumpCmd.Flags().BoolVar(&dumpAnnotate, "annotate", false, "Comment each config with its audit status and owning package")
+	dumpCmd.Flags().BoolVar(&dumpAsConfig, "as-config", false, "Output a config that loads back as is")
+	dumpCmd.Flags().StringVar(&dumpSplit, "split", "", "Write the config as one include per section into a directory (implies --as-config)")
+	dumpCmd.Flags().BoolVar(&dumpNoContent, "no-content", false, "List configs with their metadata and content hash instead of their content")
+	dumpCmd.Flags().BoolVar(&dumpWithVersions, "with-versions", false, "Record the installed version of each package, as listed by the package manager (apk info -v)")
+	dumpCmd.Flags().StringSliceVar(&dumpOnly, "only", nil, "Only output these sections, e.g. packages,services")
+	dumpCmd.Flags().StringSliceVar(&dumpExclude, "exclude", nil, "Leave these sections out, e.g. configs")
+	dumpCmd.Flags().StringArrayVar(&dumpUserConfigs, "user-configs", nil, "Include files of a user's home: USER for common dotfiles or USER:GLOB (repeatable)")
 }
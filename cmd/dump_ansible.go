@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"strings"
+	"summit/pkg/model"
+)
+
+// ansiblePackageForJSON is a package entry in the package_facts layout.
+type ansiblePackageForJSON struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	Source  string `json:"source"`
+}
+
+// ansibleServiceForJSON is a service entry in the service_facts layout.
+type ansibleServiceForJSON struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	Status   string `json:"status"`
+	Source   string `json:"source"`
+	Runlevel string `json:"runlevel,omitempty"`
+}
+
+// ansibleUserForJSON is a user entry of summit_users.
+type ansibleUserForJSON struct {
+	Name   string   `json:"name"`
+	Groups []string `json:"groups"`
+}
+
+// ansibleFactsDocument is the output of dump --format ansible-facts.
+type ansibleFactsDocument struct {
+	AnsibleFacts map[string]any `json:"ansible_facts"`
+	Changed      bool           `json:"changed"`
+}
+
+// ansibleFacts renders the host facts and the inferred state in the layout of
+// Ansible's setup module, merged with what its package_facts and
+// service_facts modules return, so playbooks and inventories built on those
+// facts can read summit's view of the host. Facts Ansible has no key for are
+// prefixed with summit_.
+func ansibleFacts(facts model.Facts, state *model.SystemState) ansibleFactsDocument {
+	f := map[string]any{
+		"ansible_hostname":             facts.Hostname,
+		"ansible_nodename":             facts.Hostname,
+		"ansible_architecture":         facts.Arch,
+		"ansible_system":               "Linux",
+		"ansible_os_family":            "Alpine",
+		"ansible_distribution":         "Alpine",
+		"ansible_distribution_version": facts.AlpineVersion,
+		"ansible_pkg_mgr":              "apk",
+		"ansible_service_mgr":          "openrc",
+		"ansible_all_ipv4_addresses":   nonNil(facts.IPv4Addresses),
+	}
+	if major, _, _ := strings.Cut(facts.AlpineVersion, "."); major != "" {
+		f["ansible_distribution_major_version"] = major
+	}
+	if facts.MemoryMB > 0 {
+		f["ansible_memtotal_mb"] = facts.MemoryMB
+	}
+	switch facts.Virtualization {
+	case "":
+	case "none":
+		f["ansible_virtualization_type"], f["ansible_virtualization_role"] = "NA", "NA"
+	default:
+		f["ansible_virtualization_type"], f["ansible_virtualization_role"] = facts.Virtualization, "guest"
+	}
+	if facts.IPv4 != "" {
+		f["ansible_default_ipv4"] = map[string]string{"address": facts.IPv4}
+	}
+
+	packages := map[string][]ansiblePackageForJSON{}
+	for _, p := range state.Packages {
+		packages[p.Name] = append(packages[p.Name], ansiblePackageForJSON{Name: p.Name, Version: p.Version, Source: "apk"})
+	}
+	f["packages"] = packages
+
+	services := map[string]ansibleServiceForJSON{}
+	for _, s := range state.Services {
+		status := "disabled"
+		if s.Enabled {
+			status = "enabled"
+		}
+		services[s.Name] = ansibleServiceForJSON{Name: s.Name, State: "unknown", Status: status, Source: "openrc", Runlevel: s.Runlevel}
+	}
+	f["services"] = services
+
+	users := []ansibleUserForJSON{}
+	for _, u := range state.Users {
+		users = append(users, ansibleUserForJSON{Name: u.Name, Groups: nonNil(u.Groups)})
+	}
+	f["summit_users"] = users
+
+	configs := []string{}
+	for _, c := range state.Configs {
+		configs = append(configs, c.Path)
+	}
+	f["summit_configs"] = configs
+
+	return ansibleFactsDocument{AnsibleFacts: f}
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
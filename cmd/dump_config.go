@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"summit/pkg/config"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// configFieldPattern matches the fields of validation errors on a config.
+var configFieldPattern = regexp.MustCompile(`^configs\[(\d+)\]`)
+
+// dumpedConfig returns the inferred state as a config LoadConfig reads back
+// as is: deleted files, runtime kernel parameters and files that would not
+// pass validation (too large, binary, risky modes) are left out with a
+// warning. So are sensitive files, whose content is redacted; they are
+// listed in ignored-configs instead, and sensitive user configs are dropped.
+func dumpedConfig(state *model.SystemState, logger log.Logger) (*model.SystemState, error) {
+	cfg := &model.SystemState{
+		Version:         config.CurrentVersion(),
+		Packages:        state.Packages,
+		VirtualPackages: state.VirtualPackages,
+		Services:        withoutRunning(state.Services),
+		Users:           state.Users,
+		Groups:          state.Groups,
+		UserPackages:    state.UserPackages,
+		Timezone:        state.Timezone,
+	}
+	configs, skipped, err := configsToAdopt(&model.SystemState{}, state, func(c model.SystemConfigState) bool { return !c.Deleted })
+	if err != nil {
+		return nil, err
+	}
+	// The content of sensitive files is redacted: rather than a config that
+	// would write the placeholder, they are left out and ignored
+	sensitive := make(map[string]bool)
+	for _, c := range state.Configs {
+		sensitive[c.Path] = c.Sensitive
+	}
+	for _, c := range configs {
+		if sensitive[c.Path] {
+			logger.Warn("Leaving out sensitive config, added to ignored-configs", "path", c.Path)
+			cfg.IgnoredConfigs = append(cfg.IgnoredConfigs, c.Path)
+		} else {
+			cfg.Configs = append(cfg.Configs, c)
+		}
+	}
+
+	for _, c := range state.UserConfigs {
+		if c.Sensitive {
+			logger.Warn("Leaving out sensitive user config", "user", c.User, "path", c.Path)
+		} else {
+			cfg.UserConfigs = append(cfg.UserConfigs, c)
+		}
+	}
+
+	// Drop the files the config would be refused for rather than the dump
+	drop := make(map[int]bool)
+	for _, e := range cfg.Validate() {
+		m := configFieldPattern.FindStringSubmatch(e.Field)
+		if m == nil {
+			return nil, model.ValidationErrors{e}
+		}
+		i, _ := strconv.Atoi(m[1])
+		if !drop[i] {
+			skipped = append(skipped, model.ValidationError{Field: cfg.Configs[i].Path, Message: e.Message})
+		}
+		drop[i] = true
+	}
+	configs = cfg.Configs[:0]
+	for i, c := range cfg.Configs {
+		if !drop[i] {
+			configs = append(configs, c)
+		}
+	}
+	cfg.Configs = configs
+	for _, s := range skipped {
+		logger.Warn("Skipping config", "path", s.Field, "reason", s.Message)
+	}
+	return cfg, nil
+}
+
+// withoutRunning returns services without whether they are running, which is
+// only how the host happens to be at the time: a config declaring it would
+// stop a service that was down while the config was written.
+func withoutRunning(services []model.ServiceState) []model.ServiceState {
+	result := make([]model.ServiceState, 0, len(services))
+	for _, svc := range services {
+		svc.Running = nil
+		result = append(result, svc)
+	}
+	return result
+}
+
+// writeDumpedConfig prints cfg as a config file without its empty sections
+// or, with dir set, writes one include per section into dir along with a
+// system.yaml including them.
+func writeDumpedConfig(cmd *cobra.Command, cfg *model.SystemState, dir string) error {
+	var doc yaml.Node
+	if err := doc.Encode(cfg); err != nil {
+		return err
+	}
+	sections := doc.Content[:0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		if value := doc.Content[i+1]; value.Tag != "!!null" && (value.Kind == yaml.ScalarNode || len(value.Content) > 0) {
+			sections = append(sections, doc.Content[i], value)
+		}
+	}
+	doc.Content = sections
+
+	out := cmd.OutOrStdout()
+	if dir == "" {
+		data, err := formatNode(&doc)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(out, string(data))
+		return nil
+	}
+
+	if err := system.AppFs.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	main := &yaml.Node{Kind: yaml.MappingNode}
+	var includes []*yaml.Node
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		if value.Kind == yaml.ScalarNode {
+			main.Content = append(main.Content, key, value)
+			continue
+		}
+		file := key.Value + ".yaml"
+		if err := writeNode(filepath.Join(dir, file), &yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{key, value}}); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Wrote %s\n", filepath.Join(dir, file))
+		includes = append(includes, &yaml.Node{Kind: yaml.ScalarNode, Value: file})
+	}
+	main.Content = append(main.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: "includes"}, &yaml.Node{Kind: yaml.SequenceNode, Content: includes})
+	if err := writeNode(filepath.Join(dir, "system.yaml"), main); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Wrote %s\n", filepath.Join(dir, "system.yaml"))
+	return nil
+}
+
+// formatNode renders node as a config file in canonical form.
+func formatNode(node *yaml.Node) ([]byte, error) {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	return config.Format(data)
+}
+
+func writeNode(path string, node *yaml.Node) error {
+	data, err := formatNode(node)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(system.AppFs, path, data, 0644)
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"summit/pkg/actions"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// etcGenerationTag prefixes the tags marking the /etc commit of each apply.
+const etcGenerationTag = "summit-gen-"
+
+// commitEtcHistory records /etc after an apply that changed the system as a
+// commit of the /etc git repository, tagged summit-gen-<generation>. With
+// etc-history set to git the repository is created on first use; with
+// etckeeper the commit goes through "etckeeper commit" so its hooks (file
+// metadata, ignores) keep working. Failures are logged: the system has
+// already been changed and the apply stands.
+func commitEtcHistory(policy string, applied []actions.Action, runner system.CommandRunner, logger log.Logger) {
+	if policy == "" || len(applied) == 0 {
+		return
+	}
+	if err := commitEtc(policy, applied, runner, logger); err != nil {
+		logger.Warn("Failed to commit /etc history", "error", err)
+	}
+}
+
+func commitEtc(policy string, applied []actions.Action, runner system.CommandRunner, logger log.Logger) error {
+	if _, err := runner.Run("", "git -C /etc rev-parse --git-dir"); err != nil {
+		if policy == model.EtcHistoryEtckeeper {
+			return fmt.Errorf("/etc is not an etckeeper repository; run etckeeper init first")
+		}
+		// The history holds every file of /etc, so only root may read it
+		if out, err := runner.Run("", "git init -q /etc && chmod 700 /etc/.git"); err != nil {
+			return fmt.Errorf("git init /etc: %w: %s", err, strings.TrimSpace(string(out)))
+		}
+		logger.Info("Created git repository for the /etc history")
+	}
+
+	status, err := runner.Run("", "git -C /etc status --porcelain")
+	if err != nil {
+		return fmt.Errorf("git status: %w: %s", err, strings.TrimSpace(string(status)))
+	}
+	if strings.TrimSpace(string(status)) == "" {
+		logger.Debug("No changes in /etc to commit")
+		return nil
+	}
+
+	tags, err := runner.Run("", "git -C /etc tag --list "+system.ShellQuote(etcGenerationTag+"*"))
+	if err != nil {
+		return fmt.Errorf("git tag: %w: %s", err, strings.TrimSpace(string(tags)))
+	}
+	generation := nextGeneration(string(tags))
+	message := etcCommitMessage(generation, applied)
+
+	commit := "git -C /etc add -A && git -C /etc -c user.name=summit -c user.email=summit@localhost commit -q -m " + system.ShellQuote(message)
+	if policy == model.EtcHistoryEtckeeper {
+		commit = "etckeeper commit " + system.ShellQuote(message)
+	}
+	if out, err := runner.Run("", commit); err != nil {
+		return fmt.Errorf("commit: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	tag := fmt.Sprintf("%s%d", etcGenerationTag, generation)
+	if out, err := runner.Run("", "git -C /etc tag "+tag); err != nil {
+		return fmt.Errorf("git tag %s: %w: %s", tag, err, strings.TrimSpace(string(out)))
+	}
+	logger.Info("Committed /etc history", "tag", tag)
+	return nil
+}
+
+// nextGeneration returns the generation following the highest summit-gen tag
+// in the output of git tag --list.
+func nextGeneration(tags string) int {
+	highest := 0
+	for _, tag := range strings.Fields(tags) {
+		if n, err := strconv.Atoi(strings.TrimPrefix(tag, etcGenerationTag)); err == nil && n > highest {
+			highest = n
+		}
+	}
+	return highest + 1
+}
+
+// etcCommitMessage describes the apply a commit of /etc records.
+func etcCommitMessage(generation int, applied []actions.Action) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "summit generation %d\n\n", generation)
+	for _, action := range applied {
+		fmt.Fprintf(&sb, "- %s\n", action.Description())
+	}
+	return sb.String()
+}
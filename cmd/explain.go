@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"summit/pkg/config"
+	"summit/pkg/log"
+	"summit/pkg/model"
+
+	"github.com/spf13/cobra"
+)
+
+// explainCmd represents the explain command
+var explainCmd = &cobra.Command{
+	Use:   "explain [filter]",
+	Short: "Shows which file and line each merged entity comes from",
+	Long: `The explain command loads the config with its includes and prints, for every
+package, service, user, config, managed block, plugin, user package, var and
+top-level setting, the file and line that declared it, along with the earlier
+declarations it overrode or was merged into:
+
+  config /etc/motd  hosts/web1.yaml:12
+    overrides common.yaml:30
+
+With a filter, only entities whose name contains it are shown, e.g.
+"summit explain /etc/nginx" or "summit explain user".`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+		desired, err := config.LoadConfig(cfgFile, logger)
+		if err != nil {
+			return err
+		}
+		filter := ""
+		if len(args) == 1 {
+			filter = args[0]
+		}
+
+		out := cmd.OutOrStdout()
+		lines := explainProvenance(desired.Provenance, filepath.Dir(cfgFile), filter)
+		if len(lines) == 0 {
+			fmt.Fprintln(out, "No matching entities.")
+			return nil
+		}
+		for _, line := range lines {
+			fmt.Fprintln(out, line)
+		}
+		return nil
+	},
+}
+
+// explainProvenance returns the explain output lines for the entities whose
+// key contains filter, sorted by key, with files relative to base.
+func explainProvenance(p *model.Provenance, base, filter string) []string {
+	if p == nil {
+		return nil
+	}
+	rel := func(s model.Source) string {
+		if r, err := filepath.Rel(base, s.File); err == nil && !strings.HasPrefix(r, "..") {
+			return fmt.Sprintf("%s:%d", r, s.Line)
+		}
+		return s.String()
+	}
+
+	overrides := make(map[string][]model.Override)
+	for _, o := range p.Overrides {
+		overrides[o.Key] = append(overrides[o.Key], o)
+	}
+	keys := make([]string, 0, len(p.Sources))
+	for key := range p.Sources {
+		if strings.Contains(key, filter) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		lines = append(lines, fmt.Sprintf("%s  %s", key, rel(p.Sources[key])))
+		// Most recent first, back to the first declaration
+		list := overrides[key]
+		for i := len(list) - 1; i >= 0; i-- {
+			verb := "overrides"
+			if list[i].Merged {
+				verb = "merged with"
+			}
+			lines = append(lines, fmt.Sprintf("    %s %s", verb, rel(list[i].Previous)))
+		}
+	}
+	return lines
+}
+
+func init() {
+	rootCmd.AddCommand(explainCmd)
+}
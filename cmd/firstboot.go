@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"summit/pkg/actions"
+	"summit/pkg/config"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+const (
+	firstbootService    = "summit-firstboot"
+	firstbootInitScript = "/etc/init.d/" + firstbootService
+	firstbootConfFile   = "/etc/conf.d/" + firstbootService
+)
+
+var (
+	firstbootRunlevel   string
+	firstbootLogFile    string
+	firstbootDisruptive bool
+	firstbootDryRun     bool
+)
+
+// firstbootCmd groups the first-boot subcommands
+var firstbootCmd = &cobra.Command{
+	Use:   "firstboot",
+	Short: "Provisions a golden image on its first boot",
+	Long: `Golden images are built generic; the config that makes a machine what it is
+is applied when an instance of the image first boots. "summit firstboot install",
+run while building the image, registers a one-shot OpenRC service that applies the
+baked-in config on the next boot and then removes itself from its runlevel.
+
+If the apply fails the service stays enabled, so the next boot tries again.`,
+}
+
+// firstbootInstallCmd represents the firstboot install command
+var firstbootInstallCmd = &cobra.Command{
+	Use:   "install [-- apply flags]",
+	Short: "Registers a one-shot service applying the config on first boot",
+	Long: `The firstboot install command installs the summit-firstboot OpenRC service and
+enables it without starting it. On the next boot it runs "summit apply" against
+the current config, logging to --log-file, and on success disables itself with
+rc-update del. Flags after -- are passed to that apply, e.g.
+"summit firstboot install -- --from-metadata --metadata-timeout 10m".
+
+The config is loaded once at install time so a broken config fails the image
+build rather than the first boot. It must stay at the same path in the image.
+With --from-metadata it is fetched on the first boot instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+
+		if err := checkApplyArgs(args); err != nil {
+			return err
+		}
+		var applyArgs []string
+		if firstbootDisruptive {
+			applyArgs = append(applyArgs, "--allow-disruptive")
+		}
+		applyArgs = append(applyArgs, args...)
+
+		binary, err := os.Executable()
+		if err != nil {
+			return fmt.Errorf("failed to locate the summit binary: %w", err)
+		}
+		configPath, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return err
+		}
+		if !fromMetadata(applyArgs) {
+			if _, err := config.LoadConfig(configPath, logger); err != nil {
+				return err
+			}
+		}
+
+		plan, err := firstbootPlan(binary, configPath, applyArgs)
+		if err != nil {
+			return err
+		}
+
+		if firstbootDryRun {
+			fmt.Fprintln(cmd.OutOrStdout(), "Dry run enabled. The following operations would be performed:")
+			for _, action := range plan {
+				fmt.Fprintf(cmd.OutOrStdout(), "=> %s\n", action.Description())
+				for _, detail := range action.ExecutionDetails() {
+					fmt.Fprintf(cmd.OutOrStdout(), "   - %s\n", detail)
+				}
+			}
+			return nil
+		}
+
+		// The image being built is not running its init system, and the
+		// service must only run on the next boot
+		startServices := actions.StartServices
+		actions.StartServices = false
+		defer func() { actions.StartServices = startServices }()
+
+		_, err = executePlan(cmd, plan, &model.SystemState{}, cmdRunner, logger)
+		return err
+	},
+}
+
+// checkApplyArgs refuses arguments that name no flag of summit apply, so a
+// typo fails the image build rather than every boot.
+func checkApplyArgs(args []string) error {
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			continue // The value of the previous flag
+		}
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		known := false
+		if strings.HasPrefix(arg, "--") {
+			known = applyCmd.Flags().Lookup(name) != nil || rootCmd.PersistentFlags().Lookup(name) != nil
+		} else {
+			known = applyCmd.Flags().ShorthandLookup(name[:1]) != nil || rootCmd.PersistentFlags().ShorthandLookup(name[:1]) != nil
+		}
+		if !known {
+			return fmt.Errorf("unknown apply flag %s", arg)
+		}
+	}
+	return nil
+}
+
+// fromMetadata reports whether the apply arguments fetch the config from the
+// instance metadata service.
+func fromMetadata(args []string) bool {
+	for _, arg := range args {
+		if arg == "--from-metadata" || arg == "--from-metadata=true" {
+			return true
+		}
+	}
+	return false
+}
+
+// firstbootPlan returns the actions that install and enable the first-boot
+// service.
+func firstbootPlan(binary, configPath string, applyArgs []string) ([]actions.Action, error) {
+	var plan []actions.Action
+	for _, file := range []struct {
+		path, content, mode string
+	}{
+		{firstbootInitScript, firstbootInitScriptContent(binary), "0755"},
+		{firstbootConfFile, firstbootConfContent(configPath, applyArgs), "0644"},
+	} {
+		fileActions, err := ensureFile(file.path, file.content, file.mode)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, fileActions...)
+	}
+
+	enabled, err := afero.Exists(system.AppFs, filepath.Join("/etc/runlevels", firstbootRunlevel, firstbootService))
+	if err != nil {
+		return nil, err
+	}
+	if !enabled {
+		plan = append(plan, &actions.ServiceEnableAction{ServiceName: firstbootService, Runlevel: firstbootRunlevel})
+	}
+	return plan, nil
+}
+
+func firstbootInitScriptContent(binary string) string {
+	return fmt.Sprintf(`#!/sbin/openrc-run
+# Installed by summit firstboot install. Settings live in /etc/conf.d/summit-firstboot.
+
+name="summit-firstboot"
+description="summit first-boot provisioning"
+
+depend() {
+	need net localmount
+	after firewall
+}
+
+start() {
+	ebegin "Applying ${SUMMIT_CONFIG}"
+	# SUMMIT_APPLY_ARGS holds shell-quoted arguments
+	eval "set -- ${SUMMIT_APPLY_ARGS}"
+	%s --config "${SUMMIT_CONFIG}" --log-level "${SUMMIT_LOG_LEVEL}" apply "$@" >>"${SUMMIT_LOG_FILE}" 2>&1
+	ret=$?
+	if [ $ret -eq 0 ]; then
+		rc-update del "${RC_SVCNAME}" "${SUMMIT_RUNLEVEL}"
+	fi
+	eend $ret "summit apply failed, see ${SUMMIT_LOG_FILE}; retrying on next boot"
+}
+`, system.ShellQuote(binary))
+}
+
+func firstbootConfContent(configPath string, applyArgs []string) string {
+	quoted := make([]string, len(applyArgs))
+	for i, arg := range applyArgs {
+		quoted[i] = system.ShellQuote(arg)
+	}
+	return fmt.Sprintf(`# Settings for the summit first-boot service, written by summit firstboot install.
+SUMMIT_CONFIG=%s
+SUMMIT_LOG_LEVEL=%s
+SUMMIT_LOG_FILE=%s
+SUMMIT_RUNLEVEL=%s
+SUMMIT_APPLY_ARGS=%s
+`, system.ShellQuote(configPath), system.ShellQuote(logLevel), system.ShellQuote(firstbootLogFile), system.ShellQuote(firstbootRunlevel), system.ShellQuote(strings.Join(quoted, " ")))
+}
+
+func init() {
+	rootCmd.AddCommand(firstbootCmd)
+	firstbootCmd.AddCommand(firstbootInstallCmd)
+	firstbootInstallCmd.Flags().StringVar(&firstbootRunlevel, "runlevel", "default", "Runlevel the first-boot service runs in")
+	firstbootInstallCmd.Flags().StringVar(&firstbootLogFile, "log-file", "/var/log/summit-firstboot.log", "File the first-boot apply logs to")
+	firstbootInstallCmd.Flags().BoolVar(&firstbootDisruptive, "allow-disruptive", false, "Let the first-boot apply make changes that could cut off remote access")
+	firstbootInstallCmd.Flags().BoolVar(&firstbootDryRun, "dry-run", false, "Show what would be installed without changing the system")
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"summit/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+var fmtCheck bool
+
+// fmtCmd represents the fmt command
+var fmtCmd = &cobra.Command{
+	Use:   "fmt [file...]",
+	Short: "Rewrites config files in canonical form",
+	Long: `The fmt command rewrites config files in canonical form, so changes to a config
+repository only show what actually changed:
+
+  - top-level keys, and the keys of each package, config, user, ..., in a fixed order
+  - packages, services, users, groups, configs, user configs, user packages and
+    plugins sorted the way summit sorts them after loading
+  - two-space indentation, block style except for short lists like [wheel]
+  - strings quoted only when they would otherwise read as a number or boolean,
+    and multi-line content as a literal block
+
+Comments are kept. Without arguments the config file itself is formatted;
+includes are formatted by naming them, e.g. "summit fmt system.yaml roles/*.yaml".
+Files encrypted with sops or age are left alone.
+
+With --check nothing is written: the files that are not formatted are listed
+and the command fails, for use in CI.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files := args
+		if len(files) == 0 {
+			files = []string{cfgFile}
+		}
+		out := cmd.OutOrStdout()
+		var unformatted int
+		for _, file := range files {
+			changed, err := config.FormatFile(file, fmtCheck)
+			if err != nil {
+				return fmt.Errorf("failed to format %s: %w", file, err)
+			}
+			if changed {
+				unformatted++
+				fmt.Fprintln(out, file)
+			}
+		}
+		if fmtCheck && unformatted > 0 {
+			return fmt.Errorf("%d of %d files are not formatted; run summit fmt", unformatted, len(files))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fmtCmd)
+	fmtCmd.Flags().BoolVar(&fmtCheck, "check", false, "Only list the files that are not formatted, failing if there are any")
+}
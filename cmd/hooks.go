@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"summit/pkg/actions"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// runWithHooks runs apply, which applies plan, between the pre-apply and
+// post-apply hooks of the config, and runs the on-failure hooks when a
+// pre-apply hook or apply fails. Nothing runs around a plan without changes.
+// A failing post-apply hook fails the run but leaves the changes in place.
+func runWithHooks(hooks *model.HooksState, plan []actions.Action, runner system.CommandRunner, logger log.Logger, apply func() error) error {
+	if hooks == nil || len(plan) == 0 {
+		return apply()
+	}
+	if err := runHooks("pre-apply", hooks.PreApply, true, runner, logger); err != nil {
+		runHooks("on-failure", hooks.OnFailure, false, runner, logger)
+		return fmt.Errorf("%w; nothing was applied", err)
+	}
+	if err := apply(); err != nil {
+		runHooks("on-failure", hooks.OnFailure, false, runner, logger)
+		return err
+	}
+	return runHooks("post-apply", hooks.PostApply, false, runner, logger)
+}
+
+// runHooks runs the hooks of a stage in order as root, logging their output.
+// With stop set the first failing hook ends the stage; otherwise every hook
+// runs and the first failure is returned.
+func runHooks(stage string, hooks []string, stop bool, runner system.CommandRunner, logger log.Logger) error {
+	var first error
+	for _, hook := range hooks {
+		logger.Info("Running hook", "stage", stage, "command", hook)
+		out, err := runner.Run("", hook)
+		if output := strings.TrimSpace(string(out)); output != "" {
+			logger.Info("Hook output", "stage", stage, "command", hook, "output", output)
+		}
+		if err == nil {
+			continue
+		}
+		logger.Error("Hook failed", "stage", stage, "command", hook, "error", err)
+		if first == nil {
+			first = fmt.Errorf("%s hook %q failed: %w", stage, hook, err)
+		}
+		if stop {
+			break
+		}
+	}
+	return first
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"summit/pkg/config"
+	"summit/pkg/diff"
+	"summit/pkg/facts"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	initConfigs []string
+	initForce   bool
+)
+
+// initCmd represents the init command
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Writes a starter system.yaml from the live system",
+	Long: `The init command reads the live system and writes a starter config for it, so
+an existing box can be brought under summit without copying dump output by hand.
+The config lists:
+
+  - the packages in /etc/apk/world
+  - the services enabled in a runlevel
+  - the users
+  - the package-modified files, which apply would otherwise revert
+  - the files matching --configs, e.g. --configs '/etc/nginx/**'
+
+Files the config does not list are left alone by apply and reported as
+unmanaged. Files too large or binary to inline are skipped. An existing config
+file is only overwritten with --force.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if exists, _ := afero.Exists(system.AppFs, cfgFile); exists && !initForce {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", cfgFile)
+		}
+
+		current, _, err := system.InferSystemState(cmdRunner, false)
+		if err != nil {
+			return err
+		}
+		starter, skipped, err := starterConfig(current, initConfigs)
+		if err != nil {
+			return err
+		}
+		if errs := starter.Validate(); len(errs) > 0 {
+			return errs
+		}
+
+		data, err := yaml.Marshal(starter)
+		if err != nil {
+			return fmt.Errorf("error marshaling to YAML: %w", err)
+		}
+		if data, err = config.Format(data); err != nil {
+			return err
+		}
+		header := fmt.Sprintf("# Starter config written by summit init on %s.\n# Review it with summit diff before running summit apply.\n\n", facts.Hostname())
+		if err := afero.WriteFile(system.AppFs, cfgFile, append([]byte(header), data...), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", cfgFile, err)
+		}
+
+		out := cmd.OutOrStdout()
+		for _, s := range skipped {
+			fmt.Fprintf(out, "skipped %s: %s\n", s.Field, s.Message)
+		}
+		fmt.Fprintf(out, "Wrote %s: %d packages, %d services, %d users, %d configs\n",
+			cfgFile, len(starter.Packages), len(starter.Services), len(starter.Users), len(starter.Configs))
+		return nil
+	},
+}
+
+// starterConfig returns the config init writes for the current state: its
+// packages, enabled services and users, the package-modified files and the
+// files matching patterns, along with the files skipped for their size or
+// content.
+func starterConfig(current *model.SystemState, patterns []string) (*model.SystemState, model.ValidationErrors, error) {
+	starter := &model.SystemState{
+		Version:         config.CurrentVersion(),
+		Packages:        current.Packages,
+		VirtualPackages: current.VirtualPackages,
+		Users:           current.Users,
+	}
+	for _, svc := range model.SplitRunlevels(withoutRunning(current.Services)) {
+		if svc.Enabled || svc.Runlevel != "" {
+			starter.Services = append(starter.Services, svc)
+		}
+	}
+
+	configs, skipped, err := configsToAdopt(&model.SystemState{}, current, func(c model.SystemConfigState) bool {
+		if c.Origin == model.OriginPackageModified {
+			return true
+		}
+		for _, pattern := range patterns {
+			if diff.MatchesGlob(pattern, c.Path) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	starter.Configs = configs
+	return starter, skipped, nil
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringArrayVar(&initConfigs, "configs", nil, "Also list the files matching a glob, e.g. '/etc/nginx/**' (repeatable)")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite an existing config file")
+}
@@ -1,8 +1,110 @@
 package cmd
 
+import (
+	"fmt"
+	"summit/pkg/actions"
+	"summit/pkg/diff"
+	"summit/pkg/executor"
+	"summit/pkg/model"
+)
+
 // actionForJSON is a struct used for marshaling an action to JSON for machine-readable output.
 type actionForJSON struct {
 	Type        string   `json:"type"`
 	Description string   `json:"description"`
 	Details     []string `json:"details"`
+	Rollback    []string `json:"rollback"`
+	Team        string   `json:"team,omitempty"`
+}
+
+// planForJSON converts a plan into its machine-readable representation,
+// labeling each action with the team of the desired resource it converges.
+func planForJSON(plan []actions.Action, desired *model.SystemState) []actionForJSON {
+	actionsForJSON := []actionForJSON{}
+	for _, action := range plan {
+		actionsForJSON = append(actionsForJSON, actionForJSON{
+			Type:        action.Type(),
+			Description: action.Description(),
+			Details:     action.ExecutionDetails(),
+			Rollback:    action.RollbackDetails(),
+			Team:        diff.Team(action, desired),
+		})
+	}
+	return actionsForJSON
+}
+
+// warningForJSON is a single warning in machine-readable output.
+type warningForJSON struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// planDocumentForJSON is the top-level document emitted by --json together with
+// --json-warnings: the plan and any warnings found while building it.
+type planDocumentForJSON struct {
+	Actions  []actionForJSON  `json:"actions"`
+	Warnings []warningForJSON `json:"warnings"`
+}
+
+// planDocument converts a plan and its warnings into the machine-readable document.
+func planDocument(plan []actions.Action, desired *model.SystemState, warnings model.ValidationErrors) planDocumentForJSON {
+	doc := planDocumentForJSON{Actions: planForJSON(plan, desired), Warnings: []warningForJSON{}}
+	for _, w := range warnings {
+		doc.Warnings = append(doc.Warnings, warningForJSON{Field: w.Field, Message: w.Message})
+	}
+	return doc
+}
+
+// planOutput returns the machine-readable plan printed by --json: a bare array
+// of actions, or the document with its warnings when --json-warnings is set.
+func planOutput(plan []actions.Action, desired *model.SystemState, warnings model.ValidationErrors) any {
+	if jsonWarnings {
+		return planDocument(plan, desired, warnings)
+	}
+	return planForJSON(plan, desired)
+}
+
+// describeAction returns the plan line for an action, suffixed with the team
+// label of its resource when it has one.
+func describeAction(action actions.Action, desired *model.SystemState) string {
+	if team := diff.Team(action, desired); team != "" {
+		return fmt.Sprintf("%s [team: %s]", action.Description(), team)
+	}
+	return action.Description()
+}
+
+// resultForJSON is the outcome of a single applied action in machine-readable output.
+type resultForJSON struct {
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// applyResultForJSON is the machine-readable outcome of an apply: the status of
+// every planned action in plan order and whether the run was rolled back.
+type applyResultForJSON struct {
+	Results    []resultForJSON `json:"results"`
+	RolledBack bool            `json:"rolled_back"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// applyResult converts an executor report and the error of the run into the
+// machine-readable result.
+func applyResult(report *executor.Report, err error) applyResultForJSON {
+	result := applyResultForJSON{Results: []resultForJSON{}}
+	if report != nil {
+		result.RolledBack = report.RolledBack
+		for _, r := range report.Results {
+			entry := resultForJSON{Type: r.Action.Type(), Description: r.Action.Description(), Status: string(r.Status)}
+			if r.Err != nil {
+				entry.Error = r.Err.Error()
+			}
+			result.Results = append(result.Results, entry)
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
 }
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"summit/pkg/actions"
+	"summit/pkg/diff"
+	"summit/pkg/log"
+	"summit/pkg/system"
+)
+
+// mailReport mails the outcome of a run to the --mail-to addresses, but only
+// when there were changes or the run failed, so cron-driven runs stay quiet
+// when the system is converged. changes are the pending or applied actions,
+// described by verb; plan is what the run attempted. The run error is
+// returned unchanged; a mail failure is only returned when the run succeeded.
+func mailReport(runner system.CommandRunner, logger log.Logger, to, command, verb string, plan, changes []actions.Action, runErr error) error {
+	if runErr == nil && len(changes) == 0 {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	subject := fmt.Sprintf("summit %s on %s: %d change(s) %s", command, host, len(changes), verb)
+	body := diff.FormatSummary(changes)
+	if runErr != nil {
+		subject = fmt.Sprintf("summit %s on %s failed", command, host)
+		body = fmt.Sprintf("Error: %v\n\nPlan:\n%s", runErr, diff.FormatSummary(plan))
+	}
+
+	if err := sendMail(runner, to, subject, body); err != nil {
+		logger.Error("Failed to mail the run report", "to", to, "error", err)
+		if runErr == nil {
+			return err
+		}
+	}
+	return runErr
+}
+
+// sendMail hands a plain-text message to the local sendmail, which busybox
+// provides on Alpine. to may list several comma-separated addresses.
+func sendMail(runner system.CommandRunner, to, subject, body string) error {
+	message := fmt.Sprintf("To: %s\nSubject: %s\nContent-Type: text/plain; charset=utf-8\n\n%s", to, subject, body)
+	out, err := runner.Run("", fmt.Sprintf("printf '%%s' %s | sendmail -t", system.ShellQuote(message)))
+	if err != nil {
+		return fmt.Errorf("sendmail failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
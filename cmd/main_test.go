@@ -3,9 +3,20 @@ package cmd
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"summit/pkg/actions"
+	"summit/pkg/config"
+	"summit/pkg/log"
 	"summit/pkg/model"
 	"summit/pkg/system"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
@@ -32,6 +43,11 @@ func (r *MockCommandRunner) Run(user, command string) ([]byte, error) {
 	return nil, nil
 }
 
+// RunInput simulates running a command with input on its stdin.
+func (r *MockCommandRunner) RunInput(user, command string, input []byte) ([]byte, error) {
+	return r.Run(user, command)
+}
+
 func executeCommand(runner *MockCommandRunner, args ...string) (string, error) {
 	buf := new(bytes.Buffer)
 	rootCmd.SetOut(buf)
@@ -88,6 +104,47 @@ configs:
 	assert.Equal(t, "Hello from summit!\n", string(content))
 }
 
+func TestApply_AssertionFailureRollsBack(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { rollbackOnAssert = false })
+
+	config := `
+configs:
+  - path: /etc/motd
+    content: hello
+assertions:
+  - file-exists: /etc/motd
+  - name: nginx running
+    file-exists: /run/nginx.pid
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--rollback-on-assert-failure")
+	require.EqualError(t, err, "1 of 2 assertion(s) failed after apply")
+
+	exists, err := afero.Exists(system.AppFs, "/etc/motd")
+	require.NoError(t, err)
+	assert.False(t, exists, "created file should be rolled back")
+}
+
+func TestVerify_ReportsResults(t *testing.T) {
+	runner := setupTest(t)
+	runner.Errors[":nginx -t"] = errors.New("nginx not installed")
+
+	config := `
+assertions:
+  - file-exists: /etc/passwd
+  - command: nginx -t
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "verify", "--config", "/system.yaml")
+	require.EqualError(t, err, "1 of 2 assertion(s) failed")
+	assert.Contains(t, output, "PASS file-exists: /etc/passwd\n")
+	assert.Contains(t, output, `FAIL command: nginx -t: failed to run "nginx -t": nginx not installed`)
+}
+
 func TestDiff_ShowsChanges(t *testing.T) {
 	runner := setupTest(t)
 	runner.Responses[":apk audit"] = []byte("")
@@ -116,10 +173,10 @@ configs:
 
 	assert.Len(t, plan, 2)
 
-	assert.Equal(t, "*actions.PackageInstallAction", plan[0].Type)
+	assert.Equal(t, "package.install", plan[0].Type)
 	assert.Equal(t, "Install package htop", plan[0].Description)
 
-	assert.Equal(t, "*actions.FileCreateAction", plan[1].Type)
+	assert.Equal(t, "file.create", plan[1].Type)
 	assert.Equal(t, "Create file /etc/motd", plan[1].Description)
 
 	// Verify no side effects: diff should only run read commands to infer state
@@ -133,6 +190,7 @@ func TestDump_OutputsSystemState(t *testing.T) {
 
 	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\n"), 0644))
 	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("Hello from summit!"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/group", []byte("wheel:x:10:\ndevs:x:1500:\n"), 0644))
 
 	output, err := executeCommand(runner, "dump", "--json")
 	require.NoError(t, err)
@@ -147,6 +205,197 @@ func TestDump_OutputsSystemState(t *testing.T) {
 	assert.Len(t, state.Configs, 1)
 	assert.Equal(t, "/etc/motd", state.Configs[0].Path)
 	assert.Equal(t, "Hello from summit!", state.Configs[0].Content)
+
+	assert.Equal(t, []model.GroupState{{Name: "devs", GID: 1500}}, state.Groups)
+}
+
+func TestDump_AnsibleFacts(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("A  /etc/motd")
+	runner.Responses[":apk --print-arch"] = []byte("x86_64\n")
+	runner.Responses[":ip -4 -o addr show scope global"] = []byte("2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\n")
+	t.Cleanup(func() { dumpFormat = "yaml" })
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hostname", []byte("web1\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/alpine-release", []byte("3.20.3\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("Hello"), 0644))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--format", "ansible-facts")
+	require.NoError(t, err)
+
+	var doc struct {
+		AnsibleFacts map[string]any `json:"ansible_facts"`
+		Changed      bool           `json:"changed"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &doc))
+	facts := doc.AnsibleFacts
+	assert.Equal(t, "web1", facts["ansible_hostname"])
+	assert.Equal(t, "x86_64", facts["ansible_architecture"])
+	assert.Equal(t, "3.20.3", facts["ansible_distribution_version"])
+	assert.Equal(t, "3", facts["ansible_distribution_major_version"])
+	assert.Equal(t, map[string]any{"address": "10.0.0.5"}, facts["ansible_default_ipv4"])
+	assert.Equal(t, map[string]any{"htop": []any{map[string]any{"name": "htop", "source": "apk"}}}, facts["packages"])
+	assert.Equal(t, []any{"/etc/motd"}, facts["summit_configs"])
+
+	_, err = executeCommand(runner, "dump", "--json=false", "--format", "toml")
+	assert.ErrorContains(t, err, "invalid --format value: toml")
+}
+
+func TestDump_Annotate(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("A  /etc/motd\nU  /etc/nginx/nginx.conf")
+	runner.Responses[":apk info --who-owns '/etc/nginx/nginx.conf'"] = []byte("/etc/nginx/nginx.conf is owned by nginx-1.24.0-r1")
+	t.Cleanup(func() { dumpAnnotate = false })
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("Hello"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/nginx/nginx.conf", []byte("user nginx;"), 0644))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--annotate")
+	require.NoError(t, err)
+
+	assert.Contains(t, output, "# added (apk audit: A), not owned by any package\n    - path: /etc/motd")
+	assert.Contains(t, output, "# modified (apk audit: U), owned by package nginx-1.24.0-r1\n    - path: /etc/nginx/nginx.conf")
+}
+
+func TestDump_UserConfigs(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { dumpUserConfigs = nil })
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("alice:x:1000:1000::/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/home/alice/.profile", []byte("export EDITOR=vi\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/home/alice/.config/git/config", []byte("[user]\n"), 0600))
+
+	output, err := executeCommand(runner, "dump", "--json")
+	require.NoError(t, err)
+	var state model.SystemState
+	require.NoError(t, json.Unmarshal([]byte(output), &state))
+	assert.Empty(t, state.UserConfigs, "homes are only read for the users asked for")
+
+	output, err = executeCommand(runner, "dump", "--json", "--user-configs", "alice", "--user-configs", "alice:.config/git/*")
+	require.NoError(t, err)
+	state = model.SystemState{}
+	require.NoError(t, json.Unmarshal([]byte(output), &state))
+	assert.Equal(t, []model.UserConfigState{
+		{User: "alice", Path: ".profile", Content: "export EDITOR=vi\n", Mode: "0644"},
+		{User: "alice", Path: ".config/git/config", Content: "[user]\n", Mode: "0600"},
+	}, state.UserConfigs)
+}
+
+func TestSensitiveConfigs(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("A  /etc/wireguard/wg0.conf")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/wireguard/wg0.conf", []byte("PrivateKey = old-key\n"), 0600))
+
+	content := `configs:
+  - path: /etc/wireguard/wg0.conf
+    mode: "0600"
+    sensitive: true
+    content: "PrivateKey = new-key\n"
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(content), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json")
+	require.NoError(t, err)
+	assert.Contains(t, output, `"content changed (redacted)"`)
+	assert.NotContains(t, output, "new-key")
+	assert.NotContains(t, output, "old-key")
+
+	output, err = executeCommand(runner, "dump", "--config", "/system.yaml", "--json")
+	require.NoError(t, err)
+	var state model.SystemState
+	require.NoError(t, json.Unmarshal([]byte(output), &state))
+	require.Len(t, state.Configs, 1)
+	assert.Equal(t, "(redacted)", state.Configs[0].Content)
+	assert.True(t, state.Configs[0].Sensitive)
+
+	// A config is never dumped with the placeholder for content
+	t.Cleanup(func() { dumpAsConfig = false })
+	output, err = executeCommand(runner, "dump", "--config", "/system.yaml", "--json=false", "--as-config")
+	require.NoError(t, err)
+	assert.NotContains(t, output, "(redacted)")
+	assert.NotContains(t, output, "old-key")
+	assert.Contains(t, output, "ignored-configs:\n  - /etc/wireguard/wg0.conf\n")
+}
+
+func TestSensitiveUserConfigs(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { dumpUserConfigs = nil; dumpAsConfig = false })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("alice:x:1000:1000::/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/home/alice/.netrc", []byte("password old-token\n"), 0600))
+
+	content := `user-configs:
+  - user: alice
+    path: .netrc
+    mode: "0600"
+    sensitive: true
+    content: "password new-token\n"
+managed-blocks:
+  - path: /etc/hosts
+    sensitive: true
+    content: "10.0.0.1 vault-token\n"
+service-options:
+  - service: app
+    sensitive: true
+    options:
+      APP_TOKEN: options-token
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(content), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json")
+	require.NoError(t, err)
+	assert.Contains(t, output, `"content changed (redacted)"`)
+	assert.NotContains(t, output, "-token")
+
+	output, err = executeCommand(runner, "dump", "--config", "/system.yaml", "--json", "--user-configs", "alice:.netrc")
+	require.NoError(t, err)
+	var state model.SystemState
+	require.NoError(t, json.Unmarshal([]byte(output), &state))
+	require.Len(t, state.UserConfigs, 1)
+	assert.Equal(t, "(redacted)", state.UserConfigs[0].Content)
+	assert.True(t, state.UserConfigs[0].Sensitive)
+
+	// A sensitive user config is left out of a dumped config
+	dumpUserConfigs = nil
+	output, err = executeCommand(runner, "dump", "--config", "/system.yaml", "--json=false", "--as-config", "--user-configs", "alice:.netrc")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Leaving out sensitive user config")
+	assert.NotContains(t, output, "user-configs:")
+	assert.NotContains(t, output, "-token")
+}
+
+func TestApply_UserConfigs(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("alice:x:1000:1000::/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/home/alice/.profile", []byte("export EDITOR=nano\n"), 0644))
+
+	config := `
+user-configs:
+  - user: alice
+    path: .profile
+    content: |
+      export EDITOR=vi
+  - user: alice
+    path: .config/git/config
+    mode: "0600"
+    content: |
+      [user]
+        name = Alice
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml")
+	require.NoError(t, err)
+
+	content, err := afero.ReadFile(system.AppFs, "/home/alice/.profile")
+	require.NoError(t, err)
+	assert.Equal(t, "export EDITOR=vi\n", string(content))
+	content, err = afero.ReadFile(system.AppFs, "/home/alice/.config/git/config")
+	require.NoError(t, err)
+	assert.Equal(t, "[user]\n  name = Alice\n", string(content))
 }
 
 func TestApply_DryRun(t *testing.T) {
@@ -167,16 +416,18 @@ packages:
 	type actionForJSON struct {
 		Type        string
 		Description string
+		Rollback    []string
 	}
 	var plan []actionForJSON
 	require.NoError(t, json.Unmarshal([]byte(output), &plan))
 
 	assert.Len(t, plan, 1)
-	assert.Equal(t, "*actions.PackageInstallAction", plan[0].Type)
+	assert.Equal(t, "package.install", plan[0].Type)
 	assert.Equal(t, "Install package htop", plan[0].Description)
+	assert.Equal(t, []string{"run: apk del htop"}, plan[0].Rollback)
 
 	// Verify that only read-only commands were run
-	assert.Equal(t, []string{":apk audit", ":sh -c 'cat /etc/group'"}, runner.Commands)
+	assert.Equal(t, []string{":rc-status --servicelist", ":apk audit", ":sysctl -a", ":readlink /etc/localtime", ":sh -c 'cat /etc/group'"}, runner.Commands)
 }
 
 func TestDiff_UserPackages(t *testing.T) {
@@ -228,13 +479,13 @@ user-packages:
 	foundRuff := false
 	foundBlack := false
 	for _, action := range plan {
-		if action.Type == "*actions.PackageInstallAction" && action.Description == "Install package pipx" {
+		if action.Type == "package.install" && action.Description == "Install package pipx" {
 			foundPipx = true
 		}
-		if action.Type == "*actions.UserPackageAction" && action.Description == "Ensure user package 'ruff' for user 'testuser' managed by 'pipx' is present" {
+		if action.Type == "userpackage.ensure" && action.Description == "Ensure user package 'ruff' for user 'testuser' managed by 'pipx' is present" {
 			foundRuff = true
 		}
-		if action.Type == "*actions.UserPackageAction" && action.Description == "Ensure user package 'black' for user 'testuser' managed by 'pipx' is absent" {
+		if action.Type == "userpackage.ensure" && action.Description == "Ensure user package 'black' for user 'testuser' managed by 'pipx' is absent" {
 			foundBlack = true
 		}
 	}
@@ -246,3 +497,1266 @@ user-packages:
 	assert.Contains(t, runner.Commands, ":apk audit")
 	assert.Contains(t, runner.Commands, "testuser:pipx list --json")
 }
+
+func TestDiff_GoldenFormatToFile(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() {
+		diffFormat = "text"
+		diffOutputFile = ""
+	})
+
+	config := `
+packages:
+  - name: htop
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--format", "golden", "-o", "/plan.txt")
+	require.NoError(t, err)
+	assert.Empty(t, output)
+
+	content, err := afero.ReadFile(system.AppFs, "/plan.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "# summit plan\npackage.install: Install package htop\n    run: apk add htop\n", string(content))
+}
+
+func TestDiff_MarkdownFormats(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { diffFormat = "text" })
+
+	config := `
+packages:
+  - name: htop
+    team: ops
+configs:
+  - path: /etc/motd
+    content: "a | b"
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--format", "markdown")
+	require.NoError(t, err)
+	assert.Contains(t, output, "2 change(s):\n\n| # | Type | Change | Team |\n|---|------|--------|------|\n")
+	assert.Contains(t, output, "| 1 | `package.install` | Install package htop | ops |\n")
+	assert.Contains(t, output, "| 2 | `file.create` | Create file /etc/motd |  |\n")
+
+	output, err = executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--format", "github")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(output, "<!-- summit-plan -->\n### summit plan\n\n**2 change(s)**\n"), output)
+	assert.Contains(t, output, "| `package.install` | 1 | htop |\n")
+	assert.Contains(t, output, "<details>\n<summary>Details</summary>\n\n**Install package htop [team: ops]**\n\n```\nrun: apk add htop\n```\n")
+	assert.Contains(t, output, "\n</details>\n")
+
+	_, err = executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--format", "html")
+	assert.ErrorContains(t, err, "invalid format: html (must be text, json, golden, summary, markdown or github)")
+}
+
+func TestDiff_Summary(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { diffSummary = false })
+
+	config := `
+packages:
+  - name: htop
+  - name: vim
+configs:
+  - path: /etc/motd
+    content: secret
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--summary")
+	require.NoError(t, err)
+	assert.Equal(t, "3 change(s):\n  file.create (1): /etc/motd\n  package.install (2): htop, vim\n", output)
+}
+
+func TestApply_RendersTemplates(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	runner.Responses[":ip -4 -o addr show scope global"] = []byte("2: eth0    inet 10.0.0.5/24 scope global eth0\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hostname", []byte("web1\n"), 0644))
+
+	config := `
+vars:
+  port: 80
+host-vars:
+  web1:
+    port: 8080
+configs:
+  - path: /etc/nginx/http.d/site.conf
+    template: true
+    content: "listen {{ .Facts.IPv4 }}:{{ .Vars.port }};"
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false")
+	require.NoError(t, err)
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/nginx/http.d/site.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "listen 10.0.0.5:8080;", string(content))
+}
+
+func TestDiff_WhenConditions(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	runner.Responses[":apk --print-arch"] = []byte("aarch64\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hostname", []byte("laptop1\n"), 0644))
+
+	common := `packages:
+  - name: htop
+  - name: raspberrypi-utils
+    when: facts.arch == "aarch64"
+  - name: tlp
+    when: vars.kind == "laptop"
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/common.yaml", []byte(common), 0644))
+	config := `includes:
+  - common.yaml
+vars:
+  kind: server
+host-vars:
+  laptop1:
+    kind: laptop
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/srv/summit/system.yaml", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "raspberrypi-utils")
+	assert.Contains(t, output, "tlp")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hostname", []byte("web1\n"), 0644))
+	runner.Responses[":apk --print-arch"] = []byte("x86_64\n")
+	output, err = executeCommand(runner, "diff", "--config", "/srv/summit/system.yaml", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "htop")
+	assert.NotContains(t, output, "raspberrypi-utils")
+	assert.NotContains(t, output, "tlp")
+}
+
+func TestDiff_Profile(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { config.Profiles = nil })
+
+	content := `packages:
+  - name: htop
+profiles:
+  laptop:
+    packages:
+      - name: tlp
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(content), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--profile", "laptop")
+	require.NoError(t, err)
+	assert.Contains(t, output, "htop")
+	assert.Contains(t, output, "tlp")
+}
+
+func TestApply_EnvRefs(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+
+	content := `configs:
+  - path: /etc/app/token
+    mode: "0600"
+    content: "token=${env:SUMMIT_TEST_TOKEN}\n"
+managed-blocks:
+  - path: /etc/app/env
+    content: "TOKEN=${env:SUMMIT_TEST_TOKEN}\n"
+service-options:
+  - service: app
+    options:
+      APP_TOKEN: ${env:SUMMIT_TEST_TOKEN}
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(content), 0644))
+
+	_, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "configs[0].content (/system.yaml:2): environment variable SUMMIT_TEST_TOKEN is not set")
+
+	t.Setenv("SUMMIT_TEST_TOKEN", "tok-123")
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Create file /etc/app/token")
+	assert.Contains(t, output, "Update managed block in /etc/app/env")
+	assert.Contains(t, output, "Set options of service app")
+	assert.NotContains(t, output, "tok-123")
+
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false")
+	require.NoError(t, err)
+	written, err := afero.ReadFile(system.AppFs, "/etc/app/token")
+	require.NoError(t, err)
+	assert.Equal(t, "token=tok-123\n", string(written))
+	written, err = afero.ReadFile(system.AppFs, "/etc/app/env")
+	require.NoError(t, err)
+	assert.Equal(t, "# BEGIN summit\nTOKEN=tok-123\n# END summit\n", string(written))
+	written, err = afero.ReadFile(system.AppFs, "/etc/conf.d/app")
+	require.NoError(t, err)
+	assert.Equal(t, "APP_TOKEN=\"tok-123\"\n", string(written))
+
+	// Once written, the block and options match the values they expand to
+	output, err = executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false")
+	require.NoError(t, err)
+	assert.NotContains(t, output, "managed block")
+	assert.NotContains(t, output, "options of service")
+}
+
+func TestRoles(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	runner.Responses[":apk --print-arch"] = []byte("aarch64\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hostname", []byte("web1\n"), 0644))
+
+	role := `role:
+  name: webserver
+  description: nginx with TLS
+  required-vars: [domain]
+  arches: [x86_64, aarch64]
+packages:
+  - name: nginx
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/roles/web.yaml", []byte(role), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/roles/legacy.yaml", []byte("role:\n  name: legacy\n  arches: [x86]\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/system.yaml", []byte("includes:\n  - roles/web.yaml\n"), 0644))
+
+	output, err := executeCommand(runner, "roles", "list", "--config", "/srv/summit/system.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "legacy (roles/legacy.yaml)\n  arches: x86\nwebserver (roles/web.yaml)\n  nginx with TLS\n  required vars: domain\n  arches: x86_64, aarch64\n", output)
+
+	_, err = executeCommand(runner, "diff", "--config", "/srv/summit/system.yaml", "--json=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "required var 'domain' is not set for host 'web1'")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/system.yaml", []byte("includes:\n  - roles/web.yaml\nhost-vars:\n  web1:\n    domain: example.com\n"), 0644))
+	output, err = executeCommand(runner, "diff", "--config", "/srv/summit/system.yaml", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Install package nginx")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/srv/summit/system.yaml", []byte("includes:\n  - roles/legacy.yaml\n"), 0644))
+	_, err = executeCommand(runner, "diff", "--config", "/srv/summit/system.yaml", "--json=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "architecture 'aarch64' is not supported (supported: x86)")
+}
+
+func TestDiff_JSONIncludesWarnings(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("A  /etc/stray.conf")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/stray.conf", []byte("stray"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/init.d/crond", []byte(""), 0755))
+
+	config := `
+services:
+  - name: crond
+    enabled: true
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	t.Cleanup(func() { jsonWarnings = false })
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json", "--json-warnings")
+	require.NoError(t, err)
+
+	var doc struct {
+		Warnings []struct {
+			Field   string
+			Message string
+		}
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &doc), "output must be pure JSON: %s", output)
+
+	require.Len(t, doc.Warnings, 2)
+	assert.Equal(t, "services[0].runlevel", doc.Warnings[0].Field)
+	assert.Equal(t, "/etc/stray.conf", doc.Warnings[1].Field)
+	assert.Contains(t, doc.Warnings[1].Message, "unmanaged file found /etc/stray.conf")
+}
+
+func TestValidate_ReportsWarnings(t *testing.T) {
+	runner := setupTest(t)
+
+	config := `
+services:
+  - name: crond
+    enabled: true
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "validate", "--config", "/system.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, output, "warning: services[0].runlevel: service 'crond' is enabled but has no runlevel")
+	assert.Contains(t, output, "Configuration is valid (1 warning(s)).")
+	assert.Empty(t, runner.Commands, "validate without --system must not inspect the system")
+}
+
+func TestApply_InteractivePrune(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("A etc/old.conf\nA etc/keep.conf\nA etc/local.conf\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/old.conf", []byte("old"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/keep.conf", []byte("keep"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/local.conf", []byte("local"), 0600))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("# my host\npackages: []\n"), 0644))
+
+	rootCmd.SetIn(strings.NewReader("i\na\nd\n"))
+	t.Cleanup(func() {
+		rootCmd.SetIn(nil)
+		interactivePruning = false
+	})
+
+	output, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--interactive-prune")
+	require.NoError(t, err)
+	assert.Contains(t, output, "/etc/old.conf (owner :, 3 bytes, modified")
+	assert.Contains(t, output, "Updated /system.yaml: 1 ignored, 1 adopted")
+
+	exists, err := afero.Exists(system.AppFs, "/etc/old.conf")
+	require.NoError(t, err)
+	assert.False(t, exists)
+	exists, err = afero.Exists(system.AppFs, "/etc/keep.conf")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	updated, err := afero.ReadFile(system.AppFs, "/system.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(updated), "# my host")
+	assert.Contains(t, string(updated), "ignored-configs:\n    - /etc/keep.conf")
+	assert.Contains(t, string(updated), "path: /etc/local.conf")
+	assert.Contains(t, string(updated), "content: local")
+	assert.Contains(t, string(updated), `mode: "0600"`)
+}
+
+func TestAdopt_Modified(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("U etc/nginx/nginx.conf\nU etc/ssh/sshd_config\nU etc/hosts\nA etc/local.conf\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/nginx/nginx.conf", []byte("worker_processes 4;"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/ssh/sshd_config", []byte("PermitRootLogin no"), 0600))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hosts", []byte("127.0.0.1 localhost"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/local.conf", []byte("local"), 0644))
+	t.Cleanup(func() { adoptModified = false })
+
+	config := `ignored-configs:
+  - /etc/hosts
+configs:
+  - path: /etc/nginx/nginx.conf
+    content: "worker_processes 2;"
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "adopt", "--config", "/system.yaml", "--modified")
+	require.NoError(t, err)
+	assert.Contains(t, output, "adopted /etc/ssh/sshd_config\nUpdated /system.yaml: 1 adopted")
+
+	updated, err := afero.ReadFile(system.AppFs, "/system.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(updated), "path: /etc/ssh/sshd_config")
+	assert.Contains(t, string(updated), "content: PermitRootLogin no")
+	assert.NotContains(t, string(updated), "path: /etc/local.conf")
+	assert.NotContains(t, string(updated), "127.0.0.1")
+}
+
+func TestAdopt_SkipsContentOverLimits(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("U etc/big.conf\nU etc/blob.db\nU etc/small.conf\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/big.conf", []byte(strings.Repeat("x", 2048)), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/blob.db", []byte("SQLite\x00\x01"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/small.conf", []byte("ok"), 0644))
+	t.Cleanup(func() { adoptModified = false })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("limits:\n  max-file-size: 1K\n"), 0644))
+
+	output, err := executeCommand(runner, "adopt", "--config", "/system.yaml", "--modified")
+	require.NoError(t, err)
+	assert.Contains(t, output, "skipped /etc/big.conf: content is 2K, over the 1K limit (limits.max-file-size)")
+	assert.Contains(t, output, "skipped /etc/blob.db: content looks binary")
+	assert.Contains(t, output, "adopted /etc/small.conf\nUpdated /system.yaml: 1 adopted")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("limits:\n  max-total-size: 1K\n  allow-binary: true\n"), 0644))
+	_, err = executeCommand(runner, "adopt", "--config", "/system.yaml", "--modified")
+	assert.ErrorContains(t, err, "refusing to adopt 3 file(s): inlined content totals 2.0K, over the 1K limit")
+}
+
+func TestExecutePlan_ReportsStatuses(t *testing.T) {
+	runner := setupTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\n"), 0644))
+	runner.Errors[":apk add curl"] = errors.New("no such package")
+
+	out := new(bytes.Buffer)
+	rootCmd.SetOut(out)
+	buf := new(bytes.Buffer)
+	logger := log.NewSlogLogger(slog.LevelInfo, buf)
+
+	plan := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "htop"},
+		&actions.PackageInstallAction{PackageName: "vim"},
+	}
+	completed, err := executePlan(rootCmd, plan, &model.SystemState{}, runner, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []actions.Action{plan[1]}, completed)
+	assert.Equal(t, []string{":apk add vim"}, runner.Commands)
+	assert.Contains(t, buf.String(), "ok: Install package htop")
+	assert.Contains(t, buf.String(), "changed: Install package vim")
+	assert.Equal(t, "Recap: ok=1 changed=1 failed=0 skipped=0\n", out.String())
+
+	out.Reset()
+	plan = []actions.Action{
+		&actions.PackageInstallAction{PackageName: "vim"},
+		&actions.PackageInstallAction{PackageName: "curl"},
+		&actions.PackageInstallAction{PackageName: "git"},
+	}
+	_, err = executePlan(rootCmd, plan, &model.SystemState{}, runner, logger)
+	require.Error(t, err)
+	assert.Equal(t, "Recap: ok=0 changed=1 failed=1 skipped=1\n", out.String())
+}
+
+func TestExecutePlan_RollbackScope(t *testing.T) {
+	runner := setupTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/nginx/nginx.conf", []byte("old\n"), 0644))
+	runner.Errors[":rc-service --ifstarted nginx restart"] = errors.New("bad config")
+	t.Cleanup(func() { applyRollbackScope = "all" })
+	rootCmd.SetOut(new(bytes.Buffer))
+	logger := log.NewSlogLogger(slog.LevelInfo, new(bytes.Buffer))
+
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{{Path: "/etc/nginx/nginx.conf", Content: "new\n", Notify: []string{"nginx"}}},
+	}
+	plan := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "htop"},
+		&actions.FileUpdateAction{Path: "/etc/nginx/nginx.conf", NewContent: "new\n"},
+		&actions.ServiceRestartAction{ServiceName: "nginx", Files: []string{"/etc/nginx/nginx.conf"}},
+	}
+	applyRollbackScope = "group"
+	_, err := executePlan(rootCmd, plan, desired, runner, logger)
+	require.Error(t, err)
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/nginx/nginx.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "old\n", string(content), "the nginx config is rolled back")
+	assert.NotContains(t, runner.Commands, ":apk del htop", "the unrelated package install is kept")
+
+	applyRollbackScope = "bogus"
+	_, err = executePlan(rootCmd, plan, desired, runner, logger)
+	assert.ErrorContains(t, err, "invalid --rollback-scope value: bogus")
+}
+
+func TestWatch_AlertsOnPersistentDrift(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { watchAlertAfter, watchNotify, watchCount, watchInterval = 1, "", 0, 5*time.Minute })
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages:\n  - name: htop\n"), 0644))
+
+	output, err := executeCommand(runner, "watch", "--config", "/system.yaml", "--count", "3", "--interval", "0s", "--alert-after", "2", "--notify", "notify-me")
+	require.NoError(t, err)
+	assert.Contains(t, output, "package.install (1): htop")
+
+	var notifications []string
+	for _, c := range runner.Commands {
+		if strings.HasSuffix(c, "| notify-me") {
+			notifications = append(notifications, c)
+		}
+	}
+	require.Len(t, notifications, 1, "the hook runs once per drift episode")
+	assert.Contains(t, notifications[0], "1 change(s):")
+}
+
+func TestDriftTracker(t *testing.T) {
+	tracker := &driftTracker{alertAfter: 3}
+
+	var alerts []bool
+	for _, drifted := range []bool{true, true, false, true, true, true, true} {
+		alerts = append(alerts, tracker.observe(drifted))
+	}
+
+	assert.Equal(t, []bool{false, false, false, false, false, true, false}, alerts)
+}
+
+func TestApply_RespectsApplyWindows(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	// 2024-06-03 is a Monday
+	now = func() time.Time { return time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now; applyForce = false })
+
+	config := `
+apply-windows:
+  - "* 2-4 * * 6"
+packages:
+  - name: htop
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--json=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "outside of the configured apply windows (* 2-4 * * 6); use --force to apply anyway")
+	assert.NotContains(t, runner.Commands, ":apk add htop")
+
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=true", "--json=false")
+	require.NoError(t, err, "dry runs are allowed outside the window")
+
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--force")
+	require.NoError(t, err)
+	assert.Contains(t, runner.Commands, ":apk add htop")
+}
+
+func TestApply_RefusesDisruptiveChanges(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { applyDisruptive = false })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("openssh\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages:\n  - name: htop\n"), 0644))
+
+	output, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=true", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Remove package openssh: removes the SSH server; requires --allow-disruptive")
+
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refusing to apply 1 change(s) that could cut off remote access")
+	assert.NotContains(t, runner.Commands, ":apk add htop")
+
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--allow-disruptive")
+	require.NoError(t, err)
+	assert.Contains(t, runner.Commands, ":apk del openssh")
+}
+
+func TestApply_Offline(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { applyOffline, applyIndexUpdate = false, "" })
+	config := `
+offline-repository: /srv/apk
+packages:
+  - name: htop
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+	simulate := ":apk add --no-network --repositories-file /dev/null --repository /srv/apk --simulate htop"
+
+	runner.Errors[simulate] = errors.New("exit status 1")
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--offline")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "packages htop are not all available in /srv/apk")
+	assert.NotContains(t, runner.Commands, ":apk add --no-network --repositories-file /dev/null --repository /srv/apk htop")
+
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--offline", "--package-index-update", "always")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "updating the package index needs the network")
+
+	delete(runner.Errors, simulate)
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--offline", "--package-index-update", "never")
+	require.NoError(t, err)
+	assert.Contains(t, runner.Commands, ":apk add --no-network --repositories-file /dev/null --repository /srv/apk htop")
+	assert.NotContains(t, runner.Commands, ":apk update")
+}
+
+func TestApply_Hooks(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	config := `
+hooks:
+  pre-apply: [rc-service app stop, monitoring-silence 30m]
+  post-apply: [rc-service app start]
+  on-failure: [notify-oncall]
+packages:
+  - name: htop
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	// A failing pre-apply hook stops the others and nothing is applied
+	runner.Errors[":monitoring-silence 30m"] = errors.New("exit status 1")
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `pre-apply hook "monitoring-silence 30m" failed`)
+	assert.NotContains(t, runner.Commands, ":apk add htop")
+	assert.NotContains(t, runner.Commands, ":rc-service app start")
+	assert.Contains(t, runner.Commands, ":notify-oncall")
+
+	delete(runner.Errors, ":monitoring-silence 30m")
+	runner.Commands = nil
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false")
+	require.NoError(t, err)
+	var order []string
+	for _, c := range runner.Commands {
+		switch c {
+		case ":rc-service app stop", ":monitoring-silence 30m", ":apk add htop", ":rc-service app start", ":notify-oncall":
+			order = append(order, c)
+		}
+	}
+	assert.Equal(t, []string{":rc-service app stop", ":monitoring-silence 30m", ":apk add htop", ":rc-service app start"}, order)
+}
+
+func TestWatch_DefersApplyOutsideWindow(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	now = func() time.Time { return time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC) }
+	t.Cleanup(func() { now = time.Now; watchApply, watchCount, watchInterval = false, 0, 5*time.Minute })
+
+	config := `
+apply-windows:
+  - "* 2-4 * * 6"
+packages:
+  - name: htop
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "watch", "--config", "/system.yaml", "--count", "1", "--interval", "0s", "--apply")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Apply deferred until the apply window opens")
+	assert.NotContains(t, runner.Commands, ":apk add htop")
+
+	now = func() time.Time { return time.Date(2024, time.June, 1, 3, 0, 0, 0, time.UTC) }
+	_, err = executeCommand(runner, "watch", "--config", "/system.yaml", "--count", "1", "--interval", "0s", "--apply")
+	require.NoError(t, err)
+	assert.Contains(t, runner.Commands, ":apk add htop")
+}
+
+func TestDiff_TeamLabels(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { diffTeam = ""; jsonOutput = false })
+
+	config := `
+team: web
+packages:
+  - name: nginx
+  - name: htop
+    team: platform
+`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	output, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--team", "web")
+	require.NoError(t, err)
+	assert.Contains(t, output, "=> Install package nginx [team: web]")
+	assert.NotContains(t, output, "htop")
+
+	output, err = executeCommand(runner, "diff", "--config", "/system.yaml", "--json", "--team", "")
+	require.NoError(t, err)
+	var plan []struct {
+		Description string
+		Team        string
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &plan), output)
+	teams := map[string]string{}
+	for _, a := range plan {
+		teams[a.Description] = a.Team
+	}
+	assert.Equal(t, map[string]string{"Install package nginx": "web", "Install package htop": "platform"}, teams)
+}
+
+func TestInstallAgent(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { agentInterval, agentApply, agentDryRun = 5*time.Minute, false, false })
+
+	_, err := executeCommand(runner, "install-agent", "--config", "/etc/summit/system.yaml", "--interval", "10m", "--dry-run=false")
+	require.NoError(t, err)
+
+	script, err := afero.ReadFile(system.AppFs, agentInitScript)
+	require.NoError(t, err)
+	assert.Contains(t, string(script), "#!/sbin/openrc-run")
+	assert.Contains(t, string(script), "watch --interval ${SUMMIT_INTERVAL}")
+	info, err := system.AppFs.Stat(agentInitScript)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	conf, err := afero.ReadFile(system.AppFs, agentConfFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(conf), "SUMMIT_CONFIG='/etc/summit/system.yaml'")
+	assert.Contains(t, string(conf), "SUMMIT_INTERVAL=10m0s")
+	assert.Contains(t, runner.Commands, ":rc-update add summit default")
+	assert.NotContains(t, runner.Commands, ":rc-service --ifstarted summit restart")
+
+	// Once enabled, rerunning with the same settings changes nothing
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/runlevels/default/summit", nil, 0644))
+	runner.Commands = nil
+	_, err = executeCommand(runner, "install-agent", "--config", "/etc/summit/system.yaml", "--interval", "10m")
+	require.NoError(t, err)
+	assert.Empty(t, runner.Commands)
+
+	// Changed settings are written and the running agent restarted
+	_, err = executeCommand(runner, "install-agent", "--config", "/etc/summit/system.yaml", "--interval", "10m", "--apply")
+	require.NoError(t, err)
+	conf, err = afero.ReadFile(system.AppFs, agentConfFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(conf), "SUMMIT_WATCH_ARGS='--alert-after 1 --apply'")
+	assert.Equal(t, []string{":rc-service --ifstarted summit restart"}, runner.Commands)
+}
+
+func TestFirstbootInstall(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { firstbootDisruptive, firstbootDryRun = false, false })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/summit/system.yaml", []byte("packages: []\n"), 0644))
+
+	_, err := executeCommand(runner, "firstboot", "install", "--config", "/etc/summit/system.yaml", "--allow-disruptive", "--", "--package-index-update", "always", "--metadata-url=http://config.local/it's")
+	require.NoError(t, err)
+
+	script, err := afero.ReadFile(system.AppFs, firstbootInitScript)
+	require.NoError(t, err)
+	assert.Contains(t, string(script), "#!/sbin/openrc-run")
+	assert.Contains(t, string(script), `rc-update del "${RC_SVCNAME}" "${SUMMIT_RUNLEVEL}"`)
+	conf, err := afero.ReadFile(system.AppFs, firstbootConfFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(conf), "SUMMIT_CONFIG='/etc/summit/system.yaml'")
+	assert.Contains(t, string(conf), "SUMMIT_LOG_LEVEL='info'")
+	// The init script gets the arguments back as they were given
+	out, err := exec.Command("sh", "-c", string(conf)+`eval "set -- ${SUMMIT_APPLY_ARGS}"; printf '%s\n' "$@"`).Output()
+	require.NoError(t, err)
+	assert.Equal(t, "--allow-disruptive\n--package-index-update\nalways\n--metadata-url=http://config.local/it's\n", string(out))
+
+	// Enabled for the next boot, not started in the image being built
+	assert.Contains(t, runner.Commands, ":rc-update add summit-firstboot default")
+	assert.NotContains(t, runner.Commands, ":rc-service summit-firstboot start")
+	assert.True(t, actions.StartServices)
+
+	// A config that does not load fails the install, unless it is fetched on
+	// the first boot
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/summit/system.yaml", []byte("packages: [\n"), 0644))
+	_, err = executeCommand(runner, "firstboot", "install", "--config", "/etc/summit/system.yaml", "--allow-disruptive=false")
+	assert.Error(t, err)
+	_, err = executeCommand(runner, "firstboot", "install", "--config", "/etc/summit/system.yaml", "--", "--from-metadata")
+	assert.NoError(t, err)
+
+	_, err = executeCommand(runner, "firstboot", "install", "--config", "/etc/summit/system.yaml", "--", "--form-metadata")
+	assert.EqualError(t, err, "unknown apply flag --form-metadata")
+}
+
+func TestApply_FromMetadata(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { applyFromMetadata, metadataURL, allowedSigners, dryRun = false, "", "", false })
+	backoff := metadataBackoff
+	metadataBackoff = time.Millisecond
+	t.Cleanup(func() { metadataBackoff = backoff })
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config":
+			// The endpoint is not ready on the first attempt
+			if requests++; requests == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("packages:\n  - name: htop\n"))
+		case "/config.sig":
+			w.Write([]byte("signature"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	// Unsigned configs are refused unless verification is explicitly skipped
+	_, err := executeCommand(runner, "apply", "--from-metadata", "--metadata-url", server.URL+"/config", "--dry-run", "--json=false")
+	require.ErrorContains(t, err, "refusing to apply an unsigned config from metadata")
+
+	runner.Responses[":ssh-keygen -Y find-principals -f '/etc/summit/allowed_signers' -s '/run/summit/metadata/system.yaml.sig'"] = []byte("ops@example.com\n")
+	output, err := executeCommand(runner, "apply", "--from-metadata", "--metadata-url", server.URL+"/config", "--allowed-signers", "/etc/summit/allowed_signers", "--dry-run", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Install package htop")
+	assert.Equal(t, 2, requests)
+	assert.Contains(t, runner.Commands, ":ssh-keygen -Y verify -f '/etc/summit/allowed_signers' -I 'ops@example.com' -n summit -s '/run/summit/metadata/system.yaml.sig'")
+
+	// A config whose signature is not trusted is not applied
+	runner.Errors[":ssh-keygen -Y find-principals -f '/etc/summit/allowed_signers' -s '/run/summit/metadata/system.yaml.sig'"] = errors.New("exit status 255")
+	_, err = executeCommand(runner, "apply", "--from-metadata", "--metadata-url", server.URL+"/config", "--allowed-signers", "/etc/summit/allowed_signers", "--dry-run", "--json=false")
+	require.ErrorContains(t, err, "is not signed by an allowed signer")
+}
+
+func TestMailTo_OnlyWhenChanged(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	t.Cleanup(func() { applyMailTo, diffMailTo = "", "" })
+
+	mails := func() []string {
+		var sent []string
+		for _, c := range runner.Commands {
+			if strings.HasSuffix(c, "| sendmail -t") {
+				sent = append(sent, c)
+			}
+		}
+		return sent
+	}
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages: []\n"), 0644))
+	_, err := executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--mail-to", "ops@example.com")
+	require.NoError(t, err)
+	assert.Empty(t, mails(), "no mail without changes")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages:\n  - name: htop\n"), 0644))
+	_, err = executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--json=false", "--mail-to", "ops@example.com")
+	require.NoError(t, err)
+	require.Len(t, mails(), 1)
+	assert.Contains(t, mails()[0], "To: ops@example.com")
+	assert.Contains(t, mails()[0], "1 change(s) applied")
+	assert.Contains(t, mails()[0], "package.install (1): htop")
+
+	runner.Errors[":sh -c 'cat /etc/group'"] = errors.New("permission denied")
+	_, err = executeCommand(runner, "diff", "--config", "/system.yaml", "--json=false", "--mail-to", "ops@example.com")
+	require.Error(t, err)
+	require.Len(t, mails(), 2)
+	assert.Contains(t, mails()[1], "failed")
+	assert.Contains(t, mails()[1], "permission denied")
+}
+
+func TestPlanDiff(t *testing.T) {
+	runner := setupTest(t)
+
+	oldPlan := `{"actions": [
+  {"type": "package.install", "description": "Install package htop", "details": ["run: apk add htop"]},
+  {"type": "file.update", "description": "Update file /etc/motd", "details": ["-old", "+new"]},
+  {"type": "service.enable", "description": "Enable and start service sshd in runlevel default", "details": []}
+], "warnings": []}`
+	newPlan := `{"actions": [
+  {"type": "package.install", "description": "Install package htop", "details": ["run: apk add htop"]},
+  {"type": "file.update", "description": "Update file /etc/motd", "details": ["-old", "+newer"]},
+  {"type": "package.install", "description": "Install package vim", "details": ["run: apk add vim"]}
+], "warnings": []}`
+	require.NoError(t, afero.WriteFile(system.AppFs, "/old.json", []byte(oldPlan), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/new.json", []byte(newPlan), 0644))
+
+	output, err := executeCommand(runner, "plan-diff", "/old.json", "/new.json", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "+ package.install: Install package vim\n")
+	assert.Contains(t, output, "- service.enable: Enable and start service sshd in runlevel default\n")
+	assert.Contains(t, output, "~ file.update: Update file /etc/motd\n")
+	assert.Contains(t, output, "Added: 1, removed: 1, changed: 1")
+
+	output, err = executeCommand(runner, "plan-diff", "/old.json", "/new.json", "--json=true")
+	require.NoError(t, err)
+	var comparison planComparisonForJSON
+	require.NoError(t, json.Unmarshal([]byte(output), &comparison))
+	require.Len(t, comparison.Changed, 1)
+	assert.Equal(t, []string{"-old", "+newer"}, comparison.Changed[0].New.Details)
+
+	output, err = executeCommand(runner, "plan-diff", "/old.json", "/old.json", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "The plans are identical.")
+
+	// Plans saved without --json-warnings are bare arrays of actions
+	require.NoError(t, afero.WriteFile(system.AppFs, "/array.json", []byte(`[
+  {"type": "package.install", "description": "Install package htop", "details": ["run: apk add htop"]}
+]`), 0644))
+	output, err = executeCommand(runner, "plan-diff", "/array.json", "/new.json", "--json=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Added: 2, removed: 0, changed: 0")
+}
+
+func TestBake(t *testing.T) {
+	runner := setupTest(t)
+	now = func() time.Time { return time.Unix(0, 42) }
+	t.Cleanup(func() { now = time.Now; bakeBase, bakeTag = "", "" })
+	runner.Responses[":docker image inspect --format '{{json .Config.Entrypoint}}' 'alpine:3.20'"] = []byte("null\n")
+	runner.Responses[":docker image inspect --format '{{json .Config.Cmd}}' 'alpine:3.20'"] = []byte("[\"/bin/sh\"]\n")
+
+	output, err := executeCommand(runner, "bake", "--config", "/srv/cfg/system.yaml", "--base", "alpine:3.20", "-t", "web:1", "--dry-run=false")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Built image web:1 from alpine:3.20")
+
+	require.GreaterOrEqual(t, len(runner.Commands), 5)
+	assert.Equal(t, ":docker run -d --name summit-bake-42 --entrypoint sleep 'alpine:3.20' infinity", runner.Commands[0])
+	assert.Contains(t, runner.Commands, ":docker cp '/srv/cfg' summit-bake-42:/tmp/summit-bake/config")
+	assert.Contains(t, runner.Commands, ":docker exec summit-bake-42 /tmp/summit-bake/summit apply --config '/tmp/summit-bake/config/system.yaml' --no-start")
+	assert.Contains(t, runner.Commands, `:docker commit --change 'ENTRYPOINT []' --change 'CMD ["/bin/sh"]' summit-bake-42 'web:1'`)
+	assert.Equal(t, ":docker rm -f summit-bake-42", runner.Commands[len(runner.Commands)-1], "the build container is always removed")
+}
+
+func TestServe_API(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	cmdRunner = runner
+	previousConfig := cfgFile
+	cfgFile = "/system.yaml"
+	t.Cleanup(func() { cfgFile = previousConfig })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages:\n  - name: htop\n"), 0644))
+
+	logger := log.NewSlogLogger(slog.LevelInfo, new(bytes.Buffer))
+	handler := newAPIServer(rootCmd, "s3cret", logger).handler()
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/plan", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("GET", "/v1/plan", "wrong").Code)
+
+	rec := request("GET", "/v1/plan", "s3cret")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var doc planDocumentForJSON
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &doc))
+	require.Len(t, doc.Actions, 1)
+	assert.Equal(t, "Install package htop", doc.Actions[0].Description)
+	assert.NotContains(t, runner.Commands, ":apk add htop", "planning does not change the system")
+
+	rec = request("POST", "/v1/apply", "s3cret")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var result applyResultForJSON
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []resultForJSON{{Type: "package.install", Description: "Install package htop", Status: "changed"}}, result.Results)
+	assert.Contains(t, runner.Commands, ":apk add htop")
+
+	rec = request("GET", "/v1/status", "s3cret")
+	var status apiStatusForJSON
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	assert.False(t, status.Busy)
+	require.NotNil(t, status.LastApply)
+	assert.Len(t, status.LastApply.Results, 1)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, request("GET", "/v1/apply", "s3cret").Code)
+
+	// Applies follow the failure policy and run the hooks like apply
+	applyOnFailure = "stop"
+	t.Cleanup(func() { applyOnFailure = "rollback" })
+	runner.Errors[":apk add curl"] = errors.New("exit status 1")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("hooks:\n  on-failure: [notify-oncall]\npackages:\n  - name: htop\n  - name: curl\n"), 0644))
+	runner.Commands = nil
+	rec = request("POST", "/v1/apply", "s3cret")
+	require.Equal(t, http.StatusInternalServerError, rec.Code, rec.Body.String())
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.False(t, result.RolledBack)
+	assert.NotContains(t, runner.Commands, ":apk del htop")
+	assert.Contains(t, runner.Commands, ":notify-oncall")
+}
+
+func TestReadAPIToken(t *testing.T) {
+	setupTest(t)
+	t.Setenv(apiTokenEnv, "")
+	_, err := readAPIToken("")
+	assert.ErrorContains(t, err, "an API token is required")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/summit/token", []byte("abc\n"), 0600))
+	token, err := readAPIToken("/etc/summit/token")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", token)
+}
+
+func TestExplain(t *testing.T) {
+	runner := setupTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/common.yaml", []byte("configs:\n  - path: /etc/motd\n    content: common\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/system.yaml", []byte("includes:\n  - common.yaml\nconfigs:\n  - path: /etc/motd\n    content: host\npackages:\n  - name: htop\n"), 0644))
+
+	output, err := executeCommand(runner, "explain", "--config", "/cfg/system.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, output, "config /etc/motd  system.yaml:4\n    overrides common.yaml:2\npackage htop  system.yaml:7\n")
+
+	output, err = executeCommand(runner, "explain", "--config", "/cfg/system.yaml", "htop")
+	require.NoError(t, err)
+	assert.Contains(t, output, "package htop  system.yaml:7\n")
+	assert.NotContains(t, output, "config /etc/motd  ")
+}
+
+func TestCommitEtcHistory(t *testing.T) {
+	logger := log.NewSlogLogger(slog.LevelInfo, new(bytes.Buffer))
+	applied := []actions.Action{&actions.FileCreateAction{Path: "/etc/motd"}}
+
+	// Git: the repository is created on first use and the generation follows
+	// the highest existing tag
+	runner := setupTest(t)
+	runner.Errors[":git -C /etc rev-parse --git-dir"] = errors.New("exit status 128")
+	runner.Responses[":git -C /etc status --porcelain"] = []byte("?? motd\n")
+	runner.Responses[":git -C /etc tag --list 'summit-gen-*'"] = []byte("summit-gen-2\nsummit-gen-10\nrelease\n")
+	commitEtcHistory(model.EtcHistoryGit, applied, runner, logger)
+	assert.Equal(t, []string{
+		":git -C /etc rev-parse --git-dir",
+		":git init -q /etc && chmod 700 /etc/.git",
+		":git -C /etc status --porcelain",
+		":git -C /etc tag --list 'summit-gen-*'",
+		":git -C /etc add -A && git -C /etc -c user.name=summit -c user.email=summit@localhost commit -q -m 'summit generation 11\n\n- Create file /etc/motd\n'",
+		":git -C /etc tag summit-gen-11",
+	}, runner.Commands)
+
+	// Etckeeper commits through its own command
+	runner = setupTest(t)
+	runner.Responses[":git -C /etc status --porcelain"] = []byte(" M motd\n")
+	commitEtcHistory(model.EtcHistoryEtckeeper, applied, runner, logger)
+	assert.Contains(t, runner.Commands, ":etckeeper commit 'summit generation 1\n\n- Create file /etc/motd\n'")
+	assert.Contains(t, runner.Commands, ":git -C /etc tag summit-gen-1")
+
+	// Nothing to commit when /etc is unchanged, or when nothing was applied
+	runner = setupTest(t)
+	commitEtcHistory(model.EtcHistoryGit, applied, runner, logger)
+	assert.Equal(t, []string{":git -C /etc rev-parse --git-dir", ":git -C /etc status --porcelain"}, runner.Commands)
+	runner = setupTest(t)
+	commitEtcHistory(model.EtcHistoryGit, nil, runner, logger)
+	assert.Empty(t, runner.Commands)
+}
+
+func TestCoverage(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { jsonOutput, coverageListUnmanaged = false, false })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\ncurl\nvim\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages:\n  - name: htop\nignored-packages:\n  - vim\n"), 0644))
+
+	output, err := executeCommand(runner, "coverage", "--config", "/system.yaml", "--unmanaged")
+	require.NoError(t, err)
+	assert.Contains(t, output, "  packages  1 of 3 managed (33.3%), 1 ignored, 1 unmanaged\n    unmanaged: curl\n")
+	assert.Contains(t, output, "  users     0 of 0 managed (100.0%), 0 ignored, 0 unmanaged\n")
+
+	output, err = executeCommand(runner, "coverage", "--config", "/system.yaml", "--json")
+	require.NoError(t, err)
+	var doc coverageForJSON
+	require.NoError(t, json.Unmarshal([]byte(output[strings.Index(output, "{"):]), &doc))
+	require.Len(t, doc.Coverage, 4)
+	assert.Equal(t, "packages", doc.Coverage[0].Kind)
+	assert.Equal(t, []string{"curl"}, doc.Coverage[0].Unmanaged)
+	assert.InDelta(t, 33.3, doc.Coverage[0].Percent, 0.1)
+}
+
+func TestApply_CanaryRollsBackUnlessConfirmed(t *testing.T) {
+	runner := setupTest(t)
+	runner.Responses[":apk audit"] = []byte("")
+	previousInterval := canaryPollInterval
+	canaryPollInterval = time.Millisecond
+	t.Cleanup(func() { applyCanary, canaryPollInterval = 0, previousInterval })
+	config := "hooks:\n  post-apply: [monitoring-unsilence]\n  on-failure: [notify-oncall]\npackages:\n  - name: htop\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte(config), 0644))
+
+	_, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=true", "--canary", "10m")
+	assert.ErrorContains(t, err, "--canary cannot be combined with --dry-run")
+
+	output, err := executeCommand(runner, "apply", "--config", "/system.yaml", "--dry-run=false", "--canary", "20ms")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "canary apply not confirmed within 20ms; 1 change(s) rolled back")
+	assert.Contains(t, output, "Canary: run 'summit confirm' within 20ms to keep the changes")
+	assert.Contains(t, runner.Commands, ":apk add htop")
+	assert.Contains(t, runner.Commands, ":apk del htop")
+	// The hooks see the canary's outcome: it failed once rolled back
+	assert.NotContains(t, runner.Commands, ":monitoring-unsilence")
+	assert.Equal(t, ":notify-oncall", runner.Commands[len(runner.Commands)-1])
+	exists, _ := afero.Exists(system.AppFs, canaryPath)
+	assert.False(t, exists, "the canary file is removed once rolled back")
+}
+
+func TestConfirm(t *testing.T) {
+	runner := setupTest(t)
+	previousInterval := canaryPollInterval
+	canaryPollInterval = time.Millisecond
+	t.Cleanup(func() { canaryPollInterval = previousInterval })
+
+	_, err := executeCommand(runner, "confirm")
+	assert.ErrorContains(t, err, "no canary apply is waiting for confirmation")
+
+	logger := log.NewSlogLogger(slog.LevelInfo, new(bytes.Buffer))
+	require.NoError(t, startCanary(3, time.Minute))
+	assert.ErrorContains(t, checkNoCanary(logger), "a canary apply is already waiting for confirmation")
+	confirmed := make(chan bool)
+	go func() { confirmed <- waitForConfirmation(time.Minute) }()
+
+	output, err := executeCommand(runner, "confirm")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Confirmed the canary apply of 3 change(s)")
+	assert.True(t, <-confirmed)
+	assert.NoError(t, checkNoCanary(logger))
+}
+
+func TestConfirm_StaleCanary(t *testing.T) {
+	runner := setupTest(t)
+	previousRunning := processRunning
+	t.Cleanup(func() { processRunning, now = previousRunning, time.Now })
+	logs := new(bytes.Buffer)
+	logger := log.NewSlogLogger(slog.LevelInfo, logs)
+
+	// The canary apply crashed
+	processRunning = func(int) bool { return false }
+	require.NoError(t, startCanary(2, time.Minute))
+	require.NoError(t, checkNoCanary(logger))
+	assert.Contains(t, logs.String(), "Removed a stale canary file")
+	exists, _ := afero.Exists(system.AppFs, canaryPath)
+	assert.False(t, exists)
+
+	require.NoError(t, startCanary(2, time.Minute))
+	_, err := executeCommand(runner, "confirm")
+	assert.ErrorContains(t, err, "the canary apply of 2 change(s) is no longer waiting: pid")
+	exists, _ = afero.Exists(system.AppFs, canaryPath)
+	assert.False(t, exists)
+
+	// The canary apply outlived its window
+	processRunning = func(int) bool { return true }
+	require.NoError(t, startCanary(2, time.Minute))
+	now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	require.NoError(t, checkNoCanary(logger))
+	exists, _ = afero.Exists(system.AppFs, canaryPath)
+	assert.False(t, exists)
+}
+
+func TestFmt(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { fmtCheck = false })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages:\n    - name: vim\n    - name: htop\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/base.yaml", []byte("packages:\n  - name: curl\n"), 0644))
+
+	output, err := executeCommand(runner, "fmt", "--check", "/system.yaml", "/base.yaml")
+	assert.EqualError(t, err, "1 of 2 files are not formatted; run summit fmt")
+	assert.Contains(t, output, "/system.yaml\n")
+	data, _ := afero.ReadFile(system.AppFs, "/system.yaml")
+	assert.Equal(t, "packages:\n    - name: vim\n    - name: htop\n", string(data), "--check writes nothing")
+
+	fmtCheck = false
+	output, err = executeCommand(runner, "fmt", "--config", "/system.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "/system.yaml\n", output)
+	data, _ = afero.ReadFile(system.AppFs, "/system.yaml")
+	assert.Equal(t, "packages:\n  - name: htop\n  - name: vim\n", string(data))
+
+	// Encrypted files are left alone: reformatting would break the sops MAC
+	sops := "packages:\n    - name: ENC[AES256_GCM,data:Zm9v,iv:YQ==,tag:Yg==,type:str]\nsops:\n    mac: ENC[AES256_GCM,data:bWFj,iv:YQ==,tag:Yg==,type:str]\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/secrets.sops.yaml", []byte(sops), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/wg.yaml.age", []byte("age-encryption.org/v1\n-> X25519 abc\n"), 0644))
+	output, err = executeCommand(runner, "fmt", "/secrets.sops.yaml", "/wg.yaml.age")
+	require.NoError(t, err)
+	assert.Empty(t, output)
+	data, _ = afero.ReadFile(system.AppFs, "/secrets.sops.yaml")
+	assert.Equal(t, sops, string(data))
+}
+
+func TestInit(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { initConfigs, initForce = nil, false })
+	runner.Responses[":apk audit"] = []byte("U etc/ssh/sshd_config\nA etc/nginx/conf.d/site.conf\nA etc/local.conf\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("nginx\nhtop\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/init.d/nginx", nil, 0755))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/init.d/sshd", nil, 0755))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/runlevels/default/nginx", nil, 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("alice:x:1000:1000:Alice:/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/ssh/sshd_config", []byte("PermitRootLogin no\n"), 0600))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/nginx/conf.d/site.conf", []byte("server {}\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/local.conf", []byte("local\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/system.yaml", []byte("packages: []\n"), 0644))
+
+	_, err := executeCommand(runner, "init", "--config", "/system.yaml")
+	assert.EqualError(t, err, "/system.yaml already exists; use --force to overwrite it")
+
+	output, err := executeCommand(runner, "init", "--config", "/system.yaml", "--force", "--configs", "/etc/nginx/**")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Wrote /system.yaml: 2 packages, 1 services, 1 users, 2 configs")
+
+	cfg, err := config.LoadConfig("/system.yaml", log.NewSlogLogger(slog.LevelError, new(bytes.Buffer)))
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "htop"}, {Name: "nginx"}}, cfg.Packages)
+	require.Len(t, cfg.Services, 1)
+	assert.Equal(t, "nginx", cfg.Services[0].Name)
+	require.Len(t, cfg.Users, 1)
+	assert.Equal(t, "alice", cfg.Users[0].Name)
+	var paths []string
+	for _, c := range cfg.Configs {
+		paths = append(paths, c.Path)
+	}
+	assert.Equal(t, []string{"/etc/nginx/conf.d/site.conf", "/etc/ssh/sshd_config"}, paths)
+}
+
+func TestDump_AsConfig(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { dumpAsConfig, dumpSplit, dumpRaw = false, "", false })
+	runner.Responses[":apk audit"] = []byte("A  /etc/motd\nA  /etc/blob.db")
+	runner.Responses["alice:pipx list --json"] = []byte(`{"venvs": {"black": {"metadata": {"package": "black"}}}}`)
+	runner.Errors["alice:npm list --json"] = errors.New("npm: not found")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("alice:x:1000:1000:Alice:/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("Hello\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/blob.db", []byte("SQLite\x00\x01"), 0644))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--as-config")
+	require.NoError(t, err)
+	assert.NotContains(t, output, "services:", "empty sections are dropped")
+	assert.NotContains(t, output, "path: /etc/blob.db")
+
+	logger := log.NewSlogLogger(slog.LevelError, new(bytes.Buffer))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/dump/system.yaml", []byte(output[strings.Index(output, "version:"):]), 0644))
+	cfg, err := config.LoadConfig("/dump/system.yaml", logger)
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "htop"}}, cfg.Packages)
+	assert.Equal(t, []model.UserPackageState{{User: "alice", Pipx: []string{"black"}}}, cfg.UserPackages)
+	require.Len(t, cfg.Configs, 1)
+	assert.Equal(t, "Hello\n", cfg.Configs[0].Content)
+
+	output, err = executeCommand(runner, "dump", "--json=false", "--split", "/split")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Wrote /split/packages.yaml\n")
+	assert.Contains(t, output, "Wrote /split/system.yaml\n")
+	split, err := config.LoadConfig("/split/system.yaml", logger)
+	require.NoError(t, err)
+	assert.Equal(t, cfg.Packages, split.Packages)
+	assert.Equal(t, cfg.Configs, split.Configs)
+	assert.Equal(t, cfg.UserPackages, split.UserPackages)
+
+	_, err = executeCommand(runner, "dump", "--json=false", "--as-config", "--raw")
+	assert.ErrorContains(t, err, "--as-config cannot be combined")
+}
+
+func TestDump_WithVersions(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { dumpWithVersions, dumpAsConfig, dumpOnly = false, false, nil })
+	runner.Responses[":apk info -v"] = []byte("htop-3.3.0-r0\nnginx-1.24.0-r7\nmusl-1.2.4_git20230717-r4\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\nnginx=1.24.0-r6\n"), 0644))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--only", "packages")
+	require.NoError(t, err)
+	assert.Equal(t, "packages:\n    - name: htop\n    - name: nginx\n      version: 1.24.0-r6\n", output, "only pins are listed by default")
+
+	output, err = executeCommand(runner, "dump", "--json=false", "--as-config", "--with-versions", "--only", "packages")
+	require.NoError(t, err)
+	assert.Contains(t, output, "packages:\n  - name: htop\n    version: 3.3.0-r0\n  - name: nginx\n    version: 1.24.0-r6\n", "a pin wins over the installed version")
+}
+
+func TestDump_Sections(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { dumpOnly, dumpExclude = nil, nil })
+	runner.Responses[":apk audit"] = []byte("A  /etc/motd")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("Hello"), 0644))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--only", "packages,services")
+	require.NoError(t, err)
+	assert.Equal(t, "packages:\n    - name: htop\nservices: []\n", output)
+
+	dumpOnly = nil
+	output, err = executeCommand(runner, "dump", "--json=false", "--exclude", "configs")
+	require.NoError(t, err)
+	assert.Contains(t, output, "- name: htop")
+	assert.NotContains(t, output, "configs:")
+	assert.NotContains(t, output, "/etc/motd")
+
+	_, err = executeCommand(runner, "dump", "--json=false", "--exclude", "config")
+	assert.ErrorContains(t, err, "unknown section 'config' (sections: version, role, team, includes, packages,")
+}
+
+func TestDump_ServiceInSeveralRunlevels(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { dumpOnly = nil })
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/init.d/sshd", nil, 0755))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/runlevels/boot/sshd", nil, 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/runlevels/default/sshd", nil, 0644))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--only", "services")
+	require.NoError(t, err)
+	assert.Equal(t, "services:\n    - name: sshd\n      enabled: true\n      runlevel: boot\n    - name: sshd\n      enabled: true\n      runlevel: default\n", output)
+}
+
+func TestDump_NoContent(t *testing.T) {
+	runner := setupTest(t)
+	t.Cleanup(func() { dumpNoContent = false })
+	runner.Responses[":apk audit"] = []byte("A  /etc/motd")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("Hello"), 0640))
+
+	output, err := executeCommand(runner, "dump", "--json=false", "--no-content")
+	require.NoError(t, err)
+	assert.Contains(t, output, "configs:\n    - path: /etc/motd\n      mode: \"0640\"\n")
+	assert.Contains(t, output, "      origin: user-created\n      sha256: "+model.HashContent("Hello")+"\n")
+	assert.NotContains(t, output, "Hello")
+
+	output, err = executeCommand(runner, "dump", "--json", "--no-content")
+	t.Cleanup(func() { jsonOutput = false })
+	require.NoError(t, err)
+	var doc struct {
+		Packages []model.PackageState
+		Configs  []map[string]any
+	}
+	require.NoError(t, json.Unmarshal([]byte(output), &doc))
+	require.Len(t, doc.Configs, 1)
+	assert.Equal(t, model.HashContent("Hello"), doc.Configs[0]["sha256"])
+	assert.NotContains(t, doc.Configs[0], "Content")
+}
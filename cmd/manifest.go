@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"fmt"
+	"summit/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+// manifestCmd represents the manifest command
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Writes the checksum manifest of the config tree for signing",
+	Long: `The manifest command hashes every file in the directory of system.yaml and
+its subdirectories into summit.manifest. Sign the manifest with one of:
+
+  ssh-keygen -Y sign -f ~/.ssh/id_ed25519 -n summit summit.manifest
+  minisign -Sm summit.manifest
+
+Hosts run with --allowed-signers or --minisign-key only load configuration
+whose files match a manifest carrying a trusted signature.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := config.WriteManifest(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Wrote %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+}
@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"summit/pkg/config"
+	"summit/pkg/log"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// metadataConfigPath is where a config fetched from instance metadata is
+	// stored, with its signature next to it, before it is loaded.
+	metadataConfigPath = "/run/summit/metadata/system.yaml"
+	// metadataURLFile holds a config URL handed out by DHCP, written by a
+	// udhcpc hook. It is used when --metadata-url is not given.
+	metadataURLFile = "/run/summit/metadata-url"
+	// defaultMetadataURL is the user-data endpoint of EC2-style metadata
+	// services (EC2, OpenStack, most VPS providers).
+	defaultMetadataURL = "http://169.254.169.254/latest/user-data"
+)
+
+var (
+	applyFromMetadata bool
+	metadataURL       string
+	metadataTimeout   time.Duration
+	metadataInsecure  bool
+
+	// metadataClient fetches the config. It is a variable so tests can
+	// replace it.
+	metadataClient = &http.Client{Timeout: 10 * time.Second}
+	// metadataBackoff is the delay before the first retry; it doubles on
+	// every failed attempt up to metadataMaxBackoff.
+	metadataBackoff    = time.Second
+	metadataMaxBackoff = 30 * time.Second
+)
+
+// fetchMetadataConfig downloads the host config from the metadata endpoint,
+// retrying until --metadata-timeout as the network or the endpoint may not be
+// up yet at boot, checks its signature and returns the path it was stored at.
+func fetchMetadataConfig(logger log.Logger) (string, error) {
+	if config.Integrity == nil && !metadataInsecure {
+		return "", fmt.Errorf("refusing to apply an unsigned config from metadata; pass --allowed-signers or --minisign-key, or --metadata-insecure to skip verification")
+	}
+	url, err := resolveMetadataURL()
+	if err != nil {
+		return "", err
+	}
+
+	logger.Info("Fetching config from metadata", "url", url)
+	content, err := fetchWithRetry(url, time.Now().Add(metadataTimeout), logger)
+	if err != nil {
+		return "", err
+	}
+	if err := system.AppFs.MkdirAll(filepath.Dir(metadataConfigPath), 0700); err != nil {
+		return "", err
+	}
+	if err := afero.WriteFile(system.AppFs, metadataConfigPath, content, 0600); err != nil {
+		return "", err
+	}
+	if config.Integrity == nil {
+		logger.Warn("Config from metadata is not verified", "url", url)
+		return metadataConfigPath, nil
+	}
+
+	// The endpoint is up once the config is fetched, so a missing signature
+	// is not retried
+	for _, suffix := range []string{".sig", ".minisig"} {
+		sigPath := metadataConfigPath + suffix
+		if err := system.AppFs.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		signature, status, err := fetch(url + suffix)
+		if err != nil || status != http.StatusOK {
+			continue
+		}
+		if err := afero.WriteFile(system.AppFs, sigPath, signature, 0600); err != nil {
+			return "", err
+		}
+	}
+	if err := config.Integrity.VerifyFile(metadataConfigPath, content); err != nil {
+		return "", fmt.Errorf("config from %s: %w", url, err)
+	}
+	return metadataConfigPath, nil
+}
+
+// resolveMetadataURL returns the URL the config is fetched from: the
+// --metadata-url flag, the URL handed out by DHCP, or the EC2-style user-data
+// endpoint.
+func resolveMetadataURL() (string, error) {
+	if metadataURL != "" {
+		return metadataURL, nil
+	}
+	content, err := afero.ReadFile(system.AppFs, metadataURLFile)
+	if err == nil && strings.TrimSpace(string(content)) != "" {
+		return strings.TrimSpace(string(content)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return defaultMetadataURL, nil
+}
+
+// fetchWithRetry gets url, retrying failed requests and non-200 responses
+// with exponential backoff until deadline.
+func fetchWithRetry(url string, deadline time.Time, logger log.Logger) ([]byte, error) {
+	backoff := metadataBackoff
+	for attempt := 1; ; attempt++ {
+		body, status, err := fetch(url)
+		if err == nil && status == http.StatusOK {
+			return body, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("%s returned %s", url, http.StatusText(status))
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("failed to fetch config from metadata after %d attempt(s): %w", attempt, err)
+		}
+		logger.Warn("Failed to fetch config from metadata, retrying", "url", url, "error", err, "retry-in", backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, metadataMaxBackoff)
+	}
+}
+
+func fetch(url string) ([]byte, int, error) {
+	resp, err := metadataClient.Get(url)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return body, resp.StatusCode, err
+}
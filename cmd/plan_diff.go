@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// planChangeForJSON is an action present in both plans whose details differ.
+type planChangeForJSON struct {
+	Old actionForJSON `json:"old"`
+	New actionForJSON `json:"new"`
+}
+
+// planComparisonForJSON is the document emitted by plan-diff --json.
+type planComparisonForJSON struct {
+	Added   []actionForJSON     `json:"added"`
+	Removed []actionForJSON     `json:"removed"`
+	Changed []planChangeForJSON `json:"changed"`
+}
+
+// planDiffCmd represents the plan-diff command
+var planDiffCmd = &cobra.Command{
+	Use:   "plan-diff <old-plan.json> <new-plan.json>",
+	Short: "Compares two plans saved with diff --json",
+	Long: `The plan-diff command reports the actions added, removed and changed between
+two plans saved with "summit diff --json" (or "apply --dry-run --json"), e.g. the
+plan of a host before and after a config change, so reviewers can see how the
+change affects what would be applied.
+
+Actions are matched by type and description; a matched action is changed when
+its details, such as a file diff, or its team differ.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oldPlan, err := readPlanDocument(args[0])
+		if err != nil {
+			return err
+		}
+		newPlan, err := readPlanDocument(args[1])
+		if err != nil {
+			return err
+		}
+
+		comparison := comparePlans(oldPlan.Actions, newPlan.Actions)
+		if jsonOutput {
+			jsonBytes, err := json.MarshalIndent(comparison, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal plan comparison to JSON: %w", err)
+			}
+			fmt.Fprint(cmd.OutOrStdout(), string(jsonBytes))
+			return nil
+		}
+
+		out := cmd.OutOrStdout()
+		if len(comparison.Added)+len(comparison.Removed)+len(comparison.Changed) == 0 {
+			fmt.Fprintln(out, "The plans are identical.")
+			return nil
+		}
+		for _, a := range comparison.Added {
+			fmt.Fprintf(out, "+ %s: %s\n", a.Type, a.Description)
+		}
+		for _, a := range comparison.Removed {
+			fmt.Fprintf(out, "- %s: %s\n", a.Type, a.Description)
+		}
+		for _, c := range comparison.Changed {
+			fmt.Fprintf(out, "~ %s: %s\n", c.New.Type, c.New.Description)
+			if c.Old.Team != c.New.Team {
+				fmt.Fprintf(out, "   team: %q -> %q\n", c.Old.Team, c.New.Team)
+			}
+		}
+		fmt.Fprintf(out, "Added: %d, removed: %d, changed: %d\n", len(comparison.Added), len(comparison.Removed), len(comparison.Changed))
+		return nil
+	},
+}
+
+func readPlanDocument(path string) (*planDocumentForJSON, error) {
+	data, err := afero.ReadFile(system.AppFs, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+	var doc planDocumentForJSON
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		// Saved without --json-warnings: a bare array of actions
+		err = json.Unmarshal(trimmed, &doc.Actions)
+	} else {
+		err = json.Unmarshal(data, &doc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	return &doc, nil
+}
+
+// comparePlans matches the actions of two plans by type and description. When
+// a plan holds the same action several times, occurrences are matched in order.
+// Results keep the order of the plan they come from.
+func comparePlans(oldActions, newActions []actionForJSON) planComparisonForJSON {
+	comparison := planComparisonForJSON{Added: []actionForJSON{}, Removed: []actionForJSON{}, Changed: []planChangeForJSON{}}
+
+	oldByKey := make(map[string]actionForJSON)
+	for i, key := range planActionKeys(oldActions) {
+		oldByKey[key] = oldActions[i]
+	}
+	newKeys := planActionKeys(newActions)
+	matched := make(map[string]bool)
+	for i, key := range newKeys {
+		old, ok := oldByKey[key]
+		if !ok {
+			comparison.Added = append(comparison.Added, newActions[i])
+			continue
+		}
+		matched[key] = true
+		if old.Team != newActions[i].Team || !reflect.DeepEqual(old.Details, newActions[i].Details) {
+			comparison.Changed = append(comparison.Changed, planChangeForJSON{Old: old, New: newActions[i]})
+		}
+	}
+	for i, key := range planActionKeys(oldActions) {
+		if !matched[key] {
+			comparison.Removed = append(comparison.Removed, oldActions[i])
+		}
+	}
+	return comparison
+}
+
+// planActionKeys returns the identity of each action: its type, description
+// and occurrence number among identical actions.
+func planActionKeys(plan []actionForJSON) []string {
+	seen := make(map[string]int)
+	keys := make([]string, len(plan))
+	for i, a := range plan {
+		base := a.Type + "\x00" + a.Description
+		keys[i] = fmt.Sprintf("%s\x00%d", base, seen[base])
+		seen[base]++
+	}
+	return keys
+}
+
+func init() {
+	rootCmd.AddCommand(planDiffCmd)
+	planDiffCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the comparison in JSON format")
+}
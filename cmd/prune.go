@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"summit/pkg/actions"
+	"summit/pkg/config"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/cobra"
+)
+
+// interactivePrune asks the user what to do with every unmanaged file the plan
+// would delete. Files can be deleted, ignored (appended to ignored-configs) or
+// adopted (appended to configs); anything else leaves the file untouched. The
+// returned plan only keeps the deletions that were confirmed.
+func interactivePrune(cmd *cobra.Command, plan []actions.Action, current *model.SystemState, configFile string) ([]actions.Action, error) {
+	currentConfigs := make(map[string]model.SystemConfigState)
+	for _, c := range current.Configs {
+		currentConfigs[c.Path] = c
+	}
+
+	in := bufio.NewReader(cmd.InOrStdin())
+	out := cmd.OutOrStdout()
+
+	var kept []actions.Action
+	var deletions []*actions.FileDeleteAction
+	for _, action := range plan {
+		if deleteAction, ok := action.(*actions.FileDeleteAction); ok {
+			deletions = append(deletions, deleteAction)
+		} else {
+			kept = append(kept, action)
+		}
+	}
+	// Ask in a predictable order regardless of plan order
+	sort.Slice(deletions, func(i, j int) bool {
+		return deletions[i].Path < deletions[j].Path
+	})
+
+	var ignore []string
+	var adopt []model.SystemConfigState
+
+	for _, deleteAction := range deletions {
+		cfg := currentConfigs[deleteAction.Path]
+		fmt.Fprintf(out, "%s (%s)\n", deleteAction.Path, describeUnmanagedFile(cfg))
+		fmt.Fprint(out, "  [d]elete, [i]gnore, [a]dopt, [s]kip? ")
+
+		answer, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("failed to read answer: %w", err)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "d", "delete":
+			kept = append(kept, deleteAction)
+		case "i", "ignore":
+			ignore = append(ignore, deleteAction.Path)
+		case "a", "adopt":
+			content, err := cfg.LoadContent()
+			if err != nil {
+				return nil, err
+			}
+			adopt = append(adopt, model.SystemConfigState{Path: cfg.Path, Content: content, Mode: cfg.Mode, Owner: cfg.Owner, Group: cfg.Group})
+		default:
+			fmt.Fprintln(out, "  skipped")
+		}
+	}
+
+	if err := config.AppendIgnoredConfigs(configFile, ignore); err != nil {
+		return nil, fmt.Errorf("failed to update ignored-configs in %s: %w", configFile, err)
+	}
+	if err := config.AppendConfigs(configFile, adopt); err != nil {
+		return nil, fmt.Errorf("failed to update configs in %s: %w", configFile, err)
+	}
+	if len(ignore) > 0 || len(adopt) > 0 {
+		fmt.Fprintf(out, "Updated %s: %d ignored, %d adopted\n", configFile, len(ignore), len(adopt))
+	}
+
+	return kept, nil
+}
+
+// describeUnmanagedFile summarizes ownership, size and modification time.
+func describeUnmanagedFile(cfg model.SystemConfigState) string {
+	owner := fmt.Sprintf("owner %s:%s", cfg.Owner, cfg.Group)
+	info, err := system.AppFs.Stat(cfg.Path)
+	if err != nil {
+		return owner
+	}
+	return fmt.Sprintf("%s, %d bytes, modified %s", owner, info.Size(), info.ModTime().Format("2006-01-02 15:04"))
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"summit/pkg/actions"
+	"summit/pkg/diff"
+	"summit/pkg/model"
+)
+
+// renderedPlan is what a plan renderer gets to work with.
+type renderedPlan struct {
+	Plan     []actions.Action
+	Desired  *model.SystemState
+	Warnings model.ValidationErrors
+}
+
+// planRenderer writes a plan in one output format of diff.
+type planRenderer interface {
+	Render(w io.Writer, p renderedPlan) error
+}
+
+// planRenderers are the formats diff --format accepts, in the order they are
+// listed in errors.
+var planRenderers = []struct {
+	name     string
+	renderer planRenderer
+}{
+	{"text", textRenderer{}},
+	{"json", jsonRenderer{}},
+	{"golden", goldenRenderer{}},
+	{"summary", summaryRenderer{}},
+	{"markdown", markdownRenderer{}},
+	{"github", githubRenderer{}},
+}
+
+// lookupRenderer returns the renderer of a format.
+func lookupRenderer(format string) (planRenderer, error) {
+	names := make([]string, len(planRenderers))
+	for i, r := range planRenderers {
+		if r.name == format {
+			return r.renderer, nil
+		}
+		names[i] = r.name
+	}
+	return nil, fmt.Errorf("invalid format: %s (must be %s or %s)", format, strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
+
+// textRenderer prints each action with its execution details.
+type textRenderer struct{}
+
+func (textRenderer) Render(w io.Writer, p renderedPlan) error {
+	fmt.Fprintln(w, "The following operations will be performed:")
+	for _, action := range p.Plan {
+		fmt.Fprintf(w, "=> %s\n", describeAction(action, p.Desired)) // Keep the high-level description
+		for _, detail := range action.ExecutionDetails() {
+			fmt.Fprintf(w, "   - %s\n", detail) // Print the detailed steps
+		}
+	}
+	return nil
+}
+
+// jsonRenderer prints the plan document shared with apply --dry-run --json.
+type jsonRenderer struct{}
+
+func (jsonRenderer) Render(w io.Writer, p renderedPlan) error {
+	jsonBytes, err := json.MarshalIndent(planOutput(p.Plan, p.Desired, p.Warnings), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal plan to JSON: %w", err)
+	}
+	_, err = w.Write(jsonBytes)
+	return err
+}
+
+// goldenRenderer prints the deterministic plan meant to be committed.
+type goldenRenderer struct{}
+
+func (goldenRenderer) Render(w io.Writer, p renderedPlan) error {
+	_, err := io.WriteString(w, diff.FormatGolden(p.Plan))
+	return err
+}
+
+// summaryRenderer prints change counts per action type.
+type summaryRenderer struct{}
+
+func (summaryRenderer) Render(w io.Writer, p renderedPlan) error {
+	_, err := io.WriteString(w, diff.FormatSummary(p.Plan))
+	return err
+}
+
+// markdownRenderer prints the plan as a Markdown table, one row per action,
+// followed by the warnings.
+type markdownRenderer struct{}
+
+func (markdownRenderer) Render(w io.Writer, p renderedPlan) error {
+	if len(p.Plan) == 0 {
+		fmt.Fprintln(w, "No changes.")
+	} else {
+		fmt.Fprintf(w, "%d change(s):\n\n", len(p.Plan))
+		fmt.Fprintln(w, "| # | Type | Change | Team |")
+		fmt.Fprintln(w, "|---|------|--------|------|")
+		for i, action := range p.Plan {
+			fmt.Fprintf(w, "| %d | `%s` | %s | %s |\n", i+1, action.Type(), markdownCell(action.Description()), markdownCell(diff.Team(action, p.Desired)))
+		}
+	}
+	writeMarkdownWarnings(w, p.Warnings)
+	return nil
+}
+
+// githubRenderer prints a pull request comment: the summary per action type
+// up front and the details of every action folded away. The first line is a
+// marker bots can look for to update their previous comment.
+type githubRenderer struct{}
+
+func (githubRenderer) Render(w io.Writer, p renderedPlan) error {
+	fmt.Fprintln(w, "<!-- summit-plan -->")
+	fmt.Fprintln(w, "### summit plan")
+	fmt.Fprintln(w)
+	if len(p.Plan) == 0 {
+		fmt.Fprintln(w, "No changes.")
+		writeMarkdownWarnings(w, p.Warnings)
+		return nil
+	}
+	fmt.Fprintf(w, "**%d change(s)**\n\n", len(p.Plan))
+	fmt.Fprintln(w, "| Type | Count | Resources |")
+	fmt.Fprintln(w, "|------|-------|-----------|")
+	for _, entry := range diff.Summarize(p.Plan) {
+		fmt.Fprintf(w, "| `%s` | %d | %s |\n", entry.Type, entry.Count, markdownCell(strings.Join(entry.Resources, ", ")))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "<details>")
+	fmt.Fprintln(w, "<summary>Details</summary>")
+	for _, action := range p.Plan {
+		fmt.Fprintf(w, "\n**%s**\n", markdownCell(describeAction(action, p.Desired)))
+		if details := action.ExecutionDetails(); len(details) > 0 {
+			fence := markdownFence(details)
+			fmt.Fprintf(w, "\n%s\n%s\n%s\n", fence, strings.Join(details, "\n"), fence)
+		}
+	}
+	fmt.Fprintln(w, "\n</details>")
+	writeMarkdownWarnings(w, p.Warnings)
+	return nil
+}
+
+func writeMarkdownWarnings(w io.Writer, warnings model.ValidationErrors) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "\n**Warnings:**\n\n")
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "- %s\n", markdownCell(warning.Message))
+	}
+}
+
+// markdownCell escapes text for a single line of Markdown, such as a table
+// cell.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// markdownFence returns a code fence longer than any backtick run in lines,
+// so file contents cannot close it early.
+func markdownFence(lines []string) string {
+	longest := 0
+	for _, line := range lines {
+		run := 0
+		for _, c := range line {
+			if c == '`' {
+				run++
+				longest = max(longest, run)
+			} else {
+				run = 0
+			}
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
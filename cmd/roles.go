@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"summit/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+// rolesCmd groups the role subcommands
+var rolesCmd = &cobra.Command{
+	Use:   "roles",
+	Short: "Works with the roles available in the config tree",
+	Long: `A role is an include file with a role header describing it:
+
+  role:
+    name: webserver
+    description: nginx with TLS
+    required-vars: [domain]
+    arches: [x86_64, aarch64]
+
+When a host pulls the role in, diff and apply check that the required vars are
+set (in vars or the host's host-vars) and that the host's architecture is
+supported.`,
+}
+
+// rolesListCmd represents the roles list command
+var rolesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the roles declared in the config tree",
+	Long: `The roles list command scans the YAML files in the directory of system.yaml
+and its subdirectories and prints every role header found.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		roles, err := config.FindRoles(cfgFile)
+		if err != nil {
+			return fmt.Errorf("failed to list roles: %w", err)
+		}
+		out := cmd.OutOrStdout()
+		if len(roles) == 0 {
+			fmt.Fprintln(out, "No roles found.")
+			return nil
+		}
+		base := filepath.Dir(cfgFile)
+		for _, r := range roles {
+			file := r.File
+			if rel, err := filepath.Rel(base, r.File); err == nil {
+				file = rel
+			}
+			fmt.Fprintf(out, "%s (%s)\n", r.Name, file)
+			if r.Description != "" {
+				fmt.Fprintf(out, "  %s\n", r.Description)
+			}
+			if len(r.RequiredVars) > 0 {
+				fmt.Fprintf(out, "  required vars: %s\n", strings.Join(r.RequiredVars, ", "))
+			}
+			if len(r.Arches) > 0 {
+				fmt.Fprintf(out, "  arches: %s\n", strings.Join(r.Arches, ", "))
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rolesCmd)
+	rolesCmd.AddCommand(rolesListCmd)
+}
@@ -7,19 +7,28 @@ import (
 	"os"
 	"strings"
 
+	"summit/pkg/config"
 	"summit/pkg/log"
+	"summit/pkg/model"
 	"summit/pkg/system"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	cfgFile    string
-	logLevel   string
-	jsonOutput bool
-	logger     log.Logger
-	cmdRunner  system.CommandRunner = &system.LiveCommandRunner{}
-	rootCmd                         = &cobra.Command{
+	cfgFile        string
+	logLevel       string
+	jsonOutput     bool
+	jsonWarnings   bool
+	allowedSigners string
+	minisignKey    string
+	ageIdentity    string
+	httpProxy      string
+	httpsProxy     string
+	noProxy        []string
+	logger         log.Logger
+	cmdRunner      system.CommandRunner = &system.LiveCommandRunner{}
+	rootCmd                             = &cobra.Command{
 		Use:   "summit",
 		Short: "summit is a tool for managing Alpine Linux installations",
 		Long: `A declarative tool for managing all aspects of an Alpine Linux installation,
@@ -33,6 +42,15 @@ from package installs to system configs and services enablement and startup.`,
 			logger = log.NewSlogLogger(level, writer)
 			ctx := context.WithValue(cmd.Context(), "logger", logger)
 			cmd.SetContext(ctx)
+
+			// Only apply configuration signed by a trusted maintainer
+			config.Integrity = nil
+			if allowedSigners != "" || minisignKey != "" {
+				config.Integrity = &config.IntegrityPolicy{AllowedSigners: allowedSigners, MinisignKey: minisignKey, Runner: cmdRunner}
+			}
+			config.Decryption = &config.DecryptionPolicy{AgeIdentity: ageIdentity, Runner: cmdRunner}
+			system.Packages = system.DetectPackageManager()
+			useProxy(nil, cmdRunner)
 			return nil
 		},
 	}
@@ -47,6 +65,34 @@ func Execute() {
 	}
 }
 
+// useProxy exports the proxy of the config, with the --http-proxy,
+// --https-proxy and --no-proxy flags taking precedence, to the commands run
+// through runner. summit's own requests, such as fetching the config with
+// --from-metadata and HTTP assertions, go through it too.
+func useProxy(configured *model.ProxyState, runner system.CommandRunner) {
+	proxy := &model.ProxyState{}
+	if configured != nil {
+		*proxy = *configured
+	}
+	if httpProxy != "" {
+		proxy.HTTP = httpProxy
+	}
+	if httpsProxy != "" {
+		proxy.HTTPS = httpsProxy
+	}
+	if len(noProxy) > 0 {
+		proxy.NoProxy = noProxy
+	}
+	env := proxy.Env()
+	for _, kv := range env {
+		name, value, _ := strings.Cut(kv, "=")
+		os.Setenv(name, value)
+	}
+	if live, ok := runner.(*system.LiveCommandRunner); ok {
+		live.Env = env
+	}
+}
+
 func parseLogLevel(levelStr string) (slog.Level, error) {
 	switch strings.ToLower(levelStr) {
 	case "debug":
@@ -65,4 +111,10 @@ func parseLogLevel(levelStr string) (slog.Level, error) {
 func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "./system.yaml", "config file (default is ./system.yaml)")
 	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "info", "Log level (debug, info, warn, error)")
+	rootCmd.PersistentFlags().StringVar(&allowedSigners, "allowed-signers", "", "ssh allowed_signers file; refuse configs not covered by a summit.manifest signed by one of them")
+	rootCmd.PersistentFlags().StringVar(&minisignKey, "minisign-key", "", "minisign public key; refuse configs not covered by a summit.manifest signed with it")
+	rootCmd.PersistentFlags().StringVar(&httpProxy, "http-proxy", "", "HTTP proxy for the commands summit runs, overriding the proxy of the config")
+	rootCmd.PersistentFlags().StringVar(&httpsProxy, "https-proxy", "", "HTTPS proxy for the commands summit runs (default: the HTTP proxy)")
+	rootCmd.PersistentFlags().StringSliceVar(&noProxy, "no-proxy", nil, "Hosts, domains and CIDRs reached without the proxy")
+	rootCmd.PersistentFlags().StringVar(&ageIdentity, "age-identity", "", "age identity file for age-encrypted config files and sops files encrypted to age (default "+config.DefaultAgeIdentity+" for age files)")
 }
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"summit/pkg/model"
+
+	"github.com/spf13/cobra"
+)
+
+// schemaCmd represents the schema command
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON Schema of system.yaml",
+	Long: `The schema command prints a JSON Schema describing system.yaml and its
+includes, for editors and CI to validate configs before they reach a host:
+
+  summit schema > summit.schema.json
+
+With the YAML language server, a config points at it with a first line like:
+
+  # yaml-language-server: $schema=./summit.schema.json
+
+The schema is generated from the config model of this summit binary, so it
+always matches the keys this version understands. It checks the shape of the
+config only; run "summit validate" for the full checks.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := json.MarshalIndent(model.Schema(), "", "  ")
+		if err != nil {
+			return fmt.Errorf("error marshaling to JSON: %w", err)
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), string(data))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+}
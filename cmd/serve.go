@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"summit/pkg/executor"
+	"summit/pkg/log"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+)
+
+// apiTokenEnv is the environment variable the API token is read from when
+// --token-file is not given.
+const apiTokenEnv = "SUMMIT_API_TOKEN"
+
+var (
+	serveListen    string
+	serveTokenFile string
+	serveTLSCert   string
+	serveTLSKey    string
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serves plan, apply and status over an HTTP API",
+	Long: `The serve command exposes the agent over HTTP, so an orchestration layer can
+query drift and trigger applies without SSH:
+
+  GET  /v1/plan     the pending plan, in the same document as "diff --json"
+  POST /v1/apply    applies the plan and returns the status of every action;
+                    ?force=true applies outside the configured apply windows,
+                    ?allow-disruptive=true applies changes that could cut off
+                    remote access
+  GET  /v1/status   whether a run is in progress and the result of the last apply
+
+Every request must carry "Authorization: Bearer <token>", where the token is read
+from --token-file or the SUMMIT_API_TOKEN environment variable. Plans and applies
+run one at a time; an apply requested while another run is in progress is refused
+with 409 Conflict. Use --tls-cert and --tls-key unless the API only listens on
+localhost.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+		token, err := readAPIToken(serveTokenFile)
+		if err != nil {
+			return err
+		}
+
+		server := &http.Server{
+			Addr:              serveListen,
+			Handler:           newAPIServer(cmd, token, logger).handler(),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		if serveTLSCert != "" || serveTLSKey != "" {
+			logger.Info("Serving the API over HTTPS", "listen", serveListen)
+			return server.ListenAndServeTLS(serveTLSCert, serveTLSKey)
+		}
+		logger.Warn("Serving the API without TLS; the token is sent in clear text", "listen", serveListen)
+		return server.ListenAndServe()
+	},
+}
+
+// readAPIToken returns the token from path, or from the environment when path
+// is empty.
+func readAPIToken(path string) (string, error) {
+	token := os.Getenv(apiTokenEnv)
+	if path != "" {
+		content, err := afero.ReadFile(system.AppFs, path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the API token: %w", err)
+		}
+		token = string(content)
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("an API token is required: use --token-file or set %s", apiTokenEnv)
+	}
+	return token, nil
+}
+
+// apiStatusForJSON is the document returned by GET /v1/status.
+type apiStatusForJSON struct {
+	Busy      bool                 `json:"busy"`
+	LastApply *apiLastApplyForJSON `json:"last_apply"`
+}
+
+// apiLastApplyForJSON is the result of the last apply made through the API.
+type apiLastApplyForJSON struct {
+	FinishedAt time.Time `json:"finished_at"`
+	applyResultForJSON
+}
+
+// apiServer handles the API requests. Runs are serialized by run; state
+// guards the status reported between runs.
+type apiServer struct {
+	cmd    *cobra.Command
+	token  string
+	logger log.Logger
+
+	run   sync.Mutex
+	state sync.Mutex
+	busy  bool
+	last  *apiLastApplyForJSON
+}
+
+func newAPIServer(cmd *cobra.Command, token string, logger log.Logger) *apiServer {
+	return &apiServer{cmd: cmd, token: token, logger: logger}
+}
+
+func (s *apiServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/plan", s.handlePlan)
+	mux.HandleFunc("POST /v1/apply", s.handleApply)
+	mux.HandleFunc("GET /v1/status", s.handleStatus)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the bearer token.
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) handlePlan(w http.ResponseWriter, r *http.Request) {
+	s.run.Lock()
+	defer s.run.Unlock()
+	s.setBusy(true)
+	defer s.setBusy(false)
+
+	desired, plan, warnings, err := checkDrift(s.logger)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, planDocument(plan, desired, warnings))
+}
+
+func (s *apiServer) handleApply(w http.ResponseWriter, r *http.Request) {
+	if !s.run.TryLock() {
+		writeJSONError(w, http.StatusConflict, fmt.Errorf("a run is already in progress"))
+		return
+	}
+	defer s.run.Unlock()
+	s.setBusy(true)
+	defer s.setBusy(false)
+
+	desired, plan, _, err := checkDrift(s.logger)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if len(plan) > 0 && r.URL.Query().Get("force") != "true" {
+		if err := checkApplyWindow(desired.ApplyWindows); err != nil {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+	}
+	if r.URL.Query().Get("allow-disruptive") != "true" {
+		if err := checkDisruptive(plan); err != nil {
+			writeJSONError(w, http.StatusConflict, err)
+			return
+		}
+	}
+
+	// The same run as apply: between the hooks, with the failure policy
+	// and rollback scope of the serve flags, followed by the assertions
+	s.logger.Info("Applying through the API", "actions", len(plan))
+	var report *executor.Report
+	err = runWithHooks(desired.Hooks, plan, cmdRunner, s.logger, func() error {
+		var err error
+		if report, err = runPlan(s.cmd, plan, desired, cmdRunner, s.logger); err != nil {
+			return err
+		}
+		return runAssertions(s.cmd, desired.Assertions, report.Applied, cmdRunner, s.logger)
+	})
+	result := applyResult(report, err)
+
+	s.state.Lock()
+	s.last = &apiLastApplyForJSON{FinishedAt: now(), applyResultForJSON: result}
+	s.state.Unlock()
+
+	status := http.StatusOK
+	if err != nil {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, result)
+}
+
+func (s *apiServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.state.Lock()
+	defer s.state.Unlock()
+	writeJSON(w, http.StatusOK, apiStatusForJSON{Busy: s.busy, LastApply: s.last})
+}
+
+func (s *apiServer) setBusy(busy bool) {
+	s.state.Lock()
+	s.busy = busy
+	s.state.Unlock()
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveListen, "listen", "127.0.0.1:8750", "Address the API listens on")
+	serveCmd.Flags().StringVar(&serveTokenFile, "token-file", "", "File holding the bearer token clients must send (default: $"+apiTokenEnv+")")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "TLS certificate file; serve HTTPS")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "TLS private key file")
+	serveCmd.Flags().IntVar(&applyParallelism, "parallelism", 1, "Maximum number of independent file actions applied concurrently")
+	serveCmd.Flags().StringVar(&applyOnFailure, "on-failure", "rollback", "What to do when an action of an apply fails: rollback, stop or continue")
+	serveCmd.Flags().StringVar(&applyRollbackScope, "rollback-scope", "all", "What to roll back when an action fails with --on-failure=rollback: action, group or all")
+}
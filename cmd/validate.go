@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"summit/pkg/config"
+	"summit/pkg/diff"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/cobra"
+)
+
+var validateAgainstSystem bool
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates the configuration file and reports errors and warnings",
+	Long: `The validate command loads the system.yaml file, including all includes, and
+reports validation errors and warnings. Errors make the command fail; warnings
+point at configuration that is probably unintended but never block apply.
+
+Use --system to also run checks that need the live system, such as detecting
+configs that manage files owned by a package or checking that the host meets
+the requirements of the roles it pulls in.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+
+		desiredSystemState, err := config.LoadConfig(cfgFile, logger)
+		if err != nil {
+			return err
+		}
+
+		warnings := desiredSystemState.Warnings()
+		if validateAgainstSystem {
+			if err := resolveHostState(desiredSystemState, cmdRunner); err != nil {
+				return err
+			}
+			system.ExtraIntrinsicIgnores = desiredSystemState.IntrinsicIgnores
+			currentSystemState, _, err := system.InferSystemState(cmdRunner, false)
+			if err != nil {
+				return err
+			}
+			if desiredSystemState.PackageOwnedConfigs == model.PackageOwnedError {
+				owners := diff.PackageOwnedConfigs(desiredSystemState, currentSystemState, cmdRunner)
+				if conflicts := diff.PackageOwnedConflicts(desiredSystemState, owners); len(conflicts) > 0 {
+					return conflicts
+				}
+			}
+			warnings = diff.CollectWarnings(desiredSystemState, currentSystemState, cmdRunner)
+		}
+
+		for _, w := range warnings {
+			fmt.Fprintf(cmd.OutOrStdout(), "warning: %s\n", w.Error())
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Configuration is valid (%d warning(s)).\n", len(warnings))
+		return nil
+	},
+}
+
+// logWarnings reports validation warnings through the logger without failing.
+func logWarnings(logger log.Logger, warnings model.ValidationErrors) {
+	for _, w := range warnings {
+		logger.Warn("Validation warning", "field", w.Field, "message", w.Message)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+	validateCmd.Flags().BoolVar(&validateAgainstSystem, "system", false, "Also run checks that compare the config against the live system")
+}
@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+	"summit/pkg/config"
+	"summit/pkg/log"
+	"summit/pkg/verify"
+
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Runs the assertions declared in the configuration",
+	Long: `The verify command runs every entry of the assertions section of system.yaml
+against the live system and reports which ones pass. Each assertion checks a
+command's exit code, an HTTP endpoint's status or that a file exists.
+
+The same assertions run automatically after a successful apply.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+
+		desiredSystemState, err := config.LoadConfig(cfgFile, logger)
+		if err != nil {
+			return err
+		}
+
+		results := verify.Run(desiredSystemState.Assertions, cmdRunner)
+		for _, r := range results {
+			if r.Err != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "FAIL %s: %v\n", r.Assertion.Label(), r.Err)
+			} else {
+				fmt.Fprintf(cmd.OutOrStdout(), "PASS %s\n", r.Assertion.Label())
+			}
+		}
+
+		if failed := verify.Failures(results); len(failed) > 0 {
+			return fmt.Errorf("%d of %d assertion(s) failed", len(failed), len(results))
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "All %d assertion(s) passed.\n", len(results))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"summit/pkg/actions"
+	"summit/pkg/config"
+	"summit/pkg/diff"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	watchInterval   time.Duration
+	watchAlertAfter int
+	watchNotify     string
+	watchCount      int
+	watchApply      bool
+)
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "Periodically checks for drift, optionally converging it",
+	Long: `The watch command recalculates the plan every --interval and reports drift,
+i.e. any difference between the live system and system.yaml. It never applies
+changes unless --apply is given. The config is reloaded on every check.
+
+Drift only triggers the --notify hook after it has been seen on --alert-after
+consecutive checks, so a transient change (a package being upgraded by hand
+mid-check) doesn't page anyone. The hook runs once per drift episode with the
+plan summary on stdin; a check without drift starts a new episode.
+
+With --apply, drift is converged as it is found. Outside the config's
+apply-windows the apply is deferred until a check falls inside a window.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := cmd.Context().Value("logger").(log.Logger)
+		if watchAlertAfter < 1 {
+			return fmt.Errorf("--alert-after must be at least 1")
+		}
+
+		tracker := &driftTracker{alertAfter: watchAlertAfter}
+		for check := 1; watchCount == 0 || check <= watchCount; check++ {
+			if check > 1 {
+				select {
+				case <-cmd.Context().Done():
+					return nil
+				case <-time.After(watchInterval):
+				}
+			}
+
+			desiredSystemState, plan, _, err := checkDrift(logger)
+			if err != nil {
+				// A broken config or a failed inference must not stop the loop
+				logger.Error("Drift check failed", "error", err)
+				continue
+			}
+
+			alert := tracker.observe(len(plan) > 0)
+			if len(plan) == 0 {
+				logger.Info("No drift detected.")
+				continue
+			}
+			summary := diff.FormatSummary(plan)
+			logger.Warn("Drift detected", "changes", len(plan), "consecutive", tracker.consecutive, "alert-after", watchAlertAfter)
+			fmt.Fprint(cmd.OutOrStdout(), summary)
+			if alert && watchNotify != "" {
+				if err := notify(watchNotify, summary); err != nil {
+					logger.Error("Notification hook failed", "command", watchNotify, "error", err)
+				}
+			}
+			if watchApply {
+				applyDrift(cmd, desiredSystemState, plan, logger)
+			}
+		}
+		return nil
+	},
+}
+
+// driftTracker counts consecutive checks with drift and decides when the
+// alerting threshold is crossed.
+type driftTracker struct {
+	alertAfter  int
+	consecutive int
+}
+
+// observe records the outcome of one check and reports whether it is the one
+// that makes the drift persistent enough to alert on.
+func (t *driftTracker) observe(drifted bool) bool {
+	if !drifted {
+		t.consecutive = 0
+		return false
+	}
+	t.consecutive++
+	return t.consecutive == t.alertAfter
+}
+
+// checkDrift loads the config and returns it along with the plan that would
+// converge the live system to it and the warnings found on the way.
+func checkDrift(logger log.Logger) (*model.SystemState, []actions.Action, model.ValidationErrors, error) {
+	desiredSystemState, err := config.LoadConfig(cfgFile, logger)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := resolveHostState(desiredSystemState, cmdRunner); err != nil {
+		return nil, nil, nil, err
+	}
+
+	currentSystemState, err := inferCurrentState(desiredSystemState, cmdRunner)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	plan, planWarnings, err := diff.CalculatePlanWithWarnings(desiredSystemState, currentSystemState, cmdRunner, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	warnings := append(diff.CollectWarnings(desiredSystemState, currentSystemState, cmdRunner), planWarnings...)
+	return desiredSystemState, plan, warnings, nil
+}
+
+// applyDrift converges the drift found by a check, deferring it while the
+// config's apply windows are closed. Failures are logged so the loop keeps
+// running; the next check sees whatever drift remains.
+func applyDrift(cmd *cobra.Command, desired *model.SystemState, plan []actions.Action, logger log.Logger) {
+	if err := checkApplyWindow(desired.ApplyWindows); err != nil {
+		logger.Info("Apply deferred until the apply window opens", "windows", strings.Join(desired.ApplyWindows, ", "))
+		return
+	}
+	// Nobody is watching to recover the host, so disruptive changes wait for
+	// an explicit apply --allow-disruptive
+	if err := checkDisruptive(plan); err != nil {
+		logger.Error("Apply skipped", "error", err)
+		return
+	}
+	err := runWithHooks(desired.Hooks, plan, cmdRunner, logger, func() error {
+		completed, err := executePlan(cmd, plan, desired, cmdRunner, logger)
+		if err != nil {
+			return err
+		}
+		return runAssertions(cmd, desired.Assertions, completed, cmdRunner, logger)
+	})
+	if err != nil {
+		logger.Error("Apply failed", "error", err)
+	}
+}
+
+// notify runs a notification hook with the message on its stdin.
+func notify(command, message string) error {
+	_, err := cmdRunner.Run("", fmt.Sprintf("printf '%%s' %s | %s", system.ShellQuote(message), command))
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Minute, "Time between drift checks")
+	watchCmd.Flags().IntVar(&watchAlertAfter, "alert-after", 1, "Number of consecutive checks with drift before the notification hook runs")
+	watchCmd.Flags().StringVar(&watchNotify, "notify", "", "Shell command run when drift persists; receives the plan summary on stdin")
+	watchCmd.Flags().BoolVar(&watchApply, "apply", false, "Apply the plan when drift is found, deferring until the apply window opens")
+	watchCmd.Flags().IntVar(&watchCount, "count", 0, "Stop after this many checks (0 runs until interrupted)")
+}
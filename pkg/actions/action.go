@@ -1,12 +1,16 @@
 package actions
 
 import (
+	"fmt"
 	"summit/pkg/log"
 	"summit/pkg/system"
 )
 
 // Action represents a single, discrete change to the system.
 type Action interface {
+	// Type returns a stable, machine-readable identifier such as "package.install".
+	// It is part of summit's output format and must not change between releases.
+	Type() string
 	// Description returns a human-readable string of what the action does.
 	Description() string
 	// Apply executes the action.
@@ -16,4 +20,57 @@ type Action interface {
 	Rollback(runner system.CommandRunner, logger log.Logger) error
 	// ExecutionDetails returns a slice of strings describing the low-level operations.
 	ExecutionDetails() []string
+	// RollbackDetails returns a slice of strings describing how Rollback would
+	// undo the action, as far as it is known before Apply runs.
+	RollbackDetails() []string
 }
+
+// Checker is implemented by actions that can tell whether their outcome
+// already holds, for example because another process made the change after the
+// plan was calculated or because the plan is being re-applied. Apply skips
+// such actions and reports them as ok instead of changed.
+type Checker interface {
+	Check(runner system.CommandRunner) (bool, error)
+}
+
+// Remover is implemented by actions that remove something from the system.
+// Removals returns the key of the limit the removals count against, one of
+// the model.Limit* constants, and how many the action makes, so a plan can
+// be refused when a bad config would remove too much.
+type Remover interface {
+	Removals() (limit string, count int)
+}
+
+// Resolvable is implemented by actions whose behavior depends on lookups made
+// on the live system, such as the installed version of a package. Resolve
+// performs those lookups when the plan is calculated and records the result
+// in the action, so ExecutionDetails shows exactly what Apply will do and
+// Apply uses the recorded values.
+type Resolvable interface {
+	Resolve(runner system.CommandRunner) error
+}
+
+// ResolvePlan resolves every Resolvable action of the plan. An action that
+// cannot be resolved is left as is and resolves again when applied; the
+// returned errors say why, in plan order.
+func ResolvePlan(plan []Action, runner system.CommandRunner) []error {
+	var errs []error
+	for _, action := range plan {
+		if r, ok := action.(Resolvable); ok {
+			if err := r.Resolve(runner); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", action.Description(), err))
+			}
+		}
+	}
+	return errs
+}
+
+// Status is the outcome of a single action during apply.
+type Status string
+
+const (
+	StatusOK      Status = "ok"      // The outcome already held; nothing was done
+	StatusChanged Status = "changed" // The action was applied
+	StatusFailed  Status = "failed"  // The action returned an error
+	StatusSkipped Status = "skipped" // The action was not attempted because an earlier one failed
+)
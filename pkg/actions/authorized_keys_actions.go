@@ -0,0 +1,220 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// AuthorizedKeyAddAction adds a public key to the summit-owned block of a
+// user's ~/.ssh/authorized_keys, creating the file and directory if needed.
+type AuthorizedKeyAddAction struct {
+	User string
+	Key  string
+
+	edit authorizedKeysEdit
+}
+
+func (a *AuthorizedKeyAddAction) Type() string {
+	return "user.key.add"
+}
+
+func (a *AuthorizedKeyAddAction) Description() string {
+	return fmt.Sprintf("Add authorized key %s for user %s", model.AuthorizedKeyLabel(a.Key), a.User)
+}
+
+func (a *AuthorizedKeyAddAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Adding authorized key", "user", a.User, "key", model.AuthorizedKeyLabel(a.Key))
+	return a.edit.apply(a.User, func(content string) string {
+		if hasAuthorizedKey(content, a.Key) {
+			return content
+		}
+		managed, _ := model.ParseAuthorizedKeys(content)
+		block := &ManagedBlockAction{Content: strings.Join(append(managed, a.Key), "\n")}
+		return block.render(content)
+	})
+}
+
+func (a *AuthorizedKeyAddAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back authorized key", "user", a.User, "key", model.AuthorizedKeyLabel(a.Key))
+	return a.edit.rollback(logger)
+}
+
+func (a *AuthorizedKeyAddAction) ExecutionDetails() []string {
+	return []string{fmt.Sprintf("add to the summit block of ~%s/.ssh/authorized_keys: %s", a.User, a.Key)}
+}
+
+func (a *AuthorizedKeyAddAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("restore ~%s/.ssh/authorized_keys as it was before apply", a.User)}
+}
+
+func (a *AuthorizedKeyAddAction) Check(runner system.CommandRunner) (bool, error) {
+	content, err := readAuthorizedKeys(a.User)
+	return err == nil && hasAuthorizedKey(content, a.Key), nil
+}
+
+// AuthorizedKeyRemoveAction removes a public key from a user's
+// ~/.ssh/authorized_keys, inside or outside the summit-owned block.
+type AuthorizedKeyRemoveAction struct {
+	User string
+	Key  string
+
+	edit authorizedKeysEdit
+}
+
+func (a *AuthorizedKeyRemoveAction) Type() string {
+	return "user.key.remove"
+}
+
+func (a *AuthorizedKeyRemoveAction) Removals() (string, int) {
+	return model.LimitKeyRemovals, 1
+}
+
+func (a *AuthorizedKeyRemoveAction) Description() string {
+	return fmt.Sprintf("Remove authorized key %s from user %s", model.AuthorizedKeyLabel(a.Key), a.User)
+}
+
+func (a *AuthorizedKeyRemoveAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Removing authorized key", "user", a.User, "key", model.AuthorizedKeyLabel(a.Key))
+	id, _ := model.AuthorizedKeyID(a.Key)
+	return a.edit.apply(a.User, func(content string) string {
+		var kept []string
+		for _, line := range strings.SplitAfter(content, "\n") {
+			if lineID, ok := model.AuthorizedKeyID(line); ok && lineID == id && !strings.HasPrefix(strings.TrimSpace(line), "#") {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return strings.Join(kept, "")
+	})
+}
+
+func (a *AuthorizedKeyRemoveAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back authorized key removal", "user", a.User, "key", model.AuthorizedKeyLabel(a.Key))
+	return a.edit.rollback(logger)
+}
+
+func (a *AuthorizedKeyRemoveAction) ExecutionDetails() []string {
+	return []string{fmt.Sprintf("remove from ~%s/.ssh/authorized_keys: %s", a.User, a.Key)}
+}
+
+func (a *AuthorizedKeyRemoveAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("restore ~%s/.ssh/authorized_keys as it was before apply", a.User)}
+}
+
+func (a *AuthorizedKeyRemoveAction) Check(runner system.CommandRunner) (bool, error) {
+	content, err := readAuthorizedKeys(a.User)
+	return err == nil && !hasAuthorizedKey(content, a.Key), nil
+}
+
+// hasAuthorizedKey reports whether content holds key, compared by type and
+// blob.
+func hasAuthorizedKey(content, key string) bool {
+	id, _ := model.AuthorizedKeyID(key)
+	managed, unmanaged := model.ParseAuthorizedKeys(content)
+	for _, line := range append(managed, unmanaged...) {
+		if lineID, ok := model.AuthorizedKeyID(line); ok && lineID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// readAuthorizedKeys returns the authorized_keys of a user, empty when the
+// file does not exist.
+func readAuthorizedKeys(user string) (string, error) {
+	home, _, _, err := system.LookupHome(user)
+	if err != nil {
+		return "", err
+	}
+	path := system.AuthorizedKeysPath(home)
+	if err := system.RefuseSymlinks(home, path); err != nil {
+		return "", err
+	}
+	content, err := afero.ReadFile(system.AppFs, path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(content), err
+}
+
+// authorizedKeysEdit changes a user's authorized_keys and remembers what to
+// restore on rollback. Files and directories it creates belong to the user,
+// with the permissions sshd requires. Symlinks in the home are refused, as
+// root would otherwise write wherever the user points them.
+type authorizedKeysEdit struct {
+	home        string
+	path        string
+	dir         string
+	origContent string
+	origMode    os.FileMode
+	createdFile bool
+	createdDir  bool
+}
+
+func (e *authorizedKeysEdit) apply(user string, change func(content string) string) error {
+	home, uid, gid, err := system.LookupHome(user)
+	if err != nil {
+		return err
+	}
+	path := system.AuthorizedKeysPath(home)
+	if err := system.RefuseSymlinks(home, path); err != nil {
+		return err
+	}
+	e.home, e.path, e.dir = home, path, filepath.Dir(path)
+
+	if exists, _ := afero.DirExists(system.AppFs, e.dir); !exists {
+		if err := system.AppFs.MkdirAll(e.dir, 0700); err != nil {
+			return fmt.Errorf("failed to create %s: %w", e.dir, err)
+		}
+		e.createdDir = true
+		if err := system.AppFs.Chown(e.dir, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", e.dir, err)
+		}
+	}
+
+	e.origMode = 0600
+	content, err := afero.ReadFile(system.AppFs, e.path)
+	switch {
+	case os.IsNotExist(err):
+		e.createdFile = true
+	case err != nil:
+		return err
+	default:
+		if info, err := system.AppFs.Stat(e.path); err == nil {
+			e.origMode = info.Mode()
+		}
+	}
+	e.origContent = string(content)
+
+	fileUID, fileGID := -1, -1
+	if e.createdFile {
+		fileUID, fileGID = uid, gid
+	}
+	return system.WriteFileNoFollow(e.home, e.path, []byte(change(e.origContent)), e.origMode, fileUID, fileGID)
+}
+
+func (e *authorizedKeysEdit) rollback(logger log.Logger) error {
+	if e.path == "" {
+		return nil
+	}
+	var err error
+	switch {
+	case e.createdDir:
+		err = system.AppFs.RemoveAll(e.dir)
+	case e.createdFile:
+		err = system.AppFs.Remove(e.path)
+	default:
+		err = system.WriteFileNoFollow(e.home, e.path, []byte(e.origContent), e.origMode, -1, -1)
+	}
+	if err != nil {
+		logger.Error("Failed to roll back authorized keys", "path", e.path, "error", err)
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+package actions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPasswd = "alice:x:1000:1000::/home/alice:/bin/ash\n"
+
+func TestAuthorizedKeyAddAction_CreatesFile(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(testPasswd), 0644))
+	require.NoError(t, system.AppFs.MkdirAll("/home/alice", 0755))
+
+	action := &AuthorizedKeyAddAction{User: "alice", Key: "ssh-ed25519 AAAAC3Nza alice@laptop"}
+	assert.Equal(t, "Add authorized key alice@laptop for user alice", action.Description())
+	require.NoError(t, action.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/home/alice/.ssh/authorized_keys")
+	require.NoError(t, err)
+	assert.Equal(t, "# BEGIN summit\nssh-ed25519 AAAAC3Nza alice@laptop\n# END summit\n", string(content))
+	info, err := system.AppFs.Stat("/home/alice/.ssh/authorized_keys")
+	require.NoError(t, err)
+	assert.Equal(t, "-rw-------", info.Mode().String())
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	require.NoError(t, action.Rollback(runner, logger))
+	exists, _ := afero.DirExists(system.AppFs, "/home/alice/.ssh")
+	assert.False(t, exists, "the .ssh directory created by apply is removed on rollback")
+}
+
+func TestAuthorizedKeyActions_KeepUnmanagedKeys(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(testPasswd), 0644))
+	original := "ssh-rsa AAAAold bob\n# BEGIN summit\nssh-ed25519 AAAAci ci\n# END summit\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/home/alice/.ssh/authorized_keys", []byte(original), 0600))
+
+	add := &AuthorizedKeyAddAction{User: "alice", Key: "ssh-ed25519 AAAAnew"}
+	assert.Equal(t, "Add authorized key ssh-ed25519 ...AAAAnew for user alice", add.Description())
+	require.NoError(t, add.Apply(runner, logger))
+	remove := &AuthorizedKeyRemoveAction{User: "alice", Key: "ssh-ed25519 AAAAci ci"}
+	require.NoError(t, remove.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/home/alice/.ssh/authorized_keys")
+	require.NoError(t, err)
+	assert.Equal(t, "ssh-rsa AAAAold bob\n# BEGIN summit\nssh-ed25519 AAAAnew\n# END summit\n", string(content))
+
+	// A key already present outside the block is not added again
+	existing := &AuthorizedKeyAddAction{User: "alice", Key: "ssh-rsa AAAAold bob@other"}
+	done, err := existing.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	require.NoError(t, remove.Rollback(runner, logger))
+	require.NoError(t, add.Rollback(runner, logger))
+	content, err = afero.ReadFile(system.AppFs, "/home/alice/.ssh/authorized_keys")
+	require.NoError(t, err)
+	assert.Equal(t, original, string(content))
+}
+
+func TestAuthorizedKeyAddAction_UnknownUser(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(testPasswd), 0644))
+
+	action := &AuthorizedKeyAddAction{User: "bob", Key: "ssh-ed25519 AAAAnew"}
+	assert.ErrorContains(t, action.Apply(runner, logger), "user bob not found in /etc/passwd")
+	assert.NoError(t, action.Rollback(runner, logger))
+}
+
+func TestAuthorizedKeyAddAction_RefusesSymlinks(t *testing.T) {
+	runner, logger, root := setupSymlinkTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/shadow", []byte("root:secret\n"), 0600))
+	require.NoError(t, system.AppFs.MkdirAll("/home/alice/.ssh", 0700))
+	link := filepath.Join(root, "home/alice/.ssh/authorized_keys")
+	require.NoError(t, os.Symlink(filepath.Join(root, "etc/shadow"), link))
+
+	action := &AuthorizedKeyAddAction{User: "alice", Key: "ssh-ed25519 AAAAC3Nza alice@laptop"}
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done)
+	assert.ErrorContains(t, action.Apply(runner, logger), "refusing to follow symlink")
+	require.NoError(t, action.Rollback(runner, logger))
+
+	// A link of the .ssh directory is refused as well
+	require.NoError(t, os.Remove(link))
+	require.NoError(t, system.AppFs.Remove("/home/alice/.ssh"))
+	require.NoError(t, os.Symlink(filepath.Join(root, "etc"), filepath.Join(root, "home/alice/.ssh")))
+	assert.ErrorContains(t, action.Apply(runner, logger), "refusing to follow symlink")
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/shadow")
+	require.NoError(t, err)
+	assert.Equal(t, "root:secret\n", string(content))
+	exists, _ := afero.Exists(system.AppFs, "/etc/authorized_keys")
+	assert.False(t, exists)
+}
@@ -0,0 +1,190 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// ManagedBlockAction owns a delimited region of a file that summit does not
+// fully manage, e.g. /etc/hosts. Only the lines between the BEGIN and END
+// markers are written; the rest of the file is left untouched.
+type ManagedBlockAction struct {
+	Path      string
+	Name      string // Distinguishes several blocks in the same file
+	Comment   string // Line comment prefix used for the markers, "#" by default
+	Content   string
+	Sensitive bool // Content and diff are never shown
+
+	origContent string
+	origMode    os.FileMode
+	created     bool
+}
+
+func (a *ManagedBlockAction) Type() string {
+	return "file.block"
+}
+
+func (a *ManagedBlockAction) Description() string {
+	if a.Name != "" {
+		return fmt.Sprintf("Update managed block %s in %s", a.Name, a.Path)
+	}
+	return fmt.Sprintf("Update managed block in %s", a.Path)
+}
+
+func (a *ManagedBlockAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Updating managed block", "path", a.Path, "block", a.Name)
+	mode := os.FileMode(0644)
+	content, err := afero.ReadFile(system.AppFs, a.Path)
+	switch {
+	case os.IsNotExist(err):
+		a.created = true
+	case err != nil:
+		return err
+	default:
+		info, err := system.AppFs.Stat(a.Path)
+		if err != nil {
+			return err
+		}
+		mode = info.Mode()
+	}
+	a.origContent = string(content)
+	a.origMode = mode
+	expanded, err := a.Expanded()
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(system.AppFs, a.Path, []byte(expanded.render(a.origContent)), mode)
+}
+
+func (a *ManagedBlockAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back managed block", "path", a.Path, "block", a.Name)
+	var err error
+	if a.created {
+		err = system.AppFs.Remove(a.Path)
+	} else {
+		err = afero.WriteFile(system.AppFs, a.Path, []byte(a.origContent), a.origMode)
+	}
+	if err != nil {
+		logger.Error("Failed to roll back managed block", "path", a.Path, "error", err)
+	}
+	return err
+}
+
+func (a *ManagedBlockAction) ExecutionDetails() []string {
+	current, _ := afero.ReadFile(system.AppFs, a.Path)
+	oldBlock, _ := a.extract(string(current))
+	details := []string{
+		fmt.Sprintf("update block %s ... %s in: %s", a.markers()[0], a.markers()[1], a.Path),
+	}
+	if a.Sensitive {
+		return append(details, RedactedDiff)
+	}
+	details = append(details, "--- diff ---")
+	// The current block may hold secret values the new one references
+	details = append(details, lineDiff(Secrets.Redact(oldBlock), withTrailingNewline(a.Content), DiffContextLines, DiffMaxLines)...)
+	return append(details, "--- end diff ---")
+}
+
+func (a *ManagedBlockAction) RollbackDetails() []string {
+	if exists, _ := fileExists(a.Path); !exists {
+		return []string{fmt.Sprintf("delete file: %s", a.Path)}
+	}
+	return []string{fmt.Sprintf("restore the previous content of %s", a.Path)}
+}
+
+func (a *ManagedBlockAction) Check(runner system.CommandRunner) (bool, error) {
+	content, err := afero.ReadFile(system.AppFs, a.Path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	expanded, err := a.Expanded()
+	if err != nil {
+		return false, err
+	}
+	return !expanded.NeedsUpdate(string(content)), nil
+}
+
+// NeedsUpdate reports whether the block in content differs from the desired
+// one, or is missing.
+func (a *ManagedBlockAction) NeedsUpdate(content string) bool {
+	return a.render(content) != content
+}
+
+// Expanded returns a copy of the action with the secret and environment
+// references of its content replaced by their values, the block written to
+// the file.
+func (a *ManagedBlockAction) Expanded() (*ManagedBlockAction, error) {
+	content, err := Secrets.Expand(a.Content)
+	if err != nil {
+		return nil, err
+	}
+	expanded := *a
+	expanded.Content = content
+	return &expanded, nil
+}
+
+// markers returns the BEGIN and END marker lines of the block.
+func (a *ManagedBlockAction) markers() [2]string {
+	return model.BlockMarkers(a.Comment, a.Name)
+}
+
+// locate returns the line indexes of the block markers in lines, or -1s when
+// the file has no complete block.
+func (a *ManagedBlockAction) locate(lines []string) (int, int) {
+	m := a.markers()
+	begin := -1
+	for i, line := range lines {
+		switch strings.TrimSpace(line) {
+		case m[0]:
+			begin = i
+		case m[1]:
+			if begin >= 0 {
+				return begin, i
+			}
+		}
+	}
+	return -1, -1
+}
+
+// extract returns the current content between the markers.
+func (a *ManagedBlockAction) extract(content string) (string, bool) {
+	lines := strings.SplitAfter(content, "\n")
+	begin, end := a.locate(lines)
+	if begin < 0 {
+		return "", false
+	}
+	return strings.Join(lines[begin+1:end], ""), true
+}
+
+// render returns content with the block replaced, or appended at the end of
+// the file when it has no block yet.
+func (a *ManagedBlockAction) render(content string) string {
+	m := a.markers()
+	block := m[0] + "\n" + withTrailingNewline(a.Content) + m[1] + "\n"
+
+	lines := strings.SplitAfter(content, "\n")
+	begin, end := a.locate(lines)
+	if begin < 0 {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		return content + block
+	}
+	return strings.Join(lines[:begin], "") + block + strings.Join(lines[end+1:], "")
+}
+
+func withTrailingNewline(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
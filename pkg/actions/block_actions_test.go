@@ -0,0 +1,72 @@
+package actions
+
+import (
+	"testing"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManagedBlockAction_AppendsAndReplaces(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	hosts := "127.0.0.1\tlocalhost\n::1\tlocalhost"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hosts", []byte(hosts), 0644))
+
+	action := &ManagedBlockAction{Path: "/etc/hosts", Content: "10.0.0.5 db"}
+	require.NoError(t, action.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/hosts")
+	require.NoError(t, err)
+	assert.Equal(t, hosts+"\n# BEGIN summit\n10.0.0.5 db\n# END summit\n", string(content))
+
+	// Lines added around the block by other tools survive an update
+	edited := "# added by hand\n" + string(content) + "192.168.1.1 router\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hosts", []byte(edited), 0644))
+
+	update := &ManagedBlockAction{Path: "/etc/hosts", Content: "10.0.0.5 db\n10.0.0.6 cache\n"}
+	require.NoError(t, update.Apply(runner, logger))
+
+	content, err = afero.ReadFile(system.AppFs, "/etc/hosts")
+	require.NoError(t, err)
+	assert.Equal(t, "# added by hand\n"+hosts+"\n# BEGIN summit\n10.0.0.5 db\n10.0.0.6 cache\n# END summit\n192.168.1.1 router\n", string(content))
+
+	converged, err := update.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, converged)
+
+	require.NoError(t, update.Rollback(runner, logger))
+	content, err = afero.ReadFile(system.AppFs, "/etc/hosts")
+	require.NoError(t, err)
+	assert.Equal(t, edited, string(content))
+}
+
+func TestManagedBlockAction_NamedBlocks(t *testing.T) {
+	runner, logger := setupFileTest(t)
+
+	first := &ManagedBlockAction{Path: "/etc/fstab", Name: "nfs", Content: "nas:/data /data nfs defaults 0 0"}
+	second := &ManagedBlockAction{Path: "/etc/fstab", Name: "tmp", Comment: "#", Content: "tmpfs /tmp tmpfs defaults 0 0"}
+	require.NoError(t, first.Apply(runner, logger))
+	require.NoError(t, second.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/fstab")
+	require.NoError(t, err)
+	assert.Equal(t, "# BEGIN summit nfs\nnas:/data /data nfs defaults 0 0\n# END summit nfs\n"+
+		"# BEGIN summit tmp\ntmpfs /tmp tmpfs defaults 0 0\n# END summit tmp\n", string(content))
+	assert.False(t, first.NeedsUpdate(string(content)))
+	assert.Equal(t, "Update managed block nfs in /etc/fstab", first.Description())
+
+	// The file did not exist before, so rolling back the first block removes it
+	require.NoError(t, first.Rollback(runner, logger))
+	exists, err := afero.Exists(system.AppFs, "/etc/fstab")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestManagedBlockAction_SensitiveDetails(t *testing.T) {
+	setupFileTest(t)
+	action := &ManagedBlockAction{Path: "/etc/hosts", Content: "10.0.0.1 vault\n", Sensitive: true}
+	assert.Equal(t, []string{"update block # BEGIN summit ... # END summit in: /etc/hosts", RedactedDiff}, action.ExecutionDetails())
+}
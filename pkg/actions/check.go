@@ -0,0 +1,89 @@
+package actions
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// installedVersion reports whether a package is installed explicitly, as the
+// package manager lists it (in /etc/apk/world for apk), along with the version
+// it is pinned to, if any.
+func installedVersion(runner system.CommandRunner, name string) (string, bool, error) {
+	packages, err := system.Packages.Installed(runner)
+	if err != nil {
+		return "", false, err
+	}
+	for _, pkg := range packages {
+		if pkg.Name == name {
+			return pkg.Version, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// databaseHasEntry reports whether a colon-separated database such as
+// /etc/passwd or /etc/group has an entry with the given name.
+func databaseHasEntry(path, name string) (bool, error) {
+	f, err := system.AppFs.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if fields := strings.SplitN(scanner.Text(), ":", 2); fields[0] == name {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// runlevelHasService reports whether a service is added to a runlevel.
+func runlevelHasService(runlevel, service string) (bool, error) {
+	return fileExists(filepath.Join("/etc/runlevels", runlevel, service))
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := system.AppFs.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// fileHasContent reports whether path exists with exactly the given content.
+func fileHasContent(path, content string) (bool, error) {
+	current, err := afero.ReadFile(system.AppFs, path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return string(current) == content, nil
+}
+
+// groupHasMember reports whether /etc/group lists user as a member of group.
+func groupHasMember(group, user string) (bool, error) {
+	content, err := afero.ReadFile(system.AppFs, "/etc/group")
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 || fields[0] != group {
+			continue
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if strings.TrimSpace(member) == user {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	runner, _ := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/apk/world", []byte("htop\nvim>=9\nnginx=1.24.0-r7\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("mino:x:1000:1000::/home/mino:/bin/sh\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/group", []byte("wheel:x:10:mino\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/runlevels/default/sshd", []byte(""), 0755))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/motd", []byte("hello"), 0600))
+
+	tests := []struct {
+		action   Checker
+		expected bool
+	}{
+		{&PackageInstallAction{PackageName: "htop"}, true},
+		{&PackageInstallAction{PackageName: "vim"}, true},
+		{&PackageInstallAction{PackageName: "curl"}, false},
+		{&PackageInstallAction{PackageName: "nginx", Version: "1.24.0-r7"}, true},
+		{&PackageInstallAction{PackageName: "nginx", Version: "1.24.0-r6", Update: true}, false},
+		{&PackageInstallAction{PackageName: "htop", Version: "3.3.0-r0"}, false},
+		{&PackageRemoveAction{PackageName: "curl"}, true},
+		{&PackageRemoveAction{PackageName: "htop"}, false},
+		{&ServiceEnableAction{ServiceName: "sshd", Runlevel: "default"}, true},
+		{&ServiceEnableAction{ServiceName: "crond", Runlevel: "default"}, false},
+		{&ServiceDisableAction{ServiceName: "crond", Runlevel: "default"}, true},
+		{&ServiceDisableAction{ServiceName: "sshd", Runlevel: "default"}, false},
+		{&UserCreateAction{UserName: "mino"}, true},
+		{&UserRemoveAction{UserName: "mino"}, false},
+		{&GroupCreateAction{GroupName: "wheel"}, true},
+		{&GroupCreateAction{GroupName: "docker"}, false},
+		{&AddUserToGroupAction{UserName: "mino", GroupName: "wheel"}, true},
+		{&AddUserToGroupAction{UserName: "bob", GroupName: "wheel"}, false},
+		{&RemoveUserFromGroupAction{UserName: "mino", GroupName: "wheel"}, false},
+		{&FileCreateAction{Path: "/etc/motd", Content: "hello"}, true},
+		{&FileCreateAction{Path: "/etc/motd", Content: "hello", Mode: "0644"}, false},
+		{&FileUpdateAction{Path: "/etc/motd", NewContent: "bye"}, false},
+		{&FileDeleteAction{Path: "/etc/issue"}, true},
+		{&FileChmodAction{Path: "/etc/motd", Mode: "600"}, true},
+		{&FileChmodAction{Path: "/etc/motd", Mode: "0644"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action.(Action).Description(), func(t *testing.T) {
+			done, err := tt.action.Check(runner)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, done)
+		})
+	}
+}
+
+func TestServiceEnableAction_CheckRequiresRunning(t *testing.T) {
+	runner, _ := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/runlevels/default/sshd", []byte(""), 0755))
+	runner.Errors[":rc-service sshd status"] = errors.New("exit status 3")
+	action := &ServiceEnableAction{ServiceName: "sshd", Runlevel: "default"}
+
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done, "a stopped service in its runlevel is not converged")
+	assert.Equal(t, []string{"rc-service sshd status"}, runner.Commands)
+
+	StartServices = false
+	t.Cleanup(func() { StartServices = true })
+	done, err = action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done, "services are not started, so the runlevel is enough")
+}
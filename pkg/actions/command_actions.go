@@ -0,0 +1,116 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/system"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// CommandAction runs a shell command of the commands section, as User when
+// set, killing it once Timeout runs out. Creates and Unless are the guards
+// the plan was calculated with: Check looks at them again, so a command whose
+// work got done in the meantime is skipped.
+type CommandAction struct {
+	Name    string
+	Command string
+	User    string
+	Creates string
+	Unless  string
+	Timeout time.Duration
+}
+
+func (a *CommandAction) Type() string {
+	return "command.run"
+}
+
+func (a *CommandAction) Description() string {
+	return fmt.Sprintf("Run command %s", a.Name)
+}
+
+// runCommand returns the command line running the command under timeout(1),
+// which exits 124 once the timeout runs out.
+func (a *CommandAction) runCommand() string {
+	return a.withTimeout(a.Command)
+}
+
+// withTimeout returns the command line running command under timeout(1).
+// The Unless guard runs under it too: it runs while the plan is calculated,
+// which a hung guard would block.
+func (a *CommandAction) withTimeout(command string) string {
+	seconds := int(math.Ceil(a.Timeout.Seconds()))
+	if seconds <= 0 {
+		return command
+	}
+	return fmt.Sprintf("timeout %d sh -c %s", seconds, system.ShellQuote(command))
+}
+
+func (a *CommandAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.Command) == "" {
+		return fmt.Errorf("command cannot be empty")
+	}
+	logger.Info("Running command", "name", a.Name, "user", a.User, "timeout", a.Timeout)
+	out, err := runner.Run(a.User, a.runCommand())
+	output := strings.TrimSpace(string(out))
+	if output != "" {
+		logger.Debug("Command output", "name", a.Name, "output", output)
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 124:
+		return fmt.Errorf("command %s timed out after %s", a.Name, a.Timeout)
+	case err != nil && output != "":
+		return fmt.Errorf("command %s failed: %w: %s", a.Name, err, output)
+	case err != nil:
+		return fmt.Errorf("command %s failed: %w", a.Name, err)
+	}
+	return nil
+}
+
+// Rollback cannot undo what an arbitrary command did; it only says so.
+func (a *CommandAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Warn("Commands cannot be rolled back; undo their changes by hand if needed", "name", a.Name)
+	return nil
+}
+
+func (a *CommandAction) ExecutionDetails() []string {
+	details := []string{"run: " + system.UserCommand(a.User, a.runCommand())}
+	if a.Creates != "" {
+		details = append(details, "unless exists: "+a.Creates)
+	}
+	if a.Unless != "" {
+		details = append(details, "unless succeeds: "+system.UserCommand(a.User, a.withTimeout(a.Unless)))
+	}
+	return details
+}
+
+func (a *CommandAction) RollbackDetails() []string {
+	return []string{"cannot be rolled back: undo the changes of the command by hand"}
+}
+
+// Check reports whether a guard says the command need not run: the Creates
+// path exists or the Unless command succeeds within Timeout. A command
+// without guards always runs.
+func (a *CommandAction) Check(runner system.CommandRunner) (bool, error) {
+	if a.Creates != "" {
+		exists, err := afero.Exists(system.AppFs, a.Creates)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return true, nil
+		}
+	}
+	if a.Unless != "" {
+		if _, err := runner.Run(a.User, a.withTimeout(a.Unless)); err == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,62 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandAction_Apply(t *testing.T) {
+	runner, logger := setupFileTest(t)
+
+	action := &CommandAction{Name: "dhparam", Command: "openssl dhparam -out '/etc/ssl/dh.pem' 2048", User: "alice", Timeout: 90 * time.Second}
+	assert.Equal(t, "Run command dhparam", action.Description())
+	require.NoError(t, action.Apply(runner, logger))
+	assert.Equal(t, []string{`timeout 90 sh -c 'openssl dhparam -out '\''/etc/ssl/dh.pem'\'' 2048'`}, runner.Commands)
+
+	runner.Errors["alice:"+action.runCommand()] = errors.New("exit status 1")
+	err := action.Apply(runner, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "command dhparam failed: exit status 1")
+}
+
+func TestCommandAction_Check(t *testing.T) {
+	runner, _ := setupFileTest(t)
+
+	action := &CommandAction{Name: "init", Command: "init-db", Creates: "/var/lib/db/ready", Unless: "db-ready"}
+	runner.Errors[":db-ready"] = errors.New("exit status 1")
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	// Either guard holding skips the command
+	require.NoError(t, afero.WriteFile(system.AppFs, "/var/lib/db/ready", nil, 0644))
+	done, err = action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	require.NoError(t, system.AppFs.Remove("/var/lib/db/ready"))
+	delete(runner.Errors, ":db-ready")
+	done, err = action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	// Without guards a command always runs
+	done, err = (&CommandAction{Name: "always", Command: "true"}).Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done)
+
+	// The unless guard runs under the timeout of the command
+	runner.Commands = nil
+	timed := &CommandAction{Name: "migrate", Command: "app migrate", Unless: "app migrate --check", Timeout: time.Minute}
+	_, err = timed.Check(runner)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"timeout 60 sh -c 'app migrate --check'"}, runner.Commands)
+	assert.Contains(t, timed.ExecutionDetails(), "unless succeeds: timeout 60 sh -c 'app migrate --check'")
+}
@@ -0,0 +1,114 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// CrontabAction writes the summit-owned block of a user's crontab through
+// crontab -u. Jobs outside the block are kept as they are.
+type CrontabAction struct {
+	User    string
+	Content string // Desired lines between the markers
+	Current string // Lines between the markers when the plan was made, for the diff
+
+	origCrontab string
+	hadCrontab  bool
+}
+
+func (a *CrontabAction) Type() string {
+	return "cron.update"
+}
+
+// Removals counts the jobs of the block that the update drops, blank lines
+// and comments aside.
+func (a *CrontabAction) Removals() (string, int) {
+	kept := make(map[string]int)
+	for _, line := range strings.Split(a.Content, "\n") {
+		kept[strings.TrimSpace(line)]++
+	}
+	removed := 0
+	for _, line := range strings.Split(a.Current, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if kept[line] > 0 {
+			kept[line]--
+		} else {
+			removed++
+		}
+	}
+	return model.LimitCronRemovals, removed
+}
+
+func (a *CrontabAction) Description() string {
+	return fmt.Sprintf("Update crontab of user %s", a.User)
+}
+
+func (a *CrontabAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Updating crontab", "user", a.User)
+	a.origCrontab, a.hadCrontab = readCrontab(runner, a.User)
+	return writeCrontab(runner, a.User, a.block().render(a.origCrontab))
+}
+
+func (a *CrontabAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back crontab", "user", a.User)
+	var err error
+	if a.hadCrontab {
+		err = writeCrontab(runner, a.User, a.origCrontab)
+	} else {
+		_, err = runner.Run("", fmt.Sprintf("crontab -u %s -r", a.User))
+	}
+	if err != nil {
+		logger.Error("Failed to roll back crontab", "user", a.User, "error", err)
+	}
+	return err
+}
+
+func (a *CrontabAction) ExecutionDetails() []string {
+	markers := a.block().markers()
+	details := []string{
+		fmt.Sprintf("run: crontab -u %s - (block %s ... %s)", a.User, markers[0], markers[1]),
+		"--- diff ---",
+	}
+	details = append(details, lineDiff(a.Current, a.Content, DiffContextLines, DiffMaxLines)...)
+	return append(details, "--- end diff ---")
+}
+
+func (a *CrontabAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("restore the crontab of user %s as it was before apply (run: crontab -u %s -r if it had none)", a.User, a.User)}
+}
+
+func (a *CrontabAction) Check(runner system.CommandRunner) (bool, error) {
+	crontab, ok := readCrontab(runner, a.User)
+	if !ok {
+		return false, nil
+	}
+	return !a.block().NeedsUpdate(crontab), nil
+}
+
+// block returns the marker-delimited region the action owns in the crontab.
+func (a *CrontabAction) block() *ManagedBlockAction {
+	return &ManagedBlockAction{Content: a.Content}
+}
+
+// readCrontab returns the crontab of user, and false when the user has none.
+func readCrontab(runner system.CommandRunner, user string) (string, bool) {
+	out, err := runner.Run("", fmt.Sprintf("crontab -u %s -l", user))
+	if err != nil {
+		return "", false
+	}
+	return string(out), true
+}
+
+func writeCrontab(runner system.CommandRunner, user, crontab string) error {
+	cmd := fmt.Sprintf("printf '%%s' %s | crontab -u %s -", system.ShellQuote(crontab), user)
+	if out, err := runner.Run("", cmd); err != nil {
+		return fmt.Errorf("failed to install crontab for user %s: %w: %s", user, err, out)
+	}
+	return nil
+}
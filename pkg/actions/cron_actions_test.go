@@ -0,0 +1,60 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrontabAction_ApplyKeepsUnmanagedJobs(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+	runner.Responses[":crontab -u alice -l"] = []byte("MAILTO=alice\n*/5 * * * * fetchmail\n")
+
+	action := &CrontabAction{User: "alice", Content: "# backup\n0 3 * * * backup.sh\n"}
+	require.NoError(t, action.Apply(runner, logger))
+
+	want := "MAILTO=alice\n*/5 * * * * fetchmail\n# BEGIN summit\n# backup\n0 3 * * * backup.sh\n# END summit\n"
+	assert.Contains(t, runner.Commands, "printf '%s' '"+want+"' | crontab -u alice -")
+
+	// Rollback reinstalls the crontab as it was
+	runner.Commands = nil
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{"printf '%s' 'MAILTO=alice\n*/5 * * * * fetchmail\n' | crontab -u alice -"}, runner.Commands)
+}
+
+func TestCrontabAction_RollbackRemovesCreatedCrontab(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+	runner.Errors[":crontab -u bob -l"] = errors.New("no crontab for bob")
+
+	action := &CrontabAction{User: "bob", Content: "@daily cleanup\n"}
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+
+	assert.Contains(t, runner.Commands, "crontab -u bob -r")
+}
+
+func TestCrontabAction_Check(t *testing.T) {
+	runner, _ := setupServiceTest(t)
+	runner.Responses[":crontab -u alice -l"] = []byte("# BEGIN summit\n@daily cleanup\n# END summit\n")
+
+	done, err := (&CrontabAction{User: "alice", Content: "@daily cleanup\n"}).Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	done, err = (&CrontabAction{User: "alice", Content: "@hourly cleanup\n"}).Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done)
+}
+
+func TestCrontabAction_Removals(t *testing.T) {
+	action := &CrontabAction{
+		User:    "alice",
+		Current: "# jobs\n0 3 * * * backup.sh\n@daily cleanup\n\n*/5 * * * * fetchmail\n",
+		Content: "# jobs\n@daily cleanup\n@hourly sync\n",
+	}
+	key, n := action.Removals()
+	assert.Equal(t, "max-cron-removals", key)
+	assert.Equal(t, 2, n)
+}
@@ -3,24 +3,32 @@ package actions
 import (
 	"fmt"
 	"os"
-	"os/user"
-	"strconv"
 	"strings"
 	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/secrets"
 	"summit/pkg/system"
 	"syscall"
 
-	"github.com/sergi/go-diff/diffmatchpatch"
 	"github.com/spf13/afero"
 )
 
+// Secrets expands the secret://name references in file contents when files
+// are written. Commands install one built from the config's secrets provider.
+var Secrets = secrets.NewResolver(secrets.EnvProvider{Prefix: secrets.DefaultEnvPrefix})
+
 // FileCreateAction creates a file.
 type FileCreateAction struct {
-	Path    string
-	Content string
-	Mode    string
-	Owner   string
-	Group   string
+	Path         string
+	Content      string
+	Mode         string
+	Owner        string
+	Group        string
+	OwnerPackage string // Package owning the file being overwritten, if any
+}
+
+func (a *FileCreateAction) Type() string {
+	return "file.create"
 }
 
 func (a *FileCreateAction) Description() string {
@@ -29,40 +37,32 @@ func (a *FileCreateAction) Description() string {
 
 func (a *FileCreateAction) Apply(runner system.CommandRunner, logger log.Logger) error {
 	logger.Info("Creating file", "path", a.Path, "owner", a.Owner, "group", a.Group, "mode", a.Mode)
-	if err := afero.WriteFile(system.AppFs, a.Path, []byte(a.Content), 0644); err != nil {
+	modeStr := a.Mode
+	if modeStr == "" {
+		modeStr = model.DefaultFileMode
+	}
+	mode, err := model.ParseMode(modeStr)
+	if err != nil {
 		return err
 	}
+	content, err := Secrets.Expand(a.Content)
+	if err != nil {
+		return err
+	}
+	if err := afero.WriteFile(system.AppFs, a.Path, []byte(content), mode); err != nil {
+		return err
+	}
+	// An explicit mode must not be narrowed by the process umask
 	if a.Mode != "" {
-		mode, err := strconv.ParseUint(a.Mode, 8, 32)
-		if err != nil {
-			return err
-		}
-		if err := system.AppFs.Chmod(a.Path, os.FileMode(mode)); err != nil {
+		if err := system.AppFs.Chmod(a.Path, mode); err != nil {
 			return err
 		}
 	}
 	if a.Owner != "" || a.Group != "" {
-		var uid, gid int
-		if a.Owner != "" {
-			u, err := user.Lookup(a.Owner)
-			if err != nil {
-				return err
-			}
-			uid, _ = strconv.Atoi(u.Uid)
-		} else {
-			uid = -1 // Keep current owner
-		}
-
-		if a.Group != "" {
-			g, err := user.LookupGroup(a.Group)
-			if err != nil {
-				return err
-			}
-			gid, _ = strconv.Atoi(g.Gid)
-		} else {
-			gid = -1 // Keep current group
+		uid, gid, err := Resolver.Ownership(a.Owner, a.Group)
+		if err != nil {
+			return err
 		}
-
 		if err := system.AppFs.Chown(a.Path, uid, gid); err != nil {
 			return err
 		}
@@ -80,22 +80,54 @@ func (a *FileCreateAction) Rollback(runner system.CommandRunner, logger log.Logg
 }
 
 func (a *FileCreateAction) ExecutionDetails() []string {
-	details := []string{fmt.Sprintf("create file: %s with permissions %s", a.Path, a.Mode)}
+	mode := a.Mode
+	if mode == "" {
+		mode = model.DefaultFileMode + " (default)"
+	}
+	details := []string{fmt.Sprintf("create file: %s with permissions %s", a.Path, mode)}
 	if a.Owner != "" {
 		details = append(details, fmt.Sprintf("set owner to %s", a.Owner))
 	}
 	if a.Group != "" {
 		details = append(details, fmt.Sprintf("set group to %s", a.Group))
 	}
+	if a.OwnerPackage != "" {
+		details = append(details, fmt.Sprintf("overrides file owned by package %s", a.OwnerPackage))
+	}
 	return details
 }
 
+func (a *FileCreateAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("delete file: %s", a.Path)}
+}
+
+func (a *FileCreateAction) Check(runner system.CommandRunner) (bool, error) {
+	if a.Mode != "" || a.Owner != "" || a.Group != "" {
+		// Only an exact content match is cheap to verify; let Apply set attributes
+		return false, nil
+	}
+	content, err := Secrets.Expand(a.Content)
+	if err != nil {
+		return false, err
+	}
+	return fileHasContent(a.Path, content)
+}
+
+// RedactedDiff replaces the diff of a sensitive file in plan details.
+const RedactedDiff = "content changed (redacted)"
+
 // FileUpdateAction updates a file.
 type FileUpdateAction struct {
-	Path        string
-	NewContent  string
-	origContent string
-	origMode    os.FileMode
+	Path         string
+	NewContent   string
+	OwnerPackage string // Package owning the file, if any
+	Sensitive    bool   // Content and diff are never shown
+	origContent  string
+	origMode     os.FileMode
+}
+
+func (a *FileUpdateAction) Type() string {
+	return "file.update"
 }
 
 func (a *FileUpdateAction) Description() string {
@@ -104,6 +136,10 @@ func (a *FileUpdateAction) Description() string {
 
 func (a *FileUpdateAction) Apply(runner system.CommandRunner, logger log.Logger) error {
 	logger.Info("Updating file content", "path", a.Path)
+	newContent, err := Secrets.Expand(a.NewContent)
+	if err != nil {
+		return err
+	}
 	info, err := system.AppFs.Stat(a.Path)
 	if err != nil {
 		return err
@@ -114,7 +150,7 @@ func (a *FileUpdateAction) Apply(runner system.CommandRunner, logger log.Logger)
 		return err
 	}
 	a.origContent = string(content)
-	return afero.WriteFile(system.AppFs, a.Path, []byte(a.NewContent), a.origMode)
+	return afero.WriteFile(system.AppFs, a.Path, []byte(newContent), a.origMode)
 }
 
 func (a *FileUpdateAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
@@ -127,14 +163,29 @@ func (a *FileUpdateAction) Rollback(runner system.CommandRunner, logger log.Logg
 }
 
 func (a *FileUpdateAction) ExecutionDetails() []string {
-	dmp := diffmatchpatch.New()
-	diffs := dmp.DiffMain(a.origContent, a.NewContent, false)
-	return []string{
-		fmt.Sprintf("update file: %s", a.Path),
-		"--- diff ---",
-		dmp.DiffPrettyText(diffs),
-		"--- end diff ---",
+	details := []string{fmt.Sprintf("update file: %s", a.Path)}
+	if a.OwnerPackage != "" {
+		details = append(details, fmt.Sprintf("overrides file owned by package %s", a.OwnerPackage))
+	}
+	if a.Sensitive {
+		return append(details, RedactedDiff)
+	}
+	details = append(details, "--- diff ---")
+	// The previous content may hold secret values the new one references
+	details = append(details, lineDiff(Secrets.Redact(a.origContent), a.NewContent, DiffContextLines, DiffMaxLines)...)
+	return append(details, "--- end diff ---")
+}
+
+func (a *FileUpdateAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("restore the previous content and mode of %s", a.Path)}
+}
+
+func (a *FileUpdateAction) Check(runner system.CommandRunner) (bool, error) {
+	content, err := Secrets.Expand(a.NewContent)
+	if err != nil {
+		return false, err
 	}
+	return fileHasContent(a.Path, content)
 }
 
 // FileDeleteAction deletes a file.
@@ -146,6 +197,14 @@ type FileDeleteAction struct {
 	origGroup   string
 }
 
+func (a *FileDeleteAction) Type() string {
+	return "file.delete"
+}
+
+func (a *FileDeleteAction) Removals() (string, int) {
+	return model.LimitFileDeletions, 1
+}
+
 func (a *FileDeleteAction) Description() string {
 	return fmt.Sprintf("Delete file %s", a.Path)
 }
@@ -159,14 +218,8 @@ func (a *FileDeleteAction) Apply(runner system.CommandRunner, logger log.Logger)
 	a.origMode = info.Mode()
 	stat, ok := info.Sys().(*syscall.Stat_t)
 	if ok {
-		u, err := user.LookupId(fmt.Sprint(stat.Uid))
-		if err == nil {
-			a.origOwner = u.Username
-		}
-		g, err := user.LookupGroupId(fmt.Sprint(stat.Gid))
-		if err == nil {
-			a.origGroup = g.Name
-		}
+		a.origOwner, _ = Resolver.UserName(int(stat.Uid))
+		a.origGroup, _ = Resolver.GroupName(int(stat.Gid))
 	}
 
 	content, err := afero.ReadFile(system.AppFs, a.Path)
@@ -187,26 +240,10 @@ func (a *FileDeleteAction) Rollback(runner system.CommandRunner, logger log.Logg
 	// Rollback ownership
 	if a.origOwner != "" || a.origGroup != "" {
 		logger.Info("Rolling back file ownership", "path", a.Path, "owner", a.origOwner, "group", a.origGroup)
-		var uid, gid int
-		if a.origOwner != "" {
-			u, err := user.Lookup(a.origOwner)
-			if err != nil {
-				logger.Error("Failed to lookup original owner for rollback", "user", a.origOwner, "error", err)
-				return err
-			}
-			uid, _ = strconv.Atoi(u.Uid)
-		} else {
-			uid = -1
-		}
-		if a.origGroup != "" {
-			g, err := user.LookupGroup(a.origGroup)
-			if err != nil {
-				logger.Error("Failed to lookup original group for rollback", "group", a.origGroup, "error", err)
-				return err
-			}
-			gid, _ = strconv.Atoi(g.Gid)
-		} else {
-			gid = -1
+		uid, gid, err := Resolver.Ownership(a.origOwner, a.origGroup)
+		if err != nil {
+			logger.Error("Failed to lookup original ownership for rollback", "user", a.origOwner, "group", a.origGroup, "error", err)
+			return err
 		}
 		if err := system.AppFs.Chown(a.Path, uid, gid); err != nil {
 			logger.Error("Failed to chown file during rollback", "path", a.Path, "error", err)
@@ -220,47 +257,81 @@ func (a *FileDeleteAction) ExecutionDetails() []string {
 	return []string{fmt.Sprintf("delete file: %s", a.Path)}
 }
 
-// FileRevertAction reverts a file to its package-provided state.
+func (a *FileDeleteAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("recreate file %s with its previous content, mode, owner and group", a.Path)}
+}
+
+func (a *FileDeleteAction) Check(runner system.CommandRunner) (bool, error) {
+	exists, err := fileExists(a.Path)
+	return !exists, err
+}
+
+// FileRevertAction reverts a file to its package-provided state by extracting
+// it from the package's cached apk.
 type FileRevertAction struct {
-	Path            string
-	OwnerPackage    string
+	Path         string
+	OwnerPackage string
+	Version      string // Installed version of OwnerPackage, recorded by Resolve
+	CachedApk    string // Cached apk the file is extracted from, recorded by Resolve
+
 	modifiedContent string
 }
 
+func (a *FileRevertAction) Type() string {
+	return "file.revert"
+}
+
 func (a *FileRevertAction) Description() string {
 	return fmt.Sprintf("Revert file %s to state from package %s", a.Path, a.OwnerPackage)
 }
 
-func (a *FileRevertAction) Apply(runner system.CommandRunner, logger log.Logger) error {
-	logger.Info("Reverting file to package version", "path", a.Path, "package", a.OwnerPackage)
-	content, err := afero.ReadFile(system.AppFs, a.Path)
-	if err != nil {
-		return err
-	}
-	a.modifiedContent = string(content)
-
-	// Get package version
+// Resolve looks up the installed version of the owning package and checks
+// that its apk is cached, so the plan names the exact archive Apply uses.
+func (a *FileRevertAction) Resolve(runner system.CommandRunner) error {
 	out, err := runner.Run("", fmt.Sprintf("apk info %s", a.OwnerPackage))
 	if err != nil {
 		return fmt.Errorf("could not get package info for %s: %w", a.OwnerPackage, err)
 	}
 	// The output of apk info is like: `musl-1.2.4_git20230717-r4 description:`
-	// We want to extract the version, which is everything after the first hyphen.
-	parts := strings.SplitN(string(out), "-", 2)
-	if len(parts) < 2 {
+	// The version is what follows the package name and a hyphen.
+	nameVersion := strings.Fields(string(out))
+	if len(nameVersion) == 0 {
 		return fmt.Errorf("could not parse package version from: %s", string(out))
 	}
-	version := strings.Split(parts[1], " ")[0]
+	version, ok := strings.CutPrefix(nameVersion[0], a.OwnerPackage+"-")
+	if !ok {
+		parts := strings.SplitN(nameVersion[0], "-", 2)
+		if len(parts) < 2 {
+			return fmt.Errorf("could not parse package version from: %s", string(out))
+		}
+		version = parts[1]
+	}
 
-	// Construct path to cached apk
-	cachedApkPath := fmt.Sprintf("/var/cache/apk/%s-%s.apk", a.OwnerPackage, version)
+	cachedApk := fmt.Sprintf("/var/cache/apk/%s-%s.apk", a.OwnerPackage, version)
+	if _, err := system.AppFs.Stat(cachedApk); err != nil {
+		return fmt.Errorf("cached apk not found at %s: %w. You may need to run 'apk add --no-cache' to ensure packages are cached.", cachedApk, err)
+	}
+	a.Version = version
+	a.CachedApk = cachedApk
+	return nil
+}
 
-	// Check if the cached file exists
-	if _, err := system.AppFs.Stat(cachedApkPath); err != nil {
-		return fmt.Errorf("cached apk not found at %s: %w. You may need to run 'apk add --no-cache' to ensure packages are cached.", cachedApkPath, err)
+func (a *FileRevertAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Reverting file to package version", "path", a.Path, "package", a.OwnerPackage)
+	content, err := afero.ReadFile(system.AppFs, a.Path)
+	if err != nil {
+		return err
 	}
+	a.modifiedContent = string(content)
 
-	logger.Info("Found cached apk", "path", cachedApkPath)
+	if a.CachedApk == "" {
+		if err := a.Resolve(runner); err != nil {
+			return err
+		}
+	} else if _, err := system.AppFs.Stat(a.CachedApk); err != nil {
+		return fmt.Errorf("cached apk not found at %s: %w", a.CachedApk, err)
+	}
+	logger.Info("Found cached apk", "path", a.CachedApk)
 
 	// Create temp dir
 	tempDir, err := afero.TempDir(system.AppFs, "", "summit-apk-")
@@ -272,7 +343,7 @@ func (a *FileRevertAction) Apply(runner system.CommandRunner, logger log.Logger)
 	// Extract file
 	// The path in the archive is relative, but a.Path is absolute. We need to strip the leading "/"
 	relPath := strings.TrimPrefix(a.Path, "/")
-	_, err = runner.Run("", fmt.Sprintf("tar -xzf %s -C %s %s", cachedApkPath, tempDir, relPath))
+	_, err = runner.Run("", fmt.Sprintf("tar -xzf %s -C %s %s", a.CachedApk, tempDir, relPath))
 	if err != nil {
 		return fmt.Errorf("could not extract file from package: %w", err)
 	}
@@ -292,7 +363,23 @@ func (a *FileRevertAction) Rollback(runner system.CommandRunner, logger log.Logg
 }
 
 func (a *FileRevertAction) ExecutionDetails() []string {
-	return []string{fmt.Sprintf("run: apk fix --reinstall %s", a.OwnerPackage)}
+	relPath := strings.TrimPrefix(a.Path, "/")
+	if a.CachedApk == "" {
+		return []string{
+			fmt.Sprintf("run: apk info %s (to find the installed version)", a.OwnerPackage),
+			fmt.Sprintf("run: tar -xzf /var/cache/apk/%s-<version>.apk -C <tmpdir> %s", a.OwnerPackage, relPath),
+			fmt.Sprintf("replace %s with the extracted file", a.Path),
+		}
+	}
+	return []string{
+		fmt.Sprintf("extract from %s %s (cached apk %s)", a.OwnerPackage, a.Version, a.CachedApk),
+		fmt.Sprintf("run: tar -xzf %s -C <tmpdir> %s", a.CachedApk, relPath),
+		fmt.Sprintf("replace %s with the extracted file", a.Path),
+	}
+}
+
+func (a *FileRevertAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("restore the locally modified content of %s", a.Path)}
 }
 
 // FileChmodAction changes the mode of a file.
@@ -302,6 +389,10 @@ type FileChmodAction struct {
 	origMode os.FileMode
 }
 
+func (a *FileChmodAction) Type() string {
+	return "file.chmod"
+}
+
 func (a *FileChmodAction) Description() string {
 	return fmt.Sprintf("Chmod file %s to %s", a.Path, a.Mode)
 }
@@ -313,11 +404,11 @@ func (a *FileChmodAction) Apply(runner system.CommandRunner, logger log.Logger)
 		return err
 	}
 	a.origMode = info.Mode()
-	mode, err := strconv.ParseUint(a.Mode, 8, 32)
+	mode, err := model.ParseMode(a.Mode)
 	if err != nil {
 		return err
 	}
-	return system.AppFs.Chmod(a.Path, os.FileMode(mode))
+	return system.AppFs.Chmod(a.Path, mode)
 }
 
 func (a *FileChmodAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
@@ -333,6 +424,21 @@ func (a *FileChmodAction) ExecutionDetails() []string {
 	return []string{fmt.Sprintf("chmod file %s to %s", a.Path, a.Mode)}
 }
 
+func (a *FileChmodAction) RollbackDetails() []string {
+	if info, err := system.AppFs.Stat(a.Path); err == nil {
+		return []string{fmt.Sprintf("chmod file %s to %s", a.Path, model.FormatMode(info.Mode()))}
+	}
+	return []string{fmt.Sprintf("chmod file %s back to its previous mode", a.Path)}
+}
+
+func (a *FileChmodAction) Check(runner system.CommandRunner) (bool, error) {
+	info, err := system.AppFs.Stat(a.Path)
+	if err != nil {
+		return false, nil
+	}
+	return model.NormalizeMode(a.Mode) == model.FormatMode(info.Mode()), nil
+}
+
 // FileChownAction changes the owner of a file.
 type FileChownAction struct {
 	Path      string
@@ -342,6 +448,10 @@ type FileChownAction struct {
 	origGroup string
 }
 
+func (a *FileChownAction) Type() string {
+	return "file.chown"
+}
+
 func (a *FileChownAction) Description() string {
 	return fmt.Sprintf("Chown file %s to %s:%s", a.Path, a.Owner, a.Group)
 }
@@ -357,34 +467,12 @@ func (a *FileChownAction) Apply(runner system.CommandRunner, logger log.Logger)
 	if !ok {
 		return fmt.Errorf("could not get syscall.Stat_t for %s", a.Path)
 	}
-	u, err := user.LookupId(fmt.Sprint(stat.Uid))
-	if err == nil {
-		a.origOwner = u.Username
-	}
-	g, err := user.LookupGroupId(fmt.Sprint(stat.Gid))
-	if err == nil {
-		a.origGroup = g.Name
-	}
+	a.origOwner, _ = Resolver.UserName(int(stat.Uid))
+	a.origGroup, _ = Resolver.GroupName(int(stat.Gid))
 
-	var uid, gid int
-	if a.Owner != "" {
-		u, err := user.Lookup(a.Owner)
-		if err != nil {
-			return err
-		}
-		uid, _ = strconv.Atoi(u.Uid)
-	} else {
-		uid = -1 // Keep current owner
-	}
-
-	if a.Group != "" {
-		g, err := user.LookupGroup(a.Group)
-		if err != nil {
-			return err
-		}
-		gid, _ = strconv.Atoi(g.Gid)
-	} else {
-		gid = -1 // Keep current group
+	uid, gid, err := Resolver.Ownership(a.Owner, a.Group)
+	if err != nil {
+		return err
 	}
 
 	return system.AppFs.Chown(a.Path, uid, gid)
@@ -392,30 +480,13 @@ func (a *FileChownAction) Apply(runner system.CommandRunner, logger log.Logger)
 
 func (a *FileChownAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
 	logger.Info("Rolling back file ownership", "path", a.Path, "owner", a.origOwner, "group", a.origGroup)
-	var uid, gid int
-	if a.origOwner != "" {
-		u, err := user.Lookup(a.origOwner)
-		if err != nil {
-			logger.Error("Failed to lookup original owner for rollback", "user", a.origOwner, "error", err)
-			return err
-		}
-		uid, _ = strconv.Atoi(u.Uid)
-	} else {
-		uid = -1 // Keep current owner
-	}
-
-	if a.origGroup != "" {
-		g, err := user.LookupGroup(a.origGroup)
-		if err != nil {
-			logger.Error("Failed to lookup original group for rollback", "group", a.origGroup, "error", err)
-			return err
-		}
-		gid, _ = strconv.Atoi(g.Gid)
-	} else {
-		gid = -1 // Keep current group
+	uid, gid, err := Resolver.Ownership(a.origOwner, a.origGroup)
+	if err != nil {
+		logger.Error("Failed to lookup original ownership for rollback", "user", a.origOwner, "group", a.origGroup, "error", err)
+		return err
 	}
 
-	err := system.AppFs.Chown(a.Path, uid, gid)
+	err = system.AppFs.Chown(a.Path, uid, gid)
 	if err != nil {
 		logger.Error("Failed to chown file during rollback", "path", a.Path, "error", err)
 	}
@@ -425,3 +496,7 @@ func (a *FileChownAction) Rollback(runner system.CommandRunner, logger log.Logge
 func (a *FileChownAction) ExecutionDetails() []string {
 	return []string{fmt.Sprintf("chown file %s to %s:%s", a.Path, a.Owner, a.Group)}
 }
+
+func (a *FileChownAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("chown file %s back to its previous owner and group", a.Path)}
+}
@@ -291,3 +291,23 @@ func TestFileRevertAction_Apply_ErrorCases(t *testing.T) {
 		})
 	}
 }
+
+func TestFileRevertAction_Resolve(t *testing.T) {
+	system.AppFs = afero.NewMemMapFs()
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk info py3-yaml", []byte("py3-yaml-6.0.1-r3 description:\nYAML parser\n"))
+	action := &FileRevertAction{Path: "/etc/test.conf", OwnerPackage: "py3-yaml"}
+
+	err := action.Resolve(runner)
+	require.Error(t, err, "the apk is not cached yet")
+	assert.Contains(t, action.ExecutionDetails()[0], "run: apk info py3-yaml")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/var/cache/apk/py3-yaml-6.0.1-r3.apk", []byte("apk"), 0644))
+	require.NoError(t, action.Resolve(runner))
+	assert.Equal(t, "6.0.1-r3", action.Version)
+	assert.Equal(t, []string{
+		"extract from py3-yaml 6.0.1-r3 (cached apk /var/cache/apk/py3-yaml-6.0.1-r3.apk)",
+		"run: tar -xzf /var/cache/apk/py3-yaml-6.0.1-r3.apk -C <tmpdir> etc/test.conf",
+		"replace /etc/test.conf with the extracted file",
+	}, action.ExecutionDetails())
+}
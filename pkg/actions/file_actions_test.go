@@ -3,9 +3,12 @@ package actions
 import (
 	"bytes"
 	"log/slog"
+	"strings"
 	"testing"
 
 	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/secrets"
 	"summit/pkg/system"
 
 	"github.com/spf13/afero"
@@ -31,6 +34,10 @@ func (r *MockCommandRunner) Run(user, command string) ([]byte, error) {
 	return nil, nil
 }
 
+func (r *MockCommandRunner) RunInput(user, command string, input []byte) ([]byte, error) {
+	return r.Run(user, command)
+}
+
 func setupFileTest(t *testing.T) (*MockCommandRunner, log.Logger) {
 	system.AppFs = afero.NewMemMapFs()
 	runner := &MockCommandRunner{
@@ -111,6 +118,18 @@ func TestFileCreateAction_ExecutionDetails(t *testing.T) {
 	assert.Contains(t, details, "set group to root")
 }
 
+func TestFileCreateAction_DefaultMode(t *testing.T) {
+	runner, logger := setupFileTest(t)
+
+	action := &FileCreateAction{Path: "/etc/motd", Content: "hi"}
+	require.NoError(t, action.Apply(runner, logger))
+
+	info, err := system.AppFs.Stat("/etc/motd")
+	require.NoError(t, err)
+	assert.Equal(t, "-rw-r--r--", info.Mode().String())
+	assert.Equal(t, []string{"create file: /etc/motd with permissions 0644 (default)"}, action.ExecutionDetails())
+}
+
 func TestFileUpdateAction_Apply(t *testing.T) {
 	runner, logger := setupFileTest(t)
 
@@ -166,6 +185,11 @@ func TestFileUpdateAction_Description(t *testing.T) {
 	assert.Equal(t, "Update file /etc/motd", action.Description())
 }
 
+func TestFileUpdateAction_SensitiveDetails(t *testing.T) {
+	action := &FileUpdateAction{Path: "/etc/wireguard/wg0.conf", NewContent: "PrivateKey = abc\n", Sensitive: true}
+	assert.Equal(t, []string{"update file: /etc/wireguard/wg0.conf", RedactedDiff}, action.ExecutionDetails())
+}
+
 func TestFileDeleteAction_Apply(t *testing.T) {
 	runner, logger := setupFileTest(t)
 
@@ -238,6 +262,21 @@ func TestFileChmodAction_Apply(t *testing.T) {
 	assert.NotZero(t, action.origMode) // origMode should be set to some value
 }
 
+func TestFileChmodAction_SpecialBits(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/usr/local/bin/tool", []byte("#!/bin/sh\n"), 0755))
+
+	action := &FileChmodAction{Path: "/usr/local/bin/tool", Mode: "4755"}
+	require.NoError(t, action.Apply(runner, logger))
+
+	info, err := system.AppFs.Stat("/usr/local/bin/tool")
+	require.NoError(t, err)
+	assert.Equal(t, "4755", model.FormatMode(info.Mode()))
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+}
+
 func TestFileChmodAction_Rollback(t *testing.T) {
 	runner, logger := setupFileTest(t)
 
@@ -265,3 +304,32 @@ func TestFileChmodAction_Description(t *testing.T) {
 	action := &FileChmodAction{Path: "/etc/motd", Mode: "0755"}
 	assert.Equal(t, "Chmod file /etc/motd to 0755", action.Description())
 }
+
+func TestFileActions_ExpandSecrets(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	t.Setenv("SUMMIT_SECRET_DB", "hunter2")
+	previous := Secrets
+	t.Cleanup(func() { Secrets = previous })
+	Secrets = secrets.NewResolver(secrets.EnvProvider{Prefix: secrets.DefaultEnvPrefix})
+
+	create := &FileCreateAction{Path: "/etc/app.conf", Content: "password=secret://db\n"}
+	require.NoError(t, create.Apply(runner, logger))
+	content, err := afero.ReadFile(system.AppFs, "/etc/app.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "password=hunter2\n", string(content))
+	done, err := create.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	update := &FileUpdateAction{Path: "/etc/app.conf", NewContent: "user=app\npassword=secret://db\n"}
+	require.NoError(t, update.Apply(runner, logger))
+	content, err = afero.ReadFile(system.AppFs, "/etc/app.conf")
+	require.NoError(t, err)
+	assert.Equal(t, "user=app\npassword=hunter2\n", string(content))
+
+	// Neither the written nor the previous content shows the value
+	assert.NotContains(t, strings.Join(update.ExecutionDetails(), "\n"), "hunter2")
+
+	missing := &FileCreateAction{Path: "/etc/other.conf", Content: "token=secret://missing\n"}
+	assert.ErrorContains(t, missing.Apply(runner, logger), "SUMMIT_SECRET_MISSING")
+}
@@ -0,0 +1,70 @@
+package actions
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// HealthcheckInterval is how long to wait between two probes of a service's
+// healthcheck, and how long a single TCP or HTTP probe may take. It is a
+// variable so tests can shorten it.
+var HealthcheckInterval = time.Second
+
+// waitHealthy polls the healthcheck of a started service until it passes,
+// failing with the last probe error once the timeout runs out.
+func waitHealthy(service string, check *model.HealthcheckState, runner system.CommandRunner, logger log.Logger) error {
+	timeout := check.TimeoutDuration()
+	logger.Info("Waiting for service to become healthy", "service", service, "check", check.Label(), "timeout", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		err := probe(check, runner)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(HealthcheckInterval).After(deadline) {
+			return fmt.Errorf("service %s did not become healthy within %s: %w", service, timeout, err)
+		}
+		time.Sleep(HealthcheckInterval)
+	}
+}
+
+func probe(check *model.HealthcheckState, runner system.CommandRunner) error {
+	switch {
+	case check.TCP != "":
+		conn, err := net.DialTimeout("tcp", check.Address(), HealthcheckInterval)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case check.Command != "":
+		if _, err := runner.Run("", check.Command); err != nil {
+			return fmt.Errorf("command %q failed: %w", check.Command, err)
+		}
+		return nil
+	default:
+		client := &http.Client{Timeout: HealthcheckInterval}
+		resp, err := client.Get(check.HTTP)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned status %d", check.HTTP, resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// healthcheckDetails describes the wait for a service to become healthy.
+func healthcheckDetails(check *model.HealthcheckState) []string {
+	if check == nil || !StartServices {
+		return nil
+	}
+	return []string{fmt.Sprintf("wait: %s, up to %s", check.Label(), check.TimeoutDuration())}
+}
@@ -0,0 +1,137 @@
+package actions
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// IDResolver translates user and group names to numeric ids and back,
+// memoizing successful lookups. Names that are already numeric are used as-is,
+// which also lets summit run in minimal containers without NSS.
+type IDResolver struct {
+	mu         sync.Mutex
+	uids       map[string]int
+	gids       map[string]int
+	userNames  map[int]string
+	groupNames map[int]string
+
+	lookupUser    func(name string) (*user.User, error)
+	lookupGroup   func(name string) (*user.Group, error)
+	lookupUserID  func(uid string) (*user.User, error)
+	lookupGroupID func(gid string) (*user.Group, error)
+}
+
+// NewIDResolver creates a resolver backed by the os/user package.
+func NewIDResolver() *IDResolver {
+	return &IDResolver{
+		uids:          make(map[string]int),
+		gids:          make(map[string]int),
+		userNames:     make(map[int]string),
+		groupNames:    make(map[int]string),
+		lookupUser:    user.Lookup,
+		lookupGroup:   user.LookupGroup,
+		lookupUserID:  user.LookupId,
+		lookupGroupID: user.LookupGroupId,
+	}
+}
+
+// Resolver is shared by all file actions. executePlan installs a fresh one for
+// every run so lookups are cached for the duration of a single apply.
+var Resolver = NewIDResolver()
+
+// UID returns the numeric id for a user name or numeric uid string.
+func (r *IDResolver) UID(name string) (int, error) {
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if uid, ok := r.uids[name]; ok {
+		return uid, nil
+	}
+	u, err := r.lookupUser(name)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, fmt.Errorf("invalid uid %q for user %s: %w", u.Uid, name, err)
+	}
+	r.uids[name] = uid
+	return uid, nil
+}
+
+// GID returns the numeric id for a group name or numeric gid string.
+func (r *IDResolver) GID(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if gid, ok := r.gids[name]; ok {
+		return gid, nil
+	}
+	g, err := r.lookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gid %q for group %s: %w", g.Gid, name, err)
+	}
+	r.gids[name] = gid
+	return gid, nil
+}
+
+// UserName returns the name for a uid, or false if it cannot be resolved.
+func (r *IDResolver) UserName(uid int) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name, ok := r.userNames[uid]; ok {
+		return name, true
+	}
+	u, err := r.lookupUserID(strconv.Itoa(uid))
+	if err != nil {
+		return "", false
+	}
+	r.userNames[uid] = u.Username
+	return u.Username, true
+}
+
+// GroupName returns the name for a gid, or false if it cannot be resolved.
+func (r *IDResolver) GroupName(gid int) (string, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if name, ok := r.groupNames[gid]; ok {
+		return name, true
+	}
+	g, err := r.lookupGroupID(strconv.Itoa(gid))
+	if err != nil {
+		return "", false
+	}
+	r.groupNames[gid] = g.Name
+	return g.Name, true
+}
+
+// Ownership resolves an owner and group for chown. Empty values map to -1,
+// which leaves the corresponding id unchanged.
+func (r *IDResolver) Ownership(owner, group string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner != "" {
+		id, err := r.UID(owner)
+		if err != nil {
+			return 0, 0, err
+		}
+		uid = id
+	}
+	if group != "" {
+		id, err := r.GID(group)
+		if err != nil {
+			return 0, 0, err
+		}
+		gid = id
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,78 @@
+package actions
+
+import (
+	"errors"
+	"os/user"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCountingResolver(lookups *int) *IDResolver {
+	r := NewIDResolver()
+	r.lookupUser = func(name string) (*user.User, error) {
+		*lookups++
+		if name == "root" {
+			return &user.User{Username: "root", Uid: "0"}, nil
+		}
+		return nil, user.UnknownUserError(name)
+	}
+	r.lookupGroup = func(name string) (*user.Group, error) {
+		*lookups++
+		if name == "wheel" {
+			return &user.Group{Name: "wheel", Gid: "10"}, nil
+		}
+		return nil, user.UnknownGroupError(name)
+	}
+	r.lookupUserID = func(uid string) (*user.User, error) {
+		*lookups++
+		return nil, errors.New("no nss")
+	}
+	return r
+}
+
+func TestIDResolver_MemoizesLookups(t *testing.T) {
+	lookups := 0
+	r := newCountingResolver(&lookups)
+
+	for i := 0; i < 3; i++ {
+		uid, gid, err := r.Ownership("root", "wheel")
+		require.NoError(t, err)
+		assert.Equal(t, 0, uid)
+		assert.Equal(t, 10, gid)
+	}
+	assert.Equal(t, 2, lookups)
+}
+
+func TestIDResolver_NumericBypassesLookup(t *testing.T) {
+	lookups := 0
+	r := newCountingResolver(&lookups)
+
+	uid, gid, err := r.Ownership("1000", "1001")
+	require.NoError(t, err)
+	assert.Equal(t, 1000, uid)
+	assert.Equal(t, 1001, gid)
+	assert.Equal(t, 0, lookups)
+}
+
+func TestIDResolver_EmptyKeepsCurrent(t *testing.T) {
+	uid, gid, err := NewIDResolver().Ownership("", "")
+	require.NoError(t, err)
+	assert.Equal(t, -1, uid)
+	assert.Equal(t, -1, gid)
+}
+
+func TestIDResolver_FailuresAreNotCached(t *testing.T) {
+	lookups := 0
+	r := newCountingResolver(&lookups)
+
+	_, err := r.UID("alice")
+	require.Error(t, err)
+	_, err = r.UID("alice")
+	require.Error(t, err)
+	assert.Equal(t, 2, lookups)
+
+	_, ok := r.UserName(1000)
+	assert.False(t, ok)
+}
@@ -0,0 +1,81 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// DiffContextLines is the number of unchanged lines shown around each change
+// in file diffs.
+var DiffContextLines = 3
+
+// DiffMaxLines caps the number of diff lines rendered for a single file so
+// dry-run output stays usable for very large files. Zero disables the cap.
+var DiffMaxLines = 4000
+
+type diffLine struct {
+	op   diffmatchpatch.Operation
+	text string
+}
+
+// lineDiff renders a unified-style, line-based diff between two texts. Only
+// changed lines and their surrounding context are emitted, and rendering stops
+// once maxLines have been produced.
+func lineDiff(oldText, newText string, context, maxLines int) []string {
+	dmp := diffmatchpatch.New()
+	a, b, lineArray := dmp.DiffLinesToChars(oldText, newText)
+	diffs := dmp.DiffCharsToLines(dmp.DiffMain(a, b, false), lineArray)
+
+	var lines []diffLine
+	for _, d := range diffs {
+		for _, text := range strings.SplitAfter(d.Text, "\n") {
+			if text == "" {
+				continue
+			}
+			lines = append(lines, diffLine{op: d.Type, text: strings.TrimSuffix(text, "\n")})
+		}
+	}
+
+	// Mark which lines are visible: every change plus its context window.
+	visible := make([]bool, len(lines))
+	for i, l := range lines {
+		if l.op == diffmatchpatch.DiffEqual {
+			continue
+		}
+		for j := max(0, i-context); j <= min(len(lines)-1, i+context); j++ {
+			visible[j] = true
+		}
+	}
+
+	var out []string
+	remaining := 0
+	prevVisible := false
+	for i, l := range lines {
+		if !visible[i] {
+			prevVisible = false
+			continue
+		}
+		if maxLines > 0 && len(out) >= maxLines {
+			remaining++
+			continue
+		}
+		if !prevVisible {
+			out = append(out, "@@")
+		}
+		prevVisible = true
+		switch l.op {
+		case diffmatchpatch.DiffInsert:
+			out = append(out, "+"+l.text)
+		case diffmatchpatch.DiffDelete:
+			out = append(out, "-"+l.text)
+		default:
+			out = append(out, " "+l.text)
+		}
+	}
+	if remaining > 0 {
+		out = append(out, fmt.Sprintf("... diff truncated, %d more lines", remaining))
+	}
+	return out
+}
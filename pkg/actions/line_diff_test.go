@@ -0,0 +1,65 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineDiff_ContextAroundChanges(t *testing.T) {
+	var oldLines, newLines []string
+	for i := 1; i <= 20; i++ {
+		oldLines = append(oldLines, fmt.Sprintf("line %d", i))
+		newLines = append(newLines, fmt.Sprintf("line %d", i))
+	}
+	newLines[4] = "line five"
+	newLines[15] = "line sixteen"
+
+	out := lineDiff(strings.Join(oldLines, "\n")+"\n", strings.Join(newLines, "\n")+"\n", 1, 0)
+
+	assert.Equal(t, []string{
+		"@@",
+		" line 4",
+		"-line 5",
+		"+line five",
+		" line 6",
+		"@@",
+		" line 15",
+		"-line 16",
+		"+line sixteen",
+		" line 17",
+	}, out)
+}
+
+func TestLineDiff_Identical(t *testing.T) {
+	assert.Empty(t, lineDiff("a\nb\n", "a\nb\n", 3, 0))
+}
+
+func TestLineDiff_Truncates(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&sb, "line %d\n", i)
+	}
+
+	out := lineDiff("", sb.String(), 3, 100)
+
+	assert.Len(t, out, 101)
+	assert.Equal(t, "@@", out[0])
+	assert.Equal(t, "+line 0", out[1])
+	assert.Equal(t, "... diff truncated, 9901 more lines", out[100])
+}
+
+func TestFileUpdateAction_ExecutionDetails(t *testing.T) {
+	action := &FileUpdateAction{Path: "/etc/motd", NewContent: "hello\n", origContent: "bye\n"}
+
+	assert.Equal(t, []string{
+		"update file: /etc/motd",
+		"--- diff ---",
+		"@@",
+		"-bye",
+		"+hello",
+		"--- end diff ---",
+	}, action.ExecutionDetails())
+}
@@ -4,15 +4,32 @@ import (
 	"fmt"
 	"strings"
 	"summit/pkg/log"
+	"summit/pkg/model"
 	"summit/pkg/system"
 )
 
-// PackageInstallAction installs a package.
+// PackageInstallAction installs a package with the host's package manager,
+// pinned to Version when set. With Update, the package is installed already
+// and only its pin changes, from PreviousVersion to Version. Pins are never
+// removed: a package declared without a version keeps whatever pin it has.
 type PackageInstallAction struct {
-	PackageName string
+	PackageName     string
+	Version         string
+	Update          bool
+	PreviousVersion string
+}
+
+func (a *PackageInstallAction) Type() string {
+	return "package.install"
 }
 
 func (a *PackageInstallAction) Description() string {
+	switch {
+	case a.Update:
+		return fmt.Sprintf("Pin package %s to version %s", a.PackageName, a.Version)
+	case a.Version != "":
+		return fmt.Sprintf("Install package %s version %s", a.PackageName, a.Version)
+	}
 	return fmt.Sprintf("Install package %s", a.PackageName)
 }
 
@@ -20,22 +37,41 @@ func (a *PackageInstallAction) Apply(runner system.CommandRunner, logger log.Log
 	if strings.TrimSpace(a.PackageName) == "" {
 		return fmt.Errorf("package name cannot be empty")
 	}
-	logger.Info("Installing package", "package", a.PackageName)
-	_, err := runner.Run("", fmt.Sprintf("apk add %s", a.PackageName))
+	logger.Info("Installing package", "package", a.PackageName, "version", a.Version)
+	_, err := runner.Run("", system.Packages.InstallCommand(a.PackageName, a.Version))
 	return err
 }
 
 func (a *PackageInstallAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
 	logger.Info("Rolling back package install", "package", a.PackageName)
-	_, err := runner.Run("", fmt.Sprintf("apk del %s", a.PackageName))
+	_, err := runner.Run("", a.rollbackCommand())
 	if err != nil {
 		logger.Error("Failed to roll back package install", "package", a.PackageName, "error", err)
 	}
 	return err
 }
 
+func (a *PackageInstallAction) rollbackCommand() string {
+	if a.Update {
+		return system.Packages.InstallCommand(a.PackageName, a.PreviousVersion)
+	}
+	return system.Packages.RemoveCommand(a.PackageName)
+}
+
 func (a *PackageInstallAction) ExecutionDetails() []string {
-	return []string{fmt.Sprintf("run: apk add %s", a.PackageName)}
+	return []string{"run: " + system.Packages.InstallCommand(a.PackageName, a.Version)}
+}
+
+func (a *PackageInstallAction) RollbackDetails() []string {
+	return []string{"run: " + a.rollbackCommand()}
+}
+
+func (a *PackageInstallAction) Check(runner system.CommandRunner) (bool, error) {
+	version, installed, err := installedVersion(runner, a.PackageName)
+	if err != nil || !installed {
+		return false, err
+	}
+	return a.Version == "" || version == a.Version, nil
 }
 
 // PackageRemoveAction removes a package.
@@ -43,6 +79,14 @@ type PackageRemoveAction struct {
 	PackageName string
 }
 
+func (a *PackageRemoveAction) Type() string {
+	return "package.remove"
+}
+
+func (a *PackageRemoveAction) Removals() (string, int) {
+	return model.LimitPackageRemovals, 1
+}
+
 func (a *PackageRemoveAction) Description() string {
 	return fmt.Sprintf("Remove package %s", a.PackageName)
 }
@@ -52,13 +96,13 @@ func (a *PackageRemoveAction) Apply(runner system.CommandRunner, logger log.Logg
 		return fmt.Errorf("package name cannot be empty")
 	}
 	logger.Info("Removing package", "package", a.PackageName)
-	_, err := runner.Run("", fmt.Sprintf("apk del %s", a.PackageName))
+	_, err := runner.Run("", system.Packages.RemoveCommand(a.PackageName))
 	return err
 }
 
 func (a *PackageRemoveAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
 	logger.Info("Rolling back package removal", "package", a.PackageName)
-	_, err := runner.Run("", fmt.Sprintf("apk add %s", a.PackageName))
+	_, err := runner.Run("", system.Packages.InstallCommand(a.PackageName, ""))
 	if err != nil {
 		logger.Error("Failed to roll back package removal", "package", a.PackageName, "error", err)
 	}
@@ -66,5 +110,181 @@ func (a *PackageRemoveAction) Rollback(runner system.CommandRunner, logger log.L
 }
 
 func (a *PackageRemoveAction) ExecutionDetails() []string {
-	return []string{fmt.Sprintf("run: apk del %s", a.PackageName)}
+	return []string{"run: " + system.Packages.RemoveCommand(a.PackageName)}
+}
+
+func (a *PackageRemoveAction) RollbackDetails() []string {
+	return []string{"run: " + system.Packages.InstallCommand(a.PackageName, "")}
+}
+
+func (a *PackageRemoveAction) Check(runner system.CommandRunner) (bool, error) {
+	_, installed, err := installedVersion(runner, a.PackageName)
+	return !installed, err
+}
+
+// VirtualPackageInstallAction installs packages as the dependencies of a
+// virtual package with apk add --virtual, so they can be removed as a unit.
+// With Update, the virtual package exists already and only the packages it
+// groups change, from PreviousPackages.
+type VirtualPackageInstallAction struct {
+	Name             string
+	Packages         []string
+	Update           bool
+	PreviousPackages []string
+}
+
+func (a *VirtualPackageInstallAction) Type() string {
+	return "package.virtual-install"
+}
+
+func (a *VirtualPackageInstallAction) Description() string {
+	if a.Update {
+		return fmt.Sprintf("Update virtual package %s to %s", a.Name, strings.Join(a.Packages, ", "))
+	}
+	return fmt.Sprintf("Install virtual package %s with %s", a.Name, strings.Join(a.Packages, ", "))
+}
+
+func (a *VirtualPackageInstallAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.Name) == "" {
+		return fmt.Errorf("virtual package name cannot be empty")
+	}
+	if len(a.Packages) == 0 {
+		return fmt.Errorf("virtual package %s has no packages", a.Name)
+	}
+	logger.Info("Installing virtual package", "package", a.Name, "packages", a.Packages)
+	_, err := runner.Run("", virtualAddCommand(a.Name, a.Packages))
+	return err
+}
+
+func (a *VirtualPackageInstallAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back virtual package install", "package", a.Name)
+	_, err := runner.Run("", a.rollbackCommand())
+	if err != nil {
+		logger.Error("Failed to roll back virtual package install", "package", a.Name, "error", err)
+	}
+	return err
+}
+
+func (a *VirtualPackageInstallAction) rollbackCommand() string {
+	if a.Update {
+		return virtualAddCommand(a.Name, a.PreviousPackages)
+	}
+	return "apk del " + a.Name
+}
+
+func (a *VirtualPackageInstallAction) ExecutionDetails() []string {
+	return []string{"run: " + virtualAddCommand(a.Name, a.Packages)}
+}
+
+func (a *VirtualPackageInstallAction) RollbackDetails() []string {
+	return []string{"run: " + a.rollbackCommand()}
+}
+
+func (a *VirtualPackageInstallAction) Check(runner system.CommandRunner) (bool, error) {
+	_, installed, err := installedVersion(runner, a.Name)
+	if err != nil || !installed {
+		return false, err
+	}
+	deps, err := system.VirtualDependencies(runner, a.Name)
+	if err != nil {
+		return false, err
+	}
+	return model.VirtualPackageState{Name: a.Name, Packages: a.Packages}.Groups(deps), nil
+}
+
+// VirtualPackageRemoveAction removes a virtual package along with the
+// packages it grouped that nothing else needs. Packages are the ones it
+// groups, installed again on rollback.
+type VirtualPackageRemoveAction struct {
+	Name     string
+	Packages []string
+}
+
+func (a *VirtualPackageRemoveAction) Type() string {
+	return "package.virtual-remove"
+}
+
+func (a *VirtualPackageRemoveAction) Removals() (string, int) {
+	return model.LimitPackageRemovals, 1
+}
+
+func (a *VirtualPackageRemoveAction) Description() string {
+	return fmt.Sprintf("Remove virtual package %s", a.Name)
+}
+
+func (a *VirtualPackageRemoveAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.Name) == "" {
+		return fmt.Errorf("virtual package name cannot be empty")
+	}
+	logger.Info("Removing virtual package", "package", a.Name)
+	_, err := runner.Run("", "apk del "+a.Name)
+	return err
+}
+
+func (a *VirtualPackageRemoveAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back virtual package removal", "package", a.Name)
+	_, err := runner.Run("", virtualAddCommand(a.Name, a.Packages))
+	if err != nil {
+		logger.Error("Failed to roll back virtual package removal", "package", a.Name, "error", err)
+	}
+	return err
+}
+
+func (a *VirtualPackageRemoveAction) ExecutionDetails() []string {
+	return []string{"run: apk del " + a.Name}
+}
+
+func (a *VirtualPackageRemoveAction) RollbackDetails() []string {
+	return []string{"run: " + virtualAddCommand(a.Name, a.Packages)}
+}
+
+func (a *VirtualPackageRemoveAction) Check(runner system.CommandRunner) (bool, error) {
+	_, installed, err := installedVersion(runner, a.Name)
+	return !installed, err
+}
+
+// virtualAddCommand returns the command installing packages as the virtual
+// package name, or replacing the packages it groups when it exists.
+func virtualAddCommand(name string, packages []string) string {
+	apk, _ := system.Packages.(system.Apk)
+	return apk.AddCommand(append([]string{"--virtual", name}, packages...)...)
+}
+
+// PackageIndexUpdateAction updates the package index before packages are
+// installed, so they are not looked up in an index listing versions the
+// mirrors no longer have. Reason tells why the plan updates it.
+type PackageIndexUpdateAction struct {
+	Reason string
+}
+
+func (a *PackageIndexUpdateAction) Type() string {
+	return "package.index-update"
+}
+
+func (a *PackageIndexUpdateAction) Description() string {
+	return "Update the package index"
+}
+
+func (a *PackageIndexUpdateAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Updating the package index", "reason", a.Reason)
+	_, err := runner.Run("", system.Packages.UpdateIndexCommand())
+	return err
+}
+
+// Rollback leaves the updated index in place: it only lists what the
+// repositories offer.
+func (a *PackageIndexUpdateAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	return nil
+}
+
+func (a *PackageIndexUpdateAction) ExecutionDetails() []string {
+	details := []string{"run: " + system.Packages.UpdateIndexCommand()}
+	if a.Reason != "" {
+		details = append(details, "reason: "+a.Reason)
+	}
+	return details
+}
+
+func (a *PackageIndexUpdateAction) RollbackDetails() []string {
+	return []string{"leave the updated package index in place"}
 }
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"summit/pkg/log"
+	"summit/pkg/system"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -56,6 +57,21 @@ func TestPackageInstallAction_ExecutionDetails(t *testing.T) {
 	assert.Equal(t, []string{"run: apk add htop"}, details)
 }
 
+func TestPackageInstallAction_Version(t *testing.T) {
+	runner, logger := setupPackageTest(t)
+
+	install := &PackageInstallAction{PackageName: "nginx", Version: "1.24.0-r7"}
+	assert.Equal(t, "Install package nginx version 1.24.0-r7", install.Description())
+	require.NoError(t, install.Apply(runner, logger))
+	require.NoError(t, install.Rollback(runner, logger))
+	assert.Equal(t, []string{"apk add nginx=1.24.0-r7", "apk del nginx"}, runner.Commands)
+
+	// Changing a pin rolls back to the previous one rather than removing the package
+	pin := &PackageInstallAction{PackageName: "nginx", Version: "1.24.0-r7", Update: true, PreviousVersion: "1.24.0-r6"}
+	assert.Equal(t, "Pin package nginx to version 1.24.0-r7", pin.Description())
+	assert.Equal(t, []string{"run: apk add nginx=1.24.0-r6"}, pin.RollbackDetails())
+}
+
 func TestPackageRemoveAction_Apply(t *testing.T) {
 	runner, logger := setupPackageTest(t)
 
@@ -90,3 +106,68 @@ func TestPackageRemoveAction_ExecutionDetails(t *testing.T) {
 	details := action.ExecutionDetails()
 	assert.Equal(t, []string{"run: apk del htop"}, details)
 }
+
+func TestPackageActions_OtherPackageManager(t *testing.T) {
+	runner, logger := setupPackageTest(t)
+	system.Packages = system.Apt{}
+	t.Cleanup(func() { system.Packages = system.Apk{} })
+	runner.Responses[":apt-mark showmanual"] = []byte("curl\nhtop\n")
+
+	install := &PackageInstallAction{PackageName: "htop"}
+	remove := &PackageRemoveAction{PackageName: "nano"}
+	require.NoError(t, install.Apply(runner, logger))
+	require.NoError(t, remove.Apply(runner, logger))
+	assert.Equal(t, []string{
+		"DEBIAN_FRONTEND=noninteractive apt-get install -y htop",
+		"DEBIAN_FRONTEND=noninteractive apt-get remove -y nano",
+	}, runner.Commands)
+	assert.Equal(t, []string{"run: DEBIAN_FRONTEND=noninteractive apt-get install -y nano"}, remove.RollbackDetails())
+
+	installed, err := install.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, installed)
+	removed, err := remove.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, removed)
+}
+
+func TestPackageIndexUpdateAction(t *testing.T) {
+	runner, logger := setupPackageTest(t)
+
+	action := &PackageIndexUpdateAction{Reason: "no package index"}
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+
+	assert.Equal(t, []string{"apk update"}, runner.Commands)
+	assert.Equal(t, "Update the package index", action.Description())
+	assert.Equal(t, []string{"run: apk update", "reason: no package index"}, action.ExecutionDetails())
+}
+
+func TestVirtualPackageInstallAction(t *testing.T) {
+	runner, logger := setupPackageTest(t)
+
+	install := &VirtualPackageInstallAction{Name: ".build-deps", Packages: []string{"gcc", "make"}}
+	require.NoError(t, install.Apply(runner, logger))
+	require.NoError(t, install.Rollback(runner, logger))
+	update := &VirtualPackageInstallAction{Name: ".build-deps", Packages: []string{"gcc"}, Update: true, PreviousPackages: []string{"gcc", "make"}}
+	require.NoError(t, update.Rollback(runner, logger))
+
+	assert.Equal(t, []string{
+		"apk add --virtual .build-deps gcc make",
+		"apk del .build-deps",
+		"apk add --virtual .build-deps gcc make",
+	}, runner.Commands)
+	assert.Equal(t, "Install virtual package .build-deps with gcc, make", install.Description())
+	assert.Equal(t, "Update virtual package .build-deps to gcc", update.Description())
+}
+
+func TestVirtualPackageRemoveAction(t *testing.T) {
+	runner, logger := setupPackageTest(t)
+
+	action := &VirtualPackageRemoveAction{Name: ".build-deps", Packages: []string{"gcc", "make"}}
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+
+	assert.Equal(t, []string{"apk del .build-deps", "apk add --virtual .build-deps gcc make"}, runner.Commands)
+	assert.Equal(t, []string{"run: apk add --virtual .build-deps gcc make"}, action.RollbackDetails())
+}
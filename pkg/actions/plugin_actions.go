@@ -0,0 +1,49 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/plugin"
+	"summit/pkg/system"
+)
+
+// PluginAction applies a change proposed by an external plugin.
+type PluginAction struct {
+	Plugin string
+	Change plugin.Change
+}
+
+func (a *PluginAction) Type() string {
+	return "plugin.change"
+}
+
+func (a *PluginAction) Description() string {
+	return fmt.Sprintf("[%s] %s", a.Plugin, a.Change.Description)
+}
+
+func (a *PluginAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.Plugin) == "" {
+		return fmt.Errorf("plugin name cannot be empty")
+	}
+	logger.Info("Applying plugin change", "plugin", a.Plugin, "change", a.Change.ID)
+	return plugin.Apply(runner, a.Plugin, a.Change)
+}
+
+func (a *PluginAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back plugin change", "plugin", a.Plugin, "change", a.Change.ID)
+	err := plugin.Rollback(runner, a.Plugin, a.Change)
+	if err != nil {
+		logger.Error("Failed to roll back plugin change", "plugin", a.Plugin, "change", a.Change.ID, "error", err)
+	}
+	return err
+}
+
+func (a *PluginAction) ExecutionDetails() []string {
+	details := []string{fmt.Sprintf("run plugin: %s apply %s", a.Plugin, a.Change.ID)}
+	return append(details, a.Change.Details...)
+}
+
+func (a *PluginAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("run plugin: %s rollback %s", a.Plugin, a.Change.ID)}
+}
@@ -0,0 +1,27 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"summit/pkg/plugin"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPluginAction_ApplyAndRollback(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	runner.Responses[":timeout 300 'summit-plugin-zfs'"] = []byte(`{}`)
+
+	action := &PluginAction{Plugin: "summit-plugin-zfs", Change: plugin.Change{ID: "c1", Description: "Create dataset tank/home", Data: json.RawMessage(`{}`)}}
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{"timeout 300 'summit-plugin-zfs'", "timeout 300 'summit-plugin-zfs'"}, runner.Commands)
+
+	runner.Errors[":timeout 300 'summit-plugin-zfs'"] = errors.New("exit status 124")
+	err := action.Apply(runner, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "plugin summit-plugin-zfs failed during apply: exit status 124")
+}
@@ -0,0 +1,141 @@
+package actions
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Constructor returns a new, zero-valued action ready to be populated.
+type Constructor func() Action
+
+var (
+	registryMu   sync.RWMutex
+	constructors = make(map[string]Constructor)
+)
+
+// Register makes an action type available for plan deserialization under the
+// identifier returned by its Type method. Register panics if the constructor is
+// nil or the identifier is already taken, mirroring how database/sql handles
+// duplicate drivers.
+func Register(ctor Constructor) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if ctor == nil {
+		panic("actions: Register constructor is nil")
+	}
+	name := ctor().Type()
+	if _, dup := constructors[name]; dup {
+		panic("actions: Register called twice for " + name)
+	}
+	constructors[name] = ctor
+}
+
+// New creates an empty action for a registered type name.
+func New(name string) (Action, error) {
+	registryMu.RLock()
+	ctor, ok := constructors[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown action type: %s", name)
+	}
+	return ctor(), nil
+}
+
+// RegisteredTypes returns all registered action type names, sorted.
+func RegisteredTypes() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(constructors))
+	for name := range constructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// serializedAction is the on-disk representation of a single plan entry.
+type serializedAction struct {
+	Type   string          `json:"type"`
+	Action json.RawMessage `json:"action"`
+}
+
+// MarshalPlan serializes a plan to JSON. Only exported action fields are
+// persisted; state captured during Apply for rollback is not.
+func MarshalPlan(plan []Action) ([]byte, error) {
+	entries := make([]serializedAction, 0, len(plan))
+	for _, action := range plan {
+		name := action.Type()
+		if _, err := New(name); err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(action)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal action %s: %w", name, err)
+		}
+		entries = append(entries, serializedAction{Type: name, Action: data})
+	}
+	return json.MarshalIndent(entries, "", "  ")
+}
+
+// UnmarshalPlan restores a plan previously produced by MarshalPlan.
+func UnmarshalPlan(data []byte) ([]Action, error) {
+	var entries []serializedAction
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse plan: %w", err)
+	}
+
+	plan := make([]Action, 0, len(entries))
+	for i, entry := range entries {
+		action, err := New(entry.Type)
+		if err != nil {
+			return nil, fmt.Errorf("plan entry %d: %w", i, err)
+		}
+		if err := json.Unmarshal(entry.Action, action); err != nil {
+			return nil, fmt.Errorf("plan entry %d (%s): %w", i, entry.Type, err)
+		}
+		plan = append(plan, action)
+	}
+	return plan, nil
+}
+
+func init() {
+	Register(func() Action { return &PackageInstallAction{} })
+	Register(func() Action { return &PackageRemoveAction{} })
+	Register(func() Action { return &VirtualPackageInstallAction{} })
+	Register(func() Action { return &VirtualPackageRemoveAction{} })
+	Register(func() Action { return &PackageIndexUpdateAction{} })
+	Register(func() Action { return &ServiceEnableAction{} })
+	Register(func() Action { return &ServiceDisableAction{} })
+	Register(func() Action { return &ServiceMoveAction{} })
+	Register(func() Action { return &ServiceStartAction{} })
+	Register(func() Action { return &ServiceStopAction{} })
+	Register(func() Action { return &ServiceRestartAction{} })
+	Register(func() Action { return &UserCreateAction{} })
+	Register(func() Action { return &UserRemoveAction{} })
+	Register(func() Action { return &UserModifyAction{} })
+	Register(func() Action { return &GroupCreateAction{} })
+	Register(func() Action { return &AddUserToGroupAction{} })
+	Register(func() Action { return &RemoveUserFromGroupAction{} })
+	Register(func() Action { return &UserPackageAction{} })
+	Register(func() Action { return &UserPackageBatchInstallAction{} })
+	Register(func() Action { return &FileCreateAction{} })
+	Register(func() Action { return &FileUpdateAction{} })
+	Register(func() Action { return &FileDeleteAction{} })
+	Register(func() Action { return &FileRevertAction{} })
+	Register(func() Action { return &FileChmodAction{} })
+	Register(func() Action { return &FileChownAction{} })
+	Register(func() Action { return &ManagedBlockAction{} })
+	Register(func() Action { return &ServiceOptionsAction{} })
+	Register(func() Action { return &CommandAction{} })
+	Register(func() Action { return &CrontabAction{} })
+	Register(func() Action { return &SysctlSetAction{} })
+	Register(func() Action { return &TimezoneSetAction{} })
+	Register(func() Action { return &AuthorizedKeyAddAction{} })
+	Register(func() Action { return &AuthorizedKeyRemoveAction{} })
+	Register(func() Action { return &UserConfigAction{} })
+	Register(func() Action { return &PluginAction{} })
+}
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"testing"
+
+	"summit/pkg/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisteredTypes_CoverInTreeActions(t *testing.T) {
+	types := RegisteredTypes()
+	assert.Contains(t, types, "package.install")
+	assert.Contains(t, types, "file.update")
+	assert.Contains(t, types, "userpackage.ensure")
+	assert.Contains(t, types, "plugin.change")
+
+	for _, name := range types {
+		action, err := New(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, action.Type(), "constructor for %s builds a different type", name)
+	}
+}
+
+type unregisteredAction struct{ PackageInstallAction }
+
+func (a *unregisteredAction) Type() string { return "test.unregistered" }
+
+func TestNew_UnknownType(t *testing.T) {
+	_, err := New("does.not.exist")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unknown action type")
+}
+
+func TestRegister_DuplicatePanics(t *testing.T) {
+	assert.Panics(t, func() {
+		Register(func() Action { return &PackageInstallAction{} })
+	})
+}
+
+func TestMarshalPlan_RoundTrip(t *testing.T) {
+	plan := []Action{
+		&PackageInstallAction{PackageName: "htop"},
+		&ServiceEnableAction{ServiceName: "sshd", Runlevel: "default"},
+		&FileCreateAction{Path: "/etc/motd", Content: "hello", Mode: "0644"},
+		&UserPackageAction{User: "alice", Manager: "pipx", Package: "ruff", State: model.PackageStatePresent},
+	}
+
+	data, err := MarshalPlan(plan)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"type": "service.enable"`)
+
+	restored, err := UnmarshalPlan(data)
+	require.NoError(t, err)
+	assert.Equal(t, plan, restored)
+}
+
+func TestMarshalPlan_RejectsUnregistered(t *testing.T) {
+	_, err := MarshalPlan([]Action{&unregisteredAction{}})
+	require.Error(t, err)
+}
+
+func TestUnmarshalPlan_UnknownType(t *testing.T) {
+	_, err := UnmarshalPlan([]byte(`[{"type": "bogus", "action": {}}]`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "plan entry 0")
+}
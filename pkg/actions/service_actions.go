@@ -2,15 +2,29 @@ package actions
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 	"summit/pkg/log"
+	"summit/pkg/model"
 	"summit/pkg/system"
 )
 
-// ServiceEnableAction enables and starts a service.
+// StartServices makes service actions start, stop and restart services in
+// addition to changing their runlevels. It is turned off when the init system
+// is not running, e.g. while building a container image.
+var StartServices = true
+
+// ServiceEnableAction enables and starts a service. With a healthcheck, the
+// service must also become healthy; otherwise it is stopped and disabled
+// again and the action fails.
 type ServiceEnableAction struct {
 	ServiceName string
 	Runlevel    string
+	Healthcheck *model.HealthcheckState
+}
+
+func (a *ServiceEnableAction) Type() string {
+	return "service.enable"
 }
 
 func (a *ServiceEnableAction) Description() string {
@@ -28,16 +42,30 @@ func (a *ServiceEnableAction) Apply(runner system.CommandRunner, logger log.Logg
 	if _, err := runner.Run("", fmt.Sprintf("rc-update add %s %s", a.ServiceName, a.Runlevel)); err != nil {
 		return err
 	}
-	_, err := runner.Run("", fmt.Sprintf("rc-service %s start", a.ServiceName))
-	return err
+	if !StartServices {
+		return nil
+	}
+	if _, err := runner.Run("", fmt.Sprintf("rc-service %s start", a.ServiceName)); err != nil {
+		return err
+	}
+	if a.Healthcheck == nil {
+		return nil
+	}
+	if err := waitHealthy(a.ServiceName, a.Healthcheck, runner, logger); err != nil {
+		_ = a.Rollback(runner, logger)
+		return err
+	}
+	return nil
 }
 
 func (a *ServiceEnableAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
 	logger.Info("Stopping and disabling service during rollback", "service", a.ServiceName)
 	var lastErr error
-	if _, err := runner.Run("", fmt.Sprintf("rc-service %s stop", a.ServiceName)); err != nil {
-		logger.Error("Failed to stop service during rollback", "service", a.ServiceName, "error", err)
-		lastErr = err
+	if StartServices {
+		if _, err := runner.Run("", fmt.Sprintf("rc-service %s stop", a.ServiceName)); err != nil {
+			logger.Error("Failed to stop service during rollback", "service", a.ServiceName, "error", err)
+			lastErr = err
+		}
 	}
 	if _, err := runner.Run("", fmt.Sprintf("rc-update del %s %s", a.ServiceName, a.Runlevel)); err != nil {
 		logger.Error("Failed to disable service during rollback", "service", a.ServiceName, "error", err)
@@ -47,10 +75,31 @@ func (a *ServiceEnableAction) Rollback(runner system.CommandRunner, logger log.L
 }
 
 func (a *ServiceEnableAction) ExecutionDetails() []string {
-	return []string{
-		fmt.Sprintf("run: rc-update add %s %s", a.ServiceName, a.Runlevel),
-		fmt.Sprintf("run: rc-service %s start", a.ServiceName),
+	details := []string{fmt.Sprintf("run: rc-update add %s %s", a.ServiceName, a.Runlevel)}
+	if StartServices {
+		details = append(details, fmt.Sprintf("run: rc-service %s start", a.ServiceName))
+	}
+	return append(details, healthcheckDetails(a.Healthcheck)...)
+}
+
+func (a *ServiceEnableAction) RollbackDetails() []string {
+	details := []string{}
+	if StartServices {
+		details = append(details, fmt.Sprintf("run: rc-service %s stop", a.ServiceName))
 	}
+	return append(details, fmt.Sprintf("run: rc-update del %s %s", a.ServiceName, a.Runlevel))
+}
+
+// Check reports whether the service is in its runlevel and, unless services
+// are not started, running: rc-service status fails for a stopped or crashed
+// service.
+func (a *ServiceEnableAction) Check(runner system.CommandRunner) (bool, error) {
+	enabled, err := runlevelHasService(a.Runlevel, a.ServiceName)
+	if err != nil || !enabled || !StartServices {
+		return enabled, err
+	}
+	_, err = runner.Run("", fmt.Sprintf("rc-service %s status", a.ServiceName))
+	return err == nil, nil
 }
 
 // ServiceDisableAction stops and disables a service.
@@ -59,6 +108,14 @@ type ServiceDisableAction struct {
 	Runlevel    string
 }
 
+func (a *ServiceDisableAction) Type() string {
+	return "service.disable"
+}
+
+func (a *ServiceDisableAction) Removals() (string, int) {
+	return model.LimitServiceDisables, 1
+}
+
 func (a *ServiceDisableAction) Description() string {
 	return fmt.Sprintf("Stop and disable service %s in runlevel %s", a.ServiceName, a.Runlevel)
 }
@@ -71,8 +128,10 @@ func (a *ServiceDisableAction) Apply(runner system.CommandRunner, logger log.Log
 		return fmt.Errorf("runlevel cannot be empty")
 	}
 	logger.Info("Stopping and disabling service", "service", a.ServiceName, "runlevel", a.Runlevel)
-	if _, err := runner.Run("", fmt.Sprintf("rc-service %s stop", a.ServiceName)); err != nil {
-		return err
+	if StartServices {
+		if _, err := runner.Run("", fmt.Sprintf("rc-service %s stop", a.ServiceName)); err != nil {
+			return err
+		}
 	}
 	_, err := runner.Run("", fmt.Sprintf("rc-update del %s %s", a.ServiceName, a.Runlevel))
 	return err
@@ -85,16 +144,348 @@ func (a *ServiceDisableAction) Rollback(runner system.CommandRunner, logger log.
 		logger.Error("Failed to enable service during rollback", "service", a.ServiceName, "error", err)
 		lastErr = err
 	}
-	if _, err := runner.Run("", fmt.Sprintf("rc-service %s start", a.ServiceName)); err != nil {
-		logger.Error("Failed to start service during rollback", "service", a.ServiceName, "error", err)
-		lastErr = err
+	if StartServices {
+		if _, err := runner.Run("", fmt.Sprintf("rc-service %s start", a.ServiceName)); err != nil {
+			logger.Error("Failed to start service during rollback", "service", a.ServiceName, "error", err)
+			lastErr = err
+		}
 	}
 	return lastErr
 }
 
 func (a *ServiceDisableAction) ExecutionDetails() []string {
-	return []string{
-		fmt.Sprintf("run: rc-service %s stop", a.ServiceName),
-		fmt.Sprintf("run: rc-update del %s %s", a.ServiceName, a.Runlevel),
+	details := []string{}
+	if StartServices {
+		details = append(details, fmt.Sprintf("run: rc-service %s stop", a.ServiceName))
+	}
+	return append(details, fmt.Sprintf("run: rc-update del %s %s", a.ServiceName, a.Runlevel))
+}
+
+func (a *ServiceDisableAction) RollbackDetails() []string {
+	details := []string{fmt.Sprintf("run: rc-update add %s %s", a.ServiceName, a.Runlevel)}
+	if StartServices {
+		details = append(details, fmt.Sprintf("run: rc-service %s start", a.ServiceName))
+	}
+	return details
+}
+
+func (a *ServiceDisableAction) Check(runner system.CommandRunner) (bool, error) {
+	if a.Runlevel == "" {
+		return false, nil
+	}
+	enabled, err := runlevelHasService(a.Runlevel, a.ServiceName)
+	return !enabled, err
+}
+
+// ServiceMoveAction moves an enabled service from the runlevels it is in to
+// To and, for a service declared in several runlevels, Also, leaving it
+// started or stopped as it is. Runlevels the service is already in are kept;
+// it is only removed from the others. Should adding it to a new runlevel
+// fail, it is put back in the old ones, so the service is never left out of
+// all of them.
+type ServiceMoveAction struct {
+	ServiceName string
+	From        []string
+	To          string
+	Also        []string
+}
+
+func (a *ServiceMoveAction) Type() string {
+	return "service.move"
+}
+
+func (a *ServiceMoveAction) Description() string {
+	switch {
+	case len(a.added()) == 0:
+		return fmt.Sprintf("Remove service %s from runlevel %s, keeping it in %s", a.ServiceName, strings.Join(a.others(), ", "), strings.Join(a.targets(), ", "))
+	case len(a.others()) == 0:
+		return fmt.Sprintf("Add service %s to runlevel %s", a.ServiceName, strings.Join(a.added(), ", "))
+	}
+	return fmt.Sprintf("Move service %s from runlevel %s to %s", a.ServiceName, strings.Join(a.From, ", "), strings.Join(a.targets(), ", "))
+}
+
+func (a *ServiceMoveAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.ServiceName) == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	if len(a.From) == 0 || strings.TrimSpace(a.To) == "" {
+		return fmt.Errorf("runlevel cannot be empty")
+	}
+	logger.Info("Moving service", "service", a.ServiceName, "from", strings.Join(a.From, ","), "to", strings.Join(a.targets(), ","))
+	var removed, added []string
+	for _, runlevel := range a.others() {
+		if _, err := runner.Run("", fmt.Sprintf("rc-update del %s %s", a.ServiceName, runlevel)); err != nil {
+			a.restore(runner, logger, removed, added)
+			return err
+		}
+		removed = append(removed, runlevel)
+	}
+	for _, runlevel := range a.added() {
+		if _, err := runner.Run("", fmt.Sprintf("rc-update add %s %s", a.ServiceName, runlevel)); err != nil {
+			a.restore(runner, logger, removed, added)
+			return err
+		}
+		added = append(added, runlevel)
+	}
+	return nil
+}
+
+func (a *ServiceMoveAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Moving service back during rollback", "service", a.ServiceName, "runlevels", strings.Join(a.From, ","))
+	var lastErr error
+	for _, runlevel := range a.added() {
+		if _, err := runner.Run("", fmt.Sprintf("rc-update del %s %s", a.ServiceName, runlevel)); err != nil {
+			logger.Error("Failed to remove service from runlevel during rollback", "service", a.ServiceName, "runlevel", runlevel, "error", err)
+			lastErr = err
+		}
+	}
+	for _, runlevel := range a.others() {
+		if _, err := runner.Run("", fmt.Sprintf("rc-update add %s %s", a.ServiceName, runlevel)); err != nil {
+			logger.Error("Failed to add service to runlevel during rollback", "service", a.ServiceName, "runlevel", runlevel, "error", err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// restore undoes a failed move: the service is taken out of the runlevels it
+// was added to and put back in those it was removed from.
+func (a *ServiceMoveAction) restore(runner system.CommandRunner, logger log.Logger, removed, added []string) {
+	for _, runlevel := range added {
+		if _, err := runner.Run("", fmt.Sprintf("rc-update del %s %s", a.ServiceName, runlevel)); err != nil {
+			logger.Error("Failed to take service out of its new runlevel", "service", a.ServiceName, "runlevel", runlevel, "error", err)
+		}
+	}
+	for _, runlevel := range removed {
+		if _, err := runner.Run("", fmt.Sprintf("rc-update add %s %s", a.ServiceName, runlevel)); err != nil {
+			logger.Error("Failed to put service back in its runlevel", "service", a.ServiceName, "runlevel", runlevel, "error", err)
+		}
+	}
+}
+
+// targets returns the runlevels the service ends up in.
+func (a *ServiceMoveAction) targets() []string {
+	return append([]string{a.To}, a.Also...)
+}
+
+// others returns the runlevels the service is removed from.
+func (a *ServiceMoveAction) others() []string {
+	var others []string
+	for _, runlevel := range a.From {
+		if !slices.Contains(a.targets(), runlevel) {
+			others = append(others, runlevel)
+		}
+	}
+	return others
+}
+
+// added returns the runlevels the service is added to.
+func (a *ServiceMoveAction) added() []string {
+	var added []string
+	for _, runlevel := range a.targets() {
+		if !slices.Contains(a.From, runlevel) {
+			added = append(added, runlevel)
+		}
+	}
+	return added
+}
+
+func (a *ServiceMoveAction) ExecutionDetails() []string {
+	var details []string
+	for _, runlevel := range a.others() {
+		details = append(details, fmt.Sprintf("run: rc-update del %s %s", a.ServiceName, runlevel))
+	}
+	for _, runlevel := range a.added() {
+		details = append(details, fmt.Sprintf("run: rc-update add %s %s", a.ServiceName, runlevel))
+	}
+	return details
+}
+
+func (a *ServiceMoveAction) RollbackDetails() []string {
+	var details []string
+	for _, runlevel := range a.added() {
+		details = append(details, fmt.Sprintf("run: rc-update del %s %s", a.ServiceName, runlevel))
+	}
+	for _, runlevel := range a.others() {
+		details = append(details, fmt.Sprintf("run: rc-update add %s %s", a.ServiceName, runlevel))
+	}
+	return details
+}
+
+func (a *ServiceMoveAction) Check(runner system.CommandRunner) (bool, error) {
+	for _, runlevel := range a.targets() {
+		if inNew, err := runlevelHasService(runlevel, a.ServiceName); err != nil || !inNew {
+			return false, err
+		}
+	}
+	for _, runlevel := range a.others() {
+		if inOld, err := runlevelHasService(runlevel, a.ServiceName); err != nil || inOld {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ServiceStartAction starts a service without changing its runlevels. With a
+// healthcheck, a service that does not become healthy is stopped again and
+// the action fails.
+type ServiceStartAction struct {
+	ServiceName string
+	Healthcheck *model.HealthcheckState
+}
+
+func (a *ServiceStartAction) Type() string {
+	return "service.start"
+}
+
+func (a *ServiceStartAction) Description() string {
+	return fmt.Sprintf("Start service %s", a.ServiceName)
+}
+
+func (a *ServiceStartAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.ServiceName) == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	if err := runService(runner, logger, a.ServiceName, "start"); err != nil || a.Healthcheck == nil || !StartServices {
+		return err
+	}
+	if err := waitHealthy(a.ServiceName, a.Healthcheck, runner, logger); err != nil {
+		_ = a.Rollback(runner, logger)
+		return err
 	}
+	return nil
+}
+
+func (a *ServiceStartAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Stopping service during rollback", "service", a.ServiceName)
+	return runService(runner, logger, a.ServiceName, "stop")
+}
+
+func (a *ServiceStartAction) ExecutionDetails() []string {
+	return append(serviceDetails(a.ServiceName, "start"), healthcheckDetails(a.Healthcheck)...)
+}
+
+func (a *ServiceStartAction) RollbackDetails() []string {
+	return serviceDetails(a.ServiceName, "stop")
+}
+
+// ServiceStopAction stops a service without changing its runlevels.
+type ServiceStopAction struct {
+	ServiceName string
+}
+
+func (a *ServiceStopAction) Type() string {
+	return "service.stop"
+}
+
+func (a *ServiceStopAction) Description() string {
+	return fmt.Sprintf("Stop service %s", a.ServiceName)
+}
+
+func (a *ServiceStopAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.ServiceName) == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	return runService(runner, logger, a.ServiceName, "stop")
+}
+
+func (a *ServiceStopAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Starting service during rollback", "service", a.ServiceName)
+	return runService(runner, logger, a.ServiceName, "start")
+}
+
+func (a *ServiceStopAction) ExecutionDetails() []string {
+	return serviceDetails(a.ServiceName, "stop")
+}
+
+func (a *ServiceStopAction) RollbackDetails() []string {
+	return serviceDetails(a.ServiceName, "start")
+}
+
+// runService starts or stops a service, unless services are not started.
+func runService(runner system.CommandRunner, logger log.Logger, service, command string) error {
+	if !StartServices {
+		logger.Info("Services are not started, skipping "+command, "service", service)
+		return nil
+	}
+	logger.Info("Running service command", "service", service, "command", command)
+	_, err := runner.Run("", fmt.Sprintf("rc-service %s %s", service, command))
+	return err
+}
+
+func serviceDetails(service, command string) []string {
+	if !StartServices {
+		return []string{fmt.Sprintf("skip: services are not started, %s is left alone", service)}
+	}
+	return []string{fmt.Sprintf("run: rc-service %s %s", service, command)}
+}
+
+// ServiceRestartAction restarts (or reloads) a running service so it picks up
+// changed config files. The plan carries at most one per service, after every
+// other action, however many of its files changed.
+type ServiceRestartAction struct {
+	ServiceName string
+	Reload      bool     // Try a reload first, falling back to a restart
+	Files       []string // Changed files that triggered the restart
+}
+
+func (a *ServiceRestartAction) Type() string {
+	return "service.restart"
+}
+
+func (a *ServiceRestartAction) Description() string {
+	verb := "Restart"
+	if a.Reload {
+		verb = "Reload"
+	}
+	return fmt.Sprintf("%s service %s", verb, a.ServiceName)
+}
+
+func (a *ServiceRestartAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.ServiceName) == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	return a.restart(runner, logger)
+}
+
+// Rollback restarts the service again so it picks up the restored files.
+func (a *ServiceRestartAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Restarting service during rollback", "service", a.ServiceName)
+	return a.restart(runner, logger)
+}
+
+// restart only acts on started services, so a stopped service is not brought
+// up by a config change.
+func (a *ServiceRestartAction) restart(runner system.CommandRunner, logger log.Logger) error {
+	if !StartServices {
+		logger.Info("Services are not started, skipping restart", "service", a.ServiceName)
+		return nil
+	}
+	if a.Reload {
+		logger.Info("Reloading service", "service", a.ServiceName)
+		if _, err := runner.Run("", fmt.Sprintf("rc-service --ifstarted %s reload", a.ServiceName)); err == nil {
+			return nil
+		}
+		logger.Warn("Reload failed, restarting instead", "service", a.ServiceName)
+	}
+	logger.Info("Restarting service", "service", a.ServiceName)
+	_, err := runner.Run("", fmt.Sprintf("rc-service --ifstarted %s restart", a.ServiceName))
+	return err
+}
+
+func (a *ServiceRestartAction) ExecutionDetails() []string {
+	details := []string{}
+	if !StartServices {
+		details = append(details, fmt.Sprintf("skip: services are not started, %s is not restarted", a.ServiceName))
+	} else if a.Reload {
+		details = append(details, fmt.Sprintf("run: rc-service --ifstarted %s reload (restart if reload fails)", a.ServiceName))
+	} else {
+		details = append(details, fmt.Sprintf("run: rc-service --ifstarted %s restart", a.ServiceName))
+	}
+	return append(details, fmt.Sprintf("triggered by: %s", strings.Join(a.Files, ", ")))
+}
+
+// RollbackDetails repeats the restart, which picks up the restored files.
+func (a *ServiceRestartAction) RollbackDetails() []string {
+	return a.ExecutionDetails()[:1]
 }
@@ -3,9 +3,14 @@ package actions
 import (
 	"bytes"
 	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	"summit/pkg/log"
+	"summit/pkg/model"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -103,3 +108,135 @@ func TestServiceDisableAction_ExecutionDetails(t *testing.T) {
 	}
 	assert.Equal(t, expected, details)
 }
+
+func TestServiceMoveAction(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+
+	action := &ServiceMoveAction{ServiceName: "chronyd", From: []string{"boot"}, To: "default"}
+	assert.Equal(t, "Move service chronyd from runlevel boot to default", action.Description())
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{"rc-update del chronyd boot", "rc-update add chronyd default", "rc-update del chronyd default", "rc-update add chronyd boot"}, runner.Commands)
+
+	// A failed add puts the service back where it was
+	runner.Commands = nil
+	runner.Errors[":rc-update add chronyd default"] = assert.AnError
+	assert.ErrorIs(t, action.Apply(runner, logger), assert.AnError)
+	assert.Equal(t, []string{"rc-update del chronyd boot", "rc-update add chronyd default", "rc-update add chronyd boot"}, runner.Commands)
+
+	// A service in its runlevel and others is only removed from the others
+	runner.Commands = nil
+	cleanup := &ServiceMoveAction{ServiceName: "sshd", From: []string{"boot", "default", "nonetwork"}, To: "default"}
+	assert.Equal(t, "Remove service sshd from runlevel boot, nonetwork, keeping it in default", cleanup.Description())
+	require.NoError(t, cleanup.Apply(runner, logger))
+	assert.Equal(t, []string{"rc-update del sshd boot", "rc-update del sshd nonetwork"}, runner.Commands)
+	assert.Equal(t, []string{"run: rc-update add sshd boot", "run: rc-update add sshd nonetwork"}, cleanup.RollbackDetails())
+
+	// A service declared in several runlevels keeps all of them
+	runner.Commands = nil
+	multi := &ServiceMoveAction{ServiceName: "sshd", From: []string{"default", "nonetwork"}, To: "default", Also: []string{"boot"}}
+	assert.Equal(t, "Move service sshd from runlevel default, nonetwork to default, boot", multi.Description())
+	require.NoError(t, multi.Apply(runner, logger))
+	require.NoError(t, multi.Rollback(runner, logger))
+	assert.Equal(t, []string{"rc-update del sshd nonetwork", "rc-update add sshd boot", "rc-update del sshd boot", "rc-update add sshd nonetwork"}, runner.Commands)
+	add := &ServiceMoveAction{ServiceName: "sshd", From: []string{"default"}, To: "default", Also: []string{"boot"}}
+	assert.Equal(t, "Add service sshd to runlevel boot", add.Description())
+}
+
+func TestServiceStartStopActions(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+
+	start := &ServiceStartAction{ServiceName: "nginx"}
+	stop := &ServiceStopAction{ServiceName: "chronyd"}
+	require.NoError(t, start.Apply(runner, logger))
+	require.NoError(t, stop.Apply(runner, logger))
+	require.NoError(t, start.Rollback(runner, logger))
+	require.NoError(t, stop.Rollback(runner, logger))
+	assert.Equal(t, []string{"rc-service nginx start", "rc-service chronyd stop", "rc-service nginx stop", "rc-service chronyd start"}, runner.Commands)
+	assert.Equal(t, "Start service nginx", start.Description())
+	assert.Equal(t, []string{"run: rc-service chronyd start"}, stop.RollbackDetails())
+}
+
+func TestServiceEnableAction_Healthcheck(t *testing.T) {
+	interval := HealthcheckInterval
+	HealthcheckInterval = time.Millisecond
+	t.Cleanup(func() { HealthcheckInterval = interval })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	runner, logger := setupServiceTest(t)
+	healthy := &ServiceEnableAction{ServiceName: "nginx", Runlevel: "default", Healthcheck: &model.HealthcheckState{TCP: listener.Addr().String()}}
+	require.NoError(t, healthy.Apply(runner, logger))
+	assert.Equal(t, []string{"rc-update add nginx default", "rc-service nginx start"}, runner.Commands)
+	assert.Equal(t, "wait: tcp "+listener.Addr().String()+", up to 30s", healthy.ExecutionDetails()[2])
+
+	// A service that never becomes healthy is stopped and disabled again
+	runner, logger = setupServiceTest(t)
+	runner.Errors[":pg_isready"] = assert.AnError
+	unhealthy := &ServiceEnableAction{ServiceName: "postgresql", Runlevel: "default", Healthcheck: &model.HealthcheckState{Command: "pg_isready", Timeout: "5ms"}}
+	err = unhealthy.Apply(runner, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "service postgresql did not become healthy within 5ms")
+	assert.Equal(t, []string{"rc-update add postgresql default", "rc-service postgresql start"}, runner.Commands[:2])
+	assert.Equal(t, []string{"rc-service postgresql stop", "rc-update del postgresql default"}, runner.Commands[len(runner.Commands)-2:])
+}
+
+func TestServiceStartAction_Healthcheck(t *testing.T) {
+	interval := HealthcheckInterval
+	HealthcheckInterval = time.Millisecond
+	t.Cleanup(func() { HealthcheckInterval = interval })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	runner, logger := setupServiceTest(t)
+	require.NoError(t, (&ServiceStartAction{ServiceName: "app", Healthcheck: &model.HealthcheckState{HTTP: server.URL + "/health"}}).Apply(runner, logger))
+
+	runner, logger = setupServiceTest(t)
+	err := (&ServiceStartAction{ServiceName: "app", Healthcheck: &model.HealthcheckState{HTTP: server.URL, Timeout: "5ms"}}).Apply(runner, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "returned status 503")
+	assert.Equal(t, []string{"rc-service app start", "rc-service app stop"}, runner.Commands)
+}
+
+func TestServiceRestartAction_Apply(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+
+	action := &ServiceRestartAction{ServiceName: "nginx", Files: []string{"/etc/nginx/nginx.conf"}}
+	require.NoError(t, action.Apply(runner, logger))
+	assert.Equal(t, []string{"rc-service --ifstarted nginx restart"}, runner.Commands)
+	assert.Equal(t, "Restart service nginx", action.Description())
+}
+
+func TestServiceRestartAction_ReloadFallsBackToRestart(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+	runner.Errors[":rc-service --ifstarted nginx reload"] = assert.AnError
+
+	action := &ServiceRestartAction{ServiceName: "nginx", Reload: true, Files: []string{"/etc/nginx/nginx.conf"}}
+	require.NoError(t, action.Apply(runner, logger))
+	assert.Equal(t, []string{"rc-service --ifstarted nginx reload", "rc-service --ifstarted nginx restart"}, runner.Commands)
+	assert.Equal(t, []string{
+		"run: rc-service --ifstarted nginx reload (restart if reload fails)",
+		"triggered by: /etc/nginx/nginx.conf",
+	}, action.ExecutionDetails())
+}
+
+func TestServiceActions_WithoutStartingServices(t *testing.T) {
+	runner, logger := setupServiceTest(t)
+	StartServices = false
+	t.Cleanup(func() { StartServices = true })
+
+	require.NoError(t, (&ServiceEnableAction{ServiceName: "nginx", Runlevel: "default"}).Apply(runner, logger))
+	require.NoError(t, (&ServiceDisableAction{ServiceName: "sshd", Runlevel: "default"}).Apply(runner, logger))
+	require.NoError(t, (&ServiceRestartAction{ServiceName: "nginx"}).Apply(runner, logger))
+	require.NoError(t, (&ServiceStartAction{ServiceName: "nginx"}).Apply(runner, logger))
+	require.NoError(t, (&ServiceStopAction{ServiceName: "sshd"}).Apply(runner, logger))
+
+	assert.Equal(t, []string{"rc-update add nginx default", "rc-update del sshd default"}, runner.Commands)
+}
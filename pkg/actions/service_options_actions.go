@@ -0,0 +1,217 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// ServiceOptionsAction sets options of a service in its /etc/conf.d file. A
+// line already setting an option is rewritten in place and options the file
+// does not set are appended; comments and the lines setting other keys are
+// left untouched.
+type ServiceOptionsAction struct {
+	Service   string
+	Options   map[string]string
+	Sensitive bool // Values and diff are never shown
+
+	origContent string
+	origMode    os.FileMode
+	created     bool
+}
+
+func (a *ServiceOptionsAction) Type() string {
+	return "service.options"
+}
+
+func (a *ServiceOptionsAction) Description() string {
+	return fmt.Sprintf("Set options of service %s in %s", a.Service, a.Path())
+}
+
+// Path returns the conf.d file of the service.
+func (a *ServiceOptionsAction) Path() string {
+	return "/etc/conf.d/" + a.Service
+}
+
+func (a *ServiceOptionsAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.Service) == "" {
+		return fmt.Errorf("service name cannot be empty")
+	}
+	logger.Info("Setting service options", "service", a.Service, "path", a.Path())
+	mode := os.FileMode(0644)
+	content, err := afero.ReadFile(system.AppFs, a.Path())
+	switch {
+	case os.IsNotExist(err):
+		a.created = true
+	case err != nil:
+		return err
+	default:
+		info, err := system.AppFs.Stat(a.Path())
+		if err != nil {
+			return err
+		}
+		mode = info.Mode()
+	}
+	a.origContent = string(content)
+	a.origMode = mode
+	expanded, err := a.Expanded()
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(system.AppFs, a.Path(), []byte(expanded.render(a.origContent)), mode)
+}
+
+func (a *ServiceOptionsAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back service options", "service", a.Service, "path", a.Path())
+	var err error
+	if a.created {
+		err = system.AppFs.Remove(a.Path())
+	} else {
+		err = afero.WriteFile(system.AppFs, a.Path(), []byte(a.origContent), a.origMode)
+	}
+	if err != nil {
+		logger.Error("Failed to roll back service options", "path", a.Path(), "error", err)
+	}
+	return err
+}
+
+func (a *ServiceOptionsAction) ExecutionDetails() []string {
+	current, _ := afero.ReadFile(system.AppFs, a.Path())
+	details := []string{fmt.Sprintf("update options in: %s", a.Path())}
+	if a.Sensitive {
+		return append(details, RedactedDiff)
+	}
+	details = append(details, "--- diff ---")
+	// The file may hold secret values the options reference
+	redacted := Secrets.Redact(string(current))
+	details = append(details, lineDiff(redacted, a.render(redacted), DiffContextLines, DiffMaxLines)...)
+	return append(details, "--- end diff ---")
+}
+
+func (a *ServiceOptionsAction) RollbackDetails() []string {
+	if exists, _ := fileExists(a.Path()); !exists {
+		return []string{fmt.Sprintf("delete file: %s", a.Path())}
+	}
+	return []string{fmt.Sprintf("restore the previous content of %s", a.Path())}
+}
+
+func (a *ServiceOptionsAction) Check(runner system.CommandRunner) (bool, error) {
+	content, err := afero.ReadFile(system.AppFs, a.Path())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	expanded, err := a.Expanded()
+	if err != nil {
+		return false, err
+	}
+	return !expanded.NeedsUpdate(string(content)), nil
+}
+
+// NeedsUpdate reports whether content sets any option to another value, or
+// does not set it.
+func (a *ServiceOptionsAction) NeedsUpdate(content string) bool {
+	return a.render(content) != content
+}
+
+// Expanded returns a copy of the action with the secret and environment
+// references of the option values replaced by their values, the options
+// written to the file.
+func (a *ServiceOptionsAction) Expanded() (*ServiceOptionsAction, error) {
+	options := make(map[string]string, len(a.Options))
+	for key, value := range a.Options {
+		expanded, err := Secrets.Expand(value)
+		if err != nil {
+			return nil, fmt.Errorf("option %s: %w", key, err)
+		}
+		options[key] = expanded
+	}
+	expanded := *a
+	expanded.Options = options
+	return &expanded, nil
+}
+
+// render returns content with the options set. Lines setting an option to
+// its value already, however it is quoted, are kept as they are.
+func (a *ServiceOptionsAction) render(content string) string {
+	lines := strings.SplitAfter(content, "\n")
+	set := make(map[string]bool)
+	for i, line := range lines {
+		key, value, ok := parseOption(line)
+		want, declared := a.Options[key]
+		if !ok || !declared {
+			continue
+		}
+		set[key] = true
+		if value != want {
+			lines[i] = formatOption(key, want) + "\n"
+		}
+	}
+	result := strings.Join(lines, "")
+
+	var missing []string
+	for key := range a.Options {
+		if !set[key] {
+			missing = append(missing, key)
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 && result != "" && !strings.HasSuffix(result, "\n") {
+		result += "\n"
+	}
+	for _, key := range missing {
+		result += formatOption(key, a.Options[key]) + "\n"
+	}
+	return result
+}
+
+// parseOption returns the key and value a conf.d line sets, such as
+// command_args="-p 8080", with the value unquoted.
+func parseOption(line string) (string, string, bool) {
+	line = strings.TrimPrefix(strings.TrimSpace(line), "export ")
+	key, rest, ok := strings.Cut(line, "=")
+	if !ok || key == "" || strings.ContainsAny(key, " \t#$") {
+		return "", "", false
+	}
+	switch {
+	case strings.HasPrefix(rest, "'"):
+		value, _, _ := strings.Cut(rest[1:], "'")
+		return key, value, true
+	case strings.HasPrefix(rest, `"`):
+		var sb strings.Builder
+		for i := 1; i < len(rest); i++ {
+			switch c := rest[i]; {
+			case c == '"':
+				return key, sb.String(), true
+			case c == '\\' && i+1 < len(rest) && strings.IndexByte("\"\\$`", rest[i+1]) >= 0:
+				i++
+				sb.WriteByte(rest[i])
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return key, sb.String(), true
+	}
+	value, _, _ := strings.Cut(rest, " ")
+	value, _, _ = strings.Cut(value, "\t")
+	return key, value, true
+}
+
+// formatOption returns the line setting key to value, double-quoted.
+func formatOption(key, value string) string {
+	var sb strings.Builder
+	for _, r := range value {
+		if strings.ContainsRune("\"\\$`", r) {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return fmt.Sprintf("%s=\"%s\"", key, sb.String())
+}
@@ -0,0 +1,68 @@
+package actions
+
+import (
+	"testing"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceOptionsAction_KeepsOtherLines(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	confd := "# Options for sshd\nsshd_disable_keygen=no\ncommand_args='-4'\nrc_need=\"net\"\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/conf.d/sshd", []byte(confd), 0640))
+
+	action := &ServiceOptionsAction{Service: "sshd", Options: map[string]string{
+		"sshd_disable_keygen": "yes",
+		"command_args":        "-4",
+		"rc_after":            `"$net" pre`,
+	}}
+	assert.Equal(t, "Set options of service sshd in /etc/conf.d/sshd", action.Description())
+	require.NoError(t, action.Apply(runner, logger))
+
+	// The quoted value already set is kept as written
+	content, err := afero.ReadFile(system.AppFs, "/etc/conf.d/sshd")
+	require.NoError(t, err)
+	assert.Equal(t, "# Options for sshd\nsshd_disable_keygen=\"yes\"\ncommand_args='-4'\nrc_need=\"net\"\nrc_after=\"\\\"\\$net\\\" pre\"\n", string(content))
+	info, err := system.AppFs.Stat("/etc/conf.d/sshd")
+	require.NoError(t, err)
+	assert.Equal(t, "-rw-r-----", info.Mode().String())
+
+	converged, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, converged)
+
+	require.NoError(t, action.Rollback(runner, logger))
+	content, err = afero.ReadFile(system.AppFs, "/etc/conf.d/sshd")
+	require.NoError(t, err)
+	assert.Equal(t, confd, string(content))
+}
+
+func TestServiceOptionsAction_CreatesFile(t *testing.T) {
+	runner, logger := setupFileTest(t)
+
+	action := &ServiceOptionsAction{Service: "redis", Options: map[string]string{"cfgfile": "/etc/redis.conf"}}
+	converged, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.False(t, converged)
+	require.NoError(t, action.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/conf.d/redis")
+	require.NoError(t, err)
+	assert.Equal(t, "cfgfile=\"/etc/redis.conf\"\n", string(content))
+
+	// The file did not exist before, so rolling back removes it
+	require.NoError(t, action.Rollback(runner, logger))
+	exists, err := afero.Exists(system.AppFs, "/etc/conf.d/redis")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestServiceOptionsAction_SensitiveDetails(t *testing.T) {
+	setupFileTest(t)
+	action := &ServiceOptionsAction{Service: "app", Options: map[string]string{"APP_TOKEN": "s3cret"}, Sensitive: true}
+	assert.Equal(t, []string{"update options in: /etc/conf.d/app", RedactedDiff}, action.ExecutionDetails())
+}
@@ -0,0 +1,90 @@
+package actions
+
+import (
+	"fmt"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/system"
+)
+
+// SysctlSetAction sets a kernel parameter on the running system with
+// sysctl -w. The value is persisted separately, in the sysctl.d fragment.
+type SysctlSetAction struct {
+	Key     string
+	Value   string
+	Current string // Runtime value when the plan was made, empty if unknown
+
+	origValue string
+	hadOrig   bool
+}
+
+func (a *SysctlSetAction) Type() string {
+	return "sysctl.set"
+}
+
+func (a *SysctlSetAction) Description() string {
+	return fmt.Sprintf("Set sysctl %s to %s", a.Key, a.Value)
+}
+
+func (a *SysctlSetAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Setting sysctl", "key", a.Key, "value", a.Value)
+	a.origValue, a.hadOrig = readSysctl(runner, a.Key)
+	return writeSysctl(runner, a.Key, a.Value)
+}
+
+func (a *SysctlSetAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	if !a.hadOrig {
+		logger.Warn("Previous sysctl value unknown, leaving it set", "key", a.Key)
+		return nil
+	}
+	logger.Info("Rolling back sysctl", "key", a.Key, "value", a.origValue)
+	err := writeSysctl(runner, a.Key, a.origValue)
+	if err != nil {
+		logger.Error("Failed to roll back sysctl", "key", a.Key, "error", err)
+	}
+	return err
+}
+
+func (a *SysctlSetAction) ExecutionDetails() []string {
+	details := []string{fmt.Sprintf("run: sysctl -w %s", system.ShellQuote(a.Key+"="+a.Value))}
+	if a.Current != "" {
+		details = append(details, fmt.Sprintf("current value: %s", a.Current))
+	}
+	return details
+}
+
+func (a *SysctlSetAction) RollbackDetails() []string {
+	previous := a.Current
+	if previous == "" {
+		previous = "<previous value>"
+	}
+	return []string{fmt.Sprintf("run: sysctl -w %s", system.ShellQuote(a.Key+"="+previous))}
+}
+
+func (a *SysctlSetAction) Check(runner system.CommandRunner) (bool, error) {
+	value, ok := readSysctl(runner, a.Key)
+	return ok && SysctlValuesEqual(value, a.Value), nil
+}
+
+// SysctlValuesEqual compares sysctl values ignoring whitespace differences,
+// as sysctl prints multi-value parameters separated by tabs.
+func SysctlValuesEqual(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// readSysctl returns the runtime value of a kernel parameter, and false when
+// it cannot be read.
+func readSysctl(runner system.CommandRunner, key string) (string, bool) {
+	out, err := runner.Run("", fmt.Sprintf("sysctl -n %s", system.ShellQuote(key)))
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSpace(string(out)), true
+}
+
+func writeSysctl(runner system.CommandRunner, key, value string) error {
+	if out, err := runner.Run("", fmt.Sprintf("sysctl -w %s", system.ShellQuote(key+"="+value))); err != nil {
+		return fmt.Errorf("failed to set sysctl %s: %w: %s", key, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package actions
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSysctlSetAction_ApplyAndRollback(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	runner.Responses[":sysctl -n 'net.ipv4.ip_forward'"] = []byte("0\n")
+
+	action := &SysctlSetAction{Key: "net.ipv4.ip_forward", Value: "1", Current: "0"}
+	assert.Equal(t, "Set sysctl net.ipv4.ip_forward to 1", action.Description())
+	assert.Equal(t, []string{"run: sysctl -w 'net.ipv4.ip_forward=1'", "current value: 0"}, action.ExecutionDetails())
+	assert.Equal(t, []string{"run: sysctl -w 'net.ipv4.ip_forward=0'"}, action.RollbackDetails())
+
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{
+		"sysctl -n 'net.ipv4.ip_forward'",
+		"sysctl -w 'net.ipv4.ip_forward=1'",
+		"sysctl -w 'net.ipv4.ip_forward=0'",
+	}, runner.Commands)
+}
+
+func TestSysctlSetAction_Errors(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	runner.Errors[":sysctl -n 'kernel.unknown'"] = errors.New("exit status 255")
+	runner.Errors[":sysctl -w 'kernel.unknown=1'"] = errors.New("exit status 255")
+
+	action := &SysctlSetAction{Key: "kernel.unknown", Value: "1"}
+	assert.ErrorContains(t, action.Apply(runner, logger), "failed to set sysctl kernel.unknown")
+	// Without a previous value there is nothing to restore
+	assert.NoError(t, action.Rollback(runner, logger))
+	assert.Len(t, runner.Commands, 2)
+}
+
+func TestSysctlSetAction_Check(t *testing.T) {
+	runner, _ := setupFileTest(t)
+	runner.Responses[":sysctl -n 'net.ipv4.tcp_rmem'"] = []byte("4096\t87380\t6291456\n")
+
+	done, err := (&SysctlSetAction{Key: "net.ipv4.tcp_rmem", Value: "4096 87380 6291456"}).Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done, "multi-value parameters compare regardless of whitespace")
+
+	done, err = (&SysctlSetAction{Key: "net.ipv4.tcp_rmem", Value: "4096 131072 6291456"}).Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done)
+}
@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// TimezoneSetAction points /etc/localtime at a zone of the tzdata database.
+// /etc/timezone is written separately, as a config.
+type TimezoneSetAction struct {
+	Zone    string
+	Current string // Zone when the plan was made, empty if unknown
+
+	origZone string
+}
+
+func (a *TimezoneSetAction) Type() string {
+	return "timezone.set"
+}
+
+func (a *TimezoneSetAction) Description() string {
+	return fmt.Sprintf("Set timezone to %s", a.Zone)
+}
+
+func (a *TimezoneSetAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Setting timezone", "zone", a.Zone)
+	zoneFile := path.Join(model.ZoneinfoDir, a.Zone)
+	if exists, _ := afero.Exists(system.AppFs, zoneFile); !exists {
+		return fmt.Errorf("unknown timezone %s: %s not found", a.Zone, zoneFile)
+	}
+	a.origZone = system.ReadTimezone(runner)
+	return linkLocaltime(runner, a.Zone)
+}
+
+func (a *TimezoneSetAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	if a.origZone == "" {
+		logger.Warn("Previous timezone unknown, leaving it set", "zone", a.Zone)
+		return nil
+	}
+	logger.Info("Rolling back timezone", "zone", a.origZone)
+	err := linkLocaltime(runner, a.origZone)
+	if err != nil {
+		logger.Error("Failed to roll back timezone", "zone", a.origZone, "error", err)
+	}
+	return err
+}
+
+func (a *TimezoneSetAction) ExecutionDetails() []string {
+	details := []string{fmt.Sprintf("run: ln -sf %s %s", system.ShellQuote(path.Join(model.ZoneinfoDir, a.Zone)), model.LocaltimePath)}
+	if a.Current != "" {
+		details = append(details, fmt.Sprintf("current timezone: %s", a.Current))
+	}
+	return details
+}
+
+func (a *TimezoneSetAction) RollbackDetails() []string {
+	if a.Current == "" {
+		return []string{"leave the timezone set (previous zone unknown)"}
+	}
+	return []string{fmt.Sprintf("run: ln -sf %s %s", system.ShellQuote(path.Join(model.ZoneinfoDir, a.Current)), model.LocaltimePath)}
+}
+
+func (a *TimezoneSetAction) Check(runner system.CommandRunner) (bool, error) {
+	return system.ReadTimezone(runner) == a.Zone, nil
+}
+
+func linkLocaltime(runner system.CommandRunner, zone string) error {
+	cmd := fmt.Sprintf("ln -sf %s %s", system.ShellQuote(path.Join(model.ZoneinfoDir, zone)), model.LocaltimePath)
+	if out, err := runner.Run("", cmd); err != nil {
+		return fmt.Errorf("failed to set timezone %s: %w: %s", zone, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
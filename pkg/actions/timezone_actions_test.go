@@ -0,0 +1,51 @@
+package actions
+
+import (
+	"testing"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimezoneSetAction_ApplyAndRollback(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	runner.Responses[":readlink /etc/localtime"] = []byte("/usr/share/zoneinfo/UTC\n")
+	require.NoError(t, afero.WriteFile(system.AppFs, "/usr/share/zoneinfo/Europe/Rome", []byte("TZif"), 0644))
+
+	action := &TimezoneSetAction{Zone: "Europe/Rome", Current: "UTC"}
+	assert.Equal(t, "Set timezone to Europe/Rome", action.Description())
+	assert.Equal(t, []string{"run: ln -sf '/usr/share/zoneinfo/Europe/Rome' /etc/localtime", "current timezone: UTC"}, action.ExecutionDetails())
+	assert.Equal(t, []string{"run: ln -sf '/usr/share/zoneinfo/UTC' /etc/localtime"}, action.RollbackDetails())
+
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{
+		"readlink /etc/localtime",
+		"ln -sf '/usr/share/zoneinfo/Europe/Rome' /etc/localtime",
+		"ln -sf '/usr/share/zoneinfo/UTC' /etc/localtime",
+	}, runner.Commands)
+}
+
+func TestTimezoneSetAction_UnknownZone(t *testing.T) {
+	runner, logger := setupFileTest(t)
+
+	action := &TimezoneSetAction{Zone: "Mars/Olympus"}
+	assert.ErrorContains(t, action.Apply(runner, logger), "unknown timezone Mars/Olympus: /usr/share/zoneinfo/Mars/Olympus not found")
+	assert.Empty(t, runner.Commands)
+}
+
+func TestTimezoneSetAction_Check(t *testing.T) {
+	runner, _ := setupFileTest(t)
+	runner.Responses[":readlink /etc/localtime"] = []byte("/usr/share/zoneinfo/Europe/Rome\n")
+
+	done, err := (&TimezoneSetAction{Zone: "Europe/Rome"}).Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	done, err = (&TimezoneSetAction{Zone: "UTC"}).Check(runner)
+	require.NoError(t, err)
+	assert.False(t, done)
+}
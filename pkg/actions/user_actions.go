@@ -2,14 +2,30 @@ package actions
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"summit/pkg/log"
+	"summit/pkg/model"
 	"summit/pkg/system"
+	"syscall"
+
+	"github.com/spf13/afero"
 )
 
-// UserCreateAction creates a user.
+// UserCreateAction creates a user. The passwd fields that are set are passed
+// to adduser; the others take its defaults.
 type UserCreateAction struct {
 	UserName string
+	UID      int
+	Shell    string
+	Home     string
+	Gecos    string
+}
+
+func (a *UserCreateAction) Type() string {
+	return "user.create"
 }
 
 func (a *UserCreateAction) Description() string {
@@ -21,7 +37,7 @@ func (a *UserCreateAction) Apply(runner system.CommandRunner, logger log.Logger)
 		return fmt.Errorf("username cannot be empty")
 	}
 	logger.Info("Creating user", "user", a.UserName)
-	_, err := runner.Run("", fmt.Sprintf("adduser -D %s", a.UserName))
+	_, err := runner.Run("", a.command())
 	if err != nil {
 		return err
 	}
@@ -39,7 +55,32 @@ func (a *UserCreateAction) Rollback(runner system.CommandRunner, logger log.Logg
 }
 
 func (a *UserCreateAction) ExecutionDetails() []string {
-	return []string{fmt.Sprintf("run: adduser -D %s", a.UserName)}
+	return []string{"run: " + a.command()}
+}
+
+func (a *UserCreateAction) command() string {
+	var options []string
+	if a.UID != 0 {
+		options = append(options, fmt.Sprintf("-u %d", a.UID))
+	}
+	if a.Shell != "" {
+		options = append(options, "-s "+system.ShellQuote(a.Shell))
+	}
+	if a.Home != "" {
+		options = append(options, "-h "+system.ShellQuote(a.Home))
+	}
+	if a.Gecos != "" {
+		options = append(options, "-g "+system.ShellQuote(a.Gecos))
+	}
+	return strings.Join(append(append([]string{"adduser -D"}, options...), a.UserName), " ")
+}
+
+func (a *UserCreateAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("run: deluser %s", a.UserName)}
+}
+
+func (a *UserCreateAction) Check(runner system.CommandRunner) (bool, error) {
+	return databaseHasEntry("/etc/passwd", a.UserName)
 }
 
 // UserRemoveAction removes a user.
@@ -47,6 +88,14 @@ type UserRemoveAction struct {
 	UserName string
 }
 
+func (a *UserRemoveAction) Type() string {
+	return "user.remove"
+}
+
+func (a *UserRemoveAction) Removals() (string, int) {
+	return model.LimitUserRemovals, 1
+}
+
 func (a *UserRemoveAction) Description() string {
 	return fmt.Sprintf("Remove user %s", a.UserName)
 }
@@ -73,9 +122,24 @@ func (a *UserRemoveAction) ExecutionDetails() []string {
 	return []string{fmt.Sprintf("run: deluser %s", a.UserName)}
 }
 
-// GroupCreateAction creates a group.
+func (a *UserRemoveAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("run: adduser -D %s", a.UserName)}
+}
+
+func (a *UserRemoveAction) Check(runner system.CommandRunner) (bool, error) {
+	exists, err := databaseHasEntry("/etc/passwd", a.UserName)
+	return !exists, err
+}
+
+// GroupCreateAction creates a group, with the given gid when set.
 type GroupCreateAction struct {
 	GroupName string
+	GID       int
+	System    bool
+}
+
+func (a *GroupCreateAction) Type() string {
+	return "group.create"
 }
 
 func (a *GroupCreateAction) Description() string {
@@ -87,7 +151,7 @@ func (a *GroupCreateAction) Apply(runner system.CommandRunner, logger log.Logger
 		return fmt.Errorf("group name cannot be empty")
 	}
 	logger.Info("Creating group", "group", a.GroupName)
-	_, err := runner.Run("", fmt.Sprintf("addgroup %s", a.GroupName))
+	_, err := runner.Run("", a.command())
 	return err
 }
 
@@ -101,7 +165,76 @@ func (a *GroupCreateAction) Rollback(runner system.CommandRunner, logger log.Log
 }
 
 func (a *GroupCreateAction) ExecutionDetails() []string {
-	return []string{fmt.Sprintf("run: addgroup %s", a.GroupName)}
+	return []string{"run: " + a.command()}
+}
+
+func (a *GroupCreateAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("run: delgroup %s", a.GroupName)}
+}
+
+func (a *GroupCreateAction) Check(runner system.CommandRunner) (bool, error) {
+	return databaseHasEntry("/etc/group", a.GroupName)
+}
+
+func (a *GroupCreateAction) command() string {
+	command := "addgroup"
+	if a.GID != 0 {
+		command += fmt.Sprintf(" -g %d", a.GID)
+	}
+	if a.System {
+		command += " -S"
+	}
+	return command + " " + a.GroupName
+}
+
+// GroupRemoveAction removes a group. GID is the group's gid when the plan
+// was made, restored on rollback.
+type GroupRemoveAction struct {
+	GroupName string
+	GID       int
+}
+
+func (a *GroupRemoveAction) Type() string {
+	return "group.remove"
+}
+
+func (a *GroupRemoveAction) Removals() (string, int) {
+	return model.LimitGroupRemovals, 1
+}
+
+func (a *GroupRemoveAction) Description() string {
+	return fmt.Sprintf("Remove group %s", a.GroupName)
+}
+
+func (a *GroupRemoveAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.GroupName) == "" {
+		return fmt.Errorf("group name cannot be empty")
+	}
+	logger.Info("Removing group", "group", a.GroupName)
+	_, err := runner.Run("", fmt.Sprintf("delgroup %s", a.GroupName))
+	return err
+}
+
+func (a *GroupRemoveAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Rolling back group removal", "group", a.GroupName)
+	_, err := runner.Run("", (&GroupCreateAction{GroupName: a.GroupName, GID: a.GID}).command())
+	if err != nil {
+		logger.Error("Failed to roll back group removal", "group", a.GroupName, "error", err)
+	}
+	return err
+}
+
+func (a *GroupRemoveAction) ExecutionDetails() []string {
+	return []string{fmt.Sprintf("run: delgroup %s", a.GroupName)}
+}
+
+func (a *GroupRemoveAction) RollbackDetails() []string {
+	return []string{"run: " + (&GroupCreateAction{GroupName: a.GroupName, GID: a.GID}).command()}
+}
+
+func (a *GroupRemoveAction) Check(runner system.CommandRunner) (bool, error) {
+	exists, err := databaseHasEntry("/etc/group", a.GroupName)
+	return !exists, err
 }
 
 // AddUserToGroupAction adds a user to a group.
@@ -110,6 +243,10 @@ type AddUserToGroupAction struct {
 	GroupName string
 }
 
+func (a *AddUserToGroupAction) Type() string {
+	return "user.group.add"
+}
+
 func (a *AddUserToGroupAction) Description() string {
 	return fmt.Sprintf("Add user %s to group %s", a.UserName, a.GroupName)
 }
@@ -139,12 +276,24 @@ func (a *AddUserToGroupAction) ExecutionDetails() []string {
 	return []string{fmt.Sprintf("run: addgroup %s %s", a.UserName, a.GroupName)}
 }
 
+func (a *AddUserToGroupAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("run: delgroup %s %s", a.UserName, a.GroupName)}
+}
+
+func (a *AddUserToGroupAction) Check(runner system.CommandRunner) (bool, error) {
+	return groupHasMember(a.GroupName, a.UserName)
+}
+
 // RemoveUserFromGroupAction removes a user from a group.
 type RemoveUserFromGroupAction struct {
 	UserName  string
 	GroupName string
 }
 
+func (a *RemoveUserFromGroupAction) Type() string {
+	return "user.group.remove"
+}
+
 func (a *RemoveUserFromGroupAction) Description() string {
 	return fmt.Sprintf("Remove user %s from group %s", a.UserName, a.GroupName)
 }
@@ -173,3 +322,217 @@ func (a *RemoveUserFromGroupAction) Rollback(runner system.CommandRunner, logger
 func (a *RemoveUserFromGroupAction) ExecutionDetails() []string {
 	return []string{fmt.Sprintf("run: delgroup %s %s", a.UserName, a.GroupName)}
 }
+
+func (a *RemoveUserFromGroupAction) RollbackDetails() []string {
+	return []string{fmt.Sprintf("run: addgroup %s %s", a.UserName, a.GroupName)}
+}
+
+func (a *RemoveUserFromGroupAction) Check(runner system.CommandRunner) (bool, error) {
+	member, err := groupHasMember(a.GroupName, a.UserName)
+	return !member, err
+}
+
+// UserModifyAction changes the /etc/passwd fields of an existing user.
+// Busybox has no usermod, so the user's entry is rewritten in place; when the
+// uid changes, the files under the home directory owned by the old uid are
+// given to the new one, as usermod -u does. A new home is not created or
+// moved to.
+type UserModifyAction struct {
+	UserName string
+	UID      int // New values; unset fields are left alone
+	Shell    string
+	Home     string
+	Gecos    string
+
+	// The fields when the plan was made, shown in the details
+	CurrentUID   int
+	CurrentShell string
+	CurrentHome  string
+	CurrentGecos string
+
+	orig []string // The passwd entry before Apply
+}
+
+func (a *UserModifyAction) Type() string {
+	return "user.modify"
+}
+
+func (a *UserModifyAction) Description() string {
+	return fmt.Sprintf("Modify user %s (%s)", a.UserName, strings.Join(a.changedFields(), ", "))
+}
+
+func (a *UserModifyAction) changedFields() []string {
+	var changed []string
+	if a.UID != 0 && a.UID != a.CurrentUID {
+		changed = append(changed, "uid")
+	}
+	if a.Shell != "" && a.Shell != a.CurrentShell {
+		changed = append(changed, "shell")
+	}
+	if a.Home != "" && a.Home != a.CurrentHome {
+		changed = append(changed, "home")
+	}
+	if a.Gecos != "" && a.Gecos != a.CurrentGecos {
+		changed = append(changed, "gecos")
+	}
+	return changed
+}
+
+func (a *UserModifyAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.UserName) == "" {
+		return fmt.Errorf("username cannot be empty")
+	}
+	logger.Info("Modifying user", "user", a.UserName, "fields", strings.Join(a.changedFields(), ","))
+	orig, err := editPasswdEntry(a.UserName, func(fields []string) {
+		if a.UID != 0 {
+			fields[2] = strconv.Itoa(a.UID)
+		}
+		if a.Gecos != "" {
+			fields[4] = a.Gecos
+		}
+		if a.Home != "" {
+			fields[5] = a.Home
+		}
+		if a.Shell != "" {
+			fields[6] = a.Shell
+		}
+	})
+	if err != nil {
+		return err
+	}
+	a.orig = orig
+	if a.UID != 0 && orig[2] != strconv.Itoa(a.UID) {
+		return chownHome(runner, orig[5], orig[2], strconv.Itoa(a.UID))
+	}
+	return nil
+}
+
+func (a *UserModifyAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	if a.orig == nil {
+		return nil
+	}
+	logger.Info("Rolling back user modification", "user", a.UserName)
+	current, err := editPasswdEntry(a.UserName, func(fields []string) { copy(fields, a.orig) })
+	if err == nil && current[2] != a.orig[2] {
+		err = chownHome(runner, a.orig[5], current[2], a.orig[2])
+	}
+	if err != nil {
+		logger.Error("Failed to roll back user modification", "user", a.UserName, "error", err)
+	}
+	return err
+}
+
+func (a *UserModifyAction) ExecutionDetails() []string {
+	var details []string
+	if a.UID != 0 && a.UID != a.CurrentUID {
+		details = append(details, fmt.Sprintf("set uid: %d -> %d", a.CurrentUID, a.UID))
+		details = append(details, "run: "+chownHomeCommand(a.CurrentHome, strconv.Itoa(a.CurrentUID), strconv.Itoa(a.UID)))
+	}
+	if a.Shell != "" && a.Shell != a.CurrentShell {
+		details = append(details, fmt.Sprintf("set shell: %s -> %s", a.CurrentShell, a.Shell))
+	}
+	if a.Home != "" && a.Home != a.CurrentHome {
+		details = append(details, fmt.Sprintf("set home: %s -> %s (not moved)", a.CurrentHome, a.Home))
+	}
+	if a.Gecos != "" && a.Gecos != a.CurrentGecos {
+		details = append(details, fmt.Sprintf("set gecos: %q -> %q", a.CurrentGecos, a.Gecos))
+	}
+	return details
+}
+
+func (a *UserModifyAction) RollbackDetails() []string {
+	details := []string{fmt.Sprintf("restore the /etc/passwd entry of %s", a.UserName)}
+	if a.UID != 0 && a.UID != a.CurrentUID {
+		details = append(details, "run: "+chownHomeCommand(a.CurrentHome, strconv.Itoa(a.UID), strconv.Itoa(a.CurrentUID)))
+	}
+	return details
+}
+
+func (a *UserModifyAction) Check(runner system.CommandRunner) (bool, error) {
+	content, err := afero.ReadFile(system.AppFs, passwdPath)
+	if err != nil {
+		return false, err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 || fields[0] != a.UserName {
+			continue
+		}
+		return (a.UID == 0 || fields[2] == strconv.Itoa(a.UID)) &&
+			(a.Gecos == "" || fields[4] == a.Gecos) &&
+			(a.Home == "" || fields[5] == a.Home) &&
+			(a.Shell == "" || fields[6] == a.Shell), nil
+	}
+	return false, nil
+}
+
+const passwdPath = "/etc/passwd"
+
+// editPasswdEntry rewrites the /etc/passwd entry of user with edit and
+// returns the fields it had before. The file is replaced rather than written
+// in place, as a truncated passwd locks everyone out.
+func editPasswdEntry(user string, edit func(fields []string)) ([]string, error) {
+	info, err := system.AppFs.Stat(passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	content, err := afero.ReadFile(system.AppFs, passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 || fields[0] != user {
+			continue
+		}
+		orig := append([]string(nil), fields...)
+		edit(fields)
+		lines[i] = strings.Join(fields, ":")
+		if err := replaceFile(passwdPath, []byte(strings.Join(lines, "\n")), info); err != nil {
+			return nil, err
+		}
+		return orig, nil
+	}
+	return nil, fmt.Errorf("user %s not found in %s", user, passwdPath)
+}
+
+// replaceFile writes content to a temporary file next to path, with the mode
+// and ownership of info, and renames it over path, so that a failed or
+// interrupted write never leaves path truncated.
+func replaceFile(path string, content []byte, info os.FileInfo) error {
+	tmp, err := afero.TempFile(system.AppFs, filepath.Dir(path), "."+filepath.Base(path)+".summit-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = system.AppFs.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && err == nil {
+		err = system.AppFs.Chown(tmp.Name(), int(stat.Uid), int(stat.Gid))
+	}
+	if err == nil {
+		err = system.AppFs.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = system.AppFs.Remove(tmp.Name())
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// chownHome gives the files under home owned by uid from to uid to.
+func chownHome(runner system.CommandRunner, home, from, to string) error {
+	if out, err := runner.Run("", chownHomeCommand(home, from, to)); err != nil {
+		return fmt.Errorf("failed to chown files under %s to uid %s: %w: %s", home, to, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func chownHomeCommand(home, from, to string) string {
+	return fmt.Sprintf("find %s -xdev -user %s -exec chown -h %s {} +", system.ShellQuote(home), from, to)
+}
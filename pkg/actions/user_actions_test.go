@@ -6,7 +6,9 @@ import (
 	"testing"
 
 	"summit/pkg/log"
+	"summit/pkg/system"
 
+	"github.com/spf13/afero"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -55,6 +57,69 @@ func TestUserCreateAction_ExecutionDetails(t *testing.T) {
 	assert.Equal(t, []string{"run: adduser -D testuser"}, details)
 }
 
+func TestUserCreateAction_Attributes(t *testing.T) {
+	action := &UserCreateAction{UserName: "alice", UID: 1500, Shell: "/bin/bash", Home: "/srv/alice", Gecos: "Alice Liddell"}
+	assert.Equal(t, []string{"run: adduser -D -u 1500 -s '/bin/bash' -h '/srv/alice' -g 'Alice Liddell' alice"}, action.ExecutionDetails())
+}
+
+func TestUserModifyAction(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	passwd := "root:x:0:0:root:/root:/bin/ash\nalice:x:1000:1000::/home/alice:/bin/ash\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(passwd), 0644))
+
+	action := &UserModifyAction{
+		UserName: "alice", UID: 1500, Shell: "/bin/bash", Gecos: "Alice Liddell",
+		CurrentUID: 1000, CurrentShell: "/bin/ash", CurrentHome: "/home/alice",
+	}
+	assert.Equal(t, "Modify user alice (uid, shell, gecos)", action.Description())
+	assert.Equal(t, []string{
+		"set uid: 1000 -> 1500",
+		"run: find '/home/alice' -xdev -user 1000 -exec chown -h 1500 {} +",
+		"set shell: /bin/ash -> /bin/bash",
+		`set gecos: "" -> "Alice Liddell"`,
+	}, action.ExecutionDetails())
+
+	require.NoError(t, action.Apply(runner, logger))
+	content, err := afero.ReadFile(system.AppFs, "/etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, "root:x:0:0:root:/root:/bin/ash\nalice:x:1500:1000:Alice Liddell:/home/alice:/bin/bash\n", string(content))
+	assert.Equal(t, []string{"find '/home/alice' -xdev -user 1000 -exec chown -h 1500 {} +"}, runner.Commands)
+	entries, err := afero.ReadDir(system.AppFs, "/etc")
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "the temporary file is renamed over passwd")
+	assert.Equal(t, "-rw-r--r--", entries[0].Mode().String())
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	require.NoError(t, action.Rollback(runner, logger))
+	content, err = afero.ReadFile(system.AppFs, "/etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, passwd, string(content))
+	assert.Contains(t, runner.Commands, "find '/home/alice' -xdev -user 1500 -exec chown -h 1000 {} +")
+}
+
+func TestUserModifyAction_FailedWriteKeepsPasswd(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	passwd := "alice:x:1000:1000::/home/alice:/bin/ash\n"
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(passwd), 0644))
+	system.AppFs = afero.NewReadOnlyFs(system.AppFs)
+
+	err := (&UserModifyAction{UserName: "alice", Shell: "/bin/bash", CurrentShell: "/bin/ash"}).Apply(runner, logger)
+	require.Error(t, err)
+	content, err := afero.ReadFile(system.AppFs, "/etc/passwd")
+	require.NoError(t, err)
+	assert.Equal(t, passwd, string(content))
+}
+
+func TestUserModifyAction_UnknownUser(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte("root:x:0:0:root:/root:/bin/ash\n"), 0644))
+
+	err := (&UserModifyAction{UserName: "alice", Shell: "/bin/bash"}).Apply(runner, logger)
+	assert.EqualError(t, err, "user alice not found in /etc/passwd")
+}
+
 func TestUserRemoveAction_Apply(t *testing.T) {
 	runner, logger := setupUserTest(t)
 
@@ -121,6 +186,21 @@ func TestGroupCreateAction_ExecutionDetails(t *testing.T) {
 	assert.Equal(t, []string{"run: addgroup testgroup"}, details)
 }
 
+func TestGroupCreateAction_GID(t *testing.T) {
+	assert.Equal(t, []string{"run: addgroup -g 1500 devs"}, (&GroupCreateAction{GroupName: "devs", GID: 1500}).ExecutionDetails())
+	assert.Equal(t, []string{"run: addgroup -S docker"}, (&GroupCreateAction{GroupName: "docker", System: true}).ExecutionDetails())
+}
+
+func TestGroupRemoveAction(t *testing.T) {
+	runner, logger := setupUserTest(t)
+
+	action := &GroupRemoveAction{GroupName: "stale", GID: 1600}
+	assert.Equal(t, "Remove group stale", action.Description())
+	require.NoError(t, action.Apply(runner, logger))
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{"delgroup stale", "addgroup -g 1600 stale"}, runner.Commands)
+}
+
 func TestAddUserToGroupAction_Apply(t *testing.T) {
 	runner, logger := setupUserTest(t)
 
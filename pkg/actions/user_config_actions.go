@@ -0,0 +1,235 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"summit/pkg/log"
+	"summit/pkg/model"
+	"summit/pkg/system"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// UserConfigAction writes a file in a user's home directory. The home is
+// looked up when the action runs, so the file can be written for a user
+// created earlier in the same plan. The file and the parent directories it
+// creates belong to the user and its primary group unless Owner or Group say
+// otherwise.
+type UserConfigAction struct {
+	User      string
+	Path      string // Relative to the user's home
+	Content   string
+	Mode      string
+	Owner     string
+	Group     string
+	Current   string // Content when the plan was made, for the diff
+	Exists    bool   // Whether the file existed when the plan was made
+	Sensitive bool   // Content and diff are never shown
+
+	home        string
+	path        string
+	createdDir  string
+	created     bool
+	origContent string
+	origMode    os.FileMode
+	origUID     int
+	origGID     int
+}
+
+func (a *UserConfigAction) Type() string {
+	return "user.config"
+}
+
+func (a *UserConfigAction) Description() string {
+	if !a.Exists {
+		return fmt.Sprintf("Create file ~%s/%s", a.User, a.Path)
+	}
+	return fmt.Sprintf("Update file ~%s/%s", a.User, a.Path)
+}
+
+func (a *UserConfigAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	logger.Info("Writing user config", "user", a.User, "path", a.Path)
+	home, uid, gid, err := system.LookupHome(a.User)
+	if err != nil {
+		return err
+	}
+	content, err := Secrets.Expand(a.Content)
+	if err != nil {
+		return err
+	}
+	// The home belongs to the user, who could point a link of it at any file
+	path := filepath.Join(home, a.Path)
+	if err := system.RefuseSymlinks(home, path); err != nil {
+		return err
+	}
+	a.home, a.path = home, path
+
+	// Directories are created as the user, whatever the file's ownership
+	if err := a.mkdirs(home, filepath.Dir(a.path), uid, gid); err != nil {
+		return err
+	}
+	fileUID, fileGID, err := a.ownership(uid, gid)
+	if err != nil {
+		return err
+	}
+
+	mode, err := model.ParseMode(model.DefaultFileMode)
+	if err != nil {
+		return err
+	}
+	a.origUID, a.origGID = -1, -1
+	info, err := system.AppFs.Stat(a.path)
+	switch {
+	case os.IsNotExist(err):
+		a.created = true
+	case err != nil:
+		return err
+	default:
+		a.origMode, mode = info.Mode(), info.Mode()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			a.origUID, a.origGID = int(stat.Uid), int(stat.Gid)
+		}
+		orig, err := afero.ReadFile(system.AppFs, a.path)
+		if err != nil {
+			return err
+		}
+		a.origContent = string(orig)
+	}
+	if a.Mode != "" {
+		if mode, err = model.ParseMode(a.Mode); err != nil {
+			return err
+		}
+	}
+
+	return system.WriteFileNoFollow(home, a.path, []byte(content), mode, fileUID, fileGID)
+}
+
+// ownership returns the ids the file belongs to: Owner and Group, or else
+// the user's uid and gid.
+func (a *UserConfigAction) ownership(uid, gid int) (int, int, error) {
+	fileUID, fileGID, err := Resolver.Ownership(a.Owner, a.Group)
+	if err != nil {
+		return 0, 0, err
+	}
+	if fileUID < 0 {
+		fileUID = uid
+	}
+	if fileGID < 0 {
+		fileGID = gid
+	}
+	return fileUID, fileGID, nil
+}
+
+// mkdirs creates the missing directories from home down to dir, owned by
+// uid and gid, and remembers the topmost one so rollback can remove them.
+func (a *UserConfigAction) mkdirs(home, dir string, uid, gid int) error {
+	rel, err := filepath.Rel(home, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+	current := home
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		if exists, _ := afero.DirExists(system.AppFs, current); exists {
+			continue
+		}
+		if err := system.AppFs.Mkdir(current, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", current, err)
+		}
+		if a.createdDir == "" {
+			a.createdDir = current
+		}
+		if err := system.AppFs.Chown(current, uid, gid); err != nil {
+			return fmt.Errorf("failed to chown %s: %w", current, err)
+		}
+	}
+	return nil
+}
+
+func (a *UserConfigAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	if a.path == "" {
+		return nil
+	}
+	logger.Info("Rolling back user config", "user", a.User, "path", a.Path)
+	var err error
+	switch {
+	case a.createdDir != "":
+		err = system.AppFs.RemoveAll(a.createdDir)
+	case a.created:
+		err = system.AppFs.Remove(a.path)
+	default:
+		err = system.WriteFileNoFollow(a.home, a.path, []byte(a.origContent), a.origMode, a.origUID, a.origGID)
+	}
+	if err != nil {
+		logger.Error("Failed to roll back user config", "path", a.path, "error", err)
+	}
+	return err
+}
+
+func (a *UserConfigAction) ExecutionDetails() []string {
+	mode := a.Mode
+	if mode == "" && !a.Exists {
+		mode = model.DefaultFileMode + " (default)"
+	}
+	owner, group := a.Owner, a.Group
+	if owner == "" {
+		owner = a.User
+	}
+	if group == "" {
+		group = "primary group of " + a.User
+	}
+	details := []string{fmt.Sprintf("write file: ~%s/%s", a.User, a.Path)}
+	if mode != "" {
+		details = append(details, fmt.Sprintf("set permissions to %s", mode))
+	}
+	details = append(details, fmt.Sprintf("set owner to %s and group to %s", owner, group))
+	if a.Sensitive {
+		return append(details, RedactedDiff)
+	}
+	details = append(details, "--- diff ---")
+	details = append(details, lineDiff(Secrets.Redact(a.Current), a.Content, DiffContextLines, DiffMaxLines)...)
+	return append(details, "--- end diff ---")
+}
+
+func (a *UserConfigAction) RollbackDetails() []string {
+	if !a.Exists {
+		return []string{fmt.Sprintf("delete ~%s/%s and the directories created for it", a.User, a.Path)}
+	}
+	return []string{fmt.Sprintf("restore the previous content, mode and ownership of ~%s/%s", a.User, a.Path)}
+}
+
+func (a *UserConfigAction) Check(runner system.CommandRunner) (bool, error) {
+	home, uid, gid, err := system.LookupHome(a.User)
+	if err != nil {
+		return false, nil
+	}
+	path := filepath.Join(home, a.Path)
+	if system.RefuseSymlinks(home, path) != nil {
+		return false, nil
+	}
+	content, err := Secrets.Expand(a.Content)
+	if err != nil {
+		return false, err
+	}
+	if done, err := fileHasContent(path, content); !done || err != nil {
+		return false, err
+	}
+	info, err := system.AppFs.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if a.Mode != "" && model.NormalizeMode(a.Mode) != model.FormatMode(info.Mode()) {
+		return false, nil
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		fileUID, fileGID, err := a.ownership(uid, gid)
+		if err != nil {
+			return false, err
+		}
+		return int(stat.Uid) == fileUID && int(stat.Gid) == fileGID, nil
+	}
+	return true, nil
+}
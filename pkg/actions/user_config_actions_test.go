@@ -0,0 +1,117 @@
+package actions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"summit/pkg/log"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUserConfigAction_CreatesParentDirectories(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(testPasswd), 0644))
+	require.NoError(t, system.AppFs.MkdirAll("/home/alice", 0755))
+
+	action := &UserConfigAction{User: "alice", Path: ".config/git/config", Content: "[user]\n\tname = Alice\n", Mode: "0600"}
+	assert.Equal(t, "Create file ~alice/.config/git/config", action.Description())
+	require.NoError(t, action.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/home/alice/.config/git/config")
+	require.NoError(t, err)
+	assert.Equal(t, "[user]\n\tname = Alice\n", string(content))
+	info, err := system.AppFs.Stat("/home/alice/.config/git/config")
+	require.NoError(t, err)
+	assert.Equal(t, "-rw-------", info.Mode().String())
+	done, err := action.Check(runner)
+	require.NoError(t, err)
+	assert.True(t, done)
+
+	require.NoError(t, action.Rollback(runner, logger))
+	exists, _ := afero.DirExists(system.AppFs, "/home/alice/.config")
+	assert.False(t, exists, "the directories created by apply are removed on rollback")
+}
+
+func TestUserConfigAction_UpdateRollback(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(testPasswd), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/home/alice/.vimrc", []byte("set nu\n"), 0640))
+
+	action := &UserConfigAction{User: "alice", Path: ".vimrc", Content: "set nu\nsyntax on\n", Current: "set nu\n", Exists: true}
+	assert.Equal(t, "Update file ~alice/.vimrc", action.Description())
+	assert.Contains(t, action.ExecutionDetails(), "+syntax on")
+	require.NoError(t, action.Apply(runner, logger))
+
+	content, err := afero.ReadFile(system.AppFs, "/home/alice/.vimrc")
+	require.NoError(t, err)
+	assert.Equal(t, "set nu\nsyntax on\n", string(content))
+	info, err := system.AppFs.Stat("/home/alice/.vimrc")
+	require.NoError(t, err)
+	assert.Equal(t, "-rw-r-----", info.Mode().String(), "the mode is kept when none is declared")
+
+	require.NoError(t, action.Rollback(runner, logger))
+	content, err = afero.ReadFile(system.AppFs, "/home/alice/.vimrc")
+	require.NoError(t, err)
+	assert.Equal(t, "set nu\n", string(content))
+}
+
+func TestUserConfigAction_UnknownUser(t *testing.T) {
+	runner, logger := setupFileTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(testPasswd), 0644))
+
+	action := &UserConfigAction{User: "bob", Path: ".vimrc", Content: "set nu\n"}
+	assert.ErrorContains(t, action.Apply(runner, logger), "user bob not found in /etc/passwd")
+	assert.NoError(t, action.Rollback(runner, logger))
+}
+
+// setupSymlinkTest backs system.AppFs with a temporary directory of the real
+// filesystem, which has the symlinks MemMapFs lacks, holding the passwd entry
+// of alice and her home. It also returns the directory.
+func setupSymlinkTest(t *testing.T) (*MockCommandRunner, log.Logger, string) {
+	runner, logger := setupFileTest(t)
+	root := t.TempDir()
+	system.AppFs = afero.NewBasePathFs(afero.NewOsFs(), root)
+	require.NoError(t, system.AppFs.MkdirAll("/etc", 0755))
+	passwd := fmt.Sprintf("alice:x:%d:%d::/home/alice:/bin/ash\n", os.Getuid(), os.Getgid())
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/passwd", []byte(passwd), 0644))
+	require.NoError(t, system.AppFs.MkdirAll("/home/alice", 0755))
+	return runner, logger, root
+}
+
+func TestUserConfigAction_RefusesSymlinks(t *testing.T) {
+	runner, logger, root := setupSymlinkTest(t)
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/shadow", []byte("root:secret\n"), 0600))
+	require.NoError(t, os.Symlink(filepath.Join(root, "etc/shadow"), filepath.Join(root, "home/alice/.vimrc")))
+	require.NoError(t, os.Symlink(filepath.Join(root, "etc"), filepath.Join(root, "home/alice/.config")))
+
+	for _, path := range []string{".vimrc", ".config/shadow", ".config/new/file"} {
+		action := &UserConfigAction{User: "alice", Path: path, Content: "owned\n"}
+		done, err := action.Check(runner)
+		require.NoError(t, err)
+		assert.False(t, done, path)
+		assert.ErrorContains(t, action.Apply(runner, logger), "refusing to follow symlink", path)
+		require.NoError(t, action.Rollback(runner, logger))
+	}
+
+	content, err := afero.ReadFile(system.AppFs, "/etc/shadow")
+	require.NoError(t, err)
+	assert.Equal(t, "root:secret\n", string(content), "the target of the link is left alone")
+	exists, _ := afero.Exists(system.AppFs, "/etc/new")
+	assert.False(t, exists, "no directory is created through a link")
+}
+
+func TestUserConfigAction_SensitiveDetails(t *testing.T) {
+	action := &UserConfigAction{User: "alice", Path: ".netrc", Content: "password s3cret\n", Mode: "0600", Current: "password old\n", Exists: true, Sensitive: true}
+	assert.Equal(t, []string{
+		"write file: ~alice/.netrc",
+		"set permissions to 0600",
+		"set owner to alice and group to primary group of alice",
+		RedactedDiff,
+	}, action.ExecutionDetails())
+}
@@ -1,6 +1,7 @@
 package actions
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"summit/pkg/log"
@@ -10,7 +11,7 @@ import (
 
 type UserPackageAction struct {
 	User    string
-	Manager string // "pipx", "npm"
+	Manager string // "pipx", "npm", "cargo", "gem", "uv"
 	Package string
 	State   model.UserPackageActionState // "present" or "absent"
 }
@@ -30,9 +31,9 @@ func (a UserPackageAction) Apply(runner system.CommandRunner, logger log.Logger)
 
 	switch a.State {
 	case model.PackageStatePresent:
-		command = fmt.Sprintf("%s install %s", a.Manager, a.Package)
+		command = system.UserPackageCommand(a.Manager, a.Package, true)
 	case model.PackageStateAbsent:
-		command = fmt.Sprintf("%s uninstall %s", a.Manager, a.Package)
+		command = system.UserPackageCommand(a.Manager, a.Package, false)
 	default:
 		return fmt.Errorf("unknown user package state: %s", a.State)
 	}
@@ -46,6 +47,17 @@ func (a UserPackageAction) Ptr() Action {
 	return &a
 }
 
+func (a UserPackageAction) Type() string {
+	return "userpackage.ensure"
+}
+
+func (a UserPackageAction) Removals() (string, int) {
+	if a.State == model.PackageStateAbsent {
+		return model.LimitUserPackageRemovals, 1
+	}
+	return model.LimitUserPackageRemovals, 0
+}
+
 func (a UserPackageAction) Description() string {
 	return fmt.Sprintf("Ensure user package '%s' for user '%s' managed by '%s' is %s", a.Package, a.User, a.Manager, a.State)
 }
@@ -75,10 +87,107 @@ func (a UserPackageAction) Rollback(runner system.CommandRunner, logger log.Logg
 }
 
 func (a UserPackageAction) ExecutionDetails() []string {
-	verb := "install"
-	if a.State == model.PackageStateAbsent {
-		verb = "uninstall"
+	install := a.State != model.PackageStateAbsent
+	return []string{system.UserCommand(a.User, system.UserPackageCommand(a.Manager, a.Package, install))}
+}
+
+func (a UserPackageAction) RollbackDetails() []string {
+	install := a.State == model.PackageStateAbsent
+	return []string{system.UserCommand(a.User, system.UserPackageCommand(a.Manager, a.Package, install))}
+}
+
+// UserPackageBatchInstallAction installs several packages of a user with one
+// command of the manager, e.g. pipx install black ruff poetry, rather than one
+// su session per package. When the command fails, the packages it left out
+// are installed one by one so that the error names each package that failed;
+// should any fail, those that got installed are uninstalled again.
+type UserPackageBatchInstallAction struct {
+	User     string
+	Manager  string
+	Packages []string
+
+	installed []string
+}
+
+func (a *UserPackageBatchInstallAction) Type() string {
+	return "userpackage.install-batch"
+}
+
+func (a *UserPackageBatchInstallAction) Description() string {
+	return fmt.Sprintf("Install user packages %s for user '%s' managed by '%s'", strings.Join(a.Packages, ", "), a.User, a.Manager)
+}
+
+func (a *UserPackageBatchInstallAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if strings.TrimSpace(a.User) == "" {
+		return fmt.Errorf("user cannot be empty")
+	}
+	if strings.TrimSpace(a.Manager) == "" {
+		return fmt.Errorf("manager cannot be empty")
+	}
+	if len(a.Packages) == 0 {
+		return fmt.Errorf("packages cannot be empty")
+	}
+
+	command := system.UserPackageInstallCommand(a.Manager, a.Packages)
+	logger.Info("Running user package command", "user", a.User, "manager", a.Manager, "command", command)
+	_, err := runner.Run(a.User, command)
+	if err == nil {
+		a.installed = a.Packages
+		return nil
+	}
+
+	// Find out which packages the command did install before it failed; when
+	// the manager cannot tell, every package is retried
+	logger.Warn("User package batch install failed, installing the packages one by one", "user", a.User, "manager", a.Manager, "error", err)
+	present := make(map[string]bool)
+	if listed, listErr := system.ListUserPackages(a.User, a.Manager, runner); listErr == nil {
+		for _, p := range listed {
+			present[p] = true
+		}
+	}
+
+	a.installed = nil
+	var errs []error
+	for _, pkg := range a.Packages {
+		if !present[pkg] {
+			if _, err := runner.Run(a.User, system.UserPackageCommand(a.Manager, pkg, true)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", pkg, err))
+				continue
+			}
+		}
+		a.installed = append(a.installed, pkg)
+	}
+	if len(errs) > 0 {
+		// The executor only rolls back the actions that succeeded
+		_ = a.Rollback(runner, logger)
+		a.installed = nil
+		return fmt.Errorf("failed to install %s packages for user %s: %w", a.Manager, a.User, errors.Join(errs...))
+	}
+	return nil
+}
+
+func (a *UserPackageBatchInstallAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	var errs []error
+	for _, pkg := range a.installed {
+		if _, err := runner.Run(a.User, system.UserPackageCommand(a.Manager, pkg, false)); err != nil {
+			logger.Error("Failed to roll back user package action", "user", a.User, "package", pkg, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		logger.Warn("The user's package environment may be in an inconsistent state and may require manual intervention.", "manager", a.Manager)
+	}
+	return errors.Join(errs...)
+}
+
+func (a *UserPackageBatchInstallAction) ExecutionDetails() []string {
+	return []string{system.UserCommand(a.User, system.UserPackageInstallCommand(a.Manager, a.Packages))}
+}
+
+func (a *UserPackageBatchInstallAction) RollbackDetails() []string {
+	details := make([]string, 0, len(a.Packages))
+	for _, pkg := range a.Packages {
+		details = append(details, system.UserCommand(a.User, system.UserPackageCommand(a.Manager, pkg, false)))
 	}
-	command := fmt.Sprintf("su -l %s -c '%s %s %s'", a.User, a.Manager, verb, a.Package)
-	return []string{command}
+	return details
 }
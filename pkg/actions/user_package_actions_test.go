@@ -2,6 +2,7 @@ package actions
 
 import (
 	"bytes"
+	"errors"
 	"log/slog"
 	"testing"
 
@@ -56,6 +57,47 @@ func TestUserPackageAction_Apply_Absent(t *testing.T) {
 	assert.Contains(t, runner.Commands, "npm uninstall lodash")
 }
 
+func TestUserPackageAction_Apply_GemAndUv(t *testing.T) {
+	runner, logger := setupUserPackageTest(t)
+
+	require.NoError(t, UserPackageAction{User: "testuser", Manager: "gem", Package: "rake", State: model.PackageStatePresent}.Apply(runner, logger))
+	require.NoError(t, UserPackageAction{User: "testuser", Manager: "gem", Package: "rake", State: model.PackageStateAbsent}.Apply(runner, logger))
+	require.NoError(t, UserPackageAction{User: "testuser", Manager: "uv", Package: "ruff", State: model.PackageStatePresent}.Apply(runner, logger))
+
+	assert.Equal(t, []string{"gem install --user-install rake", "gem uninstall --user-install -x rake", "uv tool install ruff"}, runner.Commands)
+}
+
+func TestUserPackageBatchInstallAction_Apply(t *testing.T) {
+	runner, logger := setupUserPackageTest(t)
+
+	action := &UserPackageBatchInstallAction{User: "testuser", Manager: "pipx", Packages: []string{"black", "poetry", "ruff"}}
+	require.NoError(t, action.Apply(runner, logger))
+	assert.Equal(t, []string{"pipx install black poetry ruff"}, runner.Commands)
+
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Equal(t, []string{"pipx install black poetry ruff", "pipx uninstall black", "pipx uninstall poetry", "pipx uninstall ruff"}, runner.Commands)
+}
+
+func TestUserPackageBatchInstallAction_Apply_AttributesFailures(t *testing.T) {
+	runner, logger := setupUserPackageTest(t)
+	runner.Errors["testuser:pipx install black poetry ruff"] = errors.New("exit status 1")
+	runner.Responses["testuser:pipx list --json"] = []byte(`{"venvs": {"black": {"metadata": {"package": "black"}}}}`)
+	runner.Errors["testuser:pipx install ruff"] = errors.New("No matching distribution found for ruff")
+
+	action := &UserPackageBatchInstallAction{User: "testuser", Manager: "pipx", Packages: []string{"black", "poetry", "ruff"}}
+	err := action.Apply(runner, logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ruff: No matching distribution found for ruff")
+	assert.NotContains(t, err.Error(), "poetry:")
+	// The packages that did get installed are uninstalled again by the
+	// failed action itself, as the executor only rolls back applied ones
+	assert.Equal(t, []string{"pipx install black poetry ruff", "pipx list --json", "pipx install poetry", "pipx install ruff", "pipx uninstall black", "pipx uninstall poetry"}, runner.Commands)
+
+	runner.Commands = nil
+	require.NoError(t, action.Rollback(runner, logger))
+	assert.Empty(t, runner.Commands, "nothing is left to roll back")
+}
+
 func TestUserPackageAction_Rollback(t *testing.T) {
 	runner, logger := setupUserPackageTest(t)
 
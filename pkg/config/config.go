@@ -1,11 +1,16 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/fs"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
+	"summit/pkg/facts"
 	"summit/pkg/log"
 	"summit/pkg/model"
 	"summit/pkg/system"
@@ -15,7 +20,15 @@ import (
 )
 
 func LoadConfig(filename string, logger log.Logger) (*model.SystemState, error) {
-	cfg, err := loadConfigFile(filename, logger)
+	var m *manifest
+	if Integrity != nil {
+		var err error
+		if m, err = loadManifest(filename, Integrity); err != nil {
+			return nil, err
+		}
+	}
+
+	cfg, err := loadConfigFile(filename, m, logger)
 	if err != nil {
 		return nil, err
 	}
@@ -26,15 +39,27 @@ func LoadConfig(filename string, logger log.Logger) (*model.SystemState, error)
 	}
 
 	// Process includes recursively
+	profiles := cfg.Profiles
 	if len(cfg.Includes) > 0 {
-		cfg, err = processIncludes(cfg, filename, logger)
+		cfg, err = processIncludes(cfg, filename, m, logger)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	cfg, err = applyProfiles(cfg, profiles, filename, m, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	// host-overlays may come from any document, include or profile
+	cfg, err = applyHostOverlay(cfg, cfg.HostOverlays, filename, m, logger)
+	if err != nil {
+		return nil, err
+	}
+
 	if errs := cfg.Validate(); len(errs) > 0 {
-		return nil, errs
+		return nil, cfg.LocateErrors(errs)
 	}
 
 	cfg.Sort()
@@ -42,14 +67,101 @@ func LoadConfig(filename string, logger log.Logger) (*model.SystemState, error)
 	return &cfg, nil
 }
 
+// DefaultHostOverlays is the directory of per-host overlays when the config
+// does not set host-overlays.
+const DefaultHostOverlays = "hosts"
+
+// Hostname returns the name of the host whose overlay LoadConfig merges.
+var Hostname = facts.Hostname
+
+// applyHostOverlay merges <dir>/<hostname>.yaml, relative to the top-level
+// config file, on top of cfg when the file exists, so one invocation serves a
+// fleet sharing a base config. The overlay takes priority over everything
+// else, profiles included, and may have its own includes.
+func applyHostOverlay(cfg model.SystemState, dir, filename string, m *manifest, logger log.Logger) (model.SystemState, error) {
+	if dir == "none" {
+		return cfg, nil
+	}
+	if dir == "" {
+		dir = DefaultHostOverlays
+	}
+	hostname := Hostname()
+	if hostname == "" {
+		return cfg, nil
+	}
+	path := resolveIncludePath(filename, filepath.Join(dir, hostname+".yaml"))
+	if exists, err := afero.Exists(system.AppFs, path); err != nil || !exists {
+		return cfg, err
+	}
+
+	overlay, err := loadConfigFile(path, m, logger)
+	if err != nil {
+		return model.SystemState{}, fmt.Errorf("failed to load host overlay '%s': %w", path, err)
+	}
+	if len(overlay.Profiles) > 0 {
+		return model.SystemState{}, fmt.Errorf("failed to load host overlay '%s': profiles can only be declared in the top-level config", path)
+	}
+	if errs := validateIncludes(overlay.Includes); len(errs) > 0 {
+		return model.SystemState{}, errs
+	}
+	if len(overlay.Includes) > 0 {
+		if overlay, err = processIncludes(overlay, path, m, logger); err != nil {
+			return model.SystemState{}, err
+		}
+	}
+	logger.Info("Merging host overlay", "host", hostname, "path", path)
+	return *mergeConfigs(&cfg, &overlay, logger), nil
+}
+
+// Profiles are the names of the profiles LoadConfig merges on top of the
+// config, in order, as selected with --profile.
+var Profiles []string
+
+// applyProfiles merges the selected profiles on top of cfg, each with its own
+// includes, so a profile takes priority over the rest of the config.
+func applyProfiles(cfg model.SystemState, profiles map[string]*model.SystemState, filename string, m *manifest, logger log.Logger) (model.SystemState, error) {
+	for _, name := range Profiles {
+		profile, ok := profiles[name]
+		if !ok {
+			return model.SystemState{}, fmt.Errorf("unknown profile '%s' (declared: %s)", name, profileNames(profiles))
+		}
+		p := *profile
+		if errs := validateIncludes(p.Includes); len(errs) > 0 {
+			return model.SystemState{}, fmt.Errorf("profile '%s': %w", name, errs)
+		}
+		if len(p.Includes) > 0 {
+			var err error
+			if p, err = processIncludes(p, filename, m, logger); err != nil {
+				return model.SystemState{}, fmt.Errorf("profile '%s': %w", name, err)
+			}
+		}
+		logger.Debug("Applying profile", "profile", name)
+		cfg = *mergeConfigs(&cfg, &p, logger)
+	}
+	cfg.Profiles = nil
+	return cfg, nil
+}
+
+func profileNames(profiles map[string]*model.SystemState) string {
+	if len(profiles) == 0 {
+		return "none"
+	}
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
 // processIncludes processes the includes field of a SystemState, loading and merging
 // included configuration files recursively.
-func processIncludes(cfg model.SystemState, baseFile string, logger log.Logger) (model.SystemState, error) {
+func processIncludes(cfg model.SystemState, baseFile string, m *manifest, logger log.Logger) (model.SystemState, error) {
 	visited := make(map[string]bool) // For cycle detection
-	return processIncludesRecursive(cfg, baseFile, visited, logger)
+	return processIncludesRecursive(cfg, baseFile, visited, m, logger)
 }
 
-func processIncludesRecursive(cfg model.SystemState, baseFile string, visited map[string]bool, logger log.Logger) (model.SystemState, error) {
+func processIncludesRecursive(cfg model.SystemState, baseFile string, visited map[string]bool, m *manifest, logger log.Logger) (model.SystemState, error) {
 	result := &model.SystemState{}
 
 	// Track this file to prevent cycles
@@ -66,14 +178,17 @@ func processIncludesRecursive(cfg model.SystemState, baseFile string, visited ma
 	for _, includePath := range cfg.Includes {
 		resolvedPath := resolveIncludePath(baseFile, includePath)
 
-		includedCfg, err := loadConfigFile(resolvedPath, logger)
+		includedCfg, err := loadConfigFile(resolvedPath, m, logger)
 		if err != nil {
 			return model.SystemState{}, fmt.Errorf("failed to load include '%s': %w", includePath, err)
 		}
+		if len(includedCfg.Profiles) > 0 {
+			return model.SystemState{}, fmt.Errorf("failed to load include '%s': profiles can only be declared in the top-level config", includePath)
+		}
 
 		// Recursively process nested includes
 		if len(includedCfg.Includes) > 0 {
-			includedCfg, err = processIncludesRecursive(includedCfg, resolvedPath, visited, logger)
+			includedCfg, err = processIncludesRecursive(includedCfg, resolvedPath, visited, m, logger)
 			if err != nil {
 				return model.SystemState{}, err
 			}
@@ -89,25 +204,184 @@ func processIncludesRecursive(cfg model.SystemState, baseFile string, visited ma
 	return *result, nil
 }
 
-func loadConfigFile(filename string, logger log.Logger) (model.SystemState, error) {
+// loadConfigFile reads and decodes a single config file. When m is not nil
+// the file must match its checksum in the signed manifest. Files encrypted
+// with sops or age are decrypted first, and the configs they declare are
+// marked sensitive.
+//
+// A file may hold several YAML documents separated by "---". They are merged
+// in order with the same semantics as includes, later documents taking
+// priority, and the includes of every document are kept for processIncludes.
+func loadConfigFile(filename string, m *manifest, logger log.Logger) (model.SystemState, error) {
 	f, err := afero.ReadFile(system.AppFs, filename)
 	if err != nil {
 		return model.SystemState{}, err
 	}
-
-	var cfg model.SystemState
-	err = yaml.Unmarshal(f, &cfg)
+	if m != nil {
+		if err := m.verify(filename, f); err != nil {
+			return model.SystemState{}, err
+		}
+	}
+	// The manifest covers the file as committed, encrypted
+	f, encrypted, err := decrypt(filename, f, "yaml")
 	if err != nil {
 		return model.SystemState{}, err
 	}
 
-	for i := range cfg.Configs {
-		cfg.Configs[i].Origin = model.OriginManaged
+	var cfg model.SystemState
+	decoder := yaml.NewDecoder(bytes.NewReader(f))
+	for doc := 1; ; doc++ {
+		var node yaml.Node
+		err := decoder.Decode(&node)
+		if err == io.EOF {
+			break
+		}
+		var part model.SystemState
+		var migrated model.ValidationErrors
+		if err == nil {
+			migrated, err = migrate(&node, filename)
+		}
+		if err == nil {
+			err = node.Decode(&part)
+		}
+		if err != nil {
+			if doc > 1 {
+				return model.SystemState{}, fmt.Errorf("document %d: %w", doc, err)
+			}
+			return model.SystemState{}, err
+		}
+		part.LoadWarnings = append(part.LoadWarnings, migrated...)
+		part.RecordProvenance(&node, filename)
+		if encrypted {
+			markSensitive(&part)
+		}
+		if err := loadSources(&part, filename, m); err != nil {
+			return model.SystemState{}, err
+		}
+		if err := loadProfiles(&part, &node, filename, m); err != nil {
+			return model.SystemState{}, err
+		}
+
+		for i := range part.Configs {
+			part.Configs[i].Origin = model.OriginManaged
+		}
+		// Each document can set its own default team
+		part.LabelTeam()
+		if part.Role != nil {
+			part.Role.File = filename
+			part.Roles = []model.RoleState{*part.Role}
+		}
+
+		if doc == 1 {
+			cfg = part
+			continue
+		}
+		includes := append(cfg.Includes, part.Includes...)
+		profiles := cfg.Profiles
+		for name, p := range part.Profiles {
+			if profiles == nil {
+				profiles = make(map[string]*model.SystemState)
+			}
+			profiles[name] = p
+		}
+		cfg = *mergeConfigs(&cfg, &part, logger)
+		cfg.Includes, cfg.Profiles = includes, profiles
 	}
 
 	return cfg, nil
 }
 
+// loadSources reads the content of the configs declared with a source, a
+// path relative to the file declaring them, so large files need not be
+// inlined as block scalars. In a signed tree the sources must match their
+// checksums in the manifest like any config file, and encrypted sources are
+// decrypted like encrypted config files and marked sensitive.
+func loadSources(cfg *model.SystemState, filename string, m *manifest) error {
+	var errs model.ValidationErrors
+	for i := range cfg.Configs {
+		c := &cfg.Configs[i]
+		if c.Source == "" {
+			continue
+		}
+		field := fmt.Sprintf("configs[%d].source", i)
+		if c.Content != "" {
+			errs = append(errs, model.ValidationError{Field: field, Message: "source and content cannot both be set"})
+			continue
+		}
+		path := resolveIncludePath(filename, c.Source)
+		content, err := afero.ReadFile(system.AppFs, path)
+		if err == nil && m != nil {
+			err = m.verify(path, content)
+		}
+		encrypted := false
+		if err == nil {
+			// Sources are files of any format, which sops tells by extension
+			content, encrypted, err = decrypt(path, content, "")
+		}
+		if err != nil {
+			errs = append(errs, model.ValidationError{Field: field, Message: err.Error()})
+			continue
+		}
+		c.Content = string(content)
+		c.Sensitive = c.Sensitive || encrypted
+	}
+	if len(errs) > 0 {
+		return cfg.LocateErrors(errs)
+	}
+	return nil
+}
+
+// loadProfiles prepares the profiles declared in a document like the document
+// itself: their provenance is recorded, their sources read and their
+// resources labeled with the profile's team, or else the document's.
+func loadProfiles(part *model.SystemState, doc *yaml.Node, filename string, m *manifest) error {
+	if len(part.Profiles) == 0 {
+		return nil
+	}
+	nodes := make(map[string]*yaml.Node)
+	root := doc
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value != "profiles" {
+				continue
+			}
+			profiles := root.Content[i+1]
+			for j := 0; j+1 < len(profiles.Content); j += 2 {
+				nodes[profiles.Content[j].Value] = profiles.Content[j+1]
+			}
+		}
+	}
+	for name, p := range part.Profiles {
+		if p == nil {
+			p = &model.SystemState{}
+			part.Profiles[name] = p
+		}
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("profile name cannot be empty")
+		}
+		if len(p.Profiles) > 0 {
+			return fmt.Errorf("profile '%s': profiles cannot be nested", name)
+		}
+		if node, ok := nodes[name]; ok {
+			p.RecordProvenance(node, filename)
+		}
+		if err := loadSources(p, filename, m); err != nil {
+			return fmt.Errorf("profile '%s': %w", name, err)
+		}
+		for i := range p.Configs {
+			p.Configs[i].Origin = model.OriginManaged
+		}
+		if p.Team == "" {
+			p.Team = part.Team
+		}
+		p.LabelTeam()
+	}
+	return nil
+}
+
 func resolveIncludePath(baseFile, includePath string) string {
 	// If absolute path, use as-is
 	if filepath.IsAbs(includePath) {
@@ -120,18 +394,56 @@ func resolveIncludePath(baseFile, includePath string) string {
 }
 
 // mergeConfigs merges two SystemState configurations using entity-specific strategies:
+// - Roles: union by file
 // - Packages: union by name
+// - VirtualPackages: last-wins by name
 // - Services: last-wins by (name + runlevel) with warnings
-// - Users: last-wins for properties, union for groups
+// - Users: last-wins for properties (uid, shell, home and gecos only when set), union for groups and authorized keys, crontab jobs last-wins by name
+// - Groups: last-wins by name
 // - Configs: last-wins by path
+// - UserConfigs: last-wins by user and path
+// - ManagedBlocks: last-wins by path and name
+// - ServiceOptions: merged by service, last-wins by option
 // - UserPackages: union packages within each manager
-// - IgnoredConfigs: union all patterns
+// - IgnoredConfigs, IgnoredServices, IgnoredUsers, IgnoredPackages, IntrinsicIgnores: union all patterns
+// - Plugins: last-wins by name
+// - Commands: last-wins by name, in the order first declared
+// - Assertions: concatenated, base first
+// - Hooks: concatenated per stage, base first
+// - PackageOwnedConfigs: override policy wins if set
+// - PackageIndexUpdate: override policy wins if set
+// - OfflineRepository: override wins if set
+// - ModifiedFiles: override default wins, override path rules take precedence
+// - ApplyWindows: union all expressions
+// - Secrets: override provider wins if set
+// - Proxy: override proxy wins if set
+// - EtcHistory: override wins if set
+// - Timezone, Locale: override wins if set
+// - HostOverlays: override wins if set
+// - Limits: override limits win if set
+// - Sysctl: last-wins by key
+// - Vars: last-wins by key
+// - HostVars: merged per hostname, last-wins by key
+// - Provenance: follows the entities above, recording every redeclaration
 // The override configuration takes priority over the base.
 func mergeConfigs(base, override *model.SystemState, logger log.Logger) *model.SystemState {
 	result := &model.SystemState{}
 
-	// Packages: Union by name
-	result.Packages = mergePackages(base.Packages, override.Packages)
+	// Warnings: Carry forward from both sides
+	result.LoadWarnings = append(append(result.LoadWarnings, base.LoadWarnings...), override.LoadWarnings...)
+
+	// Roles: Keep every role header, once per file
+	result.Roles = mergeRoles(base.Roles, override.Roles)
+
+	// Packages: Union by name, warn on duplicates
+	var duplicates []string
+	result.Packages, duplicates = mergePackages(base.Packages, override.Packages)
+	for _, name := range duplicates {
+		result.LoadWarnings = append(result.LoadWarnings, model.ValidationError{Field: "packages", Message: fmt.Sprintf("package '%s' is declared in more than one included file", name)})
+	}
+
+	// VirtualPackages: Last-wins by name
+	result.VirtualPackages = mergeVirtualPackages(base.VirtualPackages, override.VirtualPackages)
 
 	// Services: Last-wins by (name + runlevel)
 	result.Services = mergeServices(base.Services, override.Services, logger)
@@ -139,23 +451,180 @@ func mergeConfigs(base, override *model.SystemState, logger log.Logger) *model.S
 	// Users: Last-wins by name, union groups
 	result.Users = mergeUsers(base.Users, override.Users, logger)
 
+	// Groups: Last-wins by name
+	result.Groups = mergeGroups(base.Groups, override.Groups, logger)
+
 	// Configs: Last-wins by path
 	result.Configs = mergeSystemConfigs(base.Configs, override.Configs, logger)
 
+	// UserConfigs: Last-wins by user and path
+	result.UserConfigs = mergeUserConfigs(base.UserConfigs, override.UserConfigs, logger)
+
+	// ManagedBlocks: Last-wins by path and name
+	result.ManagedBlocks = mergeManagedBlocks(base.ManagedBlocks, override.ManagedBlocks)
+
+	// ServiceOptions: Merge by service, last-wins by option
+	result.ServiceOptions = mergeServiceOptions(base.ServiceOptions, override.ServiceOptions)
+
 	// UserPackages: Merge by user, union package lists
 	result.UserPackages = mergeUserPackages(base.UserPackages, override.UserPackages, logger)
 
 	// IgnoredConfigs: Union (append all patterns)
 	result.IgnoredConfigs = mergeIgnoredConfigs(base.IgnoredConfigs, override.IgnoredConfigs)
+	result.IgnoredServices = mergeIgnoredConfigs(base.IgnoredServices, override.IgnoredServices)
+	result.IgnoredUsers = mergeIgnoredConfigs(base.IgnoredUsers, override.IgnoredUsers)
+	result.IgnoredPackages = mergeIgnoredConfigs(base.IgnoredPackages, override.IgnoredPackages)
+	result.IntrinsicIgnores = mergeIgnoredConfigs(base.IntrinsicIgnores, override.IntrinsicIgnores)
+
+	// Plugins: Last-wins by name
+	result.Plugins = mergePlugins(base.Plugins, override.Plugins, logger)
+
+	// Commands: Last-wins by name, in declaration order
+	result.Commands = mergeCommands(base.Commands, override.Commands)
+
+	// Assertions: Keep every check, in include order
+	result.Assertions = append(append(result.Assertions, base.Assertions...), override.Assertions...)
+
+	// Hooks: Keep every hook of each stage, in include order
+	result.Hooks = mergeHooks(base.Hooks, override.Hooks)
+
+	// PackageOwnedConfigs: Override policy wins
+	result.PackageOwnedConfigs = base.PackageOwnedConfigs
+	if override.PackageOwnedConfigs != "" {
+		result.PackageOwnedConfigs = override.PackageOwnedConfigs
+	}
+
+	// PackageIndexUpdate: Override policy wins
+	result.PackageIndexUpdate = base.PackageIndexUpdate
+	if override.PackageIndexUpdate != "" {
+		result.PackageIndexUpdate = override.PackageIndexUpdate
+	}
+
+	// OfflineRepository: Override wins
+	result.OfflineRepository = base.OfflineRepository
+	if override.OfflineRepository != "" {
+		result.OfflineRepository = override.OfflineRepository
+	}
+
+	// ModifiedFiles: Override default wins; its rules are checked before base rules
+	result.ModifiedFiles = base.ModifiedFiles
+	if override.ModifiedFiles.Default != "" {
+		result.ModifiedFiles.Default = override.ModifiedFiles.Default
+	}
+	result.ModifiedFiles.Paths = append(append([]model.ModifiedFileRule{}, override.ModifiedFiles.Paths...), base.ModifiedFiles.Paths...)
+
+	// ApplyWindows: Union, applying is allowed in any of them
+	result.ApplyWindows = mergeIgnoredConfigs(base.ApplyWindows, override.ApplyWindows)
+
+	// Secrets: Override provider wins
+	result.Secrets = base.Secrets
+	if override.Secrets != nil {
+		result.Secrets = override.Secrets
+	}
+
+	// Proxy: Override proxy wins
+	result.Proxy = base.Proxy
+	if override.Proxy != nil {
+		result.Proxy = override.Proxy
+	}
+
+	// EtcHistory: Override wins
+	result.EtcHistory = base.EtcHistory
+	if override.EtcHistory != "" {
+		result.EtcHistory = override.EtcHistory
+	}
+
+	// Timezone, Locale: Override wins
+	result.Timezone = base.Timezone
+	if override.Timezone != "" {
+		result.Timezone = override.Timezone
+	}
+	result.Locale = base.Locale
+	if override.Locale != "" {
+		result.Locale = override.Locale
+	}
+
+	// HostOverlays: Override wins
+	result.HostOverlays = base.HostOverlays
+	if override.HostOverlays != "" {
+		result.HostOverlays = override.HostOverlays
+	}
+
+	// Sysctl: Last-wins by key
+	for key, value := range base.Sysctl {
+		result.Sysctl = setSysctl(result.Sysctl, key, value)
+	}
+	for key, value := range override.Sysctl {
+		result.Sysctl = setSysctl(result.Sysctl, key, value)
+	}
+
+	// Limits: Override wins
+	result.Limits = base.Limits
+	if override.Limits != nil {
+		result.Limits = override.Limits
+	}
+
+	// Vars: Last-wins by key, per hostname for host-vars
+	result.Vars = mergeVars(base.Vars, override.Vars)
+	for host, vars := range base.HostVars {
+		result.HostVars = setHostVars(result.HostVars, host, mergeVars(result.HostVars[host], vars))
+	}
+	for host, vars := range override.HostVars {
+		result.HostVars = setHostVars(result.HostVars, host, mergeVars(result.HostVars[host], vars))
+	}
+
+	result.Provenance = mergeProvenance(base.Provenance, override.Provenance)
 
 	// Note: Includes are NOT merged (already processed)
 
 	return result
 }
 
-func mergePackages(base, override []model.PackageState) []model.PackageState {
+// mergeProvenance keeps the source of the declaration that wins the merge of
+// each entity and records the redeclarations. Packages are a union, so the
+// first declaration stays; users and user packages are merged into.
+func mergeProvenance(base, override *model.Provenance) *model.Provenance {
+	if base == nil && override == nil {
+		return nil
+	}
+	result := &model.Provenance{Sources: make(map[string]model.Source)}
+	if base != nil {
+		for key, source := range base.Sources {
+			result.Sources[key] = source
+		}
+		result.Overrides = append(result.Overrides, base.Overrides...)
+	}
+	if override == nil {
+		return result
+	}
+	result.Overrides = append(result.Overrides, override.Overrides...)
+
+	keys := make([]string, 0, len(override.Sources))
+	for key := range override.Sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		source := override.Sources[key]
+		previous, exists := result.Sources[key]
+		if exists && strings.HasPrefix(key, "package ") {
+			continue
+		}
+		if exists {
+			merged := strings.HasPrefix(key, "user ") || strings.HasPrefix(key, "user-packages ") || strings.HasPrefix(key, "service-options ") || strings.HasPrefix(key, "setting hooks")
+			result.Overrides = append(result.Overrides, model.Override{Key: key, Source: source, Previous: previous, Merged: merged})
+		}
+		result.Sources[key] = source
+	}
+	return result
+}
+
+// mergePackages returns the union of both package lists, along with the names
+// that override redeclared from base.
+func mergePackages(base, override []model.PackageState) ([]model.PackageState, []string) {
 	seen := make(map[string]bool)
 	result := []model.PackageState{}
+	var duplicates []string
 
 	for _, pkg := range base {
 		result = append(result, pkg)
@@ -166,10 +635,21 @@ func mergePackages(base, override []model.PackageState) []model.PackageState {
 		if !seen[pkg.Name] {
 			result = append(result, pkg)
 			seen[pkg.Name] = true
+		} else if containsPackage(base, pkg.Name) {
+			duplicates = append(duplicates, pkg.Name)
 		}
 	}
 
-	return result
+	return result, duplicates
+}
+
+func containsPackage(pkgs []model.PackageState, name string) bool {
+	for _, pkg := range pkgs {
+		if pkg.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func mergeServices(base, override []model.ServiceState, logger log.Logger) []model.ServiceState {
@@ -241,6 +721,20 @@ func mergeUsers(base, override []model.UserState, logger log.Logger) []model.Use
 			// Intentionally modify user.Groups before storing in the map
 			// This merges the groups from both base and override configs
 			user.Groups = mergedGroups
+			user.Crontab = mergeCronJobs(existing.Crontab, user.Crontab)
+			user.AuthorizedKeys = mergeAuthorizedKeys(existing.AuthorizedKeys, user.AuthorizedKeys)
+			if user.UID == 0 {
+				user.UID = existing.UID
+			}
+			if user.Shell == "" {
+				user.Shell = existing.Shell
+			}
+			if user.Home == "" {
+				user.Home = existing.Home
+			}
+			if user.Gecos == "" {
+				user.Gecos = existing.Gecos
+			}
 
 			logger.Warn("User groups merged", "user", user.Name)
 		}
@@ -260,6 +754,66 @@ func mergeUsers(base, override []model.UserState, logger log.Logger) []model.Use
 	return result
 }
 
+// mergeRoles returns the roles of both sides, in order, dropping a role file
+// pulled in more than once.
+func mergeRoles(base, override []model.RoleState) []model.RoleState {
+	var result []model.RoleState
+	seen := make(map[string]bool)
+	for _, r := range append(append([]model.RoleState{}, base...), override...) {
+		if !seen[r.File] {
+			seen[r.File] = true
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// mergeAuthorizedKeys keeps the base keys in order followed by the override
+// keys the base does not have. Keys are compared by type and blob.
+func mergeAuthorizedKeys(base, override []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, key := range append(append([]string{}, base...), override...) {
+		id, ok := model.AuthorizedKeyID(key)
+		if !ok {
+			id = key
+		}
+		if !seen[id] {
+			seen[id] = true
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// mergeCronJobs keeps the base jobs in order, replacing the ones the override
+// redeclares. Unnamed jobs are identified by their crontab line.
+func mergeCronJobs(base, override []model.CronJobState) []model.CronJobState {
+	key := func(job model.CronJobState) string {
+		if job.Name != "" {
+			return job.Name
+		}
+		return job.Line()
+	}
+	index := make(map[string]int)
+	result := append([]model.CronJobState{}, base...)
+	for i, job := range result {
+		index[key(job)] = i
+	}
+	for _, job := range override {
+		if i, exists := index[key(job)]; exists {
+			result[i] = job
+			continue
+		}
+		index[key(job)] = len(result)
+		result = append(result, job)
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
 func mergeSystemConfigs(base, override []model.SystemConfigState, logger log.Logger) []model.SystemConfigState {
 	configMap := make(map[string]model.SystemConfigState)
 
@@ -287,6 +841,173 @@ func mergeSystemConfigs(base, override []model.SystemConfigState, logger log.Log
 	return result
 }
 
+func mergeUserConfigs(base, override []model.UserConfigState, logger log.Logger) []model.UserConfigState {
+	configMap := make(map[string]model.UserConfigState)
+
+	for _, cfg := range base {
+		configMap[cfg.Key()] = cfg
+	}
+
+	for _, cfg := range override {
+		if _, exists := configMap[cfg.Key()]; exists {
+			logger.Warn("User config overridden", "user", cfg.User, "path", cfg.Path)
+		}
+		configMap[cfg.Key()] = cfg
+	}
+
+	var result []model.UserConfigState
+	for _, cfg := range configMap {
+		result = append(result, cfg)
+	}
+
+	// Sort by user and path for deterministic ordering
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].User != result[j].User {
+			return result[i].User < result[j].User
+		}
+		return result[i].Path < result[j].Path
+	})
+
+	return result
+}
+
+// mergeManagedBlocks replaces base blocks redeclared by override, keeping the
+// order in which blocks were first declared.
+func mergeManagedBlocks(base, override []model.ManagedBlockState) []model.ManagedBlockState {
+	result := append([]model.ManagedBlockState{}, base...)
+	for _, b := range override {
+		replaced := false
+		for i := range result {
+			if result[i].Path == b.Path && result[i].Name == b.Name {
+				result[i] = b
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// mergeVirtualPackages replaces the virtual packages of base that override
+// redeclares, keeping their position, and appends the new ones.
+func mergeVirtualPackages(base, override []model.VirtualPackageState) []model.VirtualPackageState {
+	result := append([]model.VirtualPackageState{}, base...)
+	for _, v := range override {
+		replaced := false
+		for i := range result {
+			if result[i].Name == v.Name {
+				result[i] = v
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// mergeHooks concatenates the hooks of each stage, base first, so roles can
+// add their own hooks to those of the files including them.
+func mergeHooks(base, override *model.HooksState) *model.HooksState {
+	if base == nil {
+		return override
+	}
+	if override == nil {
+		return base
+	}
+	return &model.HooksState{
+		PreApply:  slices.Concat(base.PreApply, override.PreApply),
+		PostApply: slices.Concat(base.PostApply, override.PostApply),
+		OnFailure: slices.Concat(base.OnFailure, override.OnFailure),
+	}
+}
+
+// mergeCommands replaces the commands of base that override redeclares by
+// name, keeping the order in which commands were first declared since they
+// run in that order.
+func mergeCommands(base, override []model.CommandState) []model.CommandState {
+	result := append([]model.CommandState{}, base...)
+	for _, c := range override {
+		replaced := false
+		for i := range result {
+			if result[i].Name == c.Name {
+				result[i] = c
+				replaced = true
+			}
+		}
+		if !replaced {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// mergeServiceOptions merges the options of services declared on both sides,
+// with override values winning, keeping the order in which services were
+// first declared.
+func mergeServiceOptions(base, override []model.ServiceOptionsState) []model.ServiceOptionsState {
+	result := append([]model.ServiceOptionsState{}, base...)
+	for _, o := range override {
+		merged := false
+		for i := range result {
+			if result[i].Service != o.Service {
+				continue
+			}
+			options := make(map[string]string, len(result[i].Options)+len(o.Options))
+			for key, value := range result[i].Options {
+				options[key] = value
+			}
+			for key, value := range o.Options {
+				options[key] = value
+			}
+			result[i].Options = options
+			result[i].Sensitive = result[i].Sensitive || o.Sensitive
+			if o.Team != "" {
+				result[i].Team = o.Team
+			}
+			merged = true
+		}
+		if !merged {
+			result = append(result, o)
+		}
+	}
+	return result
+}
+
+// mergeVars returns the keys of both maps, with override values winning.
+func mergeVars(base, override map[string]any) map[string]any {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	result := make(map[string]any, len(base)+len(override))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range override {
+		result[k] = v
+	}
+	return result
+}
+
+func setSysctl(sysctl map[string]string, key, value string) map[string]string {
+	if sysctl == nil {
+		sysctl = make(map[string]string)
+	}
+	sysctl[key] = value
+	return sysctl
+}
+
+func setHostVars(hostVars map[string]map[string]any, host string, vars map[string]any) map[string]map[string]any {
+	if hostVars == nil {
+		hostVars = make(map[string]map[string]any)
+	}
+	hostVars[host] = vars
+	return hostVars
+}
+
 func mergeUserPackages(base, override []model.UserPackageState, logger log.Logger) []model.UserPackageState {
 	userPkgMap := make(map[string]model.UserPackageState)
 
@@ -296,27 +1017,17 @@ func mergeUserPackages(base, override []model.UserPackageState, logger log.Logge
 
 	for _, up := range override {
 		if existing, exists := userPkgMap[up.User]; exists {
-			// Union pipx packages
-			pipxSet := make(map[string]bool)
-			for _, p := range existing.Pipx {
-				pipxSet[p] = true
+			// Union the packages of each manager
+			for _, manager := range model.UserPackageManagers {
+				set := make(map[string]bool)
+				for _, p := range *existing.ManagerPackages(manager) {
+					set[p] = true
+				}
+				for _, p := range *up.ManagerPackages(manager) {
+					set[p] = true
+				}
+				*up.ManagerPackages(manager) = mapKeysToSlice(set)
 			}
-			for _, p := range up.Pipx {
-				pipxSet[p] = true
-			}
-
-			// Union npm packages
-			npmSet := make(map[string]bool)
-			for _, p := range existing.Npm {
-				npmSet[p] = true
-			}
-			for _, p := range up.Npm {
-				npmSet[p] = true
-			}
-
-			// Convert back to slices
-			up.Pipx = mapKeysToSlice(pipxSet)
-			up.Npm = mapKeysToSlice(npmSet)
 
 			logger.Warn("User packages merged", "user", up.User)
 		}
@@ -357,6 +1068,60 @@ func mergeIgnoredConfigs(base, override []string) []string {
 	return result
 }
 
+func mergeGroups(base, override []model.GroupState, logger log.Logger) []model.GroupState {
+	groupMap := make(map[string]model.GroupState)
+
+	for _, g := range base {
+		groupMap[g.Name] = g
+	}
+
+	for _, g := range override {
+		if _, exists := groupMap[g.Name]; exists {
+			logger.Warn("Group overridden", "group", g.Name)
+		}
+		groupMap[g.Name] = g
+	}
+
+	var result []model.GroupState
+	for _, g := range groupMap {
+		result = append(result, g)
+	}
+
+	// Sort by name for deterministic ordering
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+func mergePlugins(base, override []model.PluginState, logger log.Logger) []model.PluginState {
+	pluginMap := make(map[string]model.PluginState)
+
+	for _, p := range base {
+		pluginMap[p.Name] = p
+	}
+
+	for _, p := range override {
+		if _, exists := pluginMap[p.Name]; exists {
+			logger.Warn("Plugin overridden", "plugin", p.Name)
+		}
+		pluginMap[p.Name] = p
+	}
+
+	result := []model.PluginState{}
+	for _, p := range pluginMap {
+		result = append(result, p)
+	}
+
+	// Sort by name for deterministic ordering
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
 func mapKeysToSlice(m map[string]bool) []string {
 	result := []string{}
 	for k := range m {
@@ -375,3 +1140,51 @@ func validateIncludes(includes []string) model.ValidationErrors {
 	}
 	return errs
 }
+
+// FindRoles returns the role headers declared in the YAML files under the
+// directory of configFile, sorted by name. Hidden directories are skipped.
+func FindRoles(configFile string) ([]model.RoleState, error) {
+	dir := filepath.Dir(configFile)
+	var roles []model.RoleState
+	err := afero.Walk(system.AppFs, dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ext := filepath.Ext(path); ext != ".yaml" && ext != ".yml" {
+			return nil
+		}
+		content, err := afero.ReadFile(system.AppFs, path)
+		if err != nil {
+			return err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			var header struct {
+				Role *model.RoleState `yaml:"role"`
+			}
+			if err := decoder.Decode(&header); err == io.EOF {
+				break
+			} else if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if header.Role != nil {
+				header.Role.File = path
+				roles = append(roles, *header.Role)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(roles, func(i, j int) bool {
+		return roles[i].Name < roles[j].Name
+	})
+	return roles, nil
+}
@@ -36,6 +36,44 @@ user-packages: []
 				assert.Empty(t, cfg.UserPackages)
 			},
 		},
+		{
+			name: "plugins as names and mappings",
+			configYAML: `plugins:
+  - summit-plugin-zfs
+  - name: /usr/local/bin/summit-plugin-wg
+    config:
+      interfaces: [wg0]
+`,
+			validate: func(t *testing.T, cfg *model.SystemState) {
+				require.Len(t, cfg.Plugins, 2)
+				assert.Equal(t, "/usr/local/bin/summit-plugin-wg", cfg.Plugins[0].Name)
+				assert.Equal(t, []any{"wg0"}, cfg.Plugins[0].Config["interfaces"])
+				assert.Equal(t, "summit-plugin-zfs", cfg.Plugins[1].Name)
+				assert.Nil(t, cfg.Plugins[1].Config)
+			},
+		},
+		{
+			name: "modified-files as policy name",
+			configYAML: `modified-files: warn
+`,
+			validate: func(t *testing.T, cfg *model.SystemState) {
+				assert.Equal(t, model.ModifiedWarn, cfg.ModifiedFiles.Default)
+				assert.Empty(t, cfg.ModifiedFiles.Paths)
+			},
+		},
+		{
+			name: "modified-files with path rules",
+			configYAML: `modified-files:
+  default: ignore
+  paths:
+    - path: /etc/nginx/**
+      policy: revert
+`,
+			validate: func(t *testing.T, cfg *model.SystemState) {
+				assert.Equal(t, model.ModifiedIgnore, cfg.ModifiedFiles.Default)
+				assert.Equal(t, []model.ModifiedFileRule{{Path: "/etc/nginx/**", Policy: model.ModifiedRevert}}, cfg.ModifiedFiles.Paths)
+			},
+		},
 		{
 			name: "minimal valid config",
 			configYAML: `packages:
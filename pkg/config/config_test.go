@@ -4,6 +4,7 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"summit/pkg/facts"
 	"summit/pkg/model"
 	"summit/pkg/test"
 	"testing"
@@ -41,6 +42,11 @@ users:
 			Users: []model.UserState{
 				{Name: "testuser", Groups: []string{"wheel"}},
 			},
+			Provenance: &model.Provenance{Sources: map[string]model.Source{
+				"package git":   {File: configPath, Line: 3},
+				"package htop":  {File: configPath, Line: 4},
+				"user testuser": {File: configPath, Line: 6},
+			}},
 		}
 
 		// Sort slices for consistent comparison
@@ -134,6 +140,115 @@ users:
 		// Check warnings
 		assert.True(t, logger.HasMessage("Service overridden"))
 		assert.True(t, logger.HasMessage("User groups merged"))
+		assert.Empty(t, cfg.Warnings())
+	})
+
+	t.Run("warns about packages declared in several files", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "base.yaml"), []byte("packages:\n  - name: htop\n"), 0644))
+		hostPath := filepath.Join(tmpDir, "host.yaml")
+		require.NoError(t, os.WriteFile(hostPath, []byte("includes:\n  - base.yaml\npackages:\n  - name: htop\n"), 0644))
+
+		cfg, err := LoadConfig(hostPath, logger)
+		require.NoError(t, err)
+
+		warnings := cfg.Warnings()
+		require.Len(t, warnings, 1)
+		assert.Contains(t, warnings[0].Message, "package 'htop' is declared in more than one included file")
+	})
+
+	t.Run("merges vars and host-vars by key", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		baseContent := "vars:\n  port: 80\n  domain: example.com\nhost-vars:\n  web1:\n    port: 8080\n    weight: 1\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "base.yaml"), []byte(baseContent), 0644))
+		hostPath := filepath.Join(tmpDir, "host.yaml")
+		hostContent := "includes:\n  - base.yaml\nvars:\n  domain: example.org\nhost-vars:\n  web1:\n    weight: 5\n"
+		require.NoError(t, os.WriteFile(hostPath, []byte(hostContent), 0644))
+
+		cfg, err := LoadConfig(hostPath, logger)
+		require.NoError(t, err)
+
+		assert.Equal(t, map[string]any{"port": 80, "domain": "example.org"}, cfg.Vars)
+		assert.Equal(t, map[string]map[string]any{"web1": {"port": 8080, "weight": 5}}, cfg.HostVars)
+	})
+
+	t.Run("merges user crontab jobs by name", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		baseContent := "users:\n  - name: alice\n    groups: []\n    crontab:\n      - name: backup\n        schedule: \"0 3 * * *\"\n        command: backup.sh\n      - name: report\n        schedule: \"@weekly\"\n        command: report.sh\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "base.yaml"), []byte(baseContent), 0644))
+		hostPath := filepath.Join(tmpDir, "host.yaml")
+		hostContent := "includes:\n  - base.yaml\nusers:\n  - name: alice\n    groups: []\n    crontab:\n      - name: backup\n        schedule: \"0 4 * * *\"\n        command: backup.sh\n"
+		require.NoError(t, os.WriteFile(hostPath, []byte(hostContent), 0644))
+
+		cfg, err := LoadConfig(hostPath, logger)
+		require.NoError(t, err)
+
+		require.Len(t, cfg.Users, 1)
+		assert.Equal(t, []model.CronJobState{
+			{Name: "backup", Schedule: "0 4 * * *", Command: "backup.sh"},
+			{Name: "report", Schedule: "@weekly", Command: "report.sh"},
+		}, cfg.Users[0].Crontab)
+	})
+
+	t.Run("merges service options by key", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		baseContent := "service-options:\n  - service: sshd\n    options:\n      command_args: \"-4\"\n      rc_need: net\n"
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "base.yaml"), []byte(baseContent), 0644))
+		hostPath := filepath.Join(tmpDir, "host.yaml")
+		hostContent := "includes:\n  - base.yaml\nservice-options:\n  - service: sshd\n    options:\n      command_args: \"-6\"\n"
+		require.NoError(t, os.WriteFile(hostPath, []byte(hostContent), 0644))
+
+		cfg, err := LoadConfig(hostPath, logger)
+		require.NoError(t, err)
+
+		assert.Equal(t, []model.ServiceOptionsState{
+			{Service: "sshd", Options: map[string]string{"command_args": "-6", "rc_need": "net"}},
+		}, cfg.ServiceOptions)
+	})
+
+	t.Run("merges multiple documents in order", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "base.yaml"), []byte("packages:\n  - name: curl\n"), 0644))
+		content := `team: web
+packages:
+  - name: nginx
+configs:
+  - path: /etc/motd
+    content: first
+---
+includes:
+  - base.yaml
+team: db
+packages:
+  - name: postgresql
+configs:
+  - path: /etc/motd
+    content: second
+`
+		path := filepath.Join(tmpDir, "system.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+		cfg, err := LoadConfig(path, logger)
+		require.NoError(t, err)
+
+		assert.Equal(t, []model.PackageState{{Name: "curl"}, {Name: "nginx", Team: "web"}, {Name: "postgresql", Team: "db"}}, cfg.Packages)
+		require.Len(t, cfg.Configs, 1)
+		assert.Equal(t, "second", cfg.Configs[0].Content)
+		assert.Equal(t, "db", cfg.Configs[0].Team)
+	})
+
+	t.Run("reports the document of a decoding error", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "system.yaml")
+		require.NoError(t, os.WriteFile(path, []byte("packages: []\n---\npackages: {\n"), 0644))
+
+		_, err := LoadConfig(path, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "document 2")
 	})
 
 	t.Run("handles nested includes", func(t *testing.T) {
@@ -385,4 +500,257 @@ packages:
 		// or isn't a valid YAML config file
 		assert.Error(t, err)
 	})
+
+	t.Run("merges hooks and commands", func(t *testing.T) {
+		tmpDir := t.TempDir()
+
+		roleContent := `
+hooks:
+  pre-apply: [rc-service app stop]
+  post-apply: [rc-service app start]
+commands:
+  - name: migrate
+    command: app migrate
+  - name: seed
+    command: app seed
+`
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "role.yaml"), []byte(roleContent), 0644))
+		hostContent := `
+includes:
+  - role.yaml
+hooks:
+  pre-apply: [monitoring-silence 30m]
+commands:
+  - name: migrate
+    command: app migrate --all
+`
+		hostPath := filepath.Join(tmpDir, "host.yaml")
+		require.NoError(t, os.WriteFile(hostPath, []byte(hostContent), 0644))
+
+		cfg, err := LoadConfig(hostPath, logger)
+		require.NoError(t, err)
+
+		assert.Equal(t, &model.HooksState{
+			PreApply:  []string{"rc-service app stop", "monitoring-silence 30m"},
+			PostApply: []string{"rc-service app start"},
+		}, cfg.Hooks)
+		assert.Equal(t, []model.CommandState{
+			{Name: "migrate", Command: "app migrate --all"},
+			{Name: "seed", Command: "app seed"},
+		}, cfg.Commands)
+	})
+}
+
+func TestLoadConfig_Provenance(t *testing.T) {
+	logger := test.NewMockLogger(slog.LevelInfo)
+	tmpDir := t.TempDir()
+	base := filepath.Join(tmpDir, "base.yaml")
+	main := filepath.Join(tmpDir, "system.yaml")
+	require.NoError(t, os.WriteFile(base, []byte(`packages:
+  - name: htop
+users:
+  - name: alice
+    groups: [wheel]
+configs:
+  - path: /etc/motd
+    content: base
+`), 0644))
+	require.NoError(t, os.WriteFile(main, []byte(`includes:
+  - base.yaml
+packages:
+  - name: htop
+users:
+  - name: alice
+    groups: [audio]
+configs:
+  - path: /etc/motd
+    content: host
+`), 0644))
+
+	cfg, err := LoadConfig(main, logger)
+	require.NoError(t, err)
+
+	p := cfg.Provenance
+	require.NotNil(t, p)
+	assert.Equal(t, model.Source{File: base, Line: 2}, p.Sources["package htop"], "the first declaration of a package stays")
+	assert.Equal(t, model.Source{File: main, Line: 9}, p.Sources["config /etc/motd"])
+	assert.Equal(t, []model.Override{
+		{Key: "config /etc/motd", Source: model.Source{File: main, Line: 9}, Previous: model.Source{File: base, Line: 7}},
+		{Key: "user alice", Source: model.Source{File: main, Line: 6}, Previous: model.Source{File: base, Line: 4}, Merged: true},
+	}, p.Overrides)
+
+	t.Run("validation errors name the file and line", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(base, []byte("configs:\n  - path: /etc/issue\n    mode: \"999\"\n"), 0644))
+		_, err := LoadConfig(main, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "configs[0].mode ("+base+":2): mode must be a valid octal value")
+	})
+
+	t.Run("settings, sysctl keys and apply windows are located too", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(base, []byte("timezone: Europe/Rome\n"), 0644))
+		require.NoError(t, os.WriteFile(main, []byte(`includes:
+  - base.yaml
+timezone: "Not A Zone"
+sysctl:
+  vm.swappiness: "10"
+  net.ipv4.ip_forward: ""
+apply-windows:
+  - "* 3-5 * * *"
+  - "every night"
+`), 0644))
+		_, err := LoadConfig(main, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "timezone ("+main+":3): invalid timezone")
+		assert.Contains(t, err.Error(), "sysctl.net.ipv4.ip_forward ("+main+":6): value cannot be empty")
+		assert.Contains(t, err.Error(), "apply-windows[1] ("+main+":9):")
+	})
+}
+
+func TestLoadConfig_Source(t *testing.T) {
+	logger := test.NewMockLogger(slog.LevelInfo)
+	tmpDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "roles", "files"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "roles", "files", "sshd_config"), []byte("PermitRootLogin no\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "roles", "ssh.yaml"), []byte(`configs:
+  - path: /etc/ssh/sshd_config
+    source: files/sshd_config
+`), 0644))
+	main := filepath.Join(tmpDir, "system.yaml")
+	require.NoError(t, os.WriteFile(main, []byte("includes:\n  - roles/ssh.yaml\n"), 0644))
+
+	cfg, err := LoadConfig(main, logger)
+	require.NoError(t, err)
+	require.Len(t, cfg.Configs, 1)
+	assert.Equal(t, "PermitRootLogin no\n", cfg.Configs[0].Content, "the source is relative to the file declaring it")
+
+	t.Run("a missing source names the file and line", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(main, []byte("configs:\n  - path: /etc/motd\n    source: files/motd\n"), 0644))
+		_, err := LoadConfig(main, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "configs[0].source ("+main+":2):")
+		assert.Contains(t, err.Error(), filepath.Join(tmpDir, "files", "motd"))
+	})
+
+	t.Run("source and content are exclusive", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(main, []byte("configs:\n  - path: /etc/motd\n    source: roles/files/sshd_config\n    content: hello\n"), 0644))
+		_, err := LoadConfig(main, logger)
+		assert.ErrorContains(t, err, "source and content cannot both be set")
+	})
+}
+
+func TestLoadConfig_Profiles(t *testing.T) {
+	logger := test.NewMockLogger(slog.LevelInfo)
+	t.Cleanup(func() { Profiles = nil })
+	tmpDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "gui.yaml"), []byte("packages:\n  - name: firefox\n"), 0644))
+	main := filepath.Join(tmpDir, "system.yaml")
+	require.NoError(t, os.WriteFile(main, []byte(`packages:
+  - name: htop
+configs:
+  - path: /etc/motd
+    content: base
+profiles:
+  laptop:
+    team: desktop
+    includes:
+      - gui.yaml
+    packages:
+      - name: tlp
+  server:
+    configs:
+      - path: /etc/motd
+        content: server
+        mode: "644"
+        owner: nobody!
+`), 0644))
+
+	cfg, err := LoadConfig(main, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "htop"}}, cfg.Packages, "profiles apply only when selected")
+	assert.Nil(t, cfg.Profiles)
+
+	Profiles = []string{"laptop"}
+	cfg, err = LoadConfig(main, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "firefox"}, {Name: "htop"}, {Name: "tlp", Team: "desktop"}}, cfg.Packages)
+	assert.Equal(t, "base", cfg.Configs[0].Content)
+
+	t.Run("profile errors name the line in the profile", func(t *testing.T) {
+		Profiles = []string{"laptop", "server"}
+		_, err := LoadConfig(main, logger)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "configs[0].owner ("+main+":15):")
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		Profiles = []string{"desktop"}
+		_, err := LoadConfig(main, logger)
+		assert.EqualError(t, err, "unknown profile 'desktop' (declared: laptop, server)")
+	})
+
+	t.Run("profiles in includes are refused", func(t *testing.T) {
+		Profiles = nil
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "gui.yaml"), []byte("profiles:\n  kiosk: {}\n"), 0644))
+		require.NoError(t, os.WriteFile(main, []byte("includes:\n  - gui.yaml\n"), 0644))
+		_, err := LoadConfig(main, logger)
+		assert.EqualError(t, err, "failed to load include 'gui.yaml': profiles can only be declared in the top-level config")
+	})
+}
+
+func TestLoadConfig_HostOverlay(t *testing.T) {
+	logger := test.NewMockLogger(slog.LevelInfo)
+	Hostname = func() string { return "web1" }
+	t.Cleanup(func() { Hostname = facts.Hostname })
+	tmpDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "hosts"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "nginx.yaml"), []byte("packages:\n  - name: nginx\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "hosts", "web1.yaml"), []byte(`includes:
+  - ../nginx.yaml
+configs:
+  - path: /etc/motd
+    content: web1
+`), 0644))
+	main := filepath.Join(tmpDir, "system.yaml")
+	require.NoError(t, os.WriteFile(main, []byte("packages:\n  - name: htop\nconfigs:\n  - path: /etc/motd\n    content: fleet\n"), 0644))
+
+	cfg, err := LoadConfig(main, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "htop"}, {Name: "nginx"}}, cfg.Packages)
+	require.Len(t, cfg.Configs, 1)
+	assert.Equal(t, "web1", cfg.Configs[0].Content, "the overlay takes priority")
+
+	t.Run("other hosts get the base config", func(t *testing.T) {
+		Hostname = func() string { return "db1" }
+		t.Cleanup(func() { Hostname = func() string { return "web1" } })
+		cfg, err := LoadConfig(main, logger)
+		require.NoError(t, err)
+		assert.Equal(t, "fleet", cfg.Configs[0].Content)
+	})
+
+	t.Run("host-overlays sets the directory or disables overlays", func(t *testing.T) {
+		require.NoError(t, os.Rename(filepath.Join(tmpDir, "hosts"), filepath.Join(tmpDir, "machines")))
+		require.NoError(t, os.WriteFile(main, []byte("host-overlays: machines\nconfigs:\n  - path: /etc/motd\n    content: fleet\n"), 0644))
+		cfg, err := LoadConfig(main, logger)
+		require.NoError(t, err)
+		assert.Equal(t, "web1", cfg.Configs[0].Content)
+
+		require.NoError(t, os.WriteFile(main, []byte("host-overlays: none\nconfigs:\n  - path: /etc/motd\n    content: fleet\n"), 0644))
+		cfg, err = LoadConfig(main, logger)
+		require.NoError(t, err)
+		assert.Equal(t, "fleet", cfg.Configs[0].Content)
+	})
+
+	t.Run("host-overlays is read after includes and documents are merged", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(tmpDir, "fleet.yaml"), []byte("host-overlays: machines\n"), 0644))
+		require.NoError(t, os.WriteFile(main, []byte("includes:\n  - fleet.yaml\nconfigs:\n  - path: /etc/motd\n    content: fleet\n"), 0644))
+		cfg, err := LoadConfig(main, logger)
+		require.NoError(t, err)
+		assert.Equal(t, "web1", cfg.Configs[0].Content, "the setting of an include applies")
+
+		require.NoError(t, os.WriteFile(main, []byte("configs:\n  - path: /etc/motd\n    content: fleet\n---\nhost-overlays: none\n"), 0644))
+		require.NoError(t, os.Rename(filepath.Join(tmpDir, "machines"), filepath.Join(tmpDir, "hosts")))
+		cfg, err = LoadConfig(main, logger)
+		require.NoError(t, err)
+		assert.Equal(t, "fleet", cfg.Configs[0].Content, "the setting of a later document applies")
+	})
 }
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultAgeIdentity is the age identity file age-encrypted config files are
+// decrypted with when the policy does not name one.
+const DefaultAgeIdentity = "/etc/summit/age.key"
+
+// Headers of the binary and armored age formats.
+var ageHeaders = [][]byte{[]byte("age-encryption.org/v1\n"), []byte("-----BEGIN AGE ENCRYPTED FILE-----")}
+
+// DecryptionPolicy decrypts config files encrypted with sops or age, so
+// secret-bearing configs such as wireguard keys can live in the same repo.
+// Files are decrypted by the sops and age tools in memory and never written
+// to disk in clear.
+type DecryptionPolicy struct {
+	// AgeIdentity is the age identity file used for age-encrypted files and,
+	// when set, for sops files encrypted to age recipients. Other sops key
+	// sources (PGP, cloud KMS) use sops' own environment.
+	AgeIdentity string
+	Runner      system.CommandRunner
+}
+
+// Decryption is the policy LoadConfig decrypts encrypted files with. Nil
+// refuses encrypted files.
+var Decryption *DecryptionPolicy
+
+// decrypt returns the clear content of filename when content is encrypted
+// with sops or age, and content itself otherwise, along with whether it was
+// encrypted. The content read, the bytes the manifest vouches for, is what
+// sops or age decrypt, on their stdin. sopsType is the format sops reads and
+// writes the file in; empty infers it from the extension, binary for unknown
+// ones.
+func decrypt(filename string, content []byte, sopsType string) ([]byte, bool, error) {
+	var command string
+	switch {
+	case isAgeEncrypted(content):
+		if Decryption == nil {
+			return nil, false, fmt.Errorf("%s is age-encrypted but decryption is not enabled", filename)
+		}
+		identity := Decryption.AgeIdentity
+		if identity == "" {
+			identity = DefaultAgeIdentity
+		}
+		command = fmt.Sprintf("age --decrypt -i %s -", system.ShellQuote(identity))
+	case isSopsEncrypted(content):
+		if Decryption == nil {
+			return nil, false, fmt.Errorf("%s is sops-encrypted but decryption is not enabled", filename)
+		}
+		if sopsType == "" {
+			sopsType = sopsFileType(filename)
+		}
+		command = fmt.Sprintf("sops --decrypt --input-type %s --output-type %s /dev/stdin", sopsType, sopsType)
+		if Decryption.AgeIdentity != "" {
+			command = "SOPS_AGE_KEY_FILE=" + system.ShellQuote(Decryption.AgeIdentity) + " " + command
+		}
+	default:
+		return content, false, nil
+	}
+	out, err := system.RunInput(Decryption.Runner, "", command, content)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decrypt %s: %w", filename, err)
+	}
+	return out, true, nil
+}
+
+// sopsFileType returns the format sops would infer from the extension of
+// filename, which it cannot see when reading stdin.
+func sopsFileType(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".json":
+		return "json"
+	case ".env":
+		return "dotenv"
+	case ".ini":
+		return "ini"
+	}
+	return "binary"
+}
+
+// markSensitive marks the files and options of cfg and of its profiles
+// sensitive, as their content was decrypted.
+func markSensitive(cfg *model.SystemState) {
+	for i := range cfg.Configs {
+		cfg.Configs[i].Sensitive = true
+	}
+	for i := range cfg.UserConfigs {
+		cfg.UserConfigs[i].Sensitive = true
+	}
+	for i := range cfg.ManagedBlocks {
+		cfg.ManagedBlocks[i].Sensitive = true
+	}
+	for i := range cfg.ServiceOptions {
+		cfg.ServiceOptions[i].Sensitive = true
+	}
+	for _, profile := range cfg.Profiles {
+		if profile != nil {
+			markSensitive(profile)
+		}
+	}
+}
+
+func isAgeEncrypted(content []byte) bool {
+	for _, header := range ageHeaders {
+		if bytes.HasPrefix(content, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSopsEncrypted reports whether content is a YAML or JSON file encrypted
+// by sops, which records its metadata, MAC included, under a top-level sops
+// key. Binary files are encrypted into JSON.
+func isSopsEncrypted(content []byte) bool {
+	if !bytes.Contains(content, []byte("sops")) {
+		return false
+	}
+	var doc struct {
+		Sops map[string]any `yaml:"sops"`
+	}
+	return yaml.Unmarshal(content, &doc) == nil && doc.Sops["mac"] != nil
+}
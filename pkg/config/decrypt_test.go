@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+	"summit/pkg/test"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sopsEncrypted = `configs:
+    - path: ENC[AES256_GCM,data:Zm9v,iv:YQ==,tag:Yg==,type:str]
+sops:
+    age:
+        - recipient: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+    mac: ENC[AES256_GCM,data:bWFj,iv:YQ==,tag:Yg==,type:str]
+    version: 3.9.0
+`
+
+func TestLoadConfig_Encrypted(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs; Decryption = nil })
+	system.AppFs = afero.NewMemMapFs()
+	logger := test.NewMockLogger(slog.LevelInfo)
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/system.yaml", []byte("includes:\n  - secrets.yaml\n  - wireguard.yaml.age\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/secrets.yaml", []byte(sopsEncrypted), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/wireguard.yaml.age", []byte("age-encryption.org/v1\n-> X25519 abc\n"), 0644))
+
+	_, err := LoadConfig("/cfg/system.yaml", logger)
+	assert.EqualError(t, err, "failed to load include 'secrets.yaml': /cfg/secrets.yaml is sops-encrypted but decryption is not enabled")
+
+	runner := test.NewMockCommandRunner()
+	Decryption = &DecryptionPolicy{AgeIdentity: "/root/age.key", Runner: runner}
+	runner.SetResponse("", "SOPS_AGE_KEY_FILE='/root/age.key' sops --decrypt --input-type yaml --output-type yaml /dev/stdin",
+		[]byte("configs:\n  - path: /etc/app/token\n    mode: \"0600\"\n    content: s3cret\n"))
+	runner.SetResponse("", "age --decrypt -i '/root/age.key' -",
+		[]byte("configs:\n  - path: /etc/wireguard/wg0.conf\n    content: \"[Interface]\\n\"\n"))
+
+	cfg, err := LoadConfig("/cfg/system.yaml", logger)
+	require.NoError(t, err)
+	require.Len(t, cfg.Configs, 2)
+	assert.Equal(t, "s3cret", cfg.Configs[0].Content)
+	assert.Equal(t, model.OriginManaged, cfg.Configs[1].Origin)
+	assert.Equal(t, "[Interface]\n", cfg.Configs[1].Content)
+	assert.True(t, cfg.Configs[0].Sensitive && cfg.Configs[1].Sensitive, "decrypted content is sensitive")
+	// The tools decrypt the bytes that were read, not the file again
+	assert.Equal(t, sopsEncrypted, string(runner.Inputs[":SOPS_AGE_KEY_FILE='/root/age.key' sops --decrypt --input-type yaml --output-type yaml /dev/stdin"]))
+	assert.Equal(t, "age-encryption.org/v1\n-> X25519 abc\n", string(runner.Inputs[":age --decrypt -i '/root/age.key' -"]))
+
+	runner.SetError("", "age --decrypt -i '/root/age.key' -", errors.New("exit status 1: no identity matched any of the recipients"))
+	_, err = LoadConfig("/cfg/system.yaml", logger)
+	assert.ErrorContains(t, err, "failed to decrypt /cfg/wireguard.yaml.age: exit status 1: no identity matched any of the recipients")
+}
+
+func TestDecrypt_PlainFiles(t *testing.T) {
+	t.Cleanup(func() { Decryption = nil })
+	Decryption = &DecryptionPolicy{Runner: test.NewMockCommandRunner()}
+
+	// A sops key that is not sops metadata leaves the file alone
+	content := []byte("vars:\n  sops: enabled\npackages: []\n")
+	out, encrypted, err := decrypt("/cfg/system.yaml", content, "yaml")
+	require.NoError(t, err)
+	assert.False(t, encrypted)
+	assert.Equal(t, content, out)
+	assert.Empty(t, Decryption.Runner.(*test.MockCommandRunner).Commands)
+}
+
+func TestLoadConfig_EncryptedSources(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs; Decryption = nil })
+	system.AppFs = afero.NewMemMapFs()
+	logger := test.NewMockLogger(slog.LevelInfo)
+	runner := test.NewMockCommandRunner()
+	Decryption = &DecryptionPolicy{Runner: runner}
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/system.yaml", []byte("configs:\n  - path: /etc/wireguard/wg0.conf\n    source: files/wg0.json\n  - path: /etc/motd\n    source: files/motd\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/files/wg0.json", []byte(`{"data": "ENC[AES256_GCM,data:Zm9v,iv:YQ==,tag:Yg==,type:str]", "sops": {"mac": "ENC[AES256_GCM,data:bWFj]"}}`), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/files/motd", []byte("Welcome\n"), 0644))
+	// The type of a source follows its extension, as sops would infer it
+	runner.SetResponse("", "sops --decrypt --input-type json --output-type json /dev/stdin", []byte("[Interface]\nPrivateKey = k\n"))
+
+	cfg, err := LoadConfig("/cfg/system.yaml", logger)
+	require.NoError(t, err)
+	require.Len(t, cfg.Configs, 2)
+	assert.Equal(t, "Welcome\n", cfg.Configs[0].Content)
+	assert.False(t, cfg.Configs[0].Sensitive)
+	assert.Equal(t, "[Interface]\nPrivateKey = k\n", cfg.Configs[1].Content)
+	assert.True(t, cfg.Configs[1].Sensitive)
+}
+
+func TestSopsFileType(t *testing.T) {
+	for filename, want := range map[string]string{"secrets.yaml": "yaml", "a.YML": "yaml", "wg0.json": "json", "app.env": "dotenv", "php.ini": "ini", "wg0.conf": "binary"} {
+		assert.Equal(t, want, sopsFileType(filename), filename)
+	}
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// AppendIgnoredConfigs adds patterns to the ignored-configs section of a config
+// file, creating the section if needed. Comments elsewhere in the file are kept.
+func AppendIgnoredConfigs(filename string, patterns []string) error {
+	if len(patterns) == 0 {
+		return nil
+	}
+	return editConfigFile(filename, func(root *yaml.Node) error {
+		seq := sequenceFor(root, "ignored-configs")
+		for _, p := range patterns {
+			seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: p})
+		}
+		return nil
+	})
+}
+
+// AppendConfigs adds entries to the configs section of a config file, creating
+// the section if needed. Comments elsewhere in the file are kept.
+func AppendConfigs(filename string, configs []model.SystemConfigState) error {
+	if len(configs) == 0 {
+		return nil
+	}
+	return editConfigFile(filename, func(root *yaml.Node) error {
+		seq := sequenceFor(root, "configs")
+		for _, c := range configs {
+			var node yaml.Node
+			if err := node.Encode(c); err != nil {
+				return fmt.Errorf("failed to encode config %s: %w", c.Path, err)
+			}
+			seq.Content = append(seq.Content, &node)
+		}
+		return nil
+	})
+}
+
+// editConfigFile parses a config file into a node tree, applies edit to its
+// top-level mapping and writes the result back. In a multi-document file the
+// last document, which takes priority when merging, is edited.
+func editConfigFile(filename string, edit func(root *yaml.Node) error) error {
+	data, err := afero.ReadFile(system.AppFs, filename)
+	if err != nil {
+		return err
+	}
+
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		docs = append(docs, &doc)
+	}
+	if len(docs) == 0 {
+		docs = append(docs, &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}})
+	}
+	root := docs[len(docs)-1].Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: top level must be a mapping", filename)
+	}
+
+	if err := edit(root); err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return err
+	}
+	info, err := system.AppFs.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return afero.WriteFile(system.AppFs, filename, out.Bytes(), info.Mode())
+}
+
+// sequenceFor returns the sequence node stored under key in a mapping,
+// creating it (or replacing an explicit null) if necessary.
+func sequenceFor(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			value := mapping.Content[i+1]
+			if value.Kind != yaml.SequenceNode {
+				*value = yaml.Node{Kind: yaml.SequenceNode}
+			}
+			value.Style = 0
+			return value
+		}
+	}
+	seq := &yaml.Node{Kind: yaml.SequenceNode}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, seq)
+	return seq
+}
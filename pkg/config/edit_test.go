@@ -0,0 +1,55 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"summit/pkg/model"
+	"summit/pkg/test"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendToConfigFile(t *testing.T) {
+	logger := test.NewMockLogger(slog.LevelInfo)
+	configPath := filepath.Join(t.TempDir(), "system.yaml")
+	content := `# base host
+packages:
+  - name: htop # monitoring
+ignored-configs: []
+`
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	require.NoError(t, AppendIgnoredConfigs(configPath, []string{"/etc/ssh/ssh_host_*"}))
+	require.NoError(t, AppendConfigs(configPath, []model.SystemConfigState{
+		{Path: "/etc/motd", Content: "line one\nline two\n", Mode: "0644"},
+	}))
+
+	data, err := os.ReadFile(configPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "# base host")
+	assert.Contains(t, string(data), "# monitoring")
+
+	cfg, err := LoadConfig(configPath, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/etc/ssh/ssh_host_*"}, cfg.IgnoredConfigs)
+	require.Len(t, cfg.Configs, 1)
+	assert.Equal(t, "line one\nline two\n", cfg.Configs[0].Content)
+	assert.Equal(t, "0644", cfg.Configs[0].Mode)
+}
+
+func TestAppendToMultiDocumentConfigFile(t *testing.T) {
+	logger := test.NewMockLogger(slog.LevelInfo)
+	configPath := filepath.Join(t.TempDir(), "system.yaml")
+	content := "packages:\n  - name: htop\n---\npackages:\n  - name: vim\n"
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+	require.NoError(t, AppendIgnoredConfigs(configPath, []string{"/etc/hostname"}))
+
+	cfg, err := LoadConfig(configPath, logger)
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "htop"}, {Name: "vim"}}, cfg.Packages)
+	assert.Equal(t, []string{"/etc/hostname"}, cfg.IgnoredConfigs)
+}
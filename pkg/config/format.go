@@ -0,0 +1,213 @@
+package config
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"gopkg.in/yaml.v3"
+)
+
+// sortedSections are the sections Format sorts, with the keys of their items
+// they are sorted by, matching SystemState.Sort.
+var sortedSections = map[string][]string{
+	"packages":      {"name"},
+	"services":      {"name"},
+	"users":         {"name"},
+	"groups":        {"name"},
+	"configs":       {"path"},
+	"user-configs":  {"user", "path"},
+	"user-packages": {"user"},
+	"plugins":       {"name"},
+}
+
+// Format rewrites a config file in canonical form: top-level keys and the
+// keys of each item in the order of the model, the sections SystemState.Sort
+// orders sorted the same way, two-space indentation, and strings quoted only
+// when they would otherwise read as another type. Comments are kept with the
+// keys and items they belong to. Documents using anchors are not reordered,
+// since an alias cannot come before its anchor.
+func Format(data []byte) ([]byte, error) {
+	var docs []*yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		docs = append(docs, &doc)
+	}
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		reorder := !hasAliases(doc)
+		for _, root := range doc.Content {
+			// A comment at the top of the file stays there when its first
+			// key moves
+			if root.Kind == yaml.MappingNode && len(root.Content) > 0 && doc.HeadComment == "" {
+				doc.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+			}
+			formatNode(root, reflect.TypeOf(model.SystemState{}), reorder)
+		}
+		if err := encoder.Encode(doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// FormatFile formats a config file in place and reports whether it changed.
+// With check, the file is left alone. So are files encrypted with sops or
+// age: reformatting would break the MAC sops keeps of the file, and age
+// files are not YAML.
+func FormatFile(filename string, check bool) (bool, error) {
+	data, err := afero.ReadFile(system.AppFs, filename)
+	if err != nil {
+		return false, err
+	}
+	if isAgeEncrypted(data) || isSopsEncrypted(data) {
+		return false, nil
+	}
+	formatted, err := Format(data)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, formatted) || check {
+		return !bytes.Equal(data, formatted), nil
+	}
+	info, err := system.AppFs.Stat(filename)
+	if err != nil {
+		return false, err
+	}
+	return true, afero.WriteFile(system.AppFs, filename, formatted, info.Mode())
+}
+
+// formatNode normalizes node, which holds a value of type t, and the nodes
+// below it.
+func formatNode(node *yaml.Node, t reflect.Type, reorder bool) {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		node.Style &^= yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle
+	case yaml.SequenceNode:
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		flow := true
+		for _, item := range node.Content {
+			formatNode(item, elem, reorder)
+			flow = flow && item.Kind == yaml.ScalarNode && !strings.Contains(item.Value, "\n")
+		}
+		// Short lists such as groups: [wheel] may stay on one line
+		if !flow {
+			node.Style &^= yaml.FlowStyle
+		}
+	case yaml.MappingNode:
+		node.Style &^= yaml.FlowStyle
+		fields := map[string]reflect.StructField{}
+		var order []string
+		if t != nil && t.Kind() == reflect.Struct {
+			for i := 0; i < t.NumField(); i++ {
+				name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+				if name != "" && name != "-" {
+					fields[name] = t.Field(i)
+					order = append(order, name)
+				}
+			}
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			key.Style &^= yaml.SingleQuotedStyle | yaml.DoubleQuotedStyle
+			var valueType reflect.Type
+			switch {
+			case t != nil && t.Kind() == reflect.Map:
+				valueType = t.Elem()
+			case fields[key.Value].Type != nil:
+				valueType = fields[key.Value].Type
+			}
+			formatNode(value, valueType, reorder)
+			if sortKeys, ok := sortedSections[key.Value]; ok && reorder && t == reflect.TypeOf(model.SystemState{}) && value.Kind == yaml.SequenceNode {
+				sortItems(value, sortKeys)
+			}
+		}
+		if reorder && len(order) > 0 {
+			sortKeys(node, order)
+		}
+	}
+}
+
+// sortKeys orders the keys of a mapping as listed in order; unknown keys keep
+// their relative order after the known ones.
+func sortKeys(mapping *yaml.Node, order []string) {
+	rank := make(map[string]int, len(order))
+	for i, name := range order {
+		rank[name] = i
+	}
+	type pair struct{ key, value *yaml.Node }
+	pairs := make([]pair, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		pairs = append(pairs, pair{mapping.Content[i], mapping.Content[i+1]})
+	}
+	position := func(p pair) int {
+		if r, ok := rank[p.key.Value]; ok {
+			return r
+		}
+		return len(order)
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return position(pairs[i]) < position(pairs[j]) })
+	mapping.Content = mapping.Content[:0]
+	for _, p := range pairs {
+		mapping.Content = append(mapping.Content, p.key, p.value)
+	}
+}
+
+// sortItems sorts the items of a section by the values of keys. Items
+// written as a bare scalar, such as plugins, sort by that scalar.
+func sortItems(seq *yaml.Node, keys []string) {
+	value := func(item *yaml.Node, key string) string {
+		if item.Kind == yaml.ScalarNode {
+			return item.Value
+		}
+		if _, v := lookupKey(item, key); v != nil {
+			return v.Value
+		}
+		return ""
+	}
+	sort.SliceStable(seq.Content, func(i, j int) bool {
+		for _, key := range keys {
+			a, b := value(seq.Content[i], key), value(seq.Content[j], key)
+			if a != b {
+				return a < b
+			}
+		}
+		return false
+	})
+}
+
+func hasAliases(node *yaml.Node) bool {
+	if node.Kind == yaml.AliasNode || node.Anchor != "" {
+		return true
+	}
+	for _, child := range node.Content {
+		if hasAliases(child) {
+			return true
+		}
+	}
+	return false
+}
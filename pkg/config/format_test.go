@@ -0,0 +1,83 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	input := `# web host
+configs:
+    - content: "hello\nworld\n"
+      path: '/etc/motd'
+      mode: "0644"
+    - path: /etc/issue
+      content: 'Welcome'
+packages:
+    - name: vim
+    # monitoring
+    - name: htop
+users: [{name: bob, groups: [wheel]}, {groups: [audio], name: alice}]
+includes: [base.yaml]
+plugins:
+  - zfs
+  - name: docker
+profiles:
+  laptop:
+    packages: [{name: tlp}, {name: acpid}]
+    team: desktop
+vars:
+  zeta: "1"
+  alpha: 2
+`
+	want := `# web host
+
+includes: [base.yaml]
+packages:
+  # monitoring
+  - name: htop
+  - name: vim
+users:
+  - name: alice
+    groups: [audio]
+  - name: bob
+    groups: [wheel]
+configs:
+  - path: /etc/issue
+    content: Welcome
+  - path: /etc/motd
+    content: |
+      hello
+      world
+    mode: "0644"
+plugins:
+  - name: docker
+  - zfs
+vars:
+  zeta: "1"
+  alpha: 2
+profiles:
+  laptop:
+    team: desktop
+    packages:
+      - name: acpid
+      - name: tlp
+`
+	out, err := Format([]byte(input))
+	require.NoError(t, err)
+	assert.Equal(t, want, string(out))
+
+	again, err := Format(out)
+	require.NoError(t, err)
+	assert.Equal(t, want, string(again), "formatting is idempotent")
+}
+
+func TestFormat_Anchors(t *testing.T) {
+	// Reordering could move an alias before its anchor
+	input := "configs:\n  - path: /etc/motd\n    content: 'hi'\npackages:\n  - &vim\n    name: vim\n"
+	out, err := Format([]byte(input))
+	require.NoError(t, err)
+	assert.Equal(t, "configs:\n  - path: /etc/motd\n    content: hi\npackages:\n  - &vim\n    name: vim\n", string(out))
+}
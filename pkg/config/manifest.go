@@ -0,0 +1,190 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// ManifestFile is the name of the checksum manifest, next to the top-level
+// config file. It lists "<sha256>  <path>" lines (the sha256sum format) with
+// paths relative to its directory.
+const ManifestFile = "summit.manifest"
+
+// Signature files verified against the manifest, one per supported tool.
+const (
+	SSHSignatureFile      = ManifestFile + ".sig"     // ssh-keygen -Y sign -n summit
+	MinisignSignatureFile = ManifestFile + ".minisig" // minisign -Sm
+)
+
+// sshSignatureNamespace is the ssh-keygen -n namespace manifests are signed in.
+const sshSignatureNamespace = "summit"
+
+// IntegrityPolicy makes LoadConfig refuse configuration that is not covered by
+// a manifest signed by an authorized maintainer.
+type IntegrityPolicy struct {
+	AllowedSigners string // ssh allowed_signers file trusted for SSHSignatureFile
+	MinisignKey    string // minisign public key file trusted for MinisignSignatureFile
+	Runner         system.CommandRunner
+}
+
+// Integrity is the policy LoadConfig enforces. Nil disables verification.
+var Integrity *IntegrityPolicy
+
+// manifest holds the verified checksums of a config tree.
+type manifest struct {
+	dir  string
+	sums map[string]string
+}
+
+// loadManifest reads the manifest next to configFile and checks its signature,
+// over the bytes read, before trusting any checksum in it.
+func loadManifest(configFile string, policy *IntegrityPolicy) (*manifest, error) {
+	dir, err := filepath.Abs(filepath.Dir(configFile))
+	if err != nil {
+		return nil, err
+	}
+	manifestPath := filepath.Join(dir, ManifestFile)
+	content, err := afero.ReadFile(system.AppFs, manifestPath)
+	if err != nil {
+		return nil, fmt.Errorf("integrity verification is enabled but the manifest could not be read: %w", err)
+	}
+	if err := policy.verifySignature(manifestPath, content, filepath.Join(dir, SSHSignatureFile), filepath.Join(dir, MinisignSignatureFile)); err != nil {
+		return nil, err
+	}
+
+	m := &manifest{dir: dir, sums: make(map[string]string)}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		sum, path, ok := strings.Cut(text, "  ")
+		if !ok || len(sum) != sha256.Size*2 {
+			return nil, fmt.Errorf("%s:%d: expected '<sha256>  <path>'", manifestPath, line)
+		}
+		m.sums[filepath.Clean(path)] = sum
+	}
+	return m, nil
+}
+
+// VerifyFile checks content, the bytes of a file fetched outside a signed
+// config tree such as a config from instance metadata, against the detached
+// signature next to the file: path.sig (ssh-keygen -Y sign -n summit) or
+// path.minisig (minisign -Sm).
+func (p *IntegrityPolicy) VerifyFile(path string, content []byte) error {
+	return p.verifySignature(path, content, path+".sig", path+".minisig")
+}
+
+// verifySignature checks content, read from path, with whichever tools the
+// policy trusts. At least one signature must be present and valid. The tools
+// read content on stdin rather than path, which could have changed since.
+func (p *IntegrityPolicy) verifySignature(path string, content []byte, sshSigPath, minisigPath string) error {
+	if p.AllowedSigners != "" {
+		if exists, _ := afero.Exists(system.AppFs, sshSigPath); exists {
+			return p.verifySSH(path, content, sshSigPath)
+		}
+	}
+	if p.MinisignKey != "" {
+		if exists, _ := afero.Exists(system.AppFs, minisigPath); exists {
+			cmd := fmt.Sprintf("minisign -V -p %s -m - -x %s", system.ShellQuote(p.MinisignKey), system.ShellQuote(minisigPath))
+			if _, err := system.RunInput(p.Runner, "", cmd, content); err != nil {
+				return fmt.Errorf("%s signature verification failed: %w", path, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("no trusted signature found for %s (expected %s or %s)", path, filepath.Base(sshSigPath), filepath.Base(minisigPath))
+}
+
+// verifySSH finds which allowed signer made the signature and verifies it.
+func (p *IntegrityPolicy) verifySSH(path string, content []byte, sigPath string) error {
+	find := fmt.Sprintf("ssh-keygen -Y find-principals -f %s -s %s", system.ShellQuote(p.AllowedSigners), system.ShellQuote(sigPath))
+	out, err := p.Runner.Run("", find)
+	if err != nil {
+		return fmt.Errorf("%s is not signed by an allowed signer: %s", path, strings.TrimSpace(string(out)))
+	}
+	for _, principal := range strings.Fields(string(out)) {
+		verify := fmt.Sprintf("ssh-keygen -Y verify -f %s -I %s -n %s -s %s",
+			system.ShellQuote(p.AllowedSigners), system.ShellQuote(principal), sshSignatureNamespace, system.ShellQuote(sigPath))
+		if _, err := system.RunInput(p.Runner, "", verify, content); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("signature verification failed for %s", sigPath)
+}
+
+// verify checks that a file read while loading the config is listed in the
+// manifest with a matching checksum.
+func (m *manifest) verify(filename string, content []byte) error {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(m.dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("%s is outside the signed config tree %s", filename, m.dir)
+	}
+	want, ok := m.sums[rel]
+	if !ok {
+		return fmt.Errorf("%s is not listed in %s", filename, ManifestFile)
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != want {
+		return fmt.Errorf("%s does not match its checksum in %s", filename, ManifestFile)
+	}
+	return nil
+}
+
+// WriteManifest hashes every file under the directory of configFile, except
+// the manifest, its signatures and hidden directories, and writes the manifest
+// for maintainers to sign. Files are listed in lexical order.
+func WriteManifest(configFile string) (string, error) {
+	dir := filepath.Dir(configFile)
+	var lines []string
+	err := afero.Walk(system.AppFs, dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir // e.g. .git
+			}
+			return nil
+		}
+		switch info.Name() {
+		case ManifestFile, SSHSignatureFile, MinisignSignatureFile:
+			return nil
+		}
+		content, err := afero.ReadFile(system.AppFs, path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		lines = append(lines, fmt.Sprintf("%s  %s", hex.EncodeToString(sum[:]), rel))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	manifestPath := filepath.Join(dir, ManifestFile)
+	content := strings.Join(lines, "\n") + "\n"
+	if err := afero.WriteFile(system.AppFs, manifestPath, []byte(content), 0644); err != nil {
+		return "", err
+	}
+	return manifestPath, nil
+}
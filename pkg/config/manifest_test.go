@@ -0,0 +1,78 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"testing"
+
+	"summit/pkg/system"
+	"summit/pkg/test"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadConfig_Integrity(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs; Integrity = nil })
+	system.AppFs = afero.NewMemMapFs()
+	logger := test.NewMockLogger(slog.LevelInfo)
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/system.yaml", []byte("includes:\n  - roles/web.yaml\npackages:\n  - name: htop\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/roles/web.yaml", []byte("packages:\n  - name: nginx\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/.git/HEAD", []byte("ref: refs/heads/main\n"), 0644))
+
+	path, err := WriteManifest("/cfg/system.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "/cfg/summit.manifest", path)
+	content, err := afero.ReadFile(system.AppFs, path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "  roles/web.yaml\n")
+	assert.NotContains(t, string(content), ".git")
+
+	runner := test.NewMockCommandRunner()
+	Integrity = &IntegrityPolicy{AllowedSigners: "/etc/summit/allowed_signers", Runner: runner}
+
+	_, err = LoadConfig("/cfg/system.yaml", logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no trusted signature found")
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/summit.manifest.sig", []byte("sig"), 0644))
+	runner.SetResponse("", "ssh-keygen -Y find-principals -f '/etc/summit/allowed_signers' -s '/cfg/summit.manifest.sig'", []byte("alice@example.com\n"))
+
+	cfg, err := LoadConfig("/cfg/system.yaml", logger)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Packages, 2)
+	verify := ":ssh-keygen -Y verify -f '/etc/summit/allowed_signers' -I 'alice@example.com' -n summit -s '/cfg/summit.manifest.sig'"
+	assert.Equal(t, string(content), string(runner.Inputs[verify]), "the signature is checked over the manifest read")
+
+	// A change after signing is rejected
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/roles/web.yaml", []byte("packages:\n  - name: backdoor\n"), 0644))
+	_, err = LoadConfig("/cfg/system.yaml", logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "/cfg/roles/web.yaml does not match its checksum in summit.manifest")
+
+	// A signature from an unknown key is rejected
+	runner.SetError("", "ssh-keygen -Y find-principals -f '/etc/summit/allowed_signers' -s '/cfg/summit.manifest.sig'", errors.New("exit status 255"))
+	_, err = LoadConfig("/cfg/system.yaml", logger)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "manifest is not signed by an allowed signer")
+}
+
+func TestVerifyFile_Minisign(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(system.AppFs, "/run/system.yaml.minisig", []byte("sig"), 0600))
+	runner := test.NewMockCommandRunner()
+	policy := &IntegrityPolicy{MinisignKey: "/etc/summit/minisign.pub", Runner: runner}
+
+	// The bytes passed in are verified, whatever the file holds by now
+	require.NoError(t, policy.VerifyFile("/run/system.yaml", []byte("packages: []\n")))
+	verify := ":minisign -V -p '/etc/summit/minisign.pub' -m - -x '/run/system.yaml.minisig'"
+	assert.Equal(t, "packages: []\n", string(runner.Inputs[verify]))
+
+	runner.SetError("", verify[1:], errors.New("exit status 1: Signature verification failed"))
+	assert.EqualError(t, policy.VerifyFile("/run/system.yaml", []byte("packages: []\n")), "/run/system.yaml signature verification failed: exit status 1: Signature verification failed")
+}
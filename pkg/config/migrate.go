@@ -0,0 +1,99 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+
+	"summit/pkg/model"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A config file declares the format it is written in with version. Older
+// files are upgraded in memory when loaded, one version at a time, and every
+// change is reported as a warning so the file can be updated at leisure. A
+// file without a version predates versioning and is read as version 1.
+
+// migration upgrades a config file from the version before it to the next.
+type migration []change
+
+// change rewrites one key of a config file's top-level mapping, returning a
+// warning per rewrite.
+type change func(root *yaml.Node) model.ValidationErrors
+
+// migrations upgrade version n to n+1 at index n-1. Version 1 is the first
+// versioned format and still the current one: no key has been renamed or
+// moved since.
+var migrations []migration
+
+// CurrentVersion returns the config format this summit reads natively.
+func CurrentVersion() int {
+	return len(migrations) + 1
+}
+
+// migrate upgrades the document in node to the current version, along with
+// the profiles it declares, and returns the warnings describing what changed.
+func migrate(node *yaml.Node, filename string) (model.ValidationErrors, error) {
+	root := node
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		root = root.Content[0]
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	version, versionNode, err := fileVersion(root)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if version == CurrentVersion() {
+		return nil, nil
+	}
+
+	roots := []*yaml.Node{root}
+	if _, profiles := lookupKey(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 1; i < len(profiles.Content); i += 2 {
+			roots = append(roots, profiles.Content[i])
+		}
+	}
+	var warnings model.ValidationErrors
+	for v := version; v < CurrentVersion(); v++ {
+		for _, change := range migrations[v-1] {
+			for _, r := range roots {
+				for _, w := range change(r) {
+					w.File = filename
+					w.Message = fmt.Sprintf("version %d %s; update the file", v+1, w.Message)
+					warnings = append(warnings, w)
+				}
+			}
+		}
+	}
+	versionNode.Value = strconv.Itoa(CurrentVersion())
+	return warnings, nil
+}
+
+// fileVersion returns the version a config file declares, or 1 when it
+// declares none. The returned node is where the migrated version is written.
+func fileVersion(root *yaml.Node) (int, *yaml.Node, error) {
+	_, value := lookupKey(root, "version")
+	if value == nil {
+		return 1, &yaml.Node{}, nil
+	}
+	version, err := strconv.Atoi(value.Value)
+	switch {
+	case err != nil || version < 1:
+		return 0, nil, fmt.Errorf("line %d: invalid version '%s', must be a number from 1", value.Line, value.Value)
+	case version > CurrentVersion():
+		return 0, nil, fmt.Errorf("line %d: config version %d is newer than this summit supports (%d); upgrade summit", value.Line, version, CurrentVersion())
+	}
+	return version, value, nil
+}
+
+// lookupKey returns the key and value nodes of key in a mapping node.
+func lookupKey(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+	"summit/pkg/test"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestLoadConfig_Migrations(t *testing.T) {
+	origFs, origMigrations := system.AppFs, migrations
+	t.Cleanup(func() { system.AppFs, migrations = origFs, origMigrations })
+	system.AppFs = afero.NewMemMapFs()
+	logger := test.NewMockLogger(slog.LevelInfo)
+
+	// Version 2 renamed ignore to ignored-configs, version 3 tz to timezone
+	migrations = []migration{
+		{renameKey("ignore", "ignored-configs")},
+		{renameKey("tz", "timezone")},
+	}
+	require.Equal(t, 3, CurrentVersion())
+
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/system.yaml", []byte(`version: 1
+includes:
+  - current.yaml
+ignore:
+  - /etc/motd
+tz: Europe/Rome
+profiles:
+  laptop:
+    ignore: [/etc/issue]
+`), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/current.yaml", []byte("version: 3\nlocale: en_US.UTF-8\n"), 0644))
+
+	Profiles = []string{"laptop"}
+	t.Cleanup(func() { Profiles = nil })
+	cfg, err := LoadConfig("/cfg/system.yaml", logger)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/etc/motd", "/etc/issue"}, cfg.IgnoredConfigs)
+	assert.Equal(t, "Europe/Rome", cfg.Timezone)
+	assert.Equal(t, "en_US.UTF-8", cfg.Locale)
+	assert.Equal(t, model.ValidationErrors{
+		{Field: "ignore", File: "/cfg/system.yaml", Line: 4, Message: "version 2 renamed 'ignore' to 'ignored-configs'; update the file"},
+		{Field: "ignore", File: "/cfg/system.yaml", Line: 9, Message: "version 2 renamed 'ignore' to 'ignored-configs'; update the file"},
+		{Field: "tz", File: "/cfg/system.yaml", Line: 6, Message: "version 3 renamed 'tz' to 'timezone'; update the file"},
+	}, cfg.LoadWarnings)
+
+	t.Run("newer versions are refused", func(t *testing.T) {
+		require.NoError(t, afero.WriteFile(system.AppFs, "/cfg/current.yaml", []byte("version: 4\n"), 0644))
+		_, err := LoadConfig("/cfg/system.yaml", logger)
+		assert.EqualError(t, err, "failed to load include 'current.yaml': /cfg/current.yaml: line 1: config version 4 is newer than this summit supports (3); upgrade summit")
+	})
+}
+
+func TestMigrate_DestinationSet(t *testing.T) {
+	origMigrations := migrations
+	t.Cleanup(func() { migrations = origMigrations })
+	migrations = []migration{{renameKey("tz", "timezone")}}
+
+	var node yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("version: 1\ntz: UTC\ntimezone: Europe/Rome\n"), &node))
+	warnings, err := migrate(&node, "system.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, model.ValidationErrors{
+		{Field: "tz", File: "system.yaml", Line: 2, Message: "version 2 renamed 'tz' to 'timezone', which is already set, so the value was dropped; update the file"},
+	}, warnings)
+
+	var state model.SystemState
+	require.NoError(t, node.Decode(&state))
+	assert.Equal(t, "Europe/Rome", state.Timezone)
+	assert.Equal(t, 2, state.Version)
+}
+
+// renameKey renames a top-level key, as a migration of a later version would.
+// A value already set under the new name wins over the renamed one.
+func renameKey(from, to string) change {
+	return func(root *yaml.Node) model.ValidationErrors {
+		key, _ := lookupKey(root, from)
+		if key == nil {
+			return nil
+		}
+		warning := model.ValidationError{Field: from, Line: key.Line, Message: fmt.Sprintf("renamed '%s' to '%s'", from, to)}
+		if existing, _ := lookupKey(root, to); existing != nil {
+			for i := 0; i < len(root.Content); i += 2 {
+				if root.Content[i] == key {
+					root.Content = append(root.Content[:i], root.Content[i+2:]...)
+					break
+				}
+			}
+			warning.Message += ", which is already set, so the value was dropped"
+			return model.ValidationErrors{warning}
+		}
+		key.Value = to
+		return model.ValidationErrors{warning}
+	}
+}
+
+func TestMigrate_Unversioned(t *testing.T) {
+	origMigrations := migrations
+	t.Cleanup(func() { migrations = origMigrations })
+	migrations = []migration{{renameKey("ignore", "ignored-configs")}}
+
+	// A file without a version predates versioning, so it is migrated from 1
+	var node yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte("ignore:\n  - /etc/motd\n"), &node))
+	warnings, err := migrate(&node, "legacy.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, model.ValidationErrors{
+		{Field: "ignore", File: "legacy.yaml", Line: 1, Message: "version 2 renamed 'ignore' to 'ignored-configs'; update the file"},
+	}, warnings)
+
+	var state model.SystemState
+	require.NoError(t, node.Decode(&state))
+	assert.Equal(t, []string{"/etc/motd"}, state.IgnoredConfigs)
+}
@@ -0,0 +1,109 @@
+package diff
+
+import (
+	"sort"
+	"summit/pkg/model"
+)
+
+// CoverageEntry counts the resources of one kind found on the system by how
+// the config accounts for them.
+type CoverageEntry struct {
+	Kind      string   `json:"kind"`
+	Total     int      `json:"total"`
+	Managed   int      `json:"managed"`
+	Ignored   int      `json:"ignored"`
+	Unmanaged []string `json:"unmanaged"`
+}
+
+// Percent returns the share of the resources the config manages.
+func (e CoverageEntry) Percent() float64 {
+	if e.Total == 0 {
+		return 100
+	}
+	return float64(e.Managed) * 100 / float64(e.Total)
+}
+
+// Coverage reports how much of the current system the desired config
+// manages: installed packages, services enabled in a runlevel, users and the
+// /etc files apk audit reports as added or modified. A resource is managed
+// when the config declares it, ignored when it matches one of the config's
+// ignore lists, and unmanaged otherwise.
+func Coverage(desired, current *model.SystemState) []CoverageEntry {
+	desired = withGenerated(desired)
+	declared := make(map[string]bool)
+	for _, p := range desired.Packages {
+		declared["package "+p.Name] = true
+	}
+	for _, v := range desired.VirtualPackages {
+		declared["package "+v.Name] = true
+	}
+	for _, s := range desired.Services {
+		declared["service "+s.Name] = true
+	}
+	for _, u := range desired.Users {
+		declared["user "+u.Name] = true
+	}
+	for _, c := range desired.Configs {
+		declared["config "+c.Path] = true
+	}
+	for _, b := range desired.ManagedBlocks {
+		declared["config "+b.Path] = true
+	}
+	for _, o := range desired.ServiceOptions {
+		declared["config "+o.Path()] = true
+	}
+
+	var packages, services, users, configs []string
+	for _, p := range current.Packages {
+		packages = append(packages, p.Name)
+	}
+	for _, v := range current.VirtualPackages {
+		packages = append(packages, v.Name)
+	}
+	for _, s := range current.Services {
+		if s.Enabled || s.Runlevel != "" {
+			services = append(services, s.Name)
+		}
+	}
+	for _, u := range current.Users {
+		users = append(users, u.Name)
+	}
+	for _, c := range current.Configs {
+		if !c.Deleted {
+			configs = append(configs, c.Path)
+		}
+	}
+
+	count := func(kind, prefix string, names []string, ignored func(string) bool) CoverageEntry {
+		entry := CoverageEntry{Kind: kind, Unmanaged: []string{}}
+		seen := make(map[string]bool)
+		for _, name := range names {
+			// A service enabled in several runlevels counts once
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			entry.Total++
+			switch {
+			case declared[prefix+name]:
+				entry.Managed++
+			case ignored(name):
+				entry.Ignored++
+			default:
+				entry.Unmanaged = append(entry.Unmanaged, name)
+			}
+		}
+		sort.Strings(entry.Unmanaged)
+		return entry
+	}
+	ignoredBy := func(patterns []string) func(string) bool {
+		return func(name string) bool { return matchesAnyGlob(patterns, name) }
+	}
+
+	return []CoverageEntry{
+		count("packages", "package ", packages, ignoredBy(desired.IgnoredPackages)),
+		count("services", "service ", services, ignoredBy(desired.IgnoredServices)),
+		count("users", "user ", users, ignoredBy(desired.IgnoredUsers)),
+		count("configs", "config ", configs, ignoredBy(desired.IgnoredConfigs)),
+	}
+}
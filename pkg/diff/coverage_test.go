@@ -0,0 +1,50 @@
+package diff
+
+import (
+	"reflect"
+	"summit/pkg/model"
+	"testing"
+)
+
+func TestCoverage(t *testing.T) {
+	desired := &model.SystemState{
+		Packages:        []model.PackageState{{Name: "nginx"}},
+		IgnoredPackages: []string{"lib*"},
+		Services:        []model.ServiceState{{Name: "nginx", Enabled: true, Runlevel: "default"}},
+		Users:           []model.UserState{{Name: "deploy"}},
+		Configs:         []model.SystemConfigState{{Path: "/etc/motd"}},
+		Sysctl:          map[string]string{"vm.swappiness": "10"},
+	}
+	current := &model.SystemState{
+		Packages: []model.PackageState{{Name: "nginx"}, {Name: "libcurl"}, {Name: "htop"}, {Name: "curl"}},
+		Services: []model.ServiceState{
+			{Name: "nginx", Enabled: true, Runlevel: "default"},
+			{Name: "sshd", Enabled: true, Runlevel: "default"},
+			{Name: "sshd", Enabled: true, Runlevel: "boot"},
+			{Name: "crond"},
+		},
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/motd"},
+			{Path: model.SysctlFragmentPath},
+			{Path: "/etc/hosts"},
+			{Path: "/etc/issue", Deleted: true},
+		},
+	}
+
+	want := []CoverageEntry{
+		{Kind: "packages", Total: 4, Managed: 1, Ignored: 1, Unmanaged: []string{"curl", "htop"}},
+		{Kind: "services", Total: 2, Managed: 1, Unmanaged: []string{"sshd"}},
+		{Kind: "users", Unmanaged: []string{}},
+		{Kind: "configs", Total: 3, Managed: 2, Unmanaged: []string{"/etc/hosts"}},
+	}
+	got := Coverage(desired, current)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Coverage() = %+v, want %+v", got, want)
+	}
+	if p := got[0].Percent(); p != 25 {
+		t.Errorf("packages Percent() = %v, want 25", p)
+	}
+	if p := got[2].Percent(); p != 100 {
+		t.Errorf("users Percent() = %v, want 100", p)
+	}
+}
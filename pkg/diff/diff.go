@@ -2,19 +2,26 @@ package diff
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"summit/pkg/actions"
 	"summit/pkg/model"
+	"summit/pkg/plugin"
 	"summit/pkg/system"
+	"time"
+
+	"github.com/spf13/afero"
 )
 
 const groupFilePath = "/etc/group"
 
-const unmanagedFileWarning = "Warning: unmanaged file found %s (created outside package manager). Consider adding to ignored_configs or use --prune-unmanaged to delete.\n"
+const modifiedFileWarning = "package-modified file %s is not managed and will be left as is. Add it to configs (summit adopt --modified) or set modified-files to revert to restore the package default."
+
+const unmanagedFileWarning = "unmanaged file found %s (created outside package manager). Consider adding to ignored_configs or use --prune-unmanaged to delete."
 
 // MatchesGlob checks if path matches the glob pattern, with support for **
 // Supports recursive ** patterns like /etc/ssh/**/*.pub
@@ -45,99 +52,284 @@ func MatchesGlob(pattern, path string) bool {
 }
 
 // CalculatePlan generates a list of actions to transform the current state into the desired state.
+// Warnings found while planning, such as unmanaged files, are printed to stderr.
 func CalculatePlan(desired *model.SystemState, current *model.SystemState, runner system.CommandRunner, pruneUnmanaged bool) ([]actions.Action, error) {
-	if err := ValidateDependencies(desired, current); err != nil {
+	plan, warnings, err := CalculatePlanWithWarnings(desired, current, runner, pruneUnmanaged)
+	if err != nil {
 		return nil, err
 	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", w.Message)
+	}
+	return plan, nil
+}
+
+// CalculatePlanWithWarnings is like CalculatePlan but returns planning warnings
+// instead of printing them, so callers can render them in machine-readable output.
+func CalculatePlanWithWarnings(desired *model.SystemState, current *model.SystemState, runner system.CommandRunner, pruneUnmanaged bool) ([]actions.Action, model.ValidationErrors, error) {
+	if err := ValidateDependencies(desired, current); err != nil {
+		return nil, nil, err
+	}
 
 	var plan []actions.Action
-
-	plan = append(plan, calculatePackageActions(desired.Packages, current.Packages)...)
-	plan = append(plan, calculateServiceActions(desired.Services, current.Services)...)
-	userActions, err := calculateUserActions(desired.Users, current.Users, runner)
+	var warnings model.ValidationErrors
+	generated := withGenerated(desired)
+
+	packages := generated.Packages
+	if !system.Packages.Pins() {
+		packages = withoutVersions(packages, &warnings)
+	}
+	packageActions := calculatePackageActions(packages, current.Packages, desired.IgnoredPackages)
+	if system.Packages.Name() == "apk" {
+		packageActions = append(packageActions, calculateVirtualPackageActions(generated.VirtualPackages, current.VirtualPackages, desired.IgnoredPackages)...)
+	} else if len(generated.VirtualPackages) > 0 {
+		warnings = append(warnings, model.ValidationError{Field: "virtual-packages", Message: fmt.Sprintf("virtual packages need apk; %s cannot group packages, so virtual-packages is ignored", system.Packages.Name())})
+	}
+	if update := calculateIndexUpdate(desired.PackageIndexUpdate, packageActions); update != nil {
+		plan = append(plan, update)
+	}
+	plan = append(plan, packageActions...)
+	plan = append(plan, calculateServiceActions(desired.Services, current.Services, desired.IgnoredServices)...)
+	warnings = append(warnings, checkServiceNeeds(desired, current, runner)...)
+	groupCreates, groupRemoves, err := calculateGroupActions(desired, current, runner, &warnings)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	plan = append(plan, userActions...)
-	plan = append(plan, calculateConfigActions(desired, current, pruneUnmanaged)...)
-	plan = append(plan, calculateUserPackageActions(desired, current, runner)...)
+	plan = append(plan, groupCreates...)
+	userActions, err := calculateUserActions(desired.Users, current.Users, desired.IgnoredUsers, runner)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan = append(plan, withoutDeclaredGroups(userActions, desired.Groups)...)
+	plan = append(plan, groupRemoves...)
+	plan = append(plan, calculateCrontabActions(desired.Users, current.Users, desired.IgnoredUsers)...)
+	plan = append(plan, calculateAuthorizedKeyActions(desired.Users, current.Users, desired.IgnoredUsers)...)
+	plan = append(plan, calculateUserConfigActions(desired.UserConfigs, current.UserConfigs, desired.IgnoredUsers, &warnings)...)
+	plan = append(plan, calculateConfigActions(generated, current, pruneUnmanaged, &warnings)...)
+	plan = append(plan, calculateSysctlActions(desired.Sysctl, current.Sysctl)...)
+	if desired.Timezone != "" && desired.Timezone != current.Timezone {
+		plan = append(plan, &actions.TimezoneSetAction{Zone: desired.Timezone, Current: current.Timezone})
+	}
+	blockActions, err := calculateManagedBlockActions(desired.ManagedBlocks, &warnings)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan = append(plan, blockActions...)
+	optionActions, err := calculateServiceOptionsActions(desired.ServiceOptions, &warnings)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan = append(plan, optionActions...)
+	plan = append(plan, calculateUserPackageActions(desired, current, runner, &warnings)...)
+	pluginActions, err := calculatePluginActions(desired.Plugins, runner)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan = append(plan, pluginActions...)
+	plan = orderByWants(plan, desired)
+	commandActions, err := calculateCommandActions(desired.Commands, runner)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan = append(plan, commandActions...)
+	plan = append(plan, calculateRestartActions(desired, plan)...)
 
-	return plan, nil
+	if errs := checkRemovalLimits(desired.Limits, plan); len(errs) > 0 {
+		return nil, nil, errs
+	}
+
+	if len(desired.Configs) > 0 && desired.PackageOwnedConfigs != model.PackageOwnedAllow {
+		owners := PackageOwnedConfigs(desired, current, runner)
+		if desired.PackageOwnedConfigs == model.PackageOwnedError {
+			if conflicts := PackageOwnedConflicts(desired, owners); len(conflicts) > 0 {
+				return nil, nil, conflicts
+			}
+		}
+		recordOwnerPackages(plan, owners)
+	}
+
+	// Record what actions depending on live lookups will do so the plan shows
+	// it; those that cannot be resolved now are reported and retried on apply
+	for _, err := range actions.ResolvePlan(plan, runner) {
+		warnings = append(warnings, model.ValidationError{Field: "plan", Message: err.Error()})
+	}
+
+	return plan, warnings, nil
 }
 
-func calculateUserPackageActions(desired *model.SystemState, current *model.SystemState, runner system.CommandRunner) []actions.Action {
-	var a []actions.Action
+// recordOwnerPackages notes on file actions which package owns the file they
+// overwrite, so the plan shows that an upstream file is being overridden.
+func recordOwnerPackages(plan []actions.Action, owners map[string]string) {
+	for _, action := range plan {
+		switch a := action.(type) {
+		case *actions.FileCreateAction:
+			a.OwnerPackage = owners[a.Path]
+		case *actions.FileUpdateAction:
+			a.OwnerPackage = owners[a.Path]
+		}
+	}
+}
 
-	for _, userPackage := range desired.UserPackages {
-		if len(userPackage.Pipx) > 0 {
-			// Discover and compare pipx packages
-			a = append(a, compareUserPackages(userPackage.User, "pipx", userPackage.Pipx, runner)...)
+// checkRemovalLimits refuses plans removing more of a kind of resource than
+// the config's limits allow.
+func checkRemovalLimits(limits *model.LimitsState, plan []actions.Action) model.ValidationErrors {
+	counts := make(map[string]int)
+	for _, action := range plan {
+		if r, ok := action.(actions.Remover); ok {
+			key, n := r.Removals()
+			counts[key] += n
+		}
+	}
+	var errs model.ValidationErrors
+	for _, limit := range limits.RemovalLimits() {
+		if n := counts[limit.Key]; n > limit.Max {
+			errs = append(errs, model.ValidationError{Field: "limits." + limit.Key, Message: fmt.Sprintf("plan has %d %s, over the limit of %d; check the includes for a bad merge, or raise the limit if the removals are intended", n, limit.Noun, limit.Max)})
+		}
+	}
+	return errs
+}
+
+// calculateRestartActions returns one restart per service notified by a
+// config the plan changes, so a service whose vhost files all changed is
+// bounced once, after everything else, rather than once per file. The
+// service is only reloaded when every config notifying it asks for a reload.
+// A service whose options change is restarted too.
+func calculateRestartActions(desired *model.SystemState, plan []actions.Action) []actions.Action {
+	changed := make(map[string]bool)
+	for _, action := range plan {
+		switch action.(type) {
+		case *actions.FileCreateAction, *actions.FileUpdateAction, *actions.FileChmodAction, *actions.FileChownAction:
+			changed[ResourceName(action)] = true
 		}
+	}
 
-		if len(userPackage.Npm) > 0 {
-			// Discover and compare npm packages
-			a = append(a, compareUserPackages(userPackage.User, "npm", userPackage.Npm, runner)...)
+	restarts := make(map[string]*actions.ServiceRestartAction)
+	// The init script reads its conf.d file when the service starts, so
+	// changed options take a restart
+	for _, action := range plan {
+		if o, ok := action.(*actions.ServiceOptionsAction); ok {
+			restarts[o.Service] = &actions.ServiceRestartAction{ServiceName: o.Service, Files: []string{o.Path()}}
+		}
+	}
+	for _, cfg := range desired.Configs {
+		if !changed[cfg.Path] {
+			continue
+		}
+		for _, entry := range cfg.Notify {
+			how, svc, _ := model.ParseNotify(entry)
+			if how == "" {
+				how = model.NotifyRestart
+				for _, s := range desired.Services {
+					if s.Name == svc && s.ReloadPreferred {
+						how = model.NotifyReload
+					}
+				}
+			}
+			restart, ok := restarts[svc]
+			if !ok {
+				restart = &actions.ServiceRestartAction{ServiceName: svc, Reload: true}
+				restarts[svc] = restart
+			}
+			restart.Reload = restart.Reload && how == model.NotifyReload
+			if !containsString(restart.Files, cfg.Path) {
+				restart.Files = append(restart.Files, cfg.Path)
+			}
 		}
 	}
 
+	names := make([]string, 0, len(restarts))
+	for name := range restarts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var a []actions.Action
+	for _, name := range names {
+		a = append(a, restarts[name])
+	}
 	return a
 }
 
-type PipxPackageMetadata struct {
-	Package string `json:"package"`
+func matchesAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchesGlob(pattern, name) {
+			return true
+		}
+	}
+	return false
 }
 
-type PipxVenv struct {
-	Metadata PipxPackageMetadata `json:"metadata"`
+// calculateCommandActions returns an action for every command whose guards
+// do not hold, in declaration order, after every other change so commands
+// can rely on the packages, users and files the config declares.
+func calculateCommandActions(commands []model.CommandState, runner system.CommandRunner) ([]actions.Action, error) {
+	var a []actions.Action
+	for _, c := range commands {
+		action := &actions.CommandAction{
+			Name:    c.Name,
+			Command: c.Command,
+			User:    c.User,
+			Creates: c.Creates,
+			Unless:  c.Unless,
+			Timeout: c.TimeoutDuration(),
+		}
+		done, err := action.Check(runner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check the guards of command %s: %w", c.Name, err)
+		}
+		if !done {
+			a = append(a, action)
+		}
+	}
+	return a, nil
 }
 
-type PipxListOutput struct {
-	Venvs map[string]PipxVenv `json:"venvs"`
-}
+// calculatePluginActions asks each declared plugin to infer its current state
+// and diff it against the desired config.
+func calculatePluginActions(plugins []model.PluginState, runner system.CommandRunner) ([]actions.Action, error) {
+	var a []actions.Action
+
+	for _, p := range plugins {
+		current, err := plugin.Infer(runner, p.Name)
+		if err != nil {
+			return nil, err
+		}
+		changes, err := plugin.Diff(runner, p.Name, p.Config, current)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range changes {
+			a = append(a, &actions.PluginAction{Plugin: p.Name, Change: change})
+		}
+	}
 
-type NpmDependency struct {
-	Version string `json:"version"`
+	return a, nil
 }
 
-type NpmListOutput struct {
-	Dependencies map[string]NpmDependency `json:"dependencies"`
+func calculateUserPackageActions(desired *model.SystemState, current *model.SystemState, runner system.CommandRunner, warnings *model.ValidationErrors) []actions.Action {
+	var a []actions.Action
+
+	for _, userPackage := range desired.UserPackages {
+		for _, manager := range model.UserPackageManagers {
+			// Discover and compare the packages of each manager in use
+			if packages := *userPackage.ManagerPackages(manager); len(packages) > 0 {
+				a = append(a, compareUserPackages(userPackage.User, manager, packages, runner, warnings)...)
+			}
+		}
+	}
+
+	return a
 }
 
-func compareUserPackages(user, manager string, desiredPackages []string, runner system.CommandRunner) []actions.Action {
+func compareUserPackages(user, manager string, desiredPackages []string, runner system.CommandRunner, warnings *model.ValidationErrors) []actions.Action {
 	var a []actions.Action
 
 	// Discover current state
-	command := manager + " list --json"
-	out, err := runner.Run(user, command)
+	installedPackages, err := system.ListUserPackages(user, manager, runner)
 	if err != nil {
-		// Handle case where user or manager is not found, or command fails
-		fmt.Printf("Warning: could not list %s packages for user %s: %v\n", manager, user, err)
+		*warnings = append(*warnings, model.ValidationError{Field: "user-packages", Message: err.Error()})
 		return a
 	}
 
-	installedPackages := []string{}
-
-	switch manager {
-	case "pipx":
-		var pipxOutput PipxListOutput
-		if err := json.Unmarshal(out, &pipxOutput); err != nil {
-			fmt.Printf("Warning: could not parse pipx list output for user %s: %v\n", user, err)
-			return a
-		}
-		for _, venv := range pipxOutput.Venvs {
-			installedPackages = append(installedPackages, venv.Metadata.Package)
-		}
-	case "npm":
-		var npmOutput NpmListOutput
-		if err := json.Unmarshal(out, &npmOutput); err != nil {
-			fmt.Printf("Warning: could not parse npm list output for user %s: %v\n", user, err)
-			return a
-		}
-		for pkg := range npmOutput.Dependencies {
-			installedPackages = append(installedPackages, pkg)
-		}
-	}
-
 	currentMap := make(map[string]bool)
 	for _, p := range installedPackages {
 		currentMap[p] = true
@@ -148,8 +340,20 @@ func compareUserPackages(user, manager string, desiredPackages []string, runner
 		desiredMap[p] = true
 	}
 
+	var missing []string
 	for pkg := range desiredMap {
 		if !currentMap[pkg] {
+			missing = append(missing, pkg)
+		}
+	}
+	sort.Strings(missing)
+
+	// Managers installing several packages with one command get a single
+	// action, rather than one su session per package
+	if len(missing) > 1 && system.UserPackageBatchInstall(manager) {
+		a = append(a, &actions.UserPackageBatchInstallAction{User: user, Manager: manager, Packages: missing})
+	} else {
+		for _, pkg := range missing {
 			a = append(a, &actions.UserPackageAction{User: user, Manager: manager, Package: pkg, State: model.PackageStatePresent})
 		}
 	}
@@ -163,73 +367,81 @@ func compareUserPackages(user, manager string, desiredPackages []string, runner
 	return a
 }
 
-func calculatePackageActions(desired []model.PackageState, current []model.PackageState) []actions.Action {
-	var a []actions.Action
-
-	desiredMap := make(map[string]model.PackageState)
-	for _, p := range desired {
-		desiredMap[p.Name] = p
-	}
-
-	currentMap := make(map[string]model.PackageState)
-	for _, p := range current {
-		currentMap[p.Name] = p
-	}
-
-	for name := range desiredMap {
-		if _, ok := currentMap[name]; !ok {
-			a = append(a, &actions.PackageInstallAction{PackageName: name})
-		}
-	}
-
-	for name := range currentMap {
-		if _, ok := desiredMap[name]; !ok {
-			a = append(a, &actions.PackageRemoveAction{PackageName: name})
-		}
-	}
-
-	return a
+func calculatePackageActions(desired, current []model.PackageState, ignored []string) []actions.Action {
+	changes, deletions := reconcile(
+		resources(desired, func(p model.PackageState) packageResource { return packageResource(p) }),
+		resources(current, func(p model.PackageState) packageResource { return packageResource(p) }),
+		reconcileOptions{Ignored: ignored, Prune: true})
+	return append(changes, deletions...)
 }
 
-func calculateServiceActions(desired []model.ServiceState, current []model.ServiceState) []actions.Action {
-	var a []actions.Action
+// calculateVirtualPackageActions installs, updates and removes virtual
+// packages. Like packages, the virtual packages the config does not declare
+// are removed.
+func calculateVirtualPackageActions(desired, current []model.VirtualPackageState, ignored []string) []actions.Action {
+	changes, deletions := reconcile(
+		resources(desired, func(v model.VirtualPackageState) virtualPackageResource { return virtualPackageResource(v) }),
+		resources(current, func(v model.VirtualPackageState) virtualPackageResource { return virtualPackageResource(v) }),
+		reconcileOptions{Ignored: ignored, Prune: true})
+	return append(changes, deletions...)
+}
 
-	desiredMap := make(map[string]model.ServiceState)
-	for _, s := range desired {
-		desiredMap[s.Name] = s
+// IndexMaxAge is how old the package index may be before the if-stale policy
+// updates it.
+var IndexMaxAge = 24 * time.Hour
+
+// calculateIndexUpdate returns the action updating the package index ahead of
+// the package actions, when they install packages and the policy asks for it:
+// always, or if-stale when the index is older than IndexMaxAge or missing.
+func calculateIndexUpdate(policy string, packageActions []actions.Action) actions.Action {
+	installs := false
+	for _, action := range packageActions {
+		switch action.(type) {
+		case *actions.PackageInstallAction, *actions.VirtualPackageInstallAction:
+			installs = true
+		}
 	}
-
-	currentMap := make(map[string]model.ServiceState)
-	for _, s := range current {
-		currentMap[s.Name] = s
+	if !installs {
+		return nil
 	}
-
-	for name, desiredService := range desiredMap {
-		if currentService, ok := currentMap[name]; ok {
-			if desiredService.Enabled && !currentService.Enabled {
-				a = append(a, &actions.ServiceEnableAction{ServiceName: name, Runlevel: desiredService.Runlevel})
-			} else if !desiredService.Enabled && currentService.Enabled {
-				a = append(a, &actions.ServiceDisableAction{ServiceName: name, Runlevel: currentService.Runlevel})
-			}
-		} else {
-			if desiredService.Enabled {
-				a = append(a, &actions.ServiceEnableAction{ServiceName: name, Runlevel: desiredService.Runlevel})
-			}
+	switch policy {
+	case model.IndexUpdateAlways:
+		return &actions.PackageIndexUpdateAction{Reason: "package-index-update is always"}
+	case model.IndexUpdateIfStale:
+		updated := system.Packages.IndexUpdated()
+		if updated.IsZero() {
+			return &actions.PackageIndexUpdateAction{Reason: "no package index"}
+		}
+		if age := time.Since(updated); age > IndexMaxAge {
+			return &actions.PackageIndexUpdateAction{Reason: fmt.Sprintf("package index is %s old", age.Truncate(time.Minute))}
 		}
 	}
+	return nil
+}
 
-	for name, currentService := range currentMap {
-		if _, ok := desiredMap[name]; !ok {
-			if currentService.Enabled {
-				a = append(a, &actions.ServiceDisableAction{ServiceName: name, Runlevel: currentService.Runlevel})
-			}
+// withoutVersions returns packages without the versions they are pinned to,
+// warning about each, for package managers that cannot pin them.
+func withoutVersions(packages []model.PackageState, warnings *model.ValidationErrors) []model.PackageState {
+	result := make([]model.PackageState, 0, len(packages))
+	for _, p := range packages {
+		if p.Version != "" {
+			*warnings = append(*warnings, model.ValidationError{Field: "packages", Message: fmt.Sprintf("%s cannot pin package %s to version %s; the version is ignored", system.Packages.Name(), p.Name, p.Version)})
+			p.Version = ""
 		}
+		result = append(result, p)
 	}
+	return result
+}
 
-	return a
+func calculateServiceActions(desired, current []model.ServiceState, ignored []string) []actions.Action {
+	changes, deletions := reconcile(
+		resources(model.GroupRunlevels(desired), func(s model.ServiceState) serviceResource { return serviceResource(s) }),
+		resources(current, func(s model.ServiceState) serviceResource { return serviceResource(s) }),
+		reconcileOptions{Ignored: ignored, Prune: true})
+	return append(changes, deletions...)
 }
 
-func calculateUserActions(desired []model.UserState, current []model.UserState, runner system.CommandRunner) ([]actions.Action, error) {
+func calculateUserActions(desired, current []model.UserState, ignored []string, runner system.CommandRunner) ([]actions.Action, error) {
 	plan := []actions.Action{}
 
 	// Infer current system groups
@@ -241,6 +453,9 @@ func calculateUserActions(desired []model.UserState, current []model.UserState,
 	// Collect all required groups from desired users
 	requiredGroups := make(map[string]struct{})
 	for _, user := range desired {
+		if matchesAnyGlob(ignored, user.Name) {
+			continue
+		}
 		for _, groupName := range user.Groups {
 			requiredGroups[groupName] = struct{}{}
 		}
@@ -255,66 +470,150 @@ func calculateUserActions(desired []model.UserState, current []model.UserState,
 		}
 	}
 
-	// Create maps for users
-	currentUsersMap := make(map[string]model.UserState)
-	for _, u := range current {
-		currentUsersMap[u.Name] = u
-	}
-
-	for _, desiredUser := range desired {
-		currentUser, userExists := currentUsersMap[desiredUser.Name]
+	changes, deletions := reconcile(
+		resources(desired, func(u model.UserState) userResource { return userResource(u) }),
+		resources(current, func(u model.UserState) userResource { return userResource(u) }),
+		reconcileOptions{Ignored: ignored, Prune: true})
+	plan = append(plan, changes...)
+	return append(plan, deletions...), nil
+}
 
-		if !userExists {
-			// Create new user and add to groups
-			plan = append(plan, &actions.UserCreateAction{UserName: desiredUser.Name})
-			for _, groupName := range desiredUser.Groups {
-				plan = append(plan, &actions.AddUserToGroupAction{UserName: desiredUser.Name, GroupName: groupName})
-			}
-		} else {
-			// Update existing user's groups
-			desiredGroups := make(map[string]struct{})
-			for _, g := range desiredUser.Groups {
-				desiredGroups[g] = struct{}{}
-			}
+// userModifyAction returns the action that gives an existing user the passwd
+// fields the config sets, or nil when they already match.
+func userModifyAction(desired, current model.UserState) *actions.UserModifyAction {
+	if (desired.UID == 0 || desired.UID == current.UID) &&
+		(desired.Shell == "" || desired.Shell == current.Shell) &&
+		(desired.Home == "" || desired.Home == current.Home) &&
+		(desired.Gecos == "" || desired.Gecos == current.Gecos) {
+		return nil
+	}
+	return &actions.UserModifyAction{
+		UserName:     desired.Name,
+		UID:          desired.UID,
+		Shell:        desired.Shell,
+		Home:         desired.Home,
+		Gecos:        desired.Gecos,
+		CurrentUID:   current.UID,
+		CurrentShell: current.Shell,
+		CurrentHome:  current.Home,
+		CurrentGecos: current.Gecos,
+	}
+}
 
-			currentGroups := make(map[string]struct{})
-			for _, g := range currentUser.Groups {
-				currentGroups[g] = struct{}{}
-			}
+// calculateGroupActions returns the creations of declared groups missing
+// from the system and, when the config has a groups section, the removals of
+// non-system groups it neither declares nor puts a user in. A declared gid
+// taken by another group fails the plan; one differing from the gid of the
+// existing group is only reported, as gids of existing groups are not changed.
+func calculateGroupActions(desired, current *model.SystemState, runner system.CommandRunner, warnings *model.ValidationErrors) ([]actions.Action, []actions.Action, error) {
+	if len(desired.Groups) == 0 {
+		return nil, nil, nil
+	}
+	gids, err := currentGroupIDs(runner)
+	if err != nil {
+		return nil, nil, err
+	}
+	byGID := make(map[int]string)
+	for name, gid := range gids {
+		byGID[gid] = name
+	}
 
-			// Add to new groups
-			for groupName := range desiredGroups {
-				if _, exists := currentGroups[groupName]; !exists {
-					plan = append(plan, &actions.AddUserToGroupAction{UserName: desiredUser.Name, GroupName: groupName})
-				}
-			}
+	var errs model.ValidationErrors
+	for i, group := range desired.Groups {
+		gid, exists := gids[group.Name]
+		switch {
+		case !exists && group.GID != 0 && byGID[group.GID] != "":
+			errs = append(errs, model.ValidationError{Field: fmt.Sprintf("groups[%d].gid", i), Message: fmt.Sprintf("gid %d of group %s is already used by group %s", group.GID, group.Name, byGID[group.GID])})
+		case exists && group.GID != 0 && gid != group.GID:
+			*warnings = append(*warnings, model.ValidationError{Field: fmt.Sprintf("groups[%d].gid", i), Message: fmt.Sprintf("group %s has gid %d instead of %d; gids of existing groups are not changed", group.Name, gid, group.GID)})
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errs
+	}
+	existing := make([]model.GroupState, 0, len(gids))
+	for name, gid := range gids {
+		existing = append(existing, model.GroupState{Name: name, GID: gid})
+	}
+	toGroup := func(g model.GroupState) groupResource { return groupResource(g) }
+	creates, _ := reconcile(resources(desired.Groups, toGroup), resources(existing, toGroup), reconcileOptions{})
 
-			// Remove from unwanted groups
-			for groupName := range currentGroups {
-				if _, exists := desiredGroups[groupName]; !exists {
-					// Skip removing from primary group
-					if groupName == currentUser.PrimaryGroup {
-						continue
-					}
-					plan = append(plan, &actions.RemoveUserFromGroupAction{UserName: desiredUser.Name, GroupName: groupName})
-				}
+	// Only the non-system groups are candidates for removal, and groups
+	// users are put in count as declared, including the groups of users
+	// managed by other tooling
+	kept := append([]model.GroupState{}, desired.Groups...)
+	for _, user := range desired.Users {
+		for _, group := range user.Groups {
+			kept = append(kept, model.GroupState{Name: group})
+		}
+	}
+	for _, user := range current.Users {
+		if matchesAnyGlob(desired.IgnoredUsers, user.Name) {
+			for _, group := range user.Groups {
+				kept = append(kept, model.GroupState{Name: group})
 			}
 		}
 	}
+	_, removes := reconcile(resources(kept, toGroup), resources(current.Groups, toGroup), reconcileOptions{Prune: true})
+	return creates, removes, nil
+}
 
-	// Handle user removal (existing logic)
-	desiredMap := make(map[string]model.UserState)
-	for _, u := range desired {
-		desiredMap[u.Name] = u
+// withoutDeclaredGroups drops the group creations planned for the groups of
+// users when the group is declared in the groups section, which creates it
+// with its gid.
+func withoutDeclaredGroups(plan []actions.Action, groups []model.GroupState) []actions.Action {
+	declared := make(map[string]bool)
+	for _, group := range groups {
+		declared[group.Name] = true
+	}
+	var result []actions.Action
+	for _, action := range plan {
+		if create, ok := action.(*actions.GroupCreateAction); ok && declared[create.GroupName] {
+			continue
+		}
+		result = append(result, action)
 	}
+	return result
+}
+
+// calculateCrontabActions updates the summit-owned crontab block of declared
+// users whose jobs differ from the ones found on the system. Crontabs of users
+// that are not declared are left alone.
+func calculateCrontabActions(desired, current []model.UserState, ignored []string) []actions.Action {
+	changes, _ := reconcile(
+		resources(desired, func(u model.UserState) crontabResource { return crontabResource(u) }),
+		resources(current, func(u model.UserState) crontabResource { return crontabResource(u) }),
+		reconcileOptions{Ignored: ignored})
+	return changes
+}
 
-	for name := range currentUsersMap {
-		if _, ok := desiredMap[name]; !ok {
-			plan = append(plan, &actions.UserRemoveAction{UserName: name})
+// calculateAuthorizedKeyActions converges the authorized_keys of declared
+// users, see authorizedKeysResource.
+func calculateAuthorizedKeyActions(desired, current []model.UserState, ignored []string) []actions.Action {
+	changes, _ := reconcile(
+		resources(desired, func(u model.UserState) authorizedKeysResource { return authorizedKeysResource(u) }),
+		resources(current, func(u model.UserState) authorizedKeysResource { return authorizedKeysResource(u) }),
+		reconcileOptions{Ignored: ignored})
+	return changes
+}
+
+// calculateUserConfigActions writes the files of users' homes whose content,
+// mode or ownership differ. Files summit does not declare are left alone,
+// and so are the homes of ignored users.
+func calculateUserConfigActions(desired, current []model.UserConfigState, ignoredUsers []string, warnings *model.ValidationErrors) []actions.Action {
+	var managed []model.UserConfigState
+	for _, c := range desired {
+		if matchesAnyGlob(ignoredUsers, c.User) {
+			continue
+		}
+		if _, err := actions.Secrets.Expand(c.Content); err != nil {
+			*warnings = append(*warnings, model.ValidationError{Field: "~" + c.User + "/" + c.Path, Message: err.Error()})
 		}
+		managed = append(managed, c)
 	}
-
-	return plan, nil
+	adapt := func(c model.UserConfigState) userConfigResource { return userConfigResource(c) }
+	changes, _ := reconcile(resources(managed, adapt), resources(current, adapt), reconcileOptions{})
+	return changes
 }
 
 // inferCurrentSystemGroups retrieves the list of current system groups
@@ -341,64 +640,182 @@ func inferCurrentSystemGroups(runner system.CommandRunner) (map[string]struct{},
 	return currentSystemGroups, nil
 }
 
-func calculateConfigActions(desired *model.SystemState, current *model.SystemState, pruneUnmanaged bool) []actions.Action {
-	var a []actions.Action
+// currentGroupIDs returns the gid of every group in /etc/group.
+func currentGroupIDs(runner system.CommandRunner) (map[string]int, error) {
+	output, err := runner.Run("", "sh -c 'cat "+groupFilePath+"'")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current system groups: %w", err)
+	}
+	gids := make(map[string]int)
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if gid, err := strconv.Atoi(fields[2]); err == nil {
+			gids[fields[0]] = gid
+		}
+	}
+	return gids, nil
+}
 
-	// Helper function to check if a path should be ignored.
-	// Implemented as closure to access desired.IgnoredConfigs from parent scope.
-	isIgnored := func(path string) bool {
-		for _, pattern := range desired.IgnoredConfigs {
-			if MatchesGlob(pattern, path) {
-				return true
+// calculateConfigActions creates and updates the declared configs and decides
+// what happens to the files the config does not declare: they are deleted
+// with pruneUnmanaged, reverted or reported as the modified-files policy says,
+// or reported as unmanaged.
+func calculateConfigActions(desired *model.SystemState, current *model.SystemState, pruneUnmanaged bool, warnings *model.ValidationErrors) []actions.Action {
+	// Files holding a managed block or service options are shared with
+	// other tooling: they are neither pruned nor reverted to the package
+	// default.
+	undeclared := &undeclaredConfigs{prune: pruneUnmanaged, policy: desired.ModifiedFiles, shared: make(map[string]bool), warnings: warnings}
+	for _, b := range desired.ManagedBlocks {
+		undeclared.shared[b.Path] = true
+	}
+	for _, o := range desired.ServiceOptions {
+		undeclared.shared[o.Path()] = true
+	}
+
+	changes, deletions := reconcile(
+		resources(desired.Configs, func(c model.SystemConfigState) configResource {
+			// Compare with the secret values the file is written with
+			content, err := actions.Secrets.Expand(c.Content)
+			if err != nil {
+				if !matchesAnyGlob(desired.IgnoredConfigs, c.Path) {
+					*warnings = append(*warnings, model.ValidationError{Field: c.Path, Message: err.Error()})
+				}
+				content = c.Content
 			}
-		}
-		return false
+			return configResource{SystemConfigState: c, content: content}
+		}),
+		resources(current.Configs, func(c model.SystemConfigState) configResource {
+			return configResource{SystemConfigState: c, undeclared: undeclared}
+		}),
+		reconcileOptions{Ignored: desired.IgnoredConfigs, Prune: true})
+	return append(changes, deletions...)
+}
+
+// withGenerated returns desired with the files written on behalf of other
+// sections (the sysctl.d fragment, /etc/timezone, the locale script) among
+// the configs and the packages those sections need among the packages, so
+// they are planned like any file or package.
+func withGenerated(desired *model.SystemState) *model.SystemState {
+	configs, packages := desired.GeneratedConfigs(), desired.ImplicitPackages()
+	if len(configs) == 0 && len(packages) == 0 {
+		return desired
+	}
+	withGenerated := *desired
+	withGenerated.Configs = append(append([]model.SystemConfigState{}, desired.Configs...), configs...)
+	withGenerated.Packages = append(append([]model.PackageState{}, desired.Packages...), packages...)
+	return &withGenerated
+}
+
+func calculateSysctlActions(desired, current map[string]string) []actions.Action {
+	changes, _ := reconcile(sysctlResources(desired), sysctlResources(current), reconcileOptions{})
+	return changes
+}
+
+// sysctlResources returns the parameters of a sysctl map, sorted by key.
+func sysctlResources(values map[string]string) []sysctlResource {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]sysctlResource, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, sysctlResource{Name: key, Value: values[key]})
 	}
+	return result
+}
 
-	desiredMap := make(map[string]model.SystemConfigState)
-	for _, c := range desired.Configs {
-		if !isIgnored(c.Path) {
-			desiredMap[c.Path] = c
+// calculateManagedBlockActions returns an action for every managed block that
+// is missing from its file or differs from the desired content, compared with
+// the secret values it is written with.
+func calculateManagedBlockActions(blocks []model.ManagedBlockState, warnings *model.ValidationErrors) ([]actions.Action, error) {
+	var a []actions.Action
+	for _, b := range blocks {
+		action := &actions.ManagedBlockAction{Path: b.Path, Name: b.Name, Comment: b.Comment, Content: b.Content, Sensitive: b.Sensitive}
+		content, err := afero.ReadFile(system.AppFs, b.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", b.Path, err)
+		}
+		expanded, err := action.Expanded()
+		if err != nil {
+			*warnings = append(*warnings, model.ValidationError{Field: b.Path, Message: err.Error()})
+			expanded = action
+		}
+		if expanded.NeedsUpdate(string(content)) {
+			a = append(a, action)
 		}
 	}
+	return a, nil
+}
 
-	currentMap := make(map[string]model.SystemConfigState)
-	for _, c := range current.Configs {
-		if !isIgnored(c.Path) {
-			currentMap[c.Path] = c
+// calculateServiceOptionsActions returns an action for every service whose
+// conf.d file does not set its options to the declared values, compared with
+// the secret values they are written with.
+func calculateServiceOptionsActions(options []model.ServiceOptionsState, warnings *model.ValidationErrors) ([]actions.Action, error) {
+	var a []actions.Action
+	for _, o := range options {
+		action := &actions.ServiceOptionsAction{Service: o.Service, Options: o.Options, Sensitive: o.Sensitive}
+		content, err := afero.ReadFile(system.AppFs, o.Path())
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", o.Path(), err)
+		}
+		expanded, err := action.Expanded()
+		if err != nil {
+			*warnings = append(*warnings, model.ValidationError{Field: o.Path(), Message: err.Error()})
+			expanded = action
+		}
+		if expanded.NeedsUpdate(string(content)) {
+			a = append(a, action)
 		}
 	}
+	return a, nil
+}
 
-	for path, desiredConfig := range desiredMap {
-		if currentConfig, ok := currentMap[path]; ok {
-			if desiredConfig.Content != currentConfig.Content {
-				a = append(a, &actions.FileUpdateAction{Path: path, NewContent: desiredConfig.Content})
-			}
-			if desiredConfig.Mode != "" && desiredConfig.Mode != currentConfig.Mode {
-				a = append(a, &actions.FileChmodAction{Path: path, Mode: desiredConfig.Mode})
-			}
-			if (desiredConfig.Owner != "" && desiredConfig.Owner != currentConfig.Owner) || (desiredConfig.Group != "" && desiredConfig.Group != currentConfig.Group) {
-				a = append(a, &actions.FileChownAction{Path: path, Owner: desiredConfig.Owner, Group: desiredConfig.Group})
-			}
-		} else {
-			a = append(a, &actions.FileCreateAction{Path: path, Content: desiredConfig.Content, Mode: desiredConfig.Mode, Owner: desiredConfig.Owner, Group: desiredConfig.Group})
-		}
+// modeDiffers reports whether an existing file needs a chmod. An empty desired
+// mode means "keep the current mode".
+func modeDiffers(desired, current string) bool {
+	return desired != "" && model.NormalizeMode(desired) != model.NormalizeMode(current)
+}
+
+// ownershipDiffers reports whether an existing file needs a chown. Empty desired
+// owner or group means "keep the current value". Owners and groups are compared
+// by numeric id, so "root" and "0" are the same owner.
+func ownershipDiffers(desired, current model.SystemConfigState) bool {
+	return (desired.Owner != "" && !sameID(desired.Owner, current.Owner, actions.Resolver.UID)) ||
+		(desired.Group != "" && !sameID(desired.Group, current.Group, actions.Resolver.GID))
+}
+
+// sameID compares two user or group references, resolving them to numeric ids
+// when the names differ. When either side cannot be resolved the names are
+// compared as-is.
+func sameID(desired, current string, resolve func(string) (int, error)) bool {
+	if desired == current {
+		return true
 	}
+	desiredID, err := resolve(desired)
+	if err != nil {
+		return false
+	}
+	currentID, err := resolve(current)
+	if err != nil {
+		return false
+	}
+	return desiredID == currentID
+}
 
-	for path, currentConfig := range currentMap {
-		if _, ok := desiredMap[path]; !ok {
-			switch currentConfig.Origin {
-			case model.OriginUserCreated:
-				if pruneUnmanaged {
-					a = append(a, &actions.FileDeleteAction{Path: path})
-				} else if !isIgnored(path) {
-					fmt.Fprintf(os.Stderr, unmanagedFileWarning, path)
-				}
-			case model.OriginPackageModified:
-				a = append(a, &actions.FileRevertAction{Path: path, OwnerPackage: currentConfig.OriginPackage})
-			}
+// modifiedFilePolicy returns the policy for an unmanaged package-modified file:
+// the first matching path rule, then the default, then revert.
+func modifiedFilePolicy(policy model.ModifiedFilesPolicy, path string) string {
+	for _, rule := range policy.Paths {
+		if MatchesGlob(rule.Path, path) {
+			return rule.Policy
 		}
 	}
-
-	return a
+	if policy.Default != "" {
+		return policy.Default
+	}
+	return model.ModifiedRevert
 }
@@ -10,7 +10,12 @@ import (
 	"strings"
 	"summit/pkg/actions"
 	"summit/pkg/model"
+	"summit/pkg/secrets"
+	"summit/pkg/system"
 	"testing"
+	"time"
+
+	"github.com/spf13/afero"
 )
 
 // MockCommandRunner is a mock implementation of the CommandRunner for testing.
@@ -31,6 +36,11 @@ func (r *MockCommandRunner) Run(user, command string) ([]byte, error) {
 	return nil, fmt.Errorf("no mock response for %s", key)
 }
 
+// RunInput simulates running a command with input on its stdin.
+func (r *MockCommandRunner) RunInput(user, command string, input []byte) ([]byte, error) {
+	return r.Run(user, command)
+}
+
 func TestCalculatePlanWithUserPackages(t *testing.T) {
 	desired := &model.SystemState{
 		Packages: []model.PackageState{
@@ -101,6 +111,110 @@ func TestCalculatePlanWithUserPackages(t *testing.T) {
 	}
 }
 
+func TestCalculatePlanWithCargoUserPackages(t *testing.T) {
+	desired := &model.SystemState{
+		Packages:     []model.PackageState{{Name: "cargo"}},
+		Users:        []model.UserState{{Name: "mino"}},
+		UserPackages: []model.UserPackageState{{User: "mino", Cargo: []string{"ripgrep", "fd-find"}}},
+	}
+	current := &model.SystemState{
+		Packages: []model.PackageState{{Name: "cargo"}},
+		Users:    []model.UserState{{Name: "mino"}},
+	}
+	runner := &MockCommandRunner{
+		Responses: map[string][]byte{
+			":sh -c 'cat /etc/group'":   []byte(""),
+			"mino:cargo install --list": []byte("bat v0.24.0:\n    bat\nripgrep v14.1.0:\n    rg\n"),
+		},
+	}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	expected := []actions.Action{
+		&actions.UserPackageAction{User: "mino", Manager: "cargo", Package: "bat", State: model.PackageStateAbsent},
+		&actions.UserPackageAction{User: "mino", Manager: "cargo", Package: "fd-find", State: model.PackageStatePresent},
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Description() < plan[j].Description()
+	})
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanWithGemAndUvUserPackages(t *testing.T) {
+	desired := &model.SystemState{
+		Packages:     []model.PackageState{{Name: "ruby"}, {Name: "uv"}},
+		Users:        []model.UserState{{Name: "mino"}},
+		UserPackages: []model.UserPackageState{{User: "mino", Gem: []string{"rake"}, Uv: []string{"ruff"}}},
+	}
+	current := &model.SystemState{
+		Packages: []model.PackageState{{Name: "ruby"}, {Name: "uv"}},
+		Users:    []model.UserState{{Name: "mino"}},
+	}
+	runner := &MockCommandRunner{
+		Responses: map[string][]byte{
+			":sh -c 'cat /etc/group'": []byte(""),
+			"mino:gem list --local":   []byte("bundler (default: 2.4.19)\nrake (13.0.6)\nrexml (3.2.6)\n"),
+			"mino:dir=$(ruby -e 'print Gem.user_dir') && GEM_HOME=$dir GEM_PATH=$dir gem list --local": []byte("rake (13.0.6)\n"),
+			"mino:uv tool list": []byte("httpie v3.2.2\n- http\n"),
+		},
+	}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	expected := []actions.Action{
+		&actions.UserPackageAction{User: "mino", Manager: "uv", Package: "httpie", State: model.PackageStateAbsent},
+		&actions.UserPackageAction{User: "mino", Manager: "uv", Package: "ruff", State: model.PackageStatePresent},
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Description() < plan[j].Description()
+	})
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanBatchesUserPackageInstalls(t *testing.T) {
+	desired := &model.SystemState{
+		Packages:     []model.PackageState{{Name: "pipx"}, {Name: "uv"}},
+		Users:        []model.UserState{{Name: "mino"}},
+		UserPackages: []model.UserPackageState{{User: "mino", Pipx: []string{"ruff", "black", "poetry"}, Uv: []string{"ruff", "httpie"}}},
+	}
+	current := &model.SystemState{
+		Packages: []model.PackageState{{Name: "pipx"}, {Name: "uv"}},
+		Users:    []model.UserState{{Name: "mino"}},
+	}
+	runner := &MockCommandRunner{
+		Responses: map[string][]byte{
+			":sh -c 'cat /etc/group'": []byte(""),
+			"mino:pipx list --json":   []byte(`{"venvs": {}}`),
+			"mino:uv tool list":       []byte("No tools installed\n"),
+		},
+	}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	// uv tool install takes a single package
+	expected := []actions.Action{
+		&actions.UserPackageBatchInstallAction{User: "mino", Manager: "pipx", Packages: []string{"black", "poetry", "ruff"}},
+		&actions.UserPackageAction{User: "mino", Manager: "uv", Package: "httpie", State: model.PackageStatePresent},
+		&actions.UserPackageAction{User: "mino", Manager: "uv", Package: "ruff", State: model.PackageStatePresent},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
 func TestCalculatePlanWithUserPackagesDependencyFailure(t *testing.T) {
 	desired := &model.SystemState{
 		Packages: []model.PackageState{},
@@ -332,6 +446,28 @@ func TestCalculateUserActions_GroupManagement(t *testing.T) {
 				&actions.RemoveUserFromGroupAction{UserName: "existinguser", GroupName: "oldgroup"},
 			},
 		},
+		{
+			name: "Passwd fields",
+			desired: []model.UserState{
+				{Name: "newuser", UID: 1500, Shell: "/bin/bash"},
+				{Name: "existinguser", Shell: "/bin/bash", Gecos: "Existing User"},
+				{Name: "sameuser", UID: 1001, Shell: "/bin/ash"},
+			},
+			current: []model.UserState{
+				{Name: "existinguser", UID: 1000, Shell: "/bin/ash", Home: "/home/existinguser"},
+				{Name: "sameuser", UID: 1001, Shell: "/bin/ash", Home: "/home/sameuser"},
+			},
+			mockResp: map[string][]byte{
+				":sh -c 'cat /etc/group'": []byte("root:x:0:\n"),
+			},
+			expected: []actions.Action{
+				&actions.UserCreateAction{UserName: "newuser", UID: 1500, Shell: "/bin/bash"},
+				&actions.UserModifyAction{
+					UserName: "existinguser", Shell: "/bin/bash", Gecos: "Existing User",
+					CurrentUID: 1000, CurrentShell: "/bin/ash", CurrentHome: "/home/existinguser",
+				},
+			},
+		},
 		{
 			name: "No changes",
 			desired: []model.UserState{
@@ -354,7 +490,7 @@ func TestCalculateUserActions_GroupManagement(t *testing.T) {
 				Errors:    make(map[string]error),
 			}
 
-			plan, err := calculateUserActions(tt.desired, tt.current, runner)
+			plan, err := calculateUserActions(tt.desired, tt.current, nil, runner)
 			if err != nil {
 				t.Fatalf("calculateUserActions failed: %v", err)
 			}
@@ -512,3 +648,966 @@ func TestMatchesGlob(t *testing.T) {
 		})
 	}
 }
+
+func TestCalculatePlanWithPlugins(t *testing.T) {
+	desired := &model.SystemState{
+		Plugins: []model.PluginState{{Name: "summit-plugin-zfs"}},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{
+		":sh -c 'cat /etc/group'": []byte(""),
+		// The same response answers infer and diff
+		":timeout 300 'summit-plugin-zfs'": []byte(`{"state": {}, "changes": [{"id": "c1", "description": "Create dataset tank/home"}]}`),
+	}}
+
+	plan, err := CalculatePlan(desired, &model.SystemState{}, runner, false)
+	if err != nil {
+		t.Fatalf("CalculatePlan() error = %v", err)
+	}
+	if len(plan) != 1 {
+		t.Fatalf("expected 1 action, got %d", len(plan))
+	}
+	if got := plan[0].Description(); got != "[summit-plugin-zfs] Create dataset tank/home" {
+		t.Errorf("unexpected description: %s", got)
+	}
+
+	runner.Errors = map[string]error{":timeout 300 'summit-plugin-zfs'": fmt.Errorf("exit status 124")}
+	if _, err := CalculatePlan(desired, &model.SystemState{}, runner, false); err == nil || !strings.Contains(err.Error(), "plugin summit-plugin-zfs failed during infer") {
+		t.Errorf("expected the plugin failure, got %v", err)
+	}
+}
+
+func TestCalculatePlanWithIgnoredResources(t *testing.T) {
+	desired := &model.SystemState{
+		Packages:        []model.PackageState{{Name: "htop"}},
+		IgnoredPackages: []string{"*-dbg"},
+		IgnoredServices: []string{"kubelet"},
+		IgnoredUsers:    []string{"backup*"},
+	}
+
+	current := &model.SystemState{
+		Packages: []model.PackageState{{Name: "htop"}, {Name: "musl-dbg"}, {Name: "vim"}},
+		Services: []model.ServiceState{
+			{Name: "kubelet", Enabled: true, Runlevel: "default"},
+			{Name: "crond", Enabled: true, Runlevel: "default"},
+		},
+		Users: []model.UserState{{Name: "backup-agent"}, {Name: "olduser"}},
+	}
+
+	runner := &MockCommandRunner{
+		Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")},
+	}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	var descriptions []string
+	for _, action := range plan {
+		descriptions = append(descriptions, action.Description())
+	}
+	sort.Strings(descriptions)
+
+	expected := []string{
+		"Remove package vim",
+		"Remove user olduser",
+		"Stop and disable service crond in runlevel default",
+	}
+	if !reflect.DeepEqual(descriptions, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %v\nExpected: %v", descriptions, expected)
+	}
+}
+
+func TestCalculatePlanWithWarningsReturnsUnmanagedFiles(t *testing.T) {
+	desired := &model.SystemState{
+		IgnoredConfigs: []string{"/etc/ignored.conf"},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/ignored.conf", Content: "x", Origin: model.OriginUserCreated},
+			{Path: "/etc/stray.conf", Content: "x", Origin: model.OriginUserCreated},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, warnings, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	if len(plan) != 0 {
+		t.Errorf("expected empty plan, got %d actions", len(plan))
+	}
+	if len(warnings) != 1 || warnings[0].Field != "/etc/stray.conf" {
+		t.Fatalf("expected a single warning for /etc/stray.conf, got %+v", warnings)
+	}
+}
+
+func TestCalculatePlanConvergesModeAndOwnership(t *testing.T) {
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/a.conf", Content: "a", Mode: "644"},
+			{Path: "/etc/b.conf", Content: "b"},
+			{Path: "/etc/c.conf", Content: "c", Owner: "root"},
+			{Path: "/etc/d.conf", Content: "d", Mode: "0600", Group: "wheel"},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/a.conf", Content: "a", Mode: "0644", Owner: "root", Group: "root"},
+			{Path: "/etc/b.conf", Content: "b", Mode: "0600", Owner: "nobody", Group: "nogroup"},
+			{Path: "/etc/c.conf", Content: "c", Mode: "0644", Owner: "root", Group: "adm"},
+			{Path: "/etc/d.conf", Content: "d", Mode: "0644", Owner: "root", Group: "root"},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	expected := []actions.Action{
+		&actions.FileChmodAction{Path: "/etc/d.conf", Mode: "0600"},
+		&actions.FileChownAction{Path: "/etc/d.conf", Group: "wheel"},
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Description() < plan[j].Description()
+	})
+	sort.Slice(expected, func(i, j int) bool {
+		return expected[i].Description() < expected[j].Description()
+	})
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanComparesSecretValues(t *testing.T) {
+	t.Setenv("SUMMIT_SECRET_DB", "hunter2")
+	previous := actions.Secrets
+	t.Cleanup(func() { actions.Secrets = previous })
+	actions.Secrets = secrets.NewResolver(secrets.EnvProvider{Prefix: secrets.DefaultEnvPrefix})
+
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/current.conf", Content: "password=secret://db\n"},
+			{Path: "/etc/stale.conf", Content: "password=secret://db\n"},
+			{Path: "/etc/missing.conf", Content: "token=secret://missing\n"},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/current.conf", Content: "password=hunter2\n"},
+			{Path: "/etc/stale.conf", Content: "password=old\n"},
+			{Path: "/etc/missing.conf", Content: "token=abc\n"},
+		},
+	}
+
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, warnings, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		return plan[i].Description() < plan[j].Description()
+	})
+	// The plan keeps the references; the values are only used to compare
+	expected := []actions.Action{
+		&actions.FileUpdateAction{Path: "/etc/missing.conf", NewContent: "token=secret://missing\n"},
+		&actions.FileUpdateAction{Path: "/etc/stale.conf", NewContent: "password=secret://db\n"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+	if len(warnings) != 1 || warnings[0].Field != "/etc/missing.conf" {
+		t.Errorf("Expected a warning for the missing secret, got %v", warnings)
+	}
+}
+
+func TestCalculatePlanSysctl(t *testing.T) {
+	desired := &model.SystemState{Sysctl: map[string]string{
+		"net.ipv4.ip_forward": "1",
+		"vm.swappiness":       "10",
+		"net.ipv4.tcp_rmem":   "4096 87380 6291456",
+	}}
+	current := &model.SystemState{
+		Sysctl: map[string]string{"net.ipv4.ip_forward": "0", "vm.swappiness": "10", "net.ipv4.tcp_rmem": "4096\t87380\t6291456"},
+		Configs: []model.SystemConfigState{
+			{Path: model.SysctlFragmentPath, Content: "# Managed by summit\nnet.ipv4.ip_forward = 0\n", Origin: model.OriginUserCreated},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	expected := []actions.Action{
+		&actions.FileUpdateAction{Path: model.SysctlFragmentPath, NewContent: "# Managed by summit\nnet.ipv4.ip_forward = 1\nnet.ipv4.tcp_rmem = 4096 87380 6291456\nvm.swappiness = 10\n"},
+		&actions.SysctlSetAction{Key: "net.ipv4.ip_forward", Value: "1", Current: "0"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanTimezoneAndLocale(t *testing.T) {
+	desired := &model.SystemState{Timezone: "Europe/Rome", Locale: "en_US.UTF-8"}
+	current := &model.SystemState{
+		Timezone: "UTC",
+		Packages: []model.PackageState{{Name: "tzdata"}},
+		Configs: []model.SystemConfigState{
+			{Path: model.TimezonePath, Content: "UTC\n", Origin: model.OriginUserCreated},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	// Config actions come from a map, so compare them regardless of order
+	expected := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "musl-locales"},
+		&actions.FileCreateAction{Path: model.LocalePath, Content: "# Managed by summit\nexport LANG=en_US.UTF-8\n"},
+		&actions.FileUpdateAction{Path: model.TimezonePath, NewContent: "Europe/Rome\n"},
+		&actions.TimezoneSetAction{Zone: "Europe/Rome", Current: "UTC"},
+	}
+	if len(plan) != len(expected) {
+		t.Fatalf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+	for _, want := range expected {
+		found := false
+		for _, got := range plan {
+			if reflect.DeepEqual(got, want) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Plan is missing %+v:\nGot: %+v", want, plan)
+		}
+	}
+	if plan[0].Type() != "package.install" || plan[len(plan)-1].Type() != "timezone.set" {
+		t.Errorf("Packages must install first and the zone link come last, got %+v", plan)
+	}
+}
+
+func TestOwnershipDiffersComparesIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		desired  model.SystemConfigState
+		current  model.SystemConfigState
+		expected bool
+	}{
+		{"same names", model.SystemConfigState{Owner: "root", Group: "root"}, model.SystemConfigState{Owner: "root", Group: "root"}, false},
+		{"numeric owner matches name", model.SystemConfigState{Owner: "0"}, model.SystemConfigState{Owner: "root", Group: "root"}, false},
+		{"name matches numeric fallback", model.SystemConfigState{Group: "root"}, model.SystemConfigState{Owner: "0", Group: "0"}, false},
+		{"different ids", model.SystemConfigState{Owner: "1234"}, model.SystemConfigState{Owner: "root"}, true},
+		{"unresolvable name", model.SystemConfigState{Owner: "no-such-user-summit"}, model.SystemConfigState{Owner: "root"}, true},
+		{"empty inherits", model.SystemConfigState{}, model.SystemConfigState{Owner: "nobody", Group: "nogroup"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ownershipDiffers(tt.desired, tt.current); got != tt.expected {
+				t.Errorf("ownershipDiffers() = %v, want %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCalculatePlanModifiedFilesPolicy(t *testing.T) {
+	desired := &model.SystemState{
+		ModifiedFiles: model.ModifiedFilesPolicy{
+			Default: model.ModifiedWarn,
+			Paths: []model.ModifiedFileRule{
+				{Path: "/etc/nginx/**", Policy: model.ModifiedRevert},
+				{Path: "/etc/ssh/*", Policy: model.ModifiedIgnore},
+			},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/nginx/nginx.conf", Origin: model.OriginPackageModified, OriginPackage: "nginx"},
+			{Path: "/etc/ssh/sshd_config", Origin: model.OriginPackageModified, OriginPackage: "openssh"},
+			{Path: "/etc/hosts", Origin: model.OriginPackageModified, OriginPackage: "alpine-baselayout"},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{
+		":sh -c 'cat /etc/group'": []byte(""),
+		":apk info nginx":         []byte("nginx-1.26.2-r0 description:\nHTTP and reverse proxy server\n"),
+	}}
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	if err := afero.WriteFile(system.AppFs, "/var/cache/apk/nginx-1.26.2-r0.apk", []byte("apk"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	plan, warnings, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	// The revert is resolved to the cached apk it will extract from
+	expected := []actions.Action{
+		&actions.FileRevertAction{Path: "/etc/nginx/nginx.conf", OwnerPackage: "nginx", Version: "1.26.2-r0", CachedApk: "/var/cache/apk/nginx-1.26.2-r0.apk"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+	if len(warnings) != 1 || warnings[0].Field != "/etc/hosts" {
+		t.Errorf("expected a single warning for /etc/hosts, got %+v", warnings)
+	}
+}
+
+func TestCalculatePlanCoalescesServiceRestarts(t *testing.T) {
+	desired := &model.SystemState{
+		Services: []model.ServiceState{
+			{Name: "nginx", Enabled: true, Runlevel: "default", ReloadPreferred: true},
+			{Name: "haproxy", Enabled: true, Runlevel: "default"},
+		},
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/haproxy/haproxy.cfg", Content: "same", Notify: []string{"haproxy"}},
+			{Path: "/etc/nginx/http.d/a.conf", Content: "new a", Notify: []string{"nginx"}},
+			{Path: "/etc/nginx/http.d/b.conf", Content: "new b", Notify: []string{"nginx"}},
+			{Path: "/etc/nginx/http.d/c.conf", Content: "same", Notify: []string{"nginx"}},
+			{Path: "/etc/haproxy/errors.http", Content: "new", Notify: []string{"reload haproxy"}},
+			{Path: "/etc/ssh/sshd_config", Content: "new", Notify: []string{"restart sshd"}},
+			{Path: "/etc/ssh/sshd_config.d/a.conf", Content: "new", Notify: []string{"reload sshd"}},
+		},
+	}
+	current := &model.SystemState{
+		Services: []model.ServiceState{
+			{Name: "nginx", Enabled: true, Runlevel: "default"},
+			{Name: "haproxy", Enabled: true, Runlevel: "default"},
+		},
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/haproxy/haproxy.cfg", Content: "same", Origin: model.OriginUserCreated},
+			{Path: "/etc/nginx/http.d/a.conf", Content: "old a", Origin: model.OriginUserCreated},
+			{Path: "/etc/nginx/http.d/c.conf", Content: "same", Origin: model.OriginUserCreated},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, _, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	var restarts []*actions.ServiceRestartAction
+	for _, action := range plan {
+		if r, ok := action.(*actions.ServiceRestartAction); ok {
+			restarts = append(restarts, r)
+		}
+	}
+	expected := []*actions.ServiceRestartAction{
+		{ServiceName: "haproxy", Reload: true, Files: []string{"/etc/haproxy/errors.http"}},
+		{ServiceName: "nginx", Reload: true, Files: []string{"/etc/nginx/http.d/a.conf", "/etc/nginx/http.d/b.conf"}},
+		{ServiceName: "sshd", Files: []string{"/etc/ssh/sshd_config", "/etc/ssh/sshd_config.d/a.conf"}},
+	}
+	if !reflect.DeepEqual(restarts, expected) {
+		t.Errorf("Restarts not as expected:\nGot:      %+v\nExpected: %+v", restarts, expected)
+	}
+	for i, restart := range restarts {
+		if plan[len(plan)-len(restarts)+i] != actions.Action(restart) {
+			t.Errorf("restarts must be the last actions, got %s", plan[len(plan)-len(restarts)+i].Description())
+		}
+	}
+}
+
+func TestCalculatePlanManagedBlocks(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	hosts := "127.0.0.1 localhost\n# BEGIN summit\n10.0.0.5 db\n# END summit\n"
+	if err := afero.WriteFile(system.AppFs, "/etc/hosts", []byte(hosts), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := &model.SystemState{
+		ManagedBlocks: []model.ManagedBlockState{
+			{Path: "/etc/hosts", Content: "10.0.0.5 db\n"},
+			{Path: "/etc/fstab", Name: "nfs", Content: "nas:/data /data nfs defaults 0 0\n"},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/hosts", Origin: model.OriginPackageModified, OriginPackage: "alpine-baselayout"},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, warnings, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	// /etc/hosts already holds the block and, despite being package-modified,
+	// is not reverted; /etc/fstab is missing its block.
+	expected := []actions.Action{
+		&actions.ManagedBlockAction{Path: "/etc/fstab", Name: "nfs", Content: "nas:/data /data nfs defaults 0 0\n"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("expected no warnings, got %+v", warnings)
+	}
+}
+
+func TestCalculatePlan_PackageManagerWithoutPins(t *testing.T) {
+	system.Packages = system.Dnf{}
+	t.Cleanup(func() { system.Packages = system.Apk{} })
+
+	desired := &model.SystemState{Packages: []model.PackageState{{Name: "htop", Version: "3.3.0-r0"}, {Name: "curl"}}}
+	current := &model.SystemState{Packages: []model.PackageState{{Name: "htop"}}}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, warnings, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	expected := []actions.Action{&actions.PackageInstallAction{PackageName: "curl"}}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+	want := model.ValidationErrors{{Field: "packages", Message: "dnf cannot pin package htop to version 3.3.0-r0; the version is ignored"}}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %+v, want %+v", warnings, want)
+	}
+}
+
+func TestCalculateIndexUpdate(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	installs := []actions.Action{&actions.PackageInstallAction{PackageName: "htop"}}
+	removes := []actions.Action{&actions.PackageRemoveAction{PackageName: "nano"}}
+
+	tests := []struct {
+		name     string
+		policy   string
+		packages []actions.Action
+		age      time.Duration
+		want     actions.Action
+	}{
+		{"never", model.IndexUpdateNever, installs, 48 * time.Hour, nil},
+		{"default", "", installs, 48 * time.Hour, nil},
+		{"always", model.IndexUpdateAlways, installs, time.Minute, &actions.PackageIndexUpdateAction{Reason: "package-index-update is always"}},
+		{"always without installs", model.IndexUpdateAlways, removes, time.Minute, nil},
+		{"fresh", model.IndexUpdateIfStale, installs, time.Hour, nil},
+		{"stale", model.IndexUpdateIfStale, installs, 48 * time.Hour, &actions.PackageIndexUpdateAction{Reason: "package index is 48h0m0s old"}},
+		{"missing", model.IndexUpdateIfStale, installs, 0, &actions.PackageIndexUpdateAction{Reason: "no package index"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			index := "/var/cache/apk/APKINDEX.12345678.tar.gz"
+			_ = system.AppFs.Remove(index)
+			if tt.age > 0 {
+				if err := afero.WriteFile(system.AppFs, index, []byte("index"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				modified := time.Now().Add(-tt.age)
+				if err := system.AppFs.Chtimes(index, modified, modified); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := calculateIndexUpdate(tt.policy, tt.packages)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calculateIndexUpdate() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculatePlan_VirtualPackages(t *testing.T) {
+	desired := &model.SystemState{VirtualPackages: []model.VirtualPackageState{
+		{Name: ".build-deps", Packages: []string{"make", "gcc"}},
+		{Name: ".docs", Packages: []string{"man-pages"}},
+		{Name: ".python-deps", Packages: []string{"python3-dev"}},
+	}}
+	current := &model.SystemState{VirtualPackages: []model.VirtualPackageState{
+		{Name: ".build-deps", Packages: []string{"gcc", "make"}},
+		{Name: ".docs", Packages: []string{"man-pages", "mandoc"}},
+		{Name: ".old-deps", Packages: []string{"perl"}},
+	}}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	expected := []actions.Action{
+		&actions.VirtualPackageInstallAction{Name: ".docs", Packages: []string{"man-pages"}, Update: true, PreviousPackages: []string{"man-pages", "mandoc"}},
+		&actions.VirtualPackageInstallAction{Name: ".python-deps", Packages: []string{"python3-dev"}},
+		&actions.VirtualPackageRemoveAction{Name: ".old-deps", Packages: []string{"perl"}},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanServiceOptions(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	if err := afero.WriteFile(system.AppFs, "/etc/conf.d/sshd", []byte("# sshd\ncommand_args='-4'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(system.AppFs, "/etc/conf.d/nginx", []byte("cfgfile=/etc/nginx/nginx.conf\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := &model.SystemState{
+		ServiceOptions: []model.ServiceOptionsState{
+			{Service: "sshd", Options: map[string]string{"command_args": "-4"}},
+			{Service: "nginx", Options: map[string]string{"cfgfile": "/etc/nginx/main.conf"}},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/conf.d/sshd", Origin: model.OriginPackageModified, OriginPackage: "openssh-server"},
+			{Path: "/etc/conf.d/nginx", Origin: model.OriginUserCreated},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, err := CalculatePlan(desired, current, runner, true)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	// sshd already has its option and its file is not reverted; the nginx
+	// file is neither pruned nor left with the old value, and nginx is
+	// restarted to read it
+	expected := []actions.Action{
+		&actions.ServiceOptionsAction{Service: "nginx", Options: map[string]string{"cfgfile": "/etc/nginx/main.conf"}},
+		&actions.ServiceRestartAction{ServiceName: "nginx", Files: []string{"/etc/conf.d/nginx"}},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanCommands(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	if err := afero.WriteFile(system.AppFs, "/etc/ssl/dh.pem", []byte("dh"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := &model.SystemState{
+		Commands: []model.CommandState{
+			{Name: "dhparam", Command: "openssl dhparam -out /etc/ssl/dh.pem 2048", Creates: "/etc/ssl/dh.pem"},
+			{Name: "migrate", Command: "app migrate", User: "app", Unless: "app migrate --check", Timeout: "1m"},
+			{Name: "seed", Command: "app seed", Unless: "app seeded"},
+		},
+	}
+	runner := &MockCommandRunner{
+		Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte(""), ":timeout 300 sh -c 'app seeded'": []byte("")},
+		Errors:    map[string]error{"app:timeout 60 sh -c 'app migrate --check'": fmt.Errorf("exit status 1")},
+	}
+
+	plan, err := CalculatePlan(desired, &model.SystemState{}, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	// dhparam created its file and seed's unless succeeds
+	expected := []actions.Action{
+		&actions.CommandAction{Name: "migrate", Command: "app migrate", User: "app", Unless: "app migrate --check", Timeout: time.Minute},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanCrontabs(t *testing.T) {
+	desired := &model.SystemState{
+		Users: []model.UserState{
+			{Name: "alice", Crontab: []model.CronJobState{{Name: "backup", Schedule: "0 3 * * *", Command: "backup.sh"}}},
+			{Name: "bob", Crontab: []model.CronJobState{{Schedule: "@daily", Command: "cleanup"}}},
+			{Name: "carol"},
+		},
+	}
+	current := &model.SystemState{
+		Users: []model.UserState{
+			{Name: "alice", Crontab: []model.CronJobState{{Name: "backup", Schedule: "0 3 * * *", Command: "backup.sh"}}},
+			{Name: "bob"},
+			{Name: "carol", Crontab: []model.CronJobState{{Schedule: "@hourly", Command: "poll"}}},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+
+	// alice is up to date, bob gains a job and carol's summit block is emptied
+	expected := []actions.Action{
+		&actions.CrontabAction{User: "bob", Content: "@daily cleanup\n"},
+		&actions.CrontabAction{User: "carol", Content: "", Current: "@hourly poll\n"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculatePlanPackageOwnedConfigs(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	if err := afero.WriteFile(system.AppFs, "/etc/nginx/nginx.conf", []byte("upstream"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{{Path: "/etc/nginx/nginx.conf", Content: "ours"}},
+	}
+	current := &model.SystemState{}
+	runner := &MockCommandRunner{Responses: map[string][]byte{
+		":sh -c 'cat /etc/group'":                      []byte(""),
+		":apk info --who-owns '/etc/nginx/nginx.conf'": []byte("/etc/nginx/nginx.conf is owned by nginx-1.26.2-r0\n"),
+	}}
+
+	plan, _, err := CalculatePlanWithWarnings(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Error calculating plan: %v", err)
+	}
+	if len(plan) != 1 || plan[0].(*actions.FileCreateAction).OwnerPackage != "nginx-1.26.2-r0" {
+		t.Fatalf("expected the create action to record the owning package, got %+v", plan)
+	}
+	if details := plan[0].ExecutionDetails(); details[len(details)-1] != "overrides file owned by package nginx-1.26.2-r0" {
+		t.Errorf("unexpected details: %v", details)
+	}
+
+	desired.PackageOwnedConfigs = model.PackageOwnedError
+	if _, _, err := CalculatePlanWithWarnings(desired, current, runner, false); err == nil || !strings.Contains(err.Error(), "configs[0].path: file is owned by package 'nginx-1.26.2-r0'") {
+		t.Errorf("expected the error policy to refuse the plan, got %v", err)
+	}
+
+	desired.Configs[0].OverridesPackage = true
+	if _, _, err := CalculatePlanWithWarnings(desired, current, runner, false); err != nil {
+		t.Errorf("overrides-package must acknowledge the conflict, got %v", err)
+	}
+}
+
+func TestOrderByWants(t *testing.T) {
+	desired := &model.SystemState{
+		Packages: []model.PackageState{{Name: "app", Wants: []string{"user:app"}}},
+		Services: []model.ServiceState{{Name: "app", Wants: []string{"config:/etc/app.conf", "service:absent"}}},
+		Users:    []model.UserState{{Name: "app"}},
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/a.conf", Wants: []string{"config:/etc/b.conf"}},
+			{Path: "/etc/b.conf", Wants: []string{"config:/etc/a.conf"}},
+		},
+	}
+	plan := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "htop"},
+		&actions.PackageInstallAction{PackageName: "app"},
+		&actions.ServiceEnableAction{ServiceName: "app", Runlevel: "default"},
+		&actions.UserCreateAction{UserName: "app"},
+		&actions.AddUserToGroupAction{UserName: "app", GroupName: "wheel"},
+		&actions.FileCreateAction{Path: "/etc/a.conf"},
+		&actions.FileCreateAction{Path: "/etc/b.conf"},
+		&actions.FileCreateAction{Path: "/etc/app.conf"},
+	}
+
+	var got []string
+	for _, action := range orderByWants(plan, desired) {
+		got = append(got, action.Description())
+	}
+	want := []string{
+		plan[0].Description(),
+		plan[3].Description(),
+		plan[4].Description(),
+		plan[1].Description(),
+		plan[7].Description(),
+		plan[2].Description(),
+		plan[5].Description(), // a and b want each other: the cycle is broken in plan order
+		plan[6].Description(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("orderByWants() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestOrderByWants_ServiceDependencies(t *testing.T) {
+	desired := &model.SystemState{
+		Packages: []model.PackageState{{Name: "nginx", Wants: []string{"config:/etc/motd"}}},
+		Services: []model.ServiceState{{Name: "nginx", Enabled: true, Runlevel: "default"}, {Name: "crond"}},
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/motd"},
+			{Path: "/etc/nginx/nginx.conf", Notify: model.NotifyList{"reload nginx"}},
+			{Path: "/etc/conf.d/nginx"},
+			{Path: "/etc/conf.d/crond"},
+		},
+	}
+	plan := []actions.Action{
+		&actions.ServiceEnableAction{ServiceName: "nginx", Runlevel: "default"},
+		&actions.ServiceDisableAction{ServiceName: "crond", Runlevel: "default"},
+		&actions.FileCreateAction{Path: "/etc/motd"},
+		&actions.FileCreateAction{Path: "/etc/nginx/nginx.conf"},
+		&actions.FileCreateAction{Path: "/etc/conf.d/nginx"},
+		&actions.FileCreateAction{Path: "/etc/conf.d/crond"},
+		&actions.PackageInstallAction{PackageName: "nginx"},
+	}
+
+	var got []string
+	for _, action := range orderByWants(plan, desired) {
+		got = append(got, action.Description())
+	}
+	// nginx is enabled once its package and files are in place; disabling
+	// crond depends on nothing
+	want := []string{
+		plan[1].Description(),
+		plan[2].Description(),
+		plan[3].Description(),
+		plan[4].Description(),
+		plan[5].Description(),
+		plan[6].Description(),
+		plan[0].Description(),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("orderByWants() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCheckServiceNeeds(t *testing.T) {
+	desired := &model.SystemState{
+		Services: []model.ServiceState{
+			{Name: "nginx", Enabled: true, Runlevel: "default"},
+			{Name: "app", Enabled: true, Runlevel: "default"},
+			{Name: "php-fpm83", Enabled: false},
+			{Name: "redis", Enabled: true, Runlevel: "default"},
+		},
+		IgnoredServices: []string{"docker"},
+	}
+	current := &model.SystemState{
+		Services: []model.ServiceState{
+			{Name: "nginx"},
+			{Name: "app"},
+			{Name: "php-fpm83", Enabled: true, Runlevel: "default"},
+			{Name: "docker", Enabled: true, Runlevel: "default"},
+			{Name: "syslog"},
+		},
+	}
+	runner := &MockCommandRunner{Responses: map[string][]byte{
+		":rc-service nginx ineed": []byte("net localmount php-fpm83\n"),
+		":rc-service app ineed":   []byte("docker syslog\n"),
+	}}
+
+	// net is virtual, docker is ignored and stays enabled, and redis is not
+	// installed yet
+	got := checkServiceNeeds(desired, current, runner)
+	want := model.ValidationErrors{
+		{Field: "services", Message: "service nginx needs php-fpm83, which is not enabled"},
+		{Field: "services", Message: "service app needs syslog, which is not enabled"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkServiceNeeds() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRemoteUnsafe(t *testing.T) {
+	plan := []actions.Action{
+		&actions.ServiceDisableAction{ServiceName: "sshd", Runlevel: "default"},
+		&actions.ServiceDisableAction{ServiceName: "crond", Runlevel: "default"},
+		&actions.PackageRemoveAction{PackageName: "openssh-server"},
+		&actions.PackageRemoveAction{PackageName: "htop"},
+		&actions.FileUpdateAction{Path: "/etc/network/interfaces", NewContent: "auto eth0\n"},
+		&actions.FileCreateAction{Path: "/etc/iptables/rules-save", Content: "*filter\n:INPUT DROP [0:0]\nCOMMIT\n"},
+		&actions.FileCreateAction{Path: "/etc/iptables/rules6-save", Content: "*filter\n:INPUT ACCEPT [0:0]\nCOMMIT\n"},
+		&actions.FileUpdateAction{Path: "/etc/nftables.nft", NewContent: "chain input {\n  type filter hook input priority 0; policy drop;\n}\n"},
+		&actions.FileCreateAction{Path: "/etc/motd", Content: "policy drop\n"},
+		&actions.ServiceOptionsAction{Service: "sshd", Options: map[string]string{"command_args": "-p 2222"}},
+		&actions.ServiceOptionsAction{Service: "networking", Options: map[string]string{"cfgfile": "/etc/network/lab"}},
+		&actions.ServiceOptionsAction{Service: "nginx", Options: map[string]string{"cfgfile": "/etc/nginx/lab.conf"}},
+	}
+
+	var got []string
+	for _, finding := range Analyze(plan, RemoteUnsafe) {
+		got = append(got, finding.String())
+	}
+	want := []string{
+		plan[0].Description() + ": stops the SSH server",
+		plan[2].Description() + ": removes the SSH server",
+		plan[4].Description() + ": changes the network interfaces",
+		plan[5].Description() + ": sets a default-deny firewall policy",
+		plan[7].Description() + ": sets a default-deny firewall policy",
+		plan[9].Description() + ": restarts the SSH server with changed options",
+		plan[10].Description() + ": restarts the network with changed options",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RemoteUnsafe() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCalculatePlanRemovalLimits(t *testing.T) {
+	two, three := 2, 3
+	desired := &model.SystemState{Limits: &model.LimitsState{MaxPackageRemovals: &two, MaxFileDeletions: &three}}
+	current := &model.SystemState{Packages: []model.PackageState{{Name: "htop"}, {Name: "vim"}, {Name: "curl"}}}
+	runner := &MockCommandRunner{Responses: map[string][]byte{":sh -c 'cat /etc/group'": []byte("")}}
+
+	_, err := CalculatePlan(desired, current, runner, false)
+	errs, ok := err.(model.ValidationErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("Expected one limit error, got %v", err)
+	}
+	if errs[0].Field != "limits.max-package-removals" || !strings.Contains(errs[0].Message, "plan has 3 package removal(s), over the limit of 2") {
+		t.Errorf("Unexpected error: %+v", errs[0])
+	}
+
+	current.Packages = current.Packages[:2]
+	plan, err := CalculatePlan(desired, current, runner, false)
+	if err != nil {
+		t.Fatalf("Removals within the limit must plan, got %v", err)
+	}
+	if len(plan) != 2 {
+		t.Errorf("Expected 2 removals, got %+v", plan)
+	}
+}
+
+func TestCheckRemovalLimits(t *testing.T) {
+	zero, one := 0, 1
+	limits := &model.LimitsState{
+		MaxPackageRemovals: &one, MaxGroupRemovals: &zero, MaxUserPackageRemovals: &one,
+		MaxCronRemovals: &one, MaxKeyRemovals: &zero,
+	}
+	plan := []actions.Action{
+		&actions.VirtualPackageRemoveAction{Name: ".build-deps"},
+		&actions.GroupRemoveAction{GroupName: "stale"},
+		actions.UserPackageAction{User: "alice", Manager: "pipx", Package: "black", State: model.PackageStateAbsent}.Ptr(),
+		actions.UserPackageAction{User: "alice", Manager: "pipx", Package: "ruff", State: model.PackageStatePresent}.Ptr(),
+		&actions.CrontabAction{User: "alice", Current: "@daily a\n@daily b\n"},
+		&actions.AuthorizedKeyRemoveAction{User: "alice", Key: "ssh-ed25519 AAAA alice@old"},
+	}
+
+	var got []string
+	for _, err := range checkRemovalLimits(limits, plan) {
+		got = append(got, err.Field+": "+err.Message[:strings.Index(err.Message, ";")])
+	}
+	want := []string{
+		"limits.max-group-removals: plan has 1 group removal(s), over the limit of 0",
+		"limits.max-cron-removals: plan has 2 cron job removal(s), over the limit of 1",
+		"limits.max-key-removals: plan has 1 authorized key removal(s), over the limit of 0",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("checkRemovalLimits() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestCalculateGroupActions(t *testing.T) {
+	runner := &MockCommandRunner{
+		Responses: map[string][]byte{
+			":sh -c 'cat /etc/group'": []byte("root:x:0:\nwheel:x:10:\nalice:x:1000:\ndevs:x:1400:alice\nstale:x:1600:\nshared:x:1700:alice\nkeep:x:1800:carol\n"),
+		},
+		Errors: make(map[string]error),
+	}
+	desired := &model.SystemState{
+		Users:        []model.UserState{{Name: "alice", Groups: []string{"shared"}}},
+		Groups:       []model.GroupState{{Name: "devs", GID: 1500}, {Name: "docker", System: true}, {Name: "ops", GID: 1501}},
+		IgnoredUsers: []string{"carol"},
+	}
+	current := &model.SystemState{
+		Users: []model.UserState{{Name: "alice"}, {Name: "carol", Groups: []string{"keep"}}},
+		Groups: []model.GroupState{
+			{Name: "devs", GID: 1400}, {Name: "stale", GID: 1600}, {Name: "shared", GID: 1700}, {Name: "keep", GID: 1800},
+		},
+	}
+
+	var warnings model.ValidationErrors
+	creates, removes, err := calculateGroupActions(desired, current, runner, &warnings)
+	if err != nil {
+		t.Fatalf("calculateGroupActions failed: %v", err)
+	}
+	expectedCreates := []actions.Action{
+		&actions.GroupCreateAction{GroupName: "docker", System: true},
+		&actions.GroupCreateAction{GroupName: "ops", GID: 1501},
+	}
+	if !reflect.DeepEqual(creates, expectedCreates) {
+		t.Errorf("Creates not as expected:\nGot:      %+v\nExpected: %+v", creates, expectedCreates)
+	}
+	expectedRemoves := []actions.Action{&actions.GroupRemoveAction{GroupName: "stale", GID: 1600}}
+	if !reflect.DeepEqual(removes, expectedRemoves) {
+		t.Errorf("Removes not as expected:\nGot:      %+v\nExpected: %+v", removes, expectedRemoves)
+	}
+	if len(warnings) != 1 || warnings[0].Message != "group devs has gid 1400 instead of 1500; gids of existing groups are not changed" {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	// A gid taken by another group fails the plan
+	desired.Groups = []model.GroupState{{Name: "ops", GID: 1600}}
+	_, _, err = calculateGroupActions(desired, current, runner, &warnings)
+	if err == nil || !strings.Contains(err.Error(), "gid 1600 of group ops is already used by group stale") {
+		t.Errorf("Expected a gid collision error, got %v", err)
+	}
+
+	// Without a groups section no group is removed
+	desired.Groups = nil
+	creates, removes, err = calculateGroupActions(desired, current, runner, &warnings)
+	if err != nil || creates != nil || removes != nil {
+		t.Errorf("Expected no group actions, got %v %v %v", creates, removes, err)
+	}
+}
+
+func TestCalculatePlanAuthorizedKeys(t *testing.T) {
+	desired := []model.UserState{
+		{Name: "alice", AuthorizedKeys: []string{"ssh-ed25519 AAAAkeep alice@laptop", "ssh-ed25519 AAAAnew alice@phone"}},
+		{Name: "bob", AuthorizedKeys: []string{"ssh-rsa AAAAbob"}, PruneAuthorizedKeys: true},
+	}
+	current := []model.UserState{
+		{Name: "alice", AuthorizedKeys: []string{"ssh-ed25519 AAAAkeep renamed", "ssh-ed25519 AAAAold"}, UnmanagedKeys: []string{"ssh-rsa AAAAmine"}},
+		{Name: "bob", UnmanagedKeys: []string{"ssh-rsa AAAAbob", "ssh-rsa AAAAstale"}},
+	}
+
+	plan := calculateAuthorizedKeyActions(desired, current, nil)
+	expected := []actions.Action{
+		&actions.AuthorizedKeyAddAction{User: "alice", Key: "ssh-ed25519 AAAAnew alice@phone"},
+		&actions.AuthorizedKeyRemoveAction{User: "alice", Key: "ssh-ed25519 AAAAold"},
+		&actions.AuthorizedKeyRemoveAction{User: "bob", Key: "ssh-rsa AAAAstale"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+}
+
+func TestCalculateUserConfigActions(t *testing.T) {
+	desired := []model.UserConfigState{
+		{User: "alice", Path: ".vimrc", Content: "syntax on\n"},
+		{User: "alice", Path: ".profile", Content: "export EDITOR=vi\n"},
+		{User: "alice", Path: ".gitconfig", Content: "[user]\n", Mode: "0600"},
+		{User: "alice", Path: ".tmux.conf", Content: "set -g mouse on\n"},
+		{User: "ci-runner", Path: ".bashrc", Content: "set -e\n"},
+	}
+	current := []model.UserConfigState{
+		{User: "alice", Path: ".vimrc", Content: "syntax on\n", Mode: "0644", Owner: "alice", Group: "alice", PrimaryGroup: "alice"},
+		{User: "alice", Path: ".profile", Content: "export EDITOR=nano\n", Mode: "0644", Owner: "alice", Group: "alice", PrimaryGroup: "alice"},
+		{User: "alice", Path: ".gitconfig", Content: "[user]\n", Mode: "0644", Owner: "alice", Group: "alice", PrimaryGroup: "alice"},
+		{User: "alice", Path: ".ashrc", Content: "alias ll='ls -l'\n", Mode: "0644", Owner: "alice", Group: "alice", PrimaryGroup: "alice"},
+	}
+
+	var warnings model.ValidationErrors
+	plan := calculateUserConfigActions(desired, current, []string{"ci-*"}, &warnings)
+	expected := []actions.Action{
+		&actions.UserConfigAction{User: "alice", Path: ".profile", Content: "export EDITOR=vi\n", Current: "export EDITOR=nano\n", Exists: true},
+		&actions.UserConfigAction{User: "alice", Path: ".gitconfig", Content: "[user]\n", Mode: "0600", Current: "[user]\n", Exists: true},
+		&actions.UserConfigAction{User: "alice", Path: ".tmux.conf", Content: "set -g mouse on\n"},
+	}
+	if !reflect.DeepEqual(plan, expected) {
+		t.Errorf("Plan not as expected:\nGot:      %+v\nExpected: %+v", plan, expected)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Unexpected warnings: %v", warnings)
+	}
+
+	// A file owned by someone else is given back to the user
+	current[0].Owner = "root"
+	plan = calculateUserConfigActions(desired[:1], current[:1], nil, &warnings)
+	if len(plan) != 1 {
+		t.Errorf("Expected a chown of ~alice/.vimrc, got %+v", plan)
+	}
+}
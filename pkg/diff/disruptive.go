@@ -0,0 +1,106 @@
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"summit/pkg/actions"
+)
+
+// Finding is a concern a plan analyzer raises about one action of a plan.
+type Finding struct {
+	Action actions.Action
+	Reason string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s", f.Action.Description(), f.Reason)
+}
+
+// Analyzer inspects a plan before it is applied and reports the actions that
+// need attention.
+type Analyzer func(plan []actions.Action) []Finding
+
+// Analyze runs analyzers over a plan and returns their findings in order.
+func Analyze(plan []actions.Action, analyzers ...Analyzer) []Finding {
+	var findings []Finding
+	for _, analyzer := range analyzers {
+		findings = append(findings, analyzer(plan)...)
+	}
+	return findings
+}
+
+// Remote access services and the packages providing them.
+var (
+	remoteAccessServices = map[string]bool{"sshd": true, "dropbear": true}
+	remoteAccessPackages = map[string]bool{"openssh": true, "openssh-server": true, "openssh-server-pam": true, "dropbear": true}
+	networkServices      = map[string]bool{"networking": true, "network": true}
+)
+
+// firewallPaths are the rule files of the firewalls found on Alpine.
+var firewallPaths = []string{"/etc/iptables/*", "/etc/ip6tables/*", "/etc/nftables.nft", "/etc/nftables.conf", "/etc/nftables.d/*", "/etc/default/ufw", "/etc/ufw/*"}
+
+// defaultDeny matches an input policy that drops whatever no rule accepts,
+// in iptables-save, nftables and ufw syntax.
+var defaultDeny = regexp.MustCompile(`(?mi)^\s*(:INPUT\s+(DROP|REJECT)|-P\s+INPUT\s+(DROP|REJECT)|.*\bpolicy\s+drop\b|DEFAULT_INPUT_POLICY\s*=\s*"?(DROP|REJECT))`)
+
+// RemoteUnsafe reports the actions that could cut off the connection the
+// host is managed through: stopping or removing the SSH server, stopping the
+// network, changing the options either restarts with, changing the network
+// interfaces and firewall rules that deny incoming traffic by default.
+func RemoteUnsafe(plan []actions.Action) []Finding {
+	var findings []Finding
+	add := func(action actions.Action, reason string) {
+		findings = append(findings, Finding{Action: action, Reason: reason})
+	}
+	for _, action := range plan {
+		switch a := action.(type) {
+		case *actions.ServiceDisableAction:
+			checkStoppedService(a.ServiceName, action, add)
+		case *actions.ServiceStopAction:
+			checkStoppedService(a.ServiceName, action, add)
+		case *actions.PackageRemoveAction:
+			if remoteAccessPackages[a.PackageName] {
+				add(action, "removes the SSH server")
+			}
+		case *actions.FileCreateAction:
+			checkNetworkFile(a.Path, a.Content, action, add)
+		case *actions.FileUpdateAction:
+			checkNetworkFile(a.Path, a.NewContent, action, add)
+		case *actions.ServiceOptionsAction:
+			// Changed options restart the service with them
+			if remoteAccessServices[a.Service] {
+				add(action, "restarts the SSH server with changed options")
+			} else if networkServices[a.Service] {
+				add(action, "restarts the network with changed options")
+			}
+		case *actions.FileDeleteAction:
+			if a.Path == "/etc/network/interfaces" {
+				add(action, "changes the network interfaces")
+			}
+		case *actions.FileRevertAction:
+			if a.Path == "/etc/network/interfaces" {
+				add(action, "changes the network interfaces")
+			}
+		}
+	}
+	return findings
+}
+
+func checkStoppedService(service string, action actions.Action, add func(actions.Action, string)) {
+	if remoteAccessServices[service] {
+		add(action, "stops the SSH server")
+	} else if networkServices[service] {
+		add(action, "stops the network")
+	}
+}
+
+func checkNetworkFile(path, content string, action actions.Action, add func(actions.Action, string)) {
+	if path == "/etc/network/interfaces" || strings.HasPrefix(path, "/etc/network/interfaces.d/") {
+		add(action, "changes the network interfaces")
+		return
+	}
+	if matchesAnyGlob(firewallPaths, path) && defaultDeny.MatchString(content) {
+		add(action, "sets a default-deny firewall policy")
+	}
+}
@@ -0,0 +1,42 @@
+package diff
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"summit/pkg/actions"
+)
+
+// ansiEscape matches terminal color sequences that action details may contain.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// FormatGolden renders a plan as stable text suitable for committing as a
+// golden file. Plan order depends on map iteration, so entries are sorted, and
+// terminal escapes are stripped from details so the output is plain text.
+func FormatGolden(plan []actions.Action) string {
+	entries := make([]string, 0, len(plan))
+	for _, action := range plan {
+		var sb strings.Builder
+		sb.WriteString(action.Type())
+		sb.WriteString(": ")
+		sb.WriteString(action.Description())
+		sb.WriteString("\n")
+		for _, detail := range action.ExecutionDetails() {
+			detail = strings.TrimRight(ansiEscape.ReplaceAllString(detail, ""), "\n")
+			for _, line := range strings.Split(detail, "\n") {
+				sb.WriteString("    ")
+				sb.WriteString(line)
+				sb.WriteString("\n")
+			}
+		}
+		entries = append(entries, sb.String())
+	}
+	sort.Strings(entries)
+
+	var sb strings.Builder
+	sb.WriteString("# summit plan\n")
+	for _, entry := range entries {
+		sb.WriteString(entry)
+	}
+	return sb.String()
+}
@@ -0,0 +1,22 @@
+package diff
+
+import (
+	"testing"
+
+	"summit/pkg/actions"
+	"summit/pkg/test"
+)
+
+func TestFormatGolden_IsOrderIndependent(t *testing.T) {
+	plan := []actions.Action{
+		&actions.ServiceEnableAction{ServiceName: "nginx", Runlevel: "default"},
+		&actions.PackageInstallAction{PackageName: "nginx"},
+		&actions.FileUpdateAction{Path: "/etc/nginx/nginx.conf", NewContent: "worker_processes 2;\n"},
+	}
+	reversed := []actions.Action{plan[2], plan[1], plan[0]}
+
+	if FormatGolden(plan) != FormatGolden(reversed) {
+		t.Fatalf("golden output depends on plan order")
+	}
+	test.AssertGolden(t, "testdata/nginx_plan.golden", FormatGolden(plan))
+}
@@ -0,0 +1,169 @@
+package diff
+
+import (
+	"strings"
+	"summit/pkg/actions"
+	"summit/pkg/model"
+)
+
+// Team returns the team label of the desired resource an action converges.
+// Actions that remove or revert something the config does not declare, and
+// actions on unlabeled resources, have no team.
+func Team(action actions.Action, desired *model.SystemState) string {
+	switch a := action.(type) {
+	case *actions.PackageInstallAction:
+		for _, pkg := range desired.Packages {
+			if pkg.Name == a.PackageName {
+				return pkg.Team
+			}
+		}
+	case *actions.VirtualPackageInstallAction:
+		for _, v := range desired.VirtualPackages {
+			if v.Name == a.Name {
+				return v.Team
+			}
+		}
+	case *actions.ServiceEnableAction:
+		return serviceTeam(desired, a.ServiceName)
+	case *actions.ServiceDisableAction:
+		return serviceTeam(desired, a.ServiceName)
+	case *actions.ServiceMoveAction:
+		return serviceTeam(desired, a.ServiceName)
+	case *actions.ServiceStartAction:
+		return serviceTeam(desired, a.ServiceName)
+	case *actions.ServiceStopAction:
+		return serviceTeam(desired, a.ServiceName)
+	case *actions.ServiceRestartAction:
+		return serviceTeam(desired, a.ServiceName)
+	case *actions.UserCreateAction:
+		return userTeam(desired, a.UserName)
+	case *actions.UserModifyAction:
+		return userTeam(desired, a.UserName)
+	case *actions.GroupCreateAction:
+		for _, group := range desired.Groups {
+			if group.Name == a.GroupName {
+				return group.Team
+			}
+		}
+	case *actions.AddUserToGroupAction:
+		return userTeam(desired, a.UserName)
+	case *actions.RemoveUserFromGroupAction:
+		return userTeam(desired, a.UserName)
+	case *actions.CrontabAction:
+		return userTeam(desired, a.User)
+	case *actions.AuthorizedKeyAddAction:
+		return userTeam(desired, a.User)
+	case *actions.AuthorizedKeyRemoveAction:
+		return userTeam(desired, a.User)
+	case *actions.UserConfigAction:
+		for _, c := range desired.UserConfigs {
+			if c.User == a.User && c.Path == a.Path {
+				return c.Team
+			}
+		}
+	case *actions.UserPackageAction:
+		return userPackageTeam(desired, a.User)
+	case actions.UserPackageAction:
+		return userPackageTeam(desired, a.User)
+	case *actions.UserPackageBatchInstallAction:
+		return userPackageTeam(desired, a.User)
+	case *actions.FileCreateAction, *actions.FileUpdateAction, *actions.FileChmodAction, *actions.FileChownAction:
+		path := ResourceName(action)
+		for _, cfg := range desired.Configs {
+			if cfg.Path == path {
+				return cfg.Team
+			}
+		}
+	case *actions.ManagedBlockAction:
+		for _, b := range desired.ManagedBlocks {
+			if b.Path == a.Path && b.Name == a.Name {
+				return b.Team
+			}
+		}
+	case *actions.ServiceOptionsAction:
+		for _, o := range desired.ServiceOptions {
+			if o.Service == a.Service {
+				return o.Team
+			}
+		}
+	case *actions.CommandAction:
+		for _, c := range desired.Commands {
+			if c.Name == a.Name {
+				return c.Team
+			}
+		}
+	}
+	return ""
+}
+
+func serviceTeam(desired *model.SystemState, name string) string {
+	for _, svc := range desired.Services {
+		if svc.Name == name {
+			return svc.Team
+		}
+	}
+	return ""
+}
+
+func userTeam(desired *model.SystemState, name string) string {
+	for _, user := range desired.Users {
+		if user.Name == name {
+			return user.Team
+		}
+	}
+	return ""
+}
+
+func userPackageTeam(desired *model.SystemState, user string) string {
+	for _, up := range desired.UserPackages {
+		if up.User == user {
+			return up.Team
+		}
+	}
+	return ""
+}
+
+// FilterByTeam keeps the actions whose resource is labeled with team.
+func FilterByTeam(plan []actions.Action, desired *model.SystemState, team string) []actions.Action {
+	filtered := []actions.Action{}
+	for _, action := range plan {
+		if Team(action, desired) == team {
+			filtered = append(filtered, action)
+		}
+	}
+	return filtered
+}
+
+// RollbackGroups returns the groups an action belongs to when a failed apply
+// is rolled back by group: first the resource it changes and then, for config
+// files and services, the notify group of each service involved. A failed nginx config
+// update thus takes the other nginx configs and the nginx restart with it,
+// but not unrelated package installs.
+func RollbackGroups(action actions.Action, desired *model.SystemState) []string {
+	groups := []string{resourceGroup(action)}
+	switch a := action.(type) {
+	case *actions.ServiceEnableAction, *actions.ServiceDisableAction, *actions.ServiceMoveAction, *actions.ServiceStartAction, *actions.ServiceStopAction, *actions.ServiceRestartAction:
+		groups = append(groups, "notify:"+ResourceName(action))
+	case *actions.ServiceOptionsAction:
+		groups = append(groups, "notify:"+a.Service)
+	case *actions.FileCreateAction, *actions.FileUpdateAction, *actions.FileChmodAction, *actions.FileChownAction, *actions.FileRevertAction:
+		path := ResourceName(action)
+		for _, cfg := range desired.Configs {
+			if cfg.Path != path {
+				continue
+			}
+			for _, entry := range cfg.Notify {
+				_, svc, _ := model.ParseNotify(entry)
+				groups = append(groups, "notify:"+svc)
+			}
+		}
+	}
+	return groups
+}
+
+// resourceGroup identifies the resource an action changes, qualified by its
+// kind so a package and a service of the same name stay apart.
+func resourceGroup(action actions.Action) string {
+	kind, _, _ := strings.Cut(action.Type(), ".")
+	return kind + ":" + ResourceName(action)
+}
@@ -0,0 +1,63 @@
+package diff
+
+import (
+	"reflect"
+	"summit/pkg/actions"
+	"summit/pkg/model"
+	"testing"
+)
+
+func TestTeamAndFilterByTeam(t *testing.T) {
+	desired := &model.SystemState{
+		Packages: []model.PackageState{{Name: "nginx", Team: "web"}, {Name: "htop"}},
+		Services: []model.ServiceState{{Name: "nginx", Enabled: true, Runlevel: "default", Team: "web"}},
+		Users:    []model.UserState{{Name: "deploy", Team: "platform"}},
+		Configs:  []model.SystemConfigState{{Path: "/etc/nginx/nginx.conf", Team: "web"}},
+	}
+	plan := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "nginx"},
+		&actions.PackageInstallAction{PackageName: "htop"},
+		&actions.PackageRemoveAction{PackageName: "apache2"},
+		&actions.ServiceEnableAction{ServiceName: "nginx", Runlevel: "default"},
+		&actions.UserCreateAction{UserName: "deploy"},
+		&actions.AddUserToGroupAction{UserName: "deploy", GroupName: "wheel"},
+		&actions.FileUpdateAction{Path: "/etc/nginx/nginx.conf"},
+	}
+
+	want := []string{"web", "", "", "web", "platform", "platform", "web"}
+	for i, action := range plan {
+		if got := Team(action, desired); got != want[i] {
+			t.Errorf("Team(%s) = %q, want %q", action.Description(), got, want[i])
+		}
+	}
+
+	web := FilterByTeam(plan, desired, "web")
+	if len(web) != 3 {
+		t.Fatalf("FilterByTeam(web) returned %d actions, want 3", len(web))
+	}
+	for _, action := range web {
+		if Team(action, desired) != "web" {
+			t.Errorf("FilterByTeam(web) kept %s", action.Description())
+		}
+	}
+}
+
+func TestRollbackGroups(t *testing.T) {
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{{Path: "/etc/nginx/nginx.conf", Notify: []string{"nginx"}}},
+	}
+	tests := []struct {
+		action actions.Action
+		want   []string
+	}{
+		{&actions.PackageInstallAction{PackageName: "nginx"}, []string{"package:nginx"}},
+		{&actions.FileUpdateAction{Path: "/etc/nginx/nginx.conf"}, []string{"file:/etc/nginx/nginx.conf", "notify:nginx"}},
+		{&actions.FileChmodAction{Path: "/etc/motd"}, []string{"file:/etc/motd"}},
+		{&actions.ServiceRestartAction{ServiceName: "nginx"}, []string{"service:nginx", "notify:nginx"}},
+	}
+	for _, tt := range tests {
+		if got := RollbackGroups(tt.action, desired); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("RollbackGroups(%s) = %v, want %v", tt.action.Description(), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// checkServiceNeeds warns about the services a desired service needs, as its
+// init script lists them, that will not be enabled once the plan is applied:
+// OpenRC starts them along with the service anyway, so the service works only
+// as long as nothing stops them. Only needs that are init scripts on the
+// system are checked, since virtual ones such as net are provided by whichever
+// service provides them; services whose script is not installed yet are
+// skipped.
+func checkServiceNeeds(desired, current *model.SystemState, runner system.CommandRunner) model.ValidationErrors {
+	installed := make(map[string]bool)
+	enabled := make(map[string]bool)
+	for _, s := range current.Services {
+		installed[s.Name] = true
+		// Ignored services are left as they are, undeclared ones are disabled
+		if s.Enabled && matchesAnyGlob(desired.IgnoredServices, s.Name) {
+			enabled[s.Name] = true
+		}
+	}
+	for _, s := range desired.Services {
+		if s.Enabled {
+			enabled[s.Name] = true
+		}
+	}
+
+	var warnings model.ValidationErrors
+	for _, s := range desired.Services {
+		if !s.Enabled || !installed[s.Name] || matchesAnyGlob(desired.IgnoredServices, s.Name) {
+			continue
+		}
+		output, err := runner.Run("", fmt.Sprintf("rc-service %s ineed", s.Name))
+		if err != nil {
+			continue
+		}
+		for _, need := range strings.Fields(string(output)) {
+			if installed[need] && !enabled[need] {
+				warnings = append(warnings, model.ValidationError{Field: "services", Message: fmt.Sprintf("service %s needs %s, which is not enabled", s.Name, need)})
+			}
+		}
+	}
+	return warnings
+}
@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"summit/pkg/actions"
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// CheckOffline returns an error for every action of plan that would need the
+// network, for apply --offline: updating the package index, installing user
+// packages with pipx, npm, cargo, gem or uv, and installing packages the
+// offline source does not have. The packages are checked at once with apk add
+// --simulate, which resolves them from the offline source alone. Commands and
+// hooks are not checked: what they do is up to them.
+func CheckOffline(plan []actions.Action, runner system.CommandRunner) model.ValidationErrors {
+	apk, ok := system.Packages.(system.Apk)
+	if !ok || !apk.Offline {
+		return model.ValidationErrors{{Field: "offline", Message: fmt.Sprintf("%s cannot install packages without the network; --offline needs apk", system.Packages.Name())}}
+	}
+
+	var errs model.ValidationErrors
+	var packages []string
+	for _, action := range plan {
+		switch a := action.(type) {
+		case *actions.PackageIndexUpdateAction:
+			errs = append(errs, model.ValidationError{Field: "package-index-update", Message: "updating the package index needs the network; run with --package-index-update never"})
+		case *actions.PackageInstallAction:
+			if a.Version != "" {
+				packages = append(packages, a.PackageName+"="+a.Version)
+			} else {
+				packages = append(packages, a.PackageName)
+			}
+		case *actions.VirtualPackageInstallAction:
+			packages = append(packages, a.Packages...)
+		case *actions.UserPackageAction:
+			errs = append(errs, offlineUserPackage(*a)...)
+		case actions.UserPackageAction:
+			errs = append(errs, offlineUserPackage(a)...)
+		case *actions.UserPackageBatchInstallAction:
+			for _, pkg := range a.Packages {
+				errs = append(errs, offlineUserPackage(actions.UserPackageAction{User: a.User, Manager: a.Manager, Package: pkg, State: model.PackageStatePresent})...)
+			}
+		}
+	}
+
+	if len(packages) > 0 {
+		if out, err := runner.Run("", apk.AddCommand(append([]string{"--simulate"}, packages...)...)); err != nil {
+			message := fmt.Sprintf("packages %s are not all available in %s: %v", strings.Join(packages, ", "), apk.SourceName(), err)
+			if detail := strings.TrimSpace(string(out)); detail != "" {
+				message += ": " + detail
+			}
+			errs = append(errs, model.ValidationError{Field: "packages", Message: message})
+		}
+	}
+	return errs
+}
+
+// offlineUserPackage returns an error when a installs a user package, which
+// every user package manager downloads.
+func offlineUserPackage(a actions.UserPackageAction) model.ValidationErrors {
+	if a.State != model.PackageStatePresent {
+		return nil
+	}
+	return model.ValidationErrors{{Field: "user-packages", Message: fmt.Sprintf("installing %s package %s for %s needs the network", a.Manager, a.Package, a.User)}}
+}
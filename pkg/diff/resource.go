@@ -0,0 +1,471 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"summit/pkg/actions"
+	"summit/pkg/model"
+)
+
+// Resource is an item of a section the plan reconciles by key, such as a
+// package or a user. The model types cannot implement it themselves, as
+// pkg/actions depends on pkg/model, so each kind is adapted by a type below.
+type Resource[R any] interface {
+	// Key identifies the resource on both sides; ignore patterns match it.
+	Key() string
+	// Equal reports whether the current resource needs no update.
+	Equal(current R) bool
+	// CreateActions converge a resource missing from the system.
+	CreateActions() []actions.Action
+	// UpdateActions converge the current resource when it is not Equal.
+	UpdateActions(current R) []actions.Action
+	// DeleteActions remove a current resource the config does not declare.
+	DeleteActions() []actions.Action
+}
+
+// reconcileOptions are the section-wide rules reconcile applies.
+type reconcileOptions struct {
+	// Ignored are patterns of keys managed by other tooling: matching
+	// resources are dropped from both sides, so they are neither created
+	// nor removed.
+	Ignored []string
+	// Prune deletes the current resources the desired list lacks.
+	Prune bool
+}
+
+// reconcile compares desired and current resources by key and returns the
+// actions that create or update desired resources, in declaration order, and
+// separately the deletions, which callers usually plan after other changes
+// that may depend on the resource.
+func reconcile[R Resource[R]](desired, current []R, opts reconcileOptions) (changes, deletions []actions.Action) {
+	currentByKey := make(map[string]R)
+	for _, r := range current {
+		if !matchesAnyGlob(opts.Ignored, r.Key()) {
+			currentByKey[r.Key()] = r
+		}
+	}
+
+	declared := make(map[string]bool)
+	for _, r := range desired {
+		key := r.Key()
+		if declared[key] || matchesAnyGlob(opts.Ignored, key) {
+			continue
+		}
+		declared[key] = true
+		if have, ok := currentByKey[key]; !ok {
+			changes = append(changes, r.CreateActions()...)
+		} else if !r.Equal(have) {
+			changes = append(changes, r.UpdateActions(have)...)
+		}
+	}
+
+	if opts.Prune {
+		for _, r := range current {
+			key := r.Key()
+			if _, ok := currentByKey[key]; ok && !declared[key] {
+				declared[key] = true
+				deletions = append(deletions, r.DeleteActions()...)
+			}
+		}
+	}
+	return changes, deletions
+}
+
+// resources adapts a list of model items to their Resource type.
+func resources[T any, R Resource[R]](items []T, adapt func(T) R) []R {
+	result := make([]R, 0, len(items))
+	for _, item := range items {
+		result = append(result, adapt(item))
+	}
+	return result
+}
+
+// packageResource is a package installed explicitly, e.g. listed in the apk
+// world file. A package declared without a version accepts whatever version
+// is installed or pinned.
+type packageResource model.PackageState
+
+func (p packageResource) Key() string { return p.Name }
+
+func (p packageResource) Equal(current packageResource) bool {
+	return p.Version == "" || p.Version == current.Version
+}
+
+func (p packageResource) CreateActions() []actions.Action {
+	return []actions.Action{&actions.PackageInstallAction{PackageName: p.Name, Version: p.Version}}
+}
+
+func (p packageResource) UpdateActions(current packageResource) []actions.Action {
+	return []actions.Action{&actions.PackageInstallAction{PackageName: p.Name, Version: p.Version, Update: true, PreviousVersion: current.Version}}
+}
+
+func (p packageResource) DeleteActions() []actions.Action {
+	return []actions.Action{&actions.PackageRemoveAction{PackageName: p.Name}}
+}
+
+// virtualPackageResource is a virtual package grouping other packages, as
+// created by apk add --virtual.
+type virtualPackageResource model.VirtualPackageState
+
+func (v virtualPackageResource) Key() string { return v.Name }
+
+func (v virtualPackageResource) Equal(current virtualPackageResource) bool {
+	return model.VirtualPackageState(v).Groups(current.Packages)
+}
+
+func (v virtualPackageResource) CreateActions() []actions.Action {
+	return []actions.Action{&actions.VirtualPackageInstallAction{Name: v.Name, Packages: v.Packages}}
+}
+
+func (v virtualPackageResource) UpdateActions(current virtualPackageResource) []actions.Action {
+	return []actions.Action{&actions.VirtualPackageInstallAction{Name: v.Name, Packages: v.Packages, Update: true, PreviousPackages: current.Packages}}
+}
+
+func (v virtualPackageResource) DeleteActions() []actions.Action {
+	return []actions.Action{&actions.VirtualPackageRemoveAction{Name: v.Name, Packages: v.Packages}}
+}
+
+// serviceResource is an OpenRC service, enabled in a runlevel or not.
+type serviceResource model.ServiceState
+
+func (s serviceResource) Key() string { return s.Name }
+
+func (s serviceResource) Equal(current serviceResource) bool {
+	return len(s.UpdateActions(current)) == 0
+}
+
+// CreateActions converges a service that is not installed yet, and so not
+// running either.
+func (s serviceResource) CreateActions() []actions.Action {
+	stopped := false
+	return s.UpdateActions(serviceResource{Name: s.Name, Running: &stopped})
+}
+
+// UpdateActions enables or disables the service, which starts or stops it,
+// or moves it to the declared runlevels, and then starts or stops it as running
+// says when it is still not in that state. Services whose running state is
+// unknown are only enabled, disabled or moved.
+func (s serviceResource) UpdateActions(current serviceResource) []actions.Action {
+	var a []actions.Action
+	running := current.Running
+	switch {
+	case s.Enabled && current.Enabled && s.Runlevel != "" && current.Runlevel != "" && !sameRunlevels(s, current):
+		a = append(a, &actions.ServiceMoveAction{ServiceName: s.Name, From: model.ServiceState(current).Runlevels(), To: s.Runlevel, Also: s.ExtraRunlevels})
+	case s.Enabled && !current.Enabled:
+		a = append(a, &actions.ServiceEnableAction{ServiceName: s.Name, Runlevel: s.Runlevel, Healthcheck: s.Healthcheck})
+		if len(s.ExtraRunlevels) > 0 {
+			a = append(a, &actions.ServiceMoveAction{ServiceName: s.Name, From: []string{s.Runlevel}, To: s.Runlevel, Also: s.ExtraRunlevels})
+		}
+		started := true
+		running = &started
+	case !s.Enabled && current.Enabled:
+		a = append(a, current.DeleteActions()...)
+		stopped := false
+		running = &stopped
+	}
+	if s.Running == nil || running == nil || *s.Running == *running {
+		return a
+	}
+	if *s.Running {
+		return append(a, &actions.ServiceStartAction{ServiceName: s.Name, Healthcheck: s.Healthcheck})
+	}
+	return append(a, &actions.ServiceStopAction{ServiceName: s.Name})
+}
+
+// sameRunlevels reports whether both services are in the same runlevels, in
+// whatever order.
+func sameRunlevels(s, current serviceResource) bool {
+	want, have := model.ServiceState(s).Runlevels(), model.ServiceState(current).Runlevels()
+	slices.Sort(want)
+	slices.Sort(have)
+	return slices.Equal(want, have)
+}
+
+// DeleteActions disables the service in every runlevel it is in.
+func (s serviceResource) DeleteActions() []actions.Action {
+	if !s.Enabled {
+		return nil
+	}
+	a := []actions.Action{&actions.ServiceDisableAction{ServiceName: s.Name, Runlevel: s.Runlevel}}
+	for _, runlevel := range s.ExtraRunlevels {
+		a = append(a, &actions.ServiceDisableAction{ServiceName: s.Name, Runlevel: runlevel})
+	}
+	return a
+}
+
+// userResource is a user with its passwd fields and supplementary groups.
+type userResource model.UserState
+
+func (u userResource) Key() string { return u.Name }
+
+func (u userResource) Equal(current userResource) bool {
+	return len(u.UpdateActions(current)) == 0
+}
+
+func (u userResource) CreateActions() []actions.Action {
+	a := []actions.Action{&actions.UserCreateAction{
+		UserName: u.Name,
+		UID:      u.UID,
+		Shell:    u.Shell,
+		Home:     u.Home,
+		Gecos:    u.Gecos,
+	}}
+	for _, groupName := range u.Groups {
+		a = append(a, &actions.AddUserToGroupAction{UserName: u.Name, GroupName: groupName})
+	}
+	return a
+}
+
+func (u userResource) UpdateActions(current userResource) []actions.Action {
+	var a []actions.Action
+	if modify := userModifyAction(model.UserState(u), model.UserState(current)); modify != nil {
+		a = append(a, modify)
+	}
+
+	desiredGroups := make(map[string]bool)
+	for _, g := range u.Groups {
+		desiredGroups[g] = true
+	}
+	currentGroups := make(map[string]bool)
+	for _, g := range current.Groups {
+		currentGroups[g] = true
+	}
+	for _, groupName := range u.Groups {
+		if !currentGroups[groupName] {
+			currentGroups[groupName] = true
+			a = append(a, &actions.AddUserToGroupAction{UserName: u.Name, GroupName: groupName})
+		}
+	}
+	for _, groupName := range current.Groups {
+		// The primary group is not a membership that can be removed
+		if !desiredGroups[groupName] && groupName != current.PrimaryGroup {
+			desiredGroups[groupName] = true
+			a = append(a, &actions.RemoveUserFromGroupAction{UserName: u.Name, GroupName: groupName})
+		}
+	}
+	return a
+}
+
+func (u userResource) DeleteActions() []actions.Action {
+	return []actions.Action{&actions.UserRemoveAction{UserName: u.Name}}
+}
+
+// crontabResource is the summit-owned block of a user's crontab.
+type crontabResource model.UserState
+
+func (c crontabResource) Key() string { return c.Name }
+
+func (c crontabResource) Equal(current crontabResource) bool {
+	return model.RenderCrontab(c.Crontab) == model.RenderCrontab(current.Crontab)
+}
+
+func (c crontabResource) CreateActions() []actions.Action {
+	return c.UpdateActions(crontabResource{Name: c.Name})
+}
+
+func (c crontabResource) UpdateActions(current crontabResource) []actions.Action {
+	want, have := model.RenderCrontab(c.Crontab), model.RenderCrontab(current.Crontab)
+	if want == have {
+		return nil
+	}
+	return []actions.Action{&actions.CrontabAction{User: c.Name, Content: want, Current: have}}
+}
+
+func (c crontabResource) DeleteActions() []actions.Action { return nil }
+
+// authorizedKeysResource is a user's ~/.ssh/authorized_keys.
+type authorizedKeysResource model.UserState
+
+func (k authorizedKeysResource) Key() string { return k.Name }
+
+func (k authorizedKeysResource) Equal(current authorizedKeysResource) bool {
+	return len(k.UpdateActions(current)) == 0
+}
+
+func (k authorizedKeysResource) CreateActions() []actions.Action {
+	return k.UpdateActions(authorizedKeysResource{Name: k.Name})
+}
+
+// UpdateActions adds the desired keys the file lacks and removes the keys of
+// the summit-owned block the config no longer lists. Keys outside the block
+// are only removed when the user sets prune-authorized-keys.
+func (k authorizedKeysResource) UpdateActions(current authorizedKeysResource) []actions.Action {
+	wanted := make(map[string]bool)
+	for _, key := range k.AuthorizedKeys {
+		id, _ := model.AuthorizedKeyID(key)
+		wanted[id] = true
+	}
+	present := make(map[string]bool)
+	removals := current.AuthorizedKeys
+	if k.PruneAuthorizedKeys {
+		removals = append(append([]string{}, removals...), current.UnmanagedKeys...)
+	}
+	for _, key := range append(append([]string{}, current.AuthorizedKeys...), current.UnmanagedKeys...) {
+		id, _ := model.AuthorizedKeyID(key)
+		present[id] = true
+	}
+
+	var a []actions.Action
+	for _, key := range k.AuthorizedKeys {
+		id, _ := model.AuthorizedKeyID(key)
+		if !present[id] {
+			present[id] = true
+			a = append(a, &actions.AuthorizedKeyAddAction{User: k.Name, Key: key})
+		}
+	}
+	for _, key := range removals {
+		if id, ok := model.AuthorizedKeyID(key); ok && !wanted[id] {
+			// A removal drops every line holding the key
+			wanted[id] = true
+			a = append(a, &actions.AuthorizedKeyRemoveAction{User: k.Name, Key: key})
+		}
+	}
+	return a
+}
+
+func (k authorizedKeysResource) DeleteActions() []actions.Action { return nil }
+
+// configResource is a system config file. A declared config carries the
+// content it is written with, secrets expanded; a file on the system carries
+// the rules for the files the config does not declare.
+type configResource struct {
+	model.SystemConfigState
+	content    string
+	undeclared *undeclaredConfigs
+}
+
+// undeclaredConfigs decides what happens to the files on the system the
+// config does not declare, collecting the warnings about them.
+type undeclaredConfigs struct {
+	prune    bool
+	policy   model.ModifiedFilesPolicy
+	shared   map[string]bool // Files holding managed blocks or service options
+	warnings *model.ValidationErrors
+}
+
+func (c configResource) Key() string { return c.Path }
+
+func (c configResource) Equal(current configResource) bool {
+	return len(c.UpdateActions(current)) == 0
+}
+
+func (c configResource) CreateActions() []actions.Action {
+	return []actions.Action{&actions.FileCreateAction{Path: c.Path, Content: c.Content, Mode: c.Mode, Owner: c.Owner, Group: c.Group}}
+}
+
+// UpdateActions rewrites the content, mode and ownership that differ. An
+// empty mode, owner or group keeps the current one.
+func (c configResource) UpdateActions(current configResource) []actions.Action {
+	var a []actions.Action
+	if !current.ContentEquals(c.content) {
+		a = append(a, &actions.FileUpdateAction{Path: c.Path, NewContent: c.Content, Sensitive: c.Sensitive})
+	}
+	if modeDiffers(c.Mode, current.Mode) {
+		a = append(a, &actions.FileChmodAction{Path: c.Path, Mode: model.NormalizeMode(c.Mode)})
+	}
+	if ownershipDiffers(c.SystemConfigState, current.SystemConfigState) {
+		a = append(a, &actions.FileChownAction{Path: c.Path, Owner: c.Owner, Group: c.Group})
+	}
+	return a
+}
+
+// DeleteActions deletes a user-created file with pruning, or reverts a
+// package-modified one when the modified-files policy says so. Other files
+// are reported, and shared files are left alone.
+func (c configResource) DeleteActions() []actions.Action {
+	u := c.undeclared
+	if u == nil || u.shared[c.Path] {
+		return nil
+	}
+	switch c.Origin {
+	case model.OriginUserCreated:
+		if u.prune {
+			return []actions.Action{&actions.FileDeleteAction{Path: c.Path}}
+		}
+		*u.warnings = append(*u.warnings, model.ValidationError{Field: c.Path, Message: fmt.Sprintf(unmanagedFileWarning, c.Path)})
+	case model.OriginPackageModified:
+		switch modifiedFilePolicy(u.policy, c.Path) {
+		case model.ModifiedRevert:
+			return []actions.Action{&actions.FileRevertAction{Path: c.Path, OwnerPackage: c.OriginPackage}}
+		case model.ModifiedWarn:
+			*u.warnings = append(*u.warnings, model.ValidationError{Field: c.Path, Message: fmt.Sprintf(modifiedFileWarning, c.Path)})
+		}
+	}
+	return nil
+}
+
+// userConfigResource is a file in a user's home directory.
+type userConfigResource model.UserConfigState
+
+func (c userConfigResource) Key() string { return model.UserConfigState(c).Key() }
+
+// Equal compares the content the file is written with, secrets expanded, and
+// its mode and ownership, which default to the user and its primary group.
+func (c userConfigResource) Equal(current userConfigResource) bool {
+	content, err := actions.Secrets.Expand(c.Content)
+	if err != nil {
+		content = c.Content
+	}
+	owner, group := c.Owner, c.Group
+	if owner == "" {
+		owner = c.User
+	}
+	if group == "" {
+		group = current.PrimaryGroup
+	}
+	return content == current.Content && !modeDiffers(c.Mode, current.Mode) &&
+		sameID(owner, current.Owner, actions.Resolver.UID) &&
+		(group == "" || sameID(group, current.Group, actions.Resolver.GID))
+}
+
+func (c userConfigResource) CreateActions() []actions.Action {
+	return []actions.Action{c.action()}
+}
+
+func (c userConfigResource) UpdateActions(current userConfigResource) []actions.Action {
+	a := c.action()
+	a.Current, a.Exists = current.Content, true
+	return []actions.Action{a}
+}
+
+func (c userConfigResource) DeleteActions() []actions.Action { return nil }
+
+func (c userConfigResource) action() *actions.UserConfigAction {
+	return &actions.UserConfigAction{User: c.User, Path: c.Path, Content: c.Content, Mode: c.Mode, Owner: c.Owner, Group: c.Group, Sensitive: c.Sensitive}
+}
+
+// groupResource is a group of /etc/group.
+type groupResource model.GroupState
+
+func (g groupResource) Key() string                                  { return g.Name }
+func (g groupResource) Equal(groupResource) bool                     { return true } // gids of existing groups are not changed
+func (g groupResource) UpdateActions(groupResource) []actions.Action { return nil }
+
+func (g groupResource) CreateActions() []actions.Action {
+	return []actions.Action{&actions.GroupCreateAction{GroupName: g.Name, GID: g.GID, System: g.System}}
+}
+
+func (g groupResource) DeleteActions() []actions.Action {
+	return []actions.Action{&actions.GroupRemoveAction{GroupName: g.Name, GID: g.GID}}
+}
+
+// sysctlResource is a kernel parameter and its value.
+type sysctlResource struct {
+	Name, Value string
+}
+
+func (s sysctlResource) Key() string { return s.Name }
+
+func (s sysctlResource) Equal(current sysctlResource) bool {
+	return actions.SysctlValuesEqual(s.Value, current.Value)
+}
+
+func (s sysctlResource) CreateActions() []actions.Action {
+	return s.UpdateActions(sysctlResource{Name: s.Name})
+}
+
+func (s sysctlResource) UpdateActions(current sysctlResource) []actions.Action {
+	return []actions.Action{&actions.SysctlSetAction{Key: s.Name, Value: s.Value, Current: current.Value}}
+}
+
+func (s sysctlResource) DeleteActions() []actions.Action { return nil }
@@ -0,0 +1,189 @@
+package diff
+
+import (
+	"reflect"
+	"summit/pkg/actions"
+	"summit/pkg/model"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	desired := []packageResource{{Name: "curl"}, {Name: "vim"}, {Name: "curl"}, {Name: "linux-lts"}}
+	current := []packageResource{{Name: "vim"}, {Name: "nano"}, {Name: "linux-virt"}}
+
+	tests := []struct {
+		name          string
+		opts          reconcileOptions
+		wantChanges   []actions.Action
+		wantDeletions []actions.Action
+	}{
+		{
+			name:        "without prune nothing is deleted",
+			wantChanges: []actions.Action{&actions.PackageInstallAction{PackageName: "curl"}, &actions.PackageInstallAction{PackageName: "linux-lts"}},
+		},
+		{
+			name:          "prune deletes undeclared resources",
+			opts:          reconcileOptions{Prune: true},
+			wantChanges:   []actions.Action{&actions.PackageInstallAction{PackageName: "curl"}, &actions.PackageInstallAction{PackageName: "linux-lts"}},
+			wantDeletions: []actions.Action{&actions.PackageRemoveAction{PackageName: "nano"}, &actions.PackageRemoveAction{PackageName: "linux-virt"}},
+		},
+		{
+			name:          "ignored keys are dropped from both sides",
+			opts:          reconcileOptions{Prune: true, Ignored: []string{"linux-*"}},
+			wantChanges:   []actions.Action{&actions.PackageInstallAction{PackageName: "curl"}},
+			wantDeletions: []actions.Action{&actions.PackageRemoveAction{PackageName: "nano"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes, deletions := reconcile(desired, current, tt.opts)
+			if !reflect.DeepEqual(changes, tt.wantChanges) {
+				t.Errorf("changes = %v, want %v", changes, tt.wantChanges)
+			}
+			if !reflect.DeepEqual(deletions, tt.wantDeletions) {
+				t.Errorf("deletions = %v, want %v", deletions, tt.wantDeletions)
+			}
+		})
+	}
+}
+
+func TestCalculateServiceActions_Runlevels(t *testing.T) {
+	desired := []model.ServiceState{
+		{Name: "chronyd", Enabled: true, Runlevel: "default"},
+		{Name: "sshd", Enabled: true, Runlevel: "default"},
+		{Name: "acpid", Enabled: true, Runlevel: "default"},
+		{Name: "crond", Enabled: true, Runlevel: "default"},
+		{Name: "dbus"},
+	}
+	current := []model.ServiceState{
+		{Name: "chronyd", Enabled: true, Runlevel: "boot"},
+		{Name: "sshd", Enabled: true, Runlevel: "default"},
+		{Name: "acpid"},
+		{Name: "crond", Enabled: true, Runlevel: "boot", ExtraRunlevels: []string{"default"}},
+		{Name: "dbus", Enabled: true, Runlevel: "boot", ExtraRunlevels: []string{"default"}},
+	}
+
+	got := calculateServiceActions(desired, current, nil)
+	want := []actions.Action{
+		&actions.ServiceMoveAction{ServiceName: "chronyd", From: []string{"boot"}, To: "default"},
+		&actions.ServiceEnableAction{ServiceName: "acpid", Runlevel: "default"},
+		&actions.ServiceMoveAction{ServiceName: "crond", From: []string{"boot", "default"}, To: "default"},
+		&actions.ServiceDisableAction{ServiceName: "dbus", Runlevel: "boot"},
+		&actions.ServiceDisableAction{ServiceName: "dbus", Runlevel: "default"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
+
+func TestCalculateServiceActions_SeveralRunlevels(t *testing.T) {
+	desired := []model.ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "boot"},
+		{Name: "sshd", Enabled: true, Runlevel: "default"},
+		{Name: "crond", Enabled: true, Runlevel: "boot"},
+		{Name: "crond", Enabled: true, Runlevel: "default"},
+		{Name: "acpid", Enabled: true, Runlevel: "default"},
+		{Name: "acpid", Enabled: true, Runlevel: "nonetwork"},
+	}
+	current := []model.ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "boot", ExtraRunlevels: []string{"default"}},
+		{Name: "crond", Enabled: true, Runlevel: "default", ExtraRunlevels: []string{"nonetwork"}},
+		{Name: "acpid"},
+	}
+
+	// The declared runlevels are kept; only the others are removed
+	got := calculateServiceActions(desired, current, nil)
+	want := []actions.Action{
+		&actions.ServiceMoveAction{ServiceName: "crond", From: []string{"default", "nonetwork"}, To: "boot", Also: []string{"default"}},
+		&actions.ServiceEnableAction{ServiceName: "acpid", Runlevel: "default"},
+		&actions.ServiceMoveAction{ServiceName: "acpid", From: []string{"default"}, To: "default", Also: []string{"nonetwork"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
+
+func TestCalculateServiceActions_Running(t *testing.T) {
+	started, stopped := true, false
+	desired := []model.ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "default", Running: &started},
+		{Name: "crond", Enabled: true, Runlevel: "default", Running: &started},
+		{Name: "chronyd", Enabled: true, Runlevel: "default", Running: &stopped},
+		{Name: "nginx", Runlevel: "", Running: &started},
+		{Name: "redis", Enabled: true, Runlevel: "default", Running: &started},
+		{Name: "acpid", Enabled: true, Runlevel: "default"},
+	}
+	current := []model.ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "default", Running: &started},
+		{Name: "crond", Enabled: true, Runlevel: "default", Running: &stopped},
+		{Name: "chronyd", Running: &stopped},
+		{Name: "nginx", Running: &stopped},
+		{Name: "redis", Enabled: true, Runlevel: "default"},
+		{Name: "acpid", Enabled: true, Runlevel: "default", Running: &stopped},
+	}
+
+	got := calculateServiceActions(desired, current, nil)
+	want := []actions.Action{
+		&actions.ServiceStartAction{ServiceName: "crond"},
+		&actions.ServiceEnableAction{ServiceName: "chronyd", Runlevel: "default"},
+		&actions.ServiceStopAction{ServiceName: "chronyd"},
+		&actions.ServiceStartAction{ServiceName: "nginx"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
+
+func TestCalculatePackageActions_Versions(t *testing.T) {
+	desired := []model.PackageState{{Name: "curl", Version: "8.5.0-r0"}, {Name: "vim"}, {Name: "nginx", Version: "1.24.0-r7"}}
+	current := []model.PackageState{{Name: "vim", Version: "9.0-r0"}, {Name: "nginx", Version: "1.24.0-r6"}}
+
+	got := calculatePackageActions(desired, current, nil)
+	want := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "curl", Version: "8.5.0-r0"},
+		&actions.PackageInstallAction{PackageName: "nginx", Version: "1.24.0-r7", Update: true, PreviousVersion: "1.24.0-r6"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
+
+func TestCalculateConfigActions_Undeclared(t *testing.T) {
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/motd", Content: "hello"},
+			{Path: "/etc/issue", Content: "same", Mode: "0600"},
+			{Path: "/etc/app/local.conf", Content: "ignored"},
+		},
+		IgnoredConfigs: []string{"/etc/app/**"},
+		ManagedBlocks:  []model.ManagedBlockState{{Path: "/etc/hosts", Content: "10.0.0.1 db"}},
+	}
+	current := &model.SystemState{Configs: []model.SystemConfigState{
+		{Path: "/etc/issue", Content: "same", Mode: "0644", Origin: model.OriginPackageModified},
+		{Path: "/etc/stray.conf", Origin: model.OriginUserCreated},
+		{Path: "/etc/hosts", Origin: model.OriginUserCreated},
+		{Path: "/etc/app/state.conf", Origin: model.OriginUserCreated},
+	}}
+
+	var warnings model.ValidationErrors
+	plan := calculateConfigActions(desired, current, false, &warnings)
+	want := []actions.Action{
+		&actions.FileCreateAction{Path: "/etc/motd", Content: "hello"},
+		&actions.FileChmodAction{Path: "/etc/issue", Mode: "0600"},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("plan = %v, want %v", plan, want)
+	}
+	if len(warnings) != 1 || warnings[0].Field != "/etc/stray.conf" {
+		t.Errorf("expected a single unmanaged file warning for /etc/stray.conf, got %v", warnings)
+	}
+
+	warnings = nil
+	plan = calculateConfigActions(desired, current, true, &warnings)
+	if got := plan[len(plan)-1]; !reflect.DeepEqual(got, &actions.FileDeleteAction{Path: "/etc/stray.conf"}) || len(plan) != 3 {
+		t.Errorf("expected only /etc/stray.conf to be pruned, got %v", plan)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", warnings)
+	}
+}
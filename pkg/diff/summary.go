@@ -0,0 +1,154 @@
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"summit/pkg/actions"
+	"summit/pkg/model"
+)
+
+// SummaryEntry counts the actions of a single type and the resources they touch.
+type SummaryEntry struct {
+	Type      string   `json:"type"`
+	Count     int      `json:"count"`
+	Resources []string `json:"resources"`
+}
+
+// Summarize groups a plan by action type. Entries and their resources are
+// sorted so the summary is stable across runs.
+func Summarize(plan []actions.Action) []SummaryEntry {
+	byType := make(map[string]*SummaryEntry)
+	for _, action := range plan {
+		entry, ok := byType[action.Type()]
+		if !ok {
+			entry = &SummaryEntry{Type: action.Type()}
+			byType[action.Type()] = entry
+		}
+		entry.Count++
+		name := ResourceName(action)
+		if !containsString(entry.Resources, name) {
+			entry.Resources = append(entry.Resources, name)
+		}
+	}
+
+	summary := make([]SummaryEntry, 0, len(byType))
+	for _, entry := range byType {
+		sort.Strings(entry.Resources)
+		summary = append(summary, *entry)
+	}
+	sort.Slice(summary, func(i, j int) bool {
+		return summary[i].Type < summary[j].Type
+	})
+	return summary
+}
+
+// FormatSummary renders a plan as counts per action type followed by the
+// affected resource names, without any file contents.
+func FormatSummary(plan []actions.Action) string {
+	var sb strings.Builder
+	if len(plan) == 0 {
+		sb.WriteString("No changes.\n")
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "%d change(s):\n", len(plan))
+	for _, entry := range Summarize(plan) {
+		fmt.Fprintf(&sb, "  %s (%d): %s\n", entry.Type, entry.Count, strings.Join(entry.Resources, ", "))
+	}
+	return sb.String()
+}
+
+// ResourceName returns the name of the resource an action changes, such as a
+// package name or a file path.
+func ResourceName(action actions.Action) string {
+	switch a := action.(type) {
+	case *actions.PackageInstallAction:
+		return a.PackageName
+	case *actions.PackageRemoveAction:
+		return a.PackageName
+	case *actions.VirtualPackageInstallAction:
+		return a.Name
+	case *actions.VirtualPackageRemoveAction:
+		return a.Name
+	case *actions.PackageIndexUpdateAction:
+		return "index"
+	case *actions.ServiceEnableAction:
+		return a.ServiceName
+	case *actions.ServiceDisableAction:
+		return a.ServiceName
+	case *actions.ServiceMoveAction:
+		return a.ServiceName
+	case *actions.ServiceStartAction:
+		return a.ServiceName
+	case *actions.ServiceStopAction:
+		return a.ServiceName
+	case *actions.ServiceRestartAction:
+		return a.ServiceName
+	case *actions.UserCreateAction:
+		return a.UserName
+	case *actions.UserRemoveAction:
+		return a.UserName
+	case *actions.UserModifyAction:
+		return a.UserName
+	case *actions.GroupCreateAction:
+		return a.GroupName
+	case *actions.GroupRemoveAction:
+		return a.GroupName
+	case *actions.AddUserToGroupAction:
+		return a.UserName + ":" + a.GroupName
+	case *actions.RemoveUserFromGroupAction:
+		return a.UserName + ":" + a.GroupName
+	case *actions.CrontabAction:
+		return a.User
+	case *actions.AuthorizedKeyAddAction:
+		return a.User + ":" + model.AuthorizedKeyLabel(a.Key)
+	case *actions.AuthorizedKeyRemoveAction:
+		return a.User + ":" + model.AuthorizedKeyLabel(a.Key)
+	case *actions.UserConfigAction:
+		return a.User + ":" + a.Path
+	case *actions.UserPackageAction:
+		return a.User + "/" + a.Manager + "/" + a.Package
+	case actions.UserPackageAction:
+		return a.User + "/" + a.Manager + "/" + a.Package
+	case *actions.UserPackageBatchInstallAction:
+		return a.User + "/" + a.Manager + "/" + strings.Join(a.Packages, ",")
+	case *actions.FileCreateAction:
+		return a.Path
+	case *actions.FileUpdateAction:
+		return a.Path
+	case *actions.FileDeleteAction:
+		return a.Path
+	case *actions.FileRevertAction:
+		return a.Path
+	case *actions.FileChmodAction:
+		return a.Path
+	case *actions.FileChownAction:
+		return a.Path
+	case *actions.ManagedBlockAction:
+		if a.Name != "" {
+			return a.Path + ":" + a.Name
+		}
+		return a.Path
+	case *actions.ServiceOptionsAction:
+		return a.Path()
+	case *actions.CommandAction:
+		return a.Name
+	case *actions.SysctlSetAction:
+		return a.Key
+	case *actions.TimezoneSetAction:
+		return a.Zone
+	case *actions.PluginAction:
+		return a.Plugin + ":" + a.Change.ID
+	default:
+		return action.Description()
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
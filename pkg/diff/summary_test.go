@@ -0,0 +1,31 @@
+package diff
+
+import (
+	"summit/pkg/actions"
+	"summit/pkg/model"
+	"testing"
+)
+
+func TestFormatSummary(t *testing.T) {
+	plan := []actions.Action{
+		&actions.PackageInstallAction{PackageName: "vim"},
+		&actions.FileUpdateAction{Path: "/etc/motd", NewContent: "secret contents"},
+		&actions.PackageInstallAction{PackageName: "htop"},
+		&actions.UserPackageAction{User: "mino", Manager: "pipx", Package: "ruff", State: model.PackageStatePresent},
+		&actions.AddUserToGroupAction{UserName: "mino", GroupName: "wheel"},
+	}
+
+	expected := `5 change(s):
+  file.update (1): /etc/motd
+  package.install (2): htop, vim
+  user.group.add (1): mino:wheel
+  userpackage.ensure (1): mino/pipx/ruff
+`
+	if got := FormatSummary(plan); got != expected {
+		t.Errorf("FormatSummary() =\n%s\nwant:\n%s", got, expected)
+	}
+
+	if got := FormatSummary(nil); got != "No changes.\n" {
+		t.Errorf("FormatSummary(nil) = %q", got)
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"strings"
 	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
 )
 
 // ValidationError holds a list of dependency errors
@@ -38,25 +41,22 @@ func validateUserPackageDependencies(desired *model.SystemState) []string {
 		desiredSystemPackages[p.Name] = true
 	}
 
-	pipxPackages := []string{}
-	npmPackages := []string{}
+	for _, manager := range model.UserPackageManagers {
+		var packages []string
+		for _, userPackage := range desired.UserPackages {
+			packages = append(packages, *userPackage.ManagerPackages(manager)...)
+		}
 
-	for _, userPackage := range desired.UserPackages {
-		if len(userPackage.Pipx) > 0 {
-			pipxPackages = append(pipxPackages, userPackage.Pipx...)
+		// gem comes with ruby; the other managers are packaged under their name
+		required := manager
+		if manager == "gem" {
+			required = "ruby"
 		}
-		if len(userPackage.Npm) > 0 {
-			npmPackages = append(npmPackages, userPackage.Npm...)
+		if len(packages) > 0 && !desiredSystemPackages[required] {
+			errors = append(errors, fmt.Sprintf("user packages require '%s' to be installed for packages: %s. Add '%s' to the system packages list.", required, strings.Join(packages, ", "), required))
 		}
 	}
 
-	if len(pipxPackages) > 0 && !desiredSystemPackages["pipx"] {
-		errors = append(errors, fmt.Sprintf("user packages require 'pipx' to be installed for packages: %s. Add 'pipx' to the system packages list.", strings.Join(pipxPackages, ", ")))
-	}
-	if len(npmPackages) > 0 && !desiredSystemPackages["npm"] {
-		errors = append(errors, fmt.Sprintf("user packages require 'npm' to be installed for packages: %s. Add 'npm' to the system packages list.", strings.Join(npmPackages, ", ")))
-	}
-
 	return errors
 }
 
@@ -93,3 +93,54 @@ func validateUserDependencies(desired *model.SystemState, current *model.SystemS
 
 	return errors
 }
+
+// CollectWarnings returns non-fatal issues in the desired state, including
+// those that can only be detected by comparing against the current system.
+func CollectWarnings(desired *model.SystemState, current *model.SystemState, runner system.CommandRunner) model.ValidationErrors {
+	warnings := desired.Warnings()
+	if desired.PackageOwnedConfigs == "" || desired.PackageOwnedConfigs == model.PackageOwnedWarn {
+		warnings = append(warnings, PackageOwnedConflicts(desired, PackageOwnedConfigs(desired, current, runner))...)
+	}
+	return warnings
+}
+
+// PackageOwnedConfigs returns the owning package of every desired config path
+// that belongs to an installed package. Package-modified files are known from
+// inference; the other desired paths that exist on disk are looked up with apk.
+func PackageOwnedConfigs(desired *model.SystemState, current *model.SystemState, runner system.CommandRunner) map[string]string {
+	owners := make(map[string]string)
+	for _, c := range current.Configs {
+		if c.Origin == model.OriginPackageModified && c.OriginPackage != "" {
+			owners[c.Path] = c.OriginPackage
+		}
+	}
+
+	var unknown []string
+	for _, c := range desired.Configs {
+		if _, ok := owners[c.Path]; ok {
+			continue
+		}
+		if exists, _ := afero.Exists(system.AppFs, c.Path); exists {
+			unknown = append(unknown, c.Path)
+		}
+	}
+	if len(unknown) > 0 {
+		found, _ := system.PackageOwners(runner, unknown)
+		for path, pkg := range found {
+			owners[path] = pkg
+		}
+	}
+	return owners
+}
+
+// PackageOwnedConflicts returns one entry per config that overrides a
+// package-owned file without acknowledging it with overrides-package.
+func PackageOwnedConflicts(desired *model.SystemState, owners map[string]string) model.ValidationErrors {
+	var conflicts model.ValidationErrors
+	for i, c := range desired.Configs {
+		if owner, ok := owners[c.Path]; ok && !c.OverridesPackage {
+			conflicts = append(conflicts, model.ValidationError{Field: fmt.Sprintf("configs[%d].path", i), Message: fmt.Sprintf("file is owned by package '%s'; package upgrades may overwrite it. Set overrides-package: true if overriding it is deliberate", owner)})
+		}
+	}
+	return conflicts
+}
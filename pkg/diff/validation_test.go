@@ -60,6 +60,29 @@ func TestValidateDependencies_MissingUserPackageDeps(t *testing.T) {
 	assert.Contains(t, err.Error(), "user packages require 'pipx' to be installed")
 }
 
+func TestValidateDependencies_MissingCargo(t *testing.T) {
+	desired := &model.SystemState{
+		UserPackages: []model.UserPackageState{{User: "mino", Cargo: []string{"ripgrep"}}},
+	}
+	current := &model.SystemState{Users: []model.UserState{{Name: "mino"}}}
+
+	err := ValidateDependencies(desired, current)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "user packages require 'cargo' to be installed for packages: ripgrep")
+}
+
+func TestValidateDependencies_MissingRubyAndUv(t *testing.T) {
+	desired := &model.SystemState{
+		UserPackages: []model.UserPackageState{{User: "mino", Gem: []string{"rake"}, Uv: []string{"ruff"}}},
+	}
+	current := &model.SystemState{Users: []model.UserState{{Name: "mino"}}}
+
+	err := ValidateDependencies(desired, current)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "user packages require 'ruby' to be installed for packages: rake. Add 'ruby'")
+	assert.Contains(t, err.Error(), "user packages require 'uv' to be installed for packages: ruff")
+}
+
 func TestValidateDependencies_MissingService(t *testing.T) {
 	desired := &model.SystemState{
 		Services: []model.ServiceState{
@@ -115,3 +138,46 @@ func TestValidateDependencies_MultipleErrors(t *testing.T) {
 	assert.Contains(t, err.Error(), "service 'non-existent-service' not found")
 	assert.Contains(t, err.Error(), "user 'non-existent-user' not found for user-packages")
 }
+
+func TestCollectWarnings_PackageOwnedConfig(t *testing.T) {
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/ssh/sshd_config"},
+			{Path: "/etc/motd"},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/ssh/sshd_config", Origin: model.OriginPackageModified, OriginPackage: "openssh-server"},
+			{Path: "/etc/motd", Origin: model.OriginUserCreated},
+		},
+	}
+
+	warnings := CollectWarnings(desired, current, &MockCommandRunner{})
+
+	assert.Len(t, warnings, 1)
+	assert.Equal(t, "configs[0].path", warnings[0].Field)
+	assert.Contains(t, warnings[0].Message, "openssh-server")
+}
+
+func TestCollectWarnings_PackageOwnedConfigPolicy(t *testing.T) {
+	desired := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/ssh/sshd_config", OverridesPackage: true},
+			{Path: "/etc/nginx/nginx.conf"},
+		},
+	}
+	current := &model.SystemState{
+		Configs: []model.SystemConfigState{
+			{Path: "/etc/ssh/sshd_config", Origin: model.OriginPackageModified, OriginPackage: "openssh-server"},
+			{Path: "/etc/nginx/nginx.conf", Origin: model.OriginPackageModified, OriginPackage: "nginx"},
+		},
+	}
+
+	warnings := CollectWarnings(desired, current, &MockCommandRunner{})
+	assert.Len(t, warnings, 1, "acknowledged overrides are not reported")
+	assert.Equal(t, "configs[1].path", warnings[0].Field)
+
+	desired.PackageOwnedConfigs = model.PackageOwnedAllow
+	assert.Empty(t, CollectWarnings(desired, current, &MockCommandRunner{}))
+}
@@ -0,0 +1,155 @@
+package diff
+
+import (
+	"summit/pkg/actions"
+	"summit/pkg/model"
+)
+
+// orderByWants reorders a plan so the actions of a resource come after those
+// of the resources it wants, when both are in the plan. It is a stable
+// topological sort: actions keep their relative order unless a want moves
+// them, and wants forming a cycle are broken in plan order rather than
+// rejected, since they are soft. Services are also ordered after what they
+// depend on, as if they wanted it.
+func orderByWants(plan []actions.Action, desired *model.SystemState) []actions.Action {
+	wants := declaredWants(desired)
+	for key, deps := range serviceDependencies(desired) {
+		wants[key] = append(wants[key], deps...)
+	}
+	if len(wants) == 0 {
+		return plan
+	}
+
+	byKey := make(map[string][]int)
+	for i, action := range plan {
+		if key := wantKey(action); key != "" {
+			byKey[key] = append(byKey[key], i)
+		}
+	}
+	before := make([][]int, len(plan))
+	for i, action := range plan {
+		for _, want := range wants[wantKey(action)] {
+			for _, j := range byKey[want] {
+				if j != i {
+					before[i] = append(before[i], j)
+				}
+			}
+		}
+	}
+
+	placed := make([]bool, len(plan))
+	ready := func(i int) bool {
+		for _, j := range before[i] {
+			if !placed[j] {
+				return false
+			}
+		}
+		return true
+	}
+	ordered := make([]actions.Action, 0, len(plan))
+	for len(ordered) < len(plan) {
+		next := -1
+		for i := range plan {
+			if !placed[i] && ready(i) {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// Cycle: take the first remaining action
+			for i := range plan {
+				if !placed[i] {
+					next = i
+					break
+				}
+			}
+		}
+		placed[next] = true
+		ordered = append(ordered, plan[next])
+	}
+	return ordered
+}
+
+// declaredWants maps each desired resource, as "kind:name", to its wants.
+func declaredWants(desired *model.SystemState) map[string][]string {
+	wants := make(map[string][]string)
+	add := func(key string, list []string) {
+		if len(list) > 0 {
+			wants[key] = append(wants[key], list...)
+		}
+	}
+	for _, p := range desired.Packages {
+		add("package:"+p.Name, p.Wants)
+	}
+	for _, s := range desired.Services {
+		add("service:"+s.Name, s.Wants)
+	}
+	for _, u := range desired.Users {
+		add("user:"+u.Name, u.Wants)
+	}
+	for _, c := range desired.Configs {
+		add("config:"+c.Path, c.Wants)
+	}
+	return wants
+}
+
+// serviceDependencies maps each service the config enables or starts to the
+// resources that must be in place before it is: the declared packages, which
+// provide init scripts and binaries, the configs notifying it and its init
+// script and conf.d file when they are declared.
+func serviceDependencies(desired *model.SystemState) map[string][]string {
+	var packages []string
+	for _, p := range desired.Packages {
+		packages = append(packages, "package:"+p.Name)
+	}
+	deps := make(map[string][]string)
+	for _, s := range desired.Services {
+		if !s.Enabled && (s.Running == nil || !*s.Running) {
+			continue
+		}
+		key := "service:" + s.Name
+		deps[key] = append(deps[key], packages...)
+		for _, c := range desired.Configs {
+			if c.Path == "/etc/init.d/"+s.Name || c.Path == "/etc/conf.d/"+s.Name {
+				deps[key] = append(deps[key], "config:"+c.Path)
+				continue
+			}
+			for _, entry := range c.Notify {
+				if _, svc, _ := model.ParseNotify(entry); svc == s.Name {
+					deps[key] = append(deps[key], "config:"+c.Path)
+					break
+				}
+			}
+		}
+	}
+	return deps
+}
+
+// wantKey returns the resource an action converges in the form used by
+// wants, or "" for actions wants cannot refer to.
+func wantKey(action actions.Action) string {
+	switch a := action.(type) {
+	case *actions.PackageInstallAction, *actions.PackageRemoveAction, *actions.VirtualPackageInstallAction, *actions.VirtualPackageRemoveAction:
+		return "package:" + ResourceName(action)
+	case *actions.ServiceEnableAction, *actions.ServiceDisableAction, *actions.ServiceMoveAction, *actions.ServiceStartAction, *actions.ServiceStopAction:
+		return "service:" + ResourceName(action)
+	case *actions.ServiceOptionsAction:
+		return "service:" + a.Service
+	case *actions.UserCreateAction, *actions.UserRemoveAction, *actions.UserModifyAction, *actions.CrontabAction:
+		return "user:" + ResourceName(action)
+	case *actions.AddUserToGroupAction:
+		return "user:" + a.UserName
+	case *actions.RemoveUserFromGroupAction:
+		return "user:" + a.UserName
+	case *actions.AuthorizedKeyAddAction:
+		return "user:" + a.User
+	case *actions.AuthorizedKeyRemoveAction:
+		return "user:" + a.User
+	case *actions.UserConfigAction:
+		return "user:" + a.User
+	case *actions.FileCreateAction, *actions.FileUpdateAction, *actions.FileDeleteAction,
+		*actions.FileRevertAction, *actions.FileChmodAction, *actions.FileChownAction:
+		return "config:" + ResourceName(action)
+	}
+	return ""
+}
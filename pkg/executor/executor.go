@@ -0,0 +1,290 @@
+// Package executor applies a plan of actions. It is shared by the CLI and by
+// programs that embed summit as a library.
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"summit/pkg/actions"
+	"summit/pkg/log"
+	"summit/pkg/system"
+)
+
+// FailurePolicy decides what happens when an action fails.
+type FailurePolicy int
+
+const (
+	// RollbackOnFailure stops at the first failure and rolls back every action
+	// applied so far. It is the default.
+	RollbackOnFailure FailurePolicy = iota
+	// StopOnFailure stops at the first failure and keeps applied actions.
+	StopOnFailure
+	// ContinueOnFailure applies the remaining actions and reports all failures.
+	ContinueOnFailure
+)
+
+// parallelSafeTypes lists action types that may run concurrently with other
+// actions of the same type. Package and user tools take global locks, so only
+// file actions on distinct paths qualify.
+var parallelSafeTypes = map[string]bool{
+	"file.create": true,
+	"file.update": true,
+	"file.delete": true,
+	"file.chmod":  true,
+	"file.chown":  true,
+}
+
+// Hooks are called around each action. Any of them may be nil. With
+// Parallelism above one, BeforeAction and AfterAction can be called from
+// several goroutines at once.
+type Hooks struct {
+	BeforeAction   func(action actions.Action)
+	AfterAction    func(result Result)
+	BeforeRollback func(action actions.Action)
+}
+
+// Options configure an Executor.
+type Options struct {
+	// Parallelism bounds how many consecutive parallel-safe actions of the same
+	// type run at once. Values below two apply the plan sequentially.
+	Parallelism   int
+	FailurePolicy FailurePolicy
+	// RollbackGroups, when set, limits RollbackOnFailure to the applied actions
+	// that share a group with a failed action, so unrelated changes made
+	// earlier in the run are kept. Nil rolls back every applied action.
+	RollbackGroups func(action actions.Action) []string
+	Hooks          Hooks
+	// Progress is called after each action with the number of finished actions
+	// and the plan size. Calls are serialized.
+	Progress func(done, total int)
+}
+
+// Result is the outcome of a single action.
+type Result struct {
+	Action actions.Action
+	Status actions.Status
+	Err    error
+}
+
+// Report describes a whole run. Results are in plan order.
+type Report struct {
+	Results    []Result
+	Applied    []actions.Action // Actions that changed the system, in plan order
+	RolledBack bool
+}
+
+// Count returns how many actions finished with status.
+func (r *Report) Count(status actions.Status) int {
+	n := 0
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Executor applies plans. It holds no per-run state, so one Executor can run
+// several plans concurrently.
+type Executor struct {
+	runner  system.CommandRunner
+	logger  log.Logger
+	options Options
+}
+
+// New creates an Executor.
+func New(runner system.CommandRunner, logger log.Logger, options Options) *Executor {
+	return &Executor{runner: runner, logger: logger, options: options}
+}
+
+// Execute applies the plan. Actions whose outcome already holds are reported
+// as ok without being applied. The returned error joins every action failure.
+func (e *Executor) Execute(plan []actions.Action) (*Report, error) {
+	run := &run{
+		executor: e,
+		results:  make([]Result, len(plan)),
+		total:    len(plan),
+	}
+	for i := range plan {
+		run.results[i] = Result{Action: plan[i], Status: actions.StatusSkipped}
+	}
+
+	var errs []error
+	for start := 0; start < len(plan); {
+		end := e.batchEnd(plan, start)
+		batchErrs := run.applyBatch(plan, start, end)
+		errs = append(errs, batchErrs...)
+		start = end
+		if len(batchErrs) > 0 && e.options.FailurePolicy != ContinueOnFailure {
+			break
+		}
+	}
+
+	report := &Report{Results: run.results}
+	for _, result := range run.results {
+		if result.Status == actions.StatusChanged {
+			report.Applied = append(report.Applied, result.Action)
+		}
+	}
+
+	if len(errs) > 0 && e.options.FailurePolicy == RollbackOnFailure {
+		e.logger.Error("Action failed, rolling back changes", "error", errs[0])
+		e.Rollback(e.rollbackScope(run.results, report.Applied))
+		report.RolledBack = true
+	}
+	return report, errors.Join(errs...)
+}
+
+// Rollback undoes applied actions in reverse order. Rollback errors are logged
+// by the actions themselves and do not stop the remaining rollbacks.
+func (e *Executor) Rollback(applied []actions.Action) {
+	e.logger.Info("--- Starting Rollback ---")
+	for i := len(applied) - 1; i >= 0; i-- {
+		action := applied[i]
+		if e.options.Hooks.BeforeRollback != nil {
+			e.options.Hooks.BeforeRollback(action)
+		}
+		e.logger.Info(fmt.Sprintf("<= Rolling back: %s", action.Description()))
+		_ = action.Rollback(e.runner, e.logger)
+	}
+	e.logger.Info("--- Rollback Complete ---")
+}
+
+// rollbackScope returns the applied actions to roll back after a failure: all
+// of them, or those sharing a rollback group with a failed action.
+func (e *Executor) rollbackScope(results []Result, applied []actions.Action) []actions.Action {
+	if e.options.RollbackGroups == nil {
+		return applied
+	}
+	failedGroups := map[string]bool{}
+	for _, result := range results {
+		if result.Status == actions.StatusFailed {
+			for _, group := range e.options.RollbackGroups(result.Action) {
+				failedGroups[group] = true
+			}
+		}
+	}
+	var scoped []actions.Action
+	for _, action := range applied {
+		for _, group := range e.options.RollbackGroups(action) {
+			if failedGroups[group] {
+				scoped = append(scoped, action)
+				break
+			}
+		}
+	}
+	return scoped
+}
+
+// batchEnd returns the end of the batch starting at start: a run of
+// consecutive parallel-safe actions of the same type on distinct resources,
+// or a single action.
+func (e *Executor) batchEnd(plan []actions.Action, start int) int {
+	if e.options.Parallelism < 2 || !parallelSafeTypes[plan[start].Type()] {
+		return start + 1
+	}
+	seen := map[string]bool{}
+	end := start
+	for end < len(plan) && plan[end].Type() == plan[start].Type() {
+		key := plan[end].Description()
+		if seen[key] {
+			break
+		}
+		seen[key] = true
+		end++
+	}
+	return end
+}
+
+// run holds the state of a single Execute call.
+type run struct {
+	executor *Executor
+	results  []Result
+
+	mu    sync.Mutex
+	done  int
+	total int
+}
+
+func (r *run) applyBatch(plan []actions.Action, start, end int) []error {
+	if end-start == 1 {
+		if err := r.apply(start, plan[start]); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, r.executor.options.Parallelism)
+	errs := make([]error, end-start)
+	for i := start; i < end; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i-start] = r.apply(i, plan[i])
+		}(i)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// apply runs a single action and records its result at index i.
+func (r *run) apply(i int, action actions.Action) error {
+	e := r.executor
+	if e.options.Hooks.BeforeAction != nil {
+		e.options.Hooks.BeforeAction(action)
+	}
+	e.logger.Info(fmt.Sprintf("=> %s", action.Description()))
+
+	result := Result{Action: action, Status: actions.StatusChanged}
+	if e.check(action) {
+		result.Status = actions.StatusOK
+	} else if err := action.Apply(e.runner, e.logger); err != nil {
+		result.Status = actions.StatusFailed
+		result.Err = err
+	}
+	if result.Err != nil {
+		e.logger.Error(fmt.Sprintf("%s: %s", result.Status, action.Description()), "error", result.Err)
+	} else {
+		e.logger.Info(fmt.Sprintf("%s: %s", result.Status, action.Description()))
+	}
+
+	r.mu.Lock()
+	r.results[i] = result
+	r.done++
+	if e.options.Progress != nil {
+		e.options.Progress(r.done, r.total)
+	}
+	r.mu.Unlock()
+
+	if e.options.Hooks.AfterAction != nil {
+		e.options.Hooks.AfterAction(result)
+	}
+	return result.Err
+}
+
+// check reports whether an action's outcome already holds. Actions that cannot
+// be checked, or whose check fails, are treated as pending.
+func (e *Executor) check(action actions.Action) bool {
+	checker, ok := action.(actions.Checker)
+	if !ok {
+		return false
+	}
+	done, err := checker.Check(e.runner)
+	if err != nil {
+		e.logger.Debug("Could not check action", "action", action.Description(), "error", err)
+		return false
+	}
+	return done
+}
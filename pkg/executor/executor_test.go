@@ -0,0 +1,203 @@
+package executor
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"summit/pkg/actions"
+	"summit/pkg/log"
+	"summit/pkg/system"
+	"summit/pkg/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder collects the order in which fake actions are applied and rolled back.
+type recorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *recorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+type fakeAction struct {
+	name     string
+	kind     string
+	done     bool
+	err      error
+	delay    time.Duration
+	rec      *recorder
+	inFlight *int32
+	maxSeen  *int32
+}
+
+func (a *fakeAction) Type() string        { return a.kind }
+func (a *fakeAction) Description() string { return a.name }
+func (a *fakeAction) ExecutionDetails() []string {
+	return nil
+}
+func (a *fakeAction) RollbackDetails() []string {
+	return nil
+}
+func (a *fakeAction) Check(runner system.CommandRunner) (bool, error) { return a.done, nil }
+func (a *fakeAction) Apply(runner system.CommandRunner, logger log.Logger) error {
+	if a.inFlight != nil {
+		n := atomic.AddInt32(a.inFlight, 1)
+		for {
+			max := atomic.LoadInt32(a.maxSeen)
+			if n <= max || atomic.CompareAndSwapInt32(a.maxSeen, max, n) {
+				break
+			}
+		}
+		time.Sleep(a.delay)
+		atomic.AddInt32(a.inFlight, -1)
+	}
+	a.rec.add("apply " + a.name)
+	return a.err
+}
+func (a *fakeAction) Rollback(runner system.CommandRunner, logger log.Logger) error {
+	a.rec.add("rollback " + a.name)
+	return nil
+}
+
+func statuses(report *Report) []actions.Status {
+	var s []actions.Status
+	for _, r := range report.Results {
+		s = append(s, r.Status)
+	}
+	return s
+}
+
+func TestExecute_FailurePolicies(t *testing.T) {
+	boom := errors.New("boom")
+	newPlan := func(rec *recorder) []actions.Action {
+		return []actions.Action{
+			&fakeAction{name: "a", kind: "x", rec: rec},
+			&fakeAction{name: "b", kind: "x", rec: rec, done: true},
+			&fakeAction{name: "c", kind: "x", rec: rec, err: boom},
+			&fakeAction{name: "d", kind: "x", rec: rec},
+		}
+	}
+
+	tests := []struct {
+		policy     FailurePolicy
+		events     []string
+		statuses   []actions.Status
+		rolledBack bool
+	}{
+		{
+			policy:     RollbackOnFailure,
+			events:     []string{"apply a", "apply c", "rollback a"},
+			statuses:   []actions.Status{actions.StatusChanged, actions.StatusOK, actions.StatusFailed, actions.StatusSkipped},
+			rolledBack: true,
+		},
+		{
+			policy:   StopOnFailure,
+			events:   []string{"apply a", "apply c"},
+			statuses: []actions.Status{actions.StatusChanged, actions.StatusOK, actions.StatusFailed, actions.StatusSkipped},
+		},
+		{
+			policy:   ContinueOnFailure,
+			events:   []string{"apply a", "apply c", "apply d"},
+			statuses: []actions.Status{actions.StatusChanged, actions.StatusOK, actions.StatusFailed, actions.StatusChanged},
+		},
+	}
+
+	for _, tt := range tests {
+		rec := &recorder{}
+		e := New(test.NewMockCommandRunner(), test.NewMockLogger(slog.LevelDebug), Options{FailurePolicy: tt.policy})
+
+		report, err := e.Execute(newPlan(rec))
+
+		require.ErrorIs(t, err, boom)
+		assert.Equal(t, tt.events, rec.events)
+		assert.Equal(t, tt.statuses, statuses(report))
+		assert.Equal(t, tt.rolledBack, report.RolledBack)
+	}
+}
+
+func TestExecute_RollbackGroups(t *testing.T) {
+	rec := &recorder{}
+	plan := []actions.Action{
+		&fakeAction{name: "htop", kind: "package.install", rec: rec},
+		&fakeAction{name: "/etc/nginx/nginx.conf", kind: "file.update", rec: rec},
+		&fakeAction{name: "nginx", kind: "service.restart", rec: rec, err: errors.New("boom")},
+	}
+	groups := map[string][]string{"htop": {"htop"}, "/etc/nginx/nginx.conf": {"nginx"}, "nginx": {"nginx"}}
+
+	e := New(test.NewMockCommandRunner(), test.NewMockLogger(slog.LevelDebug), Options{
+		RollbackGroups: func(a actions.Action) []string { return groups[a.Description()] },
+	})
+	report, err := e.Execute(plan)
+	require.Error(t, err)
+
+	assert.Equal(t, []string{"apply htop", "apply /etc/nginx/nginx.conf", "apply nginx", "rollback /etc/nginx/nginx.conf"}, rec.events)
+	assert.True(t, report.RolledBack)
+	assert.Equal(t, plan[:2], report.Applied, "the report still lists every applied action")
+}
+
+func TestExecute_HooksAndProgress(t *testing.T) {
+	rec := &recorder{}
+	plan := []actions.Action{
+		&fakeAction{name: "a", kind: "x", rec: rec},
+		&fakeAction{name: "b", kind: "x", rec: rec, done: true},
+	}
+
+	var progress []int
+	e := New(test.NewMockCommandRunner(), test.NewMockLogger(slog.LevelDebug), Options{
+		Hooks: Hooks{
+			BeforeAction: func(a actions.Action) { rec.add("before " + a.Description()) },
+			AfterAction:  func(r Result) { rec.add("after " + r.Action.Description() + " " + string(r.Status)) },
+		},
+		Progress: func(done, total int) { progress = append(progress, done*10+total) },
+	})
+
+	report, err := e.Execute(plan)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"before a", "apply a", "after a changed", "before b", "after b ok"}, rec.events)
+	assert.Equal(t, []int{12, 22}, progress)
+	assert.Equal(t, []actions.Action{plan[0]}, report.Applied)
+	assert.Equal(t, 1, report.Count(actions.StatusOK))
+}
+
+func TestExecute_Parallelism(t *testing.T) {
+	rec := &recorder{}
+	var inFlight, maxSeen int32
+	var plan []actions.Action
+	for _, name := range []string{"/etc/a", "/etc/b", "/etc/c", "/etc/d"} {
+		plan = append(plan, &fakeAction{name: name, kind: "file.create", rec: rec, delay: 20 * time.Millisecond, inFlight: &inFlight, maxSeen: &maxSeen})
+	}
+	plan = append(plan, &fakeAction{name: "apk add htop", kind: "package.install", rec: rec})
+
+	e := New(test.NewMockCommandRunner(), test.NewMockLogger(slog.LevelDebug), Options{Parallelism: 2})
+	report, err := e.Execute(plan)
+	require.NoError(t, err)
+
+	assert.Equal(t, int32(2), maxSeen, "file actions should run two at a time")
+	assert.Equal(t, "apply apk add htop", rec.events[len(rec.events)-1], "later batches wait for earlier ones")
+	assert.Equal(t, plan[:4], report.Applied[:4], "results stay in plan order")
+}
+
+func TestExecute_SequentialByDefault(t *testing.T) {
+	rec := &recorder{}
+	var inFlight, maxSeen int32
+	plan := []actions.Action{
+		&fakeAction{name: "/etc/a", kind: "file.create", rec: rec, delay: time.Millisecond, inFlight: &inFlight, maxSeen: &maxSeen},
+		&fakeAction{name: "/etc/b", kind: "file.create", rec: rec, delay: time.Millisecond, inFlight: &inFlight, maxSeen: &maxSeen},
+	}
+
+	_, err := New(test.NewMockCommandRunner(), test.NewMockLogger(slog.LevelDebug), Options{}).Execute(plan)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), maxSeen)
+	assert.Equal(t, []string{"apply /etc/a", "apply /etc/b"}, rec.events)
+}
@@ -0,0 +1,157 @@
+// Package facts gathers the facts describing the host a config is applied
+// to. They are exposed to config templates as .Facts and decide which parts
+// of a config apply, so one config tree can serve a heterogeneous fleet.
+package facts
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// Gather collects the host facts. Facts that cannot be determined are left
+// empty rather than failing the run; a template that needs a missing fact
+// renders it as an empty string.
+func Gather(runner system.CommandRunner) model.Facts {
+	facts := model.Facts{
+		Hostname:       Hostname(),
+		AlpineVersion:  readTrimmed("/etc/alpine-release"),
+		MemoryMB:       gatherMemoryMB(),
+		Virtualization: gatherVirtualization(),
+	}
+
+	if out, err := runner.Run("", "apk --print-arch"); err == nil {
+		facts.Arch = strings.TrimSpace(string(out))
+	}
+
+	if out, err := runner.Run("", "ip -4 -o addr show scope global"); err == nil {
+		facts.IPv4Addresses = parseIPv4Addresses(string(out))
+		if len(facts.IPv4Addresses) > 0 {
+			facts.IPv4 = facts.IPv4Addresses[0]
+		}
+	}
+
+	return facts
+}
+
+// Hostname returns the short hostname from /etc/hostname, falling back to
+// the kernel's.
+func Hostname() string {
+	if name := readTrimmed("/etc/hostname"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
+
+func readTrimmed(path string) string {
+	content, err := afero.ReadFile(system.AppFs, path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// parseIPv4Addresses extracts addresses from `ip -4 -o addr` output, e.g.
+// "2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0".
+func parseIPv4Addresses(output string) []string {
+	var addrs []string
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		for i := 0; i+1 < len(fields); i++ {
+			if fields[i] == "inet" {
+				addr, _, _ := strings.Cut(fields[i+1], "/")
+				addrs = append(addrs, addr)
+				break
+			}
+		}
+	}
+	return addrs
+}
+
+// gatherMemoryMB returns the total memory in MiB from the MemTotal line of
+// /proc/meminfo, e.g. "MemTotal:        2035456 kB".
+func gatherMemoryMB() int {
+	f, err := system.AppFs.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return 0
+			}
+			return kb / 1024
+		}
+	}
+	return 0
+}
+
+// dmiVirtualization maps strings found in the DMI vendor or product name to
+// the hypervisor they identify.
+var dmiVirtualization = []struct {
+	match, virtualization string
+}{
+	{"QEMU", "kvm"},
+	{"KVM", "kvm"},
+	{"Amazon EC2", "kvm"},
+	{"Google Compute Engine", "kvm"},
+	{"VMware", "vmware"},
+	{"VirtualBox", "virtualbox"},
+	{"innotek", "virtualbox"},
+	{"Virtual Machine", "hyperv"}, // Microsoft Corporation's product name
+	{"Xen", "xen"},
+	{"Parallels", "parallels"},
+	{"BHYVE", "bhyve"},
+}
+
+// gatherVirtualization tells what the host runs on: a container runtime such
+// as "docker" or "lxc", a hypervisor such as "kvm" or "vmware", "vm" for an
+// unidentified hypervisor, or "none" on bare metal.
+func gatherVirtualization() string {
+	if exists, _ := afero.Exists(system.AppFs, "/.dockerenv"); exists {
+		return "docker"
+	}
+	if exists, _ := afero.Exists(system.AppFs, "/run/.containerenv"); exists {
+		return "podman"
+	}
+	if environ, err := afero.ReadFile(system.AppFs, "/proc/1/environ"); err == nil {
+		for _, env := range strings.Split(string(environ), "\x00") {
+			if runtime, ok := strings.CutPrefix(env, "container="); ok && runtime != "" {
+				return runtime
+			}
+		}
+	}
+
+	dmi := readTrimmed("/sys/class/dmi/id/sys_vendor") + " " + readTrimmed("/sys/class/dmi/id/product_name")
+	for _, v := range dmiVirtualization {
+		if strings.Contains(dmi, v.match) {
+			return v.virtualization
+		}
+	}
+
+	cpuinfo, err := afero.ReadFile(system.AppFs, "/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(cpuinfo), "\n") {
+		if name, flags, ok := strings.Cut(line, ":"); ok && strings.TrimSpace(name) == "flags" {
+			for _, flag := range strings.Fields(flags) {
+				if flag == "hypervisor" {
+					return "vm"
+				}
+			}
+		}
+	}
+	return "none"
+}
@@ -0,0 +1,63 @@
+package facts
+
+import (
+	"testing"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+	"summit/pkg/test"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGather(t *testing.T) {
+	system.AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/hostname", []byte("web1\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/etc/alpine-release", []byte("3.20.3\n"), 0644))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/proc/meminfo", []byte("MemTotal:        2035456 kB\nMemFree:          123456 kB\n"), 0444))
+	require.NoError(t, afero.WriteFile(system.AppFs, "/sys/class/dmi/id/sys_vendor", []byte("QEMU\n"), 0444))
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk --print-arch", []byte("aarch64\n"))
+	runner.SetResponse("", "ip -4 -o addr show scope global", []byte(
+		"2: eth0    inet 10.0.0.5/24 brd 10.0.0.255 scope global eth0\\       valid_lft forever preferred_lft forever\n"+
+			"3: wg0    inet 10.8.0.1/24 scope global wg0\\       valid_lft forever preferred_lft forever\n"))
+
+	facts := Gather(runner)
+
+	assert.Equal(t, model.Facts{
+		Hostname:       "web1",
+		IPv4:           "10.0.0.5",
+		IPv4Addresses:  []string{"10.0.0.5", "10.8.0.1"},
+		Arch:           "aarch64",
+		AlpineVersion:  "3.20.3",
+		MemoryMB:       1987,
+		Virtualization: "kvm",
+	}, facts)
+}
+
+func TestGatherVirtualization(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"unknown without /proc", nil, ""},
+		{"bare metal", map[string]string{"/proc/cpuinfo": "flags\t\t: fpu vme de pse\n", "/sys/class/dmi/id/sys_vendor": "Dell Inc.\n"}, "none"},
+		{"docker", map[string]string{"/.dockerenv": "", "/sys/class/dmi/id/sys_vendor": "QEMU\n"}, "docker"},
+		{"lxc", map[string]string{"/proc/1/environ": "PATH=/bin\x00container=lxc\x00"}, "lxc"},
+		{"vmware", map[string]string{"/sys/class/dmi/id/sys_vendor": "VMware, Inc.\n"}, "vmware"},
+		{"hyper-v", map[string]string{"/sys/class/dmi/id/sys_vendor": "Microsoft Corporation\n", "/sys/class/dmi/id/product_name": "Virtual Machine\n"}, "hyperv"},
+		{"unidentified hypervisor", map[string]string{"/proc/cpuinfo": "flags\t\t: fpu vme hypervisor\n"}, "vm"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			system.AppFs = afero.NewMemMapFs()
+			for path, content := range tt.files {
+				require.NoError(t, afero.WriteFile(system.AppFs, path, []byte(content), 0444))
+			}
+			assert.Equal(t, tt.want, gatherVirtualization())
+		})
+	}
+}
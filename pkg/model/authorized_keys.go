@@ -0,0 +1,61 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthorizedKeyID identifies the public key on an authorized_keys line by its
+// type and base64 blob, so the same key matches whatever its options and
+// comment. It returns false for lines holding no key.
+func AuthorizedKeyID(line string) (string, bool) {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if i+1 < len(fields) && isAuthorizedKeyType(field) {
+			return field + " " + fields[i+1], true
+		}
+	}
+	return "", false
+}
+
+// AuthorizedKeyLabel returns a short name for a key line for plan output: its
+// comment, or the key type and the end of the blob when it has none.
+func AuthorizedKeyLabel(line string) string {
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		if i+1 < len(fields) && isAuthorizedKeyType(field) {
+			if i+2 < len(fields) {
+				return strings.Join(fields[i+2:], " ")
+			}
+			blob := fields[i+1]
+			return fmt.Sprintf("%s ...%s", field, blob[max(0, len(blob)-12):])
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func isAuthorizedKeyType(field string) bool {
+	return strings.HasPrefix(field, "ssh-") || strings.HasPrefix(field, "ecdsa-") || strings.HasPrefix(field, "sk-")
+}
+
+// ParseAuthorizedKeys splits an authorized_keys file into the keys in the
+// summit-owned block and the keys outside it.
+func ParseAuthorizedKeys(content string) (managed, unmanaged []string) {
+	markers := BlockMarkers("", "")
+	inBlock := false
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == markers[0]:
+			inBlock = true
+		case line == markers[1]:
+			inBlock = false
+		case line == "" || strings.HasPrefix(line, "#"):
+		case inBlock:
+			managed = append(managed, line)
+		default:
+			unmanaged = append(unmanaged, line)
+		}
+	}
+	return managed, unmanaged
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultCommandTimeout is how long a command may run when it does not set a
+// timeout.
+const DefaultCommandTimeout = 5 * time.Minute
+
+// CommandState is a shell command apply runs, an escape hatch for changes no
+// resource covers yet. Creates and Unless keep it from running again once its
+// work is done: it only runs while the Creates path does not exist and the
+// Unless command fails. A command with neither guard runs on every apply.
+type CommandState struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command"`
+	User    string `yaml:"user,omitempty"`    // Runs the command and Unless as this user, root by default
+	Creates string `yaml:"creates,omitempty"` // Absolute path the command creates; it does not run once the path exists
+	Unless  string `yaml:"unless,omitempty"`  // Command exiting 0 when the command need not run
+	Timeout string `yaml:"timeout,omitempty"` // Duration like 30s or 2m, default 5m
+	Team    string `yaml:"team,omitempty"`
+}
+
+// TimeoutDuration returns the timeout, or the default when it is not set or
+// invalid.
+func (c CommandState) TimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(c.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultCommandTimeout
+}
+
+// validateCommands checks the commands section: every command has a unique
+// name and a command, creates is absolute, the timeout a duration and neither
+// the command nor unless holds ${env:VAR} references.
+func validateCommands(commands []CommandState) ValidationErrors {
+	var errs ValidationErrors
+	seen := make(map[string]bool)
+	for i, c := range commands {
+		field := fmt.Sprintf("commands[%d]", i)
+		if strings.TrimSpace(c.Name) == "" || strings.ContainsAny(c.Name, "\n\x00") {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: "command name cannot be empty or span lines"})
+		} else if seen[c.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("command '%s' is declared more than once", c.Name)})
+		}
+		seen[c.Name] = true
+		if strings.TrimSpace(c.Command) == "" {
+			errs = append(errs, ValidationError{Field: field + ".command", Message: "command cannot be empty"})
+		}
+		for _, shell := range []struct{ key, command string }{{"command", c.Command}, {"unless", c.Unless}} {
+			if hasEnvRef(shell.command) {
+				errs = append(errs, ValidationError{Field: field + "." + shell.key, Message: envRefInCommand})
+			}
+		}
+		if c.User != "" && !isValidUserName(c.User) {
+			errs = append(errs, ValidationError{Field: field + ".user", Message: fmt.Sprintf("invalid user name '%s'", c.User)})
+		}
+		if c.Creates != "" && !filepath.IsAbs(c.Creates) {
+			errs = append(errs, ValidationError{Field: field + ".creates", Message: "path must be absolute (start with '/')"})
+		}
+		if c.Timeout != "" {
+			if d, err := time.ParseDuration(c.Timeout); err != nil || d <= 0 {
+				errs = append(errs, ValidationError{Field: field + ".timeout", Message: fmt.Sprintf("invalid timeout '%s', must be a duration like 30s or 2m", c.Timeout)})
+			}
+		}
+	}
+	return errs
+}
+
+// envRefInCommand is the error for a ${env:VAR} reference in a shell command.
+// References are only expanded in file contents; commands run with summit's
+// environment and read the variable themselves.
+const envRefInCommand = "${env:VAR} references are only expanded in file contents, use $VAR in commands"
+
+// hasEnvRef reports whether command holds a ${env:VAR} reference.
+func hasEnvRef(command string) bool {
+	return strings.Contains(command, "${env:")
+}
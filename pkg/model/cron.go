@@ -0,0 +1,129 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"summit/pkg/schedule"
+)
+
+// CronJobState is an entry in a user's crontab. Summit owns the jobs between
+// its BEGIN and END markers in the crontab; entries outside them are left alone.
+type CronJobState struct {
+	Name     string `yaml:"name,omitempty"` // Written as a comment line above the job
+	Schedule string `yaml:"schedule"`       // Five cron fields, or a shortcut such as @daily
+	Command  string `yaml:"command"`
+}
+
+// cronShortcuts are the @-schedules understood by busybox crond.
+var cronShortcuts = map[string]bool{
+	"@reboot":   true,
+	"@yearly":   true,
+	"@annually": true,
+	"@monthly":  true,
+	"@weekly":   true,
+	"@daily":    true,
+	"@midnight": true,
+	"@hourly":   true,
+}
+
+// BlockMarkers returns the BEGIN and END lines delimiting a summit-owned block
+// in a shared file. An empty comment defaults to "#".
+func BlockMarkers(comment, name string) [2]string {
+	if comment == "" {
+		comment = "#"
+	}
+	suffix := ""
+	if name != "" {
+		suffix = " " + name
+	}
+	return [2]string{comment + " BEGIN summit" + suffix, comment + " END summit" + suffix}
+}
+
+// Line returns the job as a crontab line.
+func (j CronJobState) Line() string {
+	return strings.Join(strings.Fields(j.Schedule), " ") + " " + strings.TrimSpace(j.Command)
+}
+
+// RenderCrontab returns the lines summit writes between its markers for jobs.
+// Named jobs are preceded by a "# name" comment so they can be inferred back.
+func RenderCrontab(jobs []CronJobState) string {
+	var sb strings.Builder
+	for _, job := range jobs {
+		if job.Name != "" {
+			sb.WriteString("# " + job.Name + "\n")
+		}
+		sb.WriteString(job.Line() + "\n")
+	}
+	return sb.String()
+}
+
+// ParseCrontab returns the jobs in the summit-owned block of a crontab, or nil
+// when it has none.
+func ParseCrontab(crontab string) []CronJobState {
+	markers := BlockMarkers("", "")
+	var jobs []CronJobState
+	inBlock := false
+	name := ""
+	for _, line := range strings.Split(crontab, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == markers[0]:
+			inBlock = true
+		case line == markers[1]:
+			return jobs
+		case !inBlock || line == "":
+		case strings.HasPrefix(line, "#"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+		default:
+			fields := 5
+			if strings.HasPrefix(line, "@") {
+				fields = 1
+			}
+			schedule, command := splitFields(line, fields)
+			jobs = append(jobs, CronJobState{Name: name, Schedule: schedule, Command: command})
+			name = ""
+		}
+	}
+	return nil
+}
+
+// splitFields returns the first n whitespace-separated fields of line joined
+// by single spaces, and the rest of the line as is.
+func splitFields(line string, n int) (string, string) {
+	var fields []string
+	rest := line
+	for len(fields) < n && rest != "" {
+		i := strings.IndexAny(rest, " \t")
+		if i < 0 {
+			fields = append(fields, rest)
+			rest = ""
+			break
+		}
+		fields = append(fields, rest[:i])
+		rest = strings.TrimLeft(rest[i:], " \t")
+	}
+	return strings.Join(fields, " "), rest
+}
+
+// validateCronJob returns the problems with a crontab entry declared at field.
+func validateCronJob(field string, job CronJobState) ValidationErrors {
+	var errs ValidationErrors
+	if strings.HasPrefix(job.Schedule, "@") {
+		if !cronShortcuts[job.Schedule] {
+			errs = append(errs, ValidationError{Field: field + ".schedule", Message: fmt.Sprintf("unknown schedule shortcut '%s'", job.Schedule)})
+		}
+	} else if _, err := schedule.Parse(job.Schedule); err != nil {
+		errs = append(errs, ValidationError{Field: field + ".schedule", Message: err.Error()})
+	}
+	if strings.TrimSpace(job.Command) == "" {
+		errs = append(errs, ValidationError{Field: field + ".command", Message: "command cannot be empty"})
+	}
+	if strings.ContainsAny(job.Command+job.Name, "\n") {
+		errs = append(errs, ValidationError{Field: field, Message: "name and command must be a single line"})
+	}
+	if strings.Contains(job.Name, "summit") && (strings.Contains(job.Name, "BEGIN") || strings.Contains(job.Name, "END")) {
+		errs = append(errs, ValidationError{Field: field + ".name", Message: "name cannot look like a summit block marker"})
+	}
+	return errs
+}
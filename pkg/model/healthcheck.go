@@ -0,0 +1,87 @@
+package model
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHealthcheckTimeout is how long a started service has to become
+// healthy when its healthcheck does not set a timeout.
+const DefaultHealthcheckTimeout = 30 * time.Second
+
+// HealthcheckState tells when a started service is up. Exactly one of TCP,
+// Command or HTTP must be set; the check is polled until it passes or the
+// timeout runs out.
+type HealthcheckState struct {
+	TCP     string `yaml:"tcp,omitempty"`     // Port on localhost, or host:port, that must accept connections
+	Command string `yaml:"command,omitempty"` // Command that must exit 0
+	HTTP    string `yaml:"http,omitempty"`    // URL that must answer with a 2xx status
+	Timeout string `yaml:"timeout,omitempty"` // Duration like 30s or 2m, default 30s
+}
+
+// Address returns the address the TCP check connects to.
+func (h HealthcheckState) Address() string {
+	if _, err := strconv.Atoi(h.TCP); err == nil {
+		return net.JoinHostPort("127.0.0.1", h.TCP)
+	}
+	return h.TCP
+}
+
+// TimeoutDuration returns the timeout, or the default when it is not set or
+// invalid.
+func (h HealthcheckState) TimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(h.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultHealthcheckTimeout
+}
+
+// Label describes the check, e.g. "tcp 127.0.0.1:8080".
+func (h HealthcheckState) Label() string {
+	switch {
+	case h.TCP != "":
+		return "tcp " + h.Address()
+	case h.Command != "":
+		return "command " + h.Command
+	default:
+		return "http " + h.HTTP
+	}
+}
+
+func validateHealthcheck(field string, h *HealthcheckState) ValidationErrors {
+	if h == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	checks := 0
+	for _, check := range []string{h.TCP, h.Command, h.HTTP} {
+		if strings.TrimSpace(check) != "" {
+			checks++
+		}
+	}
+	if checks != 1 {
+		errs = append(errs, ValidationError{Field: field, Message: "healthcheck must set exactly one of tcp, command or http"})
+	}
+	if h.TCP != "" {
+		if _, port, err := net.SplitHostPort(h.Address()); err != nil || !isValidPort(port) {
+			errs = append(errs, ValidationError{Field: field + ".tcp", Message: fmt.Sprintf("invalid address '%s', must be a port like 8080 or host:port", h.TCP)})
+		}
+	}
+	if h.HTTP != "" && !strings.HasPrefix(h.HTTP, "http://") && !strings.HasPrefix(h.HTTP, "https://") {
+		errs = append(errs, ValidationError{Field: field + ".http", Message: "URL must start with http:// or https://"})
+	}
+	if h.Timeout != "" {
+		if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+			errs = append(errs, ValidationError{Field: field + ".timeout", Message: fmt.Sprintf("invalid timeout '%s', must be a duration like 30s or 2m", h.Timeout)})
+		}
+	}
+	return errs
+}
+
+func isValidPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HooksState lists shell commands apply runs around the changes of a plan,
+// such as quiescing a service or silencing monitoring for the change window.
+// Hooks only run when the plan changes something.
+type HooksState struct {
+	PreApply  []string `yaml:"pre-apply,omitempty"`  // Run in order before the first change; a failing hook aborts the apply
+	PostApply []string `yaml:"post-apply,omitempty"` // Run after the changes were applied, the assertions passed and a canary was confirmed
+	OnFailure []string `yaml:"on-failure,omitempty"` // Run when a pre-apply hook, a change or an assertion fails, or a canary is rolled back
+}
+
+// validateHooks checks that no hook is empty or references the environment
+// as a config would.
+func validateHooks(field string, h *HooksState) ValidationErrors {
+	if h == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	for _, stage := range []struct {
+		name     string
+		commands []string
+	}{{"pre-apply", h.PreApply}, {"post-apply", h.PostApply}, {"on-failure", h.OnFailure}} {
+		for i, command := range stage.commands {
+			if strings.TrimSpace(command) == "" {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.%s[%d]", field, stage.name, i), Message: "hook command cannot be empty"})
+			} else if hasEnvRef(command) {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.%s[%d]", field, stage.name, i), Message: envRefInCommand})
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of intrinsic ignore rules.
+const (
+	IgnoreExact      = "exact"       // Pattern is a full path
+	IgnorePrefix     = "prefix"      // Pattern is a directory; everything below it matches
+	IgnoreNamePrefix = "name-prefix" // Pattern is a file; paths extending its name, like /etc/shadow.lock, match too
+	IgnoreSuffix     = "suffix"      // Pattern is a file name suffix
+	IgnorePattern    = "pattern"     // User-supplied path, directory or glob
+)
+
+// IntrinsicIgnore is a rule for files summit never manages, for security or
+// safety reasons, regardless of what the config says.
+type IntrinsicIgnore struct {
+	Pattern string `yaml:"pattern" json:"pattern"`
+	Kind    string `yaml:"kind" json:"kind"`
+	Reason  string `yaml:"reason" json:"reason"`
+}
+
+// builtinIntrinsicIgnores are the safety-critical rules. They can be extended
+// with intrinsic-ignores in the config but never removed.
+var builtinIntrinsicIgnores = []IntrinsicIgnore{
+	{Pattern: "/etc/runlevels", Kind: IgnorePrefix, Reason: "runlevel files"}, // Managed through services
+	{Pattern: "/etc/localtime", Kind: IgnoreExact, Reason: "/etc/localtime"},  // Link to the zone, managed through timezone
+	{Pattern: "-", Kind: IgnoreSuffix, Reason: "backup file"},
+	{Pattern: ".bak", Kind: IgnoreSuffix, Reason: "backup file"},
+	{Pattern: "/etc/passwd", Kind: IgnoreNamePrefix, Reason: "/etc/passwd"},       // User database, managed by system/user tools
+	{Pattern: "/etc/group", Kind: IgnoreNamePrefix, Reason: "/etc/group"},         // Group database, managed by system/user tools
+	{Pattern: "/etc/shadow", Kind: IgnoreNamePrefix, Reason: "/etc/shadow"},       // Shadow password file, managed by system/user tools
+	{Pattern: "/etc/apk/world", Kind: IgnoreNamePrefix, Reason: "/etc/apk/world"}, // APK's list of installed packages, managed by apk
+	{Pattern: "/etc/apk/keys", Kind: IgnorePrefix, Reason: "/etc/apk/keys"},       // APK's trusted keys directory, managed by apk
+	{Pattern: "/etc/apk/arch", Kind: IgnoreNamePrefix, Reason: "/etc/apk/arch"},   // System architecture for APK, set by Alpine installation
+	{Pattern: "/etc/apk/protected_paths.d/ca-certificates.list", Kind: IgnoreExact, Reason: "/etc/apk/protected_paths.d/ca-certificates.list"},
+}
+
+// IntrinsicIgnores returns the built-in rules followed by one rule per extra
+// pattern from the config.
+func IntrinsicIgnores(extra []string) []IntrinsicIgnore {
+	rules := make([]IntrinsicIgnore, 0, len(builtinIntrinsicIgnores)+len(extra))
+	rules = append(rules, builtinIntrinsicIgnores...)
+	for _, pattern := range extra {
+		rules = append(rules, IntrinsicIgnore{Pattern: pattern, Kind: IgnorePattern, Reason: pattern})
+	}
+	return rules
+}
+
+// MatchIntrinsicIgnore returns the first rule that matches path.
+func MatchIntrinsicIgnore(path string, extra []string) (IntrinsicIgnore, bool) {
+	for _, rule := range IntrinsicIgnores(extra) {
+		if rule.Matches(path) {
+			return rule, true
+		}
+	}
+	return IntrinsicIgnore{}, false
+}
+
+// Matches reports whether the rule applies to path.
+func (r IntrinsicIgnore) Matches(path string) bool {
+	switch r.Kind {
+	case IgnoreExact:
+		return path == r.Pattern
+	case IgnorePrefix:
+		return path == r.Pattern || strings.HasPrefix(path, r.Pattern+"/")
+	case IgnoreNamePrefix:
+		return strings.HasPrefix(path, r.Pattern)
+	case IgnoreSuffix:
+		return strings.HasSuffix(path, r.Pattern)
+	case IgnorePattern:
+		dir := strings.TrimSuffix(r.Pattern, "/")
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return true
+		}
+		matched, _ := filepath.Match(r.Pattern, path)
+		return matched
+	}
+	return false
+}
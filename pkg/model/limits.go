@@ -0,0 +1,219 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default guardrails on the content inlined in configs and managed blocks.
+const (
+	DefaultMaxFileSize  ByteSize = 1 << 20
+	DefaultMaxTotalSize ByteSize = 16 << 20
+)
+
+// ByteSize is a size in bytes. In YAML it is a plain number or a number with
+// a K, M or G suffix (powers of 1024), e.g. "512K" or "2MiB".
+type ByteSize int64
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*b = size
+	return nil
+}
+
+// byteUnits are the size suffixes, longest first so "MiB" is not read as "B".
+var byteUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+}
+
+// ParseByteSize parses a size like "1048576", "512K" or "2MiB".
+func ParseByteSize(s string) (ByteSize, error) {
+	number, multiplier := strings.TrimSpace(s), ByteSize(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(number), unit.suffix) {
+			number, multiplier = strings.TrimSpace(number[:len(number)-len(unit.suffix)]), unit.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size '%s', must be a number of bytes with an optional K, M or G suffix", s)
+	}
+	return ByteSize(n) * multiplier, nil
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteUnits[6:] {
+		if b < unit.size {
+			continue
+		}
+		if b%unit.size == 0 {
+			return fmt.Sprintf("%d%s", b/unit.size, unit.suffix)
+		}
+		return fmt.Sprintf("%.1f%s", float64(b)/float64(unit.size), unit.suffix)
+	}
+	return fmt.Sprintf("%d bytes", int64(b))
+}
+
+// LimitsState bounds the content inlined in the config, so adopting the
+// wrong glob does not stuff megabytes or binaries into the YAML, and the
+// removals a plan may make, so a bad include merge cannot wipe a machine.
+// Unset sizes take the defaults; unset removal counts are unlimited.
+type LimitsState struct {
+	MaxFileSize  ByteSize `yaml:"max-file-size,omitempty"`  // Per config or managed block
+	MaxTotalSize ByteSize `yaml:"max-total-size,omitempty"` // All configs and managed blocks together
+	AllowBinary  bool     `yaml:"allow-binary,omitempty"`   // Accept content with NUL bytes or invalid UTF-8
+
+	MaxPackageRemovals     *int `yaml:"max-package-removals,omitempty"`
+	MaxServiceDisables     *int `yaml:"max-service-disables,omitempty"`
+	MaxUserRemovals        *int `yaml:"max-user-removals,omitempty"`
+	MaxFileDeletions       *int `yaml:"max-file-deletions,omitempty"`
+	MaxGroupRemovals       *int `yaml:"max-group-removals,omitempty"`
+	MaxUserPackageRemovals *int `yaml:"max-user-package-removals,omitempty"`
+	MaxCronRemovals        *int `yaml:"max-cron-removals,omitempty"` // Lines removed from summit's crontab blocks
+	MaxKeyRemovals         *int `yaml:"max-key-removals,omitempty"`  // Authorized keys
+}
+
+// Keys of the removal limits, which removal actions count against.
+const (
+	LimitPackageRemovals     = "max-package-removals"
+	LimitServiceDisables     = "max-service-disables"
+	LimitUserRemovals        = "max-user-removals"
+	LimitFileDeletions       = "max-file-deletions"
+	LimitGroupRemovals       = "max-group-removals"
+	LimitUserPackageRemovals = "max-user-package-removals"
+	LimitCronRemovals        = "max-cron-removals"
+	LimitKeyRemovals         = "max-key-removals"
+)
+
+// RemovalLimit is a bound on one kind of removal in a plan.
+type RemovalLimit struct {
+	Key  string // Name of the setting under limits
+	Noun string // What is removed, e.g. "package removal(s)"
+	Max  int
+}
+
+// RemovalLimits returns the removal bounds the config sets.
+func (l *LimitsState) RemovalLimits() []RemovalLimit {
+	if l == nil {
+		return nil
+	}
+	var limits []RemovalLimit
+	for _, limit := range []struct {
+		key, noun string
+		max       *int
+	}{
+		{LimitPackageRemovals, "package removal(s)", l.MaxPackageRemovals},
+		{LimitServiceDisables, "service disable(s)", l.MaxServiceDisables},
+		{LimitUserRemovals, "user removal(s)", l.MaxUserRemovals},
+		{LimitFileDeletions, "file deletion(s)", l.MaxFileDeletions},
+		{LimitGroupRemovals, "group removal(s)", l.MaxGroupRemovals},
+		{LimitUserPackageRemovals, "user package removal(s)", l.MaxUserPackageRemovals},
+		{LimitCronRemovals, "cron job removal(s)", l.MaxCronRemovals},
+		{LimitKeyRemovals, "authorized key removal(s)", l.MaxKeyRemovals},
+	} {
+		if limit.max != nil {
+			limits = append(limits, RemovalLimit{Key: limit.key, Noun: limit.noun, Max: *limit.max})
+		}
+	}
+	return limits
+}
+
+func (l *LimitsState) maxFileSize() ByteSize {
+	if l == nil || l.MaxFileSize == 0 {
+		return DefaultMaxFileSize
+	}
+	return l.MaxFileSize
+}
+
+func (l *LimitsState) maxTotalSize() ByteSize {
+	if l == nil || l.MaxTotalSize == 0 {
+		return DefaultMaxTotalSize
+	}
+	return l.MaxTotalSize
+}
+
+// CheckContent returns an error when content is too large or looks binary
+// to be inlined in the config.
+func (l *LimitsState) CheckContent(content string) error {
+	if size := ByteSize(len(content)); size > l.maxFileSize() {
+		return fmt.Errorf("content is %s, over the %s limit (limits.max-file-size); keep large files out of the config", size, l.maxFileSize())
+	}
+	if (l == nil || !l.AllowBinary) && IsBinary(content) {
+		return fmt.Errorf("content looks binary (NUL bytes or invalid UTF-8); keep binary files out of the config or set limits.allow-binary: true")
+	}
+	return nil
+}
+
+// CheckTotal returns an error when total, the size of all inlined content,
+// is over the limit.
+func (l *LimitsState) CheckTotal(total int) error {
+	if size := ByteSize(total); size > l.maxTotalSize() {
+		return fmt.Errorf("inlined content totals %s, over the %s limit (limits.max-total-size); keep large files out of the config", size, l.maxTotalSize())
+	}
+	return nil
+}
+
+// IsBinary reports whether content looks like binary data rather than text.
+func IsBinary(content string) bool {
+	return strings.IndexByte(content, 0) >= 0 || !utf8.ValidString(content)
+}
+
+// InlinedSize returns the bytes of content inlined in configs, user configs
+// and managed blocks. Configs read from a source file are not inlined.
+func (s *SystemState) InlinedSize() int {
+	total := 0
+	for _, c := range s.Configs {
+		if c.Source == "" {
+			total += len(c.Content)
+		}
+	}
+	for _, c := range s.UserConfigs {
+		total += len(c.Content)
+	}
+	for _, b := range s.ManagedBlocks {
+		total += len(b.Content)
+	}
+	return total
+}
+
+// validateLimits checks the inlined content against the config's limits.
+// The limits keep large and binary files out of config files, where source
+// is the way to declare them.
+func (s *SystemState) validateLimits() ValidationErrors {
+	var errs ValidationErrors
+	for i, c := range s.Configs {
+		if c.Source != "" {
+			continue
+		}
+		if err := s.Limits.CheckContent(c.Content); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].content", i), Message: err.Error()})
+		}
+	}
+	for i, b := range s.ManagedBlocks {
+		if err := s.Limits.CheckContent(b.Content); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("managed-blocks[%d].content", i), Message: err.Error()})
+		}
+	}
+	if err := s.Limits.CheckTotal(s.InlinedSize()); err != nil {
+		errs = append(errs, ValidationError{Field: "configs", Message: err.Error()})
+	}
+	for _, limit := range s.Limits.RemovalLimits() {
+		if limit.Max < 0 {
+			errs = append(errs, ValidationError{Field: "limits." + limit.Key, Message: "limit cannot be negative"})
+		}
+	}
+	return errs
+}
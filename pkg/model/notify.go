@@ -0,0 +1,49 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Ways a config notifies a service of a change.
+const (
+	NotifyRestart = "restart"
+	NotifyReload  = "reload"
+)
+
+// NotifyList is the services a config notifies when it changes. A single
+// entry may be written as a bare scalar, as in notify: restart sshd.
+type NotifyList []string
+
+func (n *NotifyList) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*n = NotifyList{value.Value}
+		return nil
+	}
+	var list []string
+	if err := value.Decode(&list); err != nil {
+		return err
+	}
+	*n = list
+	return nil
+}
+
+// ParseNotify splits a notify entry such as "nginx", "restart sshd" or
+// "reload nginx" into how the service is notified and its name. A bare name
+// leaves the choice to the service: reloaded when it sets reload-preferred,
+// restarted otherwise, so how is empty.
+func ParseNotify(entry string) (how, service string, err error) {
+	fields := strings.Fields(entry)
+	switch {
+	case len(fields) == 1 && fields[0] != NotifyRestart && fields[0] != NotifyReload:
+		how, service = "", fields[0]
+	case len(fields) == 2 && (fields[0] == NotifyRestart || fields[0] == NotifyReload):
+		how, service = fields[0], fields[1]
+	}
+	if isServiceName(service) {
+		return how, service, nil
+	}
+	return "", "", fmt.Errorf("invalid notify '%s', must be a service name, 'restart <service>' or 'reload <service>'", entry)
+}
@@ -0,0 +1,202 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Source is the place a config entity was declared.
+type Source struct {
+	File string
+	Line int
+}
+
+func (s Source) String() string {
+	return fmt.Sprintf("%s:%d", s.File, s.Line)
+}
+
+// Override records an entity declared again by a file merged later. The later
+// declaration replaces the earlier one, or is merged into it for users, user
+// packages and service options.
+type Override struct {
+	Key      string
+	Source   Source // The later declaration
+	Previous Source // The declaration it overrode
+	Merged   bool
+}
+
+// Provenance tells where each entity of a merged state comes from. Entities
+// are keyed like "package htop", "service nginx:default" or "config /etc/motd",
+// and top-level settings like "setting timezone" or "setting limits.max-file-size".
+type Provenance struct {
+	Sources   map[string]Source
+	Overrides []Override
+}
+
+// EntityKeys returns the provenance key of every item of a section, in order,
+// or nil for sections whose items are not tracked.
+func (s *SystemState) EntityKeys(section string) []string {
+	var keys []string
+	switch section {
+	case "packages":
+		for _, p := range s.Packages {
+			keys = append(keys, "package "+p.Name)
+		}
+	case "virtual-packages":
+		for _, v := range s.VirtualPackages {
+			keys = append(keys, "virtual-package "+v.Name)
+		}
+	case "services":
+		for _, svc := range s.Services {
+			keys = append(keys, "service "+svc.Name+":"+svc.Runlevel)
+		}
+	case "users":
+		for _, u := range s.Users {
+			keys = append(keys, "user "+u.Name)
+		}
+	case "groups":
+		for _, g := range s.Groups {
+			keys = append(keys, "group "+g.Name)
+		}
+	case "configs":
+		for _, c := range s.Configs {
+			keys = append(keys, "config "+c.Path)
+		}
+	case "user-configs":
+		for _, c := range s.UserConfigs {
+			keys = append(keys, "user-config "+c.Key())
+		}
+	case "managed-blocks":
+		for _, b := range s.ManagedBlocks {
+			key := "managed-block " + b.Path
+			if b.Name != "" {
+				key += ":" + b.Name
+			}
+			keys = append(keys, key)
+		}
+	case "service-options":
+		for _, o := range s.ServiceOptions {
+			keys = append(keys, "service-options "+o.Service)
+		}
+	case "commands":
+		for _, c := range s.Commands {
+			keys = append(keys, "command "+c.Name)
+		}
+	case "plugins":
+		for _, p := range s.Plugins {
+			keys = append(keys, "plugin "+p.Name)
+		}
+	case "user-packages":
+		for _, up := range s.UserPackages {
+			keys = append(keys, "user-packages "+up.User)
+		}
+	case "apply-windows":
+		for _, w := range s.ApplyWindows {
+			keys = append(keys, "apply-window "+w)
+		}
+	case "assertions":
+		for _, a := range s.Assertions {
+			keys = append(keys, "assertion "+a.Label())
+		}
+	}
+	return keys
+}
+
+// untrackedSections are the top-level keys that describe the file rather
+// than settings of the merged state.
+var untrackedSections = map[string]bool{"includes": true, "role": true, "team": true, "profiles": true}
+
+// RecordProvenance sets the provenance of the state to the entities declared
+// in doc, the YAML document the state was decoded from.
+func (s *SystemState) RecordProvenance(doc *yaml.Node, file string) {
+	s.Provenance = &Provenance{Sources: make(map[string]Source)}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		doc = doc.Content[0]
+	}
+	if doc.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		section, value := doc.Content[i].Value, doc.Content[i+1]
+		switch section {
+		case "vars":
+			s.recordMapping(value, "var ", file)
+		case "sysctl":
+			s.recordMapping(value, "sysctl ", file)
+		case "host-vars":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				s.recordMapping(value.Content[j+1], "host-var "+value.Content[j].Value+".", file)
+			}
+		default:
+			keys := s.EntityKeys(section)
+			if keys == nil && value.Kind != yaml.SequenceNode && !untrackedSections[section] {
+				s.Provenance.Sources["setting "+section] = Source{File: file, Line: doc.Content[i].Line}
+				s.recordMapping(value, "setting "+section+".", file)
+				continue
+			}
+			for j, item := range value.Content {
+				if j < len(keys) {
+					s.Provenance.Sources[keys[j]] = Source{File: file, Line: item.Line}
+				}
+			}
+		}
+	}
+}
+
+func (s *SystemState) recordMapping(node *yaml.Node, prefix, file string) {
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		s.Provenance.Sources[prefix+node.Content[i].Value] = Source{File: file, Line: node.Content[i].Line}
+	}
+}
+
+// LocateErrors sets the file and line of errors to where the offending entry
+// was declared: the item of a tracked section for fields such as
+// "configs[2].mode", the parameter for "sysctl.<key>", or the closest
+// declared setting for fields such as "limits.max-file-size".
+func (s *SystemState) LocateErrors(errs ValidationErrors) ValidationErrors {
+	if s.Provenance == nil {
+		return errs
+	}
+	for i, e := range errs {
+		if source, ok := s.locate(e.Field); ok {
+			errs[i].File, errs[i].Line = source.File, source.Line
+		}
+	}
+	return errs
+}
+
+func (s *SystemState) locate(field string) (Source, bool) {
+	if key, ok := strings.CutPrefix(field, "sysctl."); ok {
+		source, ok := s.Provenance.Sources["sysctl "+key]
+		return source, ok
+	}
+	path, rest, indexed := strings.Cut(field, "[")
+	if indexed {
+		if keys := s.EntityKeys(path); keys != nil {
+			index, err := strconv.Atoi(rest[:strings.IndexByte(rest+"]", ']')])
+			if err != nil || index >= len(keys) {
+				return Source{}, false
+			}
+			source, ok := s.Provenance.Sources[keys[index]]
+			return source, ok
+		}
+	}
+	// Settings are tracked down to the keys of their mapping
+	for path != "" {
+		if source, ok := s.Provenance.Sources["setting "+path]; ok {
+			return source, true
+		}
+		dot := strings.LastIndexByte(path, '.')
+		if dot < 0 {
+			break
+		}
+		path = path[:dot]
+	}
+	return Source{}, false
+}
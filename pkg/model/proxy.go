@@ -0,0 +1,63 @@
+package model
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ProxyState is the HTTP(S) proxy commands reach the network through, such
+// as apk fetching packages or pipx installing user packages. It is exported
+// to them as http_proxy, https_proxy and no_proxy, in both cases since tools
+// disagree on which they read.
+type ProxyState struct {
+	HTTP    string   `yaml:"http,omitempty"`     // Proxy URL like http://proxy.example.com:3128
+	HTTPS   string   `yaml:"https,omitempty"`    // Proxy for https URLs, the http proxy by default
+	NoProxy []string `yaml:"no-proxy,omitempty"` // Hosts, domains like .example.com and CIDRs reached directly
+}
+
+// Env returns the proxy settings as environment variables, like
+// http_proxy=http://proxy:3128.
+func (p *ProxyState) Env() []string {
+	if p == nil {
+		return nil
+	}
+	https := p.HTTPS
+	if https == "" {
+		https = p.HTTP
+	}
+	var env []string
+	for _, v := range []struct{ name, value string }{
+		{"http_proxy", p.HTTP},
+		{"https_proxy", https},
+		{"no_proxy", strings.Join(p.NoProxy, ",")},
+	} {
+		if v.value != "" {
+			env = append(env, v.name+"="+v.value, strings.ToUpper(v.name)+"="+v.value)
+		}
+	}
+	return env
+}
+
+// validateProxy checks the settings of a proxy, when one is declared.
+func validateProxy(field string, p *ProxyState) ValidationErrors {
+	if p == nil {
+		return nil
+	}
+	var errs ValidationErrors
+	for _, v := range []struct{ key, value string }{{"http", p.HTTP}, {"https", p.HTTPS}} {
+		if v.value == "" {
+			continue
+		}
+		u, err := url.Parse(v.value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			errs = append(errs, ValidationError{Field: field + "." + v.key, Message: fmt.Sprintf("invalid proxy '%s', must be a URL like http://proxy.example.com:3128", v.value)})
+		}
+	}
+	for i, host := range p.NoProxy {
+		if strings.TrimSpace(host) == "" || strings.ContainsAny(host, ", \t\n") {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.no-proxy[%d]", field, i), Message: "entry cannot be empty or contain commas or whitespace"})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RoleState is the header of an include file that packages a reusable role,
+// such as a web server or a database. Its requirements are checked against
+// the host when a config pulls the role in.
+type RoleState struct {
+	Name         string   `yaml:"name"`
+	Description  string   `yaml:"description,omitempty"`
+	RequiredVars []string `yaml:"required-vars,omitempty"` // Vars the host must set, in vars or host-vars
+	Arches       []string `yaml:"arches,omitempty"`        // Supported apk architectures; empty means any
+	File         string   `yaml:"-"`                       // File the role is declared in
+}
+
+// NeedsFacts reports whether resolving the config for a host requires its
+// facts: to evaluate when expressions, render templates or check the
+// requirements of roles.
+func (s *SystemState) NeedsFacts() bool {
+	if s.HasConditions() {
+		return true
+	}
+	for _, cfg := range s.Configs {
+		if cfg.Template {
+			return true
+		}
+	}
+	for _, cfg := range s.UserConfigs {
+		if cfg.Template {
+			return true
+		}
+	}
+	for _, r := range s.Roles {
+		if len(r.RequiredVars) > 0 || len(r.Arches) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckRoles returns the requirements of the pulled-in roles that the host
+// described by facts does not meet.
+func (s *SystemState) CheckRoles(facts Facts) ValidationErrors {
+	var errs ValidationErrors
+	vars := s.TemplateVars(facts.Hostname)
+	for _, r := range s.Roles {
+		field := fmt.Sprintf("role %s (%s)", r.Name, r.File)
+		for _, name := range r.RequiredVars {
+			if _, ok := vars[name]; !ok {
+				errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("required var '%s' is not set for host '%s'", name, facts.Hostname)})
+			}
+		}
+		if len(r.Arches) > 0 && !containsString(r.Arches, facts.Arch) {
+			errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("architecture '%s' is not supported (supported: %s)", facts.Arch, strings.Join(r.Arches, ", "))})
+		}
+	}
+	return errs
+}
+
+// validateRole returns the problems with a role header.
+func validateRole(r RoleState) ValidationErrors {
+	var errs ValidationErrors
+	field := fmt.Sprintf("role (%s)", r.File)
+	if strings.TrimSpace(r.Name) == "" || strings.ContainsAny(r.Name, " \t\n") {
+		errs = append(errs, ValidationError{Field: field + ".name", Message: "role name cannot be empty or contain whitespace"})
+	}
+	for i, name := range r.RequiredVars {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.required-vars[%d]", field, i), Message: "var name cannot be empty"})
+		}
+	}
+	return errs
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+)
+
+// scalarShorthands are the types whose YAML form may also be a bare scalar,
+// through their UnmarshalYAML methods.
+var scalarShorthands = map[reflect.Type]map[string]any{
+	reflect.TypeOf(PluginState{}):         {"type": "string"},
+	reflect.TypeOf(ModifiedFilesPolicy{}): {"type": "string"},
+	reflect.TypeOf(ByteSize(0)):           {"type": "string"},
+	reflect.TypeOf(NotifyList{}):          {"type": "string"},
+}
+
+// Schema returns the JSON Schema of a config file. It is derived from the
+// yaml tags of SystemState and the types it contains, so it follows the model
+// without being maintained by hand. Struct types are described once under
+// $defs, and profiles refer back to the root since they are configs themselves.
+func Schema() map[string]any {
+	g := &schemaGenerator{defs: make(map[string]any)}
+	root := g.structSchema(reflect.TypeOf(SystemState{}))
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "summit system config"
+	root["$defs"] = g.defs
+	return root
+}
+
+type schemaGenerator struct {
+	defs map[string]any
+}
+
+func (g *schemaGenerator) schema(t reflect.Type) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	s := g.plainSchema(t)
+	if shorthand, ok := scalarShorthands[t]; ok {
+		return map[string]any{"anyOf": []any{shorthand, s}}
+	}
+	return s
+}
+
+func (g *schemaGenerator) plainSchema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.String:
+		// YAML decodes any scalar into a string, so mode: 0644 is a string too
+		return map[string]any{"type": []any{"string", "number", "boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t == reflect.TypeOf(SystemState{}) {
+			return map[string]any{"$ref": "#"}
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // Reserve the name for recursive types
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// structSchema describes the fields of a struct with a yaml key. Unknown keys
+// are refused, as they are most likely typos.
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		properties[name] = g.schema(field.Type)
+	}
+	return map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+}
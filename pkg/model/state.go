@@ -1,9 +1,18 @@
 package model
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+
+	"summit/pkg/schedule"
+
+	"gopkg.in/yaml.v3"
 )
 
 type FileOrigin string
@@ -33,10 +42,14 @@ var ValidRunlevels = map[string]bool{
 type ValidationError struct {
 	Field   string
 	Message string
+	File    string // Config file the offending entity was declared in, if known
 	Line    int
 }
 
 func (e ValidationError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("%s (%s:%d): %s", e.Field, e.File, e.Line, e.Message)
+	}
 	if e.Line > 0 {
 		return fmt.Sprintf("%s (line %d): %s", e.Field, e.Line, e.Message)
 	}
@@ -62,47 +75,649 @@ type Validator interface {
 }
 
 type SystemState struct {
-	Includes       []string            `yaml:"includes,omitempty"` // List of config files to include and merge
-	Packages       []PackageState      `yaml:"packages"`
-	Services       []ServiceState      `yaml:"services"`
-	Users          []UserState         `yaml:"users"`
-	Configs        []SystemConfigState `yaml:"configs"`
-	IgnoredConfigs []string            `yaml:"ignored-configs,omitempty"` // Ignore configs can either be file paths or glob patterns
-	UserPackages   []UserPackageState  `yaml:"user-packages,omitempty"`
+	Version             int                       `yaml:"version,omitempty"`  // Config format the file is written in; older formats are migrated when loaded
+	Role                *RoleState                `yaml:"role,omitempty"`     // Marks the file as a reusable role
+	Team                string                    `yaml:"team,omitempty"`     // Default team label for resources declared in this file
+	Includes            []string                  `yaml:"includes,omitempty"` // List of config files to include and merge
+	Packages            []PackageState            `yaml:"packages"`
+	VirtualPackages     []VirtualPackageState     `yaml:"virtual-packages,omitempty"` // Named sets of packages installed and removed as a unit with apk add --virtual
+	Services            []ServiceState            `yaml:"services"`
+	Users               []UserState               `yaml:"users"`
+	Groups              []GroupState              `yaml:"groups,omitempty"` // Groups declared on their own, with their gids
+	Configs             []SystemConfigState       `yaml:"configs"`
+	UserConfigs         []UserConfigState         `yaml:"user-configs,omitempty"`      // Files in users' home directories, such as dotfiles
+	IgnoredConfigs      []string                  `yaml:"ignored-configs,omitempty"`   // Ignore configs can either be file paths or glob patterns
+	IgnoredServices     []string                  `yaml:"ignored-services,omitempty"`  // Services managed by other tooling (names or glob patterns)
+	IgnoredUsers        []string                  `yaml:"ignored-users,omitempty"`     // Users managed by other tooling (names or glob patterns)
+	IgnoredPackages     []string                  `yaml:"ignored-packages,omitempty"`  // Packages managed by other tooling (names or glob patterns)
+	IntrinsicIgnores    []string                  `yaml:"intrinsic-ignores,omitempty"` // Extra paths, directories or globs that must never be managed
+	UserPackages        []UserPackageState        `yaml:"user-packages,omitempty"`
+	Plugins             []PluginState             `yaml:"plugins,omitempty"`
+	Commands            []CommandState            `yaml:"commands,omitempty"`              // Shell commands run in order, guarded by creates and unless
+	Assertions          []AssertionState          `yaml:"assertions,omitempty"`            // Smoke tests run by verify and after apply, in order
+	Hooks               *HooksState               `yaml:"hooks,omitempty"`                 // Commands run before and after apply changes the system, and when it fails
+	PackageOwnedConfigs string                    `yaml:"package-owned-configs,omitempty"` // What to do with configs overriding package-owned files: warn, error or allow
+	PackageIndexUpdate  string                    `yaml:"package-index-update,omitempty"`  // When to update the package index before installing packages: never, if-stale or always
+	OfflineRepository   string                    `yaml:"offline-repository,omitempty"`    // Local apk mirror or cache directory packages are installed from with apply --offline
+	ModifiedFiles       ModifiedFilesPolicy       `yaml:"modified-files,omitempty"`        // What to do with unmanaged package-modified files
+	Vars                map[string]any            `yaml:"vars,omitempty"`                  // Values available to config templates as .Vars
+	HostVars            map[string]map[string]any `yaml:"host-vars,omitempty"`             // Per-hostname values layered over vars
+	ManagedBlocks       []ManagedBlockState       `yaml:"managed-blocks,omitempty"`        // Delimited regions owned inside files summit does not fully manage
+	ServiceOptions      []ServiceOptionsState     `yaml:"service-options,omitempty"`       // Settings in /etc/conf.d files, leaving the rest of the files alone
+	ApplyWindows        []string                  `yaml:"apply-windows,omitempty"`         // Cron-like expressions for the minutes apply may change the system
+	Sysctl              map[string]string         `yaml:"sysctl,omitempty"`                // Kernel parameters set live and persisted in /etc/sysctl.d
+	Secrets             *SecretsConfig            `yaml:"secrets,omitempty"`               // Where secret://name references in config contents are looked up
+	Proxy               *ProxyState               `yaml:"proxy,omitempty"`                 // HTTP(S) proxy exported to the commands summit runs
+	EtcHistory          string                    `yaml:"etc-history,omitempty"`           // Commit /etc after every apply that changed the system: git or etckeeper
+	Limits              *LimitsState              `yaml:"limits,omitempty"`                // Guardrails on the size and kind of inlined content
+	Timezone            string                    `yaml:"timezone,omitempty"`              // Zone /etc/localtime links to, e.g. Europe/Rome
+	Locale              string                    `yaml:"locale,omitempty"`                // LANG exported to login shells, e.g. en_US.UTF-8
+
+	// HostOverlays is the directory, relative to the top-level config file,
+	// of the per-host files merged on top of the config: <dir>/<hostname>.yaml.
+	// It defaults to "hosts"; "none" disables overlays.
+	HostOverlays string `yaml:"host-overlays,omitempty"`
+
+	// Profiles are variants of the config, such as laptop or server, each a
+	// partial config merged on top when selected. Only the top-level file
+	// declares them.
+	Profiles map[string]*SystemState `yaml:"profiles,omitempty" json:"-"`
+
+	// LoadWarnings holds non-fatal issues found while loading and merging
+	// config files, such as packages declared by more than one include.
+	LoadWarnings ValidationErrors `yaml:"-" json:"-"`
+
+	// Roles lists the role headers of every file merged into this state.
+	Roles []RoleState `yaml:"-" json:"-"`
+
+	// Provenance tells which file and line each entity was declared at, and
+	// which declarations later files overrode.
+	Provenance *Provenance `yaml:"-" json:"-"`
+}
+
+// LabelTeam sets the file-level team label on every resource that does not
+// declare its own. It runs per file, before includes are merged, so each
+// resource keeps the label of the file it came from.
+func (s *SystemState) LabelTeam() {
+	if s.Team == "" {
+		return
+	}
+	for i := range s.Packages {
+		if s.Packages[i].Team == "" {
+			s.Packages[i].Team = s.Team
+		}
+	}
+	for i := range s.VirtualPackages {
+		if s.VirtualPackages[i].Team == "" {
+			s.VirtualPackages[i].Team = s.Team
+		}
+	}
+	for i := range s.Services {
+		if s.Services[i].Team == "" {
+			s.Services[i].Team = s.Team
+		}
+	}
+	for i := range s.Users {
+		if s.Users[i].Team == "" {
+			s.Users[i].Team = s.Team
+		}
+	}
+	for i := range s.Groups {
+		if s.Groups[i].Team == "" {
+			s.Groups[i].Team = s.Team
+		}
+	}
+	for i := range s.Configs {
+		if s.Configs[i].Team == "" {
+			s.Configs[i].Team = s.Team
+		}
+	}
+	for i := range s.UserConfigs {
+		if s.UserConfigs[i].Team == "" {
+			s.UserConfigs[i].Team = s.Team
+		}
+	}
+	for i := range s.UserPackages {
+		if s.UserPackages[i].Team == "" {
+			s.UserPackages[i].Team = s.Team
+		}
+	}
+	for i := range s.ManagedBlocks {
+		if s.ManagedBlocks[i].Team == "" {
+			s.ManagedBlocks[i].Team = s.Team
+		}
+	}
+	for i := range s.ServiceOptions {
+		if s.ServiceOptions[i].Team == "" {
+			s.ServiceOptions[i].Team = s.Team
+		}
+	}
+	for i := range s.Commands {
+		if s.Commands[i].Team == "" {
+			s.Commands[i].Team = s.Team
+		}
+	}
+}
+
+// PluginState declares an external plugin executable and the desired state it
+// should converge. In YAML it can be written either as a bare executable name
+// or as a mapping with a name and an arbitrary config block.
+type PluginState struct {
+	Name   string         `yaml:"name"`
+	Config map[string]any `yaml:"config,omitempty"`
+}
+
+func (p *PluginState) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.Name = value.Value
+		return nil
+	}
+	type plain PluginState
+	return value.Decode((*plain)(p))
+}
+
+// Policies for configs that override files owned by an installed package.
+const (
+	PackageOwnedWarn  = "warn"  // Report a warning (the default policy)
+	PackageOwnedError = "error" // Refuse to plan until the config sets overrides-package
+	PackageOwnedAllow = "allow" // Override silently
+)
+
+// Policies for updating the package index before packages are installed.
+const (
+	IndexUpdateNever   = "never"    // Install from the index as it is (the default policy)
+	IndexUpdateIfStale = "if-stale" // Update an index older than a day, or missing
+	IndexUpdateAlways  = "always"   // Update the index before every install
+)
+
+// IsValidIndexUpdatePolicy reports whether policy is one of never, if-stale
+// or always.
+func IsValidIndexUpdatePolicy(policy string) bool {
+	return policy == IndexUpdateNever || policy == IndexUpdateIfStale || policy == IndexUpdateAlways
+}
+
+// Policies for package-modified files that are not managed by the config.
+const (
+	ModifiedRevert = "revert" // Restore the package default (the default policy)
+	ModifiedWarn   = "warn"   // Leave the file alone and report it
+	ModifiedIgnore = "ignore" // Leave the file alone silently
+)
+
+// ModifiedFilesPolicy decides what happens to package-modified files that the
+// config does not manage. In YAML it can be a bare policy name or a mapping with
+// a default and per-path rules; the first rule whose glob matches wins.
+type ModifiedFilesPolicy struct {
+	Default string             `yaml:"default,omitempty"`
+	Paths   []ModifiedFileRule `yaml:"paths,omitempty"`
+}
+
+// ModifiedFileRule applies a policy to paths matching a glob pattern.
+type ModifiedFileRule struct {
+	Path   string `yaml:"path"`
+	Policy string `yaml:"policy"`
+}
+
+func (p *ModifiedFilesPolicy) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.Default = value.Value
+		return nil
+	}
+	type plain ModifiedFilesPolicy
+	return value.Decode((*plain)(p))
+}
+
+// SysctlFragmentPath is the file the sysctl settings are persisted to, so the
+// values set live survive a reboot.
+const SysctlFragmentPath = "/etc/sysctl.d/99-summit.conf"
+
+// SysctlFragment returns the config persisting the sysctl settings, sorted by
+// key, and false when the config sets none.
+func (s *SystemState) SysctlFragment() (SystemConfigState, bool) {
+	if len(s.Sysctl) == 0 {
+		return SystemConfigState{}, false
+	}
+	keys := make([]string, 0, len(s.Sysctl))
+	for key := range s.Sysctl {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	sb.WriteString("# Managed by summit\n")
+	for _, key := range keys {
+		fmt.Fprintf(&sb, "%s = %s\n", key, s.Sysctl[key])
+	}
+	return SystemConfigState{Path: SysctlFragmentPath, Content: sb.String()}, true
+}
+
+// Ways of keeping the /etc history.
+const (
+	EtcHistoryGit       = "git"
+	EtcHistoryEtckeeper = "etckeeper"
+)
+
+// Providers secret://name references can be looked up with.
+const (
+	SecretsEnv     = "env"
+	SecretsFile    = "file"
+	SecretsCommand = "command"
+)
+
+// SecretsConfig selects the provider secret://name references in config
+// contents are resolved with. Without it, secrets are read from environment
+// variables.
+type SecretsConfig struct {
+	Provider string `yaml:"provider"`          // env, file or command
+	Prefix   string `yaml:"prefix,omitempty"`  // env: prefix of the variable names, SUMMIT_SECRET_ by default
+	Dir      string `yaml:"dir,omitempty"`     // file: directory holding one file per secret, /etc/summit/secrets by default
+	Command  string `yaml:"command,omitempty"` // command: prints the secret named by {name}, e.g. "pass show {name}"
+}
+
+// IsValidModifiedPolicy reports whether policy is one of revert, warn or ignore.
+func IsValidModifiedPolicy(policy string) bool {
+	return policy == ModifiedRevert || policy == ModifiedWarn || policy == ModifiedIgnore
+}
+
+// AssertionState is a post-apply check. Exactly one of Command, HTTP or
+// FileExists must be set.
+type AssertionState struct {
+	Name       string `yaml:"name,omitempty"`
+	Command    string `yaml:"command,omitempty"`
+	ExitCode   int    `yaml:"exit-code,omitempty"` // Expected exit code for Command, default 0
+	HTTP       string `yaml:"http,omitempty"`      // URL that must answer with Status
+	Status     int    `yaml:"status,omitempty"`    // Expected HTTP status, default 200
+	FileExists string `yaml:"file-exists,omitempty"`
+}
+
+// Label returns the assertion's name, or a description derived from its check.
+func (a AssertionState) Label() string {
+	switch {
+	case a.Name != "":
+		return a.Name
+	case a.Command != "":
+		return "command: " + a.Command
+	case a.HTTP != "":
+		return "http: " + a.HTTP
+	default:
+		return "file-exists: " + a.FileExists
+	}
+}
+
+// ManagedBlockState is a region of a shared file owned by summit, delimited by
+// "# BEGIN summit" and "# END summit" marker lines. The rest of the file is
+// never touched.
+type ManagedBlockState struct {
+	Path      string `yaml:"path"`
+	Name      string `yaml:"name,omitempty"`    // Appended to the markers to tell several blocks in one file apart
+	Comment   string `yaml:"comment,omitempty"` // Line comment prefix for the markers, "#" by default
+	Content   string `yaml:"content"`
+	Sensitive bool   `yaml:"sensitive,omitempty"` // Content and diffs are redacted from plans and JSON output
+	Team      string `yaml:"team,omitempty"`
+}
+
+// ServiceOptionsState is settings of an OpenRC service in its /etc/conf.d
+// file, such as command_args or rc_need. Only the declared keys are written;
+// comments and the other keys of the file are left alone.
+type ServiceOptionsState struct {
+	Service   string            `yaml:"service"`
+	Options   map[string]string `yaml:"options"`
+	Sensitive bool              `yaml:"sensitive,omitempty"` // Values and diffs are redacted from plans and JSON output
+	Team      string            `yaml:"team,omitempty"`
+}
+
+// Path returns the file holding the options.
+func (o ServiceOptionsState) Path() string {
+	return "/etc/conf.d/" + o.Service
+}
+
+// Keys returns the names of the options, sorted.
+func (o ServiceOptionsState) Keys() []string {
+	keys := make([]string, 0, len(o.Options))
+	for key := range o.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 type UserPackageState struct {
-	User string   `yaml:"user"`
-	Pipx []string `yaml:"pipx,omitempty"`
-	Npm  []string `yaml:"npm,omitempty"`
+	User  string   `yaml:"user"`
+	Pipx  []string `yaml:"pipx,omitempty"`
+	Npm   []string `yaml:"npm,omitempty"`
+	Cargo []string `yaml:"cargo,omitempty"` // Crates installed with cargo install
+	Gem   []string `yaml:"gem,omitempty"`   // Ruby gems installed in the user's gem home
+	Uv    []string `yaml:"uv,omitempty"`    // Python tools installed with uv tool install
+	Team  string   `yaml:"team,omitempty"`
+}
+
+// UserPackageManagers are the managers user packages are installed with, in
+// the order they are planned.
+var UserPackageManagers = []string{"pipx", "npm", "cargo", "gem", "uv"}
+
+// ManagerPackages returns the list of packages declared for manager, one of
+// UserPackageManagers, or nil for other managers.
+func (up *UserPackageState) ManagerPackages(manager string) *[]string {
+	switch manager {
+	case "pipx":
+		return &up.Pipx
+	case "npm":
+		return &up.Npm
+	case "cargo":
+		return &up.Cargo
+	case "gem":
+		return &up.Gem
+	case "uv":
+		return &up.Uv
+	}
+	return nil
 }
 
 type UserState struct {
-	Name         string   `yaml:"name"`
-	Groups       []string `yaml:"groups"`
-	PrimaryGroup string   `yaml:"-"`
+	Name         string         `yaml:"name"`
+	Groups       []string       `yaml:"groups"`
+	PrimaryGroup string         `yaml:"-"`
+	Team         string         `yaml:"team,omitempty"`
+	Wants        []string       `yaml:"wants,omitempty"`   // Soft dependencies, see ParseWant
+	Crontab      []CronJobState `yaml:"crontab,omitempty"` // Jobs in the summit-owned block of the user's crontab
+	When         string         `yaml:"when,omitempty"`    // Condition on the host, see ParseWhen
+
+	// The /etc/passwd fields of the user. Unset fields are left as they are
+	// on the system, or to adduser's defaults when the user is created.
+	UID   int    `yaml:"uid,omitempty"`
+	Shell string `yaml:"shell,omitempty"`
+	Home  string `yaml:"home,omitempty"`
+	Gecos string `yaml:"gecos,omitempty"` // Full name or comment
+
+	// AuthorizedKeys are the public keys in the summit-owned block of the
+	// user's ~/.ssh/authorized_keys. Other keys in the file are kept unless
+	// PruneAuthorizedKeys is set.
+	AuthorizedKeys      []string `yaml:"authorized-keys,omitempty"`
+	PruneAuthorizedKeys bool     `yaml:"prune-authorized-keys,omitempty"`
+	UnmanagedKeys       []string `yaml:"-"` // Keys found outside the block
+}
+
+// GroupState is a group declared on its own rather than only through the
+// groups of a user, so its gid can be pinned.
+type GroupState struct {
+	Name   string `yaml:"name"`
+	GID    int    `yaml:"gid,omitempty"`    // Unset lets addgroup pick the next free gid
+	System bool   `yaml:"system,omitempty"` // A system group (gid below 1000)
+	Team   string `yaml:"team,omitempty"`
 }
 
 type PackageState struct {
-	Name string `yaml:"name"`
+	Name    string   `yaml:"name"`
+	Version string   `yaml:"version,omitempty"` // Pinned as name=version in /etc/apk/world; other package managers ignore it
+	Team    string   `yaml:"team,omitempty"`
+	Wants   []string `yaml:"wants,omitempty"` // Soft dependencies, see ParseWant
+	When    string   `yaml:"when,omitempty"`  // Condition on the host, see ParseWhen
+}
+
+// VirtualPackageState is a virtual package grouping other packages, as apk add
+// --virtual creates: the packages are installed as its dependencies, and
+// removed with it when nothing else needs them. It suits build dependencies
+// that are installed for a build and dropped afterwards. Its name starts with
+// a dot by apk convention, e.g. .build-deps, which sets it apart from the
+// packages listed in /etc/apk/world next to it.
+type VirtualPackageState struct {
+	Name     string   `yaml:"name"`
+	Packages []string `yaml:"packages"`
+	Team     string   `yaml:"team,omitempty"`
+}
+
+// Groups reports whether packages are the ones v groups, in any order.
+func (v VirtualPackageState) Groups(packages []string) bool {
+	if len(packages) != len(v.Packages) {
+		return false
+	}
+	want := append([]string{}, v.Packages...)
+	got := append([]string{}, packages...)
+	sort.Strings(want)
+	sort.Strings(got)
+	for i := range want {
+		if want[i] != got[i] {
+			return false
+		}
+	}
+	return true
 }
 
 type ServiceState struct {
-	Name     string `yaml:"name"`
-	Enabled  bool   `yaml:"enabled"`
-	Runlevel string `yaml:"runlevel"`
+	Name     string   `yaml:"name"`
+	Enabled  bool     `yaml:"enabled"`
+	Runlevel string   `yaml:"runlevel"`
+	Running  *bool    `yaml:"running,omitempty"` // Whether the service is started; unset leaves it as is
+	Team     string   `yaml:"team,omitempty"`
+	Wants    []string `yaml:"wants,omitempty"` // Soft dependencies, see ParseWant
+	When     string   `yaml:"when,omitempty"`  // Condition on the host, see ParseWhen
+
+	// ReloadPreferred makes config changes reload the service instead of
+	// restarting it, falling back to a restart when the reload fails.
+	ReloadPreferred bool `yaml:"reload-preferred,omitempty"`
+
+	// Healthcheck is polled after the service is started; a service that
+	// does not become healthy fails the action that started it.
+	Healthcheck *HealthcheckState `yaml:"healthcheck,omitempty"`
+
+	// ExtraRunlevels are the runlevels the service is in besides Runlevel.
+	// Configs declare such a service once per runlevel, see GroupRunlevels.
+	ExtraRunlevels []string `yaml:"-" json:",omitempty"`
+}
+
+// Runlevels returns the runlevels the service is in, Runlevel first.
+func (s ServiceState) Runlevels() []string {
+	if s.Runlevel == "" {
+		return slices.Clone(s.ExtraRunlevels)
+	}
+	return append([]string{s.Runlevel}, s.ExtraRunlevels...)
+}
+
+// GroupRunlevels folds the entries of a service declared in several
+// runlevels into its first enabled one, the other runlevels becoming its
+// ExtraRunlevels, so it compares with the service found on the system. An
+// entry disabling the service only keeps it out of that runlevel.
+func GroupRunlevels(services []ServiceState) []ServiceState {
+	var grouped []ServiceState
+	index := make(map[string]int)
+	for _, svc := range services {
+		i, ok := index[svc.Name]
+		if !ok {
+			index[svc.Name] = len(grouped)
+			svc.ExtraRunlevels = nil
+			grouped = append(grouped, svc)
+			continue
+		}
+		first := &grouped[i]
+		switch {
+		case !svc.Enabled || svc.Runlevel == "" || slices.Contains(first.Runlevels(), svc.Runlevel):
+		case !first.Enabled:
+			first.Enabled, first.Runlevel = true, svc.Runlevel
+		default:
+			first.ExtraRunlevels = append(first.ExtraRunlevels, svc.Runlevel)
+		}
+		if first.Running == nil {
+			first.Running = svc.Running
+		}
+		if first.Healthcheck == nil {
+			first.Healthcheck = svc.Healthcheck
+		}
+	}
+	return grouped
+}
+
+// SplitRunlevels reverses GroupRunlevels: a service in several runlevels
+// becomes one entry per runlevel, the way configs declare it.
+func SplitRunlevels(services []ServiceState) []ServiceState {
+	var split []ServiceState
+	for _, svc := range services {
+		extra := svc.ExtraRunlevels
+		svc.ExtraRunlevels = nil
+		split = append(split, svc)
+		for _, runlevel := range extra {
+			svc.Runlevel = runlevel
+			split = append(split, svc)
+		}
+	}
+	return split
+}
+
+// DefaultFileMode is the mode given to created configs that do not set one.
+// Configs without owner or group keep the ownership of the process that
+// writes them (root when applying).
+const DefaultFileMode = "0644"
+
+// NormalizeMode returns an octal mode in its canonical four-digit form, so that
+// "644", "0644" and "00644" compare equal. Empty and unparsable modes are
+// returned unchanged.
+func NormalizeMode(mode string) string {
+	if mode == "" {
+		return mode
+	}
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return mode
+	}
+	return fmt.Sprintf("%04o", parsed)
+}
+
+// Special mode bits, in their octal positions.
+const (
+	modeSetuid     = 04000
+	modeSetgid     = 02000
+	modeSticky     = 01000
+	modeWorldWrite = 00002
+)
+
+// ParseMode converts an octal mode such as "0644" or "4755" to an os.FileMode,
+// with the setuid, setgid and sticky bits mapped to their os.FileMode flags.
+func ParseMode(mode string) (os.FileMode, error) {
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	m := os.FileMode(parsed).Perm()
+	if parsed&modeSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if parsed&modeSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if parsed&modeSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m, nil
+}
+
+// FormatMode returns the four-digit octal form of a file's mode, special
+// bits included.
+func FormatMode(m os.FileMode) string {
+	octal := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		octal |= modeSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		octal |= modeSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		octal |= modeSticky
+	}
+	return fmt.Sprintf("%04o", octal)
+}
+
+// riskyModeBits describes the setuid, setgid and world-writable bits of an
+// octal mode, or returns nil when it has none.
+func riskyModeBits(mode string) []string {
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil
+	}
+	var risky []string
+	if parsed&modeSetuid != 0 {
+		risky = append(risky, "setuid")
+	}
+	if parsed&modeSetgid != 0 {
+		risky = append(risky, "setgid")
+	}
+	if parsed&modeWorldWrite != 0 {
+		risky = append(risky, "world-writable")
+	}
+	return risky
 }
 
 type SystemConfigState struct {
-	Path          string     `yaml:"path"`
-	Content       string     `yaml:"content"`
-	Mode          string     `yaml:"mode,omitempty"`
-	Owner         string     `yaml:"owner,omitempty"`
-	Group         string     `yaml:"group,omitempty"`
-	Origin        FileOrigin `yaml:"-"` // "managed", "package-modified", "user-created"
-	Deleted       bool       `yaml:"-"`
-	FileStatus    string     `yaml:"-"`
-	OriginPackage string     `yaml:"-"`
+	Path             string     `yaml:"path"`
+	Content          string     `yaml:"content"`          // Empty for inferred configs until MaterializeContent is called
+	Source           string     `yaml:"source,omitempty"` // File the content is read from at load time, relative to the config declaring it
+	ContentHash      string     `yaml:"-" json:"-"`
+	Mode             string     `yaml:"mode,omitempty"`
+	Owner            string     `yaml:"owner,omitempty"`
+	Group            string     `yaml:"group,omitempty"`
+	Template         bool       `yaml:"template,omitempty"`          // Render content as a Go template at plan time
+	Team             string     `yaml:"team,omitempty"`              // Label of the team responsible for the file
+	Notify           NotifyList `yaml:"notify,omitempty"`            // Services restarted or reloaded once at the end of apply when the file changes, see ParseNotify
+	Wants            []string   `yaml:"wants,omitempty"`             // Soft dependencies, see ParseWant
+	When             string     `yaml:"when,omitempty"`              // Condition on the host, see ParseWhen
+	OverridesPackage bool       `yaml:"overrides-package,omitempty"` // Acknowledges that the file is owned by a package and deliberately overridden
+	AllowRiskyMode   bool       `yaml:"allow-risky-mode,omitempty"`  // Acknowledges a setuid, setgid or world-writable mode
+	Sensitive        bool       `yaml:"sensitive,omitempty"`         // Content and diffs are redacted from plans, JSON output and dumps
+	Origin           FileOrigin `yaml:"-"`                           // "managed", "package-modified", "user-created"
+	Deleted          bool       `yaml:"-"`
+	FileStatus       string     `yaml:"-"`
+	OriginPackage    string     `yaml:"-"`
+
+	loadContent func() (string, error)
+}
+
+// HashContent returns the digest used to compare config contents without
+// keeping them in memory.
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// SetLazyContent records the hash of a config's content together with a loader
+// that can produce it on demand, leaving Content empty.
+func (c *SystemConfigState) SetLazyContent(hash string, loader func() (string, error)) {
+	c.Content = ""
+	c.ContentHash = hash
+	c.loadContent = loader
+}
+
+// LoadContent returns the config's content, reading it through the lazy loader
+// if one is set. The result is not cached so large files are not kept resident.
+func (c *SystemConfigState) LoadContent() (string, error) {
+	if c.loadContent == nil {
+		return c.Content, nil
+	}
+	return c.loadContent()
+}
+
+// MaterializeContent loads lazy content into the Content field so it can be
+// marshaled.
+func (c *SystemConfigState) MaterializeContent() error {
+	if c.loadContent == nil {
+		return nil
+	}
+	content, err := c.loadContent()
+	if err != nil {
+		return err
+	}
+	c.Content = content
+	c.loadContent = nil
+	return nil
+}
+
+// ContentEquals reports whether the config's content matches content, using
+// the stored hash when the content has not been loaded. Lazy content inferred
+// without a hash is read to compare.
+func (c *SystemConfigState) ContentEquals(content string) bool {
+	if c.loadContent != nil && c.ContentHash == "" {
+		current, err := c.loadContent()
+		return err == nil && current == content
+	}
+	if c.loadContent != nil {
+		return c.ContentHash == HashContent(content)
+	}
+	return c.Content == content
 }
 
 type IgnoredConfig struct {
@@ -118,6 +733,9 @@ func (s *SystemState) Sort() {
 
 	// sort services alphabetically
 	sort.Slice(s.Services, func(i, j int) bool {
+		if s.Services[i].Name == s.Services[j].Name {
+			return s.Services[i].Runlevel < s.Services[j].Runlevel
+		}
 		return s.Services[i].Name < s.Services[j].Name
 	})
 
@@ -126,15 +744,33 @@ func (s *SystemState) Sort() {
 		return s.Users[i].Name < s.Users[j].Name
 	})
 
+	// sort groups alphabetically
+	sort.Slice(s.Groups, func(i, j int) bool {
+		return s.Groups[i].Name < s.Groups[j].Name
+	})
+
 	// sort configs alphabetically
 	sort.Slice(s.Configs, func(i, j int) bool {
 		return s.Configs[i].Path < s.Configs[j].Path
 	})
 
+	// sort user configs by user, then path
+	sort.Slice(s.UserConfigs, func(i, j int) bool {
+		if s.UserConfigs[i].User != s.UserConfigs[j].User {
+			return s.UserConfigs[i].User < s.UserConfigs[j].User
+		}
+		return s.UserConfigs[i].Path < s.UserConfigs[j].Path
+	})
+
 	// sort user packages alphabetically by user
 	sort.Slice(s.UserPackages, func(i, j int) bool {
 		return s.UserPackages[i].User < s.UserPackages[j].User
 	})
+
+	// sort plugins alphabetically
+	sort.Slice(s.Plugins, func(i, j int) bool {
+		return s.Plugins[i].Name < s.Plugins[j].Name
+	})
 }
 
 func (s *SystemState) Validate() ValidationErrors {
@@ -147,6 +783,11 @@ func (s *SystemState) Validate() ValidationErrors {
 		}
 	}
 
+	// Validate roles
+	for _, r := range s.Roles {
+		errs = append(errs, validateRole(r)...)
+	}
+
 	// Validate packages
 	for i, pkg := range s.Packages {
 		if strings.TrimSpace(pkg.Name) == "" {
@@ -155,17 +796,48 @@ func (s *SystemState) Validate() ValidationErrors {
 		if !isValidPackageName(pkg.Name) {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("packages[%d].name", i), Message: "package name contains invalid characters (only alphanumeric, hyphens, and dots allowed)"})
 		}
+		if pkg.Version != "" && !isValidPackageVersion(pkg.Version) {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("packages[%d].version", i), Message: fmt.Sprintf("invalid version '%s', must be an apk version like 1.2.4-r0", pkg.Version)})
+		}
+		errs = append(errs, validateWants(fmt.Sprintf("packages[%d].wants", i), pkg.Wants)...)
+		errs = append(errs, validateWhen(fmt.Sprintf("packages[%d]", i), pkg.When)...)
+	}
+
+	// Validate virtual packages
+	seenVirtual := make(map[string]bool)
+	for i, v := range s.VirtualPackages {
+		field := fmt.Sprintf("virtual-packages[%d]", i)
+		if len(v.Name) < 2 || !strings.HasPrefix(v.Name, ".") || strings.ContainsAny(v.Name, "/ \t\n<>=~") {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: "virtual package name must start with a dot, like .build-deps, and cannot contain whitespace, '/' or version constraints"})
+		}
+		if seenVirtual[v.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("virtual package '%s' is declared more than once", v.Name)})
+		}
+		seenVirtual[v.Name] = true
+		if len(v.Packages) == 0 {
+			errs = append(errs, ValidationError{Field: field + ".packages", Message: "virtual package must list at least one package"})
+		}
+		for j, pkg := range v.Packages {
+			if strings.TrimSpace(pkg) == "" || strings.ContainsAny(pkg, " \t\n") || !isValidPackageName(pkg) {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s.packages[%d]", field, j), Message: "package name cannot be empty or contain whitespace"})
+			}
+		}
 	}
 
 	// Validate services
 	for i, svc := range s.Services {
 		if strings.TrimSpace(svc.Name) == "" {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("services[%d].name", i), Message: "service name cannot be empty"})
+		} else if !isServiceName(svc.Name) {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("services[%d].name", i), Message: invalidServiceName})
 		}
 		// Empty runlevel is valid for disabled services (not added to any runlevel)
 		if svc.Runlevel != "" && !ValidRunlevels[svc.Runlevel] {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("services[%d].runlevel", i), Message: fmt.Sprintf("invalid runlevel '%s', must be one of: boot, default, sysinit, nonetwork, shutdown", svc.Runlevel)})
 		}
+		errs = append(errs, validateHealthcheck(fmt.Sprintf("services[%d].healthcheck", i), svc.Healthcheck)...)
+		errs = append(errs, validateWants(fmt.Sprintf("services[%d].wants", i), svc.Wants)...)
+		errs = append(errs, validateWhen(fmt.Sprintf("services[%d]", i), svc.When)...)
 	}
 
 	// Validate users
@@ -181,7 +853,20 @@ func (s *SystemState) Validate() ValidationErrors {
 				errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].groups[%d]", i, j), Message: "group name contains invalid characters"})
 			}
 		}
+		for j, job := range user.Crontab {
+			errs = append(errs, validateCronJob(fmt.Sprintf("users[%d].crontab[%d]", i, j), job)...)
+		}
+		for j, key := range user.AuthorizedKeys {
+			if _, ok := AuthorizedKeyID(key); !ok || strings.Contains(key, "\n") {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].authorized-keys[%d]", i, j), Message: "must be a single public key line like 'ssh-ed25519 AAAA... comment'"})
+			}
+		}
+		errs = append(errs, validateUserAttributes(i, user)...)
+		errs = append(errs, validateWants(fmt.Sprintf("users[%d].wants", i), user.Wants)...)
+		errs = append(errs, validateWhen(fmt.Sprintf("users[%d]", i), user.When)...)
 	}
+	errs = append(errs, s.validateUniqueUIDs()...)
+	errs = append(errs, s.validateGroups()...)
 
 	// Validate configs
 	for i, cfg := range s.Configs {
@@ -195,12 +880,15 @@ func (s *SystemState) Validate() ValidationErrors {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].path", i), Message: "config path cannot contain '..'"})
 		}
 		// Check for conflicts with intrinsic ignores
-		if isIntrinsicIgnore(cfg.Path) {
+		if _, ignored := MatchIntrinsicIgnore(cfg.Path, s.IntrinsicIgnores); ignored {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].path", i), Message: "cannot manage intrinsically ignored file (security/safety reasons)"})
 		}
 		if cfg.Mode != "" {
 			if !isValidOctalMode(cfg.Mode) {
 				errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].mode", i), Message: "mode must be a valid octal value like '0755' or '0644'"})
+			} else if risky := riskyModeBits(cfg.Mode); len(risky) > 0 && !cfg.AllowRiskyMode && strings.HasPrefix(cfg.Path, "/etc/") {
+				// A typo like 0777 on sshd_config must not reach the system
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].mode", i), Message: fmt.Sprintf("mode %s makes the file %s; set allow-risky-mode: true if this is intended", cfg.Mode, strings.Join(risky, " and "))})
 			}
 		}
 		if cfg.Owner != "" && !isValidUserName(cfg.Owner) {
@@ -209,6 +897,91 @@ func (s *SystemState) Validate() ValidationErrors {
 		if cfg.Group != "" && !isValidUserName(cfg.Group) {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].group", i), Message: "group contains invalid characters"})
 		}
+		for j, entry := range cfg.Notify {
+			if _, _, err := ParseNotify(entry); err != nil {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].notify[%d]", i, j), Message: err.Error()})
+			}
+		}
+		if cfg.Template {
+			if _, err := ParseTemplate(cfg.Path, cfg.Content); err != nil {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].content", i), Message: fmt.Sprintf("invalid template: %v", err)})
+			}
+		}
+		errs = append(errs, validateWants(fmt.Sprintf("configs[%d].wants", i), cfg.Wants)...)
+		errs = append(errs, validateWhen(fmt.Sprintf("configs[%d]", i), cfg.When)...)
+	}
+
+	errs = append(errs, s.validateUserConfigs()...)
+
+	// Validate sysctl settings
+	for key, value := range s.Sysctl {
+		if !isValidSysctlKey(key) {
+			errs = append(errs, ValidationError{Field: "sysctl." + key, Message: "key must be a dotted kernel parameter name like 'net.ipv4.ip_forward'"})
+		}
+		if strings.TrimSpace(value) == "" || strings.ContainsAny(value, "\n=") {
+			errs = append(errs, ValidationError{Field: "sysctl." + key, Message: "value cannot be empty or contain newlines or '='"})
+		}
+	}
+	if len(s.Sysctl) > 0 {
+		for i, cfg := range s.Configs {
+			if cfg.Path == SysctlFragmentPath {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].path", i), Message: fmt.Sprintf("%s is written from the sysctl section", SysctlFragmentPath)})
+			}
+		}
+	}
+
+	// Validate managed blocks
+	configPaths := make(map[string]bool)
+	for _, cfg := range s.Configs {
+		configPaths[cfg.Path] = true
+	}
+	seenBlocks := make(map[string]bool)
+	for i, b := range s.ManagedBlocks {
+		field := fmt.Sprintf("managed-blocks[%d]", i)
+		if !strings.HasPrefix(b.Path, "/") || strings.Contains(b.Path, "..") {
+			errs = append(errs, ValidationError{Field: field + ".path", Message: "path must be absolute (start with '/') and cannot contain '..'"})
+		}
+		if _, ignored := MatchIntrinsicIgnore(b.Path, s.IntrinsicIgnores); ignored {
+			errs = append(errs, ValidationError{Field: field + ".path", Message: "cannot manage intrinsically ignored file (security/safety reasons)"})
+		}
+		if configPaths[b.Path] {
+			errs = append(errs, ValidationError{Field: field + ".path", Message: fmt.Sprintf("%s is fully managed in configs", b.Path)})
+		}
+		if strings.ContainsAny(b.Name, " \t\n") {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: "block name cannot contain whitespace"})
+		}
+		if strings.Contains(b.Content, "BEGIN summit") || strings.Contains(b.Content, "END summit") {
+			errs = append(errs, ValidationError{Field: field + ".content", Message: "content cannot contain summit block markers"})
+		}
+		key := b.Path + "\x00" + b.Name
+		if seenBlocks[key] {
+			errs = append(errs, ValidationError{Field: field, Message: fmt.Sprintf("duplicate block '%s' in %s", b.Name, b.Path)})
+		}
+		seenBlocks[key] = true
+	}
+
+	// Validate service options
+	seenOptions := make(map[string]bool)
+	for i, o := range s.ServiceOptions {
+		field := fmt.Sprintf("service-options[%d]", i)
+		if !isServiceName(o.Service) {
+			errs = append(errs, ValidationError{Field: field + ".service", Message: invalidServiceName})
+		}
+		if configPaths[o.Path()] {
+			errs = append(errs, ValidationError{Field: field + ".service", Message: fmt.Sprintf("%s is fully managed in configs", o.Path())})
+		}
+		if seenOptions[o.Service] {
+			errs = append(errs, ValidationError{Field: field + ".service", Message: fmt.Sprintf("options of service '%s' are declared more than once", o.Service)})
+		}
+		seenOptions[o.Service] = true
+		for _, key := range o.Keys() {
+			if !isShellVariable(key) {
+				errs = append(errs, ValidationError{Field: field + ".options." + key, Message: "option name must be a shell variable name like command_args"})
+			}
+			if strings.ContainsAny(o.Options[key], "\n\x00") {
+				errs = append(errs, ValidationError{Field: field + ".options." + key, Message: "option value cannot span lines"})
+			}
+		}
 	}
 
 	// Validate user packages
@@ -220,21 +993,158 @@ func (s *SystemState) Validate() ValidationErrors {
 		if !userMap[up.User] {
 			errs = append(errs, ValidationError{Field: fmt.Sprintf("user-packages[%d].user", i), Message: fmt.Sprintf("user '%s' not defined in users section", up.User)})
 		}
-		for j, pkg := range up.Pipx {
-			if !isValidPackageName(pkg) {
-				errs = append(errs, ValidationError{Field: fmt.Sprintf("user-packages[%d].pipx[%d]", i, j), Message: "package name contains invalid characters"})
+		for _, manager := range UserPackageManagers {
+			for j, pkg := range *up.ManagerPackages(manager) {
+				if !isValidPackageName(pkg) {
+					errs = append(errs, ValidationError{Field: fmt.Sprintf("user-packages[%d].%s[%d]", i, manager, j), Message: "package name contains invalid characters"})
+				}
+			}
+		}
+	}
+
+	// Validate ignore lists
+	ignoreLists := map[string][]string{
+		"ignored-services":  s.IgnoredServices,
+		"ignored-users":     s.IgnoredUsers,
+		"ignored-packages":  s.IgnoredPackages,
+		"intrinsic-ignores": s.IntrinsicIgnores,
+	}
+	for _, section := range []string{"ignored-services", "ignored-users", "ignored-packages", "intrinsic-ignores"} {
+		for i, pattern := range ignoreLists[section] {
+			if strings.TrimSpace(pattern) == "" {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("%s[%d]", section, i), Message: "ignore pattern cannot be empty"})
+			}
+		}
+	}
+
+	// Validate plugins
+	for i, p := range s.Plugins {
+		if strings.TrimSpace(p.Name) == "" {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("plugins[%d].name", i), Message: "plugin name cannot be empty"})
+		} else if strings.ContainsAny(p.Name, " \t\n") {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("plugins[%d].name", i), Message: "plugin name cannot contain whitespace"})
+		}
+	}
+
+	// Validate package-owned-configs policy
+	switch s.PackageOwnedConfigs {
+	case "", PackageOwnedWarn, PackageOwnedError, PackageOwnedAllow:
+	default:
+		errs = append(errs, ValidationError{Field: "package-owned-configs", Message: fmt.Sprintf("invalid policy '%s', must be one of: warn, error, allow", s.PackageOwnedConfigs)})
+	}
+
+	// Validate package-index-update policy
+	if s.PackageIndexUpdate != "" && !IsValidIndexUpdatePolicy(s.PackageIndexUpdate) {
+		errs = append(errs, ValidationError{Field: "package-index-update", Message: fmt.Sprintf("invalid policy '%s', must be one of: never, if-stale, always", s.PackageIndexUpdate)})
+	}
+	if s.OfflineRepository != "" && !strings.HasPrefix(s.OfflineRepository, "/") {
+		errs = append(errs, ValidationError{Field: "offline-repository", Message: "path must be absolute (start with '/')"})
+	}
+
+	// Validate modified-files policy
+	if s.ModifiedFiles.Default != "" && !IsValidModifiedPolicy(s.ModifiedFiles.Default) {
+		errs = append(errs, ValidationError{Field: "modified-files.default", Message: fmt.Sprintf("invalid policy '%s', must be one of: revert, warn, ignore", s.ModifiedFiles.Default)})
+	}
+	for i, rule := range s.ModifiedFiles.Paths {
+		if strings.TrimSpace(rule.Path) == "" {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("modified-files.paths[%d].path", i), Message: "path cannot be empty"})
+		}
+		if !IsValidModifiedPolicy(rule.Policy) {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("modified-files.paths[%d].policy", i), Message: fmt.Sprintf("invalid policy '%s', must be one of: revert, warn, ignore", rule.Policy)})
+		}
+	}
+
+	// Validate apply windows
+	for i, expr := range s.ApplyWindows {
+		if _, err := schedule.Parse(expr); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("apply-windows[%d]", i), Message: err.Error()})
+		}
+	}
+
+	// Validate inlined content size
+	errs = append(errs, s.validateLimits()...)
+	errs = append(errs, s.validateTimezoneAndLocale()...)
+
+	// Validate etc-history
+	switch s.EtcHistory {
+	case "", EtcHistoryGit, EtcHistoryEtckeeper:
+	default:
+		errs = append(errs, ValidationError{Field: "etc-history", Message: fmt.Sprintf("invalid value '%s', must be one of: git, etckeeper", s.EtcHistory)})
+	}
+
+	// Validate secrets provider
+	if s.Secrets != nil {
+		switch s.Secrets.Provider {
+		case SecretsEnv:
+		case SecretsFile:
+			if s.Secrets.Dir != "" && !strings.HasPrefix(s.Secrets.Dir, "/") {
+				errs = append(errs, ValidationError{Field: "secrets.dir", Message: "directory must be absolute (start with '/')"})
 			}
+		case SecretsCommand:
+			if strings.TrimSpace(s.Secrets.Command) == "" {
+				errs = append(errs, ValidationError{Field: "secrets.command", Message: "command provider requires a command"})
+			}
+		default:
+			errs = append(errs, ValidationError{Field: "secrets.provider", Message: fmt.Sprintf("invalid provider '%s', must be one of: env, file, command", s.Secrets.Provider)})
 		}
-		for j, pkg := range up.Npm {
-			if !isValidPackageName(pkg) {
-				errs = append(errs, ValidationError{Field: fmt.Sprintf("user-packages[%d].npm[%d]", i, j), Message: "package name contains invalid characters"})
+	}
+
+	errs = append(errs, validateProxy("proxy", s.Proxy)...)
+	errs = append(errs, validateCommands(s.Commands)...)
+	errs = append(errs, validateHooks("hooks", s.Hooks)...)
+
+	// Validate assertions
+	for i, a := range s.Assertions {
+		checks := 0
+		for _, check := range []string{a.Command, a.HTTP, a.FileExists} {
+			if strings.TrimSpace(check) != "" {
+				checks++
 			}
 		}
+		if checks != 1 {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("assertions[%d]", i), Message: "assertion must set exactly one of command, http or file-exists"})
+		}
+		if a.FileExists != "" && !strings.HasPrefix(a.FileExists, "/") {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("assertions[%d].file-exists", i), Message: "path must be absolute (start with '/')"})
+		}
+		if a.HTTP != "" && !strings.HasPrefix(a.HTTP, "http://") && !strings.HasPrefix(a.HTTP, "https://") {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("assertions[%d].http", i), Message: "URL must start with http:// or https://"})
+		}
 	}
 
 	return errs
 }
 
+// Warnings returns non-fatal issues in the desired state. Unlike Validate,
+// warnings never block apply; they point at configuration that is probably
+// not what the author intended.
+func (s *SystemState) Warnings() ValidationErrors {
+	warnings := append(ValidationErrors{}, s.LoadWarnings...)
+
+	seenPackages := make(map[string]int)
+	for i, pkg := range s.Packages {
+		if first, ok := seenPackages[pkg.Name]; ok {
+			warnings = append(warnings, ValidationError{Field: fmt.Sprintf("packages[%d].name", i), Message: fmt.Sprintf("package '%s' is already declared at packages[%d]", pkg.Name, first)})
+			continue
+		}
+		seenPackages[pkg.Name] = i
+	}
+
+	for i, cfg := range s.Configs {
+		if risky := riskyModeBits(cfg.Mode); len(risky) > 0 && !cfg.AllowRiskyMode && !strings.HasPrefix(cfg.Path, "/etc/") && isValidOctalMode(cfg.Mode) {
+			warnings = append(warnings, ValidationError{Field: fmt.Sprintf("configs[%d].mode", i), Message: fmt.Sprintf("mode %s makes %s %s; set allow-risky-mode: true if this is intended", cfg.Mode, cfg.Path, strings.Join(risky, " and "))})
+		}
+	}
+
+	for i, svc := range s.Services {
+		if svc.Enabled && svc.Runlevel == "" {
+			warnings = append(warnings, ValidationError{Field: fmt.Sprintf("services[%d].runlevel", i), Message: fmt.Sprintf("service '%s' is enabled but has no runlevel", svc.Name)})
+		}
+	}
+
+	return warnings
+}
+
 func isValidPackageName(name string) bool {
 	for _, r := range name {
 		if r < 32 || r == 127 { // control chars
@@ -244,6 +1154,15 @@ func isValidPackageName(name string) bool {
 	return true
 }
 
+func isValidPackageVersion(version string) bool {
+	for _, r := range version {
+		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return version[0] >= '0' && version[0] <= '9'
+}
+
 func isValidUserName(name string) bool {
 	for _, r := range name {
 		if !((r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '_') {
@@ -253,44 +1172,59 @@ func isValidUserName(name string) bool {
 	return true
 }
 
-func isValidOctalMode(mode string) bool {
-	if len(mode) != 4 || mode[0] != '0' {
+// isValidSysctlKey accepts the dotted names sysctl takes, e.g.
+// "net.ipv4.conf.eth0.forwarding" or "vm.swappiness".
+func isValidSysctlKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, "..") {
 		return false
 	}
-	for _, r := range mode[1:] {
-		if r < '0' || r > '7' {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-' || r == '/') {
 			return false
 		}
 	}
 	return true
 }
 
-func isIntrinsicIgnore(path string) bool {
-	// Exact matches
-	intrinsicIgnores := []string{
-		"/etc/passwd",
-		"/etc/group",
-		"/etc/shadow",
-		"/etc/apk/world",
+// invalidServiceName is the error for a name isServiceName refuses.
+const invalidServiceName = "service name must be letters, digits, '.', '_' and '-', not starting with '.' or '-'"
+
+// isServiceName accepts the names of OpenRC init scripts, e.g. "sshd" or
+// "net.eth0". Names are passed to rc-service and rc-update unquoted and end
+// up in /etc/conf.d paths, so nothing else is allowed.
+func isServiceName(name string) bool {
+	if name == "" || name[0] == '.' || name[0] == '-' {
+		return false
 	}
-	for _, ignore := range intrinsicIgnores {
-		if path == ignore {
-			return true
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
 		}
 	}
-	// Prefix ignores
-	prefixIgnores := []string{
-		"/etc/apk/keys",
-		"/etc/runlevels",
-	}
-	for _, ignore := range prefixIgnores {
-		if strings.HasPrefix(path, ignore) {
-			return true
+	return true
+}
+
+// isShellVariable accepts the names of shell variables, which conf.d files
+// set, e.g. command_args.
+func isShellVariable(name string) bool {
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_' || i > 0 && r >= '0' && r <= '9') {
+			return false
 		}
 	}
-	// Suffix ignores
-	if strings.HasSuffix(path, "-") || strings.HasSuffix(path, ".bak") {
-		return true
+	return name != ""
+}
+
+// isValidOctalMode accepts three or four octal digits, e.g. "644", "0644" or
+// "4755" with the setuid bit.
+func isValidOctalMode(mode string) bool {
+	if len(mode) != 3 && len(mode) != 4 {
+		return false
 	}
-	return false
+	for _, r := range mode {
+		if r < '0' || r > '7' {
+			return false
+		}
+	}
+	return true
 }
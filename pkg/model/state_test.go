@@ -1,9 +1,14 @@
 package model
 
 import (
+	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 )
 
 func TestSystemState_Sort(t *testing.T) {
@@ -48,27 +53,798 @@ func TestSystemState_Sort(t *testing.T) {
 	assert.Equal(t, "zuser", state.UserPackages[1].User)
 }
 
-func TestIsIntrinsicIgnore(t *testing.T) {
+func TestGroupRunlevels(t *testing.T) {
+	started := true
+	services := []ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "default"},
+		{Name: "crond"},
+		{Name: "sshd", Enabled: true, Runlevel: "boot", Running: &started},
+		{Name: "sshd", Runlevel: "nonetwork"},
+		{Name: "crond", Enabled: true, Runlevel: "default"},
+	}
+	grouped := GroupRunlevels(services)
+	assert.Equal(t, []ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "default", ExtraRunlevels: []string{"boot"}, Running: &started},
+		{Name: "crond", Enabled: true, Runlevel: "default"},
+	}, grouped)
+	assert.Equal(t, []string{"default", "boot"}, grouped[0].Runlevels())
+
+	assert.Equal(t, []ServiceState{
+		{Name: "sshd", Enabled: true, Runlevel: "default", Running: &started},
+		{Name: "sshd", Enabled: true, Runlevel: "boot", Running: &started},
+		{Name: "crond", Enabled: true, Runlevel: "default"},
+	}, SplitRunlevels(grouped))
+}
+
+func TestMatchIntrinsicIgnore(t *testing.T) {
+	extra := []string{"/etc/wireguard", "/etc/ssl/private/*.key"}
 	tests := []struct {
 		path     string
 		expected bool
+		reason   string
 	}{
-		{"/etc/passwd", true},
-		{"/etc/group", true},
-		{"/etc/shadow", true},
-		{"/etc/apk/world", true},
-		{"/etc/apk/keys/somekey", true},
-		{"/etc/runlevels/default/sshd", true},
-		{"/etc/hosts", false},
-		{"/etc/ssh/sshd_config", false},
-		{"/etc/file-", true},
-		{"/etc/file.bak", true},
-		{"/etc/normal", false},
+		{"/etc/passwd", true, "/etc/passwd"},
+		{"/etc/group", true, "/etc/group"},
+		{"/etc/shadow", true, "/etc/shadow"},
+		{"/etc/shadow.lock", true, "/etc/shadow"},
+		{"/etc/group+", true, "/etc/group"},
+		{"/etc/apk/arch.new", true, "/etc/apk/arch"},
+		{"/etc/apk/world", true, "/etc/apk/world"},
+		{"/etc/apk/keys/somekey", true, "/etc/apk/keys"},
+		{"/etc/runlevels/default/sshd", true, "runlevel files"},
+		{"/etc/hosts", false, ""},
+		{"/etc/ssh/sshd_config", false, ""},
+		{"/etc/file-", true, "backup file"},
+		{"/etc/file.bak", true, "backup file"},
+		{"/etc/normal", false, ""},
+		{"/etc/wireguard/wg0.conf", true, "/etc/wireguard"},
+		{"/etc/wireguard-tools", false, ""},
+		{"/etc/ssl/private/host.key", true, "/etc/ssl/private/*.key"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.path, func(t *testing.T) {
-			assert.Equal(t, tt.expected, isIntrinsicIgnore(tt.path))
+			rule, ok := MatchIntrinsicIgnore(tt.path, extra)
+			assert.Equal(t, tt.expected, ok)
+			assert.Equal(t, tt.reason, rule.Reason)
 		})
 	}
+
+	_, ok := MatchIntrinsicIgnore("/etc/wireguard/wg0.conf", nil)
+	assert.False(t, ok, "extra rules only apply when configured")
+}
+
+func TestSystemState_Warnings(t *testing.T) {
+	state := &SystemState{
+		Packages: []PackageState{{Name: "htop"}, {Name: "vim"}, {Name: "htop"}},
+		Services: []ServiceState{
+			{Name: "sshd", Enabled: true, Runlevel: "default"},
+			{Name: "crond", Enabled: true},
+		},
+		LoadWarnings: ValidationErrors{{Field: "packages", Message: "from includes"}},
+	}
+
+	warnings := state.Warnings()
+
+	assert.Len(t, warnings, 3)
+	assert.Equal(t, "packages", warnings[0].Field)
+	assert.Equal(t, "packages[2].name", warnings[1].Field)
+	assert.Equal(t, "services[1].runlevel", warnings[2].Field)
+	assert.Empty(t, state.Validate(), "warnings must not be reported as errors")
+}
+
+func TestSystemConfigState_LazyContent(t *testing.T) {
+	loads := 0
+	cfg := SystemConfigState{Path: "/etc/motd"}
+	cfg.SetLazyContent(HashContent("hello"), func() (string, error) {
+		loads++
+		return "hello", nil
+	})
+
+	assert.True(t, cfg.ContentEquals("hello"))
+	assert.False(t, cfg.ContentEquals("goodbye"))
+	assert.Equal(t, 0, loads, "comparisons must not load content")
+
+	content, err := cfg.LoadContent()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", content)
+	assert.Empty(t, cfg.Content)
+
+	assert.NoError(t, cfg.MaterializeContent())
+	assert.Equal(t, "hello", cfg.Content)
+	assert.True(t, cfg.ContentEquals("hello"))
+	assert.Equal(t, 2, loads)
+}
+
+func TestSystemState_ValidateAssertions(t *testing.T) {
+	state := &SystemState{
+		Assertions: []AssertionState{
+			{Command: "nginx -t"},
+			{HTTP: "http://localhost/", Status: 204},
+			{FileExists: "/run/nginx.pid"},
+			{},
+			{Command: "true", FileExists: "/tmp/x"},
+			{HTTP: "localhost"},
+			{FileExists: "run/nginx.pid"},
+		},
+	}
+
+	errs := state.Validate()
+
+	assert.Len(t, errs, 4)
+	assert.Equal(t, "assertions[3]", errs[0].Field)
+	assert.Equal(t, "assertions[4]", errs[1].Field)
+	assert.Equal(t, "assertions[5].http", errs[2].Field)
+	assert.Equal(t, "assertions[6].file-exists", errs[3].Field)
+}
+
+func TestNormalizeMode(t *testing.T) {
+	assert.Equal(t, "0644", NormalizeMode("644"))
+	assert.Equal(t, "0644", NormalizeMode("0644"))
+	assert.Equal(t, "0040", NormalizeMode("040"))
+	assert.Equal(t, "", NormalizeMode(""))
+	assert.Equal(t, "rw-r--r--", NormalizeMode("rw-r--r--"))
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []string{"0644", "4755", "2775", "1777", "6750"} {
+		parsed, err := ParseMode(mode)
+		require.NoError(t, err)
+		assert.Equal(t, mode, FormatMode(parsed))
+	}
+	parsed, err := ParseMode("4755")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSetuid|0755, parsed)
+}
+
+func TestSystemState_ValidateRiskyModes(t *testing.T) {
+	state := &SystemState{
+		Configs: []SystemConfigState{
+			{Path: "/etc/ssh/sshd_config", Mode: "0777"},
+			{Path: "/etc/sudo-helper", Mode: "4755"},
+			{Path: "/etc/shared", Mode: "0666", AllowRiskyMode: true},
+			{Path: "/etc/motd", Mode: "644"},
+			{Path: "/usr/local/bin/tool", Mode: "2755"},
+		},
+	}
+
+	errs := state.Validate()
+	require.Len(t, errs, 2)
+	assert.Equal(t, "configs[0].mode", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "mode 0777 makes the file world-writable; set allow-risky-mode: true")
+	assert.Equal(t, "configs[1].mode", errs[1].Field)
+	assert.Contains(t, errs[1].Message, "setuid")
+
+	// Outside /etc a risky mode is only a warning
+	warnings := state.Warnings()
+	require.Len(t, warnings, 1)
+	assert.Equal(t, "configs[4].mode", warnings[0].Field)
+	assert.Contains(t, warnings[0].Message, "makes /usr/local/bin/tool setgid")
+}
+
+func TestParseByteSize(t *testing.T) {
+	for input, expected := range map[string]ByteSize{"1024": 1024, "512K": 512 << 10, "2MiB": 2 << 20, "1 GB": 1 << 30, "3m": 3 << 20} {
+		size, err := ParseByteSize(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, size, input)
+	}
+	for _, input := range []string{"", "-1", "1T", "MB"} {
+		_, err := ParseByteSize(input)
+		assert.Error(t, err, input)
+	}
+	assert.Equal(t, "1.5M", ByteSize(3<<19).String())
+	assert.Equal(t, "512 bytes", ByteSize(512).String())
+}
+
+func TestSystemState_ValidateLimits(t *testing.T) {
+	state := &SystemState{
+		Configs: []SystemConfigState{
+			{Path: "/etc/big.conf", Content: strings.Repeat("x", 3000)},
+			{Path: "/etc/blob", Content: "\x00\x01"},
+			{Path: "/etc/motd", Content: "hello"},
+		},
+		ManagedBlocks: []ManagedBlockState{{Path: "/etc/hosts", Content: strings.Repeat("y", 2000)}},
+		Limits:        &LimitsState{MaxFileSize: 2048, MaxTotalSize: 4096},
+	}
+
+	errs := state.Validate()
+	require.Len(t, errs, 3)
+	assert.Equal(t, "configs[0].content", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "content is 2.9K, over the 2K limit (limits.max-file-size)")
+	assert.Equal(t, "configs[1].content", errs[1].Field)
+	assert.Contains(t, errs[1].Message, "looks binary")
+	assert.Equal(t, "configs", errs[2].Field)
+	assert.Contains(t, errs[2].Message, "over the 4K limit (limits.max-total-size)")
+
+	state.Limits = &LimitsState{AllowBinary: true}
+	assert.Empty(t, state.Validate(), "the default limits are far larger")
+
+	// Files read from a source are not inlined
+	state.Limits = &LimitsState{MaxFileSize: 2048, MaxTotalSize: 4096}
+	state.Configs[0].Source, state.Configs[1].Source = "files/big.conf", "files/blob"
+	assert.Empty(t, state.Validate())
+	assert.Equal(t, 2005, state.InlinedSize())
+}
+
+func TestSystemState_Sysctl(t *testing.T) {
+	state := &SystemState{
+		Sysctl: map[string]string{"vm.swappiness": "10", "net.ipv4.ip_forward": "1"},
+	}
+	assert.Empty(t, state.Validate())
+	fragment, ok := state.SysctlFragment()
+	require.True(t, ok)
+	assert.Equal(t, SysctlFragmentPath, fragment.Path)
+	assert.Equal(t, "# Managed by summit\nnet.ipv4.ip_forward = 1\nvm.swappiness = 10\n", fragment.Content)
+
+	state.Sysctl["bad key"] = "1"
+	state.Sysctl["kernel.panic"] = ""
+	state.Configs = []SystemConfigState{{Path: SysctlFragmentPath}}
+	errs := state.Validate()
+	require.Len(t, errs, 3)
+	fields := []string{errs[0].Field, errs[1].Field, errs[2].Field}
+	assert.ElementsMatch(t, []string{"sysctl.bad key", "sysctl.kernel.panic", "configs[0].path"}, fields)
+
+	_, ok = (&SystemState{}).SysctlFragment()
+	assert.False(t, ok)
+}
+
+func TestSystemState_ValidateServiceOptions(t *testing.T) {
+	state := &SystemState{
+		ServiceOptions: []ServiceOptionsState{
+			{Service: "sshd", Options: map[string]string{"command_args": "-4", "rc_need": "net"}},
+			{Service: "../shadow", Options: map[string]string{"bad-key": "x"}},
+			{Service: "sshd", Options: map[string]string{"command_args": "a\nb"}},
+			{Service: "nginx"},
+			{Service: "app;reboot"},
+			{Service: "net.eth0", Options: map[string]string{"config_eth0": "dhcp"}},
+		},
+		Configs: []SystemConfigState{{Path: "/etc/conf.d/nginx"}},
+	}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 6)
+	assert.Equal(t, "service-options[1].service", errs[0].Field)
+	assert.Equal(t, "service-options[1].options.bad-key", errs[1].Field)
+	assert.Equal(t, "service-options[2].service", errs[2].Field)
+	assert.Contains(t, errs[2].Message, "declared more than once")
+	assert.Equal(t, "service-options[2].options.command_args", errs[3].Field)
+	assert.Equal(t, "service-options[3].service", errs[4].Field)
+	assert.Contains(t, errs[4].Message, "fully managed in configs")
+	assert.Equal(t, "service-options[4].service", errs[5].Field)
+}
+
+func TestSystemState_ValidateServiceNames(t *testing.T) {
+	state := &SystemState{Services: []ServiceState{
+		{Name: "net.eth0", Enabled: true, Runlevel: "boot"},
+		{Name: "php-fpm82", Enabled: true, Runlevel: "default"},
+		{Name: "sshd && reboot", Enabled: true, Runlevel: "default"},
+		{Name: "--version"},
+	}}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 2)
+	assert.Equal(t, "services[2].name", errs[0].Field)
+	assert.Equal(t, "services[3].name", errs[1].Field)
+	assert.Contains(t, errs[1].Message, "not starting with '.' or '-'")
+}
+
+func TestSystemState_ValidateHealthcheck(t *testing.T) {
+	state := &SystemState{
+		Services: []ServiceState{
+			{Name: "nginx", Enabled: true, Runlevel: "default", Healthcheck: &HealthcheckState{TCP: "80"}},
+			{Name: "app", Enabled: true, Runlevel: "default", Healthcheck: &HealthcheckState{HTTP: "http://localhost:8080/health", Timeout: "2m"}},
+			{Name: "redis", Healthcheck: &HealthcheckState{TCP: "localhost:99999", Command: "redis-cli ping"}},
+			{Name: "db", Healthcheck: &HealthcheckState{HTTP: "localhost", Timeout: "soon"}},
+		},
+	}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 4)
+	assert.Equal(t, "services[2].healthcheck", errs[0].Field)
+	assert.Equal(t, "services[2].healthcheck.tcp", errs[1].Field)
+	assert.Equal(t, "services[3].healthcheck.http", errs[2].Field)
+	assert.Equal(t, "services[3].healthcheck.timeout", errs[3].Field)
+	assert.Equal(t, "127.0.0.1:80", state.Services[0].Healthcheck.Address())
+	assert.Equal(t, 2*time.Minute, state.Services[1].Healthcheck.TimeoutDuration())
+}
+
+func TestSystemState_ValidateModifiedFiles(t *testing.T) {
+	state := &SystemState{
+		ModifiedFiles: ModifiedFilesPolicy{
+			Default: "keep",
+			Paths: []ModifiedFileRule{
+				{Path: "/etc/ssh/*", Policy: ModifiedWarn},
+				{Path: "", Policy: "delete"},
+			},
+		},
+	}
+
+	errs := state.Validate()
+
+	assert.Len(t, errs, 3)
+	assert.Equal(t, "modified-files.default", errs[0].Field)
+	assert.Equal(t, "modified-files.paths[1].path", errs[1].Field)
+	assert.Equal(t, "modified-files.paths[1].policy", errs[2].Field)
+}
+
+func TestSystemState_ValidatePackageIndexUpdate(t *testing.T) {
+	state := &SystemState{PackageIndexUpdate: "daily"}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, "package-index-update", errs[0].Field)
+	state.PackageIndexUpdate = IndexUpdateIfStale
+	assert.Empty(t, state.Validate())
+}
+
+func TestSystemState_ValidateVirtualPackages(t *testing.T) {
+	state := &SystemState{
+		VirtualPackages: []VirtualPackageState{
+			{Name: ".build-deps", Packages: []string{"gcc", "make"}},
+			{Name: "build-deps", Packages: []string{"gcc"}},
+			{Name: ".build-deps"},
+		},
+	}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 3)
+	assert.Equal(t, "virtual-packages[1].name", errs[0].Field)
+	assert.Equal(t, "virtual-packages[2].name", errs[1].Field)
+	assert.Equal(t, "virtual-packages[2].packages", errs[2].Field)
+	assert.True(t, state.VirtualPackages[0].Groups([]string{"make", "gcc"}))
+	assert.False(t, state.VirtualPackages[0].Groups([]string{"gcc"}))
+}
+
+func TestSystemState_ValidateProxy(t *testing.T) {
+	state := &SystemState{Proxy: &ProxyState{HTTP: "proxy.example.com:3128", HTTPS: "http://proxy.example.com:3129", NoProxy: []string{"localhost", "a,b"}}}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 2)
+	assert.Equal(t, "proxy.http", errs[0].Field)
+	assert.Equal(t, "proxy.no-proxy[1]", errs[1].Field)
+}
+
+func TestProxyState_Env(t *testing.T) {
+	proxy := &ProxyState{HTTP: "http://proxy:3128", NoProxy: []string{"localhost", ".internal"}}
+
+	assert.Equal(t, []string{
+		"http_proxy=http://proxy:3128", "HTTP_PROXY=http://proxy:3128",
+		"https_proxy=http://proxy:3128", "HTTPS_PROXY=http://proxy:3128",
+		"no_proxy=localhost,.internal", "NO_PROXY=localhost,.internal",
+	}, proxy.Env())
+	assert.Empty(t, (*ProxyState)(nil).Env())
+}
+
+func TestSystemState_ValidateCommands(t *testing.T) {
+	state := &SystemState{Commands: []CommandState{
+		{Name: "dhparam", Command: "openssl dhparam -out /etc/ssl/dh.pem 2048", Creates: "/etc/ssl/dh.pem", Timeout: "10m"},
+		{Name: "dhparam", Command: "true", Creates: "dh.pem"},
+		{Name: "slow", Command: " ", User: "Bad User", Timeout: "soon"},
+		{Name: "token", Command: "app login --token ${env:API_TOKEN}", Unless: "test -n \"$API_TOKEN\""},
+	}}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 6)
+	assert.Equal(t, "commands[1].name", errs[0].Field)
+	assert.Equal(t, "commands[1].creates", errs[1].Field)
+	assert.Equal(t, "commands[2].command", errs[2].Field)
+	assert.Equal(t, "commands[2].user", errs[3].Field)
+	assert.Equal(t, "commands[2].timeout", errs[4].Field)
+	assert.Equal(t, "commands[3].command", errs[5].Field)
+	assert.Contains(t, errs[5].Message, "use $VAR in commands")
+	assert.Equal(t, 10*time.Minute, state.Commands[0].TimeoutDuration())
+	assert.Equal(t, DefaultCommandTimeout, state.Commands[2].TimeoutDuration())
+}
+
+func TestSystemState_ValidateHooks(t *testing.T) {
+	state := &SystemState{Hooks: &HooksState{PreApply: []string{"rc-service app stop"}, PostApply: []string{"curl -H \"Token: ${env:TOKEN}\" $URL"}, OnFailure: []string{"notify", " "}}}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 2)
+	assert.Equal(t, "hooks.post-apply[0]", errs[0].Field)
+	assert.Equal(t, "hooks.on-failure[1]", errs[1].Field)
+}
+
+func TestSystemState_RenderTemplates(t *testing.T) {
+	state := &SystemState{
+		Vars:     map[string]any{"port": 80, "domain": "example.com"},
+		HostVars: map[string]map[string]any{"web1": {"port": 8080}},
+		Configs: []SystemConfigState{
+			{Path: "/etc/nginx/http.d/site.conf", Template: true, Content: "listen {{ .Facts.IPv4 }}:{{ .Vars.port }};\nserver_name {{ .Facts.Hostname }}.{{ .Vars.domain }};\n"},
+			{Path: "/etc/motd", Content: "literal {{ braces }}\n"},
+		},
+	}
+
+	err := state.RenderTemplates(Facts{Hostname: "web1", IPv4: "10.0.0.5"})
+
+	require.NoError(t, err)
+	assert.Equal(t, "listen 10.0.0.5:8080;\nserver_name web1.example.com;\n", state.Configs[0].Content)
+	assert.Equal(t, "literal {{ braces }}\n", state.Configs[1].Content, "non-template content is left alone")
+}
+
+func TestSystemState_RenderTemplatesMissingVar(t *testing.T) {
+	state := &SystemState{
+		Configs: []SystemConfigState{{Path: "/etc/motd", Template: true, Content: "{{ .Vars.missing }}"}},
+	}
+
+	err := state.RenderTemplates(Facts{})
+
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to render template for /etc/motd")
+}
+
+func TestSystemState_ValidateTemplate(t *testing.T) {
+	state := &SystemState{
+		Configs: []SystemConfigState{{Path: "/etc/motd", Template: true, Content: "{{ .Vars.port "}},
+	}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, "configs[0].content", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "invalid template")
+}
+
+func TestSystemState_ValidateApplyWindows(t *testing.T) {
+	state := &SystemState{ApplyWindows: []string{"* 2-4 * * 6", "* 25 * * *"}}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, "apply-windows[1]", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "invalid hour '25'")
+}
+
+func TestSystemState_ValidateSecrets(t *testing.T) {
+	tests := []struct {
+		secrets *SecretsConfig
+		field   string
+	}{
+		{&SecretsConfig{Provider: "env"}, ""},
+		{&SecretsConfig{Provider: "file", Dir: "/run/secrets"}, ""},
+		{&SecretsConfig{Provider: "file", Dir: "secrets"}, "secrets.dir"},
+		{&SecretsConfig{Provider: "command", Command: "pass show"}, ""},
+		{&SecretsConfig{Provider: "command"}, "secrets.command"},
+		{&SecretsConfig{Provider: "vault"}, "secrets.provider"},
+	}
+	for _, tt := range tests {
+		errs := (&SystemState{Secrets: tt.secrets}).Validate()
+		if tt.field == "" {
+			assert.Empty(t, errs, tt.secrets.Provider)
+			continue
+		}
+		require.Len(t, errs, 1)
+		assert.Equal(t, tt.field, errs[0].Field)
+	}
+}
+
+func TestParseCrontab_RoundTrip(t *testing.T) {
+	jobs := []CronJobState{
+		{Name: "backup", Schedule: "0  3 * * *", Command: "/usr/local/bin/backup  --all"},
+		{Schedule: "@reboot", Command: "start-tunnel"},
+	}
+	crontab := "MAILTO=ops\n*/5 * * * * unmanaged\n# BEGIN summit\n" + RenderCrontab(jobs) + "# END summit\n"
+
+	parsed := ParseCrontab(crontab)
+
+	assert.Equal(t, []CronJobState{
+		{Name: "backup", Schedule: "0 3 * * *", Command: "/usr/local/bin/backup  --all"},
+		{Schedule: "@reboot", Command: "start-tunnel"},
+	}, parsed)
+	assert.Equal(t, RenderCrontab(jobs), RenderCrontab(parsed))
+	assert.Nil(t, ParseCrontab("*/5 * * * * unmanaged\n"))
+}
+
+func TestSystemState_ValidateCrontab(t *testing.T) {
+	state := &SystemState{Users: []UserState{{Name: "alice", Crontab: []CronJobState{
+		{Schedule: "0 3 * * *", Command: "backup.sh"},
+		{Schedule: "@fortnightly", Command: "report.sh"},
+		{Schedule: "0 3 * *", Command: ""},
+	}}}}
+
+	errs := state.Validate()
+
+	require.Len(t, errs, 3)
+	assert.Equal(t, "users[0].crontab[1].schedule", errs[0].Field)
+	assert.Equal(t, "users[0].crontab[2].schedule", errs[1].Field)
+	assert.Equal(t, "users[0].crontab[2].command", errs[2].Field)
+}
+
+func TestSystemState_CheckRoles(t *testing.T) {
+	state := &SystemState{
+		Vars:     map[string]any{"domain": "example.com"},
+		HostVars: map[string]map[string]any{"db1": {"replicas": 2}},
+		Roles: []RoleState{
+			{Name: "web", File: "roles/web.yaml", RequiredVars: []string{"domain"}},
+			{Name: "db", File: "roles/db.yaml", RequiredVars: []string{"replicas"}, Arches: []string{"x86_64"}},
+		},
+	}
+	require.True(t, state.NeedsFacts())
+
+	assert.Empty(t, state.CheckRoles(Facts{Hostname: "db1", Arch: "x86_64"}))
+
+	errs := state.CheckRoles(Facts{Hostname: "web1", Arch: "aarch64"})
+	require.Len(t, errs, 2)
+	assert.Equal(t, "role db (roles/db.yaml)", errs[0].Field)
+	assert.Equal(t, "required var 'replicas' is not set for host 'web1'", errs[0].Message)
+	assert.Equal(t, "architecture 'aarch64' is not supported (supported: x86_64)", errs[1].Message)
+
+	invalid := &SystemState{Roles: []RoleState{{Name: "", File: "roles/x.yaml"}}}
+	require.Len(t, invalid.Validate(), 1)
+}
+
+func TestEvalWhen(t *testing.T) {
+	data := TemplateData{
+		Vars:  map[string]any{"role": "laptop", "gpu": true, "db": map[string]any{"port": 5432}},
+		Facts: Facts{Arch: "aarch64", AlpineVersion: "3.20.3", MemoryMB: 2048, Virtualization: "none"},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`facts.arch == "aarch64"`, true},
+		{`facts.arch != 'aarch64'`, false},
+		{`facts.alpine_version >= 3.19`, true},
+		{`facts.alpine_version < 3.20.1`, false},
+		{`facts.memory_mb > 512 && facts.memory_mb <= 4096`, true},
+		{`vars.role == "server" || facts.virtualization == "none"`, true},
+		{`!(vars.gpu && vars.role == "laptop")`, false},
+		{`vars.db.port == 5432`, true},
+		{`vars.gpu`, true},
+		{`false || !true`, false},
+	}
+	for _, tt := range tests {
+		got, err := EvalWhen(tt.expr, data)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, got, tt.expr)
+	}
+
+	_, err := EvalWhen(`vars.missing == "x"`, data)
+	assert.EqualError(t, err, "var 'missing' is not set")
+	for expr, msg := range map[string]string{
+		`facts.cpu == "x"`:     "unknown fact 'cpu'",
+		`arch == "x"`:          "unknown name 'arch'; use facts.<name>, vars.<name> or a quoted string",
+		`facts.arch == `:       "unexpected end of expression",
+		`(facts.arch == "x"`:   "missing )",
+		`facts.arch = "x"`:     "unexpected character '='",
+		`facts.arch == "x`:     "unterminated string at offset 14",
+		`facts.arch "x"`:       "unexpected x",
+		`facts.arch == "x" &&`: "unexpected end of expression",
+	} {
+		_, err := ParseWhen(expr)
+		assert.EqualError(t, err, msg, expr)
+	}
+}
+
+func TestSystemState_ResolveConditions(t *testing.T) {
+	state := &SystemState{
+		Vars:     map[string]any{"kind": "server"},
+		HostVars: map[string]map[string]any{"laptop1": {"kind": "laptop"}},
+		Packages: []PackageState{{Name: "htop"}, {Name: "tlp", When: `vars.kind == "laptop"`}},
+		Services: []ServiceState{{Name: "tlp", Enabled: true, Runlevel: "default", When: `vars.kind == "laptop"`}},
+		Configs:  []SystemConfigState{{Path: "/etc/motd", Content: "server", When: `vars.kind != "laptop"`}},
+		Users:    []UserState{{Name: "alice"}, {Name: "pi", When: `facts.arch == "aarch64"`}},
+	}
+	require.True(t, state.NeedsFacts())
+	require.Empty(t, state.Validate())
+
+	require.Empty(t, state.ResolveConditions(Facts{Hostname: "laptop1", Arch: "x86_64"}))
+	assert.Equal(t, []PackageState{{Name: "htop"}, {Name: "tlp", When: `vars.kind == "laptop"`}}, state.Packages)
+	assert.Len(t, state.Services, 1)
+	assert.Empty(t, state.Configs)
+	assert.Equal(t, []UserState{{Name: "alice"}}, state.Users)
+
+	failing := &SystemState{Packages: []PackageState{{Name: "tlp", When: `vars.kind == "laptop"`}}}
+	errs := failing.ResolveConditions(Facts{})
+	require.Len(t, errs, 1)
+	assert.Equal(t, "packages[0].when", errs[0].Field)
+	assert.Equal(t, "var 'kind' is not set", errs[0].Message)
+	assert.Len(t, failing.Packages, 1)
+
+	invalid := &SystemState{Services: []ServiceState{{Name: "tlp", When: `facts.arch ==`}}}
+	errs = invalid.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "services[0].when", errs[0].Field)
+	assert.Equal(t, "invalid when expression: unexpected end of expression", errs[0].Message)
+}
+
+func TestSystemState_ValidateWants(t *testing.T) {
+	state := &SystemState{
+		Packages: []PackageState{{Name: "nginx", Wants: []string{"config:/etc/nginx/nginx.conf"}}},
+		Services: []ServiceState{{Name: "app", Wants: []string{"service:nginx", "service:absent"}}},
+		Users:    []UserState{{Name: "deploy", Wants: []string{"nginx"}}},
+		Configs:  []SystemConfigState{{Path: "/etc/app.conf", Wants: []string{"file:/etc/hosts"}}},
+	}
+	errs := state.Validate()
+	require.Len(t, errs, 2)
+	assert.Equal(t, "users[0].wants[0]", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "must be <kind>:<name>")
+	assert.Equal(t, "configs[0].wants[0]", errs[1].Field)
+	assert.Contains(t, errs[1].Message, "kind must be one of: package, service, user, config")
+}
+
+func TestSystemState_TimezoneAndLocale(t *testing.T) {
+	state := &SystemState{Timezone: "America/Argentina/Buenos_Aires", Locale: "C.UTF-8"}
+	assert.Empty(t, state.Validate())
+	assert.Equal(t, []SystemConfigState{
+		{Path: TimezonePath, Content: "America/Argentina/Buenos_Aires\n"},
+		{Path: LocalePath, Content: "# Managed by summit\nexport LANG=C.UTF-8\n"},
+	}, state.GeneratedConfigs())
+	assert.Equal(t, []PackageState{{Name: "tzdata"}}, state.ImplicitPackages(), "C.UTF-8 needs no extra locales")
+
+	state.Locale = "it_IT.UTF-8"
+	assert.Equal(t, []PackageState{{Name: "tzdata"}, {Name: "musl-locales"}}, state.ImplicitPackages())
+	state.Packages = []PackageState{{Name: "tzdata"}}
+	assert.Equal(t, []PackageState{{Name: "musl-locales"}}, state.ImplicitPackages())
+
+	state.Timezone = "../../etc/shadow"
+	state.Locale = "en US"
+	state.Configs = []SystemConfigState{{Path: TimezonePath}, {Path: LocalePath}}
+	errs := state.Validate()
+	require.Len(t, errs, 4)
+	fields := []string{errs[0].Field, errs[1].Field, errs[2].Field, errs[3].Field}
+	assert.Equal(t, []string{"timezone", "locale", "configs[0].path", "configs[1].path"}, fields)
+}
+
+func TestSystemState_ValidateRemovalLimits(t *testing.T) {
+	zero, negative := 0, -1
+	state := &SystemState{Limits: &LimitsState{MaxPackageRemovals: &zero, MaxFileDeletions: &negative}}
+	assert.Equal(t, []RemovalLimit{
+		{Key: "max-package-removals", Noun: "package removal(s)", Max: 0},
+		{Key: "max-file-deletions", Noun: "file deletion(s)", Max: -1},
+	}, state.Limits.RemovalLimits())
+	errs := state.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "limits.max-file-deletions", errs[0].Field)
+
+	var unset *LimitsState
+	assert.Empty(t, unset.RemovalLimits())
+}
+
+func TestSystemState_ValidateUserAttributes(t *testing.T) {
+	state := &SystemState{Users: []UserState{
+		{Name: "alice", UID: 1500, Shell: "/bin/bash", Home: "/srv/alice", Gecos: "Alice Liddell"},
+		{Name: "bob", UID: 1500, Shell: "bash", Home: "home/bob", Gecos: "Bob:Builder"},
+		{Name: "carol", UID: 100, Shell: "/sbin/nologin"},
+	}}
+	var fields []string
+	for _, err := range state.Validate() {
+		fields = append(fields, err.Field+": "+err.Message)
+	}
+	assert.Equal(t, []string{
+		"users[1].shell: shell must be an absolute path",
+		"users[1].home: home must be an absolute path",
+		"users[1].gecos: gecos cannot contain ':' or newlines",
+		"users[2].uid: uid 100 is below 1000; system accounts are not managed",
+		"users[2].shell: users without a login shell are not managed",
+		"users[1].uid: uid 1500 is already used by user alice",
+	}, fields)
+}
+
+func TestSystemState_ValidateGroups(t *testing.T) {
+	state := &SystemState{Groups: []GroupState{
+		{Name: "devs", GID: 1500},
+		{Name: "docker", GID: 101, System: true},
+		{Name: "devs"},
+		{Name: "ops", GID: 1500},
+		{Name: "audit", GID: 50},
+		{Name: "backup", GID: 2000, System: true},
+		{Name: "Bad Name"},
+	}}
+	var fields []string
+	for _, err := range state.Validate() {
+		fields = append(fields, err.Field+": "+err.Message)
+	}
+	assert.Equal(t, []string{
+		"groups[2].name: group 'devs' is declared more than once",
+		"groups[3].gid: gid 1500 is already used by group devs",
+		"groups[4].gid: gid 50 is in the system range; set system: true or use a gid from 1000",
+		"groups[5].gid: system groups take gids below 1000",
+		"groups[6].name: group name cannot be empty and contains only lowercase letters, numbers, hyphens and underscores",
+	}, fields)
+}
+
+func TestParseNotify(t *testing.T) {
+	var cfg SystemConfigState
+	require.NoError(t, yaml.Unmarshal([]byte("path: /etc/ssh/sshd_config\nnotify: restart sshd\n"), &cfg))
+	assert.Equal(t, NotifyList{"restart sshd"}, cfg.Notify)
+
+	for entry, want := range map[string][2]string{"nginx": {"", "nginx"}, "restart sshd": {"restart", "sshd"}, "reload  nginx": {"reload", "nginx"}} {
+		how, service, err := ParseNotify(entry)
+		require.NoError(t, err, entry)
+		assert.Equal(t, want, [2]string{how, service}, entry)
+	}
+	for _, entry := range []string{"", "restart", "stop sshd", "restart sshd now", "nginx;reboot", "restart $(id)", "-v"} {
+		_, _, err := ParseNotify(entry)
+		assert.Error(t, err, entry)
+	}
+}
+
+func TestSystemState_ValidatePackageVersions(t *testing.T) {
+	state := &SystemState{Packages: []PackageState{
+		{Name: "nginx", Version: "1.24.0-r7"},
+		{Name: "musl", Version: "1.2.4_git20230717-r4"},
+		{Name: "htop", Version: ">=3"},
+		{Name: "vim", Version: "latest"},
+	}}
+	var fields []string
+	for _, err := range state.Validate() {
+		fields = append(fields, err.Field+": "+err.Message)
+	}
+	assert.Equal(t, []string{
+		"packages[2].version: invalid version '>=3', must be an apk version like 1.2.4-r0",
+		"packages[3].version: invalid version 'latest', must be an apk version like 1.2.4-r0",
+	}, fields)
+}
+
+func TestSystemState_ValidateUserConfigs(t *testing.T) {
+	state := &SystemState{UserConfigs: []UserConfigState{
+		{User: "alice", Path: ".vimrc"},
+		{User: "alice", Path: ".config/git/config", Mode: "0600"},
+		{User: "alice", Path: ".vimrc"},
+		{User: "bob", Path: "/etc/motd"},
+		{User: "bob", Path: "../alice/.ssh/authorized_keys"},
+		{User: "bob", Path: "./.profile"},
+		{User: "", Path: ".profile", Mode: "999"},
+	}}
+	var fields []string
+	for _, err := range state.Validate() {
+		fields = append(fields, err.Field+": "+err.Message)
+	}
+	assert.Equal(t, []string{
+		"user-configs[2].path: .vimrc is declared more than once for user alice",
+		"user-configs[3].path: path must be relative to the user's home, e.g. '.vimrc'",
+		"user-configs[4].path: path must be relative to the user's home, e.g. '.vimrc'",
+		"user-configs[5].path: path must be in its clean form '.profile'",
+		"user-configs[6].user: user name cannot be empty and contains only lowercase letters, numbers, hyphens and underscores",
+		"user-configs[6].mode: mode must be a valid octal value like '0600' or '0644'",
+	}, fields)
+}
+
+func TestAuthorizedKeys(t *testing.T) {
+	id, ok := AuthorizedKeyID(`from="10.0.0.0/8",no-pty ssh-ed25519 AAAAC3Nza alice@laptop`)
+	require.True(t, ok)
+	assert.Equal(t, "ssh-ed25519 AAAAC3Nza", id)
+	_, ok = AuthorizedKeyID("not a key")
+	assert.False(t, ok)
+	assert.Equal(t, "alice@laptop", AuthorizedKeyLabel("ssh-ed25519 AAAAC3Nza alice@laptop"))
+
+	managed, unmanaged := ParseAuthorizedKeys("ssh-rsa AAAAold bob\n# BEGIN summit\nssh-ed25519 AAAAci ci\n# END summit\n\n# a comment\necdsa-sha2-nistp256 AAAAecdsa\n")
+	assert.Equal(t, []string{"ssh-ed25519 AAAAci ci"}, managed)
+	assert.Equal(t, []string{"ssh-rsa AAAAold bob", "ecdsa-sha2-nistp256 AAAAecdsa"}, unmanaged)
+
+	state := &SystemState{Users: []UserState{{Name: "alice", AuthorizedKeys: []string{"ssh-ed25519 AAAAC3Nza", "AAAAC3Nza"}}}}
+	errs := state.Validate()
+	require.Len(t, errs, 1)
+	assert.Equal(t, "users[0].authorized-keys[1]", errs[0].Field)
+}
+
+func TestSchema(t *testing.T) {
+	schema := Schema()
+	properties := schema["properties"].(map[string]any)
+
+	// Every key of the config is described, and only those
+	assert.Contains(t, properties, "packages")
+	assert.Contains(t, properties, "host-overlays")
+	assert.NotContains(t, properties, "LoadWarnings")
+	assert.Equal(t, false, schema["additionalProperties"])
+
+	// Shorthands accepted by UnmarshalYAML are allowed
+	plugins := properties["plugins"].(map[string]any)["items"].(map[string]any)
+	assert.Equal(t, []any{map[string]any{"type": "string"}, map[string]any{"$ref": "#/$defs/PluginState"}}, plugins["anyOf"])
+
+	// Profiles are configs themselves
+	assert.Equal(t, map[string]any{"$ref": "#"}, properties["profiles"].(map[string]any)["additionalProperties"])
+
+	configs := schema["$defs"].(map[string]any)["SystemConfigState"].(map[string]any)["properties"].(map[string]any)
+	assert.Contains(t, configs, "sensitive")
+	assert.Equal(t, map[string]any{"type": "boolean"}, configs["sensitive"])
 }
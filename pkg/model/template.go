@@ -0,0 +1,83 @@
+package model
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// Facts describes the host a config is being applied to. They are gathered
+// once per run and exposed to config templates as .Facts.
+type Facts struct {
+	Hostname       string   // Short hostname, e.g. "web1"
+	IPv4           string   // First global IPv4 address, without prefix length
+	IPv4Addresses  []string // All global IPv4 addresses
+	Arch           string   // apk architecture, e.g. "x86_64" or "aarch64"
+	AlpineVersion  string   // Contents of /etc/alpine-release, e.g. "3.20.3"
+	MemoryMB       int      // Total memory in MiB
+	Virtualization string   // "none" on bare metal, else e.g. "kvm", "vmware", "docker" or "lxc"
+}
+
+// TemplateData is the value config templates are executed against.
+type TemplateData struct {
+	Vars  map[string]any
+	Facts Facts
+}
+
+// ParseTemplate parses config content as a Go text/template. Missing map keys
+// are errors so that a typo in a variable name fails the plan instead of
+// writing "<no value>" to disk.
+func ParseTemplate(name, content string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(content)
+}
+
+// TemplateVars returns vars with the host-vars entry for hostname layered on
+// top, so per-host values override fleet-wide defaults key by key.
+func (s *SystemState) TemplateVars(hostname string) map[string]any {
+	vars := make(map[string]any, len(s.Vars))
+	for k, v := range s.Vars {
+		vars[k] = v
+	}
+	for k, v := range s.HostVars[hostname] {
+		vars[k] = v
+	}
+	return vars
+}
+
+// RenderTemplates replaces the content of every config marked as a template
+// with its rendered output. It is called at plan time, after includes are
+// merged and facts are gathered, so the plan shows the final file content.
+func (s *SystemState) RenderTemplates(facts Facts) error {
+	data := TemplateData{Vars: s.TemplateVars(facts.Hostname), Facts: facts}
+	for i := range s.Configs {
+		cfg := &s.Configs[i]
+		if !cfg.Template {
+			continue
+		}
+		tmpl, err := ParseTemplate(cfg.Path, cfg.Content)
+		if err != nil {
+			return fmt.Errorf("failed to parse template for %s: %w", cfg.Path, err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return fmt.Errorf("failed to render template for %s: %w", cfg.Path, err)
+		}
+		cfg.Content = out.String()
+	}
+	for i := range s.UserConfigs {
+		cfg := &s.UserConfigs[i]
+		if !cfg.Template {
+			continue
+		}
+		tmpl, err := ParseTemplate(cfg.Key(), cfg.Content)
+		if err != nil {
+			return fmt.Errorf("failed to parse template for %s: %w", cfg.Key(), err)
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, data); err != nil {
+			return fmt.Errorf("failed to render template for %s: %w", cfg.Key(), err)
+		}
+		cfg.Content = out.String()
+	}
+	return nil
+}
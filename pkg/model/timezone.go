@@ -0,0 +1,97 @@
+package model
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Files the timezone and locale settings are written to.
+const (
+	LocaltimePath = "/etc/localtime"
+	TimezonePath  = "/etc/timezone"
+	ZoneinfoDir   = "/usr/share/zoneinfo"
+	// LocalePath sorts after the locale.sh of musl-locales, so its LANG wins
+	LocalePath = "/etc/profile.d/summit-locale.sh"
+)
+
+var (
+	timezoneRegex = regexp.MustCompile(`^[A-Za-z0-9_+-]+(/[A-Za-z0-9_+-]+)*$`)
+	localeRegex   = regexp.MustCompile(`^[A-Za-z0-9_.@-]+$`)
+)
+
+// builtinLocales are available without musl-locales.
+var builtinLocales = map[string]bool{"C": true, "POSIX": true, "C.UTF-8": true}
+
+// TimezoneFile returns the /etc/timezone config naming the desired zone, and
+// false when the config sets no timezone.
+func (s *SystemState) TimezoneFile() (SystemConfigState, bool) {
+	if s.Timezone == "" {
+		return SystemConfigState{}, false
+	}
+	return SystemConfigState{Path: TimezonePath, Content: s.Timezone + "\n"}, true
+}
+
+// LocaleFile returns the profile.d script exporting the desired locale, and
+// false when the config sets no locale.
+func (s *SystemState) LocaleFile() (SystemConfigState, bool) {
+	if s.Locale == "" {
+		return SystemConfigState{}, false
+	}
+	return SystemConfigState{Path: LocalePath, Content: fmt.Sprintf("# Managed by summit\nexport LANG=%s\n", s.Locale)}, true
+}
+
+// GeneratedConfigs returns the files summit writes on behalf of sections
+// other than configs: the sysctl fragment, /etc/timezone and the locale
+// script.
+func (s *SystemState) GeneratedConfigs() []SystemConfigState {
+	var generated []SystemConfigState
+	for _, file := range []func() (SystemConfigState, bool){s.SysctlFragment, s.TimezoneFile, s.LocaleFile} {
+		if cfg, ok := file(); ok {
+			generated = append(generated, cfg)
+		}
+	}
+	return generated
+}
+
+// ImplicitPackages returns the packages the timezone and locale settings
+// need, unless the config already declares them.
+func (s *SystemState) ImplicitPackages() []PackageState {
+	declared := make(map[string]bool)
+	for _, p := range s.Packages {
+		declared[p.Name] = true
+	}
+	var implicit []PackageState
+	need := func(name string) {
+		if !declared[name] {
+			declared[name] = true
+			implicit = append(implicit, PackageState{Name: name})
+		}
+	}
+	if s.Timezone != "" {
+		need("tzdata")
+	}
+	if s.Locale != "" && !builtinLocales[s.Locale] {
+		need("musl-locales")
+	}
+	return implicit
+}
+
+func (s *SystemState) validateTimezoneAndLocale() ValidationErrors {
+	var errs ValidationErrors
+	if s.Timezone != "" && (!timezoneRegex.MatchString(s.Timezone) || strings.Contains(s.Timezone, "..")) {
+		errs = append(errs, ValidationError{Field: "timezone", Message: fmt.Sprintf("invalid timezone '%s', must be a zone name like 'Europe/Rome' or 'UTC'", s.Timezone)})
+	}
+	if s.Locale != "" && !localeRegex.MatchString(s.Locale) {
+		errs = append(errs, ValidationError{Field: "locale", Message: fmt.Sprintf("invalid locale '%s', must be a name like 'en_US.UTF-8'", s.Locale)})
+	}
+	for i, cfg := range s.Configs {
+		switch {
+		case s.Timezone != "" && cfg.Path == TimezonePath:
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].path", i), Message: fmt.Sprintf("%s is written from the timezone setting", cfg.Path)})
+		case s.Locale != "" && cfg.Path == LocalePath:
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("configs[%d].path", i), Message: fmt.Sprintf("%s is written from the locale setting", cfg.Path)})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,86 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// UserConfigState is a file in a user's home directory, such as a dotfile.
+// It is written like a config, but its path is relative to the home and it
+// belongs to the user unless Owner or Group say otherwise.
+type UserConfigState struct {
+	User      string `yaml:"user"`
+	Path      string `yaml:"path"` // Relative to the user's home, e.g. .config/git/config
+	Content   string `yaml:"content"`
+	Mode      string `yaml:"mode,omitempty"`
+	Owner     string `yaml:"owner,omitempty"` // The user when unset
+	Group     string `yaml:"group,omitempty"` // The user's primary group when unset
+	Template  bool   `yaml:"template,omitempty"`
+	Sensitive bool   `yaml:"sensitive,omitempty"` // Content and diffs are redacted from plans, JSON output and dumps
+	Team      string `yaml:"team,omitempty"`
+
+	// PrimaryGroup is the primary group of the user on the system, the group
+	// an inferred file has when Group is unset.
+	PrimaryGroup string `yaml:"-" json:"-"`
+}
+
+// Key identifies the file among the user configs, e.g. "alice:.vimrc".
+func (c UserConfigState) Key() string {
+	return c.User + ":" + c.Path
+}
+
+// OmitDefaultOwnership clears the owner and group of an inferred file when
+// they are the user and its primary group, as a config would leave them.
+func (c *UserConfigState) OmitDefaultOwnership() {
+	if c.Owner == c.User {
+		c.Owner = ""
+	}
+	if c.Group == c.PrimaryGroup {
+		c.Group = ""
+	}
+}
+
+// validateUserConfigs checks the user-configs section: paths must stay inside
+// the home directory and each file is declared once.
+func (s *SystemState) validateUserConfigs() ValidationErrors {
+	var errs ValidationErrors
+	seen := make(map[string]bool)
+	for i, c := range s.UserConfigs {
+		field := fmt.Sprintf("user-configs[%d]", i)
+		if strings.TrimSpace(c.User) == "" || !isValidUserName(c.User) {
+			errs = append(errs, ValidationError{Field: field + ".user", Message: "user name cannot be empty and contains only lowercase letters, numbers, hyphens and underscores"})
+		}
+		clean := path.Clean(c.Path)
+		switch {
+		case strings.TrimSpace(c.Path) == "":
+			errs = append(errs, ValidationError{Field: field + ".path", Message: "path cannot be empty"})
+		case path.IsAbs(c.Path) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../"):
+			errs = append(errs, ValidationError{Field: field + ".path", Message: "path must be relative to the user's home, e.g. '.vimrc'"})
+		case clean != c.Path:
+			errs = append(errs, ValidationError{Field: field + ".path", Message: fmt.Sprintf("path must be in its clean form '%s'", clean)})
+		}
+		if seen[c.Key()] {
+			errs = append(errs, ValidationError{Field: field + ".path", Message: fmt.Sprintf("%s is declared more than once for user %s", c.Path, c.User)})
+		}
+		seen[c.Key()] = true
+		if c.Mode != "" && !isValidOctalMode(c.Mode) {
+			errs = append(errs, ValidationError{Field: field + ".mode", Message: "mode must be a valid octal value like '0600' or '0644'"})
+		}
+		if c.Owner != "" && !isValidUserName(c.Owner) {
+			errs = append(errs, ValidationError{Field: field + ".owner", Message: "owner contains invalid characters"})
+		}
+		if c.Group != "" && !isValidUserName(c.Group) {
+			errs = append(errs, ValidationError{Field: field + ".group", Message: "group contains invalid characters"})
+		}
+		if c.Template {
+			if _, err := ParseTemplate(c.Key(), c.Content); err != nil {
+				errs = append(errs, ValidationError{Field: field + ".content", Message: fmt.Sprintf("invalid template: %v", err)})
+			}
+		}
+		if err := s.Limits.CheckContent(c.Content); err != nil {
+			errs = append(errs, ValidationError{Field: field + ".content", Message: err.Error()})
+		}
+	}
+	return errs
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// MinUserUID is the lowest uid of the users summit manages; lower uids
+// belong to system accounts and are not inferred from /etc/passwd.
+const MinUserUID = 1000
+
+// validateUserAttributes checks the /etc/passwd fields set on the i-th user.
+func validateUserAttributes(i int, user UserState) ValidationErrors {
+	var errs ValidationErrors
+	if user.UID != 0 && user.UID < MinUserUID {
+		errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].uid", i), Message: fmt.Sprintf("uid %d is below %d; system accounts are not managed", user.UID, MinUserUID)})
+	}
+	if user.Shell != "" {
+		switch {
+		case !isPasswdField(user.Shell) || !path.IsAbs(user.Shell):
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].shell", i), Message: "shell must be an absolute path"})
+		case strings.Contains(user.Shell, "nologin"):
+			// listUsers skips users without a login shell, so the user
+			// would look missing on every run
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].shell", i), Message: "users without a login shell are not managed"})
+		}
+	}
+	if user.Home != "" && (!isPasswdField(user.Home) || !path.IsAbs(user.Home)) {
+		errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].home", i), Message: "home must be an absolute path"})
+	}
+	if !isPasswdField(user.Gecos) {
+		errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].gecos", i), Message: "gecos cannot contain ':' or newlines"})
+	}
+	return errs
+}
+
+// validateUniqueUIDs reports users declared with the uid of another user.
+func (s *SystemState) validateUniqueUIDs() ValidationErrors {
+	var errs ValidationErrors
+	owners := make(map[int]string)
+	for i, user := range s.Users {
+		if user.UID == 0 {
+			continue
+		}
+		if owner, ok := owners[user.UID]; ok {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("users[%d].uid", i), Message: fmt.Sprintf("uid %d is already used by user %s", user.UID, owner)})
+			continue
+		}
+		owners[user.UID] = user.Name
+	}
+	return errs
+}
+
+// isPasswdField reports whether value can be stored in a field of
+// /etc/passwd.
+func isPasswdField(value string) bool {
+	return !strings.ContainsAny(value, ":\n")
+}
+
+// validateGroups checks the groups section: valid names and gids in the
+// range matching the system flag, with no name or gid declared twice.
+func (s *SystemState) validateGroups() ValidationErrors {
+	var errs ValidationErrors
+	names := make(map[string]bool)
+	owners := make(map[int]string)
+	for i, group := range s.Groups {
+		field := fmt.Sprintf("groups[%d]", i)
+		if strings.TrimSpace(group.Name) == "" || !isValidUserName(group.Name) {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: "group name cannot be empty and contains only lowercase letters, numbers, hyphens and underscores"})
+		} else if names[group.Name] {
+			errs = append(errs, ValidationError{Field: field + ".name", Message: fmt.Sprintf("group '%s' is declared more than once", group.Name)})
+		}
+		names[group.Name] = true
+
+		switch {
+		case group.GID < 0:
+			errs = append(errs, ValidationError{Field: field + ".gid", Message: "gid cannot be negative"})
+		case group.GID == 0:
+		case group.System && group.GID >= MinUserUID:
+			errs = append(errs, ValidationError{Field: field + ".gid", Message: fmt.Sprintf("system groups take gids below %d", MinUserUID)})
+		case !group.System && group.GID < MinUserUID:
+			errs = append(errs, ValidationError{Field: field + ".gid", Message: fmt.Sprintf("gid %d is in the system range; set system: true or use a gid from %d", group.GID, MinUserUID)})
+		}
+		if group.GID > 0 {
+			if owner, ok := owners[group.GID]; ok {
+				errs = append(errs, ValidationError{Field: field + ".gid", Message: fmt.Sprintf("gid %d is already used by group %s", group.GID, owner)})
+			} else {
+				owners[group.GID] = group.Name
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WantKinds are the resource kinds a wants entry can refer to.
+var WantKinds = []string{"package", "service", "user", "config"}
+
+// ParseWant splits a soft dependency such as "service:nginx" or
+// "config:/etc/nginx/nginx.conf" into its kind and name. A resource that
+// wants another is converged after it when both are in the plan; unlike the
+// dependencies checked before planning, a wanted resource the config does not
+// declare is not an error.
+func ParseWant(want string) (kind, name string, err error) {
+	kind, name, ok := strings.Cut(want, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return "", "", fmt.Errorf("invalid want '%s', must be <kind>:<name> like 'service:nginx'", want)
+	}
+	for _, k := range WantKinds {
+		if k == kind {
+			return kind, name, nil
+		}
+	}
+	return "", "", fmt.Errorf("invalid want '%s', kind must be one of: %s", want, strings.Join(WantKinds, ", "))
+}
+
+func validateWants(field string, wants []string) ValidationErrors {
+	var errs ValidationErrors
+	for i, want := range wants {
+		if _, _, err := ParseWant(want); err != nil {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("%s[%d]", field, i), Message: err.Error()})
+		}
+	}
+	return errs
+}
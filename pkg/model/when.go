@@ -0,0 +1,400 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A when expression makes a package, service, config or user conditional on
+// the host, e.g. `facts.arch == "aarch64" && vars.role != "laptop"`. It is
+// evaluated at plan time, after includes are merged and facts are gathered,
+// and the resource is dropped from the config when it is false.
+//
+// Operands are facts.<name>, vars.<name> (host-vars included), quoted
+// strings, numbers, true and false. They combine with ==, !=, <, <=, >, >=,
+// !, &&, || and parentheses. Ordering compares numbers and versions segment
+// by segment, so `facts.alpine_version >= 3.19` holds on 3.20.3. An operand
+// on its own is true unless it is false, empty or 0.
+
+// whenFacts maps the fact names usable in when expressions to their value.
+var whenFacts = map[string]func(Facts) any{
+	"hostname":       func(f Facts) any { return f.Hostname },
+	"ipv4":           func(f Facts) any { return f.IPv4 },
+	"arch":           func(f Facts) any { return f.Arch },
+	"alpine_version": func(f Facts) any { return f.AlpineVersion },
+	"memory_mb":      func(f Facts) any { return f.MemoryMB },
+	"virtualization": func(f Facts) any { return f.Virtualization },
+}
+
+// whenExpr is a parsed when expression.
+type whenExpr func(data TemplateData) (any, error)
+
+// ParseWhen parses a when expression, reporting syntax errors and unknown
+// facts. Vars are only looked up when the expression is evaluated.
+func ParseWhen(expr string) (whenExpr, error) {
+	tokens, err := lexWhen(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whenParser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].text)
+	}
+	return e, nil
+}
+
+// EvalWhen reports whether expr holds for the host described by data.
+func EvalWhen(expr string, data TemplateData) (bool, error) {
+	e, err := ParseWhen(expr)
+	if err != nil {
+		return false, err
+	}
+	v, err := e(data)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// HasConditions reports whether any resource has a when expression.
+func (s *SystemState) HasConditions() bool {
+	for _, p := range s.Packages {
+		if p.When != "" {
+			return true
+		}
+	}
+	for _, svc := range s.Services {
+		if svc.When != "" {
+			return true
+		}
+	}
+	for _, cfg := range s.Configs {
+		if cfg.When != "" {
+			return true
+		}
+	}
+	for _, u := range s.Users {
+		if u.When != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveConditions drops the packages, services, configs and users whose
+// when expression is false for the host described by facts. Nothing is
+// dropped when an expression cannot be evaluated.
+func (s *SystemState) ResolveConditions(facts Facts) ValidationErrors {
+	data := TemplateData{Vars: s.TemplateVars(facts.Hostname), Facts: facts}
+	var errs ValidationErrors
+	keep := func(field, when string) bool {
+		if when == "" {
+			return true
+		}
+		ok, err := EvalWhen(when, data)
+		if err != nil {
+			errs = append(errs, ValidationError{Field: field + ".when", Message: err.Error()})
+		}
+		return ok
+	}
+
+	packages := s.Packages[:0]
+	for i, p := range s.Packages {
+		if keep(fmt.Sprintf("packages[%d]", i), p.When) {
+			packages = append(packages, p)
+		}
+	}
+	services := s.Services[:0]
+	for i, svc := range s.Services {
+		if keep(fmt.Sprintf("services[%d]", i), svc.When) {
+			services = append(services, svc)
+		}
+	}
+	configs := s.Configs[:0]
+	for i, cfg := range s.Configs {
+		if keep(fmt.Sprintf("configs[%d]", i), cfg.When) {
+			configs = append(configs, cfg)
+		}
+	}
+	users := s.Users[:0]
+	for i, u := range s.Users {
+		if keep(fmt.Sprintf("users[%d]", i), u.When) {
+			users = append(users, u)
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	s.Packages, s.Services, s.Configs, s.Users = packages, services, configs, users
+	return nil
+}
+
+// validateWhen returns the syntax error of a resource's when expression.
+func validateWhen(field, when string) ValidationErrors {
+	if when == "" {
+		return nil
+	}
+	if _, err := ParseWhen(when); err != nil {
+		return ValidationErrors{{Field: field + ".when", Message: fmt.Sprintf("invalid when expression: %v", err)}}
+	}
+	return nil
+}
+
+type whenToken struct {
+	kind byte // 'i' identifier, 's' string, 'n' number, 'o' operator
+	text string
+}
+
+func lexWhen(expr string) ([]whenToken, error) {
+	var tokens []whenToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			tokens = append(tokens, whenToken{'s', expr[i+1 : i+1+end]})
+			i += end + 2
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(expr) && (expr[j] >= '0' && expr[j] <= '9' || expr[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, whenToken{'n', expr[i:j]})
+			i = j
+		case c == '_' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(expr) && (expr[j] == '_' || expr[j] == '.' || expr[j] == '-' ||
+				unicode.IsLetter(rune(expr[j])) || unicode.IsDigit(rune(expr[j]))) {
+				j++
+			}
+			tokens = append(tokens, whenToken{'i', expr[i:j]})
+			i = j
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, whenToken{'o', op})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+type whenParser struct {
+	tokens []whenToken
+	pos    int
+}
+
+// accept consumes the next token when it is one of the operators ops.
+func (p *whenParser) accept(ops ...string) string {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == 'o' {
+		for _, op := range ops {
+			if p.tokens[p.pos].text == op {
+				p.pos++
+				return op
+			}
+		}
+	}
+	return ""
+}
+
+func (p *whenParser) or() (whenExpr, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") != "" {
+		var right whenExpr
+		if right, err = p.and(); err == nil {
+			left = logical(left, right, true)
+		}
+	}
+	return left, err
+}
+
+func (p *whenParser) and() (whenExpr, error) {
+	left, err := p.unary()
+	for err == nil && p.accept("&&") != "" {
+		var right whenExpr
+		if right, err = p.unary(); err == nil {
+			left = logical(left, right, false)
+		}
+	}
+	return left, err
+}
+
+// logical combines two operands with || (or) or && (!or), evaluating the
+// right one only when needed.
+func logical(left, right whenExpr, or bool) whenExpr {
+	return func(data TemplateData) (any, error) {
+		l, err := left(data)
+		if err != nil || truthy(l) == or {
+			return truthy(l), err
+		}
+		r, err := right(data)
+		return truthy(r), err
+	}
+}
+
+func (p *whenParser) unary() (whenExpr, error) {
+	if p.accept("!") != "" {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(data TemplateData) (any, error) {
+			v, err := operand(data)
+			return !truthy(v), err
+		}, nil
+	}
+	return p.comparison()
+}
+
+func (p *whenParser) comparison() (whenExpr, error) {
+	left, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if op == "" {
+		return left, nil
+	}
+	right, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return func(data TemplateData) (any, error) {
+		l, err := left(data)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(data)
+		if err != nil {
+			return nil, err
+		}
+		ls, rs := fmt.Sprint(l), fmt.Sprint(r)
+		switch op {
+		case "==":
+			return ls == rs, nil
+		case "!=":
+			return ls != rs, nil
+		case "<":
+			return compareVersions(ls, rs) < 0, nil
+		case "<=":
+			return compareVersions(ls, rs) <= 0, nil
+		case ">":
+			return compareVersions(ls, rs) > 0, nil
+		default:
+			return compareVersions(ls, rs) >= 0, nil
+		}
+	}, nil
+}
+
+func (p *whenParser) operand() (whenExpr, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if p.accept("(") != "" {
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(")") == "" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	switch tok.kind {
+	case 's', 'n':
+		return func(TemplateData) (any, error) { return tok.text, nil }, nil
+	case 'i':
+		return identifier(tok.text)
+	}
+	return nil, fmt.Errorf("unexpected %s", tok.text)
+}
+
+// identifier resolves true, false, facts.<name> and vars.<name>; nested var
+// maps are reached with further dots, e.g. vars.db.port.
+func identifier(name string) (whenExpr, error) {
+	switch name {
+	case "true", "false":
+		return func(TemplateData) (any, error) { return name == "true", nil }, nil
+	}
+	scope, rest, _ := strings.Cut(name, ".")
+	switch {
+	case scope == "facts":
+		fact, ok := whenFacts[rest]
+		if !ok {
+			return nil, fmt.Errorf("unknown fact '%s'", rest)
+		}
+		return func(data TemplateData) (any, error) { return fact(data.Facts), nil }, nil
+	case scope == "vars" && rest != "":
+		return func(data TemplateData) (any, error) {
+			var v any = data.Vars
+			for _, key := range strings.Split(rest, ".") {
+				m, ok := v.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("var '%s' is not set", rest)
+				}
+				if v, ok = m[key]; !ok {
+					return nil, fmt.Errorf("var '%s' is not set", rest)
+				}
+			}
+			return v, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown name '%s'; use facts.<name>, vars.<name> or a quoted string", name)
+}
+
+// truthy reports whether a value counts as true on its own.
+func truthy(v any) bool {
+	switch s := fmt.Sprint(v); s {
+	case "", "false", "0", "<nil>":
+		return false
+	}
+	return true
+}
+
+// compareVersions orders dotted values segment by segment, numerically when
+// both segments are numbers, so "3.20.3" > "3.19" and "512" < "4096".
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil && xn != yn:
+			if xn < yn {
+				return -1
+			}
+			return 1
+		case (xerr != nil || yerr != nil) && x != y:
+			return strings.Compare(x, y)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+// Package plugin implements the protocol used to manage site-specific resources
+// through external executables.
+//
+// A plugin is any executable on PATH (or an absolute path). Summit invokes it
+// once per operation, writes a single JSON Request to its stdin and expects a
+// single JSON Response on stdout. A non-empty Response.Error, or a non-zero exit
+// status, is treated as a failure. Plugins run through the command runner under
+// timeout(1), so a hung plugin cannot block the plan or the apply forever.
+//
+// The supported methods are:
+//   - infer:    report the current state of the plugin's resources
+//   - diff:     compare the desired config with the inferred state and return changes
+//   - apply:    perform a single change previously returned by diff
+//   - rollback: undo a single change previously applied
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"summit/pkg/system"
+	"time"
+)
+
+const (
+	MethodInfer    = "infer"
+	MethodDiff     = "diff"
+	MethodApply    = "apply"
+	MethodRollback = "rollback"
+)
+
+// Request is the message written to a plugin's stdin.
+type Request struct {
+	Method  string          `json:"method"`
+	Config  map[string]any  `json:"config,omitempty"`
+	Current json.RawMessage `json:"current,omitempty"`
+	Change  *Change         `json:"change,omitempty"`
+}
+
+// Change is a single unit of work proposed by a plugin. Data is opaque to
+// summit and is handed back to the plugin verbatim on apply and rollback.
+type Change struct {
+	ID          string          `json:"id"`
+	Description string          `json:"description"`
+	Details     []string        `json:"details,omitempty"`
+	Data        json.RawMessage `json:"data,omitempty"`
+}
+
+// Response is the message a plugin writes to stdout.
+type Response struct {
+	State   json.RawMessage `json:"state,omitempty"`
+	Changes []Change        `json:"changes,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
+
+// Timeout is how long a single plugin call may run before it is killed.
+var Timeout = 5 * time.Minute
+
+// command returns the command line running a plugin under timeout(1).
+func command(name string) string {
+	return fmt.Sprintf("timeout %d %s", int(math.Ceil(Timeout.Seconds())), system.ShellQuote(name))
+}
+
+// Call sends a request to a plugin and decodes its response.
+func Call(runner system.CommandRunner, name string, req Request) (*Response, error) {
+	input, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s request for plugin %s: %w", req.Method, name, err)
+	}
+	out, err := system.RunInput(runner, "", command(name), input)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s failed during %s: %w", name, req.Method, err)
+	}
+	var resp Response
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("plugin %s returned invalid %s response: %w", name, req.Method, err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("plugin %s reported an error during %s: %s", name, req.Method, resp.Error)
+	}
+	return &resp, nil
+}
+
+// Infer asks a plugin for the current state of its resources.
+func Infer(runner system.CommandRunner, name string) (json.RawMessage, error) {
+	resp, err := Call(runner, name, Request{Method: MethodInfer})
+	if err != nil {
+		return nil, err
+	}
+	return resp.State, nil
+}
+
+// Diff asks a plugin which changes are needed to reach the desired config.
+func Diff(runner system.CommandRunner, name string, config map[string]any, current json.RawMessage) ([]Change, error) {
+	resp, err := Call(runner, name, Request{Method: MethodDiff, Config: config, Current: current})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Changes, nil
+}
+
+// Apply asks a plugin to perform a change.
+func Apply(runner system.CommandRunner, name string, change Change) error {
+	_, err := Call(runner, name, Request{Method: MethodApply, Change: &change})
+	return err
+}
+
+// Rollback asks a plugin to undo a change.
+func Rollback(runner system.CommandRunner, name string, change Change) error {
+	_, err := Call(runner, name, Request{Method: MethodRollback, Change: &change})
+	return err
+}
@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pluginRunner is a command runner answering plugin calls with handler,
+// recording the commands and the requests written to their stdin.
+type pluginRunner struct {
+	t        *testing.T
+	handler  func(command string, req Request) (string, error)
+	commands []string
+	requests []Request
+}
+
+func (r *pluginRunner) Run(user, command string) ([]byte, error) {
+	r.t.Fatalf("plugin run without stdin: %s", command)
+	return nil, nil
+}
+
+func (r *pluginRunner) RunInput(user, command string, input []byte) ([]byte, error) {
+	var req Request
+	require.NoError(r.t, json.Unmarshal(input, &req))
+	r.commands = append(r.commands, command)
+	r.requests = append(r.requests, req)
+	out, err := r.handler(command, req)
+	return []byte(out), err
+}
+
+func TestInferAndDiff(t *testing.T) {
+	runner := &pluginRunner{t: t, handler: func(command string, req Request) (string, error) {
+		assert.Equal(t, "timeout 300 'summit-plugin-zfs'", command)
+		switch req.Method {
+		case MethodInfer:
+			return `{"state": {"datasets": ["tank"]}}`, nil
+		case MethodDiff:
+			return `{"changes": [{"id": "create-tank/home", "description": "Create dataset tank/home"}]}`, nil
+		}
+		return "", errors.New("unexpected method")
+	}}
+
+	current, err := Infer(runner, "summit-plugin-zfs")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"datasets": ["tank"]}`, string(current))
+
+	changes, err := Diff(runner, "summit-plugin-zfs", map[string]any{"datasets": []any{"tank", "tank/home"}}, current)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "create-tank/home", changes[0].ID)
+
+	require.Len(t, runner.requests, 2)
+	assert.JSONEq(t, `{"datasets": ["tank"]}`, string(runner.requests[1].Current))
+	assert.Equal(t, []any{"tank", "tank/home"}, runner.requests[1].Config["datasets"])
+}
+
+func TestApplyAndRollback_PassChangeThrough(t *testing.T) {
+	runner := &pluginRunner{t: t, handler: func(command string, req Request) (string, error) {
+		return `{}`, nil
+	}}
+
+	change := Change{ID: "c1", Description: "do it", Data: json.RawMessage(`{"k":"v"}`)}
+	require.NoError(t, Apply(runner, "p", change))
+	require.NoError(t, Rollback(runner, "p", change))
+
+	require.Len(t, runner.requests, 2)
+	assert.Equal(t, MethodApply, runner.requests[0].Method)
+	assert.Equal(t, MethodRollback, runner.requests[1].Method)
+	assert.JSONEq(t, `{"k":"v"}`, string(runner.requests[1].Change.Data))
+}
+
+func TestCall_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		out      string
+		execErr  error
+		errorMsg string
+	}{
+		{name: "exec failure", execErr: errors.New("exit status 1"), errorMsg: "failed during infer"},
+		{name: "invalid json", out: "not json", errorMsg: "invalid infer response"},
+		{name: "reported error", out: `{"error": "pool offline"}`, errorMsg: "pool offline"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &pluginRunner{t: t, handler: func(command string, req Request) (string, error) {
+				return tt.out, tt.execErr
+			}}
+			_, err := Infer(runner, "p")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.errorMsg)
+		})
+	}
+}
+
+func TestCall_Timeout(t *testing.T) {
+	orig := Timeout
+	Timeout = 90 * time.Second
+	t.Cleanup(func() { Timeout = orig })
+	runner := &pluginRunner{t: t, handler: func(command string, req Request) (string, error) {
+		return `{}`, nil
+	}}
+
+	_, err := Infer(runner, "/usr/local/bin/summit plugin")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"timeout 90 '/usr/local/bin/summit plugin'"}, runner.commands)
+}
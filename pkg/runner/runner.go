@@ -7,3 +7,10 @@ package runner
 type CommandRunner interface {
 	Run(user, command string) ([]byte, error)
 }
+
+// InputRunner is implemented by runners that can write input to the stdin of
+// a command. Only its stdout is returned, so what the command reports on
+// stderr never mixes with the data it prints.
+type InputRunner interface {
+	RunInput(user, command string, input []byte) ([]byte, error)
+}
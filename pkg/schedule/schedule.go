@@ -0,0 +1,162 @@
+// Package schedule matches times against cron-like expressions. It is used to
+// describe maintenance windows rather than to trigger jobs: an expression
+// matches every minute it would fire on, so "* 2-4 * * 6" is the window from
+// Saturday 02:00 to 04:59.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week (0-7, where both 0 and 7 are Sunday).
+type Schedule struct {
+	expr             string
+	minute, hour     []bool
+	dom, month, dow  []bool
+	domStar, dowStar bool
+}
+
+type field struct {
+	name     string
+	min, max int
+}
+
+var fields = []field{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// Parse parses a cron expression. Each field accepts "*", single values,
+// ranges ("1-5"), lists ("1,3,5") and steps ("*/15", "0-30/10").
+func Parse(expr string) (*Schedule, error) {
+	parts := strings.Fields(expr)
+	if len(parts) != len(fields) {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day-of-month month day-of-week), got %d", len(parts))
+	}
+
+	sets := make([][]bool, len(fields))
+	for i, f := range fields {
+		set, err := parseField(parts[i], f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %w", f.name, parts[i], err)
+		}
+		sets[i] = set
+	}
+	// Sunday may be written as 0 or 7
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+
+	return &Schedule{
+		expr:    expr,
+		minute:  sets[0],
+		hour:    sets[1],
+		dom:     sets[2],
+		month:   sets[3],
+		dow:     sets[4],
+		domStar: strings.HasPrefix(parts[2], "*"),
+		dowStar: strings.HasPrefix(parts[4], "*"),
+	}, nil
+}
+
+func parseField(spec string, f field) ([]bool, error) {
+	set := make([]bool, f.max+1)
+	for _, item := range strings.Split(spec, ",") {
+		rangeSpec, stepSpec, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepSpec)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid step '%s'", stepSpec)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		if rangeSpec != "*" {
+			loSpec, hiSpec, isRange := strings.Cut(rangeSpec, "-")
+			var err error
+			if lo, err = parseValue(loSpec, f); err != nil {
+				return nil, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(hiSpec, f); err != nil {
+					return nil, err
+				}
+			} else if hasStep {
+				hi = f.max
+			}
+			if lo > hi {
+				return nil, fmt.Errorf("range start %d is after end %d", lo, hi)
+			}
+		}
+
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+func parseValue(s string, f field) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("'%s' is not a number", s)
+	}
+	if n < f.min || n > f.max {
+		return 0, fmt.Errorf("%d is out of range %d-%d", n, f.min, f.max)
+	}
+	return n, nil
+}
+
+// Matches reports whether t falls on a minute selected by the schedule. As in
+// cron, when both day of month and day of week are restricted, a day matching
+// either one is selected.
+func (s *Schedule) Matches(t time.Time) bool {
+	if !s.minute[t.Minute()] || !s.hour[t.Hour()] || !s.month[int(t.Month())] {
+		return false
+	}
+	domMatch := s.dom[t.Day()]
+	dowMatch := s.dow[int(t.Weekday())]
+	switch {
+	case s.domStar && s.dowStar:
+		return true
+	case s.domStar:
+		return dowMatch
+	case s.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// String returns the expression the schedule was parsed from.
+func (s *Schedule) String() string {
+	return s.expr
+}
+
+// InWindow reports whether t matches any of the expressions. An empty list
+// means there is no restriction.
+func InWindow(exprs []string, t time.Time) (bool, error) {
+	if len(exprs) == 0 {
+		return true, nil
+	}
+	for _, expr := range exprs {
+		s, err := Parse(expr)
+		if err != nil {
+			return false, err
+		}
+		if s.Matches(t) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,81 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMatches(t *testing.T) {
+	// 2024-06-01 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		expr string
+		t    time.Time
+		want bool
+	}{
+		{"* * * * *", at(1, 12, 0), true},
+		{"* 2-4 * * 6", at(1, 2, 0), true},
+		{"* 2-4 * * 6", at(1, 4, 59), true},
+		{"* 2-4 * * 6", at(1, 5, 0), false},
+		{"* 2-4 * * 6", at(2, 3, 0), false},
+		{"* * * * 0", at(2, 3, 0), true},
+		{"* * * * 7", at(2, 3, 0), true},
+		{"*/15 * * * *", at(1, 3, 30), true},
+		{"*/15 * * * *", at(1, 3, 31), false},
+		{"0-30/10 * * * *", at(1, 3, 20), true},
+		{"5/20 * * * *", at(1, 3, 45), true},
+		{"* 1,22 * * *", at(1, 22, 10), true},
+		{"* * 1 6 *", at(1, 9, 0), true},
+		{"* * 1 7 *", at(1, 9, 0), false},
+		// day of month and day of week restricted: either matches
+		{"* * 15 * 6", at(1, 9, 0), true},
+		{"* * 1 * 1", at(1, 9, 0), true},
+		{"* * 2 * 1", at(1, 9, 0), false},
+	}
+
+	for _, tt := range tests {
+		s, err := Parse(tt.expr)
+		require.NoError(t, err, tt.expr)
+		assert.Equal(t, tt.want, s.Matches(tt.t), "%s at %s", tt.expr, tt.t)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	for expr, msg := range map[string]string{
+		"* * * *":       "expected 5 fields",
+		"60 * * * *":    "invalid minute '60': 60 is out of range 0-59",
+		"* 5-2 * * *":   "range start 5 is after end 2",
+		"* * * * mon":   "'mon' is not a number",
+		"*/0 * * * *":   "invalid step '0'",
+		"* * 0 * *":     "invalid day of month",
+		"* * * 1-13 * ": "invalid month",
+	} {
+		_, err := Parse(expr)
+		require.Error(t, err, expr)
+		assert.Contains(t, err.Error(), msg, expr)
+	}
+}
+
+func TestInWindow(t *testing.T) {
+	saturdayNight := time.Date(2024, time.June, 1, 3, 0, 0, 0, time.UTC)
+	mondayNoon := time.Date(2024, time.June, 3, 12, 0, 0, 0, time.UTC)
+	windows := []string{"* 2-4 * * 6", "* 22-23 * * 1-5"}
+
+	ok, err := InWindow(nil, mondayNoon)
+	require.NoError(t, err)
+	assert.True(t, ok, "no windows means no restriction")
+
+	ok, err = InWindow(windows, saturdayNight)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = InWindow(windows, mondayNoon)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
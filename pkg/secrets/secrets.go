@@ -0,0 +1,222 @@
+// Package secrets resolves secret://name and ${env:VAR} references in config
+// contents.
+//
+// The config only ever holds the references: plans, dumps and logs show them
+// as written, and the values are looked up from a provider (environment
+// variables, files or an external command such as "pass show"), or straight
+// from the environment for ${env:VAR}, when a file is compared with or written
+// to the system.
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+)
+
+// Defaults of the providers when the config leaves them unset.
+const (
+	DefaultEnvPrefix = "SUMMIT_SECRET_"
+	DefaultDir       = "/etc/summit/secrets"
+)
+
+// refPattern matches a reference such as secret://db-password or
+// secret://prod/smtp.key; a trailing dot or slash ends the reference.
+var refPattern = regexp.MustCompile(`secret://([A-Za-z0-9_](?:[A-Za-z0-9_./-]*[A-Za-z0-9_])?)`)
+
+// envRefPattern matches a reference to an environment variable of summit's
+// own environment, such as ${env:API_TOKEN}.
+var envRefPattern = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Provider looks up the value of a secret by name.
+type Provider interface {
+	Lookup(name string) (string, error)
+}
+
+// New returns the provider selected by the config, the environment provider
+// when cfg is nil.
+func New(cfg *model.SecretsConfig, runner system.CommandRunner) (Provider, error) {
+	if cfg == nil {
+		return EnvProvider{Prefix: DefaultEnvPrefix}, nil
+	}
+	switch cfg.Provider {
+	case "", model.SecretsEnv:
+		prefix := cfg.Prefix
+		if prefix == "" {
+			prefix = DefaultEnvPrefix
+		}
+		return EnvProvider{Prefix: prefix}, nil
+	case model.SecretsFile:
+		dir := cfg.Dir
+		if dir == "" {
+			dir = DefaultDir
+		}
+		return FileProvider{Dir: dir}, nil
+	case model.SecretsCommand:
+		return CommandProvider{Command: cfg.Command, Runner: runner}, nil
+	}
+	return nil, fmt.Errorf("unknown secrets provider '%s'", cfg.Provider)
+}
+
+// EnvProvider reads secrets from environment variables: secret://db-password
+// is $SUMMIT_SECRET_DB_PASSWORD with the default prefix.
+type EnvProvider struct {
+	Prefix string
+}
+
+func (p EnvProvider) Lookup(name string) (string, error) {
+	variable := p.Prefix + strings.ToUpper(strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name))
+	value, ok := os.LookupEnv(variable)
+	if !ok {
+		return "", fmt.Errorf("secret %s: environment variable %s is not set", name, variable)
+	}
+	return value, nil
+}
+
+// FileProvider reads each secret from a file named after it under Dir, e.g.
+// a directory of mounted container or systemd credentials.
+type FileProvider struct {
+	Dir string
+}
+
+func (p FileProvider) Lookup(name string) (string, error) {
+	if strings.Contains(name, "..") {
+		return "", fmt.Errorf("secret %s: name cannot contain '..'", name)
+	}
+	content, err := afero.ReadFile(system.AppFs, filepath.Join(p.Dir, name))
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %w", name, err)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// CommandProvider runs Command and takes its output as the secret. {name} in
+// the command is replaced by the quoted secret name, which is appended as the
+// last argument when the command does not contain it.
+type CommandProvider struct {
+	Command string
+	Runner  system.CommandRunner
+}
+
+func (p CommandProvider) Lookup(name string) (string, error) {
+	command := p.Command
+	if strings.Contains(command, "{name}") {
+		command = strings.ReplaceAll(command, "{name}", system.ShellQuote(name))
+	} else {
+		command += " " + system.ShellQuote(name)
+	}
+	out, err := p.Runner.Run("", command)
+	if err != nil {
+		return "", fmt.Errorf("secret %s: %s failed: %w", name, p.Command, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// HasRefs reports whether s holds secret or environment references.
+func HasRefs(s string) bool {
+	return strings.Contains(s, "secret://") && refPattern.MatchString(s) ||
+		strings.Contains(s, "${env:") && envRefPattern.MatchString(s)
+}
+
+// UnsetEnvRefs returns the environment variables s references that are not
+// set, so a config can be refused when it is loaded rather than when the
+// file is written.
+func UnsetEnvRefs(s string) []string {
+	var unset []string
+	for _, m := range envRefPattern.FindAllStringSubmatch(s, -1) {
+		if _, ok := os.LookupEnv(m[1]); !ok {
+			unset = append(unset, m[1])
+		}
+	}
+	return unset
+}
+
+// Resolver expands references with a provider. It remembers the values it
+// looked up, by reference, so each secret is fetched once per run and can be
+// redacted from anything shown to the user.
+type Resolver struct {
+	provider Provider
+
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func NewResolver(provider Provider) *Resolver {
+	return &Resolver{provider: provider, values: make(map[string]string)}
+}
+
+// Expand returns s with every reference replaced by the secret's value.
+func (r *Resolver) Expand(s string) (string, error) {
+	if !HasRefs(s) {
+		return s, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var lookupErr error
+	expand := func(pattern *regexp.Regexp, lookup func(name string) (string, error)) func(string) string {
+		return func(ref string) string {
+			value, ok := r.values[ref]
+			if !ok {
+				var err error
+				if value, err = lookup(pattern.FindStringSubmatch(ref)[1]); err != nil {
+					if lookupErr == nil {
+						lookupErr = err
+					}
+					return ref
+				}
+				r.values[ref] = value
+			}
+			return value
+		}
+	}
+	expanded := refPattern.ReplaceAllStringFunc(s, expand(refPattern, r.provider.Lookup))
+	expanded = envRefPattern.ReplaceAllStringFunc(expanded, expand(envRefPattern, lookupEnv))
+	if lookupErr != nil {
+		return "", lookupErr
+	}
+	return expanded, nil
+}
+
+func lookupEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return value, nil
+}
+
+// Redact returns s with the values of the secrets looked up so far replaced
+// by their references, longest values first.
+func (r *Resolver) Redact(s string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refs := make([]string, 0, len(r.values))
+	for ref, value := range r.values {
+		if value != "" {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return len(r.values[refs[i]]) > len(r.values[refs[j]])
+	})
+	for _, ref := range refs {
+		s = strings.ReplaceAll(s, r.values[ref], ref)
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package secrets
+
+import (
+	"fmt"
+	"testing"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockRunner struct {
+	commands []string
+	outputs  map[string]string
+}
+
+func (r *mockRunner) Run(user, command string) ([]byte, error) {
+	r.commands = append(r.commands, command)
+	if out, ok := r.outputs[command]; ok {
+		return []byte(out), nil
+	}
+	return nil, fmt.Errorf("exit status 1")
+}
+
+type mapProvider map[string]string
+
+func (p mapProvider) Lookup(name string) (string, error) {
+	if value, ok := p[name]; ok {
+		return value, nil
+	}
+	return "", fmt.Errorf("secret %s: not found", name)
+}
+
+func TestNew(t *testing.T) {
+	runner := &mockRunner{}
+	tests := []struct {
+		cfg      *model.SecretsConfig
+		expected Provider
+	}{
+		{nil, EnvProvider{Prefix: DefaultEnvPrefix}},
+		{&model.SecretsConfig{Provider: "env", Prefix: "APP_"}, EnvProvider{Prefix: "APP_"}},
+		{&model.SecretsConfig{Provider: "file"}, FileProvider{Dir: DefaultDir}},
+		{&model.SecretsConfig{Provider: "command", Command: "pass show"}, CommandProvider{Command: "pass show", Runner: runner}},
+	}
+	for _, tt := range tests {
+		provider, err := New(tt.cfg, runner)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, provider)
+	}
+
+	_, err := New(&model.SecretsConfig{Provider: "vault"}, runner)
+	assert.Error(t, err)
+}
+
+func TestEnvProvider(t *testing.T) {
+	t.Setenv("SUMMIT_SECRET_DB_PASSWORD", "hunter2")
+	provider := EnvProvider{Prefix: DefaultEnvPrefix}
+
+	value, err := provider.Lookup("db-password")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	_, err = provider.Lookup("missing")
+	assert.ErrorContains(t, err, "SUMMIT_SECRET_MISSING is not set")
+}
+
+func TestFileProvider(t *testing.T) {
+	system.AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(system.AppFs, "/run/secrets/smtp/key", []byte("s3cret\n"), 0600))
+	provider := FileProvider{Dir: "/run/secrets"}
+
+	value, err := provider.Lookup("smtp/key")
+	require.NoError(t, err)
+	assert.Equal(t, "s3cret", value)
+
+	_, err = provider.Lookup("../shadow")
+	assert.Error(t, err)
+	_, err = provider.Lookup("missing")
+	assert.Error(t, err)
+}
+
+func TestCommandProvider(t *testing.T) {
+	runner := &mockRunner{outputs: map[string]string{
+		"pass show 'db'":                      "hunter2\n",
+		"vault kv get -field='db' secret/app": "from-vault",
+	}}
+
+	value, err := CommandProvider{Command: "pass show", Runner: runner}.Lookup("db")
+	require.NoError(t, err)
+	assert.Equal(t, "hunter2", value)
+
+	value, err = CommandProvider{Command: "vault kv get -field={name} secret/app", Runner: runner}.Lookup("db")
+	require.NoError(t, err)
+	assert.Equal(t, "from-vault", value)
+
+	_, err = CommandProvider{Command: "false", Runner: runner}.Lookup("db")
+	assert.ErrorContains(t, err, "secret db: false failed")
+}
+
+func TestResolver(t *testing.T) {
+	provider := mapProvider{"db": "hunter2", "api.key": "abc123"}
+	resolver := NewResolver(provider)
+
+	assert.True(t, HasRefs("password=secret://db"))
+	assert.False(t, HasRefs("password=hunter2"))
+
+	expanded, err := resolver.Expand("password=secret://db\nkey=secret://api.key.\n")
+	require.NoError(t, err)
+	assert.Equal(t, "password=hunter2\nkey=abc123.\n", expanded)
+
+	_, err = resolver.Expand("token=secret://missing")
+	assert.ErrorContains(t, err, "secret missing")
+
+	assert.Equal(t, "old=secret://db new=secret://api.key", resolver.Redact("old=hunter2 new=abc123"))
+}
+
+func TestResolver_EnvRefs(t *testing.T) {
+	t.Setenv("API_TOKEN", "tok-123")
+	resolver := NewResolver(mapProvider{"db": "hunter2"})
+
+	assert.True(t, HasRefs("token=${env:API_TOKEN}"))
+	assert.False(t, HasRefs("home=${HOME}"))
+	assert.Equal(t, []string{"MISSING_TOKEN"}, UnsetEnvRefs("a=${env:API_TOKEN} b=${env:MISSING_TOKEN}"))
+
+	expanded, err := resolver.Expand("token=${env:API_TOKEN}\npassword=secret://db\n")
+	require.NoError(t, err)
+	assert.Equal(t, "token=tok-123\npassword=hunter2\n", expanded)
+
+	_, err = resolver.Expand("token=${env:MISSING_TOKEN}")
+	assert.EqualError(t, err, "environment variable MISSING_TOKEN is not set")
+
+	assert.Equal(t, "token=${env:API_TOKEN} password=secret://db", resolver.Redact("token=tok-123 password=hunter2"))
+}
@@ -1,7 +1,11 @@
 package system
 
 import (
+	"bytes"
+	"fmt"
+	"os"
 	"os/exec"
+	"strings"
 
 	"summit/pkg/runner"
 )
@@ -11,11 +15,79 @@ import (
 // Re-exported from pkg/runner to maintain backward compatibility.
 type CommandRunner = runner.CommandRunner
 
+// InputRunner is a runner that can write to the stdin of a command, see
+// RunInput.
+type InputRunner = runner.InputRunner
+
 // LiveCommandRunner is an implementation of CommandRunner that runs commands on the live system.
-type LiveCommandRunner struct{}
+type LiveCommandRunner struct {
+	// Env holds variables like http_proxy=... added to the environment of
+	// every command.
+	Env []string
+}
 
-// Run executes the given command and returns its output.
+// Run executes the given command, as user when it is not empty, and returns
+// its output. Commands run as another user get Env exported by the command
+// itself, since su -l starts them with a clean environment.
 func (r *LiveCommandRunner) Run(user, command string) ([]byte, error) {
-	cmd := exec.Command("sh", "-c", command)
-	return cmd.CombinedOutput()
+	return r.command(user, command).CombinedOutput()
+}
+
+// command returns the process running command as user with Env.
+func (r *LiveCommandRunner) command(user, command string) *exec.Cmd {
+	if user != "" && len(r.Env) > 0 {
+		var exports []string
+		for _, kv := range r.Env {
+			name, value, _ := strings.Cut(kv, "=")
+			exports = append(exports, "export "+name+"="+ShellQuote(value)+";")
+		}
+		command = strings.Join(exports, " ") + " " + command
+	}
+	cmd := exec.Command("sh", "-c", UserCommand(user, command))
+	if len(r.Env) > 0 {
+		cmd.Env = append(os.Environ(), r.Env...)
+	}
+	return cmd
+}
+
+// RunInput runs command with input on its stdin and returns its stdout. A
+// failing command's stderr is part of the error.
+func (r *LiveCommandRunner) RunInput(user, command string, input []byte) ([]byte, error) {
+	cmd := r.command(user, command)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// RunInput runs command with input on its stdin through runner, which must
+// be an InputRunner, and returns its stdout.
+func RunInput(runner CommandRunner, user, command string, input []byte) ([]byte, error) {
+	r, ok := runner.(InputRunner)
+	if !ok {
+		return nil, fmt.Errorf("cannot run %s: the command runner cannot write to stdin", command)
+	}
+	return r.RunInput(user, command, input)
+}
+
+// UserCommand returns the shell command that runs command as user through a
+// login shell, or command itself for an empty user (the user running summit).
+func UserCommand(user, command string) string {
+	if user == "" {
+		return command
+	}
+	return "su -l " + user + " -c " + ShellQuote(command)
+}
+
+// ShellQuote wraps s in single quotes for use in a command passed to a
+// CommandRunner, which runs it through sh.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
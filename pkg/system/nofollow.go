@@ -0,0 +1,82 @@
+package system
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/afero"
+)
+
+// RefuseSymlinks returns an error when path is not under root, or when path
+// or a directory between root and it is a symlink. summit writes the files
+// of users' homes as root: following a link the user planted there, such as
+// ~/.vimrc -> /etc/shadow, would overwrite and chown any file of the system.
+// Components that do not exist yet are fine; root itself may be a link.
+func RefuseSymlinks(root, path string) error {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside of %s", path, root)
+	}
+	lstater, ok := AppFs.(afero.Lstater)
+	if !ok {
+		return nil
+	}
+	current := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part == "." {
+			continue
+		}
+		current = filepath.Join(current, part)
+		info, _, err := lstater.LstatIfPossible(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("refusing to follow symlink %s", current)
+		}
+	}
+	return nil
+}
+
+// WriteFileNoFollow writes content to path under root with mode and, when
+// uid or gid are not -1, that ownership, refusing symlinks like
+// RefuseSymlinks. The file is opened with O_NOFOLLOW and changed through the
+// descriptor where the filesystem allows it, so a link swapped in after the
+// check is not followed either.
+func WriteFileNoFollow(root, path string, content []byte, mode os.FileMode, uid, gid int) error {
+	if err := RefuseSymlinks(root, path); err != nil {
+		return err
+	}
+	f, err := AppFs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if fd, ok := f.(interface {
+		Chmod(os.FileMode) error
+		Chown(int, int) error
+	}); ok {
+		err = fd.Chmod(mode)
+		if err == nil && (uid >= 0 || gid >= 0) {
+			err = fd.Chown(uid, gid)
+		}
+	} else {
+		err = AppFs.Chmod(path, mode)
+		if err == nil && (uid >= 0 || gid >= 0) {
+			err = AppFs.Chown(path, uid, gid)
+		}
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,353 @@
+package system
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"summit/pkg/model"
+
+	"github.com/spf13/afero"
+)
+
+// PackageManager is the package manager of the host: which packages are
+// installed and how to install and remove them. apk is the default; the
+// others let the packages section be applied to Debian, Fedora or Arch hosts.
+type PackageManager interface {
+	// Name returns the name of the package manager, e.g. "apk".
+	Name() string
+	// Pins reports whether packages can be pinned to a version, as apk does
+	// with name=version in /etc/apk/world.
+	Pins() bool
+	// Installed returns the packages installed explicitly, as opposed to the
+	// dependencies pulled in for them, along with the version each is pinned
+	// to, if any.
+	Installed(runner CommandRunner) ([]model.PackageState, error)
+	// Versions returns the installed version of every package, by name.
+	Versions(runner CommandRunner) (map[string]string, error)
+	// InstallCommand returns the command installing a package, pinned to
+	// version when it is set and the package manager Pins.
+	InstallCommand(name, version string) string
+	// RemoveCommand returns the command removing a package.
+	RemoveCommand(name string) string
+	// UpdateIndexCommand returns the command updating the package index from
+	// the repositories.
+	UpdateIndexCommand() string
+	// IndexUpdated returns when the package index was last updated, or the
+	// zero time when there is no index.
+	IndexUpdated() time.Time
+}
+
+// Packages is the package manager packages are listed, installed and removed
+// with. It is set by DetectPackageManager when summit starts.
+var Packages PackageManager = Apk{}
+
+// packageManagers are the package managers by the distribution ids they
+// serve, as found in ID and ID_LIKE of /etc/os-release.
+var packageManagers = map[string]PackageManager{
+	"alpine": Apk{},
+	"debian": Apt{},
+	"ubuntu": Apt{},
+	"fedora": Dnf{},
+	"rhel":   Dnf{},
+	"centos": Dnf{},
+	"arch":   Pacman{},
+}
+
+// DetectPackageManager returns the package manager of the distribution named
+// in /etc/os-release, first by its ID and then by the ones it is like, e.g.
+// apt for Linux Mint. Unknown distributions, or hosts without the file, get
+// apk.
+func DetectPackageManager() PackageManager {
+	content, err := afero.ReadFile(AppFs, "/etc/os-release")
+	if err != nil {
+		return Apk{}
+	}
+	fields := make(map[string]string)
+	for _, line := range strings.Split(string(content), "\n") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+			fields[key] = strings.Trim(value, `"'`)
+		}
+	}
+	for _, id := range append([]string{fields["ID"]}, strings.Fields(fields["ID_LIKE"])...) {
+		if manager, ok := packageManagers[id]; ok {
+			return manager
+		}
+	}
+	return Apk{}
+}
+
+// Apk manages the packages of Alpine Linux. Explicitly installed packages are
+// the ones listed in /etc/apk/world, pinned there as name=version.
+//
+// With Offline, packages are installed without touching the network: from
+// Source, a local mirror or a directory of cached indexes and .apk files like
+// /var/cache/apk, or from the apk cache when Source is empty.
+type Apk struct {
+	Offline bool
+	Source  string
+}
+
+func (Apk) Name() string { return "apk" }
+
+func (Apk) Pins() bool { return true }
+
+func (Apk) Installed(runner CommandRunner) ([]model.PackageState, error) {
+	worldPath := "/etc/apk/world"
+	content, err := afero.ReadFile(AppFs, worldPath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %w", worldPath, err)
+	}
+
+	packageNames := strings.Split(string(content), "\n")
+	packages := make([]model.PackageState, 0, len(packageNames))
+
+	for _, packageName := range packageNames {
+		packageName = strings.TrimSpace(packageName)
+		if packageName != "" {
+			pkg := model.PackageState{Name: packageName}
+			// Other constraints, such as htop>=3, are not versions to pin
+			if i := strings.IndexAny(packageName, "<>=~"); i >= 0 {
+				pkg.Name = packageName[:i]
+				if version, ok := strings.CutPrefix(packageName[i:], "="); ok && !strings.ContainsAny(version, "<>=~") {
+					pkg.Version = version
+				}
+			}
+			packages = append(packages, pkg)
+		}
+	}
+
+	return packages, nil
+}
+
+// Versions lists the installed packages with apk info -v.
+func (Apk) Versions(runner CommandRunner) (map[string]string, error) {
+	out, err := runner.Run("", "apk info -v")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed package versions: %w", err)
+	}
+	versions := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if name, version, ok := splitPackageVersion(strings.TrimSpace(line)); ok {
+			versions[name] = version
+		}
+	}
+	return versions, nil
+}
+
+func (a Apk) InstallCommand(name, version string) string {
+	if version == "" {
+		return a.AddCommand(name)
+	}
+	return a.AddCommand(name + "=" + version)
+}
+
+// AddCommand returns apk add with args, restricted to the local source when
+// Offline.
+func (a Apk) AddCommand(args ...string) string {
+	command := "apk add"
+	if a.Offline {
+		command += " --no-network"
+		if matches, _ := afero.Glob(AppFs, a.Source+"/APKINDEX.*.tar.gz"); a.Source != "" && len(matches) > 0 {
+			command += " --cache-dir " + a.Source
+		} else if a.Source != "" {
+			command += " --repositories-file /dev/null --repository " + a.Source
+		}
+	}
+	return strings.Join(append([]string{command}, args...), " ")
+}
+
+// SourceName returns where packages are installed from offline, for messages.
+func (a Apk) SourceName() string {
+	if a.Source == "" {
+		return "the apk cache"
+	}
+	return a.Source
+}
+
+func (Apk) RemoveCommand(name string) string { return "apk del " + name }
+
+func (Apk) UpdateIndexCommand() string { return "apk update" }
+
+func (Apk) IndexUpdated() time.Time { return indexUpdated("/var/cache/apk/APKINDEX.*.tar.gz") }
+
+// VirtualPackages splits the virtual packages apk add --virtual created out
+// of the explicitly installed packages, by the leading dot of their names,
+// along with the packages each groups.
+func VirtualPackages(runner CommandRunner, packages []model.PackageState) ([]model.PackageState, []model.VirtualPackageState, error) {
+	plain := make([]model.PackageState, 0, len(packages))
+	var virtual []model.VirtualPackageState
+	for _, pkg := range packages {
+		if !strings.HasPrefix(pkg.Name, ".") {
+			plain = append(plain, pkg)
+			continue
+		}
+		deps, err := VirtualDependencies(runner, pkg.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		virtual = append(virtual, model.VirtualPackageState{Name: pkg.Name, Packages: deps})
+	}
+	return plain, virtual, nil
+}
+
+// VirtualDependencies returns the packages a virtual package groups, as
+// apk info -R lists them below a "<name>-<version> depends on:" line.
+func VirtualDependencies(runner CommandRunner, name string) ([]string, error) {
+	out, err := runner.Run("", "apk info -R "+name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the packages of %s: %w", name, err)
+	}
+	var deps []string
+	for _, line := range strings.Split(string(out), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasSuffix(line, ":") {
+			deps = append(deps, line)
+		}
+	}
+	return deps, nil
+}
+
+// splitPackageVersion splits an apk package id like musl-1.2.4_git20230717-r4
+// into its name and version, the version being the last two dash-separated
+// parts.
+func splitPackageVersion(id string) (string, string, bool) {
+	release := strings.LastIndex(id, "-")
+	if release <= 0 {
+		return "", "", false
+	}
+	i := strings.LastIndex(id[:release], "-")
+	if i <= 0 || id[i+1] < '0' || id[i+1] > '9' {
+		return "", "", false
+	}
+	return id[:i], id[i+1:], true
+}
+
+// Apt manages the packages of Debian and its derivatives. Explicitly
+// installed packages are the ones apt-mark reports as manually installed.
+type Apt struct{}
+
+func (Apt) Name() string { return "apt" }
+
+func (Apt) Pins() bool { return false }
+
+func (Apt) Installed(runner CommandRunner) ([]model.PackageState, error) {
+	return listedPackages(runner, "apt-mark showmanual")
+}
+
+func (Apt) Versions(runner CommandRunner) (map[string]string, error) {
+	return listedVersions(runner, "dpkg-query -W -f '${Package} ${Version}\\n'")
+}
+
+func (Apt) InstallCommand(name, version string) string {
+	return "DEBIAN_FRONTEND=noninteractive apt-get install -y " + name
+}
+
+func (Apt) RemoveCommand(name string) string {
+	return "DEBIAN_FRONTEND=noninteractive apt-get remove -y " + name
+}
+
+func (Apt) UpdateIndexCommand() string { return "apt-get update" }
+
+func (Apt) IndexUpdated() time.Time { return indexUpdated("/var/lib/apt/lists/*_InRelease") }
+
+// Dnf manages the packages of Fedora and RHEL. Explicitly installed packages
+// are the ones dnf records as installed by the user.
+type Dnf struct{}
+
+func (Dnf) Name() string { return "dnf" }
+
+func (Dnf) Pins() bool { return false }
+
+func (Dnf) Installed(runner CommandRunner) ([]model.PackageState, error) {
+	return listedPackages(runner, "dnf repoquery --userinstalled --qf '%{name}\\n'")
+}
+
+func (Dnf) Versions(runner CommandRunner) (map[string]string, error) {
+	return listedVersions(runner, "rpm -qa --qf '%{NAME} %{VERSION}-%{RELEASE}\\n'")
+}
+
+func (Dnf) InstallCommand(name, version string) string {
+	return "dnf install -y " + name
+}
+
+func (Dnf) RemoveCommand(name string) string { return "dnf remove -y " + name }
+
+func (Dnf) UpdateIndexCommand() string { return "dnf makecache" }
+
+func (Dnf) IndexUpdated() time.Time { return indexUpdated("/var/cache/dnf/*.solv") }
+
+// Pacman manages the packages of Arch Linux. Explicitly installed packages are
+// the ones pacman -Qe lists.
+type Pacman struct{}
+
+func (Pacman) Name() string { return "pacman" }
+
+func (Pacman) Pins() bool { return false }
+
+func (Pacman) Installed(runner CommandRunner) ([]model.PackageState, error) {
+	return listedPackages(runner, "pacman -Qqe")
+}
+
+func (Pacman) Versions(runner CommandRunner) (map[string]string, error) {
+	return listedVersions(runner, "pacman -Q")
+}
+
+func (Pacman) InstallCommand(name, version string) string {
+	return "pacman -S --noconfirm --needed " + name
+}
+
+func (Pacman) RemoveCommand(name string) string { return "pacman -R --noconfirm " + name }
+
+func (Pacman) UpdateIndexCommand() string { return "pacman -Sy" }
+
+func (Pacman) IndexUpdated() time.Time { return indexUpdated("/var/lib/pacman/sync/*.db") }
+
+// indexUpdated returns the modification time of the oldest file matching
+// pattern, one per repository, or the zero time when none match: the index
+// is only as fresh as its stalest repository.
+func indexUpdated(pattern string) time.Time {
+	matches, _ := afero.Glob(AppFs, pattern)
+	var oldest time.Time
+	for _, match := range matches {
+		info, err := AppFs.Stat(match)
+		if err != nil {
+			continue
+		}
+		if oldest.IsZero() || info.ModTime().Before(oldest) {
+			oldest = info.ModTime()
+		}
+	}
+	return oldest
+}
+
+// listedPackages returns the packages command lists, one name per line.
+func listedPackages(runner CommandRunner, command string) ([]model.PackageState, error) {
+	out, err := runner.Run("", command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	var packages []model.PackageState
+	for _, line := range strings.Split(string(out), "\n") {
+		if name := strings.TrimSpace(line); name != "" {
+			packages = append(packages, model.PackageState{Name: name})
+		}
+	}
+	return packages, nil
+}
+
+// listedVersions returns the versions command lists, one "name version" pair
+// per line.
+func listedVersions(runner CommandRunner, command string) (map[string]string, error) {
+	out, err := runner.Run("", command)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list installed package versions: %w", err)
+	}
+	versions := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			versions[fields[0]] = fields[1]
+		}
+	}
+	return versions, nil
+}
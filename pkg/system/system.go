@@ -2,69 +2,110 @@ package system
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/user"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"summit/pkg/model"
 	"syscall"
 
 	"github.com/spf13/afero"
+	"golang.org/x/sync/errgroup"
 )
 
 // InferSystemState infers the current system state by gathering information about installed packages,
 // running services, existing users, and system configurations.
 // It returns a SystemState struct containing this information or an error if any occurred.
+// The pipx, npm, cargo, gem and uv packages of the users are listed too, best
+// effort.
 func InferSystemState(runner CommandRunner, skipIntrinsicIgnores bool) (*model.SystemState, []model.IgnoredConfig, error) {
-	packages, err := listInstalledPackages()
+	state, ignored, err := inferSystemState(runner, skipIntrinsicIgnores, nil, nil)
 	if err != nil {
 		return nil, nil, err
 	}
+	state.UserPackages = listUserPackages(runner, state.Users)
+	return state, ignored, nil
+}
 
-	services, err := listServices()
+// InferSystemStateFor is like InferSystemState for computing a plan against
+// a desired state: only the configs at paths, the ones a plan compares
+// contents of, are read up front. Every other audited file is only stat'ed;
+// its content is read if and when it is asked for. Only the crontabs of the
+// given users, the ones a plan reconciles, are listed.
+func InferSystemStateFor(runner CommandRunner, paths, users []string) (*model.SystemState, []model.IgnoredConfig, error) {
+	wanted := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		wanted[path] = true
+	}
+	declared := make(map[string]bool, len(users))
+	for _, user := range users {
+		declared[user] = true
+	}
+	return inferSystemState(runner, false, func(path string) bool { return wanted[path] }, func(user string) bool { return declared[user] })
+}
+
+// inferSystemState infers the state, hashing the content of the configs
+// selected by hash, or of all configs when hash is nil, and listing the
+// crontabs of the users selected by crontab, or of all users when it is nil.
+func inferSystemState(runner CommandRunner, skipIntrinsicIgnores bool, hash, crontab func(string) bool) (*model.SystemState, []model.IgnoredConfig, error) {
+	packages, err := Packages.Installed(runner)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	users, err := listUsers(runner)
+	packages, virtual, err := VirtualPackages(runner, packages)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	configs, ignored, err := listSystemConfigs(runner, skipIntrinsicIgnores)
+	services, err := listServices()
 	if err != nil {
 		return nil, nil, err
 	}
+	if status := listServiceStatus(runner); status != nil {
+		for i := range services {
+			if running, ok := status[services[i].Name]; ok {
+				services[i].Running = &running
+			}
+		}
+	}
 
-	return &model.SystemState{
-		Packages: packages,
-		Services: services,
-		Users:    users,
-		Configs:  configs,
-	}, ignored, nil
-}
-
-// listInstalledPackages returns all installed packages in the system
-func listInstalledPackages() ([]model.PackageState, error) {
-	worldPath := "/etc/apk/world"
-	content, err := afero.ReadFile(AppFs, worldPath)
+	users, err := listUsers(runner, crontab)
 	if err != nil {
-		return nil, fmt.Errorf("Error reading %s: %w", worldPath, err)
+		return nil, nil, err
 	}
 
-	packageNames := strings.Split(string(content), "\n")
-	packages := make([]model.PackageState, 0, len(packageNames))
+	groups, err := listGroups()
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for _, packageName := range packageNames {
-		packageName = strings.TrimSpace(packageName)
-		if packageName != "" {
-			packages = append(packages, model.PackageState{Name: packageName})
-		}
+	configs, ignored, err := listSystemConfigs(runner, skipIntrinsicIgnores, hash)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return packages, nil
+	return &model.SystemState{
+		Packages:        packages,
+		VirtualPackages: virtual,
+		Services:        services,
+		Users:           users,
+		Groups:          groups,
+		Configs:         configs,
+		Sysctl:          listSysctl(runner),
+		Timezone:        ReadTimezone(runner),
+	}, ignored, nil
+}
+
+// InstalledVersions returns the installed version of every package, by name,
+// as listed by the package manager, e.g. with apk info -v.
+func InstalledVersions(runner CommandRunner) (map[string]string, error) {
+	return Packages.Versions(runner)
 }
 
 func listServices() ([]model.ServiceState, error) {
@@ -84,19 +125,17 @@ func listServices() ([]model.ServiceState, error) {
 			continue
 		}
 
-		// Determine enabled status and runlevel
-		var enabled bool
-		var runlevel string
-
-		// Check for symlinks in runlevels directories
+		// Check for symlinks in runlevels directories. The first runlevel
+		// found is the service's; any other is recorded so it can be
+		// reported and cleaned up.
+		var found []string
 		runlevels := []string{"boot", "default", "sysinit", "nonetwork", "shutdown"}
 		for _, rl := range runlevels {
 			runlevelPath := filepath.Join("/etc/runlevels", rl, name)
 			_, err := AppFs.Stat(runlevelPath)
 			if err == nil {
-				enabled = true
-				runlevel = rl
-				break // Assuming a service is only enabled in one runlevel
+				found = append(found, rl)
+				continue
 			}
 			if !os.IsNotExist(err) {
 				return nil, fmt.Errorf("error checking runlevel path %s: %w", runlevelPath, err)
@@ -105,17 +144,46 @@ func listServices() ([]model.ServiceState, error) {
 
 		// Include all services from /etc/init.d, even if not enabled
 		// For disabled services, set runlevel to empty string
-		services = append(services, model.ServiceState{
-			Name:     name,
-			Enabled:  enabled,
-			Runlevel: runlevel,
-		})
+		service := model.ServiceState{Name: name}
+		if len(found) > 0 {
+			service.Enabled, service.Runlevel = true, found[0]
+		}
+		if len(found) > 1 {
+			service.ExtraRunlevels = found[1:]
+		}
+		services = append(services, service)
 	}
 
 	return services, nil
 }
 
-func listUsers(runner CommandRunner) ([]model.UserState, error) {
+// listServiceStatus returns whether each service is started, as listed by
+// rc-status, or nil when OpenRC is not running, e.g. in a container.
+func listServiceStatus(runner CommandRunner) map[string]bool {
+	out, err := runner.Run("", "rc-status --servicelist")
+	if err != nil {
+		return nil
+	}
+	var status map[string]bool
+	for _, line := range strings.Split(string(out), "\n") {
+		// Lines look like " sshd   [  started  ]", with the uptime of
+		// supervised services after the state
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "[" {
+			continue
+		}
+		if status == nil {
+			status = make(map[string]bool)
+		}
+		status[fields[0]] = fields[2] == "started"
+	}
+	return status
+}
+
+// listUsers returns the regular users of /etc/passwd with their groups and
+// authorized keys, and the crontabs of the users selected by crontab, or of
+// all users when it is nil.
+func listUsers(runner CommandRunner, crontab func(user string) bool) ([]model.UserState, error) {
 	// Build gid to group name map
 	gidToName, err := buildGidToNameMap()
 	if err != nil {
@@ -142,8 +210,8 @@ func listUsers(runner CommandRunner) ([]model.UserState, error) {
 		if err != nil {
 			continue
 		}
-		// filter users with UID < 1000
-		if uid < 1000 {
+		// filter system users
+		if uid < model.MinUserUID {
 			continue
 		}
 
@@ -171,6 +239,19 @@ func listUsers(runner CommandRunner) ([]model.UserState, error) {
 			Name:         userName,
 			Groups:       userGroups,
 			PrimaryGroup: primaryGroupName,
+			UID:          uid,
+			Shell:        fields[6],
+			Home:         fields[5],
+			Gecos:        fields[4],
+		}
+		if crontab == nil || crontab(userName) {
+			user.Crontab = listCrontab(runner, userName)
+		}
+		keysPath := AuthorizedKeysPath(fields[5])
+		if RefuseSymlinks(fields[5], keysPath) != nil {
+			// Left empty: a link of the user could expose any file
+		} else if keys, err := afero.ReadFile(AppFs, keysPath); err == nil {
+			user.AuthorizedKeys, user.UnmanagedKeys = model.ParseAuthorizedKeys(string(keys))
 		}
 		users = append(users, user)
 	}
@@ -180,6 +261,42 @@ func listUsers(runner CommandRunner) ([]model.UserState, error) {
 
 const groupFilePath = "/etc/group"
 
+// firstOverflowGID is the gid of nogroup; it and nobody (65534) are not
+// regular groups even though their gids are above 1000.
+const firstOverflowGID = 65533
+
+// listGroups returns the non-system groups (gid >= 1000) of /etc/group,
+// except primary groups of users, such as the private group adduser creates
+// along with each user: they come and go with their users.
+func listGroups() ([]model.GroupState, error) {
+	primary := make(map[string]bool)
+	if passwd, err := afero.ReadFile(AppFs, "/etc/passwd"); err == nil {
+		for _, line := range strings.Split(string(passwd), "\n") {
+			if fields := strings.Split(line, ":"); len(fields) >= 4 {
+				primary[fields[3]] = true
+			}
+		}
+	}
+
+	content, err := afero.ReadFile(AppFs, groupFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %s: %w", groupFilePath, err)
+	}
+	var groups []model.GroupState
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil || gid < model.MinUserUID || gid >= firstOverflowGID || primary[fields[2]] {
+			continue
+		}
+		groups = append(groups, model.GroupState{Name: fields[0], GID: gid})
+	}
+	return groups, nil
+}
+
 // buildGidToNameMap builds a map from gid to group name
 func buildGidToNameMap() (map[int]string, error) {
 	groupFile, err := AppFs.Open(groupFilePath)
@@ -208,10 +325,27 @@ func buildGidToNameMap() (map[int]string, error) {
 	return gidToName, nil
 }
 
+// ExtraIntrinsicIgnores extends the built-in intrinsic ignore rules for
+// inference. Commands set it from the config's intrinsic-ignores.
+var ExtraIntrinsicIgnores []string
+
+// ConfigReadConcurrency bounds how many audited files are read in parallel
+// while inferring system configs.
+var ConfigReadConcurrency = 16
+
+// auditEntry is a single line of apk audit output after intrinsic filtering.
+// Exactly one of config or ignored is set.
+type auditEntry struct {
+	config  *model.SystemConfigState
+	ignored *model.IgnoredConfig
+	status  string
+	isDir   bool
+}
+
 // listSystemConfigs returns all system configs added or modified by the user
 // sistem configs are configs stored in /etc folder
 // Returns included configs and ignored configs with reasons
-func listSystemConfigs(runner CommandRunner, skipIntrinsicIgnores bool) ([]model.SystemConfigState, []model.IgnoredConfig, error) {
+func listSystemConfigs(runner CommandRunner, skipIntrinsicIgnores bool, hash func(path string) bool) ([]model.SystemConfigState, []model.IgnoredConfig, error) {
 
 	cmd := "apk audit"
 	output, err := runner.Run("", cmd)
@@ -220,11 +354,8 @@ func listSystemConfigs(runner CommandRunner, skipIntrinsicIgnores bool) ([]model
 	}
 
 	lines := strings.Split(string(output), "\n")
-	configs := []model.SystemConfigState{}
-	ignored := []model.IgnoredConfig{}
-	modifiedFiles := []string{}
+	entries := []auditEntry{}
 
-linesloop:
 	for _, line := range lines {
 		line = strings.TrimSpace(line)
 		if len(line) < 2 {
@@ -244,58 +375,17 @@ linesloop:
 			continue
 		}
 
-		// ignore runlevel files
-		if strings.HasPrefix(filePath, "/etc/runlevels") {
+		// ignore files that must never be managed for security/safety reasons
+		if rule, ok := model.MatchIntrinsicIgnore(filePath, ExtraIntrinsicIgnores); ok {
 			if !skipIntrinsicIgnores {
-				ignored = append(ignored, model.IgnoredConfig{Path: filePath, Reason: "intrinsic: runlevel files"})
+				entries = append(entries, auditEntry{ignored: &model.IgnoredConfig{Path: filePath, Reason: "intrinsic: " + rule.Reason}})
 			}
 			continue
 		}
 
-		// ignore files that end with "-" or ".bak"
-		if strings.HasSuffix(filePath, "-") || strings.HasSuffix(filePath, ".bak") {
-			if !skipIntrinsicIgnores {
-				ignored = append(ignored, model.IgnoredConfig{Path: filePath, Reason: "intrinsic: backup file"})
-			}
-			continue
-		}
-
-		// ignore predefined files and paths that are managed by the system or package manager
-		// and should never be managed by Summit configurations
-		ignoredPaths := []string{
-			"/etc/passwd",    // User database, managed by system/user tools
-			"/etc/group",     // Group database, managed by system/user tools
-			"/etc/shadow",    // Shadow password file, managed by system/user tools
-			"/etc/apk/world", // APK's list of installed packages, managed by apk
-			"/etc/apk/keys",  // APK's trusted keys directory, managed by apk
-			"/etc/apk/arch",  // System architecture for APK, set by Alpine installation
-			"/etc/apk/protected_paths.d/ca-certificates.list", // APK's protected paths for ca-certificates
-		}
-		for _, ignoredPath := range ignoredPaths {
-			if filePath == ignoredPath || strings.HasPrefix(filePath, ignoredPath) {
-				if !skipIntrinsicIgnores {
-					ignored = append(ignored, model.IgnoredConfig{Path: filePath, Reason: "intrinsic: " + ignoredPath})
-				}
-				continue linesloop
-			}
-		}
-
-		if fileStatus != "X" {
-			fileInfo, err := AppFs.Stat(filePath)
-			if err != nil {
-				return nil, nil, fmt.Errorf("error stating file %s: %w", filePath, err)
-			}
-			// We can't handle directories, we just skip them for now
-			if fileInfo.IsDir() {
-				if !skipIntrinsicIgnores {
-					ignored = append(ignored, model.IgnoredConfig{Path: filePath, Reason: "intrinsic: directory"})
-				}
-				continue
-			}
-		}
-
-		config := model.SystemConfigState{
-			Path: filePath,
+		config := &model.SystemConfigState{
+			Path:       filePath,
+			FileStatus: fileStatus,
 		}
 
 		switch fileStatus {
@@ -303,17 +393,56 @@ linesloop:
 			config.Origin = model.OriginUserCreated
 		case "U": // File updated
 			config.Origin = model.OriginPackageModified
-			modifiedFiles = append(modifiedFiles, filePath)
 		case "X": // File deleted
 			config.Deleted = true
 		}
 
-		configs = append(configs, config)
+		entries = append(entries, auditEntry{config: config, status: fileStatus})
+	}
+
+	// Stat and read all existing files in parallel. Each worker only touches
+	// its own entry, so the audit order is preserved without extra sorting.
+	g := new(errgroup.Group)
+	g.SetLimit(ConfigReadConcurrency)
+	for i := range entries {
+		entry := &entries[i]
+		if entry.config == nil || entry.config.Deleted {
+			continue
+		}
+		g.Go(func() error {
+			isDir, err := loadConfigFile(entry.config, hash == nil || hash(entry.config.Path))
+			entry.isDir = isDir
+			return err
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+
+	configs := []model.SystemConfigState{}
+	ignored := []model.IgnoredConfig{}
+	modifiedFiles := []string{}
+	for _, entry := range entries {
+		if entry.ignored != nil {
+			ignored = append(ignored, *entry.ignored)
+			continue
+		}
+		// We can't handle directories, we just skip them for now
+		if entry.isDir {
+			if !skipIntrinsicIgnores {
+				ignored = append(ignored, model.IgnoredConfig{Path: entry.config.Path, Reason: "intrinsic: directory"})
+			}
+			continue
+		}
+		if entry.status == "U" {
+			modifiedFiles = append(modifiedFiles, entry.config.Path)
+		}
+		configs = append(configs, *entry.config)
 	}
 
 	// Get package owner for modified files
 	if len(modifiedFiles) > 0 {
-		ownerMap, err := getPackageOwners(runner, modifiedFiles)
+		ownerMap, err := PackageOwners(runner, modifiedFiles)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -324,57 +453,209 @@ linesloop:
 		}
 	}
 
-	// Read file content for added and updated files
-	for i := range configs {
-		if !configs[i].Deleted {
-			content, err := afero.ReadFile(AppFs, configs[i].Path)
-			if err != nil {
-				return nil, nil, fmt.Errorf("error reading file %s: %w", configs[i].Path, err)
-			}
-			configs[i].Content = string(content)
+	return configs, ignored, nil
+}
+
+// loadConfigFile fills in content, mode and ownership for an existing file
+// using a single open; the content is only hashed when hash is set. It reports
+// whether the path turned out to be a directory, in which case the config is
+// left untouched.
+func loadConfigFile(config *model.SystemConfigState, hash bool) (bool, error) {
+	f, err := AppFs.Open(config.Path)
+	if err != nil {
+		return false, fmt.Errorf("error stating file %s: %w", config.Path, err)
+	}
+	defer f.Close()
+
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return false, fmt.Errorf("error stating file %s: %w", config.Path, err)
+	}
+	if fileInfo.IsDir() {
+		return true, nil
+	}
+
+	// Only keep a digest; content is re-read on demand for the few files that
+	// end up being diffed or dumped.
+	digest := ""
+	if hash {
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, f); err != nil {
+			return false, fmt.Errorf("error reading file %s: %w", config.Path, err)
+		}
+		digest = hex.EncodeToString(hasher.Sum(nil))
+	}
+	path := config.Path
+	config.SetLazyContent(digest, func() (string, error) {
+		content, err := afero.ReadFile(AppFs, path)
+		if err != nil {
+			return "", fmt.Errorf("error reading file %s: %w", path, err)
+		}
+		return string(content), nil
+	})
+
+	// Get file mode, owner, and group
+	if fileInfo.Sys() != nil {
+		stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+		if !ok {
+			return false, fmt.Errorf("error getting syscall.Stat_t for %s", config.Path)
+		}
+		// Get owner
+		uid := fmt.Sprint(stat.Uid)
+		u, err := user.LookupId(uid)
+		if err != nil {
+			config.Owner = uid // fallback to UID if lookup fails
+		} else {
+			config.Owner = u.Username
+		}
+
+		// Get group
+		gid := fmt.Sprint(stat.Gid)
+		g, err := user.LookupGroupId(gid)
+		if err != nil {
+			config.Group = gid // fallback to GID if lookup fails
+		} else {
+			config.Group = g.Name
+		}
+	}
+
+	config.Mode = model.FormatMode(fileInfo.Mode())
+	return false, nil
+}
+
+// listSysctl returns the runtime values of all kernel parameters, or nil when
+// sysctl is unavailable, e.g. in a container.
+func listSysctl(runner CommandRunner) map[string]string {
+	out, err := runner.Run("", "sysctl -a")
+	if err != nil {
+		return nil
+	}
+	var values map[string]string
+	for _, line := range strings.Split(string(out), "\n") {
+		key, value, ok := strings.Cut(line, " = ")
+		if !ok {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
+// ReadTimezone returns the zone /etc/localtime links to, or "" when it is not
+// a link into the zoneinfo database.
+func ReadTimezone(runner CommandRunner) string {
+	out, err := runner.Run("", "readlink "+model.LocaltimePath)
+	if err != nil {
+		return ""
+	}
+	zone, ok := strings.CutPrefix(strings.TrimSpace(string(out)), model.ZoneinfoDir+"/")
+	if !ok {
+		return ""
+	}
+	return zone
+}
 
-			// Get FileInfo for mode and ownership
-			fileInfo, err := AppFs.Stat(configs[i].Path)
+// AuthorizedKeysPath returns the authorized_keys file under a home directory.
+func AuthorizedKeysPath(home string) string {
+	return filepath.Join(home, ".ssh", "authorized_keys")
+}
+
+// DefaultUserConfigs are the dotfiles inferred for a user when no paths are
+// given: shell and editor settings, never history or credentials.
+var DefaultUserConfigs = []string{
+	".profile", ".ashrc", ".bashrc", ".bash_profile", ".zshrc", ".inputrc",
+	".vimrc", ".nanorc", ".tmux.conf", ".gitconfig",
+}
+
+// ListUserConfigs returns the regular files of a user's home that match
+// patterns, paths or globs relative to the home. Users that do not exist yet
+// have no files.
+func ListUserConfigs(userName string, patterns []string) ([]model.UserConfigState, error) {
+	home, _, gid, err := LookupHome(userName)
+	if err != nil {
+		return nil, nil
+	}
+	gidToName, err := buildGidToNameMap()
+	if err != nil {
+		return nil, err
+	}
+	primaryGroup, ok := gidToName[gid]
+	if !ok {
+		primaryGroup = strconv.Itoa(gid)
+	}
+
+	var configs []model.UserConfigState
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		if filepath.IsAbs(pattern) || slices.Contains(strings.Split(filepath.ToSlash(pattern), "/"), "..") {
+			return nil, fmt.Errorf("invalid pattern %s for user %s: must stay inside the home directory", pattern, userName)
+		}
+		matches, err := afero.Glob(AppFs, filepath.Join(home, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %s for user %s: %w", pattern, userName, err)
+		}
+		for _, match := range matches {
+			if seen[match] {
+				continue
+			}
+			seen[match] = true
+			// Files are read as root: a link of the user could expose any file
+			if RefuseSymlinks(home, match) != nil {
+				continue
+			}
+			config := &model.SystemConfigState{Path: match}
+			isDir, err := loadConfigFile(config, false)
 			if err != nil {
-				return nil, nil, fmt.Errorf("error stating file %s: %w", configs[i].Path, err)
+				return nil, err
 			}
-
-			// Get file mode, owner, and group
-			if fileInfo.Sys() != nil {
-				stat, ok := fileInfo.Sys().(*syscall.Stat_t)
-				if !ok {
-					return nil, nil, fmt.Errorf("error getting syscall.Stat_t for %s", configs[i].Path)
-				} else {
-					// Get owner
-					uid := fmt.Sprint(stat.Uid)
-					u, err := user.LookupId(uid)
-					var ownerName string
-					if err != nil {
-						ownerName = uid // fallback to UID if lookup fails
-					} else {
-						ownerName = u.Username
-					}
-
-					// Get group
-					gid := fmt.Sprint(stat.Gid)
-					g, err := user.LookupGroupId(gid)
-					var groupName string
-					if err != nil {
-						groupName = gid // fallback to GID if lookup fails
-					} else {
-						groupName = g.Name
-					}
-
-					configs[i].Owner = ownerName
-					configs[i].Group = groupName
-				}
+			if isDir {
+				continue
 			}
-
-			configs[i].Mode = fmt.Sprintf("0%o", fileInfo.Mode().Perm())
+			content, err := config.LoadContent()
+			if err != nil {
+				return nil, err
+			}
+			rel, _ := filepath.Rel(home, match)
+			configs = append(configs, model.UserConfigState{
+				User:         userName,
+				Path:         rel,
+				Content:      content,
+				Mode:         config.Mode,
+				Owner:        config.Owner,
+				Group:        config.Group,
+				PrimaryGroup: primaryGroup,
+			})
 		}
 	}
+	return configs, nil
+}
 
-	return configs, ignored, nil
+// LookupHome returns the home directory, uid and gid of a user from
+// /etc/passwd.
+func LookupHome(userName string) (string, int, int, error) {
+	content, err := afero.ReadFile(AppFs, "/etc/passwd")
+	if err != nil {
+		return "", 0, 0, fmt.Errorf("Error reading /etc/passwd: %w", err)
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 7 || fields[0] != userName {
+			continue
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid uid for user %s in /etc/passwd", userName)
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return "", 0, 0, fmt.Errorf("invalid gid for user %s in /etc/passwd", userName)
+		}
+		return fields[5], uid, gid, nil
+	}
+	return "", 0, 0, fmt.Errorf("user %s not found in /etc/passwd", userName)
 }
 
 func listGroupsForUser(runner CommandRunner, userName string) ([]string, error) {
@@ -399,15 +680,28 @@ func listGroupsForUser(runner CommandRunner, userName string) ([]string, error)
 	return groups, nil
 }
 
-func getPackageOwners(runner CommandRunner, files []string) (map[string]string, error) {
-	ownerMap := make(map[string]string)
-	args := append([]string{"info", "--who-owns"}, files...)
-	cmd := fmt.Sprintf("apk %s", strings.Join(args, " "))
-	output, err := runner.Run("", cmd)
+// listCrontab returns the jobs in the summit-owned block of the user's
+// crontab. Users without a crontab have no jobs.
+func listCrontab(runner CommandRunner, userName string) []model.CronJobState {
+	output, err := runner.Run("", fmt.Sprintf("crontab -u %s -l", userName))
 	if err != nil {
-		// Ignore errors, as some files may not be owned by any package
+		return nil
 	}
+	return model.ParseCrontab(string(output))
+}
 
+// PackageOwners maps each of the given paths owned by an installed package to
+// the package name. Paths not owned by any package are left out.
+func PackageOwners(runner CommandRunner, files []string) (map[string]string, error) {
+	ownerMap := make(map[string]string)
+	quoted := make([]string, len(files))
+	for i, file := range files {
+		quoted[i] = ShellQuote(file)
+	}
+	output, err := runner.Run("", "apk info --who-owns "+strings.Join(quoted, " "))
+
+	// apk fails when any path has no owner, listing the others all the same
+	answered := false
 	scanner := bufio.NewScanner(strings.NewReader(string(output)))
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -416,8 +710,14 @@ func getPackageOwners(runner CommandRunner, files []string) (map[string]string,
 			path := strings.TrimSpace(parts[0])
 			owner := strings.TrimSpace(parts[1])
 			ownerMap[path] = owner
+			answered = true
+		} else if strings.Contains(line, "Could not find owner package") {
+			answered = true
 		}
 	}
+	if err != nil && !answered {
+		return nil, fmt.Errorf("failed to look up the packages owning %s: %w", strings.Join(files, ", "), err)
+	}
 
 	return ownerMap, nil
 }
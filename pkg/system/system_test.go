@@ -1,6 +1,11 @@
 package system
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"summit/pkg/model"
@@ -17,7 +22,7 @@ func TestInferSystemState(t *testing.T) {
 
 	// Setup /etc/apk/world
 	require.NoError(t, AppFs.MkdirAll("/etc/apk", 0755))
-	require.NoError(t, afero.WriteFile(AppFs, "/etc/apk/world", []byte("package1\npackage2\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/apk/world", []byte("package1\npackage2\nnginx=1.24.0-r7\nvim>=9\n"), 0644))
 
 	// Setup /etc/init.d
 	require.NoError(t, AppFs.MkdirAll("/etc/init.d", 0755))
@@ -29,29 +34,47 @@ func TestInferSystemState(t *testing.T) {
 	require.NoError(t, afero.WriteFile(AppFs, "/etc/passwd", []byte("root:x:0:0:root:/root:/bin/bash\ntestuser:x:1000:1000:testuser:/home/testuser:/bin/bash\n"), 0644))
 
 	// Setup /etc/group
-	require.NoError(t, afero.WriteFile(AppFs, "/etc/group", []byte("root:x:0:\ntestuser:x:1000:\nwheel:x:10:\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/group", []byte("root:x:0:\ntestuser:x:1000:\nwheel:x:10:\ndevs:x:1500:testuser\nnogroup:x:65533:\n"), 0644))
 
 	// Mock runner for apk audit and groups
 	runner := test.NewMockCommandRunner()
 	runner.SetResponse("", "apk audit", []byte("A /etc/test.conf"))
 	runner.SetResponse("", "groups testuser", []byte("testuser wheel"))
+	runner.SetResponse("", "crontab -u testuser -l", []byte("@hourly mine\n# BEGIN summit\n# backup\n0 3 * * * backup.sh\n# END summit\n"))
+	runner.SetResponse("testuser", "pipx list --json", []byte(`{"venvs": {"ruff": {"metadata": {"package": "ruff"}}, "black": {"metadata": {"package": "black"}}}}`))
+	runner.SetError("testuser", "npm list --json", errors.New("npm: not found"))
+	runner.SetError("testuser", "cargo install --list", errors.New("cargo: not found"))
 
 	// Setup /etc/test.conf
 	require.NoError(t, afero.WriteFile(AppFs, "/etc/test.conf", []byte("content"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/home/testuser/.ssh/authorized_keys", []byte("ssh-rsa AAAAold laptop\n# BEGIN summit\nssh-ed25519 AAAAnew ci\n# END summit\n"), 0600))
 
 	state, _, err := InferSystemState(runner, false)
 	require.NoError(t, err)
 
 	// Check packages
-	assert.Len(t, state.Packages, 2)
+	assert.Len(t, state.Packages, 4)
 	assert.Contains(t, state.Packages, model.PackageState{Name: "package1"})
 	assert.Contains(t, state.Packages, model.PackageState{Name: "package2"})
+	assert.Contains(t, state.Packages, model.PackageState{Name: "nginx", Version: "1.24.0-r7"})
+	assert.Contains(t, state.Packages, model.PackageState{Name: "vim"})
 
 	// Check users
 	assert.Len(t, state.Users, 1)
 	assert.Equal(t, "testuser", state.Users[0].Name)
 	assert.Equal(t, "testuser", state.Users[0].PrimaryGroup)
+	assert.Equal(t, 1000, state.Users[0].UID)
+	// Primary, system and overflow groups are not listed
+	assert.Equal(t, []model.GroupState{{Name: "devs", GID: 1500}}, state.Groups)
+	assert.Equal(t, "/bin/bash", state.Users[0].Shell)
+	assert.Equal(t, "/home/testuser", state.Users[0].Home)
+	assert.Equal(t, "testuser", state.Users[0].Gecos)
 	assert.Contains(t, state.Users[0].Groups, "wheel")
+	assert.Equal(t, []model.CronJobState{{Name: "backup", Schedule: "0 3 * * *", Command: "backup.sh"}}, state.Users[0].Crontab)
+	assert.Equal(t, []string{"ssh-ed25519 AAAAnew ci"}, state.Users[0].AuthorizedKeys)
+	assert.Equal(t, []string{"ssh-rsa AAAAold laptop"}, state.Users[0].UnmanagedKeys)
+	// A user without npm still has their pipx packages listed
+	assert.Equal(t, []model.UserPackageState{{User: "testuser", Pipx: []string{"black", "ruff"}}}, state.UserPackages)
 
 	// Check configs
 	assert.Len(t, state.Configs, 1)
@@ -203,3 +226,343 @@ func TestInferSystemState_EdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestListSystemConfigs_PreservesAuditOrder(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	runner := test.NewMockCommandRunner()
+
+	var audit strings.Builder
+	var expected []string
+	for i := 0; i < 100; i++ {
+		path := fmt.Sprintf("/etc/conf.d/file%03d", 99-i)
+		require.NoError(t, afero.WriteFile(AppFs, path, []byte(path), 0644))
+		fmt.Fprintf(&audit, "A %s\n", strings.TrimPrefix(path, "/"))
+		expected = append(expected, path)
+		if i == 50 {
+			require.NoError(t, AppFs.MkdirAll("/etc/subdir", 0755))
+			audit.WriteString("A etc/subdir\n")
+			audit.WriteString("A etc/passwd\n")
+		}
+	}
+	runner.SetResponse("", "apk audit", []byte(audit.String()))
+
+	configs, ignored, err := listSystemConfigs(runner, false, nil)
+	require.NoError(t, err)
+
+	require.Len(t, configs, len(expected))
+	for i, c := range configs {
+		assert.Equal(t, expected[i], c.Path)
+		content, err := c.LoadContent()
+		require.NoError(t, err)
+		assert.Equal(t, expected[i], content)
+		assert.Empty(t, c.Content, "content should be loaded lazily")
+		assert.Equal(t, "0644", c.Mode)
+	}
+	assert.Equal(t, []model.IgnoredConfig{
+		{Path: "/etc/subdir", Reason: "intrinsic: directory"},
+		{Path: "/etc/passwd", Reason: "intrinsic: /etc/passwd"},
+	}, ignored)
+}
+
+func TestInferSystemStateFor_HashesOnlyWantedPaths(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/apk/world", []byte(""), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/passwd", []byte("alice:x:1000:1000::/home/alice:/bin/sh\nbob:x:1001:1001::/home/bob:/bin/sh\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/group", []byte(""), 0644))
+	require.NoError(t, AppFs.MkdirAll("/etc/init.d", 0755))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/motd", []byte("hello"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/big.conf", []byte("unrelated"), 0600))
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk audit", []byte("A etc/motd\nA etc/big.conf\n"))
+	runner.SetResponse("", "crontab -u alice -l", []byte("# BEGIN summit\n@daily backup\n# END summit\n"))
+
+	state, _, err := InferSystemStateFor(runner, []string{"/etc/motd"}, []string{"alice"})
+	require.NoError(t, err)
+	require.Len(t, state.Configs, 2)
+
+	// Only the crontabs of the given users are listed
+	assert.Contains(t, runner.Commands, "crontab -u alice -l")
+	assert.NotContains(t, runner.Commands, "crontab -u bob -l")
+	require.Len(t, state.Users, 2)
+	assert.Len(t, state.Users[0].Crontab, 1)
+
+	motd, big := state.Configs[0], state.Configs[1]
+	assert.Equal(t, model.HashContent("hello"), motd.ContentHash)
+	assert.Empty(t, big.ContentHash, "files the plan does not compare are not read")
+	assert.Equal(t, "0600", big.Mode)
+
+	// Unhashed content is still read when it is compared or needed
+	assert.True(t, big.ContentEquals("unrelated"))
+	assert.False(t, big.ContentEquals("other"))
+	content, err := big.LoadContent()
+	require.NoError(t, err)
+	assert.Equal(t, "unrelated", content)
+}
+
+func TestListServices_SeveralRunlevels(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	for _, path := range []string{"/etc/init.d/sshd", "/etc/init.d/crond", "/etc/init.d/acpid", "/etc/runlevels/default/sshd", "/etc/runlevels/boot/crond", "/etc/runlevels/default/crond", "/etc/runlevels/nonetwork/crond"} {
+		require.NoError(t, afero.WriteFile(AppFs, path, []byte("#!/sbin/openrc-run"), 0755))
+	}
+
+	services, err := listServices()
+	require.NoError(t, err)
+	assert.Equal(t, []model.ServiceState{
+		{Name: "acpid"},
+		{Name: "crond", Enabled: true, Runlevel: "boot", ExtraRunlevels: []string{"default", "nonetwork"}},
+		{Name: "sshd", Enabled: true, Runlevel: "default"},
+	}, services)
+}
+
+func TestListServiceStatus(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "rc-status --servicelist", []byte(" sshd                    [  started 01:02:03 (0) ]\n crond                   [  stopped  ]\n nginx                   [  crashed  ]\n"))
+
+	assert.Equal(t, map[string]bool{"sshd": true, "crond": false, "nginx": false}, listServiceStatus(runner))
+
+	runner.SetError("", "rc-status --servicelist", fmt.Errorf("rc-status: not found"))
+	assert.Nil(t, listServiceStatus(runner))
+}
+
+func TestListSysctl(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "sysctl -a", []byte("kernel.hostname = web1\nnet.ipv4.tcp_rmem = 4096\t87380\t6291456\nsysctl: permission denied on key 'fs.protected_regular'\n"))
+
+	assert.Equal(t, map[string]string{
+		"kernel.hostname":   "web1",
+		"net.ipv4.tcp_rmem": "4096\t87380\t6291456",
+	}, listSysctl(runner))
+
+	runner.SetError("", "sysctl -a", fmt.Errorf("sysctl: not found"))
+	assert.Nil(t, listSysctl(runner))
+}
+
+func TestInstalledVersions(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk info -v", []byte("musl-1.2.4_git20230717-r4\nlinux-firmware-none-20231111-r1\nWARNING: opening /var/cache/apk-tools: No such file\n"))
+
+	versions, err := InstalledVersions(runner)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"musl": "1.2.4_git20230717-r4", "linux-firmware-none": "20231111-r1"}, versions)
+}
+
+func TestPackageOwners(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk info --who-owns '/etc/nginx/nginx.conf' '/etc/x;reboot'", []byte("/etc/nginx/nginx.conf is owned by nginx-1.24.0-r1\nERROR: /etc/x;reboot: Could not find owner package\n"))
+
+	owners, err := PackageOwners(runner, []string{"/etc/nginx/nginx.conf", "/etc/x;reboot"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/etc/nginx/nginx.conf": "nginx-1.24.0-r1"}, owners)
+
+	runner.SetError("", "apk info --who-owns '/etc/motd'", errors.New("apk: not found"))
+	_, err = PackageOwners(runner, []string{"/etc/motd"})
+	assert.EqualError(t, err, "failed to look up the packages owning /etc/motd: apk: not found")
+}
+
+func TestReadTimezone(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "readlink /etc/localtime", []byte("/usr/share/zoneinfo/Europe/Rome\n"))
+	assert.Equal(t, "Europe/Rome", ReadTimezone(runner))
+
+	runner.SetResponse("", "readlink /etc/localtime", []byte("/etc/zoneinfo/UTC\n"))
+	assert.Equal(t, "", ReadTimezone(runner), "links outside the zoneinfo database are unknown")
+
+	runner.SetError("", "readlink /etc/localtime", fmt.Errorf("exit status 1"))
+	assert.Equal(t, "", ReadTimezone(runner))
+}
+
+func TestListSystemConfigs_MissingFile(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk audit", []byte("A etc/missing.conf\n"))
+
+	_, _, err := listSystemConfigs(runner, false, nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "error stating file /etc/missing.conf")
+}
+
+func TestListSystemConfigs_ExtraIntrinsicIgnores(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	ExtraIntrinsicIgnores = []string{"/etc/wireguard"}
+	t.Cleanup(func() { ExtraIntrinsicIgnores = nil })
+	runner := test.NewMockCommandRunner()
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/motd", []byte("hi"), 0644))
+	runner.SetResponse("", "apk audit", []byte("A etc/wireguard/wg0.conf\nA etc/motd\nA etc/motd.bak\n"))
+
+	configs, ignored, err := listSystemConfigs(runner, false, nil)
+	require.NoError(t, err)
+
+	require.Len(t, configs, 1)
+	assert.Equal(t, "/etc/motd", configs[0].Path)
+	assert.Equal(t, []model.IgnoredConfig{
+		{Path: "/etc/wireguard/wg0.conf", Reason: "intrinsic: /etc/wireguard"},
+		{Path: "/etc/motd.bak", Reason: "intrinsic: backup file"},
+	}, ignored)
+}
+
+func TestUserCommand(t *testing.T) {
+	assert.Equal(t, "apk info", UserCommand("", "apk info"))
+	assert.Equal(t, "su -l alice -c 'pipx list --short'", UserCommand("alice", "pipx list --short"))
+}
+
+func TestListUserConfigs(t *testing.T) {
+	AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/passwd", []byte("alice:x:1000:1000::/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/group", []byte("alice:x:1000:\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/home/alice/.vimrc", []byte("syntax on\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/home/alice/.ash_history", []byte("ls\n"), 0600))
+	require.NoError(t, afero.WriteFile(AppFs, "/home/alice/.config/git/config", []byte("[user]\n"), 0600))
+	require.NoError(t, AppFs.MkdirAll("/home/alice/.config/nvim", 0755))
+
+	configs, err := ListUserConfigs("alice", DefaultUserConfigs)
+	require.NoError(t, err)
+	assert.Equal(t, []model.UserConfigState{
+		{User: "alice", Path: ".vimrc", Content: "syntax on\n", Mode: "0644", PrimaryGroup: "alice"},
+	}, configs, "history files are not among the default dotfiles")
+
+	configs, err = ListUserConfigs("alice", []string{".config/*/*", ".config/*"})
+	require.NoError(t, err)
+	require.Len(t, configs, 1, "directories are skipped")
+	assert.Equal(t, ".config/git/config", configs[0].Path)
+	assert.Equal(t, "0600", configs[0].Mode)
+
+	configs, err = ListUserConfigs("bob", DefaultUserConfigs)
+	require.NoError(t, err)
+	assert.Empty(t, configs, "users that do not exist have no files")
+}
+
+func TestListUserConfigs_Symlinks(t *testing.T) {
+	origFs := AppFs
+	t.Cleanup(func() { AppFs = origFs })
+	root := t.TempDir()
+	AppFs = afero.NewBasePathFs(afero.NewOsFs(), root)
+	require.NoError(t, AppFs.MkdirAll("/etc", 0755))
+	require.NoError(t, AppFs.MkdirAll("/home/alice", 0755))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/passwd", []byte("alice:x:1000:1000::/home/alice:/bin/ash\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/group", []byte("alice:x:1000:\n"), 0644))
+	require.NoError(t, afero.WriteFile(AppFs, "/etc/shadow", []byte("root:secret\n"), 0600))
+	require.NoError(t, afero.WriteFile(AppFs, "/home/alice/.profile", []byte("umask 022\n"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(root, "etc/shadow"), filepath.Join(root, "home/alice/.vimrc")))
+
+	configs, err := ListUserConfigs("alice", []string{".*"})
+	require.NoError(t, err)
+	require.Len(t, configs, 1, "links are not followed")
+	assert.Equal(t, ".profile", configs[0].Path)
+
+	for _, pattern := range []string{"../../etc/shadow", "/etc/shadow", ".config/../../*"} {
+		_, err := ListUserConfigs("alice", []string{pattern})
+		assert.ErrorContains(t, err, "must stay inside the home directory", pattern)
+	}
+}
+
+func TestDetectPackageManager(t *testing.T) {
+	origFs := AppFs
+	t.Cleanup(func() { AppFs = origFs })
+	AppFs = afero.NewMemMapFs()
+	assert.Equal(t, "apk", DetectPackageManager().Name(), "apk without /etc/os-release")
+
+	for osRelease, want := range map[string]string{
+		"NAME=\"Alpine Linux\"\nID=alpine\n":             "apk",
+		"ID=debian\nVERSION_ID=\"12\"\n":                 "apt",
+		"ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n":      "apt",
+		"ID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\n": "dnf",
+		"ID=arch\n":   "pacman",
+		"ID=gentoo\n": "apk",
+	} {
+		require.NoError(t, afero.WriteFile(AppFs, "/etc/os-release", []byte(osRelease), 0644))
+		assert.Equal(t, want, DetectPackageManager().Name(), osRelease)
+	}
+}
+
+func TestPackageManagers(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apt-mark showmanual", []byte("curl\nhtop\n"))
+	runner.SetResponse("", "pacman -Q", []byte("bash 5.2.026-2\nlinux 6.8.1.arch1-1\n"))
+
+	packages, err := Apt{}.Installed(runner)
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "curl"}, {Name: "htop"}}, packages)
+	versions, err := Pacman{}.Versions(runner)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"bash": "5.2.026-2", "linux": "6.8.1.arch1-1"}, versions)
+
+	assert.Equal(t, "apk add htop=3.3.0-r0", Apk{}.InstallCommand("htop", "3.3.0-r0"))
+	assert.Equal(t, "dnf install -y htop", Dnf{}.InstallCommand("htop", "3.3.0"))
+	assert.Equal(t, "pacman -R --noconfirm htop", Pacman{}.RemoveCommand("htop"))
+}
+
+func TestVirtualPackages(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("", "apk info -R .build-deps", []byte(".build-deps-20240101.120000 depends on:\ngcc\nmake\n\n"))
+
+	packages, virtual, err := VirtualPackages(runner, []model.PackageState{{Name: "htop"}, {Name: ".build-deps"}})
+	require.NoError(t, err)
+	assert.Equal(t, []model.PackageState{{Name: "htop"}}, packages)
+	assert.Equal(t, []model.VirtualPackageState{{Name: ".build-deps", Packages: []string{"gcc", "make"}}}, virtual)
+}
+
+func TestApk_OfflineAddCommand(t *testing.T) {
+	origFs := AppFs
+	t.Cleanup(func() { AppFs = origFs })
+	AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(AppFs, "/var/cache/apk/APKINDEX.12345678.tar.gz", []byte("index"), 0644))
+
+	assert.Equal(t, "apk add htop", Apk{}.InstallCommand("htop", ""))
+	assert.Equal(t, "apk add --no-network htop=3.3.0-r0", Apk{Offline: true}.InstallCommand("htop", "3.3.0-r0"))
+	assert.Equal(t, "apk add --no-network --cache-dir /var/cache/apk htop", Apk{Offline: true, Source: "/var/cache/apk"}.InstallCommand("htop", ""))
+	assert.Equal(t, "apk add --no-network --repositories-file /dev/null --repository /srv/apk htop", Apk{Offline: true, Source: "/srv/apk"}.InstallCommand("htop", ""))
+}
+
+func TestLiveCommandRunner_Env(t *testing.T) {
+	runner := &LiveCommandRunner{Env: []string{"http_proxy=http://proxy.example.com:3128"}}
+
+	out, err := runner.Run("", "echo $http_proxy")
+	require.NoError(t, err)
+	assert.Equal(t, "http://proxy.example.com:3128\n", string(out))
+}
+
+func TestLiveCommandRunner_RunInput(t *testing.T) {
+	runner := &LiveCommandRunner{}
+
+	out, err := runner.RunInput("", "echo warning >&2; tr a-z A-Z", []byte("secret"))
+	require.NoError(t, err)
+	assert.Equal(t, "SECRET", string(out), "stderr is left out of the output")
+
+	_, err = runner.RunInput("", "echo bad key >&2; exit 1", nil)
+	assert.EqualError(t, err, "exit status 1: bad key")
+}
+
+func TestListUserPackages_Cargo(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("mino", "cargo install --list", []byte("ripgrep v14.1.0:\n    rg\nbat v0.24.0 (/home/mino/src/bat):\n    bat\n"))
+
+	packages, err := ListUserPackages("mino", "cargo", runner)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bat", "ripgrep"}, packages)
+}
+
+func TestListUserPackages_Gem(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	// The gems of the system are listed along those of the user without the
+	// user's gem directory as the only gem path
+	runner.SetResponse("mino", "gem list --local", []byte("\n*** LOCAL GEMS ***\n\nbundler (default: 2.4.19)\njson (2.7.1, default: 2.6.3)\nrake (13.0.6)\nrexml (3.2.6)\n"))
+	runner.SetResponse("mino", gemUserList, []byte("\n*** LOCAL GEMS ***\n\njson (2.7.1)\nrake (13.0.6)\n"))
+
+	packages, err := ListUserPackages("mino", "gem", runner)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"json", "rake"}, packages)
+}
+
+func TestListUserPackages_Uv(t *testing.T) {
+	runner := test.NewMockCommandRunner()
+	runner.SetResponse("mino", "uv tool list", []byte("ruff v0.4.4\n- ruff\nhttpie v3.2.2\n- http\n- https\n"))
+
+	packages, err := ListUserPackages("mino", "uv", runner)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"httpie", "ruff"}, packages)
+
+	runner.SetResponse("mino", "uv tool list", []byte("No tools installed\n"))
+	packages, err = ListUserPackages("mino", "uv", runner)
+	require.NoError(t, err)
+	assert.Empty(t, packages)
+}
@@ -0,0 +1,162 @@
+package system
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"summit/pkg/model"
+)
+
+type PipxPackageMetadata struct {
+	Package string `json:"package"`
+}
+
+type PipxVenv struct {
+	Metadata PipxPackageMetadata `json:"metadata"`
+}
+
+type PipxListOutput struct {
+	Venvs map[string]PipxVenv `json:"venvs"`
+}
+
+type NpmDependency struct {
+	Version string `json:"version"`
+}
+
+type NpmListOutput struct {
+	Dependencies map[string]NpmDependency `json:"dependencies"`
+}
+
+// gemUserList lists the gems of the user's gem directory only: plain gem list
+// also shows the gems of the system, which are not the user's to uninstall.
+const gemUserList = `dir=$(ruby -e 'print Gem.user_dir') && GEM_HOME=$dir GEM_PATH=$dir gem list --local`
+
+// userPackageCommands are the commands of each user package manager that
+// list, install and uninstall packages, and whether its install command takes
+// several packages at once.
+var userPackageCommands = map[string]struct {
+	list, install, uninstall string
+	batch                    bool
+}{
+	"pipx":  {"pipx list --json", "pipx install", "pipx uninstall", true},
+	"npm":   {"npm list --json", "npm install", "npm uninstall", true},
+	"cargo": {"cargo install --list", "cargo install", "cargo uninstall", true},
+	"gem":   {gemUserList, "gem install --user-install", "gem uninstall --user-install -x", true},
+	"uv":    {"uv tool list", "uv tool install", "uv tool uninstall", false},
+}
+
+// UserPackageBatchInstall reports whether manager installs several packages
+// with a single command, e.g. pipx install black ruff.
+func UserPackageBatchInstall(manager string) bool {
+	return userPackageCommands[manager].batch
+}
+
+// UserPackageCommand returns the command installing pkg with manager or,
+// when install is false, uninstalling it.
+func UserPackageCommand(manager, pkg string, install bool) string {
+	commands, ok := userPackageCommands[manager]
+	if !ok {
+		commands.install, commands.uninstall = manager+" install", manager+" uninstall"
+	}
+	if install {
+		return commands.install + " " + pkg
+	}
+	return commands.uninstall + " " + pkg
+}
+
+// UserPackageInstallCommand returns the command installing packages with
+// manager at once.
+func UserPackageInstallCommand(manager string, packages []string) string {
+	return UserPackageCommand(manager, strings.Join(packages, " "), true)
+}
+
+// ListUserPackages returns the packages a user has installed with manager,
+// pipx, npm, cargo, gem or uv.
+func ListUserPackages(user, manager string, runner CommandRunner) ([]string, error) {
+	command := manager + " list --json"
+	if commands, ok := userPackageCommands[manager]; ok {
+		command = commands.list
+	}
+	out, err := runner.Run(user, command)
+	if err != nil {
+		// Handle case where user or manager is not found, or command fails
+		return nil, fmt.Errorf("could not list %s packages for user %s: %v", manager, user, err)
+	}
+
+	installedPackages := []string{}
+
+	switch manager {
+	case "pipx":
+		var pipxOutput PipxListOutput
+		if err := json.Unmarshal(out, &pipxOutput); err != nil {
+			return nil, fmt.Errorf("could not parse pipx list output for user %s: %v", user, err)
+		}
+		for _, venv := range pipxOutput.Venvs {
+			installedPackages = append(installedPackages, venv.Metadata.Package)
+		}
+	case "npm":
+		var npmOutput NpmListOutput
+		if err := json.Unmarshal(out, &npmOutput); err != nil {
+			return nil, fmt.Errorf("could not parse npm list output for user %s: %v", user, err)
+		}
+		for pkg := range npmOutput.Dependencies {
+			installedPackages = append(installedPackages, pkg)
+		}
+	case "cargo":
+		// Each crate is listed as "ripgrep v14.1.0:", followed by its
+		// binaries indented below it
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+				installedPackages = append(installedPackages, fields[0])
+			}
+		}
+	case "gem":
+		// Gems are listed as "rake (13.0.6)"; the default gems shipped
+		// with ruby, "bundler (default: 2.4.19)", cannot be uninstalled
+		for _, line := range strings.Split(string(out), "\n") {
+			name, versions, ok := strings.Cut(strings.TrimSpace(line), " (")
+			if !ok || strings.HasPrefix(name, "*") {
+				continue
+			}
+			for _, version := range strings.Split(strings.TrimSuffix(versions, ")"), ",") {
+				if !strings.HasPrefix(strings.TrimSpace(version), "default:") {
+					installedPackages = append(installedPackages, name)
+					break
+				}
+			}
+		}
+	case "uv":
+		// Each tool is listed as "ruff v0.4.4", followed by its
+		// executables as "- ruff"; no tools at all prints a message
+		for _, line := range strings.Split(string(out), "\n") {
+			if fields := strings.Fields(line); len(fields) >= 2 && strings.HasPrefix(fields[1], "v") {
+				installedPackages = append(installedPackages, fields[0])
+			}
+		}
+	}
+	sort.Strings(installedPackages)
+	return installedPackages, nil
+}
+
+// listUserPackages returns the packages of users for every user package
+// manager, best effort: users without any of the tools, or whose tools fail,
+// are left out.
+func listUserPackages(runner CommandRunner, users []model.UserState) []model.UserPackageState {
+	var result []model.UserPackageState
+	for _, u := range users {
+		state := model.UserPackageState{User: u.Name}
+		found := false
+		for _, manager := range model.UserPackageManagers {
+			if packages, _ := ListUserPackages(u.Name, manager, runner); len(packages) > 0 {
+				*state.ManagerPackages(manager) = packages
+				found = true
+			}
+		}
+		if found {
+			result = append(result, state)
+		}
+	}
+	return result
+}
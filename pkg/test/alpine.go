@@ -0,0 +1,180 @@
+package test
+
+import (
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/require"
+)
+
+// FakeAlpine is an in-memory Alpine Linux system. Mutator methods keep the
+// filesystem and the runner's canned responses consistent with each other, so
+// state inference sees the same system that actions modify.
+type FakeAlpine struct {
+	Fs     afero.Fs
+	Runner *MockCommandRunner
+	Logger *MockLogger
+
+	t      *testing.T
+	audit  map[string]string // path -> apk audit status (A, U, X)
+	owners map[string]string // path -> owning package for U entries
+	groups map[string][]string
+}
+
+// NewFakeAlpine creates a fake system seeded with the files summit expects
+// to find on a fresh Alpine install.
+func NewFakeAlpine(t *testing.T) *FakeAlpine {
+	t.Helper()
+	a := &FakeAlpine{
+		Fs:     afero.NewMemMapFs(),
+		Runner: NewMockCommandRunner(),
+		Logger: NewMockLogger(slog.LevelDebug),
+		t:      t,
+		audit:  make(map[string]string),
+		owners: make(map[string]string),
+		groups: make(map[string][]string),
+	}
+
+	CreateTestFile(t, a.Fs, "/etc/apk/world", "")
+	CreateTestDir(t, a.Fs, "/etc/init.d")
+	for _, rl := range []string{"boot", "default", "sysinit", "nonetwork", "shutdown"} {
+		CreateTestDir(t, a.Fs, filepath.Join("/etc/runlevels", rl))
+	}
+	CreateTestFile(t, a.Fs, "/etc/passwd", "root:x:0:0:root:/root:/bin/ash\n")
+	CreateTestFile(t, a.Fs, "/etc/group", "root:x:0:root\nwheel:x:10:root\n")
+
+	a.refresh()
+	return a
+}
+
+// Install points target (usually &system.AppFs) at the fake filesystem and
+// restores the previous value when the test finishes.
+func (a *FakeAlpine) Install(target *afero.Fs) {
+	orig := *target
+	*target = a.Fs
+	a.t.Cleanup(func() { *target = orig })
+}
+
+// AddPackage appends a package to /etc/apk/world.
+func (a *FakeAlpine) AddPackage(name string) {
+	a.t.Helper()
+	a.appendLine("/etc/apk/world", name)
+}
+
+// AddService creates an init script and, if runlevel is not empty, enables it.
+func (a *FakeAlpine) AddService(name, runlevel string) {
+	a.t.Helper()
+	CreateTestFile(a.t, a.Fs, filepath.Join("/etc/init.d", name), "#!/sbin/openrc-run\n")
+	if runlevel != "" {
+		CreateTestFile(a.t, a.Fs, filepath.Join("/etc/runlevels", runlevel, name), "")
+	}
+}
+
+// AddGroup adds a group to /etc/group with the given gid.
+func (a *FakeAlpine) AddGroup(name string, gid int) {
+	a.t.Helper()
+	a.appendLine("/etc/group", fmt.Sprintf("%s:x:%d:", name, gid))
+	a.refresh()
+}
+
+// AddUser adds a login user with a primary group of the same name and
+// membership in the given supplementary groups.
+func (a *FakeAlpine) AddUser(name string, uid int, groups ...string) {
+	a.t.Helper()
+	a.AddGroup(name, uid)
+	a.appendLine("/etc/passwd", fmt.Sprintf("%s:x:%d:%d:%s:/home/%s:/bin/ash", name, uid, uid, name, name))
+	a.groups[name] = append([]string{name}, groups...)
+	a.refresh()
+}
+
+// AddConfig writes a file under /etc and records it in apk audit output as
+// created by the user.
+func (a *FakeAlpine) AddConfig(path, content string) {
+	a.t.Helper()
+	CreateTestFile(a.t, a.Fs, path, content)
+	a.audit[path] = "A"
+	a.refresh()
+}
+
+// AddModifiedConfig writes a package-owned file that apk audit reports as
+// modified.
+func (a *FakeAlpine) AddModifiedConfig(path, content, owner string) {
+	a.t.Helper()
+	CreateTestFile(a.t, a.Fs, path, content)
+	a.audit[path] = "U"
+	a.owners[path] = owner
+	a.refresh()
+}
+
+// AssertRan checks that command was run by any user.
+func (a *FakeAlpine) AssertRan(t *testing.T, command string) {
+	t.Helper()
+	AssertCommandExecuted(t, a.Runner, command)
+}
+
+// AssertNotRan checks that command was never run.
+func (a *FakeAlpine) AssertNotRan(t *testing.T, command string) {
+	t.Helper()
+	AssertCommandNotExecuted(t, a.Runner, command)
+}
+
+// AssertFile checks that path exists with exactly the given content.
+func (a *FakeAlpine) AssertFile(t *testing.T, path, content string) {
+	t.Helper()
+	exists, err := afero.Exists(a.Fs, path)
+	require.NoError(t, err)
+	require.True(t, exists, "File %s should exist", path)
+	got, err := afero.ReadFile(a.Fs, path)
+	require.NoError(t, err)
+	require.Equal(t, content, string(got))
+}
+
+// AssertNoFile checks that path does not exist.
+func (a *FakeAlpine) AssertNoFile(t *testing.T, path string) {
+	t.Helper()
+	AssertFileNotExists(t, a.Fs, path)
+}
+
+func (a *FakeAlpine) appendLine(path, line string) {
+	content, err := afero.ReadFile(a.Fs, path)
+	require.NoError(a.t, err)
+	require.NoError(a.t, afero.WriteFile(a.Fs, path, append(content, []byte(line+"\n")...), 0644))
+}
+
+// refresh regenerates canned command output from the current fake state.
+func (a *FakeAlpine) refresh() {
+	paths := make([]string, 0, len(a.audit))
+	for p := range a.audit {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var audit strings.Builder
+	var modified []string
+	var owners strings.Builder
+	for _, p := range paths {
+		fmt.Fprintf(&audit, "%s %s\n", a.audit[p], strings.TrimPrefix(p, "/"))
+		if a.audit[p] == "U" {
+			modified = append(modified, "'"+p+"'")
+			fmt.Fprintf(&owners, "%s is owned by %s\n", p, a.owners[p])
+		}
+	}
+	a.Runner.SetResponse("", "apk audit", []byte(audit.String()))
+	if len(modified) > 0 {
+		a.Runner.SetResponse("", "apk info --who-owns "+strings.Join(modified, " "), []byte(owners.String()))
+	}
+
+	for user, groups := range a.groups {
+		a.Runner.SetResponse("", "groups "+user, []byte(strings.Join(groups, " ")))
+	}
+
+	groupFile, err := afero.ReadFile(a.Fs, "/etc/group")
+	if err == nil {
+		a.Runner.SetResponse("", "sh -c 'cat /etc/group'", groupFile)
+	}
+}
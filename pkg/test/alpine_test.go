@@ -0,0 +1,47 @@
+package test
+
+import (
+	"testing"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeAlpine_InferSystemState(t *testing.T) {
+	alpine := NewFakeAlpine(t)
+	alpine.Install(&system.AppFs)
+
+	alpine.AddPackage("htop")
+	alpine.AddService("sshd", "default")
+	alpine.AddService("nginx", "")
+	alpine.AddUser("alice", 1000, "wheel")
+	alpine.AddConfig("/etc/motd", "hello")
+	alpine.AddModifiedConfig("/etc/ssh/sshd_config", "PermitRootLogin no\n", "openssh-server")
+
+	state, _, err := system.InferSystemState(alpine.Runner, false)
+	require.NoError(t, err)
+
+	assert.Equal(t, []model.PackageState{{Name: "htop"}}, state.Packages)
+	assert.Contains(t, state.Services, model.ServiceState{Name: "sshd", Enabled: true, Runlevel: "default"})
+	assert.Contains(t, state.Services, model.ServiceState{Name: "nginx"})
+	require.Len(t, state.Users, 1)
+	assert.Equal(t, []string{"alice", "wheel"}, state.Users[0].Groups)
+	assert.Equal(t, "alice", state.Users[0].PrimaryGroup)
+
+	require.Len(t, state.Configs, 2)
+	assert.Equal(t, model.OriginUserCreated, state.Configs[0].Origin)
+	assert.Equal(t, "openssh-server", state.Configs[1].OriginPackage)
+}
+
+func TestFakeAlpine_InstallRestoresFs(t *testing.T) {
+	orig := system.AppFs
+	t.Run("installed", func(t *testing.T) {
+		alpine := NewFakeAlpine(t)
+		alpine.Install(&system.AppFs)
+		assert.Equal(t, alpine.Fs, system.AppFs)
+	})
+	assert.Equal(t, orig, system.AppFs)
+}
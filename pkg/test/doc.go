@@ -0,0 +1,26 @@
+// Package test is the test harness for summit contributors.
+//
+// It bundles everything needed to exercise actions, diffing and state
+// inference without touching a real machine:
+//
+//   - FakeAlpine: an in-memory Alpine system with a seeded /etc (apk world,
+//     init.d scripts, runlevels, passwd and group) and canned responses for the
+//     commands summit runs to inspect it (apk audit, groups, cat /etc/group).
+//   - MockCommandRunner and MockLogger: recording fakes for runner.CommandRunner
+//     and log.Logger.
+//   - Fixtures such as SampleSystemState and SampleConfigYAML.
+//   - Assertion helpers for files, commands, log messages and golden plans.
+//
+// A typical action test looks like:
+//
+//	alpine := test.NewFakeAlpine(t)
+//	alpine.Install(&system.AppFs)
+//	alpine.AddPackage("nginx")
+//
+//	action := &actions.PackageRemoveAction{PackageName: "nginx"}
+//	require.NoError(t, action.Apply(alpine.Runner, alpine.Logger))
+//	alpine.AssertRan(t, "apk del nginx")
+//
+// The package deliberately does not import summit's own packages (other than
+// log and model) so it can be used from their internal tests without cycles.
+package test
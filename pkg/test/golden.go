@@ -0,0 +1,30 @@
+package test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// UpdateGoldenEnv is the environment variable that, when set to "1", makes
+// AssertGolden rewrite golden files instead of comparing against them.
+const UpdateGoldenEnv = "SUMMIT_UPDATE_GOLDEN"
+
+// AssertGolden compares actual against the contents of the golden file at path.
+// Plans are usually rendered with diff.FormatGolden before being passed in.
+// Run the tests with SUMMIT_UPDATE_GOLDEN=1 to create or refresh golden files.
+func AssertGolden(t *testing.T, path, actual string) {
+	t.Helper()
+
+	if os.Getenv(UpdateGoldenEnv) == "1" {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(actual), 0644))
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	require.NoError(t, err, "golden file %s missing; run with %s=1 to create it", path, UpdateGoldenEnv)
+	require.Equal(t, string(expected), actual, "plan differs from golden file %s", path)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"log/slog"
+	"sync"
 
 	"summit/pkg/log"
 )
@@ -15,6 +16,7 @@ type MockCommandRunner struct {
 	Responses    map[string][]byte   // Response by command key (user:command)
 	Errors       map[string]error    // Error by command key
 	UserCommands map[string][]string // Track commands by user
+	Inputs       map[string][]byte   // Stdin of the commands run with RunInput, by command key
 }
 
 // NewMockCommandRunner creates a new MockCommandRunner with initialized maps.
@@ -45,6 +47,16 @@ func (r *MockCommandRunner) Run(user, command string) ([]byte, error) {
 	return nil, nil
 }
 
+// RunInput simulates running a command with input on its stdin, recording
+// the input in Inputs. Responses and errors are looked up as for Run.
+func (r *MockCommandRunner) RunInput(user, command string, input []byte) ([]byte, error) {
+	if r.Inputs == nil {
+		r.Inputs = make(map[string][]byte)
+	}
+	r.Inputs[user+":"+command] = input
+	return r.Run(user, command)
+}
+
 // SetResponse configures a response for a specific user:command.
 func (r *MockCommandRunner) SetResponse(user, command string, response []byte) {
 	r.Responses[user+":"+command] = response
@@ -64,10 +76,12 @@ func (r *MockCommandRunner) Reset() {
 }
 
 // MockLogger is a shared mock implementation of Logger for testing.
-// It captures logged messages for verification.
+// It captures logged messages for verification and is safe for concurrent use.
 type MockLogger struct {
 	Messages []string
 	Level    slog.Level
+
+	mu sync.Mutex
 }
 
 // NewMockLogger creates a new MockLogger with the specified level.
@@ -120,16 +134,22 @@ func (l *MockLogger) captureMessage(level, msg string, args ...any) {
 			buf.WriteString(fmt.Sprintf("%v", args[i+1]))
 		}
 	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.Messages = append(l.Messages, buf.String())
 }
 
 // Reset clears all captured messages.
 func (l *MockLogger) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	l.Messages = []string{}
 }
 
 // HasMessage checks if any captured message contains the given substring.
 func (l *MockLogger) HasMessage(substring string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	for _, msg := range l.Messages {
 		if bytes.Contains([]byte(msg), []byte(substring)) {
 			return true
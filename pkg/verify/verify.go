@@ -0,0 +1,90 @@
+// Package verify runs the post-apply assertions declared in a config.
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"summit/pkg/model"
+	"summit/pkg/system"
+)
+
+// HTTPClient performs HTTP assertions. It is a variable so tests can replace it.
+var HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Result is the outcome of a single assertion. Err is nil when it passed.
+type Result struct {
+	Assertion model.AssertionState
+	Err       error
+}
+
+// Run executes the assertions in order and returns one result per assertion.
+// Every assertion is run even if an earlier one fails.
+func Run(assertions []model.AssertionState, runner system.CommandRunner) []Result {
+	results := make([]Result, 0, len(assertions))
+	for _, a := range assertions {
+		results = append(results, Result{Assertion: a, Err: check(a, runner)})
+	}
+	return results
+}
+
+// Failures returns the results that did not pass.
+func Failures(results []Result) []Result {
+	var failed []Result
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r)
+		}
+	}
+	return failed
+}
+
+func check(a model.AssertionState, runner system.CommandRunner) error {
+	switch {
+	case a.Command != "":
+		return checkCommand(a, runner)
+	case a.HTTP != "":
+		return checkHTTP(a)
+	case a.FileExists != "":
+		if _, err := system.AppFs.Stat(a.FileExists); err != nil {
+			return fmt.Errorf("file %s does not exist", a.FileExists)
+		}
+		return nil
+	default:
+		return fmt.Errorf("assertion has no check")
+	}
+}
+
+func checkCommand(a model.AssertionState, runner system.CommandRunner) error {
+	_, err := runner.Run("", a.Command)
+	code := 0
+	if err != nil {
+		var exitErr interface{ ExitCode() int }
+		if !errors.As(err, &exitErr) {
+			return fmt.Errorf("failed to run %q: %w", a.Command, err)
+		}
+		code = exitErr.ExitCode()
+	}
+	if code != a.ExitCode {
+		return fmt.Errorf("command %q exited with %d, expected %d", a.Command, code, a.ExitCode)
+	}
+	return nil
+}
+
+func checkHTTP(a model.AssertionState) error {
+	expected := a.Status
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+	resp, err := HTTPClient.Get(a.HTTP)
+	if err != nil {
+		return fmt.Errorf("request to %s failed: %w", a.HTTP, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != expected {
+		return fmt.Errorf("%s returned status %d, expected %d", a.HTTP, resp.StatusCode, expected)
+	}
+	return nil
+}
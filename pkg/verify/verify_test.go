@@ -0,0 +1,71 @@
+package verify
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"summit/pkg/model"
+	"summit/pkg/system"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+type fakeRunner map[string]error
+
+func (r fakeRunner) Run(user, command string) ([]byte, error) {
+	return nil, r[command]
+}
+
+func TestRun(t *testing.T) {
+	origFs := system.AppFs
+	t.Cleanup(func() { system.AppFs = origFs })
+	system.AppFs = afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(system.AppFs, "/run/nginx.pid", []byte("1"), 0644))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	runner := fakeRunner{
+		"nginx -t":       nil,
+		"false":          exitError(1),
+		"rc-service foo": fmt.Errorf("sh: not found"),
+	}
+
+	assertions := []model.AssertionState{
+		{Command: "nginx -t"},
+		{Command: "false", ExitCode: 1},
+		{Command: "false"},
+		{Command: "rc-service foo"},
+		{HTTP: server.URL},
+		{HTTP: server.URL + "/missing"},
+		{HTTP: server.URL + "/missing", Status: 404},
+		{FileExists: "/run/nginx.pid"},
+		{Name: "sshd pid", FileExists: "/run/sshd.pid"},
+	}
+
+	results := Run(assertions, runner)
+	require.Len(t, results, len(assertions))
+
+	passed := []bool{}
+	for _, r := range results {
+		passed = append(passed, r.Err == nil)
+	}
+	assert.Equal(t, []bool{true, true, false, false, true, false, true, true, false}, passed)
+	assert.EqualError(t, results[2].Err, `command "false" exited with 1, expected 0`)
+
+	failed := Failures(results)
+	require.Len(t, failed, 4)
+	assert.Equal(t, "sshd pid", failed[3].Assertion.Label())
+}